@@ -0,0 +1,173 @@
+// Package access implements the tokens with which trackers control access to
+// private files: session tokens binding a peer to its owner identity, share
+// tokens handed out by the owner of a file, and grants that seeders check
+// before serving chunks of a private file
+package access
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Visibility of a file on the tracker
+const (
+	Public   = "public"   // Listed, anyone can get its peers
+	Unlisted = "unlisted" // Not listed, anyone knowing its hash can get its peers
+	Private  = "private"  // Not listed, its owner and the peers it authorized only
+)
+
+// Kinds of token
+const (
+	KindSession = "session"
+	KindShare   = "share"
+	KindGrant   = "grant"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrExpiredToken = errors.New("access token expired")
+)
+
+// Token is what a tracker vouches for. Which fields are set depends on the
+// kind: Peer, Owner and Key for a session, File and Epoch for a share, File,
+// Peer and Key for a grant.
+type Token struct {
+	Kind    string    `json:"k"`
+	File    string    `json:"f,omitempty"`
+	Peer    string    `json:"p,omitempty"`
+	Owner   string    `json:"o,omitempty"`
+	Key     string    `json:"y,omitempty"` // Peer key the peer registered, which it proves its ID to seeders with
+	Epoch   int       `json:"n,omitempty"` // Share tokens of an older epoch of the file are revoked
+	Expires time.Time `json:"e,omitzero"`  // Zero for no expiry
+}
+
+// Signer issues tokens with a tracker key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a Signer whose key is derived from secret, so that the
+// tokens a tracker issued stay valid across its restarts
+func NewSigner(secret string) *Signer {
+	seed := sha256.Sum256([]byte("p2p-access:" + secret))
+	return &Signer{key: ed25519.NewKeyFromSeed(seed[:])}
+}
+
+// PublicKey returns the key that verifies the tokens, base64-encoded
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign returns a token as a string: its JSON and its signature, both
+// base64url-encoded and joined by a dot
+func (s *Signer) Sign(t Token) string {
+	payload, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.key, payload))
+}
+
+// Verify returns the token of a string signed by the Signer, if it has the
+// kind and has not expired
+func (s *Signer) Verify(token, kind string) (*Token, error) {
+	return Verify(token, kind, []ed25519.PublicKey{s.key.Public().(ed25519.PublicKey)})
+}
+
+// Verify returns the token of a string signed by one of keys, if it has the
+// kind and has not expired
+func Verify(token, kind string, keys []ed25519.PublicKey) (*Token, error) {
+	encoded, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signed := false
+	for _, key := range keys {
+		if len(key) == ed25519.PublicKeySize && ed25519.Verify(key, payload, sig) {
+			signed = true
+			break
+		}
+	}
+	var t Token
+	if !signed || json.Unmarshal(payload, &t) != nil || t.Kind != kind {
+		return nil, ErrInvalidToken
+	}
+	if !t.Expires.IsZero() && time.Now().After(t.Expires) {
+		return nil, ErrExpiredToken
+	}
+	return &t, nil
+}
+
+// ParsePublicKey decodes a key returned by Signer.PublicKey
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid access key")
+	}
+	return key, nil
+}
+
+// challenge returns what a peer signs to prove its ID to a seeder: the
+// seeder's nonce and the ID, so that a signature is valid for neither another
+// connection nor another peer
+func challenge(nonce, peerID string) []byte {
+	return []byte("p2p-handshake:" + nonce + ":" + peerID)
+}
+
+// NewNonce returns a random nonce for a peer to sign in the handshake
+func NewNonce() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// SignChallenge returns the proof that a peer holds the private half of its
+// peer key, for the nonce of a seeder
+func SignChallenge(key ed25519.PrivateKey, nonce, peerID string) string {
+	return base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, challenge(nonce, peerID)))
+}
+
+// VerifyChallenge reports whether proof is the signature of a nonce by the
+// peer key, as returned by ParsePublicKey
+func VerifyChallenge(key ed25519.PublicKey, nonce, peerID, proof string) bool {
+	sig, err := base64.RawURLEncoding.DecodeString(proof)
+	return err == nil && len(key) == ed25519.PublicKeySize && nonce != "" &&
+		ed25519.Verify(key, challenge(nonce, peerID), sig)
+}
+
+// OwnerToken returns the token a peer proves its owner identity to a tracker
+// with: a MAC of the tracker URL with the peer's secret, so that a tracker
+// cannot use it to pose as the owner on another tracker
+func OwnerToken(secret []byte, trackerURL string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(trackerURL))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// OwnerID returns the identity of the owner of an owner token: who owns the
+// files a peer announces, and who groups are made of
+func OwnerID(ownerToken string) string {
+	sum := sha256.Sum256([]byte(ownerToken))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ValidVisibility reports whether v is a visibility, the empty string being
+// Public
+func ValidVisibility(v string) bool {
+	return v == "" || v == Public || v == Unlisted || v == Private
+}
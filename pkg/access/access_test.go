@@ -0,0 +1,100 @@
+package access
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := NewSigner("secret")
+	token := s.Sign(Token{Kind: KindGrant, File: "abc", Peer: "p1", Expires: time.Now().Add(time.Hour)})
+
+	got, err := s.Verify(token, KindGrant)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got.File != "abc" || got.Peer != "p1" {
+		t.Errorf("Verify() = %+v", got)
+	}
+
+	// Peers verify with the public key of the tracker
+	key, err := ParsePublicKey(s.PublicKey())
+	if err != nil {
+		t.Fatalf("ParsePublicKey() error = %v", err)
+	}
+	if _, err := Verify(token, KindGrant, []ed25519.PublicKey{key}); err != nil {
+		t.Errorf("Verify() with the public key error = %v", err)
+	}
+
+	// The same secret gives the same key
+	if _, err := NewSigner("secret").Verify(token, KindGrant); err != nil {
+		t.Errorf("Verify() after a restart error = %v", err)
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	s := NewSigner("secret")
+	grant := s.Sign(Token{Kind: KindGrant, File: "abc", Peer: "p1"})
+	expired := s.Sign(Token{Kind: KindGrant, File: "abc", Expires: time.Now().Add(-time.Minute)})
+
+	tests := []struct {
+		name  string
+		token string
+		kind  string
+		want  error
+	}{
+		{"other kind", grant, KindShare, ErrInvalidToken},
+		{"other tracker", NewSigner("other").Sign(Token{Kind: KindGrant}), KindGrant, ErrInvalidToken},
+		{"tampered", grant[:len(grant)-2] + "xx", KindGrant, ErrInvalidToken},
+		{"not a token", "abc", KindGrant, ErrInvalidToken},
+		{"expired", expired, KindGrant, ErrExpiredToken},
+	}
+	for _, tt := range tests {
+		if _, err := s.Verify(tt.token, tt.kind); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestOwnerID(t *testing.T) {
+	secret := []byte("peer secret")
+	a := OwnerID(OwnerToken(secret, "http://tracker-a"))
+	if a != OwnerID(OwnerToken(secret, "http://tracker-a")) {
+		t.Error("OwnerID() is not stable")
+	}
+	if a == OwnerID(OwnerToken(secret, "http://tracker-b")) {
+		t.Error("Expected a different owner ID on another tracker")
+	}
+	if len(a) != 32 {
+		t.Errorf("OwnerID() = %q, want 32 hex digits", a)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	nonce := NewNonce()
+	proof := SignChallenge(key, nonce, "p1")
+
+	if !VerifyChallenge(pub, nonce, "p1", proof) {
+		t.Fatal("VerifyChallenge() = false for the peer's own proof")
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	tests := []struct {
+		name   string
+		key    ed25519.PublicKey
+		nonce  string
+		peerID string
+	}{
+		{"other key", other, nonce, "p1"},
+		{"other nonce", pub, NewNonce(), "p1"},
+		{"other peer", pub, nonce, "p2"},
+		{"no key", nil, nonce, "p1"},
+	}
+	for _, tt := range tests {
+		if VerifyChallenge(tt.key, tt.nonce, tt.peerID, proof) {
+			t.Errorf("%s: VerifyChallenge() = true", tt.name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package bencode implements the encoding used by BitTorrent metainfo files.
+//
+// Decoded values are string (byte strings, which may hold binary data),
+// int64, []any and map[string]any. Encode also accepts []byte, int, []string,
+// map[string]any and RawMessage, and always writes dictionary keys sorted.
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// maxDepth bounds nesting so hostile input cannot exhaust the stack
+const maxDepth = 64
+
+var ErrSyntax = errors.New("bencode: invalid syntax")
+
+// RawMessage is an already encoded value, written as is
+type RawMessage []byte
+
+// Decode parses one complete bencoded value
+func Decode(data []byte) (any, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%w: trailing data at offset %d", ErrSyntax, d.pos)
+	}
+	return v, nil
+}
+
+// RawValue returns the encoded bytes of key in the top-level dictionary of
+// data, exactly as they appear. BitTorrent info hashes are computed over the
+// raw info dictionary.
+func RawValue(data []byte, key string) (RawMessage, error) {
+	d := &decoder{data: data}
+	if d.peek() != 'd' {
+		return nil, fmt.Errorf("%w: not a dictionary", ErrSyntax)
+	}
+	d.pos++
+	for d.peek() != 'e' {
+		k, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		start := d.pos
+		if _, err := d.value(1); err != nil {
+			return nil, err
+		}
+		if k == key {
+			return RawMessage(data[start:d.pos]), nil
+		}
+	}
+	return nil, fmt.Errorf("bencode: key %q not found", key)
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+// peek returns the next byte, or 0 at the end of the data
+func (d *decoder) peek() byte {
+	if d.pos >= len(d.data) {
+		return 0
+	}
+	return d.data[d.pos]
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: nested too deeply", ErrSyntax)
+	}
+	switch c := d.peek(); {
+	case c == 'i':
+		return d.int()
+	case c == 'l':
+		d.pos++
+		list := []any{}
+		for d.peek() != 'e' {
+			if d.peek() == 0 {
+				return nil, fmt.Errorf("%w: unterminated list", ErrSyntax)
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		d.pos++
+		return list, nil
+	case c == 'd':
+		d.pos++
+		dict := map[string]any{}
+		for d.peek() != 'e' {
+			if d.peek() == 0 {
+				return nil, fmt.Errorf("%w: unterminated dictionary", ErrSyntax)
+			}
+			k, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			dict[k] = v
+		}
+		d.pos++
+		return dict, nil
+	case c >= '0' && c <= '9':
+		return d.string()
+	default:
+		return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrSyntax, c, d.pos)
+	}
+}
+
+func (d *decoder) int() (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], 'e')
+	if end < 0 {
+		return 0, fmt.Errorf("%w: unterminated integer", ErrSyntax)
+	}
+	text := string(d.data[d.pos+1 : d.pos+end])
+	n, err := strconv.ParseInt(text, 10, 64)
+	// Leading zeros and negative zero are not allowed
+	if err != nil || text == "-0" || (len(text) > 1 && text[0] == '0') || (len(text) > 2 && text[:2] == "-0") {
+		return 0, fmt.Errorf("%w: bad integer %q", ErrSyntax, text)
+	}
+	d.pos += end + 1
+	return n, nil
+}
+
+func (d *decoder) string() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", fmt.Errorf("%w: bad string at offset %d", ErrSyntax, d.pos)
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	start := d.pos + colon + 1
+	if err != nil || n < 0 || n > len(d.data)-start {
+		return "", fmt.Errorf("%w: bad string length at offset %d", ErrSyntax, d.pos)
+	}
+	d.pos = start + n
+	return string(d.data[start:d.pos]), nil
+}
+
+// Encode encodes v
+func Encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case RawMessage:
+		buf.Write(v)
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.WriteString(v)
+	case []byte:
+		return encode(buf, string(v))
+	case int:
+		return encode(buf, int64(v))
+	case int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v, 10))
+		buf.WriteByte('e')
+	case []string:
+		buf.WriteByte('l')
+		for _, s := range v {
+			encode(buf, s)
+		}
+		buf.WriteByte('e')
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			encode(buf, k)
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: cannot encode %T", v)
+	}
+	return nil
+}
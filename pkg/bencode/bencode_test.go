@@ -0,0 +1,60 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	value := map[string]any{
+		"announce": "http://tracker.example.com/announce",
+		"info": map[string]any{
+			"length":       int64(12345),
+			"name":         "file.bin",
+			"piece length": int64(16384),
+			"pieces":       "\x00\x01\xff binary",
+		},
+		"url-list": []any{"https://a.example.com/file.bin", int64(-3)},
+	}
+	data, err := Encode(value)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, value) {
+		t.Errorf("Decode(Encode(v)) = %#v, want %#v", decoded, value)
+	}
+}
+
+func TestEncode_SortsKeys(t *testing.T) {
+	data, _ := Encode(map[string]any{"b": 1, "a": []string{"x"}, "c": RawMessage("i7e")})
+	if got, want := string(data), "d1:al1:xe1:bi1e1:ci7ee"; got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	for _, input := range []string{"", "i12", "i-0e", "i012e", "5:abc", "l1:a", "d1:ai1e", "x", "i1ei2e", "d1:a"} {
+		if _, err := Decode([]byte(input)); err == nil {
+			t.Errorf("Decode(%q) should fail", input)
+		}
+	}
+}
+
+func TestRawValue(t *testing.T) {
+	// Unsorted keys: the raw bytes must be returned untouched
+	data := []byte("d4:infod1:zi1e1:ai2ee3:fooi3ee")
+	raw, err := RawValue(data, "info")
+	if err != nil {
+		t.Fatalf("RawValue failed: %v", err)
+	}
+	if string(raw) != "d1:zi1e1:ai2ee" {
+		t.Errorf("RawValue = %s", raw)
+	}
+	if _, err := RawValue(data, "missing"); err == nil {
+		t.Error("RawValue of a missing key should fail")
+	}
+}
@@ -0,0 +1,85 @@
+package chunker
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// Content-defined chunking (CDC) places chunk boundaries where a rolling "gear"
+// hash of the last bytes matches a mask, so boundaries move with the content:
+// inserting or deleting bytes only changes the chunks around the edit and every
+// other chunk keeps its hash.
+
+// gearTable maps each byte to a random 64-bit value. It is generated from a
+// fixed seed so all peers cut identical boundaries.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// cdcMask returns a mask of log2(avgSize) high bits; a boundary is found on
+// average once every avgSize bytes
+func cdcMask(avgSize int64) uint64 {
+	n := bits.Len64(uint64(avgSize)) - 1
+	if n < 1 {
+		n = 1
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// cdcSplitter reads consecutive content-defined chunks from a stream
+type cdcSplitter struct {
+	r       *bufio.Reader
+	minSize int64
+	maxSize int64
+	mask    uint64
+	buf     []byte
+}
+
+func newCDCSplitter(r io.Reader, minSize, avgSize, maxSize int64) *cdcSplitter {
+	return &cdcSplitter{
+		r:       bufio.NewReaderSize(r, 1<<20),
+		minSize: minSize,
+		maxSize: maxSize,
+		mask:    cdcMask(avgSize),
+		buf:     make([]byte, 0, maxSize),
+	}
+}
+
+// next returns the next chunk, or io.EOF after the last one.
+// The returned slice is only valid until the next call.
+func (s *cdcSplitter) next() ([]byte, error) {
+	s.buf = s.buf[:0]
+	var h uint64
+
+	for {
+		b, err := s.r.ReadByte()
+		if err == io.EOF {
+			if len(s.buf) == 0 {
+				return nil, io.EOF
+			}
+			return s.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.buf = append(s.buf, b)
+		size := int64(len(s.buf))
+		h = (h << 1) + gearTable[b]
+
+		if size >= s.maxSize || (size >= s.minSize && h&s.mask == 0) {
+			return s.buf, nil
+		}
+	}
+}
@@ -2,10 +2,12 @@ package chunker
 
 import (
 	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
 
-	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/filetype"
 	"github.com/p2p-filesharing/distributed-system/pkg/merkle"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 )
@@ -19,9 +21,17 @@ const (
 
 // Chunker handles file splitting and assembly
 type Chunker struct {
-	ChunkSize int64
+	ChunkSize int64  // Fixed chunk size, or the average size in content-defined mode
+	Mode      string // protocol.ChunkingFixed or protocol.ChunkingCDC
+	MinSize   int64  // Smallest content-defined chunk (except the last)
+	MaxSize   int64  // Largest content-defined chunk
+	Workers   int    // Chunk hashing goroutines (0 = GOMAXPROCS)
 }
 
+// ProgressFunc is called as a file is hashed with the bytes hashed so far and
+// the file size. Calls are sequential but come from a background goroutine.
+type ProgressFunc func(hashed, total int64)
+
 // New creates a new Chunker with specified chunk size
 func New(chunkSize int64) *Chunker {
 	if chunkSize <= 0 {
@@ -30,11 +40,50 @@ func New(chunkSize int64) *Chunker {
 	if chunkSize > MaxChunkSize {
 		chunkSize = MaxChunkSize
 	}
-	return &Chunker{ChunkSize: chunkSize}
+	return &Chunker{ChunkSize: chunkSize, Mode: protocol.ChunkingFixed}
+}
+
+// NewContentDefined creates a Chunker that cuts chunks at content-defined
+// boundaries, averaging avgSize bytes, between avgSize/4 and 4*avgSize
+// (at most MaxChunkSize)
+func NewContentDefined(avgSize int64) *Chunker {
+	c := New(avgSize)
+	c.Mode = protocol.ChunkingCDC
+	c.MinSize = min(max(c.ChunkSize/4, 64), c.ChunkSize)
+	c.MaxSize = min(c.ChunkSize*4, MaxChunkSize)
+	return c
+}
+
+// NewWithMode creates a Chunker for a chunking mode name ("fixed" or "cdc")
+func NewWithMode(mode string, chunkSize int64) (*Chunker, error) {
+	switch mode {
+	case "", protocol.ChunkingFixed:
+		return New(chunkSize), nil
+	case protocol.ChunkingCDC:
+		return NewContentDefined(chunkSize), nil
+	default:
+		return nil, fmt.Errorf("unknown chunking mode %q (want fixed or cdc)", mode)
+	}
+}
+
+// Params identifies the chunking parameters; files chunked with different
+// parameters get different chunk boundaries
+func (c *Chunker) Params() string {
+	if c.Mode == protocol.ChunkingCDC {
+		return fmt.Sprintf("cdc:%d:%d:%d", c.MinSize, c.ChunkSize, c.MaxSize)
+	}
+	return fmt.Sprintf("fixed:%d", c.ChunkSize)
 }
 
 // ChunkFile splits a file into chunks and returns metadata
 func (c *Chunker) ChunkFile(filepath string) (*protocol.FileMetadata, error) {
+	return c.ChunkFileProgress(filepath, nil)
+}
+
+// ChunkFileProgress is ChunkFile with a progress callback. The file is read
+// once: each chunk feeds the full-file hash and is hashed on a pool of
+// Workers goroutines while the next chunk is read.
+func (c *Chunker) ChunkFileProgress(filepath string, progress ProgressFunc) (*protocol.FileMetadata, error) {
 	f, err := os.Open(filepath)
 	if err != nil {
 		return nil, err
@@ -47,48 +96,40 @@ func (c *Chunker) ChunkFile(filepath string) (*protocol.FileMetadata, error) {
 		return nil, err
 	}
 
-	// Calculate file hash
-	fileHash, err := hash.CalculateFile(filepath)
-	if err != nil {
-		return nil, err
+	nextChunk := c.fixedSplitter(f)
+	maxChunk := c.ChunkSize
+	if c.Mode == protocol.ChunkingCDC {
+		nextChunk = newCDCSplitter(f, c.MinSize, c.ChunkSize, c.MaxSize).next
+		maxChunk = c.MaxSize
 	}
 
-	// Read and hash each chunk
-	var chunks []protocol.ChunkInfo
-	var chunkHashes [][]byte
-	buf := make([]byte, c.ChunkSize)
-	index := 0
-
-	// Reset file position
-	f.Seek(0, 0)
-
+	p := newHashPipeline(c.workers(), maxChunk, stat.Size(), progress)
+	var offset int64
+	var header []byte // First bytes of the file, for the tracker to classify it
 	for {
-		n, err := f.Read(buf)
+		chunkData, err := nextChunk()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
+			p.close()
 			return nil, err
 		}
-
-		chunkData := buf[:n]
-		chunkHash := hash.Calculate(chunkData)
-
-		// Store hash bytes for merkle tree
-		hashBytes, _ := hex.DecodeString(chunkHash)
-		chunkHashes = append(chunkHashes, hashBytes)
-
-		chunks = append(chunks, protocol.ChunkInfo{
-			Index: index,
-			Hash:  chunkHash,
-			Size:  int64(n),
-		})
-		index++
+		if len(header) < filetype.HeaderSize {
+			header = append(header, chunkData[:min(len(chunkData), filetype.HeaderSize-len(header))]...)
+		}
+		p.add(offset, chunkData)
+		offset += int64(len(chunkData))
 	}
+	fileHash, chunks := p.close()
 
 	// Build Merkle tree and get root
 	var merkleRoot string
-	if len(chunkHashes) > 0 {
+	if len(chunks) > 0 {
+		chunkHashes := make([][]byte, len(chunks))
+		for i, chunk := range chunks {
+			chunkHashes[i], _ = hex.DecodeString(chunk.Hash)
+		}
 		tree, err := merkle.NewTreeFromHashes(chunkHashes)
 		if err == nil {
 			merkleRoot = tree.RootHex()
@@ -102,42 +143,72 @@ func (c *Chunker) ChunkFile(filepath string) (*protocol.FileMetadata, error) {
 		ChunkSize:  c.ChunkSize,
 		Chunks:     chunks,
 		MerkleRoot: merkleRoot,
+		Chunking:   c.Mode,
+		Header:     header,
 	}, nil
 }
 
-// ReadChunk reads a specific chunk from a file
-func (c *Chunker) ReadChunk(filepath string, index int) ([]byte, error) {
-	f, err := os.Open(filepath)
-	if err != nil {
-		return nil, err
+// workers returns the number of chunk hashing goroutines to use
+func (c *Chunker) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
 	}
-	defer f.Close()
+	return runtime.GOMAXPROCS(0)
+}
 
-	offset := int64(index) * c.ChunkSize
-	_, err = f.Seek(offset, 0)
+// fixedSplitter returns a function reading consecutive ChunkSize chunks from r
+func (c *Chunker) fixedSplitter(r io.Reader) func() ([]byte, error) {
+	buf := make([]byte, c.ChunkSize)
+	return func() ([]byte, error) {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// ReadChunkOf reads chunk index of a file described by metadata, using the
+// recorded chunk offsets so both fixed and content-defined chunks are supported
+func ReadChunkOf(filepath string, metadata *protocol.FileMetadata, index int) ([]byte, error) {
+	if index < 0 || index >= len(metadata.Chunks) {
+		return nil, fmt.Errorf("chunk index %d out of range", index)
+	}
+
+	f, err := os.Open(filepath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	buf := make([]byte, c.ChunkSize)
-	n, err := f.Read(buf)
+	buf := make([]byte, metadata.Chunks[index].Size)
+	n, err := f.ReadAt(buf, metadata.ChunkOffset(index))
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
-
 	return buf[:n], nil
 }
 
-// WriteChunk writes a chunk to a file at the correct position
-func (c *Chunker) WriteChunk(filepath string, index int, data []byte) error {
+// WriteChunkOf writes chunk index of a file described by metadata at its
+// recorded offset, the counterpart of ReadChunkOf
+func WriteChunkOf(filepath string, metadata *protocol.FileMetadata, index int, data []byte) error {
+	if index < 0 || index >= len(metadata.Chunks) {
+		return fmt.Errorf("chunk index %d out of range", index)
+	}
+	if int64(len(data)) != metadata.Chunks[index].Size {
+		return fmt.Errorf("chunk %d has %d bytes, expected %d", index, len(data), metadata.Chunks[index].Size)
+	}
+
 	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	offset := int64(index) * c.ChunkSize
-	_, err = f.WriteAt(data, offset)
+	_, err = f.WriteAt(data, metadata.ChunkOffset(index))
 	return err
 }
 
@@ -1,9 +1,14 @@
 package chunker
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 )
 
 func TestChunkFile(t *testing.T) {
@@ -40,6 +45,11 @@ func TestChunkFile(t *testing.T) {
 		t.Errorf("Expected %d chunks, got %d", expectedChunks, len(metadata.Chunks))
 	}
 
+	// The header spans chunks and stops at filetype.HeaderSize
+	if !bytes.Equal(metadata.Header, content[:512]) {
+		t.Errorf("Expected the first 512 bytes as header, got %d bytes", len(metadata.Header))
+	}
+
 	// Verify each chunk has a hash
 	for i, chunk := range metadata.Chunks {
 		if chunk.Hash == "" {
@@ -62,28 +72,35 @@ func TestReadWriteChunk(t *testing.T) {
 	}
 
 	c := New(16) // Small chunks for testing
+	metadata, err := c.ChunkFile(testFile)
+	if err != nil {
+		t.Fatalf("ChunkFile failed: %v", err)
+	}
 
-	// Read first chunk
-	chunk0, err := c.ReadChunk(testFile, 0)
+	// Read second chunk
+	chunk1, err := ReadChunkOf(testFile, metadata, 1)
 	if err != nil {
-		t.Fatalf("ReadChunk failed: %v", err)
+		t.Fatalf("ReadChunkOf failed: %v", err)
 	}
 
-	if string(chunk0) != "Hello World! Thi" {
-		t.Errorf("Chunk 0 content mismatch: %s", string(chunk0))
+	if string(chunk1) != "s is a test file" {
+		t.Errorf("Chunk 1 content mismatch: %s", string(chunk1))
 	}
 
-	// Test WriteChunk
+	// Test WriteChunkOf, which writes at the recorded offset
 	outFile := filepath.Join(tmpDir, "output.txt")
-	if err := c.WriteChunk(outFile, 0, chunk0); err != nil {
-		t.Fatalf("WriteChunk failed: %v", err)
+	if err := WriteChunkOf(outFile, metadata, 1, chunk1); err != nil {
+		t.Fatalf("WriteChunkOf failed: %v", err)
 	}
 
 	// Read back and verify
 	written, _ := os.ReadFile(outFile)
-	if string(written[:len(chunk0)]) != string(chunk0) {
+	if string(written[metadata.ChunkOffset(1):]) != string(chunk1) {
 		t.Error("Written chunk doesn't match")
 	}
+	if err := WriteChunkOf(outFile, metadata, 1, chunk1[:4]); err == nil {
+		t.Error("WriteChunkOf should refuse data of the wrong size")
+	}
 }
 
 func TestGetChunkCount(t *testing.T) {
@@ -109,3 +126,183 @@ func TestGetChunkCount(t *testing.T) {
 		}
 	}
 }
+
+// pseudoRandom returns deterministic test content
+func pseudoRandom(n int, seed uint32) []byte {
+	data := make([]byte, n)
+	x := seed
+	for i := range data {
+		x = x*1664525 + 1013904223
+		data[i] = byte(x >> 24)
+	}
+	return data
+}
+
+func TestChunkFileContentDefined(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := pseudoRandom(512*1024, 1)
+
+	original := filepath.Join(tmpDir, "v1.bin")
+	os.WriteFile(original, content, 0644)
+
+	// Same content with 10 bytes inserted at the start
+	edited := filepath.Join(tmpDir, "v2.bin")
+	os.WriteFile(edited, append([]byte("0123456789"), content...), 0644)
+
+	c := NewContentDefined(8 * 1024)
+	v1, err := c.ChunkFile(original)
+	if err != nil {
+		t.Fatalf("ChunkFile failed: %v", err)
+	}
+	v2, err := c.ChunkFile(edited)
+	if err != nil {
+		t.Fatalf("ChunkFile failed: %v", err)
+	}
+
+	if v1.Chunking != protocol.ChunkingCDC {
+		t.Errorf("Expected chunking %q, got %q", protocol.ChunkingCDC, v1.Chunking)
+	}
+
+	// Chunks are contiguous and within the size bounds
+	var offset int64
+	for i, chunk := range v1.Chunks {
+		if chunk.Offset != offset {
+			t.Fatalf("Chunk %d offset = %d, want %d", i, chunk.Offset, offset)
+		}
+		if chunk.Size > c.MaxSize || (chunk.Size < c.MinSize && i != len(v1.Chunks)-1) {
+			t.Errorf("Chunk %d size %d outside [%d, %d]", i, chunk.Size, c.MinSize, c.MaxSize)
+		}
+		offset += chunk.Size
+	}
+	if offset != v1.Size {
+		t.Errorf("Chunks cover %d bytes, file has %d", offset, v1.Size)
+	}
+
+	// Boundaries follow the content: almost every chunk survives the insertion
+	known := make(map[string]bool)
+	for _, chunk := range v1.Chunks {
+		known[chunk.Hash] = true
+	}
+	reused := 0
+	for _, chunk := range v2.Chunks {
+		if known[chunk.Hash] {
+			reused++
+		}
+	}
+	if reused < len(v1.Chunks)-2 {
+		t.Errorf("Only %d of %d chunks reused after a 10-byte insertion", reused, len(v1.Chunks))
+	}
+
+	// Fixed-size chunking shares nothing after the same edit
+	fixed := New(8 * 1024)
+	f1, _ := fixed.ChunkFile(original)
+	f2, _ := fixed.ChunkFile(edited)
+	if f1.Chunks[1].Hash == f2.Chunks[1].Hash {
+		t.Error("Fixed-size chunks unexpectedly survived the insertion")
+	}
+}
+
+func TestReadChunkOf(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := pseudoRandom(100*1024, 2)
+	path := filepath.Join(tmpDir, "file.bin")
+	os.WriteFile(path, content, 0644)
+
+	for _, c := range []*Chunker{New(4096), NewContentDefined(4096)} {
+		metadata, err := c.ChunkFile(path)
+		if err != nil {
+			t.Fatalf("ChunkFile failed: %v", err)
+		}
+
+		var assembled []byte
+		for i := range metadata.Chunks {
+			data, err := ReadChunkOf(path, metadata, i)
+			if err != nil {
+				t.Fatalf("%s: ReadChunkOf(%d) failed: %v", c.Params(), i, err)
+			}
+			if hash.Calculate(data) != metadata.Chunks[i].Hash {
+				t.Fatalf("%s: chunk %d hash mismatch", c.Params(), i)
+			}
+			assembled = append(assembled, data...)
+		}
+		if !bytes.Equal(assembled, content) {
+			t.Errorf("%s: reassembled chunks differ from the file", c.Params())
+		}
+	}
+
+	// Metadata from older peers has no offsets
+	legacy := &protocol.FileMetadata{
+		ChunkSize: 4096,
+		Chunks:    []protocol.ChunkInfo{{Index: 0, Size: 4096}, {Index: 1, Size: 4096}},
+	}
+	data, err := ReadChunkOf(path, legacy, 1)
+	if err != nil || !bytes.Equal(data, content[4096:8192]) {
+		t.Errorf("ReadChunkOf without offsets read the wrong bytes (err %v)", err)
+	}
+
+	if _, err := NewWithMode("rabin", 4096); err == nil {
+		t.Error("NewWithMode should reject unknown modes")
+	}
+}
+
+func TestChunkFileParallelMatchesSequential(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := pseudoRandom(300*1024+123, 3)
+	path := filepath.Join(tmpDir, "file.bin")
+	os.WriteFile(path, content, 0644)
+
+	fileHash, _ := hash.CalculateFile(path)
+
+	for _, c := range []*Chunker{New(4096), NewContentDefined(4096)} {
+		// Reference: one chunk hashed at a time on a single worker
+		c.Workers = 1
+		want, err := c.ChunkFile(path)
+		if err != nil {
+			t.Fatalf("ChunkFile failed: %v", err)
+		}
+		if want.Hash != fileHash {
+			t.Fatalf("%s: file hash %s, want %s", c.Params(), want.Hash, fileHash)
+		}
+		for i, chunk := range want.Chunks {
+			data := content[chunk.Offset : chunk.Offset+chunk.Size]
+			if chunk.Index != i || chunk.Hash != hash.Calculate(data) {
+				t.Fatalf("%s: chunk %d has wrong index or hash", c.Params(), i)
+			}
+		}
+
+		c.Workers = 8
+		var calls int
+		var last int64
+		got, err := c.ChunkFileProgress(path, func(hashed, total int64) {
+			calls++
+			if hashed < last || total != int64(len(content)) {
+				t.Errorf("Progress went from %d to %d of %d", last, hashed, total)
+			}
+			last = hashed
+		})
+		if err != nil {
+			t.Fatalf("ChunkFileProgress failed: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: parallel metadata differs from single-worker metadata", c.Params())
+		}
+		if calls != len(want.Chunks) || last != int64(len(content)) {
+			t.Errorf("%s: %d progress calls ending at %d, want %d ending at %d",
+				c.Params(), calls, last, len(want.Chunks), len(content))
+		}
+	}
+}
+
+func BenchmarkChunkFile(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "file.bin")
+	os.WriteFile(path, pseudoRandom(64*1024*1024, 4), 0644)
+	c := New(DefaultChunkSize)
+
+	b.SetBytes(64 * 1024 * 1024)
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ChunkFile(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
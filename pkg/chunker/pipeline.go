@@ -0,0 +1,131 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// hashPipeline hashes chunks produced by a single sequential read of a file.
+// Every chunk goes to the full-file hasher (in order) and to one of the chunk
+// hashing workers; its buffer is recycled once both are done with it, which
+// also bounds memory to a few chunks per worker.
+type hashPipeline struct {
+	ordered chan *pipelineChunk // full-file hash, in file order
+	jobs    chan *pipelineChunk // chunk hashing workers
+	results chan protocol.ChunkInfo
+	free    chan []byte
+
+	buffers    int // buffers allocated so far
+	maxBuffers int
+	chunkSize  int64
+	count      int
+
+	fileHash string
+	chunks   []protocol.ChunkInfo
+	wg       sync.WaitGroup // workers
+	done     sync.WaitGroup // file hasher and result collector
+}
+
+type pipelineChunk struct {
+	info protocol.ChunkInfo
+	data []byte
+	refs atomic.Int32
+}
+
+func newHashPipeline(workers int, chunkSize, total int64, progress ProgressFunc) *hashPipeline {
+	p := &hashPipeline{
+		ordered:    make(chan *pipelineChunk, 2*workers+2),
+		jobs:       make(chan *pipelineChunk, workers),
+		results:    make(chan protocol.ChunkInfo, workers),
+		free:       make(chan []byte, 2*workers+2),
+		maxBuffers: 2*workers + 2,
+		chunkSize:  chunkSize,
+	}
+
+	p.done.Add(2)
+	go func() {
+		defer p.done.Done()
+		h := sha256.New()
+		for chunk := range p.ordered {
+			h.Write(chunk.data)
+			p.release(chunk)
+		}
+		p.fileHash = hex.EncodeToString(h.Sum(nil))
+	}()
+	go func() {
+		defer p.done.Done()
+		var hashed int64
+		for info := range p.results {
+			p.chunks = append(p.chunks, info)
+			hashed += info.Size
+			if progress != nil {
+				progress(hashed, total)
+			}
+		}
+	}()
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for chunk := range p.jobs {
+				chunk.info.Hash = hash.Calculate(chunk.data)
+				p.results <- chunk.info
+				p.release(chunk)
+			}
+		}()
+	}
+	return p
+}
+
+// add queues a chunk read at offset. data is copied, so the caller may reuse it.
+func (p *hashPipeline) add(offset int64, data []byte) {
+	chunk := &pipelineChunk{
+		info: protocol.ChunkInfo{Index: p.count, Size: int64(len(data)), Offset: offset},
+		data: append(p.buffer(), data...),
+	}
+	chunk.refs.Store(2)
+	p.count++
+	p.ordered <- chunk
+	p.jobs <- chunk
+}
+
+// buffer returns a recycled chunk buffer, allocating up to maxBuffers
+func (p *hashPipeline) buffer() []byte {
+	select {
+	case buf := <-p.free:
+		return buf
+	default:
+	}
+	if p.buffers < p.maxBuffers {
+		p.buffers++
+		return make([]byte, 0, p.chunkSize)
+	}
+	return <-p.free
+}
+
+// release recycles a chunk's buffer once both hashers are done with it
+func (p *hashPipeline) release(chunk *pipelineChunk) {
+	if chunk.refs.Add(-1) == 0 {
+		p.free <- chunk.data[:0]
+	}
+}
+
+// close waits for all queued chunks and returns the full-file hash and the
+// chunks in file order
+func (p *hashPipeline) close() (string, []protocol.ChunkInfo) {
+	close(p.ordered)
+	close(p.jobs)
+	p.wg.Wait()
+	close(p.results)
+	p.done.Wait()
+
+	sort.Slice(p.chunks, func(i, j int) bool { return p.chunks[i].Index < p.chunks[j].Index })
+	return p.fileHash, p.chunks
+}
@@ -0,0 +1,215 @@
+// Package filetype classifies files into categories from their first bytes
+// (magic numbers), falling back to the file name extension
+package filetype
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Categories of files
+const (
+	Video    = "video"
+	Audio    = "audio"
+	Image    = "image"
+	Document = "document"
+	Archive  = "archive"
+	Software = "software"
+	Other    = "other"
+)
+
+// HeaderSize is the number of leading bytes Detect looks at
+const HeaderSize = 512
+
+// signature is a magic number at an offset of the header
+type signature struct {
+	offset   int
+	magic    string
+	category string
+}
+
+// signatures are checked in order, the first match wins. Containers whose
+// content decides the category (RIFF, ISO base media, ZIP, OLE) are refined
+// by refine.
+var signatures = []signature{
+	// Video
+	{0, "\x1a\x45\xdf\xa3", Video},                 // Matroska, WebM
+	{0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11", Video}, // ASF, WMV, WMA
+	{0, "FLV\x01", Video},
+	{0, "\x00\x00\x01\xba", Video}, // MPEG program stream
+	{0, "\x00\x00\x01\xb3", Video}, // MPEG-1 video
+
+	// Audio
+	{0, "ID3", Audio},
+	{0, "fLaC", Audio},
+	{0, "OggS", Audio},
+	{0, "MThd", Audio},     // MIDI
+	{0, "\xff\xfb", Audio}, // MP3 frames
+	{0, "\xff\xf3", Audio},
+	{0, "\xff\xf2", Audio},
+	{0, "\xff\xf1", Audio}, // AAC ADTS
+	{0, "\xff\xf9", Audio},
+	{0, "FORM", Audio}, // AIFF
+
+	// Images
+	{0, "\x89PNG\r\n\x1a\n", Image},
+	{0, "\xff\xd8\xff", Image},
+	{0, "GIF87a", Image},
+	{0, "GIF89a", Image},
+	{0, "II*\x00", Image}, // TIFF and camera raw formats
+	{0, "MM\x00*", Image},
+	{0, "8BPS", Image},             // Photoshop
+	{0, "\x00\x00\x01\x00", Image}, // ICO
+	{0, "BM", Image},
+
+	// Documents
+	{0, "%PDF-", Document},
+	{0, "%!PS", Document},
+	{0, "{\\rtf", Document},
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", Document}, // OLE: doc, xls, ppt, msi
+
+	// Archives
+	{0, "PK\x03\x04", Archive}, // ZIP and the formats based on it
+	{0, "PK\x05\x06", Archive},
+	{0, "\x1f\x8b", Archive}, // gzip
+	{0, "BZh", Archive},      // bzip2
+	{0, "\xfd7zXZ\x00", Archive},
+	{0, "7z\xbc\xaf\x27\x1c", Archive},
+	{0, "Rar!\x1a\x07", Archive},
+	{0, "\x28\xb5\x2f\xfd", Archive}, // zstd
+	{257, "ustar", Archive},          // tar
+
+	// Software
+	{0, "\x7fELF", Software},
+	{0, "MZ", Software},               // Windows executables
+	{0, "\xfe\xed\xfa\xce", Software}, // Mach-O
+	{0, "\xfe\xed\xfa\xcf", Software},
+	{0, "\xce\xfa\xed\xfe", Software},
+	{0, "\xcf\xfa\xed\xfe", Software},
+	{0, "\xca\xfe\xba\xbe", Software}, // Mach-O universal, Java class
+	{0, "\x00asm", Software},          // WebAssembly
+	{0, "!<arch>\ndebian", Software},  // Debian package
+	{0, "\xed\xab\xee\xdb", Software}, // RPM
+	{0, "#!", Software},               // Scripts
+}
+
+// extensions maps name extensions to categories, for files whose header has
+// no known signature (ISO images keep theirs at 32KB) or is not sent
+var extensions = map[string]string{}
+
+func init() {
+	for category, exts := range map[string]string{
+		Video:    "mp4 m4v mkv webm avi mov wmv flv mpg mpeg ts m2ts 3gp ogv",
+		Audio:    "mp3 flac ogg oga opus wav aac m4a wma aiff mid midi",
+		Image:    "png jpg jpeg gif bmp tif tiff webp svg ico heic heif avif psd raw cr2 nef",
+		Document: "pdf doc docx xls xlsx ppt pptx odt ods odp rtf txt md csv epub ps tex html htm json xml",
+		Archive:  "zip gz tgz bz2 xz 7z rar zst tar lz4",
+		Software: "exe msi dll so dylib deb rpm apk jar dmg pkg appimage iso img wasm sh bat bin",
+	} {
+		for _, ext := range strings.Fields(exts) {
+			extensions[ext] = category
+		}
+	}
+}
+
+// Detect returns the category of a file from its first bytes (up to
+// HeaderSize) and its name. The header wins over the extension, except for
+// containers such as ZIP whose extension says what they hold. A header that
+// looks like text with an unknown extension is a document.
+func Detect(header []byte, name string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if category, ok := detectHeader(header, ext); ok {
+		return category
+	}
+	if category, ok := extensions[ext]; ok {
+		return category
+	}
+	if isText(header) {
+		return Document
+	}
+	return Other
+}
+
+// ByExtension returns the category of a file from its name only
+func ByExtension(name string) string {
+	return Detect(nil, name)
+}
+
+func detectHeader(header []byte, ext string) (string, bool) {
+	if category, ok := detectContainer(header); ok {
+		return category, true
+	}
+	for _, sig := range signatures {
+		if len(header) >= sig.offset+len(sig.magic) && string(header[sig.offset:sig.offset+len(sig.magic)]) == sig.magic {
+			return refine(sig, ext), true
+		}
+	}
+	return "", false
+}
+
+// detectContainer recognizes the containers whose type is a few bytes in
+func detectContainer(header []byte) (string, bool) {
+	switch {
+	case len(header) >= 12 && string(header[:4]) == "RIFF":
+		switch string(header[8:12]) {
+		case "WAVE":
+			return Audio, true
+		case "AVI ":
+			return Video, true
+		case "WEBP":
+			return Image, true
+		}
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		// ISO base media: the brand tells audio and images from video
+		switch string(header[8:12]) {
+		case "M4A ", "M4B ", "M4P ":
+			return Audio, true
+		case "heic", "heix", "mif1", "msf1", "avif":
+			return Image, true
+		}
+		return Video, true
+	case len(header) >= 188*2 && header[0] == 0x47 && header[188] == 0x47 && header[2*188] == 0x47:
+		return Video, true // MPEG transport stream
+	}
+	return "", false
+}
+
+// refine uses the extension of a container format that holds other types:
+// ZIP for office documents, Java and Android packages, OLE for installers,
+// FORM for IFF images. A known extension also wins over a signature of two
+// bytes or less (MZ, BM, #!, MP3 frames), which text may start with.
+func refine(sig signature, ext string) string {
+	known, ok := extensions[ext]
+	switch {
+	case !ok:
+	case len(sig.magic) <= 2:
+		return known
+	case sig.category == Archive && (ext == "apk" || ext == "jar" || known == Document):
+		return known
+	case sig.category == Document && ext == "msi":
+		return Software
+	case sig.category == Audio && known == Image:
+		return known
+	}
+	return sig.category
+}
+
+// isText reports whether the header is UTF-8 text without control characters
+// other than whitespace
+func isText(header []byte) bool {
+	if len(header) == 0 {
+		return false
+	}
+	// The header may end in the middle of a character
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(header); i++ {
+		header = header[:len(header)-1]
+	}
+	if len(header) == 0 || !utf8.Valid(header) {
+		return false
+	}
+	return !bytes.ContainsFunc(header, func(r rune) bool {
+		return r < 0x20 && r != '\n' && r != '\r' && r != '\t' && r != '\f'
+	})
+}
@@ -0,0 +1,64 @@
+package filetype
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tar := make([]byte, HeaderSize)
+	copy(tar, "notes.txt")
+	copy(tar[257:], "ustar\x0000")
+
+	tests := []struct {
+		header string
+		name   string
+		want   string
+	}{
+		{"\x00\x00\x00\x20ftypisom", "movie.bin", Video},
+		{"\x00\x00\x00\x20ftypM4A ", "song.mp4", Audio},
+		{"\x00\x00\x00\x1cftypavif", "photo", Image},
+		{"\x1a\x45\xdf\xa3\x01", "episode.mkv", Video},
+		{"RIFF\x24\x00\x00\x00WAVEfmt ", "sound", Audio},
+		{"RIFF\x24\x00\x00\x00AVI LIST", "clip", Video},
+		{"ID3\x04\x00", "track", Audio},
+		{"\x89PNG\r\n\x1a\n", "image.dat", Image},
+		{"%PDF-1.7", "paper", Document},
+		{"PK\x03\x04\x14\x00", "archive.zip", Archive},
+		{"PK\x03\x04\x14\x00", "report.docx", Document},
+		{"PK\x03\x04\x14\x00", "app.apk", Software},
+		{"\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", "setup.msi", Software},
+		{"\x7fELF\x02\x01", "server", Software},
+		{"MZ\x90\x00", "setup.exe", Software},
+		{"MZ is my initials", "readme.txt", Document}, // Short signatures give way to the extension
+		{"#!/bin/sh\n", "install", Software},
+		{string(tar), "backup", Archive},
+		{"", "ubuntu-24.04-live-server-amd64.iso", Software}, // No signature in the first bytes
+		{"\x00\x01\x02\x03", "Movie.MKV", Video},
+		{"# Notes\n\nSome text, héllo", "NOTES", Document},
+		{"\x00\x01\x02\x03", "blob", Other},
+		{"", "blob", Other},
+	}
+	for _, tt := range tests {
+		if got := Detect([]byte(tt.header), tt.name); got != tt.want {
+			t.Errorf("Detect(%q, %q) = %s, want %s", tt.header[:min(len(tt.header), 12)], tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetect_TruncatedText(t *testing.T) {
+	// A header cut in the middle of a multi-byte character is still text
+	header := []byte("a" + strings.Repeat("é", HeaderSize/2))[:HeaderSize]
+	if got := Detect(header, "lyrics"); got != Document {
+		t.Errorf("Detect() = %s, want %s", got, Document)
+	}
+}
+
+func TestByExtension(t *testing.T) {
+	if got := ByExtension("holiday.JPEG"); got != Image {
+		t.Errorf("ByExtension() = %s, want %s", got, Image)
+	}
+	if got := ByExtension("Makefile"); got != Other {
+		t.Errorf("ByExtension() = %s, want %s", got, Other)
+	}
+}
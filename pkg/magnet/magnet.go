@@ -2,6 +2,7 @@
 package magnet
 
 import (
+	"cmp"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -12,14 +13,16 @@ import (
 
 // Magnet represents a magnet URI for file sharing
 type Magnet struct {
-	InfoHash    string   // File hash (xt=urn:sha256:...)
-	DisplayName string   // File name (dn=...)
-	Size        int64    // File size in bytes (xl=...)
-	Trackers    []string // Tracker URLs (tr=...)
-	WebSeeds    []string // Web seed URLs (ws=...)
-	Keywords    []string // Keywords for search (kt=...)
-	ChunkSize   int      // Chunk size in bytes (x.cs=...)
-	TotalChunks int      // Total chunks (x.tc=...)
+	InfoHash     string   // File hash (xt=urn:sha256:...), or a BitTorrent info hash when the link has no other
+	BTInfoHash   string   // BitTorrent v1 info hash (xt=urn:btih:...)
+	BTInfoHashV2 string   // BitTorrent v2 info hash (xt=urn:btmh:1220...)
+	DisplayName  string   // File name (dn=...)
+	Size         int64    // File size in bytes (xl=...)
+	Trackers     []string // Tracker URLs (tr=...)
+	WebSeeds     []string // Web seed URLs (ws=...)
+	Keywords     []string // Keywords for search (kt=...)
+	ChunkSize    int      // Chunk size in bytes (x.cs=...)
+	TotalChunks  int      // Total chunks (x.tc=...)
 }
 
 var (
@@ -41,15 +44,26 @@ func Parse(magnetURI string) (*Magnet, error) {
 
 	m := &Magnet{}
 
-	// Parse info hash (xt=urn:sha256:HASH or xt=urn:btih:HASH)
-	if xt := values.Get("xt"); xt != "" {
+	// Parse info hashes (xt=urn:sha256:HASH, xt=urn:btih:HASH, xt=urn:btmh:1220HASH)
+	for _, xt := range values["xt"] {
 		hash, err := parseInfoHash(xt)
 		if err != nil {
 			return nil, err
 		}
-		m.InfoHash = hash
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			m.BTInfoHash = hash
+		case strings.HasPrefix(xt, "urn:btmh:"):
+			m.BTInfoHashV2 = hash
+		default:
+			m.InfoHash = hash
+		}
 	}
 
+	// A BitTorrent-only link is looked up by its info hash
+	if m.InfoHash == "" {
+		m.InfoHash = cmp.Or(m.BTInfoHash, m.BTInfoHashV2)
+	}
 	if m.InfoHash == "" {
 		return nil, ErrMissingInfoHash
 	}
@@ -95,8 +109,16 @@ func Parse(magnetURI string) (*Magnet, error) {
 func (m *Magnet) String() string {
 	var parts []string
 
-	// Info hash (required)
-	parts = append(parts, fmt.Sprintf("xt=urn:sha256:%s", m.InfoHash))
+	// Info hashes (at least one is required)
+	if m.InfoHash != m.BTInfoHash && m.InfoHash != m.BTInfoHashV2 {
+		parts = append(parts, fmt.Sprintf("xt=urn:sha256:%s", m.InfoHash))
+	}
+	if m.BTInfoHash != "" {
+		parts = append(parts, fmt.Sprintf("xt=urn:btih:%s", m.BTInfoHash))
+	}
+	if m.BTInfoHashV2 != "" {
+		parts = append(parts, fmt.Sprintf("xt=urn:btmh:1220%s", m.BTInfoHashV2))
+	}
 
 	// Display name
 	if m.DisplayName != "" {
@@ -161,12 +183,13 @@ func parseInfoHash(xt string) (string, error) {
 	prefixes := []string{
 		"urn:sha256:",
 		"urn:sha-256:",
-		"urn:btih:", // BitTorrent compatible
+		"urn:btih:",     // BitTorrent v1
+		"urn:btmh:1220", // BitTorrent v2 (SHA-256 multihash)
 	}
 
 	for _, prefix := range prefixes {
 		if strings.HasPrefix(xt, prefix) {
-			hash := strings.TrimPrefix(xt, prefix)
+			hash := strings.ToLower(strings.TrimPrefix(xt, prefix))
 			// Validate hex string
 			if _, err := hex.DecodeString(hash); err != nil {
 				return "", fmt.Errorf("invalid hash format: %w", err)
@@ -177,4 +200,3 @@ func parseInfoHash(xt string) (string, error) {
 
 	return "", fmt.Errorf("unsupported info hash format: %s", xt)
 }
-
@@ -144,3 +144,29 @@ func TestAddTracker(t *testing.T) {
 	}
 }
 
+
+func TestParse_BitTorrent(t *testing.T) {
+	v1 := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	v2 := "d8dd32ac93357c368556af3ac1d95c9d76bd0dff6fa9833ecdac3d53134efabb"
+
+	m, err := Parse("magnet:?xt=urn:btih:" + strings.ToUpper(v1) + "&xt=urn:btmh:1220" + v2 + "&dn=data.tar")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if m.BTInfoHash != v1 || m.BTInfoHashV2 != v2 {
+		t.Errorf("BitTorrent hashes = %s, %s", m.BTInfoHash, m.BTInfoHashV2)
+	}
+	if m.InfoHash != v1 {
+		t.Errorf("InfoHash = %s, want the v1 info hash of a BitTorrent-only link", m.InfoHash)
+	}
+	if s := m.String(); strings.Contains(s, "urn:sha256") || !strings.Contains(s, "urn:btmh:1220"+v2) {
+		t.Errorf("String() = %s", s)
+	}
+
+	// A link for a mirrored torrent carries both identities
+	m.InfoHash = strings.Repeat("ab", 32)
+	again, _ := Parse(m.String())
+	if again.InfoHash != m.InfoHash || again.BTInfoHash != v1 {
+		t.Errorf("Round trip = %+v", again)
+	}
+}
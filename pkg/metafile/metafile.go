@@ -0,0 +1,248 @@
+// Package metafile reads and writes .p2pmeta files: portable descriptions of
+// a shared file (name, size, chunk hashes, Merkle root, trackers and web
+// seeds) that let it be downloaded without asking a tracker for its metadata,
+// much like .torrent files. A metafile may be signed by its publisher with an
+// Ed25519 key.
+package metafile
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/magnet"
+	"github.com/p2p-filesharing/distributed-system/pkg/merkle"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+const (
+	// Extension is the file name extension of metafiles
+	Extension = ".p2pmeta"
+	// Version is the format version written by this package
+	Version = 1
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported metafile version")
+	ErrBadSignature       = errors.New("publisher signature does not match")
+)
+
+// MetaFile is the content of a .p2pmeta file
+type MetaFile struct {
+	Version   int                   `json:"version"`
+	File      protocol.FileMetadata `json:"file"`
+	Trackers  []string              `json:"trackers,omitempty"`
+	WebSeeds  []string              `json:"web_seeds,omitempty"` // HTTP(S) URLs serving the whole file
+	CreatedAt time.Time             `json:"created_at"`
+	Comment   string                `json:"comment,omitempty"`
+	Signature *Signature            `json:"signature,omitempty"`
+}
+
+// Signature is a publisher's Ed25519 signature over every other field of the
+// metafile
+type Signature struct {
+	PublicKey string `json:"public_key"` // Base64
+	Value     string `json:"value"`      // Base64
+}
+
+// New creates an unsigned metafile for a file's metadata
+func New(metadata *protocol.FileMetadata, trackers, webSeeds []string) *MetaFile {
+	return &MetaFile{
+		Version:   Version,
+		File:      *metadata,
+		Trackers:  trackers,
+		WebSeeds:  webSeeds,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Create chunks a local file and returns its unsigned metafile. No tracker
+// is contacted.
+func Create(path string, c *chunker.Chunker, trackers, webSeeds []string) (*MetaFile, error) {
+	metadata, err := c.ChunkFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := New(metadata, trackers, webSeeds)
+	return m, m.Validate()
+}
+
+// Parse decodes and validates a metafile, checking its signature if it has one
+func Parse(data []byte) (*MetaFile, error) {
+	var m MetaFile
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid metafile: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Load reads and validates a metafile from disk
+func Load(path string) (*MetaFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Marshal encodes the metafile as indented JSON
+func (m *MetaFile) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Save writes the metafile to path
+func (m *MetaFile) Save(path string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Validate checks that the metadata is consistent: chunk sizes add up to the
+// file size, the Merkle root matches the chunk hashes, URLs are HTTP(S) and
+// the signature, if any, is valid
+func (m *MetaFile) Validate() error {
+	if m.Version != Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.Version)
+	}
+	f := &m.File
+	if f.Name == "" {
+		return errors.New("invalid metafile: missing file name")
+	}
+	if !isHash(f.Hash) {
+		return fmt.Errorf("invalid metafile: bad file hash %q", f.Hash)
+	}
+	if f.Size > 0 && len(f.Chunks) == 0 {
+		return errors.New("invalid metafile: no chunks")
+	}
+
+	var offset int64
+	hashes := make([][]byte, len(f.Chunks))
+	for i, chunk := range f.Chunks {
+		if chunk.Index != i {
+			return fmt.Errorf("invalid metafile: chunk %d has index %d", i, chunk.Index)
+		}
+		if chunk.Size <= 0 || (chunk.Offset != 0 && chunk.Offset != offset) {
+			return fmt.Errorf("invalid metafile: chunk %d has a bad size or offset", i)
+		}
+		if !isHash(chunk.Hash) {
+			return fmt.Errorf("invalid metafile: chunk %d has a bad hash", i)
+		}
+		hashes[i], _ = hex.DecodeString(chunk.Hash)
+		offset += chunk.Size
+	}
+	if offset != f.Size {
+		return fmt.Errorf("invalid metafile: chunks add up to %d bytes, file size is %d", offset, f.Size)
+	}
+	if f.MerkleRoot != "" && len(hashes) > 0 {
+		tree, err := merkle.NewTreeFromHashes(hashes)
+		if err != nil || tree.RootHex() != f.MerkleRoot {
+			return errors.New("invalid metafile: Merkle root does not match the chunk hashes")
+		}
+	}
+
+	for _, list := range [][]string{m.Trackers, m.WebSeeds} {
+		for _, raw := range list {
+			if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid metafile: %q is not an HTTP(S) URL", raw)
+			}
+		}
+	}
+
+	if m.Signature != nil {
+		return m.Verify()
+	}
+	return nil
+}
+
+// Sign signs the metafile with the publisher's key, replacing any previous
+// signature. The metafile must not be changed afterwards.
+func (m *MetaFile) Sign(key ed25519.PrivateKey) error {
+	data, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	m.Signature = &Signature{
+		PublicKey: PublicKey(key),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}
+	return nil
+}
+
+// Verify checks the publisher signature. It returns ErrBadSignature for an
+// unsigned or tampered metafile.
+func (m *MetaFile) Verify() error {
+	if m.Signature == nil {
+		return fmt.Errorf("%w: metafile is not signed", ErrBadSignature)
+	}
+	key, err := base64.StdEncoding.DecodeString(m.Signature.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: invalid public key", ErrBadSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature.Value)
+	if err != nil {
+		return fmt.Errorf("%w: invalid signature encoding", ErrBadSignature)
+	}
+	data, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Publisher returns the base64 public key of the signer, or "" if unsigned
+func (m *MetaFile) Publisher() string {
+	if m.Signature == nil {
+		return ""
+	}
+	return m.Signature.PublicKey
+}
+
+// Magnet returns a magnet link for the file with the metafile's trackers and web seeds
+func (m *MetaFile) Magnet() *magnet.Magnet {
+	link := magnet.New(m.File.Hash, m.File.Name, m.File.Size)
+	for _, tr := range m.Trackers {
+		link.AddTracker(tr)
+	}
+	link.WebSeeds = append(link.WebSeeds, m.WebSeeds...)
+	return link.SetChunkInfo(int(m.File.ChunkSize), len(m.File.Chunks))
+}
+
+// signedBytes returns the data covered by the signature: the compact JSON
+// encoding of the metafile without its signature
+func (m *MetaFile) signedBytes() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&unsigned); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isHash reports whether s is a hex SHA-256 digest
+func isHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
@@ -0,0 +1,107 @@
+package metafile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+)
+
+// createTestMeta writes a 3-chunk file and returns its metafile
+func createTestMeta(t *testing.T) *MetaFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("p2pmeta!"), 300), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Create(path, chunker.New(1000), []string{"https://tracker.example.com"}, []string{"https://cdn.example.com/data.bin"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return m
+}
+
+func TestCreate_RoundTrip(t *testing.T) {
+	m := createTestMeta(t)
+	if m.File.Name != "data.bin" || m.File.Size != 2400 || len(m.File.Chunks) != 3 || m.File.MerkleRoot == "" {
+		t.Fatalf("Unexpected metadata: %+v", m.File)
+	}
+
+	path := filepath.Join(t.TempDir(), "data"+Extension)
+	if err := m.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.File.Hash != m.File.Hash || len(loaded.Trackers) != 1 || len(loaded.WebSeeds) != 1 {
+		t.Errorf("Loaded %+v, want %+v", loaded, m)
+	}
+
+	link := loaded.Magnet()
+	if link.InfoHash != m.File.Hash || link.TotalChunks != 3 || len(link.Trackers) != 1 {
+		t.Errorf("Magnet = %+v", link)
+	}
+}
+
+func TestValidate_RejectsInconsistentMetadata(t *testing.T) {
+	tests := map[string]func(m *MetaFile){
+		"version":     func(m *MetaFile) { m.Version = 99 },
+		"hash":        func(m *MetaFile) { m.File.Hash = "abc" },
+		"size":        func(m *MetaFile) { m.File.Size++ },
+		"chunk index": func(m *MetaFile) { m.File.Chunks[1].Index = 2 },
+		"chunk hash":  func(m *MetaFile) { m.File.Chunks[0].Hash = m.File.Chunks[2].Hash },
+		"tracker":     func(m *MetaFile) { m.Trackers = []string{"ftp://tracker"} },
+	}
+	for name, corrupt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := createTestMeta(t)
+			corrupt(m)
+			data, _ := m.Marshal()
+			if _, err := Parse(data); err == nil {
+				t.Error("Parse should reject the metafile")
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "publisher.pem")
+	if _, err := GenerateKey(keyPath); err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	if _, err := GenerateKey(keyPath); err == nil {
+		t.Error("GenerateKey should not overwrite an existing key")
+	}
+	key, err := LoadKey(keyPath)
+	if err != nil {
+		t.Fatalf("LoadKey failed: %v", err)
+	}
+
+	m := createTestMeta(t)
+	if err := m.Verify(); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify of an unsigned metafile = %v, want ErrBadSignature", err)
+	}
+	if err := m.Sign(key); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	data, _ := m.Marshal()
+	signed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse of a signed metafile failed: %v", err)
+	}
+	if signed.Publisher() == "" || signed.Publisher() != m.Publisher() {
+		t.Errorf("Publisher = %q, want %q", signed.Publisher(), m.Publisher())
+	}
+
+	// Redirecting downloads to another tracker breaks the signature
+	signed.Trackers = []string{"https://evil.example.com"}
+	data, _ = signed.Marshal()
+	if _, err := Parse(data); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Parse of a tampered metafile = %v, want ErrBadSignature", err)
+	}
+}
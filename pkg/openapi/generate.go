@@ -0,0 +1,479 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Generate writes the Go source of a client package for the operations of a
+// document served under its servers. Operations of paths with servers of
+// their own, and those whose success response is not JSON, are left out.
+//
+// Schemas with an x-go-type use that type, imported from the x-go-imports of
+// the document; others become generated types named after their component,
+// or after their operation when inline. Each operation becomes a method of
+// Client named after its operationId, taking its path parameters, its body
+// and a struct of its query parameters, and sending the request through a
+// Doer.
+func Generate(doc *Document, pkg string) ([]byte, error) {
+	g := &generator{doc: doc, imports: map[string]bool{}, emitted: map[string]bool{}}
+
+	type op struct {
+		path, method string
+		item         *PathItem
+		op           *Operation
+	}
+	var ops []op
+	for path, item := range doc.Paths {
+		if len(item.Servers) > 0 {
+			continue
+		}
+		for method, o := range item.Operations() {
+			ops = append(ops, op{path, method, item, o})
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].path != ops[j].path {
+			return ops[i].path < ops[j].path
+		}
+		return ops[i].method < ops[j].method
+	})
+
+	var methods bytes.Buffer
+	names := map[string]string{}
+	for _, o := range ops {
+		if o.op.OperationID == "" {
+			return nil, fmt.Errorf("openapi: %s %s has no operationId", o.method, o.path)
+		}
+		name := exported(o.op.OperationID)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("openapi: %s %s and %s are both %s", o.method, o.path, other, name)
+		}
+		names[name] = o.method + " " + o.path
+		if err := g.method(&methods, name, o.method, o.path, o.item, o.op); err != nil {
+			return nil, err
+		}
+	}
+
+	// Component types, whether used by an operation or not
+	componentNames := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		componentNames = append(componentNames, name)
+	}
+	sort.Strings(componentNames)
+	for _, name := range componentNames {
+		if s := doc.Components.Schemas[name]; s.GoType == "" {
+			g.namedType(exported(name), s, "the schema "+name)
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by openapi-gen from the %s %s. DO NOT EDIT.\n\n", doc.Info.Title, doc.Info.Version)
+	fmt.Fprintf(&out, "package %s\n\n", pkg)
+	g.imports["context"] = true
+	out.WriteString("import (\n")
+	var std, others []string
+	for path := range g.imports {
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			others = append(others, path)
+		} else {
+			std = append(std, path)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(others)
+	for _, path := range std {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	if len(std) > 0 && len(others) > 0 {
+		out.WriteString("\n")
+	}
+	for _, path := range others {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	out.WriteString(")\n\n")
+
+	out.WriteString(`// Doer sends the requests of a Client: body, unless nil, is sent as JSON
+// and the JSON response decoded into result, unless nil. path is relative to
+// the server and carries the query.
+type Doer interface {
+	Do(ctx context.Context, method, path string, body, result any) error
+}
+
+`)
+	fmt.Fprintf(&out, "// Client calls the operations of the %s\n", doc.Info.Title)
+	out.WriteString(`type Client struct {
+	doer Doer
+}
+
+// NewClient returns a client sending its requests with doer
+func NewClient(doer Doer) *Client {
+	return &Client{doer: doer}
+}
+
+`)
+	out.Write(methods.Bytes())
+	out.Write(g.types.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("openapi: generated invalid code: %w", err)
+	}
+	return src, nil
+}
+
+type generator struct {
+	doc     *Document
+	imports map[string]bool
+	emitted map[string]bool // Names of the generated types
+	types   bytes.Buffer
+}
+
+// method writes the method of an operation
+func (g *generator) method(w *bytes.Buffer, name, method, path string, item *PathItem, op *Operation) error {
+	// The result is the JSON body of the first success response
+	result, hasResult := "", false
+	statuses := make([]string, 0, len(op.Responses))
+	for status := range op.Responses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		if !strings.HasPrefix(status, "2") {
+			continue
+		}
+		resp, _ := g.doc.resolveResponse(op.Responses[status])
+		if len(resp.Content) > 0 {
+			mt, ok := resp.Content["application/json"]
+			if !ok {
+				return nil
+			}
+			result, hasResult = g.goType(mt.Schema, name+"Response"), true
+		}
+		break
+	}
+
+	var pathParams, queryParams []*Parameter
+	for _, p := range g.doc.Parameters(item, op) {
+		switch p.In {
+		case "path":
+			pathParams = append(pathParams, p)
+		case "query":
+			queryParams = append(queryParams, p)
+		}
+	}
+	// Path parameters in the order of the path
+	slices.SortFunc(pathParams, func(a, b *Parameter) int {
+		return strings.Index(path, "{"+a.Name+"}") - strings.Index(path, "{"+b.Name+"}")
+	})
+
+	args := []string{"ctx context.Context"}
+	for _, p := range pathParams {
+		args = append(args, identifier(p.Name)+" string")
+	}
+	body := "nil"
+	if rb := op.RequestBody; rb != nil {
+		if mt, ok := rb.Content["application/json"]; ok {
+			args = append(args, "body "+pointer(g.goType(mt.Schema, name+"Request")))
+			body = "body"
+		}
+	}
+	if len(queryParams) > 0 {
+		params := name + "Params"
+		g.params(params, name, queryParams)
+		args = append(args, "params *"+params)
+	}
+
+	fmt.Fprintf(w, "// %s calls %s %s.\n", name, method, path)
+	comment(w, "", op.Summary)
+	returns := "error"
+	if hasResult {
+		returns = "(" + pointer(result) + ", error)"
+	}
+	fmt.Fprintf(w, "func (c *Client) %s(%s) %s {\n", name, strings.Join(args, ", "), returns)
+
+	// The path, with its parameters escaped
+	expr := []string{}
+	rest := path
+	for rest != "" {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			expr = append(expr, fmt.Sprintf("%q", rest))
+			break
+		}
+		end := strings.Index(rest, "}")
+		if start > 0 {
+			expr = append(expr, fmt.Sprintf("%q", rest[:start]))
+		}
+		g.imports["net/url"] = true
+		expr = append(expr, "url.PathEscape("+identifier(rest[start+1:end])+")")
+		rest = rest[end+1:]
+	}
+	fmt.Fprintf(w, "\tpath := %s\n", strings.Join(expr, " + "))
+	if len(queryParams) > 0 {
+		w.WriteString("\tif params != nil {\n\t\tpath += params.encode()\n\t}\n")
+	}
+
+	httpMethod := "http.Method" + exported(strings.ToLower(method))
+	g.imports["net/http"] = true
+	if !hasResult {
+		fmt.Fprintf(w, "\treturn c.doer.Do(ctx, %s, path, %s, nil)\n}\n\n", httpMethod, body)
+		return nil
+	}
+	if strings.HasPrefix(result, "[]") || strings.HasPrefix(result, "map[") {
+		fmt.Fprintf(w, "\tvar result %s\n", result)
+		fmt.Fprintf(w, "\tif err := c.doer.Do(ctx, %s, path, %s, &result); err != nil {\n\t\treturn nil, err\n\t}\n\treturn result, nil\n}\n\n", httpMethod, body)
+		return nil
+	}
+	fmt.Fprintf(w, "\tresult := new(%s)\n", result)
+	fmt.Fprintf(w, "\tif err := c.doer.Do(ctx, %s, path, %s, result); err != nil {\n\t\treturn nil, err\n\t}\n\treturn result, nil\n}\n\n", httpMethod, body)
+	return nil
+}
+
+// params writes the struct of the query parameters of an operation, whose
+// zero fields are not sent
+func (g *generator) params(typeName, opName string, params []*Parameter) {
+	w := &g.types
+	g.imports["net/url"] = true
+	fmt.Fprintf(w, "// %s holds the query parameters of %s\n", typeName, opName)
+	fmt.Fprintf(w, "type %s struct {\n", typeName)
+	for _, p := range params {
+		comment(w, "\t", p.Description)
+		fmt.Fprintf(w, "\t%s %s\n", exported(p.Name), g.paramType(p.Schema))
+	}
+	w.WriteString("}\n\n")
+
+	fmt.Fprintf(w, "func (p *%s) encode() string {\n\tq := url.Values{}\n", typeName)
+	for _, p := range params {
+		field := "p." + exported(p.Name)
+		switch g.paramType(p.Schema) {
+		case "[]string":
+			fmt.Fprintf(w, "\tfor _, v := range %s {\n\t\tq.Add(%q, v)\n\t}\n", field, p.Name)
+		case "int":
+			g.imports["strconv"] = true
+			fmt.Fprintf(w, "\tif %s != 0 {\n\t\tq.Set(%q, strconv.Itoa(%s))\n\t}\n", field, p.Name, field)
+		case "bool":
+			fmt.Fprintf(w, "\tif %s {\n\t\tq.Set(%q, \"true\")\n\t}\n", field, p.Name)
+		default:
+			fmt.Fprintf(w, "\tif %s != \"\" {\n\t\tq.Set(%q, %s)\n\t}\n", field, p.Name, field)
+		}
+	}
+	w.WriteString("\tif len(q) == 0 {\n\t\treturn \"\"\n\t}\n\treturn \"?\" + q.Encode()\n}\n\n")
+}
+
+// paramType returns the Go type of a query parameter
+func (g *generator) paramType(s *Schema) string {
+	s = g.doc.Resolve(s)
+	switch {
+	case s == nil:
+		return "string"
+	case s.Type == "integer":
+		return "int"
+	case s.Type == "boolean":
+		return "bool"
+	case s.Type == "array":
+		return "[]string"
+	}
+	return "string"
+}
+
+// goType returns the Go type of a schema, generating the types it needs.
+// Inline objects are named name.
+func (g *generator) goType(s *Schema, name string) string {
+	if s == nil {
+		return "any"
+	}
+	if s.Ref != "" {
+		ref, _ := refName(s.Ref, "schemas")
+		if target := g.doc.Resolve(s); target.GoType != "" {
+			return g.useGoType(target.GoType)
+		}
+		return exported(ref)
+	}
+	if s.GoType != "" {
+		return g.useGoType(s.GoType)
+	}
+	switch s.Type {
+	case "string":
+		switch s.Format {
+		case "date-time":
+			g.imports["time"] = true
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		if s.Format == "int32" {
+			return "int"
+		}
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + g.goType(s.Items, name+"Item")
+	case "object":
+		if len(s.Properties) == 0 {
+			if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+				return "map[string]" + g.goType(s.AdditionalProperties.Schema, name+"Value")
+			}
+			return "map[string]any"
+		}
+		g.namedType(name, s, "")
+		return name
+	}
+	return "any"
+}
+
+// useGoType imports the package of an x-go-type
+func (g *generator) useGoType(t string) string {
+	base := strings.TrimLeft(t, "[]*")
+	if pkg, _, ok := strings.Cut(base, "."); ok {
+		if path, ok := g.doc.GoImports[pkg]; ok {
+			g.imports[path] = true
+		}
+	}
+	return t
+}
+
+// namedType writes the declaration of a generated type
+func (g *generator) namedType(name string, s *Schema, origin string) {
+	if g.emitted[name] {
+		return
+	}
+	g.emitted[name] = true
+
+	var decl bytes.Buffer
+	if origin == "" {
+		origin = "an inline schema"
+	}
+	fmt.Fprintf(&decl, "// %s is generated from %s.\n", name, origin)
+	comment(&decl, "", s.Description)
+
+	if s.Type != "object" || len(s.Properties) == 0 {
+		fmt.Fprintf(&decl, "type %s %s\n\n", name, g.goType(&Schema{Type: s.Type, Format: s.Format, Items: s.Items, AdditionalProperties: s.AdditionalProperties}, name))
+		g.types.Write(decl.Bytes())
+		return
+	}
+
+	props := make([]string, 0, len(s.Properties))
+	for prop := range s.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	fmt.Fprintf(&decl, "type %s struct {\n", name)
+	for _, prop := range props {
+		ps := s.Properties[prop]
+		field := exported(prop)
+		t := g.goType(ps, name+field)
+		tag := prop
+		if !slices.Contains(s.Required, prop) {
+			tag += ",omitempty"
+			if r := g.doc.Resolve(ps); r.Type == "object" && len(r.Properties) > 0 {
+				t = pointer(t)
+			}
+			if t == "time.Time" {
+				tag = prop + ",omitzero"
+			}
+		}
+		comment(&decl, "\t", ps.Description)
+		fmt.Fprintf(&decl, "\t%s %s `json:%q`\n", field, t, tag)
+	}
+	decl.WriteString("}\n\n")
+	g.types.Write(decl.Bytes())
+}
+
+// comment writes text as a comment wrapped at 79 columns, tabs counting as
+// one
+func comment(w *bytes.Buffer, indent, text string) {
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(indent)+len("// ")+len(line)+len(" ")+len(word) > 79 {
+			fmt.Fprintf(w, "%s// %s\n", indent, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		fmt.Fprintf(w, "%s// %s\n", indent, line)
+	}
+}
+
+func pointer(t string) string {
+	if strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map[") || t == "any" {
+		return t
+	}
+	return "*" + t
+}
+
+// Words written in capitals in Go names
+var initialisms = map[string]bool{
+	"api": true, "bt": true, "gc": true, "http": true, "id": true, "ip": true,
+	"json": true, "mb": true, "ttl": true, "uri": true, "url": true,
+}
+
+// exported returns the exported Go name of a snake_case or camelCase name
+func exported(name string) string {
+	var b strings.Builder
+	for _, word := range words(name) {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+// identifier returns the unexported Go name of a parameter
+func identifier(name string) string {
+	ws := words(name)
+	for i, word := range ws {
+		switch {
+		case i == 0:
+			ws[i] = strings.ToLower(word)
+		case initialisms[strings.ToLower(word)]:
+			ws[i] = strings.ToUpper(word)
+		default:
+			r := []rune(word)
+			r[0] = unicode.ToUpper(r[0])
+			ws[i] = string(r)
+		}
+	}
+	return strings.Join(ws, "")
+}
+
+// words splits a name at underscores, dashes and lower to upper case changes
+func words(name string) []string {
+	var ws []string
+	start := 0
+	r := []rune(name)
+	for i := range r {
+		switch {
+		case r[i] == '_' || r[i] == '-':
+			if i > start {
+				ws = append(ws, string(r[start:i]))
+			}
+			start = i + 1
+		case i > start && unicode.IsUpper(r[i]) && unicode.IsLower(r[i-1]):
+			ws = append(ws, string(r[start:i]))
+			start = i
+		}
+	}
+	if start < len(r) {
+		ws = append(ws, string(r[start:]))
+	}
+	return ws
+}
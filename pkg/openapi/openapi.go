@@ -0,0 +1,446 @@
+// Package openapi reads the subset of OpenAPI 3 that describes the tracker
+// API, matches requests to its operations, validates requests and responses
+// against its schemas and generates Go clients from it
+package openapi
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string               `yaml:"openapi"`
+	Info       Info                 `yaml:"info"`
+	Servers    []Server             `yaml:"servers"`
+	Paths      map[string]*PathItem `yaml:"paths"`
+	Components Components           `yaml:"components"`
+
+	// Go packages of the x-go-type of schemas, by package name
+	GoImports map[string]string `yaml:"x-go-imports"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `yaml:"title"`
+	Version     string `yaml:"version"`
+	Description string `yaml:"description"`
+}
+
+// Server is a base URL of the API. Only its path is used to match requests.
+type Server struct {
+	URL         string `yaml:"url"`
+	Description string `yaml:"description"`
+}
+
+// PathItem holds the operations of a path. Servers, when set, replace those
+// of the document.
+type PathItem struct {
+	Summary    string       `yaml:"summary"`
+	Servers    []Server     `yaml:"servers"`
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Patch      *Operation   `yaml:"patch"`
+}
+
+// Operations returns the operations of the path by HTTP method
+func (p *PathItem) Operations() map[string]*Operation {
+	ops := map[string]*Operation{}
+	for method, op := range map[string]*Operation{
+		"GET": p.Get, "PUT": p.Put, "POST": p.Post, "DELETE": p.Delete, "PATCH": p.Patch,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// Operation is an HTTP method on a path
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Description string               `yaml:"description"`
+	Tags        []string             `yaml:"tags"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Ref         string  `yaml:"$ref"`
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Description string  `yaml:"description"`
+	Required    bool    `yaml:"required"`
+	Schema      *Schema `yaml:"schema"`
+}
+
+// RequestBody is the body of an operation's requests
+type RequestBody struct {
+	Description string                `yaml:"description"`
+	Required    bool                  `yaml:"required"`
+	Content     map[string]*MediaType `yaml:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Ref         string                `yaml:"$ref"`
+	Description string                `yaml:"description"`
+	Content     map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType holds the schema of a body of a content type
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Components holds the definitions referenced with $ref
+type Components struct {
+	Schemas    map[string]*Schema    `yaml:"schemas"`
+	Parameters map[string]*Parameter `yaml:"parameters"`
+	Responses  map[string]*Response  `yaml:"responses"`
+}
+
+// Schema describes a JSON value
+type Schema struct {
+	Ref                  string                `yaml:"$ref"`
+	Type                 string                `yaml:"type"`
+	Format               string                `yaml:"format"`
+	Description          string                `yaml:"description"`
+	Enum                 []any                 `yaml:"enum"`
+	Nullable             bool                  `yaml:"nullable"`
+	Properties           map[string]*Schema    `yaml:"properties"`
+	Required             []string              `yaml:"required"`
+	AdditionalProperties *AdditionalProperties `yaml:"additionalProperties"`
+	Items                *Schema               `yaml:"items"`
+	MinItems             *int                  `yaml:"minItems"`
+	MaxItems             *int                  `yaml:"maxItems"`
+	Minimum              *float64              `yaml:"minimum"`
+	Maximum              *float64              `yaml:"maximum"`
+	OneOf                []*Schema             `yaml:"oneOf"`
+
+	// Go type of the values, such as protocol.ChunkSet, used by generated
+	// code instead of one generated from the schema
+	GoType string `yaml:"x-go-type"`
+}
+
+// AdditionalProperties is the schema of the properties of an object that are
+// not in its Properties, or whether there may be any. Objects without it may
+// have any.
+type AdditionalProperties struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalYAML reads either a bool or a schema
+func (a *AdditionalProperties) UnmarshalYAML(unmarshal func(any) error) error {
+	if err := unmarshal(&a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return unmarshal(&a.Schema)
+}
+
+// Load reads a document from a YAML file
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads a document from YAML and checks its references
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", doc.OpenAPI)
+	}
+	if err := doc.check(); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return &doc, nil
+}
+
+// check resolves every reference of the document once, so that later
+// lookups cannot fail
+func (d *Document) check() error {
+	var checkSchema func(s *Schema, at string) error
+	checkSchema = func(s *Schema, at string) error {
+		if s == nil {
+			return nil
+		}
+		if s.Ref != "" {
+			if _, err := d.resolveSchema(s); err != nil {
+				return fmt.Errorf("%s: %w", at, err)
+			}
+			return nil
+		}
+		for name, p := range s.Properties {
+			if err := checkSchema(p, at+"."+name); err != nil {
+				return err
+			}
+		}
+		if s.AdditionalProperties != nil {
+			if err := checkSchema(s.AdditionalProperties.Schema, at+".additionalProperties"); err != nil {
+				return err
+			}
+		}
+		for _, o := range s.OneOf {
+			if err := checkSchema(o, at+".oneOf"); err != nil {
+				return err
+			}
+		}
+		return checkSchema(s.Items, at+"[]")
+	}
+
+	for name, s := range d.Components.Schemas {
+		if err := checkSchema(s, "components.schemas."+name); err != nil {
+			return err
+		}
+	}
+	for path, item := range d.Paths {
+		for _, p := range item.Parameters {
+			if _, err := d.resolveParameter(p); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		for method, op := range item.Operations() {
+			at := method + " " + path
+			for _, p := range op.Parameters {
+				param, err := d.resolveParameter(p)
+				if err != nil {
+					return fmt.Errorf("%s: %w", at, err)
+				}
+				if err := checkSchema(param.Schema, at+" "+param.Name); err != nil {
+					return err
+				}
+			}
+			if op.RequestBody != nil {
+				for _, mt := range op.RequestBody.Content {
+					if err := checkSchema(mt.Schema, at+" body"); err != nil {
+						return err
+					}
+				}
+			}
+			if len(op.Responses) == 0 {
+				return fmt.Errorf("%s: no responses", at)
+			}
+			for status, resp := range op.Responses {
+				resp, err := d.resolveResponse(resp)
+				if err != nil {
+					return fmt.Errorf("%s %s: %w", at, status, err)
+				}
+				for _, mt := range resp.Content {
+					if err := checkSchema(mt.Schema, at+" "+status); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// refName returns the name of a reference to a component of a kind
+func refName(ref, kind string) (string, error) {
+	name, ok := strings.CutPrefix(ref, "#/components/"+kind+"/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("unsupported reference %q", ref)
+	}
+	return name, nil
+}
+
+// resolveSchema follows the references of a schema
+func (d *Document) resolveSchema(s *Schema) (*Schema, error) {
+	for seen := 0; s.Ref != ""; seen++ {
+		name, err := refName(s.Ref, "schemas")
+		if err != nil {
+			return nil, err
+		}
+		target, ok := d.Components.Schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown schema %q", name)
+		}
+		if seen > len(d.Components.Schemas) {
+			return nil, fmt.Errorf("reference cycle at schema %q", name)
+		}
+		s = target
+	}
+	return s, nil
+}
+
+// Resolve returns the schema a schema references, or the schema itself
+func (d *Document) Resolve(s *Schema) *Schema {
+	if r, err := d.resolveSchema(s); err == nil {
+		return r
+	}
+	return s
+}
+
+func (d *Document) resolveParameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	name, err := refName(p.Ref, "parameters")
+	if err != nil {
+		return nil, err
+	}
+	target, ok := d.Components.Parameters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown parameter %q", name)
+	}
+	return target, nil
+}
+
+func (d *Document) resolveResponse(r *Response) (*Response, error) {
+	if r.Ref == "" {
+		return r, nil
+	}
+	name, err := refName(r.Ref, "responses")
+	if err != nil {
+		return nil, err
+	}
+	target, ok := d.Components.Responses[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown response %q", name)
+	}
+	return target, nil
+}
+
+// Parameters returns the parameters of an operation on a path, those of the
+// operation overriding those of the path
+func (d *Document) Parameters(item *PathItem, op *Operation) []*Parameter {
+	var params []*Parameter
+	for _, p := range op.Parameters {
+		p, _ := d.resolveParameter(p)
+		params = append(params, p)
+	}
+	for _, p := range item.Parameters {
+		p, _ := d.resolveParameter(p)
+		if !slices.ContainsFunc(params, func(q *Parameter) bool { return q.Name == p.Name && q.In == p.In }) {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// ResponseFor returns the response of an operation with a status, falling
+// back to its range (such as 4XX) and to the default response
+func (d *Document) ResponseFor(op *Operation, status int) (*Response, bool) {
+	for _, key := range []string{fmt.Sprint(status), fmt.Sprintf("%dXX", status/100), "default"} {
+		if resp, ok := op.Responses[key]; ok {
+			resp, _ := d.resolveResponse(resp)
+			return resp, true
+		}
+	}
+	return nil, false
+}
+
+// Prefixes returns the URL paths of the servers of a path, longest first.
+// The root server is an empty prefix.
+func (d *Document) Prefixes(item *PathItem) []string {
+	servers := item.Servers
+	if len(servers) == 0 {
+		servers = d.Servers
+	}
+	if len(servers) == 0 {
+		return []string{""}
+	}
+	var prefixes []string
+	for _, s := range servers {
+		prefix := s.URL
+		if u, err := url.Parse(s.URL); err == nil {
+			prefix = u.Path
+		}
+		prefix = strings.TrimSuffix(prefix, "/")
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	slices.SortFunc(prefixes, func(a, b string) int { return len(b) - len(a) })
+	return prefixes
+}
+
+// Route is the operation a request matched
+type Route struct {
+	Server    string // URL path of the server the path is relative to
+	Path      string // Path of the document, such as /files/{hash}
+	Method    string
+	Item      *PathItem
+	Operation *Operation
+	Params    map[string]string // Path parameters
+}
+
+// FindRoute returns the operation of a method on a URL path. Paths with more
+// literal segments win over templated ones, so /files/search is not taken for
+// /files/{hash}.
+func (d *Document) FindRoute(method, urlPath string) (*Route, bool) {
+	var best *Route
+	bestLiterals := -1
+	for path, item := range d.Paths {
+		op := item.Operations()[method]
+		if op == nil {
+			continue
+		}
+		for _, prefix := range d.Prefixes(item) {
+			rest, ok := strings.CutPrefix(urlPath, prefix)
+			if !ok || (prefix != "" && rest != "" && rest[0] != '/') {
+				continue
+			}
+			params, literals, ok := matchPath(path, rest)
+			if !ok {
+				continue
+			}
+			if literals > bestLiterals || (literals == bestLiterals && len(prefix) > len(best.Server)) {
+				best = &Route{Server: prefix, Path: path, Method: method, Item: item, Operation: op, Params: params}
+				bestLiterals = literals
+			}
+			break
+		}
+	}
+	return best, best != nil
+}
+
+// matchPath matches a URL path to a path template, returning its parameters
+// and the number of literal segments
+func matchPath(template, path string) (map[string]string, int, bool) {
+	want := strings.Split(strings.Trim(template, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return nil, 0, false
+	}
+	params := map[string]string{}
+	literals := 0
+	for i, seg := range want {
+		if name, ok := strings.CutPrefix(seg, "{"); ok && strings.HasSuffix(name, "}") {
+			if got[i] == "" {
+				return nil, 0, false
+			}
+			value, err := url.PathUnescape(got[i])
+			if err != nil {
+				return nil, 0, false
+			}
+			params[strings.TrimSuffix(name, "}")] = value
+			continue
+		}
+		if seg != got[i] {
+			return nil, 0, false
+		}
+		literals++
+	}
+	return params, literals, true
+}
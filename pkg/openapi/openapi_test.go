@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testSpec = `
+openapi: 3.0.3
+info: {title: Test API, version: 1.0.0}
+servers:
+  - url: /api/v2
+  - url: /api
+paths:
+  /health:
+    servers: [{url: /}]
+    get:
+      operationId: health
+      responses:
+        '200': {description: Up}
+  /files:
+    get:
+      operationId: listFiles
+      parameters:
+        - name: limit
+          in: query
+          schema: {type: integer, minimum: 1, maximum: 10}
+        - name: tag
+          in: query
+          schema: {type: array, items: {type: string}}
+      responses:
+        '200':
+          description: Files
+          content:
+            application/json:
+              schema:
+                type: array
+                items: {$ref: '#/components/schemas/File'}
+  /files/search:
+    get:
+      operationId: searchFiles
+      responses:
+        '200':
+          description: Results
+          content:
+            text/plain:
+              schema: {type: string}
+  /files/{hash}:
+    parameters:
+      - name: hash
+        in: path
+        required: true
+        schema: {type: string}
+    patch:
+      operationId: updateFile
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/File'}
+      responses:
+        '200':
+          description: Updated file
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/File'}
+        4XX:
+          description: Error
+          content:
+            application/json:
+              schema:
+                type: object
+                required: [error]
+                properties:
+                  error: {type: string}
+components:
+  schemas:
+    File:
+      type: object
+      description: A shared file
+      required: [name, size]
+      additionalProperties: false
+      properties:
+        name: {type: string}
+        size: {type: integer, minimum: 0}
+        kind: {type: string, enum: [audio, video]}
+        tags:
+          type: array
+          nullable: true
+          items: {type: string}
+`
+
+func parseTestSpec(t *testing.T) *Document {
+	t.Helper()
+	doc, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return doc
+}
+
+func TestParse_Invalid(t *testing.T) {
+	specs := map[string]string{
+		"swagger 2":   "swagger: '2.0'\ninfo: {title: T, version: '1'}\npaths: {}\n",
+		"missing ref": "openapi: 3.0.3\ninfo: {title: T, version: '1'}\npaths:\n  /a:\n    get:\n      responses:\n        '200': {$ref: '#/components/responses/Nope'}\n",
+		"not yaml":    "openapi: [3",
+	}
+	for name, spec := range specs {
+		if _, err := Parse([]byte(spec)); err == nil {
+			t.Errorf("Parse(%s) should return error", name)
+		}
+	}
+}
+
+func TestFindRoute(t *testing.T) {
+	doc := parseTestSpec(t)
+
+	tests := []struct {
+		method, path   string
+		server, target string
+		hash           string
+	}{
+		{"GET", "/api/files", "/api", "/files", ""},
+		{"GET", "/api/v2/files", "/api/v2", "/files", ""},
+		{"GET", "/api/files/search", "/api", "/files/search", ""},
+		{"PATCH", "/api/v2/files/abc", "/api/v2", "/files/{hash}", "abc"},
+		{"GET", "/health", "", "/health", ""},
+	}
+	for _, tt := range tests {
+		route, ok := doc.FindRoute(tt.method, tt.path)
+		if !ok {
+			t.Errorf("FindRoute(%s %s) found nothing", tt.method, tt.path)
+			continue
+		}
+		if route.Server != tt.server || route.Path != tt.target || route.Params["hash"] != tt.hash {
+			t.Errorf("FindRoute(%s %s) = %s%s %v", tt.method, tt.path, route.Server, route.Path, route.Params)
+		}
+	}
+
+	for _, path := range []string{"/api/files/abc", "/apix/files", "/files", "/api/v2/files/a/b"} {
+		if route, ok := doc.FindRoute("GET", path); ok {
+			t.Errorf("FindRoute(GET %s) = %s%s, want none", path, route.Server, route.Path)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	doc := parseTestSpec(t)
+	list, _ := doc.FindRoute("GET", "/api/files")
+	patch, _ := doc.FindRoute("PATCH", "/api/files/abc")
+
+	tests := []struct {
+		name  string
+		route *Route
+		query string
+		body  string
+		at    string // Where the error is, empty if valid
+	}{
+		{"no parameters", list, "", "", ""},
+		{"parameters", list, "limit=5&tag=a&tag=b", "", ""},
+		{"limit not an integer", list, "limit=x", "", "query.limit"},
+		{"limit too large", list, "limit=11", "", "query.limit"},
+		{"body", patch, "", `{"name": "a", "size": 1, "tags": null}`, ""},
+		{"missing body", patch, "", "", "body"},
+		{"not json", patch, "", `{`, "body"},
+		{"missing property", patch, "", `{"name": "a"}`, "body.size"},
+		{"wrong type", patch, "", `{"name": "a", "size": "1"}`, "body.size"},
+		{"not in enum", patch, "", `{"name": "a", "size": 1, "kind": "text"}`, "body.kind"},
+		{"unknown property", patch, "", `{"name": "a", "size": 1, "owner": "x"}`, "body.owner"},
+		{"wrong item", patch, "", `{"name": "a", "size": 1, "tags": [1]}`, "body.tags[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			err := doc.ValidateRequest(tt.route, query, []byte(tt.body))
+			if tt.at == "" {
+				if err != nil {
+					t.Errorf("ValidateRequest() error = %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) || verr.At != tt.at {
+				t.Errorf("ValidateRequest() error = %v, want one at %s", err, tt.at)
+			}
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	doc := parseTestSpec(t)
+	patch, _ := doc.FindRoute("PATCH", "/api/files/abc")
+
+	if err := doc.ValidateResponse(patch, 200, "application/json", []byte(`{"name": "a", "size": 1}`)); err != nil {
+		t.Errorf("ValidateResponse(200) error = %v", err)
+	}
+	if err := doc.ValidateResponse(patch, 404, "application/json", []byte(`{"error": "File not found"}`)); err != nil {
+		t.Errorf("ValidateResponse(404) error = %v", err)
+	}
+	if err := doc.ValidateResponse(patch, 200, "application/json", []byte(`{"name": "a"}`)); err == nil {
+		t.Error("ValidateResponse() should reject a response missing a property")
+	}
+	if err := doc.ValidateResponse(patch, 500, "application/json", []byte(`{"error": "x"}`)); err == nil {
+		t.Error("ValidateResponse() should reject an undocumented status")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	doc := parseTestSpec(t)
+	code, err := Generate(doc, "testapi")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	src := string(code)
+	for _, want := range []string{
+		"// Code generated by openapi-gen from the Test API 1.0.0. DO NOT EDIT.",
+		"package testapi",
+		"func (c *Client) ListFiles(ctx context.Context, params *ListFilesParams) ([]File, error)",
+		"func (c *Client) UpdateFile(ctx context.Context, hash string, body *File) (*File, error)",
+		"type ListFilesParams struct",
+		"type File struct",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("Generated code lacks %q", want)
+		}
+	}
+	// Health is served outside the servers of the client, search is not JSON
+	for _, unwanted := range []string{"Health(", "SearchFiles("} {
+		if strings.Contains(src, unwanted) {
+			t.Errorf("Generated code has %q", unwanted)
+		}
+	}
+}
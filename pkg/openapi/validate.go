@@ -0,0 +1,288 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError tells where a value breaks its schema, such as at
+// body.file.chunks[2].size or query.limit
+type ValidationError struct {
+	At      string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.At == "" {
+		return e.Message
+	}
+	return e.At + ": " + e.Message
+}
+
+func invalid(at, format string, args ...any) error {
+	return &ValidationError{At: at, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks a value decoded from JSON, with numbers as float64 or
+// json.Number, against a schema
+func (d *Document) Validate(s *Schema, v any, at string) error {
+	if s == nil {
+		return nil
+	}
+	s = d.Resolve(s)
+	if v == nil {
+		if s.Nullable || (s.Type == "" && len(s.OneOf) == 0) {
+			return nil
+		}
+		return invalid(at, "must not be null")
+	}
+
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, o := range s.OneOf {
+			if d.Validate(o, v, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return invalid(at, "must match exactly one schema, matches %d", matched)
+		}
+		return nil
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(v) }) {
+		return invalid(at, "must be one of %s", enumList(s.Enum))
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "string":
+		if _, ok := v.(string); !ok {
+			return invalid(at, "must be a string")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalid(at, "must be a boolean")
+		}
+	case "integer", "number":
+		n, ok := number(v)
+		if !ok || (s.Type == "integer" && n != math.Trunc(n)) {
+			if s.Type == "number" {
+				return invalid(at, "must be a number")
+			}
+			return invalid(at, "must be an integer")
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return invalid(at, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return invalid(at, "must be at most %v", *s.Maximum)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return invalid(at, "must be an array")
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			return invalid(at, "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			return invalid(at, "must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range items {
+			if err := d.Validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return invalid(at, "must be an object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return invalid(join(at, name), "is required")
+			}
+		}
+		// Sorted, for the same error on every run
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok && s.AdditionalProperties != nil {
+				if !s.AdditionalProperties.Allowed {
+					return invalid(join(at, name), "is not allowed")
+				}
+				prop = s.AdditionalProperties.Schema
+			}
+			if err := d.Validate(prop, obj[name], join(at, name)); err != nil {
+				return err
+			}
+		}
+	default:
+		return invalid(at, "unsupported schema type %q", s.Type)
+	}
+	return nil
+}
+
+func join(at, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func enumList(values []any) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// ValidateRequest checks the path and query parameters and the JSON body of a
+// request to a route. The body is read as JSON whatever its content type, as
+// the handlers do.
+func (d *Document) ValidateRequest(route *Route, query url.Values, body []byte) error {
+	for _, p := range d.Parameters(route.Item, route.Operation) {
+		var values []string
+		switch p.In {
+		case "path":
+			values = []string{route.Params[p.Name]}
+		case "query":
+			values = query[p.Name]
+		default:
+			continue
+		}
+		at := p.In + "." + p.Name
+		if len(values) == 0 {
+			if p.Required {
+				return invalid(at, "is required")
+			}
+			continue
+		}
+		if err := d.validateParameter(p, values, at); err != nil {
+			return err
+		}
+	}
+
+	rb := route.Operation.RequestBody
+	if rb == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			return invalid("body", "is required")
+		}
+		return nil
+	}
+	mt, ok := rb.Content["application/json"]
+	if !ok {
+		return nil
+	}
+	return d.validateJSON(mt.Schema, body, "body")
+}
+
+// validateParameter checks the values of a parameter, converted to the type
+// of its schema. Arrays take every value; other types the first one.
+func (d *Document) validateParameter(p *Parameter, values []string, at string) error {
+	s := d.Resolve(p.Schema)
+	if s == nil {
+		return nil
+	}
+	if s.Type == "array" {
+		items := make([]any, 0, len(values))
+		for _, v := range values {
+			item, err := d.convert(s.Items, v, at)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return d.Validate(s, items, at)
+	}
+	v, err := d.convert(s, values[0], at)
+	if err != nil {
+		return err
+	}
+	return d.Validate(s, v, at)
+}
+
+// convert reads a parameter value as the type of its schema
+func (d *Document) convert(s *Schema, value, at string) (any, error) {
+	if s == nil {
+		return value, nil
+	}
+	switch d.Resolve(s).Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, invalid(at, "must be an integer")
+		}
+		return float64(n), nil
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, invalid(at, "must be a number")
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid(at, "must be a boolean")
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// ValidateResponse checks that the status of a response is one the route
+// documents and that its JSON body matches the schema of that response
+func (d *Document) ValidateResponse(route *Route, status int, contentType string, body []byte) error {
+	resp, ok := d.ResponseFor(route.Operation, status)
+	if !ok {
+		return invalid("status", "%d is not documented", status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if len(resp.Content) == 0 || mediaType != "application/json" {
+		return nil
+	}
+	mt, ok := resp.Content["application/json"]
+	if !ok {
+		return invalid("content-type", "%s is not documented", mediaType)
+	}
+	return d.validateJSON(mt.Schema, body, "response")
+}
+
+func (d *Document) validateJSON(s *Schema, body []byte, at string) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return invalid(at, "invalid JSON: %v", err)
+	}
+	return d.Validate(s, v, at)
+}
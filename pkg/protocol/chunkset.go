@@ -0,0 +1,283 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bitfieldPrefix marks the bitfield form of an encoded ChunkSet
+const bitfieldPrefix = "b:"
+
+// ChunkSet is a set of chunk indexes, kept as sorted ranges so that the chunks
+// of a seeder or of a download progressing in order take a few bytes whatever
+// the file size.
+//
+// In JSON it is a string: ranges such as "0-99,120,200-249", or "b:" followed
+// by a base64 bitfield (the high bit of the first byte is chunk 0, as in
+// BitTorrent) when that is shorter, e.g. for chunks downloaded in random
+// order. A JSON array of indexes, the format of older peers, is also accepted.
+type ChunkSet struct {
+	ranges []chunkRange
+}
+
+// chunkRange holds the chunks from start to end, end excluded
+type chunkRange struct {
+	start, end int
+}
+
+// NewChunkSet returns the set of the given chunk indexes. Negative indexes are
+// ignored.
+func NewChunkSet(indexes ...int) ChunkSet {
+	sorted := slices.Clone(indexes)
+	slices.Sort(sorted)
+	var s ChunkSet
+	for _, i := range sorted {
+		if i >= 0 {
+			s.addRange(i, i+1)
+		}
+	}
+	return s
+}
+
+// FullChunkSet returns the set of chunks 0 to n-1
+func FullChunkSet(n int) ChunkSet {
+	var s ChunkSet
+	s.AddRange(0, n)
+	return s
+}
+
+// Add adds a chunk to the set
+func (s *ChunkSet) Add(index int) {
+	s.AddRange(index, index+1)
+}
+
+// AddRange adds the chunks from start to end, end excluded
+func (s *ChunkSet) AddRange(start, end int) {
+	start = max(start, 0)
+	if start >= end {
+		return
+	}
+	// Fast path: appending in order, as while parsing or downloading sequentially
+	if n := len(s.ranges); n == 0 || start >= s.ranges[n-1].start {
+		s.addRange(start, end)
+		return
+	}
+	merged := make([]chunkRange, 0, len(s.ranges)+1)
+	inserted := false
+	for _, r := range s.ranges {
+		if !inserted && start < r.start {
+			merged = appendRange(merged, chunkRange{start, end})
+			inserted = true
+		}
+		merged = appendRange(merged, r)
+	}
+	if !inserted {
+		merged = appendRange(merged, chunkRange{start, end})
+	}
+	s.ranges = merged
+}
+
+// addRange adds a range starting at or after the start of the last range
+func (s *ChunkSet) addRange(start, end int) {
+	s.ranges = appendRange(s.ranges, chunkRange{start, end})
+}
+
+// appendRange appends r to ranges sorted by start, merging it with the last
+// range when they overlap or touch
+func appendRange(ranges []chunkRange, r chunkRange) []chunkRange {
+	if n := len(ranges); n > 0 && r.start <= ranges[n-1].end {
+		ranges[n-1].end = max(ranges[n-1].end, r.end)
+		return ranges
+	}
+	return append(ranges, r)
+}
+
+// Union adds every chunk of other to the set
+func (s *ChunkSet) Union(other ChunkSet) {
+	for _, r := range other.ranges {
+		s.AddRange(r.start, r.end)
+	}
+}
+
+// Difference returns the chunks of the set that are not in other
+func (s ChunkSet) Difference(other ChunkSet) ChunkSet {
+	var result ChunkSet
+	j := 0
+	for _, r := range s.ranges {
+		start := r.start
+		for ; j < len(other.ranges) && other.ranges[j].start < r.end; j++ {
+			o := other.ranges[j]
+			if o.end <= start {
+				continue
+			}
+			if o.start > start {
+				result.addRange(start, o.start)
+			}
+			start = max(start, o.end)
+			if o.end > r.end {
+				// o may cover the next range too
+				break
+			}
+		}
+		if start < r.end {
+			result.addRange(start, r.end)
+		}
+	}
+	return result
+}
+
+// Clone returns a copy of the set that can be changed independently
+func (s ChunkSet) Clone() ChunkSet {
+	return ChunkSet{ranges: slices.Clone(s.ranges)}
+}
+
+// Has reports whether the set contains a chunk
+func (s ChunkSet) Has(index int) bool {
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].end > index })
+	return i < len(s.ranges) && s.ranges[i].start <= index
+}
+
+// Len returns the number of chunks in the set
+func (s ChunkSet) Len() int {
+	n := 0
+	for _, r := range s.ranges {
+		n += r.end - r.start
+	}
+	return n
+}
+
+// IsEmpty reports whether the set has no chunks
+func (s ChunkSet) IsEmpty() bool {
+	return len(s.ranges) == 0
+}
+
+// Complete reports whether the set holds every chunk of a file of n chunks
+func (s ChunkSet) Complete(n int) bool {
+	return n == 0 || (len(s.ranges) == 1 && s.ranges[0].start == 0 && s.ranges[0].end >= n)
+}
+
+// Indexes returns the chunks of the set in increasing order
+func (s ChunkSet) Indexes() []int {
+	indexes := make([]int, 0, s.Len())
+	for _, r := range s.ranges {
+		for i := r.start; i < r.end; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// String returns the ranges form, e.g. "0-99,120,200-249"
+func (s ChunkSet) String() string {
+	var b strings.Builder
+	for i, r := range s.ranges {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(r.start))
+		if r.end-r.start > 1 {
+			b.WriteByte('-')
+			b.WriteString(strconv.Itoa(r.end - 1))
+		}
+	}
+	return b.String()
+}
+
+// Bitfield returns the set as a bitfield long enough for its last chunk
+func (s ChunkSet) Bitfield() []byte {
+	if len(s.ranges) == 0 {
+		return nil
+	}
+	bits := make([]byte, (s.ranges[len(s.ranges)-1].end+7)/8)
+	for _, r := range s.ranges {
+		for i := r.start; i < r.end; i++ {
+			bits[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return bits
+}
+
+// Encode returns the shorter of the ranges and bitfield forms
+func (s ChunkSet) Encode() string {
+	ranges := s.String()
+	if len(s.ranges) == 0 {
+		return ranges
+	}
+	bitfieldLen := len(bitfieldPrefix) + base64.StdEncoding.EncodedLen((s.ranges[len(s.ranges)-1].end+7)/8)
+	if bitfieldLen >= len(ranges) {
+		return ranges
+	}
+	return bitfieldPrefix + base64.StdEncoding.EncodeToString(s.Bitfield())
+}
+
+// ParseChunkSet decodes either form returned by Encode
+func ParseChunkSet(text string) (ChunkSet, error) {
+	var s ChunkSet
+	if encoded, ok := strings.CutPrefix(text, bitfieldPrefix); ok {
+		bits, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return s, fmt.Errorf("invalid chunk bitfield: %w", err)
+		}
+		for i, b := range bits {
+			for bit := 0; b != 0 && bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					s.addRange(i*8+bit, i*8+bit+1)
+				}
+			}
+		}
+		return s, nil
+	}
+
+	if text == "" {
+		return s, nil
+	}
+	for _, part := range strings.Split(text, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		end := start
+		if err == nil && isRange {
+			end, err = strconv.Atoi(last)
+		}
+		if err != nil || start < 0 || end < start {
+			return ChunkSet{}, fmt.Errorf("invalid chunk range %q", part)
+		}
+		s.AddRange(start, end+1)
+	}
+	return s, nil
+}
+
+// MarshalJSON encodes the set as a string (see Encode)
+func (s ChunkSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Encode())
+}
+
+// UnmarshalJSON accepts both string forms and arrays of indexes
+func (s *ChunkSet) UnmarshalJSON(data []byte) error {
+	switch {
+	case string(data) == "null":
+		*s = ChunkSet{}
+		return nil
+	case len(data) > 0 && data[0] == '[':
+		var indexes []int
+		if err := json.Unmarshal(data, &indexes); err != nil {
+			return err
+		}
+		*s = NewChunkSet(indexes...)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := ParseChunkSet(text)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
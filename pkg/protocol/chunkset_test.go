@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChunkSet_Ranges(t *testing.T) {
+	s := NewChunkSet(5, 1, 2, 3, 9, 2)
+	if got := s.String(); got != "1-3,5,9" {
+		t.Errorf("String() = %q, want 1-3,5,9", got)
+	}
+	s.Add(4)
+	s.AddRange(10, 12)
+	s.Add(0)
+	if got := s.String(); got != "0-5,9-11" {
+		t.Errorf("String() after adds = %q, want 0-5,9-11", got)
+	}
+	if s.Len() != 9 || !s.Has(0) || !s.Has(11) || s.Has(6) || s.Has(12) {
+		t.Errorf("Len/Has wrong for %s", s)
+	}
+	s.Union(NewChunkSet(6, 7, 8))
+	if !s.Complete(12) || s.Complete(13) {
+		t.Errorf("%s should be complete for 12 chunks only", s)
+	}
+	if !reflect.DeepEqual(NewChunkSet(3, 1).Indexes(), []int{1, 3}) {
+		t.Error("Indexes() should be sorted")
+	}
+}
+
+func TestChunkSet_Difference(t *testing.T) {
+	a, _ := ParseChunkSet("0-9,20-29,40")
+	b, _ := ParseChunkSet("2-3,8-21,25,28-45")
+	if got := a.Difference(b).String(); got != "0-1,4-7,22-24,26-27" {
+		t.Errorf("Difference = %q, want 0-1,4-7,22-24,26-27", got)
+	}
+	if got := a.Difference(ChunkSet{}); got.String() != a.String() {
+		t.Errorf("Difference with empty set = %q", got)
+	}
+	if !b.Difference(FullChunkSet(100)).IsEmpty() {
+		t.Error("Difference with a superset should be empty")
+	}
+
+	c := a.Clone()
+	c.Add(10)
+	if a.Has(10) {
+		t.Error("changing a clone changed the original")
+	}
+}
+
+func TestChunkSet_Encode(t *testing.T) {
+	// A seeder of 400k chunks takes a few bytes
+	full := FullChunkSet(400000)
+	if got := full.Encode(); got != "0-399999" {
+		t.Errorf("Encode(full) = %q", got)
+	}
+
+	// Scattered chunks are shorter as a bitfield
+	var scattered []int
+	for i := 0; i < 1000; i += 3 {
+		scattered = append(scattered, i)
+	}
+	s := NewChunkSet(scattered...)
+	encoded := s.Encode()
+	if !strings.HasPrefix(encoded, "b:") {
+		t.Fatalf("Encode(scattered) = %q, want the bitfield form", encoded[:20])
+	}
+	decoded, err := ParseChunkSet(encoded)
+	if err != nil {
+		t.Fatalf("ParseChunkSet failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Indexes(), scattered) {
+		t.Error("bitfield round trip lost chunks")
+	}
+
+	for _, bad := range []string{"1-", "a", "5-2", "-1", "b:!!"} {
+		if _, err := ParseChunkSet(bad); err == nil {
+			t.Errorf("ParseChunkSet(%q) should fail", bad)
+		}
+	}
+}
+
+func TestChunkSet_JSON(t *testing.T) {
+	info := PeerFileInfo{Availability: &ChunkSet{}}
+	info.Availability.AddRange(0, 100)
+	data, _ := json.Marshal(info)
+	if !strings.Contains(string(data), `"availability":"0-99"`) {
+		t.Errorf("JSON = %s", data)
+	}
+
+	// Older peers and stored rows use arrays of indexes
+	var s ChunkSet
+	if err := json.Unmarshal([]byte("[0,1,2,7]"), &s); err != nil || s.String() != "0-2,7" {
+		t.Errorf("Unmarshal(array) = %s, %v", s, err)
+	}
+	if err := json.Unmarshal([]byte(`"3-4"`), &s); err != nil || s.String() != "3-4" {
+		t.Errorf("Unmarshal(string) = %s, %v", s, err)
+	}
+}
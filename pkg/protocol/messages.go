@@ -15,6 +15,7 @@ const (
 
 	// P2P message types
 	MsgHandshake    MessageType = "HANDSHAKE"
+	MsgAuth         MessageType = "AUTH"
 	MsgBitfield     MessageType = "BITFIELD"
 	MsgHave         MessageType = "HAVE"
 	MsgRequestChunk MessageType = "REQUEST_CHUNK"
@@ -32,11 +33,18 @@ type PeerInfo struct {
 
 // ChunkInfo represents metadata about a file chunk
 type ChunkInfo struct {
-	Index int    `json:"index"`
-	Hash  string `json:"hash"`
-	Size  int64  `json:"size"`
+	Index  int    `json:"index"`
+	Hash   string `json:"hash"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset,omitempty"` // Byte offset in the file (required for content-defined chunks)
 }
 
+// Chunking modes
+const (
+	ChunkingFixed = "fixed" // Every chunk but the last is ChunkSize bytes
+	ChunkingCDC   = "cdc"   // Content-defined boundaries; ChunkSize is the average size
+)
+
 // FileMetadata represents metadata about a shared file
 type FileMetadata struct {
 	Name       string      `json:"name"`
@@ -45,29 +53,91 @@ type FileMetadata struct {
 	ChunkSize  int64       `json:"chunk_size"`
 	Chunks     []ChunkInfo `json:"chunks"`
 	MerkleRoot string      `json:"merkle_root,omitempty"`
+	Chunking   string      `json:"chunking,omitempty"` // ChunkingFixed (default) or ChunkingCDC
+
+	// Visibility on the tracker: public (default), unlisted or private. It is
+	// set by the owner of the file; seeders of a private file only serve
+	// peers holding a grant of the tracker.
+	Visibility string `json:"visibility,omitempty"`
+
+	// Header holds the first bytes of the file (up to filetype.HeaderSize),
+	// from which the tracker detects its category
+	Header []byte `json:"header,omitempty"`
+
+	// Info hashes of the single-file BitTorrent torrent this file was
+	// imported from, under which the tracker also knows it
+	BTInfoHash   string `json:"bt_info_hash,omitempty"`
+	BTInfoHashV2 string `json:"bt_info_hash_v2,omitempty"`
+}
+
+// ChunkOffset returns the byte offset of a chunk in the file.
+// Recorded offsets are used when present; metadata from older peers that only
+// has fixed-size chunks falls back to index * ChunkSize.
+func (m *FileMetadata) ChunkOffset(index int) int64 {
+	if index <= 0 || index >= len(m.Chunks) {
+		return int64(max(index, 0)) * m.ChunkSize
+	}
+	if m.Chunks[index].Offset > 0 || m.Chunking == ChunkingCDC {
+		return m.Chunks[index].Offset
+	}
+	return int64(index) * m.ChunkSize
 }
 
 // === Tracker API Messages ===
 
-// RegisterRequest is sent by peer to register with tracker
+// Headers of tracker requests carrying access tokens
+const (
+	HeaderPeerToken  = "X-Peer-Token"  // Session token of the peer, proving its owner identity
+	HeaderShareToken = "X-Share-Token" // Share token of a private file
+)
+
+// RegisterRequest is sent by peer to register with tracker. OwnerToken,
+// derived from a secret the peer keeps, gives it an owner identity that
+// survives restarts. PeerKey goes into its session token and grants, for it
+// to prove its ID to seeders.
 type RegisterRequest struct {
-	PeerID   string `json:"peer_id"`
-	IP       string `json:"ip"`
-	Port     int    `json:"port"`
-	Hostname string `json:"hostname,omitempty"`
+	PeerID     string `json:"peer_id"`
+	IP         string `json:"ip"`
+	Port       int    `json:"port"`
+	Hostname   string `json:"hostname,omitempty"`
+	OwnerToken string `json:"owner_token,omitempty"`
+	PeerKey    string `json:"peer_key,omitempty"` // Base64 Ed25519 public key of the peer
 }
 
-// RegisterResponse is returned by tracker after registration
+// RegisterResponse is returned by tracker after registration. The peer sends
+// SessionToken in the X-Peer-Token header of its requests to be known as
+// OwnerID, and checks grants with AccessKey.
 type RegisterResponse struct {
 	Success      bool   `json:"success"`
 	Message      string `json:"message"`
 	SessionToken string `json:"session_token,omitempty"`
+	OwnerID      string `json:"owner_id,omitempty"`
+	AccessKey    string `json:"access_key,omitempty"` // Base64 Ed25519 public key of the tracker
 }
 
-// HeartbeatRequest is sent periodically by peer to tracker
+// HeartbeatRequest is sent periodically by peer to tracker, with the
+// transfer counters of the files it shares
 type HeartbeatRequest struct {
-	PeerID      string   `json:"peer_id"`
-	FilesHashes []string `json:"files_hashes"`
+	PeerID      string              `json:"peer_id"`
+	FilesHashes []string            `json:"files_hashes"`
+	Transfers   map[string]Transfer `json:"transfers,omitempty"` // By file hash
+}
+
+// Announce events of a peer downloading a file, as in BitTorrent
+const (
+	AnnounceStarted   = "started"   // The download started or resumed
+	AnnounceCompleted = "completed" // The peer got every chunk
+	AnnounceStopped   = "stopped"   // The peer left the swarm of the file
+	AnnouncePaused    = "paused"    // The peer keeps its chunks but stopped downloading
+)
+
+// Transfer counts the bytes of a file a peer uploaded and downloaded since it
+// started it, and those it still lacks. The counters only go up until the
+// peer restarts.
+type Transfer struct {
+	Uploaded   int64 `json:"uploaded"`
+	Downloaded int64 `json:"downloaded"`
+	Left       int64 `json:"left"`
 }
 
 // HeartbeatResponse is returned by tracker
@@ -89,11 +159,58 @@ type AnnounceResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
+// AvailabilityRequest reports the chunks a peer has of a file it is
+// downloading. Chunks are added to what the tracker knows, unless Full is set
+// and they replace it. Event, one of the Announce constants, is empty for a
+// periodic report.
+type AvailabilityRequest struct {
+	PeerID   string   `json:"peer_id"`
+	FileHash string   `json:"file_hash"`
+	Chunks   ChunkSet `json:"chunks"`
+	Full     bool     `json:"full,omitempty"`
+	Event    string   `json:"event,omitempty"`
+	Transfer
+}
+
+// AvailabilityResponse is returned by tracker. A Chunks count different from
+// the peer's own means the tracker lost earlier reports and wants a full one.
+type AvailabilityResponse struct {
+	Success  bool `json:"success"`
+	Chunks   int  `json:"chunks"`
+	IsSeeder bool `json:"is_seeder"`
+}
+
+// ScrapeRequest asks for the swarm statistics of files, by hash or
+// BitTorrent info hash. PeerID, with a session token, lets the owner of
+// private files scrape them.
+type ScrapeRequest struct {
+	PeerID string   `json:"peer_id,omitempty"`
+	Hashes []string `json:"hashes"`
+}
+
+// ScrapeFile holds the swarm statistics of a file, named as in BitTorrent
+// scrapes
+type ScrapeFile struct {
+	Name            string `json:"name"`
+	Complete        int    `json:"complete"`    // Seeders
+	Incomplete      int    `json:"incomplete"`  // Leechers, paused ones included
+	Downloaders     int    `json:"downloaders"` // Leechers that are not paused
+	Downloaded      int    `json:"downloaded"`  // Completed downloads
+	BytesUploaded   int64  `json:"bytes_uploaded"`
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+}
+
+// ScrapeResponse holds the statistics of the requested files the tracker
+// knows, by requested hash
+type ScrapeResponse struct {
+	Files map[string]ScrapeFile `json:"files"`
+}
+
 // PeerFileInfo represents a peer with file availability info
 type PeerFileInfo struct {
 	PeerInfo
-	ChunksAvailable []int `json:"chunks_available"`
-	IsSeeder        bool  `json:"is_seeder"`
+	Availability *ChunkSet `json:"availability,omitempty"` // Chunks the peer has; nil when not requested
+	IsSeeder     bool      `json:"is_seeder"`
 }
 
 // GetPeersResponse is returned when requesting peers for a file
@@ -105,6 +222,11 @@ type GetPeersResponse struct {
 	ChunkSize  int64          `json:"chunk_size"`
 	Chunks     []ChunkInfo    `json:"chunks"`
 	Peers      []PeerFileInfo `json:"peers"`
+
+	// A private file comes with a grant for the requesting peer, to send to
+	// the peers it downloads from
+	Visibility  string `json:"visibility,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
 }
 
 // FileListItem represents a file in the list
@@ -115,27 +237,102 @@ type FileListItem struct {
 	Seeders  int       `json:"seeders"`
 	Leechers int       `json:"leechers"`
 	AddedAt  time.Time `json:"added_at"`
+	Category string    `json:"category,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+
+	// Set when searching: the relevance of the file, and the byte ranges of
+	// the words of its name that match the search
+	Score      float64  `json:"score,omitempty"`
+	Highlights [][2]int `json:"highlights,omitempty"`
 }
 
-// ListFilesResponse is returned when listing available files
+// ListFilesResponse is returned when listing available files. NextCursor,
+// passed as the cursor parameter, returns the next page.
 type ListFilesResponse struct {
-	Files []FileListItem `json:"files"`
+	Files      []FileListItem `json:"files"`
+	Count      int            `json:"count"` // Files of this page
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// FileLabelsRequest edits the category and tags of a file. Tags, when set,
+// replaces them; AddTags and RemoveTags then apply. An empty Category resets
+// it to the one detected from the file name. PeerID, the peer that announced
+// the file first, is not needed on the admin endpoint.
+type FileLabelsRequest struct {
+	PeerID     string    `json:"peer_id,omitempty"`
+	Category   *string   `json:"category,omitempty"`
+	Tags       *[]string `json:"tags,omitempty"`
+	AddTags    []string  `json:"add_tags,omitempty"`
+	RemoveTags []string  `json:"remove_tags,omitempty"`
+}
+
+// FileLabelsResponse is returned by tracker with the labels of the file
+type FileLabelsResponse struct {
+	Success  bool     `json:"success"`
+	Hash     string   `json:"hash"`
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+}
+
+// FileAccessRequest sets the visibility of a file and the groups whose
+// members may download it when private. PeerID, with its session token,
+// identifies the owner; it is not needed on the admin endpoint.
+type FileAccessRequest struct {
+	PeerID     string   `json:"peer_id,omitempty"`
+	Visibility string   `json:"visibility"`
+	Groups     []string `json:"groups,omitempty"`
+}
+
+// FileAccessResponse is returned by tracker with the access of the file
+type FileAccessResponse struct {
+	Success    bool     `json:"success"`
+	Hash       string   `json:"hash"`
+	Visibility string   `json:"visibility"`
+	Groups     []string `json:"groups"`
+}
+
+// ShareRequest asks the tracker for a share token of a private file, valid
+// for TTLSecs seconds (0 for no expiry)
+type ShareRequest struct {
+	PeerID  string `json:"peer_id"`
+	TTLSecs int64  `json:"ttl_secs,omitempty"`
+}
+
+// ShareResponse carries a share token, which lets anyone holding it get the
+// peers of the file until it expires or the owner revokes the shares
+type ShareResponse struct {
+	Success   bool      `json:"success"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
 }
 
 // === P2P Messages ===
 
-// HandshakeMessage is exchanged when two peers connect
+// HandshakeMessage is exchanged when two peers connect. The seeder's carries
+// a nonce, which the connecting peer signs in an AuthMessage to prove its ID.
 type HandshakeMessage struct {
 	Type    MessageType `json:"type"`
 	PeerID  string      `json:"peer_id"`
 	Version string      `json:"version"`
+	Nonce   string      `json:"nonce,omitempty"`
+}
+
+// AuthMessage proves the ID a peer sent in its handshake: Proof is the
+// signature of the seeder's nonce with the private half of PeerKey, the key
+// in the peer's grants. The seeder answers with an empty AuthMessage, or an
+// ErrorMessage if the proof is wrong.
+type AuthMessage struct {
+	Type    MessageType `json:"type"`
+	PeerKey string      `json:"peer_key,omitempty"`
+	Proof   string      `json:"proof,omitempty"`
 }
 
 // BitfieldMessage announces which chunks a peer has
 type BitfieldMessage struct {
-	Type     MessageType `json:"type"`
-	FileHash string      `json:"file_hash"`
-	Bitfield []bool      `json:"bitfield"` // true = has chunk
+	Type        MessageType `json:"type"`
+	FileHash    string      `json:"file_hash"`
+	Bitfield    []bool      `json:"bitfield"`               // true = has chunk
+	AccessToken string      `json:"access_token,omitempty"` // Grant of the tracker, for a private file
 }
 
 // HaveMessage announces a newly acquired chunk
@@ -147,9 +344,10 @@ type HaveMessage struct {
 
 // RequestChunkMessage requests a specific chunk
 type RequestChunkMessage struct {
-	Type       MessageType `json:"type"`
-	FileHash   string      `json:"file_hash"`
-	ChunkIndex int         `json:"chunk_index"`
+	Type        MessageType `json:"type"`
+	FileHash    string      `json:"file_hash"`
+	ChunkIndex  int         `json:"chunk_index"`
+	AccessToken string      `json:"access_token,omitempty"` // Grant of the tracker, for a private file
 }
 
 // ChunkDataMessage contains the actual chunk data
@@ -176,4 +374,5 @@ const (
 	ErrHashMismatch      = 1004
 	ErrConnectionRefused = 1005
 	ErrInvalidMessage    = 1006
+	ErrAccessDenied      = 1007 // Private file requested without a valid grant
 )
@@ -0,0 +1,392 @@
+package throttle
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleRule applies fixed limits during a daily time window
+type ScheduleRule struct {
+	Days          []time.Weekday // Days the rule applies to (empty = every day)
+	Start         time.Duration  // Window start as offset from midnight
+	End           time.Duration  // Window end as offset from midnight (End <= Start wraps past midnight)
+	UploadLimit   int64          // Bytes per second (0 = unlimited)
+	DownloadLimit int64          // Bytes per second (0 = unlimited)
+}
+
+// Schedule maps the time of day to upload/download limits.
+// The first matching rule wins; outside every rule the default limits apply.
+type Schedule struct {
+	Rules           []ScheduleRule
+	DefaultUpload   int64
+	DefaultDownload int64
+}
+
+// LimitsAt returns the limits in effect at t (in t's location)
+func (s *Schedule) LimitsAt(t time.Time) (upload, download int64) {
+	for _, rule := range s.Rules {
+		if rule.matches(t) {
+			return rule.UploadLimit, rule.DownloadLimit
+		}
+	}
+	return s.DefaultUpload, s.DefaultDownload
+}
+
+// matches reports whether the rule covers t
+func (r *ScheduleRule) matches(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	day := t.Weekday()
+
+	if r.End > r.Start {
+		return offset >= r.Start && offset < r.End && r.hasDay(day)
+	}
+
+	// Window wraps past midnight: the part after midnight belongs to the previous day
+	if offset >= r.Start {
+		return r.hasDay(day)
+	}
+	if offset < r.End {
+		return r.hasDay((day + 6) % 7)
+	}
+	return false
+}
+
+func (r *ScheduleRule) hasDay(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the rule in the syntax accepted by ParseScheduleRule
+func (r ScheduleRule) String() string {
+	days := "*"
+	if len(r.Days) > 0 {
+		names := make([]string, len(r.Days))
+		for i, d := range r.Days {
+			names[i] = dayNames[d]
+		}
+		days = strings.Join(names, ",")
+	}
+	return fmt.Sprintf("%s %s-%s up=%s down=%s", days,
+		formatClock(r.Start), formatClock(r.End), FormatRate(r.UploadLimit), FormatRate(r.DownloadLimit))
+}
+
+// String formats the schedule rules in the syntax accepted by ParseSchedule
+func (s *Schedule) String() string {
+	rules := make([]string, len(s.Rules))
+	for i, r := range s.Rules {
+		rules[i] = r.String()
+	}
+	return strings.Join(rules, "; ")
+}
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseSchedule parses a ';'-separated list of rules, e.g.
+//
+//	mon-fri 08:00-18:00 up=2MB down=2MB; * 00:00-06:00 up=unlimited down=unlimited
+//
+// Default limits are left at zero for the caller to fill in.
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rule, err := ParseScheduleRule(part)
+		if err != nil {
+			return nil, err
+		}
+		s.Rules = append(s.Rules, *rule)
+	}
+	return s, nil
+}
+
+// ParseScheduleRule parses a single rule: "<days> <HH:MM>-<HH:MM> [up=<rate>] [down=<rate>]".
+// Days are "*", a day name ("mon"), a range ("mon-fri") or a comma-separated list of either.
+// A limit that is not given is unlimited.
+func ParseScheduleRule(spec string) (*ScheduleRule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid schedule rule %q: expected \"<days> <HH:MM>-<HH:MM> up=<rate> down=<rate>\"", spec)
+	}
+
+	rule := &ScheduleRule{}
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule rule %q: %w", spec, err)
+	}
+	rule.Days = days
+
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule rule %q: time window must be <HH:MM>-<HH:MM>", spec)
+	}
+	if rule.Start, err = parseClock(start); err != nil {
+		return nil, fmt.Errorf("invalid schedule rule %q: %w", spec, err)
+	}
+	if rule.End, err = parseClock(end); err != nil {
+		return nil, fmt.Errorf("invalid schedule rule %q: %w", spec, err)
+	}
+
+	for _, f := range fields[2:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule rule %q: expected key=value, got %q", spec, f)
+		}
+		rate, err := ParseRate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule rule %q: %w", spec, err)
+		}
+		switch strings.ToLower(key) {
+		case "up", "upload":
+			rule.UploadLimit = rate
+		case "down", "download":
+			rule.DownloadLimit = rate
+		default:
+			return nil, fmt.Errorf("invalid schedule rule %q: unknown key %q", spec, key)
+		}
+	}
+
+	return rule, nil
+}
+
+// ParseRate parses a rate such as "512KB", "2MB", "1.5GB", "1000" (bytes) or "unlimited"
+func ParseRate(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "/S")
+	if s == "" || s == "0" || s == "UNLIMITED" || s == "NONE" {
+		return Unlimited, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		value  int64
+	}{{"GB", GB}, {"MB", MB}, {"KB", KB}, {"G", GB}, {"M", MB}, {"K", KB}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.value
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatRate formats a rate in the syntax accepted by ParseRate
+func FormatRate(bytesPerSecond int64) string {
+	switch {
+	case bytesPerSecond <= 0:
+		return "unlimited"
+	case bytesPerSecond%GB == 0:
+		return fmt.Sprintf("%dGB", bytesPerSecond/GB)
+	case bytesPerSecond%MB == 0:
+		return fmt.Sprintf("%dMB", bytesPerSecond/MB)
+	case bytesPerSecond%KB == 0:
+		return fmt.Sprintf("%dKB", bytesPerSecond/KB)
+	default:
+		return strconv.FormatInt(bytesPerSecond, 10)
+	}
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	s = strings.ToLower(s)
+	if s == "*" || s == "daily" || s == "all" {
+		return nil, nil
+	}
+
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseDay(from)
+		if err != nil {
+			return nil, err
+		}
+		if !isRange {
+			days = append(days, first)
+			continue
+		}
+		last, err := parseDay(to)
+		if err != nil {
+			return nil, err
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseDay(s string) (time.Weekday, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 3 {
+		for i, name := range dayNames {
+			if s[:3] == name {
+				return time.Weekday(i), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+func parseClock(s string) (time.Duration, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", s)
+	}
+	h, err := strconv.Atoi(hh)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", s)
+	}
+	m, err := strconv.Atoi(mm)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid minute in %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Scheduler applies a Schedule to a BandwidthManager as the time of day changes
+type Scheduler struct {
+	manager  *BandwidthManager
+	schedule *Schedule
+	interval time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	stop     chan struct{}
+	running  bool
+}
+
+// NewScheduler creates a scheduler driving manager's upload and download limits
+func NewScheduler(manager *BandwidthManager, schedule *Schedule) *Scheduler {
+	if schedule == nil {
+		upload, download := manager.GetLimits()
+		schedule = &Schedule{DefaultUpload: upload, DefaultDownload: download}
+	}
+	return &Scheduler{
+		manager:  manager,
+		schedule: schedule,
+		interval: 30 * time.Second,
+		now:      time.Now,
+	}
+}
+
+// Start applies the current limits and re-evaluates the schedule periodically
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = true
+	s.stop = make(chan struct{})
+	stop := s.stop
+	s.mu.Unlock()
+
+	s.Apply()
+
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Apply()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops periodic re-evaluation; the last applied limits stay in effect
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		close(s.stop)
+		s.running = false
+	}
+}
+
+// Apply sets the manager's limits to those in effect now
+func (s *Scheduler) Apply() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyUnsafe()
+}
+
+// applyUnsafe sets the manager's limits (caller must hold lock). Holding the
+// lock until they are set keeps a concurrent Apply from leaving stale limits.
+func (s *Scheduler) applyUnsafe() {
+	upload, download := s.schedule.LimitsAt(s.now())
+
+	curUp, curDown := s.manager.GetLimits()
+	if curUp == upload && curDown == download {
+		return
+	}
+
+	s.manager.SetUploadLimit(upload)
+	s.manager.SetDownloadLimit(download)
+	log.Printf("[Throttle] Limits changed: up=%s down=%s", FormatRate(upload), FormatRate(download))
+}
+
+// SetSchedule replaces the schedule rules at runtime and applies them immediately.
+// The default limits are kept.
+func (s *Scheduler) SetSchedule(rules []ScheduleRule) {
+	s.mu.Lock()
+	s.schedule = &Schedule{
+		Rules:           rules,
+		DefaultUpload:   s.schedule.DefaultUpload,
+		DefaultDownload: s.schedule.DefaultDownload,
+	}
+	s.applyUnsafe()
+	s.mu.Unlock()
+}
+
+// SetDefaultLimits changes the limits used outside every rule and applies them immediately
+func (s *Scheduler) SetDefaultLimits(upload, download int64) {
+	s.mu.Lock()
+	s.schedule = &Schedule{
+		Rules:           s.schedule.Rules,
+		DefaultUpload:   upload,
+		DefaultDownload: download,
+	}
+	s.applyUnsafe()
+	s.mu.Unlock()
+}
+
+// GetSchedule returns a copy of the schedule currently in use
+func (s *Scheduler) GetSchedule() Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule := *s.schedule
+	schedule.Rules = append([]ScheduleRule(nil), s.schedule.Rules...)
+	return schedule
+}
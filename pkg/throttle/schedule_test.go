@@ -0,0 +1,185 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"unlimited", Unlimited},
+		{"0", Unlimited},
+		{"1000", 1000},
+		{"512KB", 512 * KB},
+		{"2MB", 2 * MB},
+		{"2mb/s", 2 * MB},
+		{"1.5GB", 3 * GB / 2},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil {
+			t.Errorf("ParseRate(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := ParseRate("fast"); err == nil {
+		t.Error("ParseRate(\"fast\") should fail")
+	}
+}
+
+func TestParseScheduleRule(t *testing.T) {
+	rule, err := ParseScheduleRule("mon-fri 08:00-18:00 up=2MB down=1MB")
+	if err != nil {
+		t.Fatalf("ParseScheduleRule() error = %v", err)
+	}
+
+	if len(rule.Days) != 5 || rule.Days[0] != time.Monday || rule.Days[4] != time.Friday {
+		t.Errorf("Days = %v, want Monday..Friday", rule.Days)
+	}
+	if rule.Start != 8*time.Hour || rule.End != 18*time.Hour {
+		t.Errorf("Window = %v-%v, want 8h-18h", rule.Start, rule.End)
+	}
+	if rule.UploadLimit != 2*MB || rule.DownloadLimit != MB {
+		t.Errorf("Limits = (%d, %d), want (%d, %d)", rule.UploadLimit, rule.DownloadLimit, 2*MB, MB)
+	}
+
+	// Round trip through String
+	again, err := ParseScheduleRule(rule.String())
+	if err != nil {
+		t.Fatalf("ParseScheduleRule(String()) error = %v", err)
+	}
+	if again.String() != rule.String() {
+		t.Errorf("String() round trip = %q, want %q", again.String(), rule.String())
+	}
+
+	invalid := []string{
+		"",
+		"mon-fri",
+		"funday 08:00-18:00",
+		"* 8-18",
+		"* 25:00-18:00",
+		"* 08:00-18:00 speed=1MB",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseScheduleRule(spec); err == nil {
+			t.Errorf("ParseScheduleRule(%q) should fail", spec)
+		}
+	}
+}
+
+func TestSchedule_LimitsAt(t *testing.T) {
+	s, err := ParseSchedule("mon-fri 08:00-18:00 up=2MB down=2MB; * 22:00-06:00 up=unlimited down=unlimited")
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	s.DefaultUpload = 5 * MB
+	s.DefaultDownload = 10 * MB
+
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		t        time.Time
+		wantUp   int64
+		wantDown int64
+	}{
+		{"monday office hours", at(1, 9, 30), 2 * MB, 2 * MB},
+		{"monday office end is exclusive", at(1, 18, 0), 5 * MB, 10 * MB},
+		{"monday evening", at(1, 20, 0), 5 * MB, 10 * MB},
+		{"monday night", at(1, 23, 0), Unlimited, Unlimited},
+		{"tuesday early morning (wrapped window)", at(2, 5, 59), Unlimited, Unlimited},
+		{"saturday midday", at(6, 12, 0), 5 * MB, 10 * MB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down := s.LimitsAt(tt.t)
+			if up != tt.wantUp || down != tt.wantDown {
+				t.Errorf("LimitsAt() = (%d, %d), want (%d, %d)", up, down, tt.wantUp, tt.wantDown)
+			}
+		})
+	}
+}
+
+func TestScheduleRule_WrappedWindowDays(t *testing.T) {
+	// Friday night only: the hours after midnight belong to Friday, not Saturday
+	rule, err := ParseScheduleRule("fri 22:00-02:00 up=1MB")
+	if err != nil {
+		t.Fatalf("ParseScheduleRule() error = %v", err)
+	}
+
+	saturdayEarly := time.Date(2024, 1, 6, 1, 0, 0, 0, time.UTC)
+	if !rule.matches(saturdayEarly) {
+		t.Error("Rule should match early Saturday (continuation of Friday night)")
+	}
+	sundayEarly := time.Date(2024, 1, 7, 1, 0, 0, 0, time.UTC)
+	if rule.matches(sundayEarly) {
+		t.Error("Rule should not match early Sunday")
+	}
+}
+
+func TestScheduler_Apply(t *testing.T) {
+	bm := NewBandwidthManager(Unlimited, Unlimited)
+	s, _ := ParseSchedule("* 08:00-18:00 up=1MB down=2MB")
+	s.DefaultUpload = 5 * MB
+
+	current := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	sched := NewScheduler(bm, s)
+	sched.now = func() time.Time { return current }
+
+	sched.Apply()
+	if up, down := bm.GetLimits(); up != MB || down != 2*MB {
+		t.Errorf("GetLimits() during window = (%d, %d), want (%d, %d)", up, down, MB, 2*MB)
+	}
+
+	current = current.Add(10 * time.Hour)
+	sched.Apply()
+	if up, down := bm.GetLimits(); up != 5*MB || down != Unlimited {
+		t.Errorf("GetLimits() after window = (%d, %d), want (%d, 0)", up, down, 5*MB)
+	}
+
+	// Runtime changes take effect immediately
+	sched.SetDefaultLimits(Limit100KB, Limit500KB)
+	if up, down := bm.GetLimits(); up != Limit100KB || down != Limit500KB {
+		t.Errorf("GetLimits() after SetDefaultLimits = (%d, %d)", up, down)
+	}
+
+	rule, _ := ParseScheduleRule("* 00:00-24:00 up=10MB down=10MB")
+	sched.SetSchedule([]ScheduleRule{*rule})
+	if up, down := bm.GetLimits(); up != 10*MB || down != 10*MB {
+		t.Errorf("GetLimits() after SetSchedule = (%d, %d)", up, down)
+	}
+
+	sched.SetSchedule(nil)
+	if up, _ := bm.GetLimits(); up != Limit100KB {
+		t.Errorf("GetLimits() after clearing schedule = %d, want %d", up, Limit100KB)
+	}
+}
+
+func TestBandwidthManager_WaitStats(t *testing.T) {
+	bm := NewBandwidthManager(Unlimited, Unlimited)
+	ctx := t.Context()
+
+	if err := bm.WaitUpload(ctx, 100); err != nil {
+		t.Fatalf("WaitUpload() error = %v", err)
+	}
+	if err := bm.WaitDownload(ctx, 200); err != nil {
+		t.Fatalf("WaitDownload() error = %v", err)
+	}
+
+	stats := bm.GetStats()
+	if stats.TotalUploaded != 100 || stats.TotalDownloaded != 200 {
+		t.Errorf("Stats = (%d, %d), want (100, 200)", stats.TotalUploaded, stats.TotalDownloaded)
+	}
+}
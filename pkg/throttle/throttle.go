@@ -39,6 +39,19 @@ func (l *Limiter) SetRate(bytesPerSecond int64) {
 	l.bytesPerSecond = bytesPerSecond
 }
 
+// SetBurst updates the maximum burst size (0 = same as the current rate)
+func (l *Limiter) SetBurst(burstSize int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if burstSize <= 0 {
+		burstSize = l.bytesPerSecond
+	}
+	l.maxBucket = burstSize
+	if l.bucket > l.maxBucket {
+		l.bucket = l.maxBucket
+	}
+}
+
 // GetRate returns the current rate limit
 func (l *Limiter) GetRate() int64 {
 	l.mu.Lock()
@@ -66,21 +79,21 @@ func (l *Limiter) Wait(ctx context.Context, n int64) error {
 		l.bucket = l.maxBucket
 	}
 
-	// If we have enough tokens, consume and return
-	if l.bucket >= n {
-		l.bucket -= n
+	// Consume tokens. A negative balance is debt that concurrent callers
+	// also have to wait out, so the aggregate rate stays within the limit.
+	l.bucket -= n
+	if l.bucket >= 0 {
 		return nil
 	}
 
 	// Calculate wait time
-	needed := n - l.bucket
-	waitTime := time.Duration(float64(needed) / float64(l.bytesPerSecond) * float64(time.Second))
-	l.bucket = 0
+	waitTime := time.Duration(float64(-l.bucket) / float64(l.bytesPerSecond) * float64(time.Second))
 
 	l.mu.Unlock()
 	select {
 	case <-ctx.Done():
 		l.mu.Lock()
+		l.bucket += n // Return the tokens we did not use
 		return ctx.Err()
 	case <-time.After(waitTime):
 		l.mu.Lock()
@@ -170,11 +183,13 @@ func NewBandwidthManager(uploadLimit, downloadLimit int64) *BandwidthManager {
 // SetUploadLimit sets the upload rate limit
 func (m *BandwidthManager) SetUploadLimit(bytesPerSecond int64) {
 	m.uploadLimiter.SetRate(bytesPerSecond)
+	m.uploadLimiter.SetBurst(bytesPerSecond * 2)
 }
 
 // SetDownloadLimit sets the download rate limit
 func (m *BandwidthManager) SetDownloadLimit(bytesPerSecond int64) {
 	m.downloadLimiter.SetRate(bytesPerSecond)
+	m.downloadLimiter.SetBurst(bytesPerSecond * 2)
 }
 
 // GetLimits returns current limits
@@ -198,6 +213,30 @@ func (m *BandwidthManager) WrapWriter(ctx context.Context, w io.Writer) io.Write
 	}
 }
 
+// WaitUpload blocks until n bytes may be sent and records them as uploaded.
+// Use it when data is transferred in whole messages rather than through WrapWriter.
+func (m *BandwidthManager) WaitUpload(ctx context.Context, n int64) error {
+	if err := m.uploadLimiter.Wait(ctx, n); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.stats.TotalUploaded += n
+	m.mu.Unlock()
+	return nil
+}
+
+// WaitDownload blocks until n received bytes fit within the download limit
+// and records them as downloaded.
+func (m *BandwidthManager) WaitDownload(ctx context.Context, n int64) error {
+	if err := m.downloadLimiter.Wait(ctx, n); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.stats.TotalDownloaded += n
+	m.mu.Unlock()
+	return nil
+}
+
 // GetStats returns current bandwidth statistics
 func (m *BandwidthManager) GetStats() BandwidthStats {
 	m.mu.RLock()
@@ -0,0 +1,103 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/bencode"
+)
+
+// Options configure Create
+type Options struct {
+	Name        string // Default: the base name of the file
+	PieceLength int64  // Power of two, at least 16 KiB. Default: DefaultPieceLength
+}
+
+// Create hashes a local file and returns a hybrid v1/v2 single-file torrent
+// for it, readable by both older and current BitTorrent clients. Trackers,
+// web seeds and comment can be set on the result before it is saved.
+func Create(path string, opts Options) (*Torrent, error) {
+	if opts.Name == "" {
+		opts.Name = filepath.Base(path)
+	}
+	if opts.PieceLength == 0 {
+		opts.PieceLength = DefaultPieceLength
+	}
+	if opts.PieceLength < BlockSize || opts.PieceLength&(opts.PieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two of at least %d bytes", BlockSize)
+	}
+	if err := checkComponent(opts.Name); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// One pass: SHA-1 per piece for v1, SHA-256 per 16 KiB block for v2
+	var pieces []byte
+	var leaves [][sha256.Size]byte
+	var size int64
+	buf := make([]byte, opts.PieceLength)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			pieces = append(pieces, sum[:]...)
+			for start := 0; start < n; start += BlockSize {
+				leaves = append(leaves, sha256.Sum256(buf[start:min(start+BlockSize, n)]))
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	root, layer := merkleV2(leaves, size, opts.PieceLength)
+
+	leaf := map[string]any{"length": size}
+	if size > 0 {
+		leaf["pieces root"] = root
+	}
+	info := map[string]any{
+		"file tree":    map[string]any{opts.Name: map[string]any{"": leaf}},
+		"length":       size,
+		"meta version": 2,
+		"name":         opts.Name,
+		"piece length": opts.PieceLength,
+		"pieces":       pieces,
+	}
+	rawInfo, err := bencode.Encode(info)
+	if err != nil {
+		return nil, err
+	}
+
+	v1 := sha1.Sum(rawInfo)
+	v2 := sha256.Sum256(rawInfo)
+	t := &Torrent{
+		Name:         opts.Name,
+		PieceLength:  opts.PieceLength,
+		Pieces:       pieces,
+		Files:        []File{{Path: []string{opts.Name}, Length: size, PiecesRoot: root}},
+		CreationDate: time.Now().UTC().Truncate(time.Second),
+		InfoHash:     hex.EncodeToString(v1[:]),
+		InfoHashV2:   hex.EncodeToString(v2[:]),
+		single:       true,
+		rawInfo:      rawInfo,
+	}
+	if layer != nil {
+		t.pieceLayers = map[string]any{string(root): layer}
+	}
+	return t, nil
+}
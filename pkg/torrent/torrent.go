@@ -0,0 +1,366 @@
+// Package torrent reads, verifies and creates BitTorrent metainfo (.torrent)
+// files: v1 (BEP 3), v2 (BEP 52) and hybrid torrents, single-file and
+// multi-file. It lets content distributed as torrents be mirrored into the
+// P2P network: a torrent is checked against local data, which is then shared
+// like any other file.
+package torrent
+
+import (
+	"cmp"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/bencode"
+	"github.com/p2p-filesharing/distributed-system/pkg/magnet"
+)
+
+const (
+	// Extension is the file name extension of torrent files
+	Extension = ".torrent"
+	// BlockSize is the size of the leaves of v2 Merkle trees
+	BlockSize = 16 * 1024
+	// DefaultPieceLength is the piece length used by Create when none is given
+	DefaultPieceLength = 256 * 1024
+)
+
+var (
+	ErrInvalid  = errors.New("invalid torrent")
+	ErrMismatch = errors.New("data does not match the torrent")
+)
+
+// Torrent is a parsed .torrent file
+type Torrent struct {
+	Name         string    `json:"name"`
+	PieceLength  int64     `json:"piece_length"`
+	Pieces       []byte    `json:"-"` // Concatenated SHA-1 piece hashes (v1)
+	Files        []File    `json:"files"`
+	Trackers     []string  `json:"trackers,omitempty"`  // announce, then announce-list
+	WebSeeds     []string  `json:"web_seeds,omitempty"` // url-list
+	Comment      string    `json:"comment,omitempty"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreationDate time.Time `json:"creation_date,omitzero"`
+	InfoHash     string    `json:"info_hash,omitempty"`    // Hex SHA-1 of the info dictionary, for v1 and hybrid torrents
+	InfoHashV2   string    `json:"info_hash_v2,omitempty"` // Hex SHA-256 of the info dictionary, for v2 and hybrid torrents
+
+	single      bool
+	rawInfo     bencode.RawMessage
+	pieceLayers map[string]any
+}
+
+// File is a file of a torrent
+type File struct {
+	Path       []string `json:"path"` // Relative to the torrent directory; the file name for single-file torrents
+	Length     int64    `json:"length"`
+	PiecesRoot []byte   `json:"-"`                 // v2 Merkle root of the file's 16 KiB blocks
+	Padding    bool     `json:"padding,omitempty"` // v1 pad file (BEP 47): zeros aligning the next file to a piece
+}
+
+// Parse decodes a .torrent file
+func Parse(data []byte) (*Torrent, error) {
+	decoded, err := bencode.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	root, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a dictionary", ErrInvalid)
+	}
+	info, ok := root["info"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing info dictionary", ErrInvalid)
+	}
+	rawInfo, err := bencode.RawValue(data, "info")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	t := &Torrent{rawInfo: rawInfo}
+	t.Name, _ = info["name"].(string)
+	t.PieceLength, _ = info["piece length"].(int64)
+	if err := checkComponent(t.Name); err != nil {
+		return nil, err
+	}
+	if t.PieceLength < BlockSize || t.PieceLength&(t.PieceLength-1) != 0 {
+		// v1 allows any piece length, but every client uses powers of two
+		if _, v2 := info["file tree"]; v2 || t.PieceLength <= 0 {
+			return nil, fmt.Errorf("%w: bad piece length %d", ErrInvalid, t.PieceLength)
+		}
+	}
+
+	if version, _ := info["meta version"].(int64); version == 2 {
+		sum := sha256.Sum256(rawInfo)
+		t.InfoHashV2 = hex.EncodeToString(sum[:])
+		if err := t.parseFileTree(info); err != nil {
+			return nil, err
+		}
+		t.pieceLayers, _ = root["piece layers"].(map[string]any)
+	}
+	if pieces, ok := info["pieces"].(string); ok {
+		sum := sha1.Sum(rawInfo)
+		t.InfoHash = hex.EncodeToString(sum[:])
+		t.Pieces = []byte(pieces)
+		if err := t.parseV1Files(info); err != nil {
+			return nil, err
+		}
+	}
+	if t.InfoHash == "" && t.InfoHashV2 == "" {
+		return nil, fmt.Errorf("%w: neither v1 pieces nor a v2 file tree", ErrInvalid)
+	}
+
+	if announce, ok := root["announce"].(string); ok && announce != "" {
+		t.Trackers = append(t.Trackers, announce)
+	}
+	tiers, _ := root["announce-list"].([]any)
+	for _, tier := range tiers {
+		urls, _ := tier.([]any)
+		for _, u := range urls {
+			if s, ok := u.(string); ok && !slices.Contains(t.Trackers, s) {
+				t.Trackers = append(t.Trackers, s)
+			}
+		}
+	}
+	switch seeds := root["url-list"].(type) {
+	case string:
+		t.WebSeeds = []string{seeds}
+	case []any:
+		for _, u := range seeds {
+			if s, ok := u.(string); ok {
+				t.WebSeeds = append(t.WebSeeds, s)
+			}
+		}
+	}
+	t.Comment, _ = root["comment"].(string)
+	t.CreatedBy, _ = root["created by"].(string)
+	if date, ok := root["creation date"].(int64); ok && date > 0 {
+		t.CreationDate = time.Unix(date, 0).UTC()
+	}
+	return t, nil
+}
+
+// Load reads and parses a .torrent file from disk
+func Load(path string) (*Torrent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// parseV1Files reads the v1 file list. Hybrid torrents describe the same files
+// twice; the v1 list adds the pad files needed to verify v1 pieces.
+func (t *Torrent) parseV1Files(info map[string]any) error {
+	var files []File
+	if length, ok := info["length"].(int64); ok {
+		t.single = true
+		files = []File{{Path: []string{t.Name}, Length: length}}
+	} else {
+		list, ok := info["files"].([]any)
+		if !ok {
+			return fmt.Errorf("%w: neither length nor files", ErrInvalid)
+		}
+		for _, item := range list {
+			entry, _ := item.(map[string]any)
+			f := File{}
+			f.Length, _ = entry["length"].(int64)
+			attr, _ := entry["attr"].(string)
+			f.Padding = strings.Contains(attr, "p")
+			parts, _ := entry["path"].([]any)
+			for _, part := range parts {
+				s, _ := part.(string)
+				if err := checkComponent(s); err != nil && !f.Padding {
+					return err
+				}
+				f.Path = append(f.Path, s)
+			}
+			if len(f.Path) == 0 || f.Length < 0 {
+				return fmt.Errorf("%w: bad file entry", ErrInvalid)
+			}
+			files = append(files, f)
+		}
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	if len(t.Pieces)%sha1.Size != 0 || int64(len(t.Pieces)/sha1.Size) != (total+t.PieceLength-1)/t.PieceLength {
+		return fmt.Errorf("%w: %d piece hash bytes for %d bytes of data", ErrInvalid, len(t.Pieces), total)
+	}
+
+	if t.Files == nil {
+		t.Files = files
+		return nil
+	}
+	// Hybrid: keep the v2 files and their roots, in v1 order with the pad files
+	byPath := make(map[string]File, len(t.Files))
+	for _, f := range t.Files {
+		byPath[strings.Join(f.Path, "/")] = f
+	}
+	for i, f := range files {
+		if f.Padding {
+			continue
+		}
+		v2, ok := byPath[strings.Join(f.Path, "/")]
+		if !ok || v2.Length != f.Length {
+			return fmt.Errorf("%w: v1 and v2 file lists differ at %s", ErrInvalid, strings.Join(f.Path, "/"))
+		}
+		files[i].PiecesRoot = v2.PiecesRoot
+	}
+	t.Files = files
+	return nil
+}
+
+// parseFileTree reads the v2 file tree
+func (t *Torrent) parseFileTree(info map[string]any) error {
+	tree, ok := info["file tree"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: missing file tree", ErrInvalid)
+	}
+	var walk func(node map[string]any, path []string, depth int) error
+	walk = func(node map[string]any, path []string, depth int) error {
+		if depth > 64 {
+			return fmt.Errorf("%w: file tree is too deep", ErrInvalid)
+		}
+		if leaf, ok := node[""].(map[string]any); ok {
+			f := File{Path: slices.Clone(path)}
+			f.Length, _ = leaf["length"].(int64)
+			root, _ := leaf["pieces root"].(string)
+			if f.Length < 0 || (f.Length > 0 && len(root) != sha256.Size) {
+				return fmt.Errorf("%w: bad file entry %s", ErrInvalid, strings.Join(path, "/"))
+			}
+			if f.Length > 0 {
+				f.PiecesRoot = []byte(root)
+			}
+			t.Files = append(t.Files, f)
+			return nil
+		}
+		// Sorted like the bencoded dictionary, which is also the v1 order
+		names := make([]string, 0, len(node))
+		for name := range node {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := checkComponent(name); err != nil {
+				return err
+			}
+			child, ok := node[name].(map[string]any)
+			if !ok {
+				return fmt.Errorf("%w: bad file tree node %q", ErrInvalid, name)
+			}
+			if err := walk(child, append(path, name), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(tree, nil, 0); err != nil {
+		return err
+	}
+	if len(t.Files) == 0 {
+		return fmt.Errorf("%w: empty file tree", ErrInvalid)
+	}
+	// A single file at the top of the tree, named like the torrent
+	t.single = len(t.Files) == 1 && len(t.Files[0].Path) == 1 && t.Files[0].Path[0] == t.Name
+	return nil
+}
+
+// checkComponent rejects path components that could escape the download directory
+func checkComponent(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w: unsafe path component %q", ErrInvalid, name)
+	}
+	return nil
+}
+
+// SingleFile reports whether the torrent describes a single file rather than
+// a directory
+func (t *Torrent) SingleFile() bool {
+	return t.single
+}
+
+// TotalSize returns the size of the content, not counting pad files
+func (t *Torrent) TotalSize() int64 {
+	var total int64
+	for _, f := range t.Files {
+		if !f.Padding {
+			total += f.Length
+		}
+	}
+	return total
+}
+
+// FilePath returns where a file of the torrent is stored when the content is
+// in dir: dir/<name> for single-file torrents, dir/<name>/<path> otherwise
+func (t *Torrent) FilePath(dir string, f *File) string {
+	if t.single {
+		return filepath.Join(dir, t.Name)
+	}
+	return filepath.Join(append([]string{dir, t.Name}, f.Path...)...)
+}
+
+// Magnet returns a magnet link with the torrent's info hashes, trackers and
+// web seeds
+func (t *Torrent) Magnet() *magnet.Magnet {
+	m := &magnet.Magnet{
+		BTInfoHash:   t.InfoHash,
+		BTInfoHashV2: t.InfoHashV2,
+		DisplayName:  t.Name,
+		Size:         t.TotalSize(),
+		Trackers:     t.Trackers,
+		WebSeeds:     t.WebSeeds,
+	}
+	m.InfoHash = cmp.Or(t.InfoHash, t.InfoHashV2)
+	return m
+}
+
+// Marshal encodes the torrent. The info dictionary is written exactly as it
+// was parsed or created, so the info hashes do not change.
+func (t *Torrent) Marshal() ([]byte, error) {
+	root := map[string]any{"info": t.rawInfo}
+	if len(t.Trackers) > 0 {
+		root["announce"] = t.Trackers[0]
+		if len(t.Trackers) > 1 {
+			// One tracker per tier: clients try them in order
+			tiers := make([]any, len(t.Trackers))
+			for i, tr := range t.Trackers {
+				tiers[i] = []string{tr}
+			}
+			root["announce-list"] = tiers
+		}
+	}
+	if len(t.WebSeeds) > 0 {
+		root["url-list"] = t.WebSeeds
+	}
+	if t.Comment != "" {
+		root["comment"] = t.Comment
+	}
+	if t.CreatedBy != "" {
+		root["created by"] = t.CreatedBy
+	}
+	if !t.CreationDate.IsZero() {
+		root["creation date"] = t.CreationDate.Unix()
+	}
+	if len(t.pieceLayers) > 0 {
+		root["piece layers"] = t.pieceLayers
+	}
+	return bencode.Encode(root)
+}
+
+// Save writes the torrent to path
+func (t *Torrent) Save(path string) error {
+	data, err := t.Marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,207 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/bencode"
+	"github.com/p2p-filesharing/distributed-system/pkg/magnet"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+func TestCreate_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	writeFile(t, path, testData(5*BlockSize+100))
+
+	created, err := Create(path, Options{PieceLength: 2 * BlockSize})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	created.Trackers = []string{"http://a.example.com:8080", "http://b.example.com:8080"}
+	created.WebSeeds = []string{"https://mirror.example.com/data.bin"}
+	created.Comment = "test"
+	data, err := created.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if parsed.InfoHash != created.InfoHash || parsed.InfoHashV2 != created.InfoHashV2 {
+		t.Errorf("info hashes changed: %s/%s, want %s/%s", parsed.InfoHash, parsed.InfoHashV2, created.InfoHash, created.InfoHashV2)
+	}
+	if !parsed.SingleFile() || parsed.TotalSize() != 5*BlockSize+100 || len(parsed.Files) != 1 {
+		t.Errorf("parsed files = %+v", parsed.Files)
+	}
+	if !bytes.Equal(parsed.Files[0].PiecesRoot, created.Files[0].PiecesRoot) {
+		t.Error("pieces root changed")
+	}
+	if len(parsed.Trackers) != 2 || parsed.Comment != "test" || len(parsed.WebSeeds) != 1 {
+		t.Errorf("parsed = %+v", parsed)
+	}
+	if len(parsed.pieceLayers) != 1 {
+		t.Errorf("piece layers = %d, want 1", len(parsed.pieceLayers))
+	}
+
+	if err := parsed.Verify(dir); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	corrupt := testData(5*BlockSize + 100)
+	corrupt[3*BlockSize] ^= 1
+	writeFile(t, path, corrupt)
+	if err := parsed.Verify(dir); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify of corrupt data = %v, want ErrMismatch", err)
+	}
+
+	// The v1 half of the hybrid torrent catches the change too
+	parsed.InfoHashV2 = ""
+	if err := parsed.Verify(dir); !errors.Is(err, ErrMismatch) {
+		t.Errorf("v1 Verify of corrupt data = %v, want ErrMismatch", err)
+	}
+}
+
+func TestMerkleV2_PaddingIsConsistent(t *testing.T) {
+	// The root of a file larger than a piece must equal the root over its
+	// zero-padded leaves, whatever the piece length
+	data := testData(7*BlockSize + 5)
+	var leaves [][32]byte
+	for start := 0; start < len(data); start += BlockSize {
+		leaves = append(leaves, sha256.Sum256(data[start:min(start+BlockSize, len(data))]))
+	}
+	want, _ := merkleV2(leaves, int64(len(data)), 16*BlockSize)
+	for _, pieceLength := range []int64{BlockSize, 2 * BlockSize, 4 * BlockSize} {
+		root, layer := merkleV2(leaves, int64(len(data)), pieceLength)
+		if !bytes.Equal(root, want) {
+			t.Errorf("piece length %d: root differs", pieceLength)
+		}
+		if pieces := (int64(len(data)) + pieceLength - 1) / pieceLength; int64(len(layer)) != pieces*32 {
+			t.Errorf("piece length %d: layer has %d bytes, want %d", pieceLength, len(layer), pieces*32)
+		}
+	}
+}
+
+// v1MultiFile builds a v1 torrent for files under dir/name, with a pad file
+// after the first one
+func v1MultiFile(t *testing.T, dir string, pieceLength int) []byte {
+	a, b := testData(pieceLength+10), testData(3*pieceLength/2)
+	writeFile(t, filepath.Join(dir, "set", "a.bin"), a)
+	writeFile(t, filepath.Join(dir, "set", "sub", "b.bin"), b)
+	padding := pieceLength - 10
+
+	stream := append(append(bytes.Clone(a), make([]byte, padding)...), b...)
+	var pieces []byte
+	for start := 0; start < len(stream); start += pieceLength {
+		sum := sha1.Sum(stream[start:min(start+pieceLength, len(stream))])
+		pieces = append(pieces, sum[:]...)
+	}
+	data, err := bencode.Encode(map[string]any{
+		"announce": "http://tracker.example.com:8080",
+		"info": map[string]any{
+			"name":         "set",
+			"piece length": pieceLength,
+			"pieces":       pieces,
+			"files": []any{
+				map[string]any{"length": len(a), "path": []string{"a.bin"}},
+				map[string]any{"length": padding, "path": []string{".pad", "16374"}, "attr": "p"},
+				map[string]any{"length": len(b), "path": []string{"sub", "b.bin"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParse_V1MultiFile(t *testing.T) {
+	dir := t.TempDir()
+	tor, err := Parse(v1MultiFile(t, dir, BlockSize))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if tor.SingleFile() || tor.InfoHash == "" || tor.InfoHashV2 != "" || len(tor.Files) != 3 {
+		t.Fatalf("parsed = %+v", tor)
+	}
+	if got := tor.FilePath(dir, &tor.Files[2]); got != filepath.Join(dir, "set", "sub", "b.bin") {
+		t.Errorf("FilePath = %s", got)
+	}
+	if tor.TotalSize() != BlockSize+10+3*BlockSize/2 {
+		t.Errorf("TotalSize = %d", tor.TotalSize())
+	}
+	if err := tor.Verify(dir); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "set", "sub", "b.bin"), testData(3*BlockSize/2-1), 0644)
+	if err := tor.Verify(dir); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify of a truncated file = %v, want ErrMismatch", err)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	encode := func(info map[string]any) []byte {
+		data, _ := bencode.Encode(map[string]any{"info": info})
+		return data
+	}
+	pieces := strings.Repeat("x", 20)
+	cases := map[string][]byte{
+		"not bencode":    []byte("hello"),
+		"no info":        []byte("d8:announce3:urle"),
+		"no pieces":      encode(map[string]any{"name": "a", "piece length": BlockSize, "length": 1}),
+		"piece count":    encode(map[string]any{"name": "a", "piece length": BlockSize, "length": BlockSize + 1, "pieces": pieces}),
+		"traversal name": encode(map[string]any{"name": "..", "piece length": BlockSize, "length": 1, "pieces": pieces}),
+		"traversal path": encode(map[string]any{"name": "a", "piece length": BlockSize, "pieces": pieces,
+			"files": []any{map[string]any{"length": 1, "path": []string{"..", "etc"}}}}),
+		"v2 piece length": encode(map[string]any{"name": "a", "piece length": 1000, "meta version": 2,
+			"file tree": map[string]any{"a": map[string]any{"": map[string]any{"length": 0}}}}),
+	}
+	for name, data := range cases {
+		if _, err := Parse(data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: Parse = %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestMagnet(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "file.txt"), []byte("hello"))
+	tor, err := Create(filepath.Join(dir, "file.txt"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor.Trackers = []string{"http://tracker.example.com:8080"}
+
+	m, err := magnet.Parse(tor.Magnet().String())
+	if err != nil {
+		t.Fatalf("magnet.Parse failed: %v", err)
+	}
+	if m.BTInfoHash != tor.InfoHash || m.BTInfoHashV2 != tor.InfoHashV2 || m.DisplayName != "file.txt" || m.Size != 5 {
+		t.Errorf("magnet = %+v", m)
+	}
+}
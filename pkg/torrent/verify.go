@@ -0,0 +1,163 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Verify checks that the content in dir (see FilePath) matches the torrent.
+// v2 and hybrid torrents are checked file by file against their Merkle roots,
+// v1 torrents piece by piece. The error wraps ErrMismatch when the data differs.
+func (t *Torrent) Verify(dir string) error {
+	for i := range t.Files {
+		f := &t.Files[i]
+		if f.Padding {
+			continue
+		}
+		info, err := os.Stat(t.FilePath(dir, f))
+		if err != nil {
+			return err
+		}
+		if info.Size() != f.Length {
+			return fmt.Errorf("%w: %s is %d bytes, want %d", ErrMismatch, strings.Join(f.Path, "/"), info.Size(), f.Length)
+		}
+	}
+
+	if t.InfoHashV2 != "" {
+		for i := range t.Files {
+			f := &t.Files[i]
+			if f.Padding || f.Length == 0 {
+				continue
+			}
+			root, _, err := hashFileV2(t.FilePath(dir, f), t.PieceLength)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(root, f.PiecesRoot) {
+				return fmt.Errorf("%w: %s", ErrMismatch, strings.Join(f.Path, "/"))
+			}
+		}
+		return nil
+	}
+	return t.verifyV1(dir)
+}
+
+// verifyV1 hashes the files as one stream split into pieces, pad files being
+// read as zeros
+func (t *Torrent) verifyV1(dir string) error {
+	var readers []io.Reader
+	for i := range t.Files {
+		f := &t.Files[i]
+		if f.Padding {
+			readers = append(readers, io.LimitReader(zeros{}, f.Length))
+			continue
+		}
+		file, err := os.Open(t.FilePath(dir, f))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, io.LimitReader(file, f.Length))
+	}
+
+	stream := io.MultiReader(readers...)
+	buf := make([]byte, t.PieceLength)
+	for piece := 0; piece*sha1.Size < len(t.Pieces); piece++ {
+		n, err := io.ReadFull(stream, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		sum := sha1.Sum(buf[:n])
+		if !bytes.Equal(sum[:], t.Pieces[piece*sha1.Size:(piece+1)*sha1.Size]) {
+			return fmt.Errorf("%w: piece %d", ErrMismatch, piece)
+		}
+	}
+	return nil
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// hashFileV2 returns the BEP 52 pieces root of a file and its piece layer
+func hashFileV2(path string, pieceLength int64) (root, layer []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var leaves [][sha256.Size]byte
+	var size int64
+	buf := make([]byte, BlockSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			leaves = append(leaves, sha256.Sum256(buf[:n]))
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	root, layer = merkleV2(leaves, size, pieceLength)
+	return root, layer, nil
+}
+
+// merkleV2 builds the Merkle tree of a file from the hashes of its 16 KiB
+// blocks. Files larger than a piece also get a piece layer: the concatenated
+// roots of the subtrees covering each piece.
+func merkleV2(leaves [][sha256.Size]byte, size, pieceLength int64) (root, layer []byte) {
+	if len(leaves) == 0 {
+		return nil, nil
+	}
+	var zero [sha256.Size]byte
+	if size <= pieceLength {
+		r := merkleRoot(leaves, 1, zero)
+		return r[:], nil
+	}
+
+	perPiece := int(pieceLength / BlockSize)
+	var pieces [][sha256.Size]byte
+	for start := 0; start < len(leaves); start += perPiece {
+		end := min(start+perPiece, len(leaves))
+		pieces = append(pieces, merkleRoot(leaves[start:end], perPiece, zero))
+		layer = append(layer, pieces[len(pieces)-1][:]...)
+	}
+	// Piece hashes past the end of the file are roots of all-zero subtrees
+	padding := merkleRoot(nil, perPiece, zero)
+	r := merkleRoot(pieces, 1, padding)
+	return r[:], layer
+}
+
+// merkleRoot pads hashes with padding to a power of two, at least width, and
+// returns the root of the binary tree over them
+func merkleRoot(hashes [][sha256.Size]byte, width int, padding [sha256.Size]byte) [sha256.Size]byte {
+	for width < len(hashes) {
+		width *= 2
+	}
+	level := make([][sha256.Size]byte, width)
+	copy(level, hashes)
+	for i := len(hashes); i < width; i++ {
+		level[i] = padding
+	}
+	for len(level) > 1 {
+		next := level[:len(level)/2]
+		for i := range next {
+			next[i] = sha256.Sum256(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = next
+	}
+	return level[0]
+}
@@ -0,0 +1,1101 @@
+// Code generated by openapi-gen from the P2P tracker API 1.3.0. DO NOT EDIT.
+
+package trackerapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// Doer sends the requests of a Client: body, unless nil, is sent as JSON
+// and the JSON response decoded into result, unless nil. path is relative to
+// the server and carries the query.
+type Doer interface {
+	Do(ctx context.Context, method, path string, body, result any) error
+}
+
+// Client calls the operations of the P2P tracker API
+type Client struct {
+	doer Doer
+}
+
+// NewClient returns a client sending its requests with doer
+func NewClient(doer Doer) *Client {
+	return &Client{doer: doer}
+}
+
+// AdminDeleteFile calls DELETE /admin/files/{hash}.
+// Removes a file.
+func (c *Client) AdminDeleteFile(ctx context.Context, hash string) (*AdminResult, error) {
+	path := "/admin/files/" + url.PathEscape(hash)
+	result := new(AdminResult)
+	if err := c.doer.Do(ctx, http.MethodDelete, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminUpdateFileLabels calls PATCH /admin/files/{hash}.
+// Edits the category and tags of any file.
+func (c *Client) AdminUpdateFileLabels(ctx context.Context, hash string, body *protocol.FileLabelsRequest) (*protocol.FileLabelsResponse, error) {
+	path := "/admin/files/" + url.PathEscape(hash)
+	result := new(protocol.FileLabelsResponse)
+	if err := c.doer.Do(ctx, http.MethodPatch, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminSetFileAccess calls PUT /admin/files/{hash}/access.
+// Sets the visibility and groups of any file.
+func (c *Client) AdminSetFileAccess(ctx context.Context, hash string, body *protocol.FileAccessRequest) (*protocol.FileAccessResponse, error) {
+	path := "/admin/files/" + url.PathEscape(hash) + "/access"
+	result := new(protocol.FileAccessResponse)
+	if err := c.doer.Do(ctx, http.MethodPut, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminListGroups calls GET /admin/groups.
+// Lists the groups, by name. Only /api/v2 pages them.
+func (c *Client) AdminListGroups(ctx context.Context, params *AdminListGroupsParams) (*GroupList, error) {
+	path := "/admin/groups"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(GroupList)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminDeleteGroup calls DELETE /admin/groups/{group}.
+// Removes a group.
+func (c *Client) AdminDeleteGroup(ctx context.Context, group string) (*AdminResult, error) {
+	path := "/admin/groups/" + url.PathEscape(group)
+	result := new(AdminResult)
+	if err := c.doer.Do(ctx, http.MethodDelete, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminSetGroup calls PUT /admin/groups/{group}.
+// Creates a group, or replaces its members.
+func (c *Client) AdminSetGroup(ctx context.Context, group string, body *GroupRequest) (*Group, error) {
+	path := "/admin/groups/" + url.PathEscape(group)
+	result := new(Group)
+	if err := c.doer.Do(ctx, http.MethodPut, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminListPeers calls GET /admin/peers.
+// Lists the registered peers, by ID. Only /api/v2 pages them.
+func (c *Client) AdminListPeers(ctx context.Context, params *AdminListPeersParams) (*AdminPeerList, error) {
+	path := "/admin/peers"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(AdminPeerList)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminKickPeer calls DELETE /admin/peers/{peer_id}.
+// Removes a peer and its files.
+func (c *Client) AdminKickPeer(ctx context.Context, peerID string) (*AdminResult, error) {
+	path := "/admin/peers/" + url.PathEscape(peerID)
+	result := new(AdminResult)
+	if err := c.doer.Do(ctx, http.MethodDelete, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Login calls POST /auth/login.
+// Exchanges an API key for a JWT.
+func (c *Client) Login(ctx context.Context, body *LoginRequest) (*AuthResponse, error) {
+	path := "/auth/login"
+	result := new(AuthResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListCategories calls GET /categories.
+// Lists the categories with their number of files.
+func (c *Client) ListCategories(ctx context.Context) (*CategoryList, error) {
+	path := "/categories"
+	result := new(CategoryList)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListFilesByCategory calls GET /categories/{category}/files.
+// Lists the public files of a category, with the parameters of listFiles.
+func (c *Client) ListFilesByCategory(ctx context.Context, category string, params *ListFilesByCategoryParams) (*protocol.ListFilesResponse, error) {
+	path := "/categories/" + url.PathEscape(category) + "/files"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(protocol.ListFilesResponse)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListFiles calls GET /files.
+// Lists the public files, filtered, sorted and paged.
+func (c *Client) ListFiles(ctx context.Context, params *ListFilesParams) (*protocol.ListFilesResponse, error) {
+	path := "/files"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(protocol.ListFilesResponse)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AnnounceFile calls POST /files/announce.
+// Announces a file the peer shares.
+func (c *Client) AnnounceFile(ctx context.Context, body *protocol.AnnounceRequest) (*protocol.AnnounceResponse, error) {
+	path := "/files/announce"
+	result := new(protocol.AnnounceResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReportAvailability calls POST /files/availability.
+// Reports the chunks and transfer of a file being downloaded, with an announce
+// event.
+func (c *Client) ReportAvailability(ctx context.Context, body *protocol.AvailabilityRequest) (*protocol.AvailabilityResponse, error) {
+	path := "/files/availability"
+	result := new(protocol.AvailabilityResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SearchFiles calls GET /files/search.
+// Searches the public files, ranked by relevance. q is required on the first
+// page.
+func (c *Client) SearchFiles(ctx context.Context, params *SearchFilesParams) (*protocol.ListFilesResponse, error) {
+	path := "/files/search"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(protocol.ListFilesResponse)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UpdateFileLabels calls PATCH /files/{hash}.
+// Edits the category and tags of a file, by its owner or, without one, the
+// peer that announced it, with its session token.
+func (c *Client) UpdateFileLabels(ctx context.Context, hash string, body *protocol.FileLabelsRequest) (*protocol.FileLabelsResponse, error) {
+	path := "/files/" + url.PathEscape(hash)
+	result := new(protocol.FileLabelsResponse)
+	if err := c.doer.Do(ctx, http.MethodPatch, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SetFileAccess calls PUT /files/{hash}/access.
+// Sets the visibility and groups of a file, by its owner.
+func (c *Client) SetFileAccess(ctx context.Context, hash string, body *protocol.FileAccessRequest) (*protocol.FileAccessResponse, error) {
+	path := "/files/" + url.PathEscape(hash) + "/access"
+	result := new(protocol.FileAccessResponse)
+	if err := c.doer.Do(ctx, http.MethodPut, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetMagnetLink calls GET /files/{hash}/magnet.
+// Returns the magnet link of a file.
+func (c *Client) GetMagnetLink(ctx context.Context, hash string, params *GetMagnetLinkParams) (*MagnetLink, error) {
+	path := "/files/" + url.PathEscape(hash) + "/magnet"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(MagnetLink)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetFilePeers calls GET /files/{hash}/peers.
+// Returns the metadata of a file and the peers to download it from, picked by
+// the peer policy.
+func (c *Client) GetFilePeers(ctx context.Context, hash string, params *GetFilePeersParams) (*protocol.GetPeersResponse, error) {
+	path := "/files/" + url.PathEscape(hash) + "/peers"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(protocol.GetPeersResponse)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WithdrawFile calls DELETE /files/{hash}/peers/{peer_id}.
+// Stops sharing a file, with the session token of the peer.
+func (c *Client) WithdrawFile(ctx context.Context, hash string, peerID string) (*Success, error) {
+	path := "/files/" + url.PathEscape(hash) + "/peers/" + url.PathEscape(peerID)
+	result := new(Success)
+	if err := c.doer.Do(ctx, http.MethodDelete, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RevokeShares calls DELETE /files/{hash}/shares.
+// Revokes every share token of a file issued so far, by its owner.
+func (c *Client) RevokeShares(ctx context.Context, hash string, params *RevokeSharesParams) (*RevokeSharesResponse, error) {
+	path := "/files/" + url.PathEscape(hash) + "/shares"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(RevokeSharesResponse)
+	if err := c.doer.Do(ctx, http.MethodDelete, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// CreateShare calls POST /files/{hash}/shares.
+// Issues a share token of a private file, by its owner.
+func (c *Client) CreateShare(ctx context.Context, hash string, body *protocol.ShareRequest) (*protocol.ShareResponse, error) {
+	path := "/files/" + url.PathEscape(hash) + "/shares"
+	result := new(protocol.ShareResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ParseMagnetLink calls GET /magnet.
+// Parses a magnet link, telling whether the tracker knows its file.
+func (c *Client) ParseMagnetLink(ctx context.Context, params *ParseMagnetLinkParams) (*MagnetInfo, error) {
+	path := "/magnet"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(MagnetInfo)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Heartbeat calls POST /peers/heartbeat.
+// Keeps a peer online and reports the transfers of its files, with the session
+// token of the peer.
+func (c *Client) Heartbeat(ctx context.Context, body *protocol.HeartbeatRequest) (*protocol.HeartbeatResponse, error) {
+	path := "/peers/heartbeat"
+	result := new(protocol.HeartbeatResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RegisterPeer calls POST /peers/register.
+// Registers a peer, or updates its address.
+func (c *Client) RegisterPeer(ctx context.Context, body *protocol.RegisterRequest) (*protocol.RegisterResponse, error) {
+	path := "/peers/register"
+	result := new(protocol.RegisterResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReportStats calls POST /peers/stats.
+// Reports the bytes a peer uploaded and downloaded.
+func (c *Client) ReportStats(ctx context.Context, body *StatsRequest) (*Success, error) {
+	path := "/peers/stats"
+	result := new(Success)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetTopPeers calls GET /peers/top.
+// Returns the peers of best reputation.
+func (c *Client) GetTopPeers(ctx context.Context, params *GetTopPeersParams) (*TopPeerList, error) {
+	path := "/peers/top"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(TopPeerList)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// LeavePeer calls DELETE /peers/{peer_id}.
+// Unregisters a peer leaving the network.
+func (c *Client) LeavePeer(ctx context.Context, peerID string) (*Success, error) {
+	path := "/peers/" + url.PathEscape(peerID)
+	result := new(Success)
+	if err := c.doer.Do(ctx, http.MethodDelete, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListRelayPeers calls GET /relay/peers.
+// Lists the peers connected to the relay.
+func (c *Client) ListRelayPeers(ctx context.Context) (*RelayPeers, error) {
+	path := "/relay/peers"
+	result := new(RelayPeers)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Scrape calls GET /scrape.
+// Returns the swarm statistics of files.
+func (c *Client) Scrape(ctx context.Context, params *ScrapeParams) (*protocol.ScrapeResponse, error) {
+	path := "/scrape"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(protocol.ScrapeResponse)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ScrapeFiles calls POST /scrape.
+// Returns the swarm statistics of files, for lists of hashes too long for a
+// URL.
+func (c *Client) ScrapeFiles(ctx context.Context, body *protocol.ScrapeRequest) (*protocol.ScrapeResponse, error) {
+	path := "/scrape"
+	result := new(protocol.ScrapeResponse)
+	if err := c.doer.Do(ctx, http.MethodPost, path, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListTags calls GET /tags.
+// Lists the most used tags with their number of files.
+func (c *Client) ListTags(ctx context.Context, params *ListTagsParams) (*TagList, error) {
+	path := "/tags"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(TagList)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListFilesByTag calls GET /tags/{tag}/files.
+// Lists the public files having a tag, with the parameters of listFiles.
+func (c *Client) ListFilesByTag(ctx context.Context, tag string, params *ListFilesByTagParams) (*protocol.ListFilesResponse, error) {
+	path := "/tags/" + url.PathEscape(tag) + "/files"
+	if params != nil {
+		path += params.encode()
+	}
+	result := new(protocol.ListFilesResponse)
+	if err := c.doer.Do(ctx, http.MethodGet, path, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AdminListGroupsParams holds the query parameters of AdminListGroups
+type AdminListGroupsParams struct {
+	// Items per page on /api/v2, 100 by default
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+}
+
+func (p *AdminListGroupsParams) encode() string {
+	q := url.Values{}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// AdminListPeersParams holds the query parameters of AdminListPeers
+type AdminListPeersParams struct {
+	// Items per page on /api/v2, 100 by default
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+}
+
+func (p *AdminListPeersParams) encode() string {
+	q := url.Values{}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ListFilesByCategoryParams holds the query parameters of ListFilesByCategory
+type ListFilesByCategoryParams struct {
+	// Words searched in the names, tags and categories of files
+	Q        string
+	Category string
+	// Tags the files all have, repeated or comma-separated
+	Tag        []string
+	MinSize    int
+	MaxSize    int
+	MinSeeders int
+	// An RFC 3339 time, or a duration such as 24h
+	Since string
+	// Relevance by default when searching, added otherwise
+	Sort string
+	// Ascending by default for names only
+	Order string
+	// Files per page, 100 by default
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+}
+
+func (p *ListFilesByCategoryParams) encode() string {
+	q := url.Values{}
+	if p.Q != "" {
+		q.Set("q", p.Q)
+	}
+	if p.Category != "" {
+		q.Set("category", p.Category)
+	}
+	for _, v := range p.Tag {
+		q.Add("tag", v)
+	}
+	if p.MinSize != 0 {
+		q.Set("min_size", strconv.Itoa(p.MinSize))
+	}
+	if p.MaxSize != 0 {
+		q.Set("max_size", strconv.Itoa(p.MaxSize))
+	}
+	if p.MinSeeders != 0 {
+		q.Set("min_seeders", strconv.Itoa(p.MinSeeders))
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	if p.Order != "" {
+		q.Set("order", p.Order)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ListFilesParams holds the query parameters of ListFiles
+type ListFilesParams struct {
+	// Words searched in the names, tags and categories of files
+	Q        string
+	Category string
+	// Tags the files all have, repeated or comma-separated
+	Tag        []string
+	MinSize    int
+	MaxSize    int
+	MinSeeders int
+	// An RFC 3339 time, or a duration such as 24h
+	Since string
+	// Relevance by default when searching, added otherwise
+	Sort string
+	// Ascending by default for names only
+	Order string
+	// Files per page, 100 by default
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+}
+
+func (p *ListFilesParams) encode() string {
+	q := url.Values{}
+	if p.Q != "" {
+		q.Set("q", p.Q)
+	}
+	if p.Category != "" {
+		q.Set("category", p.Category)
+	}
+	for _, v := range p.Tag {
+		q.Add("tag", v)
+	}
+	if p.MinSize != 0 {
+		q.Set("min_size", strconv.Itoa(p.MinSize))
+	}
+	if p.MaxSize != 0 {
+		q.Set("max_size", strconv.Itoa(p.MaxSize))
+	}
+	if p.MinSeeders != 0 {
+		q.Set("min_seeders", strconv.Itoa(p.MinSeeders))
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	if p.Order != "" {
+		q.Set("order", p.Order)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// SearchFilesParams holds the query parameters of SearchFiles
+type SearchFilesParams struct {
+	// Words searched in the names, tags and categories of files
+	Q        string
+	Category string
+	// Tags the files all have, repeated or comma-separated
+	Tag        []string
+	MinSize    int
+	MaxSize    int
+	MinSeeders int
+	// An RFC 3339 time, or a duration such as 24h
+	Since string
+	// Relevance by default when searching, added otherwise
+	Sort string
+	// Ascending by default for names only
+	Order string
+	// Files per page, 100 by default
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+}
+
+func (p *SearchFilesParams) encode() string {
+	q := url.Values{}
+	if p.Q != "" {
+		q.Set("q", p.Q)
+	}
+	if p.Category != "" {
+		q.Set("category", p.Category)
+	}
+	for _, v := range p.Tag {
+		q.Add("tag", v)
+	}
+	if p.MinSize != 0 {
+		q.Set("min_size", strconv.Itoa(p.MinSize))
+	}
+	if p.MaxSize != 0 {
+		q.Set("max_size", strconv.Itoa(p.MaxSize))
+	}
+	if p.MinSeeders != 0 {
+		q.Set("min_seeders", strconv.Itoa(p.MinSeeders))
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	if p.Order != "" {
+		q.Set("order", p.Order)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// GetMagnetLinkParams holds the query parameters of GetMagnetLink
+type GetMagnetLinkParams struct {
+	// Peer making the request, identified by its X-Peer-Token
+	PeerID string
+	// Share token of a private file, also read from X-Share-Token
+	Share string
+}
+
+func (p *GetMagnetLinkParams) encode() string {
+	q := url.Values{}
+	if p.PeerID != "" {
+		q.Set("peer_id", p.PeerID)
+	}
+	if p.Share != "" {
+		q.Set("share", p.Share)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// GetFilePeersParams holds the query parameters of GetFilePeers
+type GetFilePeersParams struct {
+	// Peer making the request, identified by its X-Peer-Token
+	PeerID string
+	// Number of peers wanted, the policy's default when not set
+	Numwant int
+	// Whose availability is sent, all by default on /api and leechers on /api/v2
+	Chunks string
+	// Share token of a private file, also read from X-Share-Token
+	Share string
+}
+
+func (p *GetFilePeersParams) encode() string {
+	q := url.Values{}
+	if p.PeerID != "" {
+		q.Set("peer_id", p.PeerID)
+	}
+	if p.Numwant != 0 {
+		q.Set("numwant", strconv.Itoa(p.Numwant))
+	}
+	if p.Chunks != "" {
+		q.Set("chunks", p.Chunks)
+	}
+	if p.Share != "" {
+		q.Set("share", p.Share)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// RevokeSharesParams holds the query parameters of RevokeShares
+type RevokeSharesParams struct {
+	// Peer making the request, identified by its X-Peer-Token
+	PeerID string
+}
+
+func (p *RevokeSharesParams) encode() string {
+	q := url.Values{}
+	if p.PeerID != "" {
+		q.Set("peer_id", p.PeerID)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ParseMagnetLinkParams holds the query parameters of ParseMagnetLink
+type ParseMagnetLinkParams struct {
+	URI string
+	// Peer making the request, identified by its X-Peer-Token
+	PeerID string
+	// Share token of a private file, also read from X-Share-Token
+	Share string
+}
+
+func (p *ParseMagnetLinkParams) encode() string {
+	q := url.Values{}
+	if p.URI != "" {
+		q.Set("uri", p.URI)
+	}
+	if p.PeerID != "" {
+		q.Set("peer_id", p.PeerID)
+	}
+	if p.Share != "" {
+		q.Set("share", p.Share)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// GetTopPeersParams holds the query parameters of GetTopPeers
+type GetTopPeersParams struct {
+	// Number of peers, 10 by default and at most 100
+	Limit int
+}
+
+func (p *GetTopPeersParams) encode() string {
+	q := url.Values{}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ScrapeParams holds the query parameters of Scrape
+type ScrapeParams struct {
+	// Hashes or BitTorrent info hashes, repeated or comma-separated
+	Hash []string
+	// Peer making the request, identified by its X-Peer-Token
+	PeerID string
+}
+
+func (p *ScrapeParams) encode() string {
+	q := url.Values{}
+	for _, v := range p.Hash {
+		q.Add("hash", v)
+	}
+	if p.PeerID != "" {
+		q.Set("peer_id", p.PeerID)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ListTagsParams holds the query parameters of ListTags
+type ListTagsParams struct {
+	// Number of tags, 100 by default
+	Limit int
+}
+
+func (p *ListTagsParams) encode() string {
+	q := url.Values{}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// ListFilesByTagParams holds the query parameters of ListFilesByTag
+type ListFilesByTagParams struct {
+	// Words searched in the names, tags and categories of files
+	Q        string
+	Category string
+	// Tags the files all have, repeated or comma-separated
+	Tag        []string
+	MinSize    int
+	MaxSize    int
+	MinSeeders int
+	// An RFC 3339 time, or a duration such as 24h
+	Since string
+	// Relevance by default when searching, added otherwise
+	Sort string
+	// Ascending by default for names only
+	Order string
+	// Files per page, 100 by default
+	Limit int
+	// next_cursor of the previous page
+	Cursor string
+}
+
+func (p *ListFilesByTagParams) encode() string {
+	q := url.Values{}
+	if p.Q != "" {
+		q.Set("q", p.Q)
+	}
+	if p.Category != "" {
+		q.Set("category", p.Category)
+	}
+	for _, v := range p.Tag {
+		q.Add("tag", v)
+	}
+	if p.MinSize != 0 {
+		q.Set("min_size", strconv.Itoa(p.MinSize))
+	}
+	if p.MaxSize != 0 {
+		q.Set("max_size", strconv.Itoa(p.MaxSize))
+	}
+	if p.MinSeeders != 0 {
+		q.Set("min_seeders", strconv.Itoa(p.MinSeeders))
+	}
+	if p.Since != "" {
+		q.Set("since", p.Since)
+	}
+	if p.Sort != "" {
+		q.Set("sort", p.Sort)
+	}
+	if p.Order != "" {
+		q.Set("order", p.Order)
+	}
+	if p.Limit != 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// AdminPeer is generated from the schema AdminPeer.
+type AdminPeer struct {
+	Hostname     string    `json:"hostname"`
+	ID           string    `json:"id"`
+	IP           string    `json:"ip"`
+	IsOnline     bool      `json:"is_online"`
+	LastSeen     time.Time `json:"last_seen"`
+	Port         int       `json:"port"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// AdminPeerList is generated from the schema AdminPeerList.
+type AdminPeerList struct {
+	Count      int         `json:"count"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Peers      []AdminPeer `json:"peers"`
+}
+
+// AdminResult is generated from the schema AdminResult.
+type AdminResult struct {
+	Group   string `json:"group,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+	Message string `json:"message"`
+	PeerID  string `json:"peer_id,omitempty"`
+}
+
+// AuthResponse is generated from the schema AuthResponse.
+type AuthResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	PeerID    string    `json:"peer_id"`
+	Role      string    `json:"role"`
+	Token     string    `json:"token"`
+}
+
+// CategoryList is generated from the schema CategoryList.
+type CategoryList struct {
+	Categories []CategoryStats `json:"categories"`
+	Count      int             `json:"count"`
+}
+
+// CategoryStats is generated from the schema CategoryStats.
+type CategoryStats struct {
+	Category  string `json:"category"`
+	FileCount int    `json:"file_count"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// Error is generated from the schema Error.
+// An error. On /api, error is the message; on /api/v2, error is a code such as
+// not_found and message the text.
+type Error struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// File is generated from the schema File.
+// A file as the tracker stores it.
+type File struct {
+	AddedAt      time.Time            `json:"added_at"`
+	AddedBy      string               `json:"added_by"`
+	BTInfoHash   string               `json:"bt_info_hash,omitempty"`
+	BTInfoHashV2 string               `json:"bt_info_hash_v2,omitempty"`
+	Category     string               `json:"category,omitempty"`
+	ChunkSize    int64                `json:"chunk_size"`
+	Chunks       []protocol.ChunkInfo `json:"chunks"`
+	Groups       []string             `json:"groups,omitempty"`
+	Hash         string               `json:"hash"`
+	ID           string               `json:"id"`
+	Name         string               `json:"name"`
+	OwnerID      string               `json:"owner_id,omitempty"`
+	ShareEpoch   int                  `json:"share_epoch,omitempty"`
+	Size         int64                `json:"size"`
+	Tags         []string             `json:"tags,omitempty"`
+	Visibility   Visibility           `json:"visibility,omitempty"`
+}
+
+// Group is generated from the schema Group.
+type Group struct {
+	// Owner IDs of the members
+	Members   []string  `json:"members"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GroupList is generated from the schema GroupList.
+type GroupList struct {
+	Count      int     `json:"count"`
+	Groups     []Group `json:"groups"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// GroupRequest is generated from the schema GroupRequest.
+type GroupRequest struct {
+	Members []string `json:"members,omitempty"`
+}
+
+// HealthResponseDatabase is generated from an inline schema.
+type HealthResponseDatabase struct {
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency,omitempty"`
+	Status  string `json:"status"`
+	Type    string `json:"type"`
+}
+
+// HealthResponseMemory is generated from an inline schema.
+type HealthResponseMemory struct {
+	AllocMB      float64 `json:"alloc_mb,omitempty"`
+	Goroutines   int     `json:"goroutines,omitempty"`
+	NumGC        int64   `json:"num_gc,omitempty"`
+	SysMB        float64 `json:"sys_mb,omitempty"`
+	TotalAllocMB float64 `json:"total_alloc_mb,omitempty"`
+}
+
+// HealthResponseStats is generated from an inline schema.
+type HealthResponseStats struct {
+	FilesCount  int `json:"files_count,omitempty"`
+	PeersOnline int `json:"peers_online,omitempty"`
+}
+
+// HealthResponse is generated from the schema HealthResponse.
+type HealthResponse struct {
+	Database  HealthResponseDatabase `json:"database"`
+	Memory    HealthResponseMemory   `json:"memory"`
+	Stats     HealthResponseStats    `json:"stats"`
+	Status    string                 `json:"status"`
+	Timestamp time.Time              `json:"timestamp"`
+	Uptime    string                 `json:"uptime"`
+	Version   string                 `json:"version"`
+}
+
+// LoginRequest is generated from the schema LoginRequest.
+type LoginRequest struct {
+	APIKey   string `json:"api_key"`
+	Hostname string `json:"hostname,omitempty"`
+	PeerID   string `json:"peer_id,omitempty"`
+}
+
+// MagnetInfo is generated from the schema MagnetInfo.
+type MagnetInfo struct {
+	DisplayName string   `json:"display_name"`
+	Exists      bool     `json:"exists"`
+	File        *File    `json:"file,omitempty"`
+	InfoHash    string   `json:"info_hash"`
+	SeederCount int      `json:"seeder_count,omitempty"`
+	Size        int64    `json:"size"`
+	Trackers    []string `json:"trackers"`
+}
+
+// MagnetLink is generated from the schema MagnetLink.
+type MagnetLink struct {
+	File        File   `json:"file"`
+	Magnet      string `json:"magnet"`
+	SeederCount int    `json:"seeder_count"`
+}
+
+// RelayPeers is generated from the schema RelayPeers.
+type RelayPeers struct {
+	Count int      `json:"count"`
+	Peers []string `json:"peers"`
+}
+
+// RevokeSharesResponse is generated from the schema RevokeSharesResponse.
+type RevokeSharesResponse struct {
+	Hash    string `json:"hash"`
+	Success bool   `json:"success"`
+}
+
+// StatsRequest is generated from the schema StatsRequest.
+// Bytes the peer uploaded and downloaded, in total.
+type StatsRequest struct {
+	BytesDownloaded int64  `json:"bytes_downloaded,omitempty"`
+	BytesUploaded   int64  `json:"bytes_uploaded,omitempty"`
+	PeerID          string `json:"peer_id"`
+}
+
+// Success is generated from the schema Success.
+type Success struct {
+	Success bool `json:"success"`
+}
+
+// TagList is generated from the schema TagList.
+type TagList struct {
+	Count int        `json:"count"`
+	Tags  []TagStats `json:"tags"`
+}
+
+// TagStats is generated from the schema TagStats.
+type TagStats struct {
+	FileCount int    `json:"file_count"`
+	Tag       string `json:"tag"`
+}
+
+// TopPeer is generated from the schema TopPeer.
+type TopPeer struct {
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+	BytesUploaded   int64  `json:"bytes_uploaded"`
+	FilesShared     int    `json:"files_shared"`
+	Hostname        string `json:"hostname"`
+	ID              string `json:"id"`
+	// Bytes uploaded per byte downloaded
+	Ratio float64 `json:"ratio"`
+	// Score from 0 to 100
+	Reputation float64 `json:"reputation"`
+}
+
+// TopPeerList is generated from the schema TopPeerList.
+type TopPeerList struct {
+	Count int       `json:"count"`
+	Peers []TopPeer `json:"peers"`
+}
+
+// Visibility is generated from the schema Visibility.
+type Visibility string
@@ -0,0 +1,91 @@
+// Package trackerapi is the Go client of the tracker API, generated from its
+// OpenAPI document. Client has a method per operation; a Doer sends its
+// requests, to either /api or /api/v2 of a tracker.
+package trackerapi
+
+//go:generate go run ../../services/tracker/cmd/openapi-gen -spec ../../services/tracker/internal/api/openapi.yaml -package trackerapi -out client.gen.go
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StatusError is an error response of the tracker
+type StatusError struct {
+	StatusCode int
+	Code       string // Of /api/v2 errors, such as not_found
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("tracker returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("tracker returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// ReadError reads an error response: {"error": message} from /api,
+// {"error": code, "message": text} from /api/v2, or plain text
+func ReadError(resp *http.Response) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body Error
+	if json.Unmarshal(data, &body) != nil {
+		e.Message = strings.TrimSpace(string(data))
+		return e
+	}
+	if body.Message != "" {
+		e.Code = body.Error
+	}
+	e.Message = cmp.Or(body.Message, body.Error)
+	return e
+}
+
+// HTTPDoer sends the requests of a Client over HTTP
+type HTTPDoer struct {
+	BaseURL string       // Such as http://tracker:8080/api/v2
+	Client  *http.Client // http.DefaultClient if nil
+	Header  http.Header  // Sent with every request, such as X-API-Key
+}
+
+// Do sends a request, returning a *StatusError for error statuses
+func (d *HTTPDoer) Do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(d.BaseURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range d.Header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := cmp.Or(d.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return ReadError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,103 @@
+package trackerapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/openapi"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// TestGenerated checks that client.gen.go is up to date with the OpenAPI
+// document of the tracker; run go generate ./pkg/trackerapi if not
+func TestGenerated(t *testing.T) {
+	doc, err := openapi.Load("../../services/tracker/internal/api/openapi.yaml")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want, err := openapi.Generate(doc, "trackerapi")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	got, err := os.ReadFile("client.gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("client.gen.go is out of date, run go generate ./pkg/trackerapi")
+	}
+}
+
+func TestHTTPDoer(t *testing.T) {
+	var gotPath, gotQuery, gotKey string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery, gotKey = r.URL.Path, r.URL.RawQuery, r.Header.Get("X-API-Key")
+		switch r.URL.Path {
+		case "/api/v2/peers/register":
+			var req protocol.RegisterRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(protocol.RegisterResponse{Success: true, Message: req.PeerID})
+		case "/api/v2/files":
+			json.NewEncoder(w).Encode(protocol.ListFilesResponse{Count: 0})
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not_found", "message": "Peer not found"}`))
+		}
+	}))
+	defer ts.Close()
+
+	header := http.Header{"X-Api-Key": {"secret"}}
+	c := NewClient(&HTTPDoer{BaseURL: ts.URL + "/api/v2/", Header: header})
+	ctx := context.Background()
+
+	resp, err := c.RegisterPeer(ctx, &protocol.RegisterRequest{PeerID: "peer-1", Port: 6881})
+	if err != nil || !resp.Success || resp.Message != "peer-1" {
+		t.Fatalf("RegisterPeer() = %+v, %v", resp, err)
+	}
+	if gotKey != "secret" {
+		t.Errorf("X-API-Key = %q, want secret", gotKey)
+	}
+
+	if _, err := c.ListFiles(ctx, &ListFilesParams{Q: "a b", Tag: []string{"x", "y"}, Limit: 5}); err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if gotQuery != "limit=5&q=a+b&tag=x&tag=y" {
+		t.Errorf("ListFiles() sent %s", gotQuery)
+	}
+
+	_, err = c.LeavePeer(ctx, "a/b")
+	var serr *StatusError
+	if !errors.As(err, &serr) || serr.StatusCode != http.StatusNotFound || serr.Code != "not_found" || serr.Message != "Peer not found" {
+		t.Errorf("LeavePeer() error = %#v", err)
+	}
+	if gotPath != "/api/v2/peers/a/b" {
+		t.Errorf("LeavePeer() sent %s", gotPath)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	tests := []struct {
+		body          string
+		code, message string
+	}{
+		{`{"error": "File not found"}`, "", "File not found"},
+		{`{"error": "not_found", "message": "File not found"}`, "not_found", "File not found"},
+		{"Rate limit exceeded\n", "", "Rate limit exceeded"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(http.StatusTeapot)
+		rec.WriteString(tt.body)
+		err := ReadError(rec.Result())
+		if err.StatusCode != http.StatusTeapot || err.Code != tt.code || err.Message != tt.message {
+			t.Errorf("ReadError(%s) = %+v", tt.body, err)
+		}
+	}
+}
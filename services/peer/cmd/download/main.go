@@ -1,131 +1,438 @@
+// p2p-download fetches files from the P2P network without running a peer.
+//
+// Downloads are resumable: their state is kept in <output>/.p2p, so running
+// the same command again after Ctrl-C or a failure continues where it
+// stopped. Completed files are verified against their hash and moved to the
+// output directory.
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
 	"github.com/p2p-filesharing/distributed-system/pkg/magnet"
+	"github.com/p2p-filesharing/distributed-system/pkg/metafile"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/torrent"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/client"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/config"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/p2p"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/relay"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
 )
 
+// Exit codes
+const (
+	exitOK          = 0
+	exitUsage       = 1   // Bad arguments or setup failure
+	exitFailed      = 2   // A download failed; running again resumes it
+	exitVerify      = 3   // A downloaded file did not match its hash
+	exitInterrupted = 130 // Stopped by a signal; running again resumes
+)
+
+// stateDir is the directory inside the output directory holding resume state
+const stateDir = ".p2p"
+
+// retryDelay is the wait before the second attempt at a file, growing linearly
+const retryDelay = 2 * time.Second
+
+var (
+	errNotFound = errors.New("file not found on the tracker")
+	errNoPeers  = errors.New("no peers available for this file")
+)
+
+// verifyError is returned when a downloaded file does not match its hash
+type verifyError struct {
+	want, got string
+}
+
+func (e *verifyError) Error() string {
+	return fmt.Sprintf("verification failed: hash is %s, want %s", e.got, e.want)
+}
+
+// target is a file to download
+type target struct {
+	hash     string
+	trackers []string           // From the magnet link or metafile
+	meta     *metafile.MetaFile // Set when downloading from a .p2pmeta file
+}
+
 func main() {
-	trackerURL := flag.String("tracker", "https://p2p.idist.dev", "Tracker server URL")
+	os.Exit(run())
+}
+
+func run() int {
+	trackerURLs := flag.String("tracker", "https://p2p.idist.dev", "Tracker server URL (comma-separated for several)")
 	fileHash := flag.String("hash", "", "File hash to download")
 	magnetURI := flag.String("magnet", "", "Magnet URI to download")
 	outputDir := flag.String("output", "./downloads", "Output directory")
-	listFiles := flag.Bool("list", false, "List available files")
+	listFiles := flag.Bool("list", false, "List available files by seeders, or those matching the arguments by relevance")
+	cursor := flag.String("cursor", "", "With --list, show the page after a previous one")
+	retries := flag.Int("retries", 3, "Attempts per file when no peer is available or the download fails")
+	useRelay := flag.Bool("relay", true, "Fall back to the tracker relay when peers are unreachable")
+	quiet := flag.Bool("quiet", false, "Do not show progress")
+	verbose := flag.Bool("v", false, "Show downloader logs")
+	publisher := flag.String("publisher", "", "Only accept .p2pmeta files signed by this public key")
+	flag.Usage = usage
 	flag.Parse()
 
-	// Handle positional argument for hash or magnet
-	if *fileHash == "" && *magnetURI == "" && flag.NArg() > 0 {
-		arg := flag.Arg(0)
-		if strings.HasPrefix(arg, "magnet:?") {
-			*magnetURI = arg
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	trackers := config.SplitList(*trackerURLs)
+	if len(trackers) == 0 {
+		fmt.Fprintln(os.Stderr, "At least one tracker is required")
+		return exitUsage
+	}
+	tracker := client.NewMultiTrackerClient(trackers, uuid.New().String(), "")
+
+	if *listFiles {
+		return list(tracker, strings.Join(flag.Args(), " "), *cursor)
+	}
+
+	// Hashes and magnet links from the flags and the arguments
+	args := flag.Args()
+	if *magnetURI != "" {
+		args = append([]string{*magnetURI}, args...)
+	}
+	if *fileHash != "" {
+		args = append([]string{*fileHash}, args...)
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		return exitUsage
+	}
+	targets, err := parseTargets(args, *publisher)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create output directory: %v\n", err)
+		return exitUsage
+	}
+	store, err := storage.NewLocalStorage(filepath.Join(*outputDir, stateDir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open download state: %v\n", err)
+		return exitUsage
+	}
+	defer store.Close()
+
+	peerID := uuid.New().String()
+	dl := downloader.New(store, p2p.NewClient(peerID))
+	if *useRelay {
+		// Relay client for NAT traversal fallback
+		relayClient := relay.NewClient(peerID, trackers[0])
+		if err := relayClient.Connect(); err != nil {
+			log.Printf("Relay connection failed: %v (will use direct TCP only)", err)
 		} else {
-			*fileHash = arg
+			dl.SetRelayClient(relayClient)
+			defer relayClient.Close()
 		}
 	}
 
-	// Parse magnet URI if provided
-	if *magnetURI != "" {
-		m, err := magnet.Parse(*magnetURI)
-		if err != nil {
-			log.Fatalf("Invalid magnet URI: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	f := &fetcher{
+		tracker:  tracker,
+		store:    store,
+		dl:       dl,
+		output:   *outputDir,
+		retries:  max(*retries, 1),
+		progress: !*quiet,
+		tty:      isTerminal(os.Stderr),
+	}
+
+	code := exitOK
+	var downloaded, present, failed int
+	for _, t := range targets {
+		path, skipped, err := f.fetch(ctx, t)
+		var verifyErr *verifyError
+		switch {
+		case err == nil && skipped:
+			present++
+			fmt.Printf("✓ Already downloaded: %s\n", path)
+		case err == nil:
+			downloaded++
+			fmt.Printf("✓ Downloaded: %s\n", path)
+		case ctx.Err() != nil:
+			store.SaveState()
+			fmt.Println("Interrupted. Run the same command again to resume.")
+			return exitInterrupted
+		case errors.As(err, &verifyErr):
+			failed++
+			code = exitVerify
+			fmt.Printf("✗ %s: %v\n", t.hash, err)
+		default:
+			failed++
+			if code == exitOK {
+				code = exitFailed
+			}
+			fmt.Printf("✗ %s: %v\n", t.hash, err)
+		}
+	}
+	store.SaveState()
+
+	if len(targets) > 1 {
+		fmt.Printf("\n%d downloaded, %d already present, %d failed\n", downloaded, present, failed)
+	}
+	return code
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: p2p-download [options] <hash|magnet|file.p2pmeta|file.torrent>...")
+	fmt.Fprintln(out, "\nOptions:")
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\nExamples:")
+	fmt.Fprintln(out, "  p2p-download --list                  # List available files")
+	fmt.Fprintln(out, "  p2p-download --list ubuntu           # List files whose name contains ubuntu")
+	fmt.Fprintln(out, "  p2p-download abc123def456            # Download file by hash")
+	fmt.Fprintln(out, "  p2p-download 'magnet:?xt=urn:sha256:abc123&dn=file.txt'")
+	fmt.Fprintln(out, "  p2p-download --output /tmp abc123 def456")
+	fmt.Fprintln(out, "  p2p-download movie.mkv.p2pmeta       # Download from a metafile")
+	fmt.Fprintln(out, "  p2p-download dataset.torrent         # Download a torrent imported by a peer")
+	fmt.Fprintln(out, "\nInterrupted and failed downloads resume when the command is run again.")
+	fmt.Fprintln(out, "\nExit codes:")
+	fmt.Fprintln(out, "  0    All files downloaded and verified")
+	fmt.Fprintln(out, "  1    Invalid arguments or setup failure")
+	fmt.Fprintln(out, "  2    A download failed (no peers, tracker unreachable...)")
+	fmt.Fprintln(out, "  3    A downloaded file did not match its hash")
+	fmt.Fprintln(out, "  130  Interrupted")
+}
+
+// parseTargets reads hashes, magnet links, metafiles and torrents. Metafiles
+// must be signed by publisher when it is set.
+func parseTargets(args []string, publisher string) ([]target, error) {
+	var targets []target
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "magnet:?"):
+			m, err := magnet.Parse(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid magnet URI %q: %w", arg, err)
+			}
+			targets = append(targets, target{hash: m.InfoHash, trackers: m.Trackers})
+		case strings.HasSuffix(arg, metafile.Extension):
+			meta, err := metafile.Load(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			if publisher != "" && meta.Publisher() != publisher {
+				return nil, fmt.Errorf("%s: not signed by publisher %s", arg, publisher)
+			}
+			targets = append(targets, target{hash: meta.File.Hash, trackers: meta.Trackers, meta: meta})
+		case strings.HasSuffix(arg, torrent.Extension):
+			// Its trackers are BitTorrent trackers: ours know it by its info hash
+			t, err := torrent.Load(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			targets = append(targets, target{hash: cmp.Or(t.InfoHash, t.InfoHashV2)})
+		default:
+			targets = append(targets, target{hash: arg})
 		}
-		*fileHash = m.InfoHash
-		// Use tracker from magnet if available
-		if len(m.Trackers) > 0 {
-			*trackerURL = m.Trackers[0]
+	}
+	return targets, nil
+}
+
+// listPageSize is the number of files --list shows at a time
+const listPageSize = 50
+
+func list(tracker *client.TrackerClient, search, cursor string) int {
+	opts := client.ListOptions{Search: search, Limit: listPageSize, Cursor: cursor}
+	if search == "" {
+		// Searches are ranked by relevance
+		opts.Sort = "seeders"
+	}
+	resp, err := tracker.ListFiles(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list files: %v\n", err)
+		return exitFailed
+	}
+	if len(resp.Files) == 0 {
+		fmt.Println("No files available")
+		return exitOK
+	}
+	fmt.Println("\nAvailable files:")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, f := range resp.Files {
+		fmt.Printf("%-12s  %-40s  %10s  %d seeders\n",
+			truncate(f.Hash, 12), truncate(f.Name, 40), formatSize(f.Size), f.Seeders)
+	}
+	fmt.Println(strings.Repeat("-", 80))
+	if resp.NextCursor != "" {
+		fmt.Printf("\nNext page: p2p-download --list --cursor %s\n", resp.NextCursor)
+	}
+	fmt.Println("\nTo download: p2p-download <hash>")
+	return exitOK
+}
+
+// fetcher downloads files one at a time into the output directory
+type fetcher struct {
+	tracker  *client.TrackerClient
+	store    *storage.LocalStorage
+	dl       *downloader.Downloader
+	output   string
+	retries  int
+	progress bool
+	tty      bool
+}
+
+// fetch downloads a file and returns its path in the output directory.
+// skipped is true if a previous run already downloaded it.
+func (f *fetcher) fetch(ctx context.Context, t target) (path string, skipped bool, err error) {
+	if state, ok := f.store.GetDownload(t.hash); ok && state.Status == storage.StatusCompleted {
+		path := f.outputPath(state.Metadata.Name)
+		if sum, err := hash.CalculateFile(path); err == nil && sum == t.hash {
+			return path, true, nil
+		}
+	}
+	f.tracker.AddFileTrackers(t.hash, t.trackers)
+	var webSeeds []string
+	if t.meta != nil {
+		webSeeds = t.meta.WebSeeds
+		if _, err := f.store.PrepareDownload(&t.meta.File, webSeeds); err != nil {
+			return "", false, err
 		}
-		fmt.Printf("Magnet: %s\n", m.DisplayName)
 	}
 
-	// Create simple tracker client (no auth needed for public APIs)
-	client := NewSimpleClient(*trackerURL)
+	var lastErr error
+	for attempt := 1; attempt <= f.retries; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(time.Duration(attempt-1) * retryDelay):
+			case <-ctx.Done():
+				return "", false, ctx.Err()
+			}
+		}
 
-	if *listFiles {
-		files, err := client.ListFiles()
+		fileInfo, err := f.tracker.GetPeers(t.hash)
+		var statusErr *client.StatusError
+		if err != nil && len(webSeeds) > 0 {
+			// The web seeds of the metafile are enough
+			fileInfo, err = downloader.FileInfoOf(&t.meta.File), nil
+		} else if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			err = errNotFound
+		} else if err == nil && len(fileInfo.Peers) == 0 && len(webSeeds) == 0 {
+			err = errNoPeers
+		}
 		if err != nil {
-			log.Fatalf("Failed to list files: %v", err)
+			lastErr = err
+			continue
 		}
-		if len(files) == 0 {
-			fmt.Println("No files available")
-			return
+		// A BitTorrent info hash resolves to the file's own hash
+		if fileInfo.FileHash != "" {
+			t.hash = fileInfo.FileHash
 		}
-		fmt.Println("\nAvailable files:")
-		fmt.Println(strings.Repeat("-", 80))
-		for _, f := range files {
-			fmt.Printf("%-12s  %-40s  %10s  %d seeders\n",
-				truncate(f.Hash, 12), truncate(f.Name, 40), formatSize(f.Size), f.Seeders)
+
+		if err := f.download(ctx, fileInfo); err != nil {
+			if ctx.Err() != nil {
+				f.store.PauseDownload(t.hash)
+				return "", false, ctx.Err()
+			}
+			lastErr = err
+			continue
 		}
-		fmt.Println(strings.Repeat("-", 80))
-		fmt.Println("\nTo download: p2p-download <hash>")
-		return
+		path, err := f.complete(t.hash)
+		return path, false, err
 	}
+	return "", false, lastErr
+}
 
-	if *fileHash == "" {
-		fmt.Println("Usage: p2p-download [options] <hash|magnet>")
-		fmt.Println("\nOptions:")
-		flag.PrintDefaults()
-		fmt.Println("\nExamples:")
-		fmt.Println("  p2p-download --list                  # List available files")
-		fmt.Println("  p2p-download abc123def456            # Download file by hash")
-		fmt.Println("  p2p-download 'magnet:?xt=urn:sha256:abc123&dn=file.txt'")
-		fmt.Println("  p2p-download --output /tmp abc123    # Download to specific directory")
-		os.Exit(1)
+// download runs the downloader while showing progress
+func (f *fetcher) download(ctx context.Context, fileInfo *protocol.GetPeersResponse) error {
+	if !f.progress {
+		return f.dl.DownloadFileContext(ctx, fileInfo)
 	}
 
-	// Get file info
-	fileInfo, err := client.GetPeers(*fileHash)
-	if err != nil {
-		log.Fatalf("Failed to get file info: %v", err)
-	}
+	p := newProgress(os.Stderr, f.tty, fileInfo.FileName, fileInfo.FileSize)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.update(f.received(fileInfo))
+			case <-done:
+				p.update(f.received(fileInfo))
+				p.finish()
+				return
+			}
+		}
+	}()
 
-	if len(fileInfo.Peers) == 0 {
-		log.Fatalf("No peers available for this file")
-	}
+	err := f.dl.DownloadFileContext(ctx, fileInfo)
+	close(done)
+	<-finished
+	return err
+}
 
-	fmt.Printf("Downloading: %s (%s)\n", fileInfo.FileName, formatSize(fileInfo.FileSize))
-	fmt.Printf("Chunks: %d, Peers: %d\n", fileInfo.ChunkCount, len(fileInfo.Peers))
+// received returns the bytes of the file held so far
+func (f *fetcher) received(fileInfo *protocol.GetPeersResponse) int64 {
+	if _, ok := f.store.GetDownload(fileInfo.FileHash); !ok {
+		return 0
+	}
+	received := fileInfo.FileSize
+	for _, index := range f.store.GetMissingChunks(fileInfo.FileHash) {
+		if index < len(fileInfo.Chunks) {
+			received -= fileInfo.Chunks[index].Size
+		}
+	}
+	return received
+}
 
-	// Initialize storage
-	if err := os.MkdirAll(*outputDir, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+// complete verifies a finished download and moves it to the output directory
+func (f *fetcher) complete(fileHash string) (string, error) {
+	state, ok := f.store.GetDownload(fileHash)
+	if !ok {
+		return "", errors.New("download state lost")
 	}
+	f.store.RemoveSharedFile(fileHash) // This tool does not seed
 
-	store, err := storage.NewLocalStorage(*outputDir)
+	sum, err := hash.CalculateFile(state.OutputPath)
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		return "", err
 	}
-
-	// Initialize P2P client
-	peerID := uuid.New().String()
-	p2pClient := p2p.NewClient(peerID)
-
-	// Initialize relay client for NAT traversal fallback
-	relayClient := relay.NewClient(peerID, *trackerURL)
-	if err := relayClient.Connect(); err != nil {
-		log.Printf("Warning: Relay connection failed: %v (will use direct TCP only)", err)
-	} else {
-		log.Printf("Relay connected for NAT traversal fallback")
-		defer relayClient.Close()
+	if sum != fileHash {
+		// Start over on the next run
+		os.Remove(state.OutputPath)
+		f.store.CancelDownload(fileHash)
+		return "", &verifyError{want: fileHash, got: sum}
 	}
 
-	// Start download with relay support
-	dl := downloader.NewWithRelay(store, p2pClient, relayClient)
-	if err := dl.DownloadFile(fileInfo); err != nil {
-		log.Fatalf("Download failed: %v", err)
+	path := f.outputPath(state.Metadata.Name)
+	if err := os.Rename(state.OutputPath, path); err != nil {
+		return "", err
 	}
+	return path, nil
+}
 
-	fmt.Printf("\n✓ Download complete: %s\n", filepath.Join(*outputDir, fileInfo.FileName))
+// outputPath returns where a file is saved. Only the base name of the
+// announced name is used so it cannot point outside the output directory.
+func (f *fetcher) outputPath(name string) string {
+	return filepath.Join(f.output, filepath.Base(name))
 }
 
 func truncate(s string, n int) string {
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressInterval = 200 * time.Millisecond // How often progress is sampled
+	speedWindow      = 5 * time.Second        // Speed is averaged over this window
+	logInterval      = 10 * time.Second       // Progress line period when not on a terminal
+	barWidth         = 20
+)
+
+// progress shows the progress of one download: a line redrawn in place on a
+// terminal, or a line every logInterval otherwise so CI logs stay readable
+type progress struct {
+	out     io.Writer
+	tty     bool
+	name    string
+	total   int64
+	samples []progressSample
+	lastLog time.Time
+	width   int // Longest line drawn, to clear leftovers when redrawing
+}
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+func newProgress(out io.Writer, tty bool, name string, total int64) *progress {
+	return &progress{out: out, tty: tty, name: name, total: total}
+}
+
+// update records the bytes held so far and redraws the line
+func (p *progress) update(done int64) {
+	now := time.Now()
+	p.samples = append(p.samples, progressSample{at: now, bytes: done})
+	for len(p.samples) > 2 && now.Sub(p.samples[0].at) > speedWindow {
+		p.samples = p.samples[1:]
+	}
+
+	line := p.line(done)
+	if !p.tty {
+		if now.Sub(p.lastLog) >= logInterval {
+			p.lastLog = now
+			fmt.Fprintln(p.out, line)
+		}
+		return
+	}
+	p.width = max(p.width, len(line))
+	fmt.Fprintf(p.out, "\r%-*s", p.width, line)
+}
+
+// finish ends the progress line
+func (p *progress) finish() {
+	if p.tty {
+		fmt.Fprintln(p.out)
+	} else if len(p.samples) > 0 {
+		fmt.Fprintln(p.out, p.line(p.samples[len(p.samples)-1].bytes))
+	}
+}
+
+// speed returns bytes per second over the last samples
+func (p *progress) speed() float64 {
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / elapsed
+}
+
+func (p *progress) line(done int64) string {
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(done) * 100 / float64(p.total)
+	}
+	filled := int(percent / 100 * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	speed := p.speed()
+	eta := "--:--"
+	if speed > 0 {
+		eta = formatDuration(time.Duration(float64(p.total-done) / speed * float64(time.Second)))
+	}
+	return fmt.Sprintf("%-30s [%s] %5.1f%%  %s / %s  %s/s  ETA %s",
+		truncate(p.name, 30), bar, percent, formatSize(done), formatSize(p.total), formatSize(int64(speed)), eta)
+}
+
+// formatDuration formats d as m:ss or h:mm:ss
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bufio"
+	"cmp"
+	"context"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
 	"io"
@@ -15,52 +18,135 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
 	"github.com/p2p-filesharing/distributed-system/pkg/magnet"
+	"github.com/p2p-filesharing/distributed-system/pkg/metafile"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/pkg/torrent"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/client"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/config"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/control"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/metrics"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/p2p"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/relay"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/scanner"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
 )
 
 func main() {
-	trackerURL := flag.String("tracker", "https://p2p.idist.dev", "Tracker server URL")
-	port := flag.Int("port", 6881, "P2P listen port")
-	dataDir := flag.String("data", "./data", "Data directory")
-	daemon := flag.Bool("daemon", false, "Run in daemon mode (no CLI)")
-	apiKey := flag.String("api-key", "", "API key for tracker authentication")
+	defaults := config.Default()
+	configPath := flag.String("config", os.Getenv("P2P_CONFIG"), "Config file (YAML); settings are overridden by P2P_* environment variables and by flags")
+	flag.String("tracker", defaults.TrackerURL(), "Tracker server URL (comma-separated for several)")
+	flag.Int("port", defaults.Peer.Port, "P2P listen port")
+	flag.String("data", defaults.Peer.DataDir, "Data directory")
+	flag.Bool("daemon", defaults.Peer.Daemon, "Run in daemon mode (no CLI)")
+	flag.String("api-key", "", "API key for tracker authentication")
+	flag.String("upload-limit", defaults.Bandwidth.UploadLimit, "Default upload limit (e.g. 512KB, 2MB)")
+	flag.String("download-limit", defaults.Bandwidth.DownloadLimit, "Default download limit (e.g. 512KB, 2MB)")
+	flag.String("bandwidth-schedule", "", "Time-of-day limits, e.g. \"mon-fri 08:00-18:00 up=2MB down=2MB\"")
+	flag.String("storage-quota", defaults.Storage.Quota, "Max space for downloads plus temp data (e.g. 50GB, 0 = no quota)")
+	flag.String("min-free-space", defaults.Storage.MinFreeSpace, "Free disk space to always leave")
+	flag.String("evict", defaults.Storage.Evict, "Eviction of completed downloads when space runs out: none, oldest, largest")
+	flag.String("chunking", defaults.Chunking.Mode, "Chunking for shared files: fixed, or cdc (content-defined, keeps chunks reusable across file versions)")
+	flag.String("chunk-size", defaults.Chunking.ChunkSize, "Chunk size (average size for cdc)")
+	flag.Bool("chunk-store", defaults.Storage.ChunkStore, "Keep downloaded chunks in a content-addressed store and skip chunks already held locally")
+	flag.String("control", defaults.Control.Addr, "Control API address: loopback host:port or unix:/path/to/socket (\"off\" to disable)")
+	flag.String("control-token", "", "Control API token (default: generated and stored in <data>/control.token)")
+	flag.Int("max-active-downloads", defaults.Downloads.MaxActive, "Downloads run at the same time by the download queue")
+	flag.String("metrics", "", "Serve Prometheus metrics on this address, e.g. :9100 (default: disabled)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nEnvironment overrides:\n  %s\n", strings.Join(config.EnvNames(), "\n  "))
+	}
 	flag.Parse()
 
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *configPath != "" {
+		log.Printf("Config: %s", *configPath)
+	}
+
+	// Parsed values were checked by loadConfig
+	schedule, _ := cfg.BandwidthSchedule()
+	quota, _ := cfg.Quota()
+	fileChunker, _ := cfg.NewChunker()
+
 	// Generate peer ID
 	peerID := uuid.New().String()
 	log.Printf("=== P2P File Sharing - Peer Node ===")
 	log.Printf("Peer ID: %s", peerID)
-	log.Printf("Tracker: %s", *trackerURL)
+	log.Printf("Trackers: %s", strings.Join(cfg.Tracker.URLs, ", "))
 
 	// Initialize storage
-	store, err := storage.NewLocalStorage(*dataDir)
+	store, err := storage.NewLocalStorage(cfg.Peer.DataDir)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	store.SetQuota(quota)
+	if cfg.Storage.ChunkStore {
+		if err := store.EnableChunkStore(); err != nil {
+			log.Fatalf("Failed to open chunk store: %v", err)
+		}
+	}
+	evicted := store.EnforceQuota()
+	if len(evicted) > 0 {
+		log.Printf("Evicted %d completed downloads to fit the storage quota", len(evicted))
+	}
 
 	// Initialize tracker client
-	var tracker *client.TrackerClient
-	if *apiKey != "" {
+	if cfg.Tracker.APIKey != "" {
 		log.Printf("Using API key authentication")
-		tracker = client.NewTrackerClientWithAPIKey(*trackerURL, peerID, *apiKey)
-	} else {
-		tracker = client.NewTrackerClient(*trackerURL, peerID)
+	}
+	tracker := client.NewMultiTrackerClient(cfg.Tracker.URLs, peerID, cfg.Tracker.APIKey)
+	tracker.SetTimeout(cfg.Tracker.Timeout)
+	tracker.SetRetry(cfg.Tracker.Retries, client.DefaultRetryDelay)
+	// The owner key makes this peer the owner of the files it shares across
+	// restarts, which lets it make them private
+	ownerKey, err := control.LoadOrCreateToken(filepath.Join(cfg.Peer.DataDir, "owner.key"))
+	if err != nil {
+		log.Fatalf("Failed to create owner key: %v", err)
+	}
+	tracker.SetOwnerSecret([]byte(ownerKey))
+	// The peer key, registered with the trackers and put into grants, proves
+	// the peer ID to seeders
+	peerPub, peerKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		log.Fatalf("Failed to create peer key: %v", err)
+	}
+	tracker.SetPeerKey(peerPub)
+	tracker.SetSharedFiles(func() []*protocol.FileMetadata {
+		var files []*protocol.FileMetadata
+		for _, shared := range store.ListSharedFiles() {
+			files = append(files, shared.Metadata)
+		}
+		return files
+	})
+	tracker.SetTransfers(store.Transfers)
+
+	// Initialize bandwidth manager shared by uploads and downloads
+	bandwidth := throttle.NewBandwidthManager(throttle.Unlimited, throttle.Unlimited)
+	scheduler := throttle.NewScheduler(bandwidth, schedule)
+	scheduler.Start()
+	if len(schedule.Rules) > 0 {
+		log.Printf("Bandwidth schedule: %s", schedule)
 	}
 
 	// Initialize P2P server
-	p2pServer := p2p.NewServer(*port, peerID, store)
+	p2pServer := p2p.NewServer(cfg.Peer.Port, peerID, store)
+	p2pServer.SetBandwidthManager(bandwidth)
+	p2pServer.SetAccessVerifier(tracker.VerifyGrant)
 
 	// Initialize P2P client
 	p2pClient := p2p.NewClient(peerID)
-
-	// Initialize chunker
-	fileChunker := chunker.New(chunker.DefaultChunkSize)
+	p2pClient.SetPeerKey(peerKey)
+	p2pClient.SetTimeout(cfg.Peer.DialTimeout)
 
 	// Start P2P server (auto-finds available port if needed)
 	if err := p2pServer.Start(); err != nil {
@@ -75,142 +161,239 @@ func main() {
 	log.Printf("Public IP: %s", publicIP)
 
 	// Register with tracker using actual port
-	resp, err := tracker.Register(publicIP, actualPort)
-	if err != nil {
-		log.Fatalf("Failed to register with tracker: %v", err)
+	// If every tracker is down, the heartbeat registers once one comes back
+	if resp, err := tracker.Register(publicIP, actualPort); err != nil {
+		log.Printf("Failed to register with tracker, retrying on the next heartbeat: %v", err)
+	} else {
+		log.Printf("Registered with tracker: %s", resp.Message)
+		if resp.OwnerID != "" {
+			log.Printf("Owner ID: %s", resp.OwnerID)
+		}
+	}
+
+	// The trackers must stop sending peers to the chunks of evicted
+	// downloads, whether evicted at startup or to make room for a download
+	withdrawEvicted := func(hashes []string) {
+		for _, hash := range hashes {
+			if err := tracker.WithdrawFile(hash); err != nil {
+				log.Printf("Failed to withdraw evicted file %s: %v", hash, err)
+			}
+		}
 	}
-	log.Printf("Registered with tracker: %s", resp.Message)
+	withdrawEvicted(evicted)
+	store.SetEvictionHandler(func(hashes []string) { go withdrawEvicted(hashes) })
 
 	// Initialize relay client for NAT traversal
-	relayClient := relay.NewClient(peerID, *trackerURL)
+	var relayClient *relay.Client
+	if cfg.Relay.Enabled {
+		relayClient = relay.NewClient(peerID, cfg.RelayURL())
+		relayClient.SetAccessVerifier(func(fileHash, peerID, peerKey, token string) error {
+			if store.Visibility(fileHash) != access.Private {
+				return nil
+			}
+			return tracker.VerifyGrant(fileHash, peerID, peerKey, token)
+		})
+		relayClient.SetSessionToken(func() string { return tracker.SessionToken(cfg.RelayURL()) })
+
+		// Set chunk handler for relay requests
+		relayClient.SetChunkHandler(func(ctx context.Context, fileHash string, chunkIndex int) ([]byte, string, error) {
+			sharedFile, exists := store.GetSharedFile(fileHash)
+			if !exists {
+				// Chunks of a download in progress are served too
+				chunkData, chunkHash, err := store.ReadReceivedChunk(fileHash, chunkIndex)
+				if err == storage.ErrDownloadNotFound {
+					return nil, "", fmt.Errorf("file not found: %s", fileHash)
+				}
+				if err != nil {
+					return nil, "", err
+				}
+				if err := bandwidth.WaitUpload(ctx, int64(len(chunkData))); err != nil {
+					return nil, "", err
+				}
+				store.RecordUpload(fileHash, int64(len(chunkData)))
+				return chunkData, chunkHash, nil
+			}
+			chunkData, err := store.ReadSharedChunk(fileHash, chunkIndex)
+			if err != nil {
+				return nil, "", err
+			}
+			chunkHash := ""
+			if chunkIndex < len(sharedFile.Metadata.Chunks) {
+				chunkHash = sharedFile.Metadata.Chunks[chunkIndex].Hash
+			}
+			if err := bandwidth.WaitUpload(ctx, int64(len(chunkData))); err != nil {
+				return nil, "", err
+			}
+			store.RecordUpload(fileHash, int64(len(chunkData)))
+			return chunkData, chunkHash, nil
+		})
 
-	// Set chunk handler for relay requests
-	relayClient.SetChunkHandler(func(fileHash string, chunkIndex int) ([]byte, string, error) {
-		sharedFile, exists := store.GetSharedFile(fileHash)
-		if !exists {
-			return nil, "", fmt.Errorf("file not found: %s", fileHash)
+		// Connect to relay
+		if err := relayClient.Connect(); err != nil {
+			log.Printf("Warning: Relay connection failed: %v (direct TCP only)", err)
+		} else {
+			log.Printf("[Relay] Connected for NAT traversal support")
 		}
-		chunkData, err := fileChunker.ReadChunk(sharedFile.FilePath, chunkIndex)
-		if err != nil {
-			return nil, "", err
+	}
+
+	// Download queue used by the control API
+	bus := events.NewBus()
+	dl := downloader.NewWithConfig(store, p2pClient, cfg.Downloads.Workers, cfg.Downloads.Retries, cfg.Downloads.ChunkTimeout)
+	if relayClient != nil {
+		dl.SetRelayClient(relayClient)
+	}
+	dl.SetBandwidthManager(bandwidth)
+	downloads := downloader.NewManager(dl, store, tracker, bus, cfg.Downloads.MaxActive)
+	downloads.Start()
+	if resumed := downloads.ResumeInterrupted(); resumed > 0 {
+		log.Printf("Resuming %d interrupted downloads", resumed)
+	}
+
+	// Local control API
+	var controlServer *control.Server
+	if cfg.Control.Addr != "off" && cfg.Control.Addr != "" {
+		token := cfg.Control.Token
+		if token == "" {
+			if token, err = control.LoadOrCreateToken(filepath.Join(cfg.Peer.DataDir, "control.token")); err != nil {
+				log.Fatalf("Failed to create control token: %v", err)
+			}
 		}
-		chunkHash := ""
-		if chunkIndex < len(sharedFile.Metadata.Chunks) {
-			chunkHash = sharedFile.Metadata.Chunks[chunkIndex].Hash
+		controlServer = control.NewServer(control.Config{
+			Addr:       cfg.Control.Addr,
+			Token:      token,
+			PeerID:     peerID,
+			Store:      store,
+			Tracker:    tracker,
+			Chunker:    fileChunker,
+			Downloads:  downloads,
+			Downloader: dl,
+			P2P:        p2pServer,
+			Bandwidth:  bandwidth,
+			Scheduler:  scheduler,
+			Events:     bus,
+		})
+		if err := controlServer.Start(); err != nil {
+			log.Fatalf("Failed to start control API: %v", err)
 		}
-		return chunkData, chunkHash, nil
-	})
+	}
 
-	// Connect to relay
-	if err := relayClient.Connect(); err != nil {
-		log.Printf("Warning: Relay connection failed: %v (direct TCP only)", err)
-	} else {
-		log.Printf("[Relay] Connected for NAT traversal support")
+	// Prometheus metrics
+	if cfg.Metrics.Addr != "" {
+		metrics.RegisterState(peerID, func() metrics.State {
+			return peerState(store, p2pServer, relayClient, bandwidth, downloads)
+		})
+		if _, err := metrics.Start(cfg.Metrics.Addr); err != nil {
+			log.Fatalf("Failed to start metrics listener: %v", err)
+		}
 	}
 
 	// Start heartbeat goroutine
-	go startHeartbeat(tracker, store)
+	go startHeartbeat(tracker, store, cfg.Tracker.HeartbeatInterval)
 
 	// Handle graceful shutdown
-	go handleShutdown(tracker, p2pServer, relayClient)
+	go handleShutdown(tracker, p2pServer, relayClient, store, downloads, controlServer)
+
+	// Settings that can change live are reloaded on SIGHUP
+	reload := &reloader{
+		configPath: *configPath,
+		current:    cfg,
+		scheduler:  scheduler,
+		downloads:  downloads,
+		tracker:    tracker,
+		store:      store,
+		publicIP:   publicIP,
+		port:       actualPort,
+	}
 
 	// Run in daemon mode or CLI mode
-	if *daemon {
+	if cfg.Peer.Daemon {
 		log.Println("Running in daemon mode...")
 
-		// Create shared directory if not exists
-		sharedDir := filepath.Join(*dataDir, "shared")
-		if err := os.MkdirAll(sharedDir, 0755); err != nil {
-			log.Printf("Failed to create shared directory: %v", err)
-		} else {
-			log.Printf("Shared directory: %s", sharedDir)
-			// Initial scan
-			scanAndShareFiles(sharedDir, tracker, store, fileChunker)
-			// Start periodic scan
-			go startFileScan(sharedDir, tracker, store, fileChunker)
+		// Hashes of unchanged files are reused across scans and restarts
+		hashCache, err := scanner.NewHashCache(filepath.Join(cfg.Peer.DataDir, "hashcache.json"))
+		if err != nil {
+			log.Fatalf("Failed to load hash cache: %v", err)
 		}
+		reload.shared = newSharedDirs(store, fileChunker, hashCache, tracker, cfg.Shared.ScanInterval)
+		reload.shared.Update(cfg.SharedDirs())
+		go reload.shared.Run()
+		go reload.watch()
 
 		// Block forever, waiting for shutdown signal
 		select {}
 	} else {
+		go reload.watch()
+
 		// Start CLI loop
-		runCLI(tracker, store, p2pClient, fileChunker)
+		runCLI(tracker, store, p2pClient, fileChunker, bandwidth, scheduler)
 	}
 }
 
-func startHeartbeat(tracker *client.TrackerClient, store *storage.LocalStorage) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		hashes := store.GetAllSharedHashes()
-		if _, err := tracker.Heartbeat(hashes); err != nil {
-			log.Printf("Heartbeat failed: %v", err)
-		}
+// peerState collects the gauges reported on each metrics scrape. Storage usage
+// comes from the counters the storage keeps, so a scrape does not read the disk
+// beyond the free space of its volume.
+func peerState(store *storage.LocalStorage, server *p2p.Server, relayClient *relay.Client, bandwidth *throttle.BandwidthManager, downloads *downloader.Manager) metrics.State {
+	state := metrics.State{
+		Connections:    len(server.Connections()),
+		RelayConnected: relayClient != nil && relayClient.IsConnected(),
+		SharedFiles:    len(store.GetAllSharedHashes()),
+		StorageUsed:    store.UsedBytes(),
+		StorageQuota:   store.GetQuota().MaxBytes,
+		DiskFree:       -1,
 	}
-}
-
-// scanAndShareFiles scans the shared directory and announces all files to tracker
-func scanAndShareFiles(sharedDir string, tracker *client.TrackerClient, store *storage.LocalStorage, c *chunker.Chunker) {
-	entries, err := os.ReadDir(sharedDir)
-	if err != nil {
-		log.Printf("Error reading shared directory: %v", err)
-		return
+	state.UploadLimit, state.DownloadLimit = bandwidth.GetLimits()
+	if free, err := store.FreeBytes(); err == nil {
+		state.DiskFree = free
 	}
-
-	sharedCount := 0
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip directories for now
+	if chunks := store.ChunkStore(); chunks != nil {
+		state.ChunkStoreBytes = chunks.Bytes()
+	}
+	for _, entry := range downloads.Entries() {
+		switch entry.State {
+		case downloader.QueueActive:
+			state.ActiveDownloads++
+		case downloader.QueueQueued:
+			state.QueuedDownloads++
+		case downloader.QueuePaused:
+			state.PausedDownloads++
 		}
+	}
+	return state
+}
 
-		filePath := filepath.Join(sharedDir, entry.Name())
-
-		// Check if already shared
-		if store.IsFileShared(filePath) {
-			continue
-		}
+// startHeartbeat sends heartbeats as often as the trackers ask, or every
+// interval if they do not say
+func startHeartbeat(tracker *client.TrackerClient, store *storage.LocalStorage, interval time.Duration) {
+	next := interval
+	for {
+		time.Sleep(next)
 
-		// Chunk and share file
-		metadata, err := c.ChunkFile(filePath)
+		next = interval
+		resp, err := tracker.Heartbeat(store.GetAllSharedHashes())
 		if err != nil {
-			log.Printf("Error chunking file %s: %v", entry.Name(), err)
+			log.Printf("Heartbeat failed: %v", err)
 			continue
 		}
-
-		store.AddSharedFile(metadata, filePath)
-
-		resp, err := tracker.AnnounceFile(metadata)
-		if err != nil {
-			log.Printf("Error announcing file %s: %v", entry.Name(), err)
-			continue
+		if resp.NextHeartbeatSecs > 0 {
+			next = time.Duration(resp.NextHeartbeatSecs) * time.Second
 		}
-
-		log.Printf("Shared: %s (hash: %s, %d chunks)", metadata.Name, resp.FileID, len(metadata.Chunks))
-		sharedCount++
-	}
-
-	if sharedCount > 0 {
-		log.Printf("Shared %d new files from %s", sharedCount, sharedDir)
 	}
 }
 
-// startFileScan periodically scans for new files in the shared directory
-func startFileScan(sharedDir string, tracker *client.TrackerClient, store *storage.LocalStorage, c *chunker.Chunker) {
-	ticker := time.NewTicker(60 * time.Second) // Scan every 60 seconds
-	defer ticker.Stop()
-
-	for range ticker.C {
-		scanAndShareFiles(sharedDir, tracker, store, c)
-	}
-}
-
-func handleShutdown(tracker *client.TrackerClient, server *p2p.Server, relayClient *relay.Client) {
+func handleShutdown(tracker *client.TrackerClient, server *p2p.Server, relayClient *relay.Client, store *storage.LocalStorage, downloads *downloader.Manager, controlServer *control.Server) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 
 	log.Println("Shutting down...")
+	if controlServer != nil {
+		controlServer.Stop()
+	}
+	downloads.Stop()
+	if err := store.SaveState(); err != nil {
+		log.Printf("Failed to save state: %v", err)
+	}
+	store.Close()
 	tracker.Leave()
 	server.Stop()
 	if relayClient != nil {
@@ -219,14 +402,20 @@ func handleShutdown(tracker *client.TrackerClient, server *p2p.Server, relayClie
 	os.Exit(0)
 }
 
-func runCLI(tracker *client.TrackerClient, store *storage.LocalStorage, p2pClient *p2p.Client, fileChunker *chunker.Chunker) {
+func runCLI(tracker *client.TrackerClient, store *storage.LocalStorage, p2pClient *p2p.Client, fileChunker *chunker.Chunker, bandwidth *throttle.BandwidthManager, scheduler *throttle.Scheduler) {
 	scanner := bufio.NewScanner(os.Stdin)
 
 	fmt.Println("\nCommands:")
-	fmt.Println("  share <filepath>  - Share a file")
-	fmt.Println("  list              - List available files")
-	fmt.Println("  download <hash>   - Download a file")
+	fmt.Println("  share <filepath>  - Share a file (share -private or -unlisted <filepath> to hide it)")
+	fmt.Println("  list [name]       - List available files (\"list -h\" for filters and sorting)")
+	fmt.Println("  download <hash>   - Download a file (hash, magnet link, .p2pmeta or .torrent file), then the share token of a private file")
+	fmt.Println("  tag <hash> [...]  - Edit the tags (+tag, -tag) and category (category=name) of a file you shared")
+	fmt.Println("  access <hash> <public|unlisted|private> [groups] - Set who can download a file you shared")
+	fmt.Println("  invite <hash> [ttl] - Get a share token of a private file you shared (ttl e.g. 24h)")
+	fmt.Println("  revoke <hash>     - Revoke the share tokens of a file you shared")
 	fmt.Println("  status            - Show status")
+	fmt.Println("  limits [up down]  - Show or set default bandwidth limits")
+	fmt.Println("  schedule [rules]  - Show or set bandwidth schedule (\"off\" to clear)")
 	fmt.Println("  quit              - Exit")
 	fmt.Println()
 
@@ -252,11 +441,23 @@ func runCLI(tracker *client.TrackerClient, store *storage.LocalStorage, p2pClien
 		case "share":
 			cmdShare(arg, tracker, store, fileChunker)
 		case "list":
-			cmdList(tracker)
+			cmdList(arg, tracker)
 		case "download":
-			cmdDownload(arg, tracker, store, p2pClient)
+			cmdDownload(arg, tracker, store, p2pClient, bandwidth)
+		case "tag":
+			cmdTag(arg, tracker)
+		case "access":
+			cmdAccess(arg, tracker, store)
+		case "invite":
+			cmdInvite(arg, tracker)
+		case "revoke":
+			cmdRevoke(arg, tracker)
 		case "status":
-			cmdStatus(store)
+			cmdStatus(store, tracker)
+		case "limits":
+			cmdLimits(arg, bandwidth, scheduler)
+		case "schedule":
+			cmdSchedule(arg, scheduler)
 		case "quit", "exit":
 			fmt.Println("Goodbye!")
 			return
@@ -267,16 +468,28 @@ func runCLI(tracker *client.TrackerClient, store *storage.LocalStorage, p2pClien
 }
 
 func cmdShare(filepath string, tracker *client.TrackerClient, store *storage.LocalStorage, c *chunker.Chunker) {
+	visibility := ""
+	for _, v := range []string{access.Private, access.Unlisted} {
+		if rest, ok := strings.CutPrefix(filepath, "-"+v+" "); ok {
+			visibility, filepath = v, strings.TrimSpace(rest)
+		}
+	}
 	if filepath == "" {
-		fmt.Println("Usage: share <filepath>")
+		fmt.Println("Usage: share [-private|-unlisted] <filepath>")
 		return
 	}
 
-	metadata, err := c.ChunkFile(filepath)
+	metadata, err := c.ChunkFileProgress(filepath, func(hashed, total int64) {
+		if total > 0 {
+			fmt.Printf("\rHashing... %d%%", hashed*100/total)
+		}
+	})
+	fmt.Println()
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
+	metadata.Visibility = visibility
 
 	store.AddSharedFile(metadata, filepath)
 
@@ -289,16 +502,49 @@ func cmdShare(filepath string, tracker *client.TrackerClient, store *storage.Loc
 	fmt.Printf("Shared: %s\n", metadata.Name)
 	fmt.Printf("Hash: %s\n", resp.FileID)
 	fmt.Printf("Chunks: %d\n", len(metadata.Chunks))
+	if visibility == access.Private {
+		fmt.Printf("Private: run \"invite %s\" to get a share token\n", metadata.Hash)
+	}
 
 	// Generate magnet link
 	m := magnet.New(metadata.Hash, metadata.Name, metadata.Size).
-		AddTracker("https://p2p.idist.dev").
 		SetChunkInfo(int(metadata.ChunkSize), len(metadata.Chunks))
+	for _, trackerURL := range tracker.Trackers() {
+		m.AddTracker(trackerURL)
+	}
 	fmt.Printf("Magnet: %s\n", m.String())
 }
 
-func cmdList(tracker *client.TrackerClient) {
-	resp, err := tracker.ListFiles()
+func cmdList(arg string, tracker *client.TrackerClient) {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	var opts client.ListOptions
+	fs.StringVar(&opts.Sort, "sort", "", "Sort by added, name, size, seeders or relevance (default: relevance when searching, added otherwise)")
+	fs.StringVar(&opts.Order, "order", "", "asc or desc (default: asc for name, desc otherwise)")
+	fs.StringVar(&opts.Category, "category", "", "Only files of this category")
+	tags := fs.String("tag", "", "Only files with all these tags (comma-separated)")
+	minSize := fs.String("min-size", "", "Minimum size, e.g. 100MB")
+	maxSize := fs.String("max-size", "", "Maximum size, e.g. 4GB")
+	fs.IntVar(&opts.MinSeeders, "min-seeders", 0, "Minimum number of seeders")
+	fs.StringVar(&opts.Since, "since", "", "Only files added since an RFC 3339 time or for a duration, e.g. 24h")
+	fs.IntVar(&opts.Limit, "limit", 20, "Files per page")
+	fs.StringVar(&opts.Cursor, "cursor", "", "Show the next page of a previous list")
+	if err := fs.Parse(strings.Fields(arg)); err != nil {
+		return
+	}
+	opts.Search = strings.Join(fs.Args(), " ")
+	opts.Tags = config.SplitList(*tags)
+	var err error
+	if opts.MinSize, err = storage.ParseSize(*minSize); err != nil {
+		fmt.Printf("Invalid -min-size: %v\n", err)
+		return
+	}
+	if opts.MaxSize, err = storage.ParseSize(*maxSize); err != nil {
+		fmt.Printf("Invalid -max-size: %v\n", err)
+		return
+	}
+
+	resp, err := tracker.ListFiles(opts)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
@@ -318,24 +564,174 @@ func cmdList(tracker *client.TrackerClient) {
 		if hashDisplay == "" {
 			continue // Skip files with empty hash
 		}
-		fmt.Printf("  [%s] %s (%d bytes) - %d seeders\n", hashDisplay, f.Name, f.Size, f.Seeders)
+		fmt.Printf("  [%s] %s (%d bytes) - %d seeders - %s", hashDisplay, f.Name, f.Size, f.Seeders, f.Category)
+		for _, tag := range f.Tags {
+			fmt.Printf(" #%s", tag)
+		}
+		fmt.Println()
+	}
+	if resp.NextCursor != "" {
+		fmt.Printf("\nNext page: list -limit %d -cursor %s\n", opts.Limit, resp.NextCursor)
+	}
+}
+
+// cmdTag edits the labels of a file: +tag adds a tag, -tag removes one and
+// category=name sets the category (category= resets it)
+func cmdTag(arg string, tracker *client.TrackerClient) {
+	fields := strings.Fields(arg)
+	if len(fields) < 2 {
+		fmt.Println("Usage: tag <hash> [+tag|-tag|category=name]...")
+		return
+	}
+
+	var req protocol.FileLabelsRequest
+	for _, field := range fields[1:] {
+		switch {
+		case strings.HasPrefix(field, "+"):
+			req.AddTags = append(req.AddTags, field[1:])
+		case strings.HasPrefix(field, "-"):
+			req.RemoveTags = append(req.RemoveTags, field[1:])
+		case strings.HasPrefix(field, "category="):
+			category := strings.TrimPrefix(field, "category=")
+			req.Category = &category
+		default:
+			fmt.Printf("Invalid argument %q: want +tag, -tag or category=name\n", field)
+			return
+		}
+	}
+
+	resp, err := tracker.UpdateFileLabels(fields[0], req)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Category: %s\n", resp.Category)
+	fmt.Printf("Tags: %s\n", strings.Join(resp.Tags, ", "))
+}
+
+// cmdAccess sets the visibility of a file this peer owns and the groups
+// (comma-separated) whose members may download it when private
+func cmdAccess(arg string, tracker *client.TrackerClient, store *storage.LocalStorage) {
+	fields := strings.Fields(arg)
+	if len(fields) < 2 || len(fields) > 3 || !access.ValidVisibility(fields[1]) {
+		fmt.Println("Usage: access <hash> <public|unlisted|private> [group,...]")
+		return
+	}
+	var groups []string
+	if len(fields) == 3 {
+		groups = config.SplitList(fields[2])
+	}
+
+	if err := tracker.SetFileAccess(fields[0], fields[1], groups); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	// This peer serves the file to peers with a grant only once private
+	store.SetVisibility(fields[0], fields[1])
+	fmt.Printf("Visibility: %s\n", fields[1])
+	if len(groups) > 0 {
+		fmt.Printf("Groups: %s\n", strings.Join(groups, ", "))
+	}
+}
+
+// cmdInvite prints a share token of a private file this peer owns
+func cmdInvite(arg string, tracker *client.TrackerClient) {
+	fields := strings.Fields(arg)
+	if len(fields) < 1 || len(fields) > 2 {
+		fmt.Println("Usage: invite <hash> [ttl]")
+		return
+	}
+	var ttl time.Duration
+	if len(fields) == 2 {
+		var err error
+		if ttl, err = time.ParseDuration(fields[1]); err != nil || ttl < 0 {
+			fmt.Printf("Invalid ttl %q: want a duration such as 24h\n", fields[1])
+			return
+		}
+	}
+
+	resp, err := tracker.CreateShare(fields[0], ttl)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Share token: %s\n", resp.Token)
+	if !resp.ExpiresAt.IsZero() {
+		fmt.Printf("Expires: %s\n", resp.ExpiresAt.Local().Format(time.RFC1123))
+	}
+	fmt.Printf("Download with: download %s %s\n", fields[0], resp.Token)
+}
+
+// cmdRevoke revokes the share tokens of a file this peer owns
+func cmdRevoke(arg string, tracker *client.TrackerClient) {
+	hash := strings.TrimSpace(arg)
+	if hash == "" {
+		fmt.Println("Usage: revoke <hash>")
+		return
+	}
+	if err := tracker.RevokeShares(hash); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
+	fmt.Println("Share tokens revoked")
 }
 
-func cmdDownload(fileHash string, tracker *client.TrackerClient, store *storage.LocalStorage, p2pClient *p2p.Client) {
+func cmdDownload(arg string, tracker *client.TrackerClient, store *storage.LocalStorage, p2pClient *p2p.Client, bandwidth *throttle.BandwidthManager) {
+	fileHash, shareToken, _ := strings.Cut(arg, " ")
 	if fileHash == "" {
-		fmt.Println("Usage: download <hash>")
+		fmt.Println("Usage: download <hash|magnet|file.p2pmeta|file.torrent> [share-token]")
 		return
 	}
+	var webSeeds []string
+	switch {
+	case strings.HasPrefix(fileHash, "magnet:?"):
+		m, err := magnet.Parse(fileHash)
+		if err != nil {
+			fmt.Printf("Invalid magnet link: %v\n", err)
+			return
+		}
+		fileHash = m.InfoHash
+		tracker.AddFileTrackers(fileHash, m.Trackers)
+	case strings.HasSuffix(fileHash, metafile.Extension):
+		meta, err := metafile.Load(fileHash)
+		if err != nil {
+			fmt.Printf("Invalid metafile: %v\n", err)
+			return
+		}
+		fileHash, webSeeds = meta.File.Hash, meta.WebSeeds
+		tracker.AddFileTrackers(fileHash, meta.Trackers)
+		if _, err := store.PrepareDownload(&meta.File, meta.WebSeeds); err != nil {
+			fmt.Printf("Cannot start download: %v\n", err)
+			return
+		}
+	case strings.HasSuffix(fileHash, torrent.Extension):
+		// Trackers know imported torrents by their info hash
+		t, err := torrent.Load(fileHash)
+		if err != nil {
+			fmt.Printf("Invalid torrent: %v\n", err)
+			return
+		}
+		fileHash = cmp.Or(t.InfoHash, t.InfoHashV2)
+	}
+
+	if shareToken = strings.TrimSpace(shareToken); shareToken != "" {
+		tracker.SetShareToken(fileHash, shareToken)
+	}
 
 	// Get file info and peers from tracker
 	fileInfo, err := tracker.GetPeers(fileHash)
+	if err != nil && len(webSeeds) > 0 {
+		if state, ok := store.GetDownload(fileHash); ok {
+			fmt.Printf("No peers from the trackers (%v), using web seeds\n", err)
+			fileInfo, err = downloader.FileInfoOf(state.Metadata), nil
+		}
+	}
 	if err != nil {
 		fmt.Printf("Error getting file info: %v\n", err)
 		return
 	}
 
-	if len(fileInfo.Peers) == 0 {
+	if len(fileInfo.Peers) == 0 && len(webSeeds) == 0 {
 		fmt.Println("No peers available for this file")
 		return
 	}
@@ -345,6 +741,7 @@ func cmdDownload(fileHash string, tracker *client.TrackerClient, store *storage.
 
 	// Start download
 	dl := downloader.New(store, p2pClient)
+	dl.SetBandwidthManager(bandwidth)
 	if err := dl.DownloadFile(fileInfo); err != nil {
 		fmt.Printf("Download failed: %v\n", err)
 		return
@@ -353,9 +750,84 @@ func cmdDownload(fileHash string, tracker *client.TrackerClient, store *storage.
 	fmt.Printf("Download complete: %s\n", fileInfo.FileName)
 }
 
-func cmdStatus(store *storage.LocalStorage) {
+func cmdStatus(store *storage.LocalStorage, tracker *client.TrackerClient) {
 	hashes := store.GetAllSharedHashes()
 	fmt.Printf("Sharing %d files\n", len(hashes))
+	for url, ownerID := range tracker.OwnerIDs() {
+		fmt.Printf("Owner ID on %s: %s\n", url, ownerID)
+	}
+
+	quota := store.GetQuota()
+	if quota.MaxBytes > 0 {
+		fmt.Printf("Storage: %d / %d bytes used (eviction: %s)\n", store.UsedBytes(), quota.MaxBytes, quota.Eviction)
+	}
+	for _, d := range store.ListDownloads() {
+		if d.Status == storage.StatusNoSpace {
+			fmt.Printf("  %s: %s\n", d.Metadata.Name, d.LastError)
+		}
+	}
+	if chunks := store.ChunkStore(); chunks != nil {
+		stats := chunks.Stats()
+		fmt.Printf("Chunk store: %d chunks, %d bytes, %d references\n", stats.Chunks, stats.Bytes, stats.References)
+	}
+}
+
+func cmdLimits(arg string, bandwidth *throttle.BandwidthManager, scheduler *throttle.Scheduler) {
+	if arg == "" {
+		up, down := bandwidth.GetLimits()
+		schedule := scheduler.GetSchedule()
+		fmt.Printf("Current limits: up=%s down=%s\n", throttle.FormatRate(up), throttle.FormatRate(down))
+		fmt.Printf("Default limits: up=%s down=%s\n",
+			throttle.FormatRate(schedule.DefaultUpload), throttle.FormatRate(schedule.DefaultDownload))
+		return
+	}
+
+	parts := strings.Fields(arg)
+	if len(parts) != 2 {
+		fmt.Println("Usage: limits <upload> <download>  (e.g. limits 1MB unlimited)")
+		return
+	}
+	up, err := throttle.ParseRate(parts[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	down, err := throttle.ParseRate(parts[1])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	scheduler.SetDefaultLimits(up, down)
+	fmt.Printf("Default limits set: up=%s down=%s\n", throttle.FormatRate(up), throttle.FormatRate(down))
+}
+
+func cmdSchedule(arg string, scheduler *throttle.Scheduler) {
+	if arg == "" {
+		schedule := scheduler.GetSchedule()
+		if len(schedule.Rules) == 0 {
+			fmt.Println("No bandwidth schedule (default limits always apply)")
+			return
+		}
+		for i, rule := range schedule.Rules {
+			fmt.Printf("  %d. %s\n", i+1, rule)
+		}
+		return
+	}
+
+	if arg == "off" {
+		scheduler.SetSchedule(nil)
+		fmt.Println("Bandwidth schedule cleared")
+		return
+	}
+
+	schedule, err := throttle.ParseSchedule(arg)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	scheduler.SetSchedule(schedule.Rules)
+	fmt.Printf("Bandwidth schedule set: %d rules\n", len(schedule.Rules))
 }
 
 // getPublicIP retrieves the public IP address of this peer
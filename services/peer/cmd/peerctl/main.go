@@ -0,0 +1,661 @@
+// peerctl controls a running peer through its local control API
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/metafile"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/pkg/torrent"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/control"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+)
+
+const usageText = `Usage: peerctl [flags] <command> [arguments]
+
+Commands:
+  share [-private|-unlisted] [path...]
+                              Share files (no arguments: list shared files)
+  unshare <hash>...           Stop sharing files (deletes files kept in the chunk store)
+  export <hash> [path]        Write a shared file to path (default: the current
+                              directory), e.g. a download kept in the chunk store
+  download [-share token] <hash|magnet|file.p2pmeta|file.torrent>...
+                              Queue downloads (-share: token of a private file)
+  downloads [hash]            List downloads, or show one
+  pause <hash>...             Pause downloads
+  resume <hash>...            Resume paused or failed downloads
+  cancel <hash>...            Cancel downloads and delete partial data
+  move <hash> <position>      Move a queued download (1 = next)
+  queue [max-active]          Show the download queue, or set how many downloads run at once
+  peers                       Show connected peers and peers serving downloads
+  stats                       Show peer status
+  limits [upload download]    Show or set default bandwidth limits (e.g. 1MB unlimited)
+  events [type...]            Follow peer events (e.g. download.progress)
+  meta create|show|verify|keygen
+                              Create and inspect .p2pmeta files (no peer needed)
+  torrent show|import|export  Inspect .torrent files, share their content, or
+                              create one for a shared file
+
+Hashes may be abbreviated to any unique prefix.
+
+Flags:
+`
+
+func main() {
+	addr := flag.String("addr", envOr("PEERCTL_ADDR", control.DefaultAddr), "Control API address (host:port or unix:/path/to/socket)")
+	token := flag.String("token", os.Getenv("PEERCTL_TOKEN"), "Control API token (default: read from <data>/control.token)")
+	dataDir := flag.String("data", envOr("PEERCTL_DATA", "./data"), "Peer data directory")
+	jsonOutput := flag.Bool("json", false, "Print JSON instead of tables")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usageText)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	// Allow -json after the command too
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-json" || args[i] == "--json" {
+			*jsonOutput = true
+			args = append(args[:i], args[i+1:]...)
+			i--
+		}
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "meta" || (args[0] == "torrent" && len(args) > 1 && args[1] == "show") {
+		// Works on local files, without a running peer
+		ctl := &peerctl{json: *jsonOutput, out: os.Stdout}
+		if err := ctl.local(args[0], args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "peerctl: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *token == "" {
+		data, err := os.ReadFile(filepath.Join(*dataDir, "control.token"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "peerctl: no token: use -token or -data (%v)\n", err)
+			os.Exit(1)
+		}
+		*token = strings.TrimSpace(string(data))
+	}
+
+	ctl := &peerctl{
+		client: control.NewClient(*addr, *token),
+		json:   *jsonOutput,
+		out:    os.Stdout,
+	}
+	if err := ctl.run(args[0], args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "peerctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type peerctl struct {
+	client *control.Client
+	json   bool
+	out    io.Writer
+}
+
+// local executes a command that does not need a running peer
+func (p *peerctl) local(cmd string, args []string) error {
+	if cmd == "meta" {
+		return p.meta(args)
+	}
+	return p.torrent(args)
+}
+
+// run executes one command
+func (p *peerctl) run(cmd string, args []string) error {
+	switch cmd {
+	case "share":
+		if len(args) == 0 {
+			return p.listShares()
+		}
+		return p.share(args)
+	case "unshare":
+		return p.eachShare(args, func(hash string) error { return p.client.Unshare(hash) })
+	case "export":
+		return p.export(args)
+	case "download":
+		return p.download(args)
+	case "downloads":
+		if len(args) > 0 {
+			return p.showDownload(args[0])
+		}
+		return p.listDownloads()
+	case "pause":
+		return p.eachDownload(args, p.client.Pause)
+	case "resume":
+		return p.eachDownload(args, p.client.Resume)
+	case "cancel":
+		return p.eachDownload(args, func(hash string) (*control.DownloadInfo, error) {
+			return nil, p.client.Cancel(hash)
+		})
+	case "move":
+		return p.move(args)
+	case "queue":
+		return p.queue(args)
+	case "peers":
+		return p.peers()
+	case "stats", "status":
+		return p.stats()
+	case "limits":
+		return p.limits(args)
+	case "events":
+		return p.events(args)
+	case "torrent":
+		return p.torrent(args)
+	default:
+		return fmt.Errorf("unknown command %q (run peerctl -h)", cmd)
+	}
+}
+
+func (p *peerctl) listShares() error {
+	shares, err := p.client.Shares()
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(shares)
+	}
+
+	w := p.table("HASH", "NAME", "SIZE", "CHUNKS", "PATH")
+	for _, s := range shares {
+		path := s.Path
+		if s.Stored {
+			path = "(chunk store)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", shortHash(s.Hash), s.Name, formatSize(s.Size), s.Chunks, path)
+	}
+	return w.Flush()
+}
+
+func (p *peerctl) export(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: export <hash> [path]")
+	}
+	path := "."
+	if len(args) == 2 {
+		path = args[1]
+	}
+	// The daemon resolves paths from its own working directory
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	shares, err := p.client.Shares()
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(shares))
+	for i, s := range shares {
+		hashes[i] = s.Hash
+	}
+	hash, err := resolveHash(args[0], hashes)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Export(hash, abs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", shortHash(hash), err)
+	}
+	if p.json {
+		return p.printJSON(resp)
+	}
+	fmt.Fprintf(p.out, "Exported %s to %s (%s)\n", shortHash(hash), resp.Path, formatSize(resp.Size))
+	return nil
+}
+
+func (p *peerctl) share(paths []string) error {
+	visibility := ""
+	if len(paths) > 0 && (paths[0] == "-private" || paths[0] == "-unlisted") {
+		visibility, paths = paths[0][1:], paths[1:]
+	}
+
+	var shared []*control.ShareInfo
+	for _, path := range paths {
+		// The daemon resolves paths from its own working directory
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		info, err := p.client.Share(abs, visibility)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		shared = append(shared, info)
+		if !p.json {
+			fmt.Fprintf(p.out, "Shared %s\n  Hash:   %s\n  Magnet: %s\n", info.Name, info.Hash, info.Magnet)
+		}
+	}
+	if p.json {
+		return p.printJSON(shared)
+	}
+	return nil
+}
+
+func (p *peerctl) download(targets []string) error {
+	shareToken := ""
+	if len(targets) > 1 && targets[0] == "-share" {
+		shareToken, targets = targets[1], targets[2:]
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("usage: download [-share token] <hash|magnet|file.p2pmeta|file.torrent>...")
+	}
+
+	var queued []*control.DownloadInfo
+	for _, target := range targets {
+		var info *control.DownloadInfo
+		var err error
+		if strings.HasSuffix(target, metafile.Extension) {
+			// The metafile is read here: the daemon may not see the same files
+			var data []byte
+			if data, err = os.ReadFile(target); err == nil {
+				info, err = p.client.DownloadMeta(data)
+			}
+		} else if strings.HasSuffix(target, torrent.Extension) {
+			// Found through the info hashes of a torrent imported by another peer
+			var link string
+			if link, err = torrentMagnet(target); err == nil {
+				info, err = p.client.Download(link, shareToken)
+			}
+		} else {
+			info, err = p.client.Download(target, shareToken)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", shortHash(target), err)
+		}
+		queued = append(queued, info)
+		if !p.json {
+			fmt.Fprintf(p.out, "Queued %s (%s)\n", displayName(info), info.Status)
+		}
+	}
+	if p.json {
+		return p.printJSON(queued)
+	}
+	return nil
+}
+
+func (p *peerctl) listDownloads() error {
+	downloads, err := p.client.Downloads()
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(downloads)
+	}
+
+	w := p.table("HASH", "NAME", "STATUS", "PROGRESS", "SPEED", "SIZE")
+	for _, d := range downloads {
+		status := d.Status
+		if d.Position > 0 {
+			status = fmt.Sprintf("%s #%d", status, d.Position)
+		}
+		speed := "-"
+		if d.Speed > 0 {
+			speed = throttle.FormatRate(int64(d.Speed))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1f%%\t%s\t%s\n",
+			shortHash(d.Hash), d.Name, status, d.Progress, speed, formatSize(d.Size))
+	}
+	return w.Flush()
+}
+
+func (p *peerctl) showDownload(prefix string) error {
+	hash, err := p.resolveDownload(prefix)
+	if err != nil {
+		return err
+	}
+	d, err := p.client.GetDownload(hash)
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(d)
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Hash:\t%s\n", d.Hash)
+	fmt.Fprintf(w, "Name:\t%s\n", d.Name)
+	fmt.Fprintf(w, "Status:\t%s\n", d.Status)
+	if d.Position > 0 {
+		fmt.Fprintf(w, "Queue position:\t%d\n", d.Position)
+	}
+	fmt.Fprintf(w, "Progress:\t%.1f%% of %s\n", d.Progress, formatSize(d.Size))
+	if d.Speed > 0 {
+		fmt.Fprintf(w, "Speed:\t%s\n", throttle.FormatRate(int64(d.Speed)))
+	}
+	if d.OutputPath != "" {
+		fmt.Fprintf(w, "Output:\t%s\n", d.OutputPath)
+	}
+	if d.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", d.Error)
+	}
+	return w.Flush()
+}
+
+func (p *peerctl) move(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: move <hash> <position>")
+	}
+	position, err := strconv.Atoi(args[1])
+	if err != nil || position < 1 {
+		return fmt.Errorf("invalid position %q", args[1])
+	}
+	return p.eachDownload(args[:1], func(hash string) (*control.DownloadInfo, error) {
+		return p.client.Move(hash, position)
+	})
+}
+
+func (p *peerctl) queue(args []string) error {
+	var queue *control.QueueResponse
+	var err error
+	if len(args) > 0 {
+		n, convErr := strconv.Atoi(args[0])
+		if convErr != nil || n < 1 {
+			return fmt.Errorf("invalid max-active %q", args[0])
+		}
+		queue, err = p.client.SetMaxActive(n)
+	} else {
+		queue, err = p.client.Queue()
+	}
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(queue)
+	}
+
+	fmt.Fprintf(p.out, "Max active downloads: %d\n", queue.MaxActive)
+	w := p.table("HASH", "NAME", "STATE", "POSITION", "ADDED")
+	for _, e := range queue.Entries {
+		position := "-"
+		if e.Position > 0 {
+			position = strconv.Itoa(e.Position)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			shortHash(e.Hash), e.Name, e.State, position, e.AddedAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func (p *peerctl) peers() error {
+	peers, err := p.client.Peers()
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(peers)
+	}
+
+	fmt.Fprintln(p.out, "Uploading to:")
+	w := p.table("ADDRESS", "PEER", "CONNECTED", "CHUNKS", "SENT")
+	for _, c := range peers.Connections {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", c.RemoteAddr, shortHash(c.PeerID),
+			time.Since(c.ConnectedAt).Round(time.Second), c.ChunksServed, formatSize(c.BytesServed))
+	}
+	w.Flush()
+
+	for _, d := range peers.Downloads {
+		fmt.Fprintf(p.out, "\nDownloading %s from:\n", shortHash(d.Hash))
+		sort.Slice(d.Peers, func(i, j int) bool { return d.Peers[i].Score > d.Peers[j].Score })
+		w := p.table("PEER", "CHUNKS", "RECEIVED", "FAILURES", "LATENCY", "SCORE")
+		for _, peer := range d.Peers {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%dms\t%.1f\n", shortHash(peer.PeerID), peer.Chunks,
+				formatSize(peer.Bytes), peer.Failures, peer.AvgLatencyMs, peer.Score)
+		}
+		w.Flush()
+	}
+	return nil
+}
+
+func (p *peerctl) stats() error {
+	status, err := p.client.Status()
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(status)
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Peer ID:\t%s\n", status.PeerID)
+	for _, tracker := range status.Trackers {
+		state := "up"
+		if !tracker.Up {
+			state = fmt.Sprintf("down (%s)", tracker.LastError)
+		}
+		fmt.Fprintf(w, "Tracker:\t%s %s\n", tracker.URL, state)
+	}
+	if status.Port > 0 {
+		fmt.Fprintf(w, "P2P port:\t%d\n", status.Port)
+	}
+	fmt.Fprintf(w, "Uptime:\t%s\n", time.Since(status.StartedAt).Round(time.Second))
+	fmt.Fprintf(w, "Shared files:\t%d\n", status.SharedFiles)
+	fmt.Fprintf(w, "Downloads:\t%d active, %d queued, %d paused\n", status.Active, status.Queued, status.Paused)
+	fmt.Fprintf(w, "Connections:\t%d\n", status.Connections)
+	storage := formatSize(status.StorageUsed)
+	if status.StorageQuota > 0 {
+		storage += " / " + formatSize(status.StorageQuota)
+	}
+	fmt.Fprintf(w, "Storage:\t%s\n", storage)
+	if cs := status.ChunkStore; cs != nil {
+		fmt.Fprintf(w, "Chunk store:\t%d chunks, %s\n", cs.Chunks, formatSize(cs.Bytes))
+	}
+	fmt.Fprintf(w, "Upload:\t%s\n", throttle.FormatRate(status.UploadRate))
+	fmt.Fprintf(w, "Download:\t%s\n", throttle.FormatRate(status.DownloadRate))
+	return w.Flush()
+}
+
+func (p *peerctl) limits(args []string) error {
+	var bw *control.BandwidthResponse
+	var err error
+	switch len(args) {
+	case 0:
+		bw, err = p.client.Bandwidth()
+	case 2:
+		bw, err = p.client.SetBandwidth(args[0], args[1])
+	default:
+		return fmt.Errorf("usage: limits [upload download]")
+	}
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(bw)
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\tUPLOAD\tDOWNLOAD\n")
+	fmt.Fprintf(w, "Current limit\t%s\t%s\n", throttle.FormatRate(bw.UploadLimit), throttle.FormatRate(bw.DownloadLimit))
+	fmt.Fprintf(w, "Default limit\t%s\t%s\n", throttle.FormatRate(bw.DefaultUpload), throttle.FormatRate(bw.DefaultDownload))
+	fmt.Fprintf(w, "Rate\t%s\t%s\n", formatSize(bw.UploadRate)+"/s", formatSize(bw.DownloadRate)+"/s")
+	fmt.Fprintf(w, "Total\t%s\t%s\n", formatSize(bw.TotalUploaded), formatSize(bw.TotalDownloaded))
+	w.Flush()
+	for _, rule := range bw.Schedule {
+		fmt.Fprintf(p.out, "Schedule: %s\n", rule)
+	}
+	return nil
+}
+
+func (p *peerctl) events(types []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	encoder := json.NewEncoder(p.out)
+	return p.client.Events(ctx, types, func(event events.Event) {
+		if p.json {
+			encoder.Encode(event)
+			return
+		}
+		data, _ := json.Marshal(event.Data)
+		fmt.Fprintf(p.out, "%s  %-20s %s\n", event.Time.Local().Format(time.TimeOnly), event.Type, data)
+	})
+}
+
+// eachDownload applies action to downloads given by hash prefix
+func (p *peerctl) eachDownload(prefixes []string, action func(hash string) (*control.DownloadInfo, error)) error {
+	if len(prefixes) == 0 {
+		return fmt.Errorf("missing download hash")
+	}
+
+	var results []*control.DownloadInfo
+	for _, prefix := range prefixes {
+		hash, err := p.resolveDownload(prefix)
+		if err != nil {
+			return err
+		}
+		info, err := action(hash)
+		if err != nil {
+			return fmt.Errorf("%s: %w", shortHash(hash), err)
+		}
+		if info != nil {
+			results = append(results, info)
+		}
+		if !p.json {
+			if info != nil {
+				fmt.Fprintf(p.out, "%s: %s\n", displayName(info), info.Status)
+			} else {
+				fmt.Fprintf(p.out, "%s: done\n", shortHash(hash))
+			}
+		}
+	}
+	if p.json && len(results) > 0 {
+		return p.printJSON(results)
+	}
+	return nil
+}
+
+// eachShare applies action to shared files given by hash prefix
+func (p *peerctl) eachShare(prefixes []string, action func(hash string) error) error {
+	if len(prefixes) == 0 {
+		return fmt.Errorf("missing file hash")
+	}
+	shares, err := p.client.Shares()
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(shares))
+	for i, s := range shares {
+		hashes[i] = s.Hash
+	}
+
+	for _, prefix := range prefixes {
+		hash, err := resolveHash(prefix, hashes)
+		if err != nil {
+			return err
+		}
+		if err := action(hash); err != nil {
+			return fmt.Errorf("%s: %w", shortHash(hash), err)
+		}
+		if !p.json {
+			fmt.Fprintf(p.out, "%s: no longer shared\n", shortHash(hash))
+		}
+	}
+	return nil
+}
+
+// resolveDownload expands a hash prefix using the peer's downloads
+func (p *peerctl) resolveDownload(prefix string) (string, error) {
+	downloads, err := p.client.Downloads()
+	if err != nil {
+		return "", err
+	}
+	hashes := make([]string, len(downloads))
+	for i, d := range downloads {
+		hashes[i] = d.Hash
+	}
+	return resolveHash(prefix, hashes)
+}
+
+// resolveHash returns the only hash starting with prefix. An unknown prefix
+// is returned unchanged so the peer can report it.
+func resolveHash(prefix string, hashes []string) (string, error) {
+	var matches []string
+	for _, hash := range hashes {
+		if hash == prefix {
+			return hash, nil
+		}
+		if strings.HasPrefix(hash, prefix) {
+			matches = append(matches, hash)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return prefix, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("hash prefix %q is ambiguous (%d matches)", prefix, len(matches))
+	}
+}
+
+// table returns a tabwriter with a header row written
+func (p *peerctl) table(columns ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return w
+}
+
+func (p *peerctl) printJSON(v any) error {
+	encoder := json.NewEncoder(p.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func displayName(d *control.DownloadInfo) string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return shortHash(d.Hash)
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	if hash == "" {
+		return "-"
+	}
+	return hash
+}
+
+// formatSize formats a byte count with binary units
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/metafile"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/config"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+const metaUsage = `usage:
+  meta create [flags] <file>        Write <file>.p2pmeta (no tracker is contacted)
+  meta show <file.p2pmeta>          Show a metafile and check its signature
+  meta verify <file.p2pmeta> <file> Check that a local file matches a metafile
+  meta keygen <key.pem>             Create a publisher key for signing metafiles`
+
+// meta runs the .p2pmeta commands. They work on local files and do not need a
+// running peer.
+func (p *peerctl) meta(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", metaUsage)
+	}
+	switch args[0] {
+	case "create":
+		return p.metaCreate(args[1:])
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: meta show <file.p2pmeta>")
+		}
+		return p.metaShow(args[1])
+	case "verify":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: meta verify <file.p2pmeta> <file>")
+		}
+		return p.metaVerify(args[1], args[2])
+	case "keygen":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: meta keygen <key.pem>")
+		}
+		key, err := metafile.GenerateKey(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "Wrote %s\n  Public key: %s\n", args[1], metafile.PublicKey(key))
+		return nil
+	default:
+		return fmt.Errorf("unknown meta command %q\n%s", args[0], metaUsage)
+	}
+}
+
+func (p *peerctl) metaCreate(args []string) error {
+	fs := flag.NewFlagSet("meta create", flag.ContinueOnError)
+	output := fs.String("o", "", "Output path (default: <file name>.p2pmeta in the current directory)")
+	trackers := fs.String("tracker", "", "Tracker URLs to record (comma-separated)")
+	webSeeds := fs.String("webseed", "", "HTTP(S) URLs serving the whole file (comma-separated)")
+	keyPath := fs.String("key", "", "Publisher key to sign the metafile with (see meta keygen)")
+	comment := fs.String("comment", "", "Free-form comment")
+	mode := fs.String("chunking", "fixed", "Chunking mode: fixed or cdc")
+	chunkSize := fs.String("chunk-size", "256KB", "Chunk size (average size with cdc)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: meta create [flags] <file>")
+	}
+	path := fs.Arg(0)
+
+	size, err := storage.ParseSize(*chunkSize)
+	if err != nil {
+		return fmt.Errorf("-chunk-size: %w", err)
+	}
+	c, err := chunker.NewWithMode(*mode, size)
+	if err != nil {
+		return err
+	}
+	m, err := metafile.Create(path, c, config.SplitList(*trackers), config.SplitList(*webSeeds))
+	if err != nil {
+		return err
+	}
+	m.Comment = *comment
+	if *keyPath != "" {
+		key, err := metafile.LoadKey(*keyPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *keyPath, err)
+		}
+		if err := m.Sign(key); err != nil {
+			return err
+		}
+	}
+
+	if *output == "" {
+		*output = filepath.Base(path) + metafile.Extension
+	}
+	if err := m.Save(*output); err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(m)
+	}
+	fmt.Fprintf(p.out, "Wrote %s\n  Hash:   %s\n  Chunks: %d\n", *output, m.File.Hash, len(m.File.Chunks))
+	return nil
+}
+
+func (p *peerctl) metaShow(path string) error {
+	m, err := metafile.Load(path)
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(m)
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", m.File.Name)
+	fmt.Fprintf(w, "Size:\t%s (%d bytes)\n", formatSize(m.File.Size), m.File.Size)
+	fmt.Fprintf(w, "Hash:\t%s\n", m.File.Hash)
+	fmt.Fprintf(w, "Chunks:\t%d of %s (%s)\n", len(m.File.Chunks), formatSize(m.File.ChunkSize), m.File.Chunking)
+	if m.File.MerkleRoot != "" {
+		fmt.Fprintf(w, "Merkle root:\t%s\n", m.File.MerkleRoot)
+	}
+	if len(m.Trackers) > 0 {
+		fmt.Fprintf(w, "Trackers:\t%s\n", strings.Join(m.Trackers, ", "))
+	}
+	if len(m.WebSeeds) > 0 {
+		fmt.Fprintf(w, "Web seeds:\t%s\n", strings.Join(m.WebSeeds, ", "))
+	}
+	fmt.Fprintf(w, "Created:\t%s\n", m.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	if m.Comment != "" {
+		fmt.Fprintf(w, "Comment:\t%s\n", m.Comment)
+	}
+	if publisher := m.Publisher(); publisher != "" {
+		// Load rejects bad signatures, so a loaded signature is valid
+		fmt.Fprintf(w, "Publisher:\t%s (signature valid)\n", publisher)
+	} else {
+		fmt.Fprintf(w, "Publisher:\tunsigned\n")
+	}
+	fmt.Fprintf(w, "Magnet:\t%s\n", m.Magnet())
+	return w.Flush()
+}
+
+func (p *peerctl) metaVerify(metaPath, path string) error {
+	m, err := metafile.Load(metaPath)
+	if err != nil {
+		return err
+	}
+	sum, err := hash.CalculateFile(path)
+	if err != nil {
+		return err
+	}
+	if sum != m.File.Hash {
+		return fmt.Errorf("%s does not match %s: hash is %s, want %s", path, metaPath, sum, m.File.Hash)
+	}
+	fmt.Fprintf(p.out, "%s matches %s\n", path, metaPath)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/torrent"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/config"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/control"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+const torrentUsage = `usage:
+  torrent show <file.torrent>           Show a .torrent file (no peer needed)
+  torrent import <file.torrent> <dir>   Check the torrent's content in <dir> and share it
+  torrent export [flags] <hash>         Write a .torrent file for a shared file`
+
+// torrent runs the BitTorrent commands
+func (p *peerctl) torrent(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", torrentUsage)
+	}
+	switch args[0] {
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: torrent show <file.torrent>")
+		}
+		return p.torrentShow(args[1])
+	case "import":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: torrent import <file.torrent> <dir>")
+		}
+		return p.torrentImport(args[1], args[2])
+	case "export":
+		return p.torrentExport(args[1:])
+	default:
+		return fmt.Errorf("unknown torrent command %q\n%s", args[0], torrentUsage)
+	}
+}
+
+func (p *peerctl) torrentShow(path string) error {
+	t, err := torrent.Load(path)
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(t)
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", t.Name)
+	fmt.Fprintf(w, "Size:\t%s (%d bytes)\n", formatSize(t.TotalSize()), t.TotalSize())
+	fmt.Fprintf(w, "Pieces:\t%s each\n", formatSize(t.PieceLength))
+	if t.InfoHash != "" {
+		fmt.Fprintf(w, "Info hash (v1):\t%s\n", t.InfoHash)
+	}
+	if t.InfoHashV2 != "" {
+		fmt.Fprintf(w, "Info hash (v2):\t%s\n", t.InfoHashV2)
+	}
+	if len(t.Trackers) > 0 {
+		fmt.Fprintf(w, "Trackers:\t%s\n", strings.Join(t.Trackers, ", "))
+	}
+	if len(t.WebSeeds) > 0 {
+		fmt.Fprintf(w, "Web seeds:\t%s\n", strings.Join(t.WebSeeds, ", "))
+	}
+	if !t.CreationDate.IsZero() {
+		fmt.Fprintf(w, "Created:\t%s\n", t.CreationDate.Local().Format("2006-01-02 15:04:05"))
+	}
+	if t.Comment != "" {
+		fmt.Fprintf(w, "Comment:\t%s\n", t.Comment)
+	}
+	fmt.Fprintf(w, "Magnet:\t%s\n", t.Magnet())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !t.SingleFile() {
+		fmt.Fprintln(p.out)
+		files := p.table("SIZE", "PATH")
+		for _, f := range t.Files {
+			if !f.Padding {
+				fmt.Fprintf(files, "%s\t%s\n", formatSize(f.Length), filepath.Join(append([]string{t.Name}, f.Path...)...))
+			}
+		}
+		return files.Flush()
+	}
+	return nil
+}
+
+func (p *peerctl) torrentImport(path, dir string) error {
+	// The torrent is read here, the content by the daemon
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.ImportTorrent(data, abs)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if p.json {
+		return p.printJSON(resp)
+	}
+
+	fmt.Fprintf(p.out, "Imported %s (%d files)\n", resp.Name, len(resp.Shares))
+	w := p.table("HASH", "NAME", "SIZE")
+	for _, s := range resp.Shares {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", shortHash(s.Hash), s.Name, formatSize(s.Size))
+	}
+	return w.Flush()
+}
+
+func (p *peerctl) torrentExport(args []string) error {
+	fs := flag.NewFlagSet("torrent export", flag.ContinueOnError)
+	output := fs.String("o", "", "Output path (default: <file name>.torrent in the current directory)")
+	trackers := fs.String("tracker", "", "BitTorrent tracker announce URLs (comma-separated)")
+	webSeeds := fs.String("webseed", "", "HTTP(S) URLs serving the whole file (comma-separated)")
+	comment := fs.String("comment", "", "Free-form comment")
+	pieceLength := fs.String("piece-length", "256KB", "Piece length, a power of two of at least 16KB")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: torrent export [flags] <hash>")
+	}
+	length, err := storage.ParseSize(*pieceLength)
+	if err != nil {
+		return fmt.Errorf("-piece-length: %w", err)
+	}
+
+	shares, err := p.client.Shares()
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(shares))
+	for i, s := range shares {
+		hashes[i] = s.Hash
+	}
+	hash, err := resolveHash(fs.Arg(0), hashes)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.ExportTorrent(hash, control.TorrentExportRequest{
+		Trackers:    config.SplitList(*trackers),
+		WebSeeds:    config.SplitList(*webSeeds),
+		Comment:     *comment,
+		PieceLength: length,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", shortHash(hash), err)
+	}
+
+	if *output == "" {
+		t, err := torrent.Parse(resp.Torrent)
+		if err != nil {
+			return err
+		}
+		*output = t.Name + torrent.Extension
+	}
+	if err := os.WriteFile(*output, resp.Torrent, 0644); err != nil {
+		return err
+	}
+	if p.json {
+		return p.printJSON(resp)
+	}
+	fmt.Fprintf(p.out, "Wrote %s\n  Info hash (v1): %s\n  Info hash (v2): %s\n  Magnet: %s\n",
+		*output, resp.InfoHash, resp.InfoHashV2, resp.Magnet)
+	return nil
+}
+
+// torrentMagnet returns the magnet link of a .torrent file, which a peer
+// resolves through the trackers that know the imported torrent
+func torrentMagnet(path string) (string, error) {
+	t, err := torrent.Load(path)
+	if err != nil {
+		return "", err
+	}
+	m := t.Magnet()
+	// BitTorrent trackers do not know our peers
+	m.Trackers = nil
+	return m.String(), nil
+}
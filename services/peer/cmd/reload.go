@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/client"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/config"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// loadConfig loads the config file and environment, applies the flags given
+// on the command line and validates the result
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyFlags(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyFlags overrides cfg with the flags set explicitly on the command line
+func applyFlags(cfg *config.Config) error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "tracker":
+			cfg.Tracker.URLs = config.SplitList(value)
+		case "port":
+			cfg.Peer.Port = f.Value.(flag.Getter).Get().(int)
+		case "data":
+			cfg.Peer.DataDir = value
+		case "daemon":
+			cfg.Peer.Daemon = f.Value.(flag.Getter).Get().(bool)
+		case "api-key":
+			cfg.Tracker.APIKey = value
+		case "upload-limit":
+			cfg.Bandwidth.UploadLimit = value
+		case "download-limit":
+			cfg.Bandwidth.DownloadLimit = value
+		case "bandwidth-schedule":
+			cfg.Bandwidth.Schedule = nil
+			schedule, parseErr := throttle.ParseSchedule(value)
+			if parseErr != nil {
+				err = fmt.Errorf("invalid -bandwidth-schedule: %w", parseErr)
+				return
+			}
+			for _, rule := range schedule.Rules {
+				cfg.Bandwidth.Schedule = append(cfg.Bandwidth.Schedule, rule.String())
+			}
+		case "storage-quota":
+			cfg.Storage.Quota = value
+		case "min-free-space":
+			cfg.Storage.MinFreeSpace = value
+		case "evict":
+			cfg.Storage.Evict = value
+		case "chunking":
+			cfg.Chunking.Mode = value
+		case "chunk-size":
+			cfg.Chunking.ChunkSize = value
+		case "chunk-store":
+			cfg.Storage.ChunkStore = f.Value.(flag.Getter).Get().(bool)
+		case "control":
+			cfg.Control.Addr = value
+		case "control-token":
+			cfg.Control.Token = value
+		case "max-active-downloads":
+			cfg.Downloads.MaxActive = f.Value.(flag.Getter).Get().(int)
+		case "metrics":
+			cfg.Metrics.Addr = value
+		}
+	})
+	return err
+}
+
+// reloader applies a changed configuration on SIGHUP. Bandwidth limits, the
+// download queue size, shared directories and trackers change live; other
+// settings need a restart.
+type reloader struct {
+	configPath string
+	current    *config.Config
+	scheduler  *throttle.Scheduler
+	downloads  *downloader.Manager
+	tracker    *client.TrackerClient
+	store      *storage.LocalStorage
+	shared     *sharedDirs // nil unless in daemon mode
+	publicIP   string
+	port       int
+}
+
+// watch reloads the configuration on every SIGHUP
+func (r *reloader) watch() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	for range sigChan {
+		log.Printf("[Config] Reloading...")
+		if err := r.Reload(); err != nil {
+			log.Printf("[Config] Reload failed, keeping current settings: %v", err)
+		}
+	}
+}
+
+// Reload loads the configuration again and applies what changed
+func (r *reloader) Reload() error {
+	next, err := loadConfig(r.configPath)
+	if err != nil {
+		return err
+	}
+	prev := r.current
+
+	if !reflect.DeepEqual(prev.Bandwidth, next.Bandwidth) {
+		schedule, _ := next.BandwidthSchedule()
+		r.scheduler.SetDefaultLimits(schedule.DefaultUpload, schedule.DefaultDownload)
+		r.scheduler.SetSchedule(schedule.Rules)
+		log.Printf("[Config] Bandwidth: up=%s down=%s, %d schedule rules",
+			throttle.FormatRate(schedule.DefaultUpload), throttle.FormatRate(schedule.DefaultDownload), len(schedule.Rules))
+	}
+
+	if prev.Downloads.MaxActive != next.Downloads.MaxActive {
+		r.downloads.SetMaxActive(next.Downloads.MaxActive)
+		log.Printf("[Config] Max active downloads: %d", next.Downloads.MaxActive)
+	}
+
+	if !slices.Equal(prev.Tracker.URLs, next.Tracker.URLs) {
+		r.switchTrackers(next.Tracker.URLs)
+	}
+
+	if r.shared != nil {
+		r.shared.Update(next.SharedDirs())
+		if prev.Shared.ScanInterval != next.Shared.ScanInterval {
+			r.shared.SetInterval(next.Shared.ScanInterval)
+		}
+	}
+
+	for _, name := range restartRequired(prev, next) {
+		log.Printf("[Config] Changes to %s need a restart", name)
+	}
+
+	r.current = next
+	return nil
+}
+
+// switchTrackers registers with the new list of trackers and announces every
+// shared file to them
+func (r *reloader) switchTrackers(trackerURLs []string) {
+	log.Printf("[Config] Switching trackers to %s", strings.Join(trackerURLs, ", "))
+	r.tracker.SetTrackers(trackerURLs)
+	if _, err := r.tracker.Register(r.publicIP, r.port); err != nil {
+		log.Printf("[Config] Failed to register with the trackers: %v", err)
+		return
+	}
+	for _, shared := range r.store.ListSharedFiles() {
+		if _, err := r.tracker.AnnounceFile(shared.Metadata); err != nil {
+			log.Printf("[Config] Error announcing %s: %v", shared.Metadata.Name, err)
+		}
+	}
+}
+
+// restartRequired lists the changed settings that cannot be applied live
+func restartRequired(prev, next *config.Config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+
+	check("peer", prev.Peer, next.Peer)
+	check("storage", prev.Storage, next.Storage)
+	check("chunking", prev.Chunking, next.Chunking)
+	check("relay", prev.Relay, next.Relay)
+	check("control", prev.Control, next.Control)
+	check("metrics", prev.Metrics, next.Metrics)
+	check("tracker.api_key", prev.Tracker.APIKey, next.Tracker.APIKey)
+	check("tracker.timeout", prev.Tracker.Timeout, next.Tracker.Timeout)
+	check("tracker.retries", prev.Tracker.Retries, next.Tracker.Retries)
+	check("tracker.heartbeat_interval", prev.Tracker.HeartbeatInterval, next.Tracker.HeartbeatInterval)
+	check("downloads.workers", prev.Downloads.Workers, next.Downloads.Workers)
+	check("downloads.retries", prev.Downloads.Retries, next.Downloads.Retries)
+	check("downloads.chunk_timeout", prev.Downloads.ChunkTimeout, next.Downloads.ChunkTimeout)
+	return changed
+}
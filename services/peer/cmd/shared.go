@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/scanner"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// sharedDirs keeps the shared directories in sync with the tracker. The set of
+// directories and the scan interval can change while the peer runs.
+type sharedDirs struct {
+	mu       sync.Mutex // Held during scans; scanners are not safe for concurrent use
+	store    *storage.LocalStorage
+	chunker  *chunker.Chunker
+	cache    *scanner.HashCache
+	tracker  scanner.Announcer
+	scanners map[string]*scanner.Scanner
+	interval chan time.Duration
+	current  time.Duration
+}
+
+func newSharedDirs(store *storage.LocalStorage, c *chunker.Chunker, cache *scanner.HashCache, tracker scanner.Announcer, interval time.Duration) *sharedDirs {
+	return &sharedDirs{
+		store:    store,
+		chunker:  c,
+		cache:    cache,
+		tracker:  tracker,
+		scanners: make(map[string]*scanner.Scanner),
+		interval: make(chan time.Duration, 1),
+		current:  interval,
+	}
+}
+
+// Update shares new directories right away and stops sharing the files of
+// directories that are no longer listed
+func (d *sharedDirs) Update(dirs []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for dir, s := range d.scanners {
+		if slices.Contains(dirs, dir) {
+			continue
+		}
+		removed := s.StopSharing()
+		delete(d.scanners, dir)
+		log.Printf("Stopped sharing %s (%d files)", dir, removed)
+	}
+
+	for _, dir := range dirs {
+		if _, ok := d.scanners[dir]; ok {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("Failed to create shared directory: %v", err)
+			continue
+		}
+		log.Printf("Shared directory: %s", dir)
+		d.scanners[dir] = scanner.New(dir, d.store, d.chunker, d.cache, d.tracker)
+		scanAndShareFiles(d.scanners[dir], dir)
+	}
+}
+
+// SetInterval changes how often the directories are scanned
+func (d *sharedDirs) SetInterval(interval time.Duration) {
+	select {
+	case <-d.interval:
+	default:
+	}
+	d.interval <- interval
+}
+
+// Run periodically scans for new, modified and deleted files
+func (d *sharedDirs) Run() {
+	ticker := time.NewTicker(d.current)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.scanAll()
+		case interval := <-d.interval:
+			d.current = interval
+			ticker.Reset(interval)
+		}
+	}
+}
+
+func (d *sharedDirs) scanAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for dir, s := range d.scanners {
+		scanAndShareFiles(s, dir)
+	}
+}
+
+// scanAndShareFiles syncs a shared directory with the tracker: new files are
+// announced, modified files re-announced and deleted files withdrawn
+func scanAndShareFiles(fileScanner *scanner.Scanner, sharedDir string) {
+	result := fileScanner.Scan()
+
+	if result.Added > 0 || result.Changed > 0 || result.Removed > 0 {
+		log.Printf("Scanned %s: %d new, %d modified, %d removed (%d hashed, %d announced)",
+			sharedDir, result.Added, result.Changed, result.Removed, result.Rehashed, result.Announced)
+	}
+	if result.Failed > 0 {
+		log.Printf("Scan of %s: %d files failed", sharedDir, result.Failed)
+	}
+}
@@ -2,157 +2,931 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/trackerapi"
 )
 
-// TrackerClient handles communication with the tracker server
+// TrackerClient handles communication with the tracker servers. A peer can be
+// configured with several trackers: it registers, heartbeats and announces to
+// all of them, merges the peers they return, and reads from the first tracker
+// that answers so a single outage does not stop downloads.
+//
+// Failed requests are retried with exponential backoff. A tracker that forgot
+// this peer (restarted with in-memory storage, or dropped it while offline) is
+// registered with again on the next heartbeat, and the shared files are
+// announced to it again.
+//
+// With an owner secret, the peer registers with an owner identity that
+// survives its restarts: the owner of the files it announces, who can make
+// them private. Each tracker returns a session token proving the identity,
+// sent with every request, and the key of the grants to download private
+// files, checked before serving their chunks.
 type TrackerClient struct {
-	baseURL    string
-	httpClient *http.Client
-	peerID     string
-	apiKey     string
+	mu           sync.RWMutex
+	trackers     []string
+	fileTrackers map[string][]string       // Extra trackers per file hash, from magnet links
+	health       map[string]*trackerHealth // By tracker URL
+	registration *protocol.RegisterRequest // Set by Register, sent again to trackers that forgot this peer
+	sharedFiles  func() []*protocol.FileMetadata
+	transfers    func() map[string]protocol.Transfer
+	reported     map[string]map[string]protocol.ChunkSet // Tracker URL -> file hash -> chunks of a download the tracker knows
+	ownerSecret  []byte
+	peerKey      string            // Base64 public key this peer proves its ID to seeders with
+	shareTokens  map[string]string // File hash -> share token of a private file
+	httpClient   *http.Client
+	peerID       string
+	apiKey       string
+	retries      int
+	retryDelay   time.Duration
+}
+
+// trackerHealth records recent failures of a tracker
+type trackerHealth struct {
+	failures   int // Consecutive failed requests
+	lastError  string
+	registered bool
+
+	// Returned by the last registration
+	session   string
+	ownerID   string
+	accessKey ed25519.PublicKey
+}
+
+// Retry defaults: a request is tried DefaultRetries more times, waiting
+// about DefaultRetryDelay, then twice as long each time up to maxRetryDelay
+const (
+	DefaultRetries    = 3
+	DefaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 8 * time.Second
+)
+
+// TrackerStatus reports the health of a tracker
+type TrackerStatus struct {
+	URL       string `json:"url"`
+	Up        bool   `json:"up"`
+	Failures  int    `json:"failures,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// StatusError is returned when a tracker answers with an error status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request failed with status: %d", e.StatusCode)
 }
 
 // NewTrackerClient creates a new tracker client
 func NewTrackerClient(trackerURL, peerID string) *TrackerClient {
-	return &TrackerClient{
-		baseURL: trackerURL,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		peerID: peerID,
-	}
+	return NewMultiTrackerClient([]string{trackerURL}, peerID, "")
 }
 
 // NewTrackerClientWithAPIKey creates a new tracker client with API key authentication
 func NewTrackerClientWithAPIKey(trackerURL, peerID, apiKey string) *TrackerClient {
-	return &TrackerClient{
-		baseURL: trackerURL,
+	return NewMultiTrackerClient([]string{trackerURL}, peerID, apiKey)
+}
+
+// NewMultiTrackerClient creates a client for several trackers. The API key,
+// if any, is sent to all of them.
+func NewMultiTrackerClient(trackerURLs []string, peerID, apiKey string) *TrackerClient {
+	c := &TrackerClient{
+		fileTrackers: make(map[string][]string),
+		health:       make(map[string]*trackerHealth),
+		reported:     make(map[string]map[string]protocol.ChunkSet),
+		shareTokens:  make(map[string]string),
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		peerID: peerID,
-		apiKey: apiKey,
+		peerID:     peerID,
+		apiKey:     apiKey,
+		retries:    DefaultRetries,
+		retryDelay: DefaultRetryDelay,
+	}
+	c.SetTrackers(trackerURLs)
+	return c
+}
+
+// SetTrackers replaces the configured trackers. The caller re-registers and
+// re-announces its files.
+func (c *TrackerClient) SetTrackers(trackerURLs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trackers = normalizeURLs(trackerURLs)
+}
+
+// Trackers returns the configured trackers
+func (c *TrackerClient) Trackers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.trackers)
+}
+
+// AddFileTrackers adds trackers to ask for the peers of one file, typically
+// the tr= entries of a magnet link
+func (c *TrackerClient) AddFileTrackers(fileHash string, trackerURLs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, url := range normalizeURLs(trackerURLs) {
+		if !slices.Contains(c.fileTrackers[fileHash], url) {
+			c.fileTrackers[fileHash] = append(c.fileTrackers[fileHash], url)
+		}
+	}
+}
+
+// Status reports the health of the configured trackers
+func (c *TrackerClient) Status() []TrackerStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	statuses := make([]TrackerStatus, 0, len(c.trackers))
+	for _, url := range c.trackers {
+		status := TrackerStatus{URL: url, Up: true}
+		if h := c.health[url]; h != nil && h.failures > 0 {
+			status.Up = false
+			status.Failures = h.failures
+			status.LastError = h.lastError
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SetTimeout sets the timeout of each tracker request
+func (c *TrackerClient) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
+// SetRetry sets how many times a failed request is retried and the delay
+// before the first retry
+func (c *TrackerClient) SetRetry(retries int, delay time.Duration) {
+	c.retries = retries
+	c.retryDelay = delay
+}
+
+// SetSharedFiles sets the source of the files announced again to a tracker
+// that forgot this peer
+func (c *TrackerClient) SetSharedFiles(files func() []*protocol.FileMetadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sharedFiles = files
+}
+
+// SetTransfers sets the source of the transfer counters of shared files and
+// downloads, sent with heartbeats and availability reports
+func (c *TrackerClient) SetTransfers(transfers func() map[string]protocol.Transfer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transfers = transfers
+}
+
+// transferOf returns the transfer counters of a file, zero without a source
+func (c *TrackerClient) transferOf(fileHash string) protocol.Transfer {
+	c.mu.RLock()
+	transfers := c.transfers
+	c.mu.RUnlock()
+	if transfers == nil {
+		return protocol.Transfer{}
+	}
+	return transfers()[fileHash]
+}
+
+// SetOwnerSecret sets the secret this peer proves its owner identity with.
+// It takes effect on the next registration.
+func (c *TrackerClient) SetOwnerSecret(secret []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ownerSecret = secret
+}
+
+// SetPeerKey sets the key this peer proves its ID to seeders with, which
+// trackers put into its grants. It takes effect on the next registration.
+func (c *TrackerClient) SetPeerKey(key ed25519.PublicKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peerKey = base64.StdEncoding.EncodeToString(key)
+}
+
+// SessionToken returns the session token a tracker issued to this peer,
+// empty if it is not registered with it
+func (c *TrackerClient) SessionToken(baseURL string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if h := c.health[baseURL]; h != nil {
+		return h.session
+	}
+	return ""
+}
+
+// OwnerIDs returns the owner identity of this peer on each tracker it
+// registered with. Trackers know a peer by a different identity each, which
+// is what their groups list.
+func (c *TrackerClient) OwnerIDs() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids := make(map[string]string)
+	for _, url := range c.trackers {
+		if h := c.health[url]; h != nil && h.ownerID != "" {
+			ids[url] = h.ownerID
+		}
+	}
+	return ids
+}
+
+// SetShareToken sets the share token sent when getting the peers of a
+// private file
+func (c *TrackerClient) SetShareToken(fileHash, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shareTokens[fileHash] = token
+}
+
+// VerifyGrant checks that a grant to download a private file was issued by
+// one of the trackers to a peer, and to the key it proved it holds
+func (c *TrackerClient) VerifyGrant(fileHash, peerID, peerKey, token string) error {
+	c.mu.RLock()
+	var keys []ed25519.PublicKey
+	for _, h := range c.health {
+		if h.accessKey != nil {
+			keys = append(keys, h.accessKey)
+		}
+	}
+	c.mu.RUnlock()
+
+	grant, err := access.Verify(token, access.KindGrant, keys)
+	if err != nil {
+		return err
+	}
+	if grant.File != fileHash || grant.Peer != peerID || grant.Key == "" || grant.Key != peerKey {
+		return access.ErrInvalidToken
 	}
+	return nil
 }
 
-// Register registers this peer with the tracker
+// Register registers this peer with every tracker. It fails only if no
+// tracker accepted the registration; the others are registered with on a
+// later heartbeat.
 func (c *TrackerClient) Register(ip string, port int) (*protocol.RegisterResponse, error) {
-	req := protocol.RegisterRequest{
+	c.mu.Lock()
+	c.registration = &protocol.RegisterRequest{
 		PeerID: c.peerID,
 		IP:     ip,
 		Port:   port,
 	}
+	c.mu.Unlock()
 
-	var resp protocol.RegisterResponse
-	err := c.post("/api/peers/register", req, &resp)
-	return &resp, err
+	var resp *protocol.RegisterResponse
+	err := c.broadcast("register", c.Trackers(), func(baseURL string) error {
+		r, err := c.registerWith(baseURL)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if resp == nil {
+			resp = r
+		}
+		c.mu.Unlock()
+		return nil
+	})
+	if resp == nil {
+		resp = &protocol.RegisterResponse{}
+	}
+	return resp, err
 }
 
-// Heartbeat sends a heartbeat to the tracker
+// Heartbeat sends a heartbeat to every tracker. Trackers this peer is not
+// registered with are registered with first, and the shared files announced
+// to them. The response asking for the soonest next heartbeat is returned.
 func (c *TrackerClient) Heartbeat(fileHashes []string) (*protocol.HeartbeatResponse, error) {
 	req := protocol.HeartbeatRequest{
 		PeerID:      c.peerID,
 		FilesHashes: fileHashes,
 	}
+	c.mu.RLock()
+	transfers := c.transfers
+	c.mu.RUnlock()
+	if transfers != nil {
+		req.Transfers = transfers()
+	}
 
-	var resp protocol.HeartbeatResponse
-	err := c.post("/api/peers/heartbeat", req, &resp)
-	return &resp, err
+	var resp *protocol.HeartbeatResponse
+	err := c.broadcast("heartbeat", c.Trackers(), func(baseURL string) error {
+		if c.needsRegistration(baseURL) {
+			if err := c.rejoin(baseURL); err != nil {
+				return err
+			}
+		}
+
+		r, err := c.api(baseURL).Heartbeat(context.Background(), &req)
+		if isUnknownPeer(err) && c.canRegister() {
+			log.Printf("[Tracker] %s does not know this peer, registering again", baseURL)
+			if err = c.rejoin(baseURL); err == nil {
+				r, err = c.api(baseURL).Heartbeat(context.Background(), &req)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		if resp == nil || (r.NextHeartbeatSecs > 0 &&
+			(resp.NextHeartbeatSecs <= 0 || r.NextHeartbeatSecs < resp.NextHeartbeatSecs)) {
+			resp = r
+		}
+		c.mu.Unlock()
+		return nil
+	})
+	if resp == nil {
+		resp = &protocol.HeartbeatResponse{}
+	}
+	return resp, err
 }
 
-// Leave notifies the tracker that this peer is leaving
-func (c *TrackerClient) Leave() error {
-	url := fmt.Sprintf("%s/api/peers/%s", c.baseURL, c.peerID)
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
+// registerWith sends the registration to one tracker
+func (c *TrackerClient) registerWith(baseURL string) (*protocol.RegisterResponse, error) {
+	c.mu.RLock()
+	req := *c.registration
+	req.PeerKey = c.peerKey
+	if c.ownerSecret != nil {
+		// A token of its own for each tracker, so that none can pose as this
+		// peer's owner on the others
+		req.OwnerToken = access.OwnerToken(c.ownerSecret, baseURL)
+	}
+	c.mu.RUnlock()
+
+	resp, err := c.api(baseURL).RegisterPeer(context.Background(), &req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if c.apiKey != "" {
-		req.Header.Set("X-API-Key", c.apiKey)
+	accessKey, err := access.ParsePublicKey(resp.AccessKey)
+	if err != nil && resp.AccessKey != "" {
+		log.Printf("[Tracker] %s sent an invalid access key", baseURL)
 	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+	c.mu.Lock()
+	h := c.healthUnsafe(baseURL)
+	h.registered = true
+	h.session, h.ownerID, h.accessKey = resp.SessionToken, resp.OwnerID, accessKey
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// rejoin registers with one tracker and announces the shared files to it
+func (c *TrackerClient) rejoin(baseURL string) error {
+	if _, err := c.registerWith(baseURL); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("leave failed with status: %d", resp.StatusCode)
+	c.mu.Lock()
+	sharedFiles := c.sharedFiles
+	// The tracker forgot the downloads too; they are reported in full next time
+	delete(c.reported, baseURL)
+	c.mu.Unlock()
+	if sharedFiles == nil {
+		return nil
+	}
+
+	files := sharedFiles()
+	announced := 0
+	for _, file := range files {
+		req := protocol.AnnounceRequest{PeerID: c.peerID, File: *file}
+		if _, err := c.api(baseURL).AnnounceFile(context.Background(), &req); err != nil {
+			log.Printf("[Tracker] Error announcing %s to %s: %v", file.Name, baseURL, err)
+			continue
+		}
+		announced++
 	}
+	log.Printf("[Tracker] Registered with %s again, announced %d/%d files", baseURL, announced, len(files))
 	return nil
 }
 
-// AnnounceFile announces a file to the tracker
+// canRegister reports whether Register was called
+func (c *TrackerClient) canRegister() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.registration != nil
+}
+
+// needsRegistration reports whether this peer registered but not with baseURL,
+// because the tracker was down or was added later
+func (c *TrackerClient) needsRegistration(baseURL string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h := c.health[baseURL]
+	return c.registration != nil && (h == nil || !h.registered)
+}
+
+// Leave notifies every tracker that this peer is leaving
+func (c *TrackerClient) Leave() error {
+	return c.broadcast("leave", c.Trackers(), func(baseURL string) error {
+		_, err := c.api(baseURL).LeavePeer(context.Background(), c.peerID)
+		return err
+	})
+}
+
+// AnnounceFile announces a file to every tracker
 func (c *TrackerClient) AnnounceFile(file *protocol.FileMetadata) (*protocol.AnnounceResponse, error) {
 	req := protocol.AnnounceRequest{
 		PeerID: c.peerID,
 		File:   *file,
 	}
 
-	var resp protocol.AnnounceResponse
-	err := c.post("/api/files/announce", req, &resp)
-	return &resp, err
+	var resp *protocol.AnnounceResponse
+	err := c.broadcast("announce", c.Trackers(), func(baseURL string) error {
+		r, err := c.api(baseURL).AnnounceFile(context.Background(), &req)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if resp == nil {
+			resp = r
+		}
+		c.mu.Unlock()
+		return nil
+	})
+	if resp == nil {
+		resp = &protocol.AnnounceResponse{}
+	}
+	return resp, err
 }
 
-// ListFiles gets all available files from tracker
-func (c *TrackerClient) ListFiles() (*protocol.ListFilesResponse, error) {
-	var resp protocol.ListFilesResponse
-	err := c.get("/api/files", &resp)
-	return &resp, err
+// WithdrawFile tells every tracker this peer no longer shares a file, or no
+// longer has the chunks of a download
+func (c *TrackerClient) WithdrawFile(fileHash string) error {
+	c.mu.Lock()
+	for _, files := range c.reported {
+		delete(files, fileHash)
+	}
+	c.mu.Unlock()
+
+	return c.broadcast("withdraw", c.Trackers(), func(baseURL string) error {
+		_, err := c.api(baseURL).WithdrawFile(context.Background(), fileHash, c.peerID)
+		return err
+	})
 }
 
-// GetPeers gets peers that have a specific file
-func (c *TrackerClient) GetPeers(fileHash string) (*protocol.GetPeersResponse, error) {
-	var resp protocol.GetPeersResponse
-	err := c.get(fmt.Sprintf("/api/files/%s/peers", fileHash), &resp)
-	return &resp, err
+// UpdateFileLabels edits the category and tags of a file this peer owns, or
+// announced first, on every tracker of the file, and returns the labels it
+// ends up with
+func (c *TrackerClient) UpdateFileLabels(fileHash string, req protocol.FileLabelsRequest) (*protocol.FileLabelsResponse, error) {
+	req.PeerID = c.peerID
+
+	var resp *protocol.FileLabelsResponse
+	err := c.broadcast("update labels", c.trackersFor(fileHash), func(baseURL string) error {
+		r, err := c.api(baseURL).UpdateFileLabels(context.Background(), fileHash, &req)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if resp == nil {
+			resp = r
+		}
+		c.mu.Unlock()
+		return nil
+	})
+	if resp == nil {
+		resp = &protocol.FileLabelsResponse{}
+	}
+	return resp, err
 }
 
-// Helper methods
+// SetFileAccess sets the visibility of a file this peer owns, and the groups
+// it is shared with when private, on every tracker of the file
+func (c *TrackerClient) SetFileAccess(fileHash, visibility string, groups []string) error {
+	req := protocol.FileAccessRequest{PeerID: c.peerID, Visibility: visibility, Groups: groups}
+	return c.broadcast("set access", c.trackersFor(fileHash), func(baseURL string) error {
+		_, err := c.api(baseURL).SetFileAccess(context.Background(), fileHash, &req)
+		return err
+	})
+}
 
-func (c *TrackerClient) post(path string, body any, result any) error {
-	data, err := json.Marshal(body)
-	if err != nil {
+// CreateShare gets a share token of a private file this peer owns from the
+// first tracker that answers. Only that tracker accepts the token; ttl 0 is
+// for a token that does not expire.
+func (c *TrackerClient) CreateShare(fileHash string, ttl time.Duration) (*protocol.ShareResponse, error) {
+	req := protocol.ShareRequest{PeerID: c.peerID, TTLSecs: int64(ttl / time.Second)}
+	resp := &protocol.ShareResponse{}
+	err := c.failover(c.trackersFor(fileHash), func(baseURL string) error {
+		r, err := c.api(baseURL).CreateShare(context.Background(), fileHash, &req)
+		if err == nil {
+			resp = r
+		}
 		return err
-	}
+	})
+	return resp, err
+}
 
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+path, bytes.NewReader(data))
-	if err != nil {
+// RevokeShares revokes the share tokens of a file this peer owns, on every
+// tracker of the file
+func (c *TrackerClient) RevokeShares(fileHash string) error {
+	return c.broadcast("revoke shares", c.trackersFor(fileHash), func(baseURL string) error {
+		_, err := c.api(baseURL).RevokeShares(context.Background(), fileHash, &trackerapi.RevokeSharesParams{PeerID: c.peerID})
 		return err
+	})
+}
+
+// ReportAvailability tells the trackers which chunks this peer has of a file
+// it is downloading, so that other peers can get them from it. Each tracker is
+// sent the chunks it does not know yet, or all of them after it lost track.
+func (c *TrackerClient) ReportAvailability(fileHash string, have protocol.ChunkSet) error {
+	transfer := c.transferOf(fileHash)
+	return c.broadcast("report availability", c.trackersFor(fileHash), func(baseURL string) error {
+		req := protocol.AvailabilityRequest{PeerID: c.peerID, FileHash: fileHash, Transfer: transfer}
+		c.mu.RLock()
+		known, ok := c.reported[baseURL][fileHash]
+		c.mu.RUnlock()
+		if ok {
+			req.Chunks = have.Difference(known)
+			if req.Chunks.IsEmpty() {
+				return nil
+			}
+		} else {
+			req.Chunks, req.Full = have, true
+		}
+
+		resp, err := c.api(baseURL).ReportAvailability(context.Background(), &req)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil || resp.Chunks != have.Len() {
+			// Send everything next time
+			delete(c.reported[baseURL], fileHash)
+			return err
+		}
+		if c.reported[baseURL] == nil {
+			c.reported[baseURL] = make(map[string]protocol.ChunkSet)
+		}
+		c.reported[baseURL][fileHash] = have.Clone()
+		return nil
+	})
+}
+
+// Announce tells the trackers that a download started, completed, was paused
+// or stopped, one of the protocol.Announce events, with every chunk this peer
+// has of the file and its transfer counters. A stopped download leaves the
+// swarm of the file.
+func (c *TrackerClient) Announce(fileHash, event string, have protocol.ChunkSet) error {
+	transfer := c.transferOf(fileHash)
+	return c.broadcast("announce "+event, c.trackersFor(fileHash), func(baseURL string) error {
+		req := protocol.AvailabilityRequest{
+			PeerID:   c.peerID,
+			FileHash: fileHash,
+			Chunks:   have,
+			Full:     true,
+			Event:    event,
+			Transfer: transfer,
+		}
+		resp, err := c.api(baseURL).ReportAvailability(context.Background(), &req)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil || event == protocol.AnnounceStopped || resp.Chunks != have.Len() {
+			delete(c.reported[baseURL], fileHash)
+			return err
+		}
+		if c.reported[baseURL] == nil {
+			c.reported[baseURL] = make(map[string]protocol.ChunkSet)
+		}
+		c.reported[baseURL][fileHash] = have.Clone()
+		return nil
+	})
+}
+
+// ListOptions filters, orders and pages the files returned by ListFiles. The
+// zero value asks for the tracker's first page, newest files first.
+type ListOptions struct {
+	Search     string // Full-text search of names, tags and categories
+	Category   string
+	Tags       []string
+	MinSize    int64
+	MaxSize    int64
+	MinSeeders int
+	Since      string // RFC 3339 time or duration such as 24h
+	Sort       string // added, name, size, seeders or relevance
+	Order      string // asc or desc; the tracker's default for the sort if empty
+	Limit      int
+	Cursor     string // NextCursor of the previous page, which keeps its filters
+}
+
+// params returns the query parameters of GET /api/files
+func (o ListOptions) params() *trackerapi.ListFilesParams {
+	p := &trackerapi.ListFilesParams{
+		Q:          o.Search,
+		Category:   o.Category,
+		MinSize:    int(o.MinSize),
+		MaxSize:    int(o.MaxSize),
+		MinSeeders: o.MinSeeders,
+		Since:      o.Since,
+		Sort:       o.Sort,
+		Order:      o.Order,
+		Limit:      o.Limit,
+		Cursor:     o.Cursor,
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if c.apiKey != "" {
-		req.Header.Set("X-API-Key", c.apiKey)
+	// One comma-separated value, as the peer has always sent them
+	if len(o.Tags) > 0 {
+		p.Tag = []string{strings.Join(o.Tags, ",")}
 	}
+	return p
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+// ListFiles gets a page of files from the first tracker that answers. Cursors
+// are only valid on the tracker that issued them.
+func (c *TrackerClient) ListFiles(opts ListOptions) (*protocol.ListFilesResponse, error) {
+	resp := &protocol.ListFilesResponse{}
+	err := c.failover(c.Trackers(), func(baseURL string) error {
+		r, err := c.api(baseURL).ListFiles(context.Background(), opts.params())
+		if err == nil {
+			resp = r
+		}
 		return err
+	})
+	return resp, err
+}
+
+// GetPeers gets peers that have a specific file. All trackers, including the
+// ones added for this file, are asked and their peer lists merged. A private
+// file comes with a grant to download it from its peers.
+func (c *TrackerClient) GetPeers(fileHash string) (*protocol.GetPeersResponse, error) {
+	// Seeders have every chunk, their availability is not worth sending
+	params := &trackerapi.GetFilePeersParams{PeerID: c.peerID, Chunks: "leechers"}
+	c.mu.RLock()
+	params.Share = c.shareTokens[fileHash]
+	c.mu.RUnlock()
+
+	trackers := c.trackersFor(fileHash)
+	responses := make([]*protocol.GetPeersResponse, len(trackers))
+	err := c.broadcast("get peers", trackers, func(baseURL string) error {
+		r, err := c.api(baseURL).GetFilePeers(context.Background(), fileHash, params)
+		if err != nil {
+			return err
+		}
+		responses[slices.Index(trackers, baseURL)] = r
+		return nil
+	})
+	if err != nil {
+		return &protocol.GetPeersResponse{}, err
 	}
-	defer resp.Body.Close()
+	return mergePeers(responses), nil
+}
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("request failed with status: %d", resp.StatusCode)
+// trackersFor returns the configured trackers and the ones added for a file
+func (c *TrackerClient) trackersFor(fileHash string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	trackers := slices.Clone(c.trackers)
+	for _, url := range c.fileTrackers[fileHash] {
+		if !slices.Contains(trackers, url) {
+			trackers = append(trackers, url)
+		}
 	}
+	return trackers
+}
 
-	return json.NewDecoder(resp.Body).Decode(result)
+// mergePeers combines the answers of several trackers. File metadata and
+// access come from the first answer; peers are deduplicated by ID.
+func mergePeers(responses []*protocol.GetPeersResponse) *protocol.GetPeersResponse {
+	var merged *protocol.GetPeersResponse
+	seen := make(map[string]bool)
+	for _, r := range responses {
+		if r == nil {
+			continue
+		}
+		if merged == nil {
+			copied := *r
+			copied.Peers = nil
+			merged = &copied
+		}
+		for _, peer := range r.Peers {
+			if !seen[peer.PeerID] {
+				seen[peer.PeerID] = true
+				merged.Peers = append(merged.Peers, peer)
+			}
+		}
+	}
+	return merged
+}
+
+// broadcast runs fn against every tracker concurrently. It fails only if all
+// trackers failed; other failures are logged.
+func (c *TrackerClient) broadcast(op string, trackers []string, fn func(baseURL string) error) error {
+	if len(trackers) == 0 {
+		return errors.New("no tracker configured")
+	}
+
+	errs := make([]error, len(trackers))
+	var wg sync.WaitGroup
+	for i, url := range trackers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(url)
+			c.recordResult(url, errs[i])
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", trackers[i], err))
+		}
+	}
+	if len(failed) == len(trackers) {
+		if len(failed) == 1 {
+			return errors.Unwrap(failed[0])
+		}
+		return errors.Join(failed...)
+	}
+	for _, err := range failed {
+		if isOutage(err) {
+			log.Printf("[Tracker] %s failed on %v", op, err)
+		}
+	}
+	return nil
+}
+
+// failover runs fn against one tracker at a time, healthy trackers first,
+// until one succeeds
+func (c *TrackerClient) failover(trackers []string, fn func(baseURL string) error) error {
+	if len(trackers) == 0 {
+		return errors.New("no tracker configured")
+	}
+
+	c.mu.RLock()
+	slices.SortStableFunc(trackers, func(a, b string) int {
+		return c.failuresUnsafe(a) - c.failuresUnsafe(b)
+	})
+	c.mu.RUnlock()
+
+	var failed []error
+	for _, url := range trackers {
+		err := fn(url)
+		c.recordResult(url, err)
+		if err == nil {
+			return nil
+		}
+		failed = append(failed, fmt.Errorf("%s: %w", url, err))
+	}
+	if len(failed) == 1 {
+		return errors.Unwrap(failed[0])
+	}
+	return errors.Join(failed...)
+}
+
+// isUnknownPeer reports whether a tracker answered that it does not know this peer
+func isUnknownPeer(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// isRetryable reports whether a failed request may succeed if sent again
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return isOutage(err)
+}
+
+// isOutage reports whether err means the tracker is unreachable or broken.
+// Error statuses below 500 mean the tracker is up but refused the request.
+func isOutage(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return err != nil
+}
+
+// recordResult updates the health of a tracker
+func (c *TrackerClient) recordResult(url string, err error) {
+	if !isOutage(err) {
+		err = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.healthUnsafe(url)
+	if err == nil {
+		if h.failures > 0 {
+			log.Printf("[Tracker] %s is back up", url)
+		}
+		h.failures = 0
+		h.lastError = ""
+		return
+	}
+	if h.failures == 0 {
+		log.Printf("[Tracker] %s is down: %v", url, err)
+	}
+	h.failures++
+	h.lastError = err.Error()
+}
+
+func (c *TrackerClient) healthUnsafe(url string) *trackerHealth {
+	h := c.health[url]
+	if h == nil {
+		h = &trackerHealth{}
+		c.health[url] = h
+	}
+	return h
+}
+
+func (c *TrackerClient) failuresUnsafe(url string) int {
+	if h := c.health[url]; h != nil {
+		return h.failures
+	}
+	return 0
 }
 
-func (c *TrackerClient) get(path string, result any) error {
-	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+// normalizeURLs trims trailing slashes and drops empty and duplicate URLs
+func normalizeURLs(urls []string) []string {
+	var normalized []string
+	for _, url := range urls {
+		url = strings.TrimRight(strings.TrimSpace(url), "/")
+		if url != "" && !slices.Contains(normalized, url) {
+			normalized = append(normalized, url)
+		}
+	}
+	return normalized
+}
+
+// Helper methods
+
+// apiPrefix is where the peer reaches the tracker API. It stays on /api,
+// which trackers of every version serve, rather than /api/v2.
+const apiPrefix = "/api"
+
+// api returns the client of the API of one tracker, generated from its
+// OpenAPI document
+func (c *TrackerClient) api(baseURL string) *trackerapi.Client {
+	return trackerapi.NewClient(trackerDoer{c: c, baseURL: baseURL})
+}
+
+// trackerDoer sends the requests of a trackerapi.Client to one tracker
+// through do
+type trackerDoer struct {
+	c       *TrackerClient
+	baseURL string
+}
+
+func (d trackerDoer) Do(ctx context.Context, method, path string, body, result any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	return d.c.do(ctx, method, d.baseURL, apiPrefix+path, data, result)
+}
+
+// do sends a request, retrying with exponential backoff while the tracker is
+// unreachable, fails with a 5xx status or rate limits the peer
+func (c *TrackerClient) do(ctx context.Context, method, baseURL, path string, body []byte, result any) error {
+	for attempt := 0; ; attempt++ {
+		err := c.doOnce(ctx, method, baseURL, path, body, result)
+		if err == nil || !isRetryable(err) || attempt >= c.retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff(c.retryDelay, attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (c *TrackerClient) doOnce(ctx context.Context, method, baseURL, path string, body []byte, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	if c.apiKey != "" {
 		req.Header.Set("X-API-Key", c.apiKey)
 	}
+	c.mu.RLock()
+	if h := c.health[baseURL]; h != nil && h.session != "" {
+		req.Header.Set(protocol.HeaderPeerToken, h.session)
+	}
+	c.mu.RUnlock()
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -161,8 +935,24 @@ func (c *TrackerClient) get(path string, result any) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("request failed with status: %d", resp.StatusCode)
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	if result == nil {
+		return nil
 	}
-
 	return json.NewDecoder(resp.Body).Decode(result)
 }
+
+// backoff returns the delay before retry number attempt+1: base doubled on
+// every attempt up to maxRetryDelay, with jitter so peers that lost the same
+// tracker do not retry in lockstep
+func backoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := base << attempt
+	if delay > maxRetryDelay || delay <= 0 {
+		delay = maxRetryDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,409 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// fakeTracker serves the tracker API with a fixed set of peers for file "abc"
+type fakeTracker struct {
+	*httptest.Server
+	registered atomic.Int32
+	announced  atomic.Int32
+	known      atomic.Bool  // Whether the tracker knows the peer; reset to simulate a restart
+	down       atomic.Bool  // Answer every request with 503
+	nextSecs   atomic.Int32 // next_heartbeat_in
+
+	mu           sync.Mutex
+	availability protocol.ChunkSet              // Chunks reported for file "abc"
+	reports      []protocol.AvailabilityRequest // Availability reports received
+}
+
+func newFakeTracker(t *testing.T, peerIDs ...string) *fakeTracker {
+	t.Helper()
+	f := &fakeTracker{}
+	f.nextSecs.Store(30)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/peers/register", func(w http.ResponseWriter, r *http.Request) {
+		f.registered.Add(1)
+		f.known.Store(true)
+		json.NewEncoder(w).Encode(protocol.RegisterResponse{Success: true, Message: "registered"})
+	})
+	mux.HandleFunc("POST /api/peers/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		if !f.known.Load() {
+			http.Error(w, "Unknown peer", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(protocol.HeartbeatResponse{Success: true, NextHeartbeatSecs: int(f.nextSecs.Load())})
+	})
+	mux.HandleFunc("POST /api/files/announce", func(w http.ResponseWriter, r *http.Request) {
+		f.announced.Add(1)
+		json.NewEncoder(w).Encode(protocol.AnnounceResponse{Success: true, FileID: "abc"})
+	})
+	mux.HandleFunc("POST /api/files/availability", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.AvailabilityRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.reports = append(f.reports, req)
+		if req.Full {
+			f.availability = protocol.ChunkSet{}
+		}
+		f.availability.Union(req.Chunks)
+		json.NewEncoder(w).Encode(protocol.AvailabilityResponse{Success: true, Chunks: f.availability.Len()})
+	})
+	mux.HandleFunc("GET /api/files", func(w http.ResponseWriter, r *http.Request) {
+		// The query comes back as the cursor for tests to check it
+		json.NewEncoder(w).Encode(protocol.ListFilesResponse{Files: []protocol.FileListItem{{Hash: "abc", Name: "a.txt"}}, NextCursor: r.URL.RawQuery})
+	})
+	mux.HandleFunc("GET /api/files/{hash}/peers", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("hash") != "abc" {
+			http.NotFound(w, r)
+			return
+		}
+		resp := protocol.GetPeersResponse{FileHash: "abc", FileName: "a.txt", ChunkCount: 1}
+		for _, id := range peerIDs {
+			resp.Peers = append(resp.Peers, protocol.PeerFileInfo{PeerInfo: protocol.PeerInfo{PeerID: id}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// downTracker returns the URL of a tracker that refuses connections
+func downTracker(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	return ts.URL
+}
+
+func TestTrackerClient_Failover(t *testing.T) {
+	down := downTracker(t)
+	up := newFakeTracker(t, "peer-a")
+	c := NewMultiTrackerClient([]string{down, up.URL}, "me", "")
+	c.SetRetry(0, 0)
+
+	if _, err := c.Register("127.0.0.1", 6881); err != nil {
+		t.Fatalf("Register should succeed while one tracker is up: %v", err)
+	}
+	if up.registered.Load() != 1 {
+		t.Errorf("Registered %d times, want 1", up.registered.Load())
+	}
+	if _, err := c.AnnounceFile(&protocol.FileMetadata{Hash: "abc"}); err != nil {
+		t.Fatalf("AnnounceFile failed: %v", err)
+	}
+
+	files, err := c.ListFiles(ListOptions{Search: "a b", Tags: []string{"x", "y"}, Sort: "size", Limit: 5})
+	if err != nil || len(files.Files) != 1 {
+		t.Fatalf("ListFiles = %v, %v", files, err)
+	}
+	if files.NextCursor != "limit=5&q=a+b&sort=size&tag=x%2Cy" {
+		t.Errorf("ListFiles sent %s", files.NextCursor)
+	}
+	peers, err := c.GetPeers("abc")
+	if err != nil || len(peers.Peers) != 1 {
+		t.Fatalf("GetPeers = %v, %v", peers, err)
+	}
+
+	status := c.Status()
+	if len(status) != 2 || status[0].Up || !status[1].Up {
+		t.Errorf("Status = %+v, want the first tracker down", status)
+	}
+}
+
+func TestTrackerClient_AllDown(t *testing.T) {
+	c := NewMultiTrackerClient([]string{downTracker(t), downTracker(t)}, "me", "")
+	c.SetRetry(0, 0)
+
+	if _, err := c.Register("127.0.0.1", 6881); err == nil {
+		t.Error("Register should fail when every tracker is down")
+	}
+	if _, err := c.GetPeers("abc"); err == nil {
+		t.Error("GetPeers should fail when every tracker is down")
+	}
+}
+
+func TestTrackerClient_MergesPeers(t *testing.T) {
+	a := newFakeTracker(t, "peer-a", "peer-b")
+	b := newFakeTracker(t, "peer-b", "peer-c")
+	fromMagnet := newFakeTracker(t, "peer-d")
+	c := NewMultiTrackerClient([]string{a.URL, b.URL + "/"}, "me", "")
+
+	peers, err := c.GetPeers("abc")
+	if err != nil {
+		t.Fatalf("GetPeers failed: %v", err)
+	}
+	if len(peers.Peers) != 3 || peers.FileName != "a.txt" {
+		t.Errorf("Got %d peers for %q, want 3 for a.txt", len(peers.Peers), peers.FileName)
+	}
+
+	c.AddFileTrackers("abc", []string{fromMagnet.URL, a.URL})
+	peers, _ = c.GetPeers("abc")
+	if len(peers.Peers) != 4 {
+		t.Errorf("Got %d peers with the magnet tracker, want 4", len(peers.Peers))
+	}
+	if len(c.Trackers()) != 2 {
+		t.Errorf("File trackers should not be added to the configured ones: %v", c.Trackers())
+	}
+
+	// A tracker that does not know the file is not counted as down
+	if _, err := c.GetPeers("unknown"); err == nil {
+		t.Error("GetPeers of an unknown file should fail")
+	}
+	for _, s := range c.Status() {
+		if !s.Up {
+			t.Errorf("%s marked down after a 404", s.URL)
+		}
+	}
+}
+
+func TestTrackerClient_Retry(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(protocol.ListFilesResponse{})
+	}))
+	defer ts.Close()
+
+	c := NewTrackerClient(ts.URL, "me")
+	c.SetRetry(2, time.Millisecond)
+	if _, err := c.ListFiles(ListOptions{}); err != nil {
+		t.Fatalf("ListFiles should succeed on the third attempt: %v", err)
+	}
+
+	attempts.Store(0)
+	c.SetRetry(1, time.Millisecond)
+	if _, err := c.ListFiles(ListOptions{}); err == nil {
+		t.Error("ListFiles should fail after one retry")
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("Sent %d requests, want 2", attempts.Load())
+	}
+}
+
+func TestTrackerClient_Reregisters(t *testing.T) {
+	a := newFakeTracker(t)
+	b := newFakeTracker(t)
+	b.nextSecs.Store(10)
+	b.down.Store(true)
+
+	c := NewMultiTrackerClient([]string{a.URL, b.URL}, "me", "")
+	c.SetRetry(0, 0)
+	c.SetSharedFiles(func() []*protocol.FileMetadata {
+		return []*protocol.FileMetadata{{Hash: "abc"}, {Hash: "def"}}
+	})
+	if _, err := c.Register("127.0.0.1", 6881); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	// a restarts and forgets the peer; b comes up after missing the registration
+	a.known.Store(false)
+	b.down.Store(false)
+	resp, err := c.Heartbeat([]string{"abc", "def"})
+	if err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	for name, f := range map[string]*fakeTracker{"a": a, "b": b} {
+		if f.announced.Load() != 2 {
+			t.Errorf("Tracker %s got %d announces, want 2", name, f.announced.Load())
+		}
+	}
+	if a.registered.Load() != 2 || b.registered.Load() != 1 {
+		t.Errorf("Registrations: a=%d b=%d, want 2 and 1", a.registered.Load(), b.registered.Load())
+	}
+	if resp.NextHeartbeatSecs != 10 {
+		t.Errorf("NextHeartbeatSecs = %d, want the soonest (10)", resp.NextHeartbeatSecs)
+	}
+
+	// Nothing is re-announced while the trackers remember the peer
+	c.Heartbeat(nil)
+	if a.announced.Load() != 2 || b.announced.Load() != 2 {
+		t.Errorf("Files announced again: a=%d b=%d", a.announced.Load(), b.announced.Load())
+	}
+}
+
+func TestTrackerClient_ReportAvailability(t *testing.T) {
+	f := newFakeTracker(t)
+	c := NewTrackerClient(f.URL, "me")
+	lastReport := func() protocol.AvailabilityRequest {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.reports[len(f.reports)-1]
+	}
+
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2))
+	if r := lastReport(); !r.Full || r.Chunks.String() != "0-2" {
+		t.Errorf("First report = %+v, want all chunks", r)
+	}
+
+	// Only new chunks are sent, and nothing when there are none
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2, 3, 4))
+	if r := lastReport(); r.Full || r.Chunks.String() != "3-4" {
+		t.Errorf("Second report = %+v, want chunks 3-4", r)
+	}
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2, 3, 4))
+	if len(f.reports) != 2 {
+		t.Errorf("Got %d reports, want no report without new chunks", len(f.reports))
+	}
+
+	// A tracker that lost the earlier reports gets everything again
+	f.mu.Lock()
+	f.availability = protocol.ChunkSet{}
+	f.mu.Unlock()
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2, 3, 4, 5))
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2, 3, 4, 5))
+	if r := lastReport(); !r.Full || r.Chunks.String() != "0-5" {
+		t.Errorf("Report after a tracker restart = %+v, want all chunks", r)
+	}
+}
+
+func TestTrackerClient_Announce(t *testing.T) {
+	f := newFakeTracker(t)
+	c := NewTrackerClient(f.URL, "me")
+	c.SetTransfers(func() map[string]protocol.Transfer {
+		return map[string]protocol.Transfer{"abc": {Uploaded: 10, Downloaded: 20, Left: 30}}
+	})
+	lastReport := func() protocol.AvailabilityRequest {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.reports[len(f.reports)-1]
+	}
+
+	// Events send every chunk, with the transfer counters
+	c.Announce("abc", protocol.AnnounceStarted, protocol.NewChunkSet(0, 1))
+	if r := lastReport(); r.Event != protocol.AnnounceStarted || !r.Full || r.Chunks.String() != "0-1" || r.Downloaded != 20 {
+		t.Errorf("Started announce = %+v, want all chunks and counters", r)
+	}
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2))
+	if r := lastReport(); r.Event != "" || r.Full || r.Chunks.String() != "2" || r.Left != 30 {
+		t.Errorf("Report after the announce = %+v, want chunk 2 and counters", r)
+	}
+
+	// Once stopped, the next report sends everything again
+	c.Announce("abc", protocol.AnnounceStopped, protocol.ChunkSet{})
+	c.ReportAvailability("abc", protocol.NewChunkSet(0, 1, 2))
+	if r := lastReport(); !r.Full {
+		t.Errorf("Report after a stop = %+v, want all chunks", r)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, maxRetryDelay} {
+		got := backoff(time.Second, attempt)
+		if got < want/2 || got > want {
+			t.Errorf("backoff(1s, %d) = %v, want between %v and %v", attempt, got, want/2, want)
+		}
+	}
+	if got := backoff(time.Second, 100); got > maxRetryDelay {
+		t.Errorf("backoff overflowed: %v", got)
+	}
+}
+
+func TestTrackerClient_OwnerIdentity(t *testing.T) {
+	signer := access.NewSigner("secret")
+	var mu sync.Mutex
+	ownerTokens := map[string]string{} // Host -> owner token registered with
+	sessions := map[string]string{}    // Path -> session token received
+	var peerKey string                 // Peer key registered with
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		sessions[r.URL.Path] = r.Header.Get(protocol.HeaderPeerToken)
+		switch r.URL.Path {
+		case "/api/peers/register":
+			var req protocol.RegisterRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			ownerTokens[r.Host] = req.OwnerToken
+			peerKey = req.PeerKey
+			owner := access.OwnerID(req.OwnerToken)
+			json.NewEncoder(w).Encode(protocol.RegisterResponse{
+				Success:      true,
+				OwnerID:      owner,
+				AccessKey:    signer.PublicKey(),
+				SessionToken: signer.Sign(access.Token{Kind: access.KindSession, Peer: req.PeerID, Owner: owner, Key: req.PeerKey}),
+			})
+		case "/api/files/abc/peers":
+			if r.URL.Query().Get("share") != "share-token" || r.URL.Query().Get("peer_id") != "me" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(protocol.GetPeersResponse{FileHash: "abc", Visibility: access.Private,
+				AccessToken: signer.Sign(access.Token{Kind: access.KindGrant, File: "abc", Peer: "me", Key: peerKey})})
+		}
+	}
+	a := httptest.NewServer(http.HandlerFunc(handler))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(handler))
+	defer b.Close()
+
+	c := NewMultiTrackerClient([]string{a.URL, b.URL}, "me", "")
+	c.SetRetry(0, 0)
+	c.SetOwnerSecret([]byte("owner secret"))
+	pub, _, _ := ed25519.GenerateKey(nil)
+	c.SetPeerKey(pub)
+	if _, err := c.Register("127.0.0.1", 6881); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+	if peerKey != key {
+		t.Errorf("Expected the peer key registered, got %q", peerKey)
+	}
+	if c.SessionToken(a.URL) == "" || c.SessionToken("http://unknown") != "" {
+		t.Error("Expected the session of registered trackers only")
+	}
+
+	// Each tracker gets its own owner token, and knows the peer by its own ID
+	tokenA, tokenB := ownerTokens[a.Listener.Addr().String()], ownerTokens[b.Listener.Addr().String()]
+	if tokenA == "" || tokenA == tokenB {
+		t.Errorf("Expected a different owner token per tracker, got %q and %q", tokenA, tokenB)
+	}
+	if ids := c.OwnerIDs(); len(ids) != 2 || ids[a.URL] != access.OwnerID(tokenA) {
+		t.Errorf("OwnerIDs() = %v", ids)
+	}
+
+	c.SetShareToken("abc", "share-token")
+	resp, err := c.GetPeers("abc")
+	if err != nil || resp.AccessToken == "" {
+		t.Fatalf("GetPeers = %+v, %v", resp, err)
+	}
+	if sessions["/api/files/abc/peers"] == "" {
+		t.Error("Expected the session token sent with requests")
+	}
+
+	if err := c.VerifyGrant("abc", "me", key, resp.AccessToken); err != nil {
+		t.Errorf("VerifyGrant failed: %v", err)
+	}
+	if err := c.VerifyGrant("abc", "thief", key, resp.AccessToken); err == nil {
+		t.Error("Expected a grant of another peer refused")
+	}
+	if err := c.VerifyGrant("abc", "me", "", resp.AccessToken); err == nil {
+		t.Error("Expected a grant refused to a peer that did not prove its key")
+	}
+	forged := access.NewSigner("other").Sign(access.Token{Kind: access.KindGrant, File: "abc", Peer: "me", Key: key})
+	if err := c.VerifyGrant("abc", "me", key, forged); err == nil {
+		t.Error("Expected a grant of an unknown tracker refused")
+	}
+}
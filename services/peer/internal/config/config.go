@@ -0,0 +1,352 @@
+// Package config loads the peer configuration from a YAML file, environment
+// variables and command-line flags, and validates it.
+//
+// Values are applied in this order, later sources overriding earlier ones:
+// built-in defaults, the config file, P2P_* environment variables, flags.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/control"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// Config is the full peer configuration
+type Config struct {
+	Peer      PeerConfig      `yaml:"peer"`
+	Tracker   TrackerConfig   `yaml:"tracker"`
+	Bandwidth BandwidthConfig `yaml:"bandwidth"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Chunking  ChunkingConfig  `yaml:"chunking"`
+	Downloads DownloadsConfig `yaml:"downloads"`
+	Shared    SharedConfig    `yaml:"shared"`
+	Relay     RelayConfig     `yaml:"relay"`
+	Control   ControlConfig   `yaml:"control"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+}
+
+// PeerConfig holds the basic peer settings
+type PeerConfig struct {
+	Port        int           `yaml:"port"`         // P2P listen port (the next free port is used if busy)
+	DataDir     string        `yaml:"data_dir"`     // State, downloads and tokens
+	Daemon      bool          `yaml:"daemon"`       // Run without the interactive CLI
+	DialTimeout time.Duration `yaml:"dial_timeout"` // Direct TCP connections to other peers
+}
+
+// TrackerConfig holds the tracker connection settings
+type TrackerConfig struct {
+	URLs              []string      `yaml:"urls"`
+	APIKey            string        `yaml:"api_key"`
+	Timeout           time.Duration `yaml:"timeout"`            // Per HTTP request
+	Retries           int           `yaml:"retries"`            // Retries of a failed request, with backoff
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"` // Until a tracker asks for another interval
+}
+
+// BandwidthConfig holds the default limits and the time-of-day schedule
+type BandwidthConfig struct {
+	UploadLimit   string   `yaml:"upload_limit"`     // e.g. "512KB", "2MB", "unlimited"
+	DownloadLimit string   `yaml:"download_limit"`   // e.g. "512KB", "2MB", "unlimited"
+	Schedule      []string `yaml:"schedule" sep:";"` // Rules such as "mon-fri 08:00-18:00 up=2MB down=2MB"
+}
+
+// StorageConfig holds the download storage limits
+type StorageConfig struct {
+	Quota        string `yaml:"quota"`          // e.g. "50GB", "0" = no quota
+	MinFreeSpace string `yaml:"min_free_space"` // Free disk space to always leave
+	Evict        string `yaml:"evict"`          // none, oldest or largest
+	ChunkStore   bool   `yaml:"chunk_store"`    // Content-addressed store for downloaded chunks
+}
+
+// ChunkingConfig holds how shared files are split and hashed
+type ChunkingConfig struct {
+	Mode      string `yaml:"mode"`       // fixed or cdc
+	ChunkSize string `yaml:"chunk_size"` // Average size for cdc
+	Workers   int    `yaml:"workers"`    // Hashing goroutines, 0 = one per CPU
+}
+
+// DownloadsConfig holds the download queue and downloader settings
+type DownloadsConfig struct {
+	MaxActive    int           `yaml:"max_active"` // Downloads run at the same time
+	Workers      int           `yaml:"workers"`    // Peers downloaded from in parallel per file
+	Retries      int           `yaml:"retries"`    // Attempts per chunk
+	ChunkTimeout time.Duration `yaml:"chunk_timeout"`
+}
+
+// SharedConfig holds the directories shared in daemon mode
+type SharedConfig struct {
+	Dirs         []string      `yaml:"dirs"` // Default: <data_dir>/shared
+	ScanInterval time.Duration `yaml:"scan_interval"`
+}
+
+// RelayConfig holds the NAT traversal relay settings
+type RelayConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"` // Default: the first tracker
+}
+
+// ControlConfig holds the local control API settings
+type ControlConfig struct {
+	Addr  string `yaml:"addr"`  // Loopback host:port, unix:/path/to/socket or "off"
+	Token string `yaml:"token"` // Default: generated and stored in <data_dir>/control.token
+}
+
+// MetricsConfig holds the Prometheus metrics listener
+type MetricsConfig struct {
+	Addr string `yaml:"addr"` // host:port serving /metrics, empty = disabled
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Peer: PeerConfig{
+			Port:        6881,
+			DataDir:     "./data",
+			DialTimeout: 5 * time.Second,
+		},
+		Tracker: TrackerConfig{
+			URLs:              []string{"https://p2p.idist.dev"},
+			Timeout:           10 * time.Second,
+			Retries:           3,
+			HeartbeatInterval: 30 * time.Second,
+		},
+		Bandwidth: BandwidthConfig{
+			UploadLimit:   "unlimited",
+			DownloadLimit: "unlimited",
+		},
+		Storage: StorageConfig{
+			Quota:        "0",
+			MinFreeSpace: "1GB",
+			Evict:        string(storage.EvictNone),
+		},
+		Chunking: ChunkingConfig{
+			Mode:      "fixed",
+			ChunkSize: "256KB",
+		},
+		Downloads: DownloadsConfig{
+			MaxActive:    3,
+			Workers:      8,
+			Retries:      3,
+			ChunkTimeout: 30 * time.Second,
+		},
+		Shared: SharedConfig{
+			ScanInterval: 60 * time.Second,
+		},
+		Relay: RelayConfig{
+			Enabled: true,
+		},
+		Control: ControlConfig{
+			Addr: control.DefaultAddr,
+		},
+	}
+}
+
+// Load returns the defaults overridden by the YAML file at path (if not
+// empty) and by the environment. The result is not validated.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Unknown keys are reported with their line instead of being ignored
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// TrackerURL returns the primary tracker
+func (c *Config) TrackerURL() string {
+	if len(c.Tracker.URLs) == 0 {
+		return ""
+	}
+	return c.Tracker.URLs[0]
+}
+
+// RelayURL returns the relay server, which defaults to the primary tracker
+func (c *Config) RelayURL() string {
+	if c.Relay.URL != "" {
+		return c.Relay.URL
+	}
+	return c.TrackerURL()
+}
+
+// SharedDirs returns the shared directories, defaulting to <data_dir>/shared
+func (c *Config) SharedDirs() []string {
+	if len(c.Shared.Dirs) == 0 {
+		return []string{filepath.Join(c.Peer.DataDir, "shared")}
+	}
+	dirs := make([]string, len(c.Shared.Dirs))
+	for i, dir := range c.Shared.Dirs {
+		dirs[i] = filepath.Clean(dir)
+	}
+	return dirs
+}
+
+// BandwidthSchedule returns the parsed limits and schedule rules
+func (c *Config) BandwidthSchedule() (*throttle.Schedule, error) {
+	schedule := &throttle.Schedule{}
+	for _, spec := range c.Bandwidth.Schedule {
+		rule, err := throttle.ParseScheduleRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("bandwidth.schedule: %w", err)
+		}
+		schedule.Rules = append(schedule.Rules, *rule)
+	}
+	var err error
+	if schedule.DefaultUpload, err = throttle.ParseRate(c.Bandwidth.UploadLimit); err != nil {
+		return nil, fmt.Errorf("bandwidth.upload_limit: %w", err)
+	}
+	if schedule.DefaultDownload, err = throttle.ParseRate(c.Bandwidth.DownloadLimit); err != nil {
+		return nil, fmt.Errorf("bandwidth.download_limit: %w", err)
+	}
+	return schedule, nil
+}
+
+// Quota returns the parsed storage limits
+func (c *Config) Quota() (storage.QuotaConfig, error) {
+	var quota storage.QuotaConfig
+	var err error
+	if quota.MaxBytes, err = storage.ParseSize(c.Storage.Quota); err != nil {
+		return quota, fmt.Errorf("storage.quota: %w", err)
+	}
+	if quota.MinFreeBytes, err = storage.ParseSize(c.Storage.MinFreeSpace); err != nil {
+		return quota, fmt.Errorf("storage.min_free_space: %w", err)
+	}
+	if quota.Eviction, err = storage.ParseEvictionPolicy(c.Storage.Evict); err != nil {
+		return quota, fmt.Errorf("storage.evict: %w", err)
+	}
+	return quota, nil
+}
+
+// NewChunker returns a chunker for the chunking settings
+func (c *Config) NewChunker() (*chunker.Chunker, error) {
+	size, err := storage.ParseSize(c.Chunking.ChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("chunking.chunk_size: %w", err)
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("chunking.chunk_size: must be greater than 0")
+	}
+	fileChunker, err := chunker.NewWithMode(c.Chunking.Mode, size)
+	if err != nil {
+		return nil, fmt.Errorf("chunking.mode: %w", err)
+	}
+	fileChunker.Workers = c.Chunking.Workers
+	return fileChunker, nil
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks every setting and reports all problems at once
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	problemf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Peer.Port < 0 || c.Peer.Port > 65535 {
+		problemf("peer.port: %d is not a valid port", c.Peer.Port)
+	}
+	if c.Peer.DataDir == "" {
+		problemf("peer.data_dir: must not be empty")
+	}
+	if c.Peer.DialTimeout <= 0 {
+		problemf("peer.dial_timeout: must be greater than 0")
+	}
+
+	if len(c.Tracker.URLs) == 0 {
+		problemf("tracker.urls: at least one tracker is required")
+	}
+	for _, raw := range c.Tracker.URLs {
+		check(validateURL("tracker.urls", raw))
+	}
+	if c.Tracker.Timeout <= 0 {
+		problemf("tracker.timeout: must be greater than 0")
+	}
+	if c.Tracker.Retries < 0 {
+		problemf("tracker.retries: must not be negative")
+	}
+	if c.Tracker.HeartbeatInterval < time.Second {
+		problemf("tracker.heartbeat_interval: must be at least 1s")
+	}
+
+	_, err := c.BandwidthSchedule()
+	check(err)
+	_, err = c.Quota()
+	check(err)
+	_, err = c.NewChunker()
+	check(err)
+	if c.Chunking.Workers < 0 {
+		problemf("chunking.workers: must not be negative")
+	}
+
+	if c.Downloads.MaxActive < 1 {
+		problemf("downloads.max_active: must be at least 1")
+	}
+	if c.Downloads.Workers < 1 {
+		problemf("downloads.workers: must be at least 1")
+	}
+	if c.Downloads.Retries < 1 {
+		problemf("downloads.retries: must be at least 1")
+	}
+	if c.Downloads.ChunkTimeout <= 0 {
+		problemf("downloads.chunk_timeout: must be greater than 0")
+	}
+
+	for _, dir := range c.Shared.Dirs {
+		if dir == "" {
+			problemf("shared.dirs: empty directory")
+		}
+	}
+	if c.Shared.ScanInterval < time.Second {
+		problemf("shared.scan_interval: must be at least 1s")
+	}
+
+	if c.Relay.URL != "" {
+		check(validateURL("relay.url", c.Relay.URL))
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func validateURL(field, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: %q is not an http(s) URL", field, raw)
+	}
+	return nil
+}
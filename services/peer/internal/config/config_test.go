@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "peer.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Default config is invalid: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+peer:
+  port: 7000
+tracker:
+  urls: [https://tracker-a.example, https://tracker-b.example]
+  heartbeat_interval: 1m
+bandwidth:
+  upload_limit: 2MB
+  schedule:
+    - mon-fri 08:00-18:00 up=512KB down=1MB
+shared:
+  dirs: [/srv/share]
+`)
+	t.Setenv("P2P_PEER_PORT", "7100")
+	t.Setenv("P2P_SHARED_DIRS", "/srv/a, /srv/b")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	if cfg.Peer.Port != 7100 {
+		t.Errorf("Port = %d, want the environment override 7100", cfg.Peer.Port)
+	}
+	if cfg.TrackerURL() != "https://tracker-a.example" || cfg.RelayURL() != cfg.TrackerURL() {
+		t.Errorf("TrackerURL = %q, RelayURL = %q", cfg.TrackerURL(), cfg.RelayURL())
+	}
+	if cfg.Tracker.HeartbeatInterval != time.Minute {
+		t.Errorf("HeartbeatInterval = %v, want 1m", cfg.Tracker.HeartbeatInterval)
+	}
+	if cfg.Downloads.MaxActive != Default().Downloads.MaxActive {
+		t.Errorf("Unset MaxActive = %d, want the default", cfg.Downloads.MaxActive)
+	}
+	if dirs := cfg.SharedDirs(); !slices.Equal(dirs, []string{"/srv/a", "/srv/b"}) {
+		t.Errorf("SharedDirs = %v", dirs)
+	}
+
+	schedule, err := cfg.BandwidthSchedule()
+	if err != nil {
+		t.Fatalf("BandwidthSchedule failed: %v", err)
+	}
+	if schedule.DefaultUpload != 2<<20 || len(schedule.Rules) != 1 {
+		t.Errorf("Schedule = %+v", schedule)
+	}
+}
+
+func TestApplyEnvSchedule(t *testing.T) {
+	env := map[string]string{"P2P_BANDWIDTH_SCHEDULE": "sat,sun 00:00-24:00 up=0; mon-fri 08:00-18:00 up=1MB"}
+	cfg := Default()
+	err := cfg.ApplyEnv(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatalf("ApplyEnv failed: %v", err)
+	}
+	want := []string{"sat,sun 00:00-24:00 up=0", "mon-fri 08:00-18:00 up=1MB"}
+	if !slices.Equal(cfg.Bandwidth.Schedule, want) {
+		t.Errorf("Schedule = %q, want %q", cfg.Bandwidth.Schedule, want)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "peer:\n  prot: 7000\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("Load = %v, want an error naming the unknown key", err)
+	}
+}
+
+func TestApplyEnvInvalidValue(t *testing.T) {
+	env := map[string]string{"P2P_DOWNLOADS_CHUNK_TIMEOUT": "30"}
+	err := Default().ApplyEnv(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+	if err == nil || !strings.Contains(err.Error(), "P2P_DOWNLOADS_CHUNK_TIMEOUT") {
+		t.Errorf("ApplyEnv = %v, want an error naming the variable", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := Default()
+	cfg.Peer.Port = 70000
+	cfg.Tracker.URLs = []string{"ftp://tracker"}
+	cfg.Bandwidth.UploadLimit = "fast"
+	cfg.Chunking.Mode = "rabin"
+	cfg.Downloads.MaxActive = 0
+
+	err := cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Validate = %v, want a ValidationError", err)
+	}
+	for _, field := range []string{"peer.port", "tracker.urls", "bandwidth.upload_limit", "chunking.mode", "downloads.max_active"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Error does not mention %s:\n%v", field, err)
+		}
+	}
+	if len(verr.Problems) != 5 {
+		t.Errorf("Got %d problems, want 5:\n%v", len(verr.Problems), err)
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	names := EnvNames()
+	for _, name := range []string{"P2P_PEER_PORT", "P2P_TRACKER_URLS", "P2P_BANDWIDTH_UPLOAD_LIMIT", "P2P_CONTROL_ADDR"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("EnvNames is missing %s", name)
+		}
+	}
+}
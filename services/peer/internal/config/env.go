@@ -0,0 +1,115 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the name of every environment override
+const EnvPrefix = "P2P_"
+
+// ApplyEnv overrides settings from environment variables named after their
+// YAML keys: P2P_<SECTION>_<KEY>, e.g. P2P_PEER_PORT, P2P_BANDWIDTH_UPLOAD_LIMIT.
+// Lists are comma-separated (P2P_TRACKER_URLS=https://a,https://b), except
+// those whose items hold commas, tagged with their separator such as sep:";".
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+}
+
+// EnvNames returns every supported environment variable
+func EnvNames() []string {
+	var names []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			name := prefix + envKey(t.Field(i))
+			if t.Field(i).Type.Kind() == reflect.Struct {
+				walk(t.Field(i).Type, name+"_")
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), EnvPrefix)
+	return names
+}
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := prefix + envKey(t.Field(i))
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		sep := cmp.Or(t.Field(i).Tag.Get("sep"), ",")
+		if err := setValue(field, strings.TrimSpace(value), sep); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func envKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return strings.ToUpper(key)
+}
+
+// setValue parses s into a string, bool, int, duration or string list field,
+// list items being separated by sep
+func setValue(field reflect.Value, s, sep string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		field.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q (e.g. 30s, 5m)", s)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		field.Set(reflect.ValueOf(splitList(s, sep)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// SplitList splits a comma-separated list, dropping empty items
+func SplitList(s string) []string {
+	return splitList(s, ",")
+}
+
+func splitList(s, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,269 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+)
+
+// APIError is an error response from the control API
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+// Client talks to the control API of a running peer
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for a control address in the -control flag
+// format: host:port, or unix:/path/to/socket
+func NewClient(addr, token string) *Client {
+	transport := &http.Transport{}
+	baseURL := "http://" + addr
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		baseURL = "http://peer"
+	}
+	return &Client{
+		baseURL:    baseURL,
+		token:      token,
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+// Status returns the peer status
+func (c *Client) Status() (*StatusResponse, error) {
+	var resp StatusResponse
+	return &resp, c.do(http.MethodGet, "/v1/status", nil, &resp)
+}
+
+// Shares lists the shared files
+func (c *Client) Shares() ([]ShareInfo, error) {
+	var resp []ShareInfo
+	return resp, c.do(http.MethodGet, "/v1/shares", nil, &resp)
+}
+
+// Share shares a file. path must be valid on the peer's machine; visibility
+// is public if empty.
+func (c *Client) Share(path, visibility string) (*ShareInfo, error) {
+	var resp ShareInfo
+	return &resp, c.do(http.MethodPost, "/v1/shares", ShareRequest{Path: path, Visibility: visibility}, &resp)
+}
+
+// Unshare stops sharing a file
+func (c *Client) Unshare(hash string) error {
+	return c.do(http.MethodDelete, "/v1/shares/"+url.PathEscape(hash), nil, nil)
+}
+
+// Export writes the content of a shared file to path, a file or directory
+// that must be valid on the peer's machine
+func (c *Client) Export(hash, path string) (*ShareExportResponse, error) {
+	var resp ShareExportResponse
+	return &resp, c.do(http.MethodPost, "/v1/shares/"+url.PathEscape(hash)+"/export", ShareExportRequest{Path: path}, &resp)
+}
+
+// ImportTorrent shares the content of a .torrent file found under dir, which
+// must be valid on the peer's machine
+func (c *Client) ImportTorrent(data []byte, dir string) (*TorrentImportResponse, error) {
+	var resp TorrentImportResponse
+	return &resp, c.do(http.MethodPost, "/v1/torrents", TorrentImportRequest{Torrent: data, Path: dir}, &resp)
+}
+
+// ExportTorrent creates a .torrent file for a shared file
+func (c *Client) ExportTorrent(hash string, req TorrentExportRequest) (*TorrentExportResponse, error) {
+	var resp TorrentExportResponse
+	return &resp, c.do(http.MethodPost, "/v1/shares/"+url.PathEscape(hash)+"/torrent", req, &resp)
+}
+
+// Downloads lists queued and stored downloads
+func (c *Client) Downloads() ([]DownloadInfo, error) {
+	var resp []DownloadInfo
+	return resp, c.do(http.MethodGet, "/v1/downloads", nil, &resp)
+}
+
+// Download queues a download by file hash or magnet link. shareToken is
+// needed for a private file the peer was not given access to otherwise.
+func (c *Client) Download(hashOrMagnet, shareToken string) (*DownloadInfo, error) {
+	req := DownloadRequest{Hash: hashOrMagnet, ShareToken: shareToken}
+	if strings.HasPrefix(hashOrMagnet, "magnet:?") {
+		req = DownloadRequest{Magnet: hashOrMagnet, ShareToken: shareToken}
+	}
+	var resp DownloadInfo
+	return &resp, c.do(http.MethodPost, "/v1/downloads", req, &resp)
+}
+
+// DownloadMeta queues a download described by the content of a .p2pmeta file
+func (c *Client) DownloadMeta(data []byte) (*DownloadInfo, error) {
+	var resp DownloadInfo
+	return &resp, c.do(http.MethodPost, "/v1/downloads", DownloadRequest{Meta: data}, &resp)
+}
+
+// GetDownload returns one download
+func (c *Client) GetDownload(hash string) (*DownloadInfo, error) {
+	var resp DownloadInfo
+	return &resp, c.do(http.MethodGet, "/v1/downloads/"+url.PathEscape(hash), nil, &resp)
+}
+
+// Pause pauses a download
+func (c *Client) Pause(hash string) (*DownloadInfo, error) {
+	var resp DownloadInfo
+	return &resp, c.do(http.MethodPost, "/v1/downloads/"+url.PathEscape(hash)+"/pause", nil, &resp)
+}
+
+// Resume queues a paused download again
+func (c *Client) Resume(hash string) (*DownloadInfo, error) {
+	var resp DownloadInfo
+	return &resp, c.do(http.MethodPost, "/v1/downloads/"+url.PathEscape(hash)+"/resume", nil, &resp)
+}
+
+// Cancel cancels a download and deletes its partial data
+func (c *Client) Cancel(hash string) error {
+	return c.do(http.MethodDelete, "/v1/downloads/"+url.PathEscape(hash), nil, nil)
+}
+
+// Move places a queued download at a 1-based queue position
+func (c *Client) Move(hash string, position int) (*DownloadInfo, error) {
+	var resp DownloadInfo
+	return &resp, c.do(http.MethodPost, "/v1/downloads/"+url.PathEscape(hash)+"/move", MoveRequest{Position: position}, &resp)
+}
+
+// Queue returns the download queue
+func (c *Client) Queue() (*QueueResponse, error) {
+	var resp QueueResponse
+	return &resp, c.do(http.MethodGet, "/v1/queue", nil, &resp)
+}
+
+// SetMaxActive changes how many downloads run at the same time
+func (c *Client) SetMaxActive(n int) (*QueueResponse, error) {
+	var resp QueueResponse
+	return &resp, c.do(http.MethodPut, "/v1/queue", QueueUpdate{MaxActive: n}, &resp)
+}
+
+// Peers returns connected peers and the peers serving active downloads
+func (c *Client) Peers() (*PeersResponse, error) {
+	var resp PeersResponse
+	return &resp, c.do(http.MethodGet, "/v1/peers", nil, &resp)
+}
+
+// Bandwidth returns limits and transfer rates
+func (c *Client) Bandwidth() (*BandwidthResponse, error) {
+	var resp BandwidthResponse
+	return &resp, c.do(http.MethodGet, "/v1/bandwidth", nil, &resp)
+}
+
+// SetBandwidth sets the default limits ("2MB", "unlimited"; empty keeps the current value)
+func (c *Client) SetBandwidth(upload, download string) (*BandwidthResponse, error) {
+	var resp BandwidthResponse
+	return &resp, c.do(http.MethodPut, "/v1/bandwidth", BandwidthUpdate{Upload: upload, Download: download}, &resp)
+}
+
+// Events calls fn for each event streamed by the peer until ctx is done or
+// the connection closes. types optionally restricts the event types.
+func (c *Client) Events(ctx context.Context, types []string, fn func(events.Event)) error {
+	path := "/v1/events"
+	if len(types) > 0 {
+		path += "?types=" + url.QueryEscape(strings.Join(types, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readAPIError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event events.Event
+		if err := json.Unmarshal([]byte(data), &event); err == nil {
+			fn(event)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// do sends a request and decodes the JSON response into result (if not nil)
+func (c *Client) do(method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// Sharing hashes the whole file before answering
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach peer: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readAPIError(resp)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func readAPIError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	return &APIError{Status: resp.StatusCode, Message: body.Error}
+}
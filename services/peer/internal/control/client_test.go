@@ -0,0 +1,64 @@
+package control
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+	c := NewClient(addr, testToken)
+
+	status, err := c.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.PeerID != "peer-1" {
+		t.Errorf("PeerID = %q, want peer-1", status.PeerID)
+	}
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("shared through peerctl"), 0644)
+	share, err := c.Share(path, "")
+	if err != nil {
+		t.Fatalf("Share failed: %v", err)
+	}
+	shares, err := c.Shares()
+	if err != nil || len(shares) != 1 || shares[0].Hash != share.Hash {
+		t.Fatalf("Shares = %v, %v", shares, err)
+	}
+
+	exported, err := c.Export(share.Hash, filepath.Join(t.TempDir(), "copy.txt"))
+	if err != nil || exported.Size != share.Size {
+		t.Fatalf("Export = %+v, %v", exported, err)
+	}
+
+	info, err := c.Download("magnet:?xt=urn:sha256:cccc&dn=movie.mkv", "")
+	if err != nil {
+		t.Fatalf("Download by magnet failed: %v", err)
+	}
+	if info.Hash != "cccc" || info.Name != "movie.mkv" {
+		t.Errorf("Unexpected download %+v", info)
+	}
+	if _, err := c.Download(share.Hash, ""); err == nil {
+		t.Error("Downloading a local file should fail")
+	}
+	if err := c.Unshare(share.Hash); err != nil {
+		t.Fatalf("Unshare failed: %v", err)
+	}
+
+	var apiErr *APIError
+	if err := c.Unshare(share.Hash); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("Unshare of unknown file: got %v, want HTTP 404", err)
+	}
+	if _, err := NewClient(addr, "wrong").Status(); !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("Wrong token: got %v, want HTTP 401", err)
+	}
+}
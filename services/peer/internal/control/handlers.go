@@ -0,0 +1,666 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/magnet"
+	"github.com/p2p-filesharing/distributed-system/pkg/metafile"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/pkg/torrent"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/p2p"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// Status handles GET /v1/status
+func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
+	c := s.config
+	resp := StatusResponse{
+		PeerID:       c.PeerID,
+		Trackers:     c.Tracker.Status(),
+		StartedAt:    s.startedAt,
+		SharedFiles:  len(c.Store.GetAllSharedHashes()),
+		StorageUsed:  c.Store.UsedBytes(),
+		StorageQuota: c.Store.GetQuota().MaxBytes,
+	}
+	if len(resp.Trackers) > 0 {
+		resp.TrackerURL = resp.Trackers[0].URL
+	}
+	if c.P2P != nil {
+		resp.Port = c.P2P.GetPort()
+		resp.Connections = len(c.P2P.Connections())
+	}
+	for _, entry := range c.Downloads.Entries() {
+		switch entry.State {
+		case downloader.QueueActive:
+			resp.Active++
+		case downloader.QueueQueued:
+			resp.Queued++
+		case downloader.QueuePaused:
+			resp.Paused++
+		}
+	}
+	if chunks := c.Store.ChunkStore(); chunks != nil {
+		stats := chunks.Stats()
+		resp.ChunkStore = &stats
+	}
+	if c.Bandwidth != nil {
+		stats := c.Bandwidth.GetStats()
+		resp.UploadRate = stats.CurrentUpRate
+		resp.DownloadRate = stats.CurrentDownRate
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// ListShares handles GET /v1/shares
+func (s *Server) ListShares(w http.ResponseWriter, r *http.Request) {
+	shares := []ShareInfo{}
+	for _, shared := range s.config.Store.ListSharedFiles() {
+		shares = append(shares, s.shareInfo(shared))
+	}
+	slices.SortFunc(shares, func(a, b ShareInfo) int { return strings.Compare(a.Name, b.Name) })
+	sendJSON(w, http.StatusOK, shares)
+}
+
+// AddShare handles POST /v1/shares: hashes a local file, shares and announces it
+func (s *Server) AddShare(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		sendError(w, http.StatusBadRequest, "Request must contain a file path")
+		return
+	}
+	if !access.ValidVisibility(req.Visibility) {
+		sendError(w, http.StatusBadRequest, "visibility must be public, unlisted or private")
+		return
+	}
+
+	path, err := filepath.Abs(req.Path)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !info.Mode().IsRegular() {
+		sendError(w, http.StatusBadRequest, "Not a regular file")
+		return
+	}
+
+	metadata, err := s.config.Chunker.ChunkFile(path)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to hash file: %v", err))
+		return
+	}
+	metadata.Visibility = req.Visibility
+	s.config.Store.AddSharedFile(metadata, path)
+	s.config.Store.SaveState()
+
+	if _, err := s.config.Tracker.AnnounceFile(metadata); err != nil {
+		log.Printf("[Control] Error announcing %s: %v", metadata.Name, err)
+		sendError(w, http.StatusBadGateway, fmt.Sprintf("Shared locally but the announce failed: %v", err))
+		return
+	}
+
+	shared, _ := s.config.Store.GetSharedFile(metadata.Hash)
+	share := s.shareInfo(shared)
+	s.config.Events.Publish(events.ShareAdded, share)
+	sendJSON(w, http.StatusCreated, share)
+}
+
+// RemoveShare handles DELETE /v1/shares/{hash}
+func (s *Server) RemoveShare(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	shared, ok := s.config.Store.GetSharedFile(hash)
+	if !ok {
+		sendError(w, http.StatusNotFound, "File is not shared")
+		return
+	}
+
+	s.config.Store.RemoveSharedFile(hash)
+	if err := s.config.Tracker.WithdrawFile(hash); err != nil {
+		log.Printf("[Control] Error withdrawing %s: %v", shared.Metadata.Name, err)
+	}
+	s.config.Events.Publish(events.ShareRemoved, s.shareInfo(shared))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ImportTorrent handles POST /v1/torrents: checks local content against a
+// .torrent file, then shares and announces each of its files. A single-file
+// torrent's file can also be found by the torrent's info hashes.
+func (s *Server) ImportTorrent(w http.ResponseWriter, r *http.Request) {
+	var req TorrentImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Torrent) == 0 || req.Path == "" {
+		sendError(w, http.StatusBadRequest, "Request must contain a torrent and a path")
+		return
+	}
+	t, err := torrent.Parse(req.Torrent)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dir, err := filepath.Abs(req.Path)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := t.Verify(dir); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, torrent.ErrMismatch) {
+			status = http.StatusUnprocessableEntity
+		}
+		sendError(w, status, err.Error())
+		return
+	}
+
+	resp := TorrentImportResponse{Name: t.Name, InfoHash: t.InfoHash, InfoHashV2: t.InfoHashV2, Shares: []ShareInfo{}}
+	var announceErr error
+	for i := range t.Files {
+		f := &t.Files[i]
+		if f.Padding {
+			continue
+		}
+		path := t.FilePath(dir, f)
+		metadata, err := s.config.Chunker.ChunkFile(path)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to hash %s: %v", path, err))
+			return
+		}
+		if t.SingleFile() {
+			metadata.BTInfoHash, metadata.BTInfoHashV2 = t.InfoHash, t.InfoHashV2
+		}
+		s.config.Store.AddSharedFile(metadata, path)
+		if _, err := s.config.Tracker.AnnounceFile(metadata); err != nil {
+			log.Printf("[Control] Error announcing %s: %v", metadata.Name, err)
+			announceErr = err
+		}
+
+		shared, _ := s.config.Store.GetSharedFile(metadata.Hash)
+		share := s.shareInfo(shared)
+		s.config.Events.Publish(events.ShareAdded, share)
+		resp.Shares = append(resp.Shares, share)
+	}
+	s.config.Store.SaveState()
+	log.Printf("[Control] Imported torrent %s: %d files", t.Name, len(resp.Shares))
+
+	if announceErr != nil {
+		sendError(w, http.StatusBadGateway, fmt.Sprintf("Shared locally but the announce failed: %v", announceErr))
+		return
+	}
+	sendJSON(w, http.StatusCreated, resp)
+}
+
+// ExportTorrent handles POST /v1/shares/{hash}/torrent: creates a hybrid
+// v1/v2 .torrent file for a shared file, for BitTorrent users
+func (s *Server) ExportTorrent(w http.ResponseWriter, r *http.Request) {
+	shared, ok := s.config.Store.GetSharedFile(r.PathValue("hash"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "File is not shared")
+		return
+	}
+	var req TorrentExportRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	path := shared.FilePath
+	if shared.Stored {
+		// The torrent is hashed from a copy of a file kept in the chunk store
+		tmp, err := os.CreateTemp("", "p2p-torrent-*")
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		tmp.Close()
+		path = tmp.Name()
+		defer os.Remove(path)
+		if err := s.config.Store.ExportFile(shared.Metadata.Hash, path); err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	t, err := torrent.Create(path, torrent.Options{Name: shared.Metadata.Name, PieceLength: req.PieceLength})
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t.Trackers, t.WebSeeds, t.Comment = req.Trackers, req.WebSeeds, req.Comment
+	t.CreatedBy = "p2p-peer"
+	data, err := t.Marshal()
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sendJSON(w, http.StatusOK, TorrentExportResponse{
+		InfoHash:   t.InfoHash,
+		InfoHashV2: t.InfoHashV2,
+		Magnet:     t.Magnet().String(),
+		Torrent:    data,
+	})
+}
+
+// ExportShare handles POST /v1/shares/{hash}/export: writes the content of a
+// shared file to a path, e.g. to open a download kept in the chunk store
+func (s *Server) ExportShare(w http.ResponseWriter, r *http.Request) {
+	shared, ok := s.config.Store.GetSharedFile(r.PathValue("hash"))
+	if !ok {
+		sendError(w, http.StatusNotFound, "File is not shared")
+		return
+	}
+	var req ShareExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		sendError(w, http.StatusBadRequest, "Request must contain a path")
+		return
+	}
+	path, err := filepath.Abs(req.Path)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, filepath.Base(shared.Metadata.Name))
+	}
+	if _, err := os.Stat(path); err == nil {
+		sendError(w, http.StatusConflict, fmt.Sprintf("%s already exists", path))
+		return
+	}
+
+	if err := s.config.Store.ExportFile(shared.Metadata.Hash, path); err != nil {
+		sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to export file: %v", err))
+		return
+	}
+	sendJSON(w, http.StatusOK, ShareExportResponse{Path: path, Size: shared.Metadata.Size})
+}
+
+// ListDownloads handles GET /v1/downloads: queued downloads first, in queue
+// order, then the other downloads in local storage
+func (s *Server) ListDownloads(w http.ResponseWriter, r *http.Request) {
+	downloads := []DownloadInfo{}
+	seen := make(map[string]bool)
+	for _, entry := range s.config.Downloads.Entries() {
+		downloads = append(downloads, s.downloadInfo(entry.Hash, &entry))
+		seen[entry.Hash] = true
+	}
+
+	var stored []DownloadInfo
+	for _, state := range s.config.Store.ListDownloads() {
+		if !seen[state.Metadata.Hash] {
+			stored = append(stored, s.downloadInfo(state.Metadata.Hash, nil))
+		}
+	}
+	slices.SortFunc(stored, func(a, b DownloadInfo) int { return strings.Compare(a.Name, b.Name) })
+	sendJSON(w, http.StatusOK, append(downloads, stored...))
+}
+
+// AddDownload handles POST /v1/downloads: queues a download by hash, magnet
+// link (including BitTorrent links to imported torrents) or .p2pmeta file
+func (s *Server) AddDownload(w http.ResponseWriter, r *http.Request) {
+	var req DownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if len(req.Meta) > 0 {
+		s.addMetaDownload(w, req.Meta)
+		return
+	}
+
+	hash, name := req.Hash, ""
+	if req.Magnet != "" {
+		m, err := magnet.Parse(req.Magnet)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid magnet link: %v", err))
+			return
+		}
+		hash, name = m.InfoHash, m.DisplayName
+		s.config.Tracker.AddFileTrackers(hash, m.Trackers)
+		if hash == m.BTInfoHash || hash == m.BTInfoHashV2 {
+			// BitTorrent-only link: trackers know imported torrents by their info hash
+			info, err := s.config.Tracker.GetPeers(hash)
+			if err != nil || info.FileHash == "" {
+				sendError(w, http.StatusNotFound, fmt.Sprintf("No tracker knows BitTorrent info hash %s", hash))
+				return
+			}
+			hash = info.FileHash
+			s.config.Tracker.AddFileTrackers(hash, m.Trackers)
+		}
+	}
+	if hash == "" {
+		sendError(w, http.StatusBadRequest, "Request must contain a hash or a magnet link")
+		return
+	}
+	if req.ShareToken != "" {
+		s.config.Tracker.SetShareToken(hash, req.ShareToken)
+	}
+
+	if err := s.config.Downloads.Enqueue(hash, name); err != nil {
+		sendError(w, http.StatusConflict, err.Error())
+		return
+	}
+	entry, _ := s.config.Downloads.Entry(hash)
+	sendJSON(w, http.StatusAccepted, s.downloadInfo(hash, &entry))
+}
+
+// addMetaDownload queues a download described by a .p2pmeta file
+func (s *Server) addMetaDownload(w http.ResponseWriter, data []byte) {
+	meta, err := metafile.Parse(data)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hash := meta.File.Hash
+	s.config.Tracker.AddFileTrackers(hash, meta.Trackers)
+
+	if err := s.config.Downloads.EnqueueMetadata(&meta.File, meta.WebSeeds); err != nil {
+		sendError(w, http.StatusConflict, err.Error())
+		return
+	}
+	entry, _ := s.config.Downloads.Entry(hash)
+	sendJSON(w, http.StatusAccepted, s.downloadInfo(hash, &entry))
+}
+
+// GetDownload handles GET /v1/downloads/{hash}
+func (s *Server) GetDownload(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if entry, ok := s.config.Downloads.Entry(hash); ok {
+		sendJSON(w, http.StatusOK, s.downloadInfo(hash, &entry))
+		return
+	}
+	if _, ok := s.config.Store.GetDownload(hash); ok {
+		sendJSON(w, http.StatusOK, s.downloadInfo(hash, nil))
+		return
+	}
+	sendError(w, http.StatusNotFound, "Download not found")
+}
+
+// PauseDownload handles POST /v1/downloads/{hash}/pause
+func (s *Server) PauseDownload(w http.ResponseWriter, r *http.Request) {
+	s.downloadAction(w, r, s.config.Downloads.Pause)
+}
+
+// ResumeDownload handles POST /v1/downloads/{hash}/resume
+func (s *Server) ResumeDownload(w http.ResponseWriter, r *http.Request) {
+	s.downloadAction(w, r, s.config.Downloads.Resume)
+}
+
+// CancelDownload handles DELETE /v1/downloads/{hash}: stops the download and deletes partial data
+func (s *Server) CancelDownload(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if err := s.config.Downloads.Cancel(hash); err != nil {
+		sendError(w, errorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MoveDownload handles POST /v1/downloads/{hash}/move
+func (s *Server) MoveDownload(w http.ResponseWriter, r *http.Request) {
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Position < 1 {
+		sendError(w, http.StatusBadRequest, "Request must contain a position (1 = next)")
+		return
+	}
+	s.downloadAction(w, r, func(hash string) error {
+		return s.config.Downloads.Move(hash, req.Position)
+	})
+}
+
+// downloadAction applies action to the download in the path and returns its new state
+func (s *Server) downloadAction(w http.ResponseWriter, r *http.Request, action func(hash string) error) {
+	hash := r.PathValue("hash")
+	if err := action(hash); err != nil {
+		sendError(w, errorStatus(err), err.Error())
+		return
+	}
+	if entry, ok := s.config.Downloads.Entry(hash); ok {
+		sendJSON(w, http.StatusOK, s.downloadInfo(hash, &entry))
+		return
+	}
+	sendJSON(w, http.StatusOK, s.downloadInfo(hash, nil))
+}
+
+// GetQueue handles GET /v1/queue
+func (s *Server) GetQueue(w http.ResponseWriter, r *http.Request) {
+	entries := s.config.Downloads.Entries()
+	if entries == nil {
+		entries = []downloader.QueueEntry{}
+	}
+	sendJSON(w, http.StatusOK, QueueResponse{
+		MaxActive: s.config.Downloads.MaxActive(),
+		Entries:   entries,
+	})
+}
+
+// UpdateQueue handles PUT /v1/queue
+func (s *Server) UpdateQueue(w http.ResponseWriter, r *http.Request) {
+	var req QueueUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxActive < 1 {
+		sendError(w, http.StatusBadRequest, "max_active must be at least 1")
+		return
+	}
+	s.config.Downloads.SetMaxActive(req.MaxActive)
+	s.GetQueue(w, r)
+}
+
+// ListPeers handles GET /v1/peers
+func (s *Server) ListPeers(w http.ResponseWriter, r *http.Request) {
+	resp := PeersResponse{Downloads: []DownloadPeers{}}
+	if s.config.P2P != nil {
+		resp.Connections = s.config.P2P.Connections()
+	}
+	if resp.Connections == nil {
+		resp.Connections = []p2p.ConnectionStats{}
+	}
+
+	for _, entry := range s.config.Downloads.Entries() {
+		if entry.State != downloader.QueueActive || s.config.Downloader == nil {
+			continue
+		}
+		stats, ok := s.config.Downloader.GetDownloadStats(entry.Hash)
+		if !ok {
+			continue
+		}
+		peers := DownloadPeers{Hash: entry.Hash, Peers: []PeerInfo{}}
+		for _, p := range stats.Peers() {
+			peers.Peers = append(peers.Peers, PeerInfo{
+				PeerID:       p.PeerID,
+				Chunks:       p.ChunksDownloaded,
+				Bytes:        p.BytesDownloaded,
+				Failures:     p.Failures,
+				AvgLatencyMs: p.AvgLatency.Milliseconds(),
+				Score:        p.Score,
+			})
+		}
+		resp.Downloads = append(resp.Downloads, peers)
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// GetBandwidth handles GET /v1/bandwidth
+func (s *Server) GetBandwidth(w http.ResponseWriter, r *http.Request) {
+	if s.config.Bandwidth == nil {
+		sendError(w, http.StatusNotImplemented, "Bandwidth management is not enabled")
+		return
+	}
+
+	up, down := s.config.Bandwidth.GetLimits()
+	stats := s.config.Bandwidth.GetStats()
+	resp := BandwidthResponse{
+		UploadLimit:     up,
+		DownloadLimit:   down,
+		UploadRate:      stats.CurrentUpRate,
+		DownloadRate:    stats.CurrentDownRate,
+		TotalUploaded:   stats.TotalUploaded,
+		TotalDownloaded: stats.TotalDownloaded,
+	}
+	if s.config.Scheduler != nil {
+		schedule := s.config.Scheduler.GetSchedule()
+		resp.DefaultUpload = schedule.DefaultUpload
+		resp.DefaultDownload = schedule.DefaultDownload
+		for _, rule := range schedule.Rules {
+			resp.Schedule = append(resp.Schedule, rule.String())
+		}
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// UpdateBandwidth handles PUT /v1/bandwidth: sets the default limits
+func (s *Server) UpdateBandwidth(w http.ResponseWriter, r *http.Request) {
+	if s.config.Scheduler == nil {
+		sendError(w, http.StatusNotImplemented, "Bandwidth management is not enabled")
+		return
+	}
+
+	var req BandwidthUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	schedule := s.config.Scheduler.GetSchedule()
+	up, down := schedule.DefaultUpload, schedule.DefaultDownload
+	var err error
+	if req.Upload != "" {
+		if up, err = throttle.ParseRate(req.Upload); err != nil {
+			sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.Download != "" {
+		if down, err = throttle.ParseRate(req.Download); err != nil {
+			sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	s.config.Scheduler.SetDefaultLimits(up, down)
+	s.GetBandwidth(w, r)
+}
+
+// StreamEvents handles GET /v1/events as a Server-Sent Events stream.
+// ?types=download.progress,share.added restricts the stream to some event types.
+func (s *Server) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	var types map[string]bool
+	if filter := r.URL.Query().Get("types"); filter != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(filter, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	ch := s.config.Events.Subscribe()
+	defer s.config.Events.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event := <-ch:
+			if types != nil && !types[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// shareInfo describes a shared file, with a magnet link to it
+func (s *Server) shareInfo(shared *storage.SharedFile) ShareInfo {
+	m := magnet.New(shared.Metadata.Hash, shared.Metadata.Name, shared.Metadata.Size).
+		SetChunkInfo(int(shared.Metadata.ChunkSize), len(shared.Metadata.Chunks))
+	m.BTInfoHash, m.BTInfoHashV2 = shared.Metadata.BTInfoHash, shared.Metadata.BTInfoHashV2
+	for _, tracker := range s.config.Tracker.Status() {
+		m.AddTracker(tracker.URL)
+	}
+	return ShareInfo{
+		Hash:   shared.Metadata.Hash,
+		Name:   shared.Metadata.Name,
+		Size:   shared.Metadata.Size,
+		Chunks: len(shared.Metadata.Chunks),
+		Path:   shared.FilePath,
+		Stored: shared.Stored,
+		Magnet: m.String(),
+
+		Visibility: shared.Metadata.Visibility,
+	}
+}
+
+// downloadInfo describes a download from its queue entry (if managed) and its stored state
+func (s *Server) downloadInfo(hash string, entry *downloader.QueueEntry) DownloadInfo {
+	info := DownloadInfo{Hash: hash}
+	if state, ok := s.config.Store.GetDownload(hash); ok {
+		info.Name = state.Metadata.Name
+		info.Size = state.TotalBytes
+		info.Status = string(state.Status)
+		info.OutputPath = state.OutputPath
+		info.Error = state.LastError
+		info.Progress, _ = s.config.Store.GetDownloadProgress(hash)
+	}
+	if entry != nil {
+		if info.Name == "" {
+			info.Name = entry.Name
+		}
+		info.Status = entry.State
+		info.Position = entry.Position
+		if entry.Error != "" {
+			info.Error = entry.Error
+		}
+		if s.config.Downloader != nil && entry.State == downloader.QueueActive {
+			if stats, ok := s.config.Downloader.GetDownloadStats(hash); ok {
+				info.Speed = stats.Speed()
+			}
+		}
+	}
+	return info
+}
+
+// errorStatus maps download errors to HTTP status codes
+func errorStatus(err error) int {
+	var spaceErr *storage.SpaceError
+	switch {
+	case errors.Is(err, storage.ErrDownloadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrDownloadNotActive), errors.Is(err, storage.ErrDownloadNotPaused),
+		errors.Is(err, downloader.ErrNotQueued), errors.Is(err, downloader.ErrAlreadyQueued):
+		return http.StatusConflict
+	case errors.As(err, &spaceErr):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,276 @@
+// Package control implements the local HTTP/JSON API used to control a
+// running peer (peerctl, scripts, desktop UIs). It only listens on loopback
+// addresses or Unix sockets and every request needs the control token.
+package control
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/client"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/p2p"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// DefaultAddr is the default control API address
+const DefaultAddr = "127.0.0.1:6880"
+
+// Tracker announces and withdraws shared files (implemented by client.TrackerClient)
+type Tracker interface {
+	AnnounceFile(file *protocol.FileMetadata) (*protocol.AnnounceResponse, error)
+	WithdrawFile(fileHash string) error
+	AddFileTrackers(fileHash string, trackerURLs []string)
+	GetPeers(fileHash string) (*protocol.GetPeersResponse, error)
+	SetShareToken(fileHash, token string)
+	Status() []client.TrackerStatus
+}
+
+// Config holds the peer components controlled through the API
+type Config struct {
+	Addr       string // host:port on a loopback address, or "unix:/path/to/socket"
+	Token      string // Required as "Authorization: Bearer <token>"
+	PeerID     string
+	Store      *storage.LocalStorage
+	Tracker    Tracker
+	Chunker    *chunker.Chunker
+	Downloads  *downloader.Manager
+	Downloader *downloader.Downloader // Source of per-download peer stats
+	P2P        *p2p.Server            // Optional
+	Bandwidth  *throttle.BandwidthManager
+	Scheduler  *throttle.Scheduler
+	Events     *events.Bus
+}
+
+// Server is the control API server
+type Server struct {
+	config    Config
+	startedAt time.Time
+	listener  net.Listener
+	http      *http.Server
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewServer creates a control API server
+func NewServer(config Config) *Server {
+	if config.Events == nil {
+		config.Events = events.NewBus()
+	}
+	return &Server{
+		config:    config,
+		startedAt: time.Now(),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Handler returns the API routes wrapped in token authentication
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/status", s.Status)
+
+	mux.HandleFunc("GET /v1/shares", s.ListShares)
+	mux.HandleFunc("POST /v1/shares", s.AddShare)
+	mux.HandleFunc("DELETE /v1/shares/{hash}", s.RemoveShare)
+	mux.HandleFunc("POST /v1/shares/{hash}/torrent", s.ExportTorrent)
+	mux.HandleFunc("POST /v1/shares/{hash}/export", s.ExportShare)
+	mux.HandleFunc("POST /v1/torrents", s.ImportTorrent)
+
+	mux.HandleFunc("GET /v1/downloads", s.ListDownloads)
+	mux.HandleFunc("POST /v1/downloads", s.AddDownload)
+	mux.HandleFunc("GET /v1/downloads/{hash}", s.GetDownload)
+	mux.HandleFunc("DELETE /v1/downloads/{hash}", s.CancelDownload)
+	mux.HandleFunc("POST /v1/downloads/{hash}/pause", s.PauseDownload)
+	mux.HandleFunc("POST /v1/downloads/{hash}/resume", s.ResumeDownload)
+	mux.HandleFunc("POST /v1/downloads/{hash}/move", s.MoveDownload)
+
+	mux.HandleFunc("GET /v1/queue", s.GetQueue)
+	mux.HandleFunc("PUT /v1/queue", s.UpdateQueue)
+
+	mux.HandleFunc("GET /v1/peers", s.ListPeers)
+	mux.HandleFunc("GET /v1/bandwidth", s.GetBandwidth)
+	mux.HandleFunc("PUT /v1/bandwidth", s.UpdateBandwidth)
+
+	mux.HandleFunc("GET /v1/events", s.StreamEvents)
+
+	return s.authenticate(mux)
+}
+
+// Start listens on the configured address and serves the API in the background
+func (s *Server) Start() error {
+	listener, err := Listen(s.config.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.http = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := s.http.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Control] Server error: %v", err)
+		}
+	}()
+	go s.updateBandwidthStats()
+
+	log.Printf("[Control] API listening on %s", s.config.Addr)
+	return nil
+}
+
+// Stop closes the listener and all connections
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	if s.http == nil {
+		return nil
+	}
+	err := s.http.Close()
+	if path, ok := strings.CutPrefix(s.config.Addr, "unix:"); ok {
+		removeSocket(path)
+	}
+	return err
+}
+
+// updateBandwidthStats refreshes the transfer rates and publishes them every second
+func (s *Server) updateBandwidthStats() {
+	if s.config.Bandwidth == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.config.Bandwidth.UpdateStats()
+			stats := s.config.Bandwidth.GetStats()
+			s.config.Events.Publish(events.BandwidthStats, map[string]int64{
+				"upload_rate":   stats.CurrentUpRate,
+				"download_rate": stats.CurrentDownRate,
+			})
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// authenticate rejects requests without the control token. The token may also
+// be passed as ?token= to the events stream, for clients such as EventSource
+// that cannot set headers.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		} else if r.URL.Path == "/v1/events" {
+			token = r.URL.Query().Get("token")
+		}
+		if s.config.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			sendError(w, http.StatusUnauthorized, "Invalid or missing control token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Listen opens a listener for a control address: "unix:/path" for a Unix
+// socket (readable by the owner only), otherwise a TCP address that must be
+// on a loopback interface
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Remove a socket left behind by a previous run
+		if err := removeSocket(path); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		os.Chmod(path, 0600)
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control address %q: %w", addr, err)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("control address %q is not a loopback address", addr)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// LoadOrCreateToken returns the token stored at path, creating a random one
+// (readable by the owner only) if the file does not exist
+func LoadOrCreateToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func sendJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func sendError(w http.ResponseWriter, status int, message string) {
+	sendJSON(w, status, map[string]string{"error": message})
+}
+
+// removeSocket removes the Unix socket at path, if any. Anything else at path
+// is left alone and reported, so a mistyped address cannot delete a file.
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("control socket path %q exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
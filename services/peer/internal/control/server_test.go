@@ -0,0 +1,470 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/metafile"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/torrent"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/client"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+const testToken = "secret"
+
+type fakeTracker struct {
+	announced []string
+	withdrawn []string
+	release   chan struct{}
+}
+
+func (f *fakeTracker) AnnounceFile(file *protocol.FileMetadata) (*protocol.AnnounceResponse, error) {
+	f.announced = append(f.announced, file.Hash)
+	return &protocol.AnnounceResponse{Success: true, FileID: file.Hash}, nil
+}
+
+func (f *fakeTracker) WithdrawFile(fileHash string) error {
+	f.withdrawn = append(f.withdrawn, fileHash)
+	return nil
+}
+
+func (f *fakeTracker) AddFileTrackers(fileHash string, trackerURLs []string) {}
+func (f *fakeTracker) SetShareToken(fileHash, token string)                  {}
+
+func (f *fakeTracker) ReportAvailability(fileHash string, have protocol.ChunkSet) error {
+	return nil
+}
+
+func (f *fakeTracker) Announce(fileHash, event string, have protocol.ChunkSet) error {
+	return nil
+}
+
+func (f *fakeTracker) Status() []client.TrackerStatus {
+	return []client.TrackerStatus{{URL: "http://tracker.test", Up: true}}
+}
+
+// GetPeers blocks until released so queued downloads stay active
+func (f *fakeTracker) GetPeers(fileHash string) (*protocol.GetPeersResponse, error) {
+	<-f.release
+	return nil, errors.New("no peers")
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeTracker) {
+	t.Helper()
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	tracker := &fakeTracker{release: make(chan struct{})}
+	bus := events.NewBus()
+	dl := downloader.New(store, nil)
+	downloads := downloader.NewManager(dl, store, tracker, bus, 1)
+	t.Cleanup(func() {
+		downloads.Stop()
+		close(tracker.release)
+	})
+
+	return NewServer(Config{
+		Token:      testToken,
+		PeerID:     "peer-1",
+		Store:      store,
+		Tracker:    tracker,
+		Chunker:    chunker.New(1024),
+		Downloads:  downloads,
+		Downloader: dl,
+		Events:     bus,
+	}), tracker
+}
+
+func doRequest(t *testing.T, h http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.Handler()
+
+	for _, header := range []string{"", "Bearer wrong", testToken} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/status", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", header, w.Code)
+		}
+	}
+
+	// The query token is only accepted by the events stream
+	r := httptest.NewRequest(http.MethodGet, "/v1/status?token="+testToken, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Query token on /v1/status: status %d, want 401", w.Code)
+	}
+
+	if w := doRequest(t, h, http.MethodGet, "/v1/status", nil); w.Code != http.StatusOK {
+		t.Errorf("Valid token: status %d, want 200", w.Code)
+	}
+}
+
+func TestShares(t *testing.T) {
+	s, tracker := newTestServer(t)
+	h := s.Handler()
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("shared through the control API"), 0644)
+
+	w := doRequest(t, h, http.MethodPost, "/v1/shares", ShareRequest{Path: path})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/shares: status %d: %s", w.Code, w.Body)
+	}
+	var share ShareInfo
+	json.NewDecoder(w.Body).Decode(&share)
+	if share.Name != "notes.txt" || !strings.HasPrefix(share.Magnet, "magnet:?") {
+		t.Errorf("Unexpected share %+v", share)
+	}
+	if len(tracker.announced) != 1 || tracker.announced[0] != share.Hash {
+		t.Errorf("Announced %v, want %s", tracker.announced, share.Hash)
+	}
+
+	var shares []ShareInfo
+	json.NewDecoder(doRequest(t, h, http.MethodGet, "/v1/shares", nil).Body).Decode(&shares)
+	if len(shares) != 1 {
+		t.Fatalf("Expected 1 share, got %d", len(shares))
+	}
+
+	if w := doRequest(t, h, http.MethodDelete, "/v1/shares/"+share.Hash, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE share: status %d", w.Code)
+	}
+	if len(tracker.withdrawn) != 1 {
+		t.Error("Removed share should be withdrawn from the tracker")
+	}
+	if w := doRequest(t, h, http.MethodDelete, "/v1/shares/"+share.Hash, nil); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE unknown share: status %d, want 404", w.Code)
+	}
+
+	if w := doRequest(t, h, http.MethodPost, "/v1/shares", ShareRequest{Path: t.TempDir()}); w.Code != http.StatusBadRequest {
+		t.Errorf("Sharing a directory: status %d, want 400", w.Code)
+	}
+}
+
+func TestShareExport(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.Handler()
+
+	content := []byte("exported through the control API")
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, content, 0644)
+	var share ShareInfo
+	json.NewDecoder(doRequest(t, h, http.MethodPost, "/v1/shares", ShareRequest{Path: path}).Body).Decode(&share)
+	if share.Stored {
+		t.Error("A file shared from disk is not in the chunk store")
+	}
+
+	// A directory gets a file named after the share
+	dir := t.TempDir()
+	w := doRequest(t, h, http.MethodPost, "/v1/shares/"+share.Hash+"/export", ShareExportRequest{Path: dir})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST export: status %d: %s", w.Code, w.Body)
+	}
+	var exported ShareExportResponse
+	json.NewDecoder(w.Body).Decode(&exported)
+	if exported.Path != filepath.Join(dir, "notes.txt") || exported.Size != int64(len(content)) {
+		t.Errorf("Unexpected export %+v", exported)
+	}
+	if data, _ := os.ReadFile(exported.Path); !bytes.Equal(data, content) {
+		t.Errorf("Exported content %q, want %q", data, content)
+	}
+
+	for _, c := range []struct {
+		hash string
+		body any
+		want int
+	}{
+		{share.Hash, ShareExportRequest{Path: dir}, http.StatusConflict},
+		{share.Hash, nil, http.StatusBadRequest},
+		{"unknown", ShareExportRequest{Path: dir}, http.StatusNotFound},
+	} {
+		if w := doRequest(t, h, http.MethodPost, "/v1/shares/"+c.hash+"/export", c.body); w.Code != c.want {
+			t.Errorf("Export %s %+v: status %d, want %d", c.hash, c.body, w.Code, c.want)
+		}
+	}
+}
+
+func TestDownloadQueue(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.Handler()
+
+	w := doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{Hash: "aaaa"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /v1/downloads: status %d: %s", w.Code, w.Body)
+	}
+	magnetLink := "magnet:?xt=urn:sha256:bbbb&dn=movie.mkv"
+	doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{Magnet: magnetLink})
+	doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{Hash: "cccc"})
+
+	if w := doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{Hash: "aaaa"}); w.Code != http.StatusConflict {
+		t.Errorf("Queueing twice: status %d, want 409", w.Code)
+	}
+	if w := doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{}); w.Code != http.StatusBadRequest {
+		t.Errorf("Empty request: status %d, want 400", w.Code)
+	}
+
+	var info DownloadInfo
+	json.NewDecoder(doRequest(t, h, http.MethodGet, "/v1/downloads/bbbb", nil).Body).Decode(&info)
+	if info.Name != "movie.mkv" || info.Status != downloader.QueueQueued || info.Position != 1 {
+		t.Errorf("Magnet download = %+v, want movie.mkv queued at position 1", info)
+	}
+
+	// Move cccc to the front, pause the active download
+	doRequest(t, h, http.MethodPost, "/v1/downloads/cccc/move", MoveRequest{Position: 1})
+	if w := doRequest(t, h, http.MethodPost, "/v1/downloads/aaaa/pause", nil); w.Code != http.StatusOK {
+		t.Fatalf("Pause: status %d: %s", w.Code, w.Body)
+	}
+
+	var queue QueueResponse
+	json.NewDecoder(doRequest(t, h, http.MethodGet, "/v1/queue", nil).Body).Decode(&queue)
+	var order []string
+	for _, entry := range queue.Entries {
+		order = append(order, entry.Hash+":"+entry.State)
+	}
+	if got := strings.Join(order, " "); got != "cccc:active bbbb:queued aaaa:paused" {
+		t.Errorf("Queue = %s", got)
+	}
+
+	if w := doRequest(t, h, http.MethodDelete, "/v1/downloads/bbbb", nil); w.Code != http.StatusNoContent {
+		t.Errorf("Cancel: status %d", w.Code)
+	}
+	if w := doRequest(t, h, http.MethodGet, "/v1/downloads/bbbb", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Cancelled download: status %d, want 404", w.Code)
+	}
+	if w := doRequest(t, h, http.MethodPost, "/v1/downloads/aaaa/move", MoveRequest{Position: 1}); w.Code != http.StatusConflict {
+		t.Errorf("Moving a paused download: status %d, want 409", w.Code)
+	}
+
+	w = doRequest(t, h, http.MethodPut, "/v1/queue", QueueUpdate{MaxActive: 4})
+	json.NewDecoder(w.Body).Decode(&queue)
+	if queue.MaxActive != 4 {
+		t.Errorf("MaxActive = %d, want 4", queue.MaxActive)
+	}
+}
+
+func TestDownloadMetaFile(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.Handler()
+
+	path := filepath.Join(t.TempDir(), "album.zip")
+	os.WriteFile(path, bytes.Repeat([]byte("offline "), 500), 0644)
+	meta, err := metafile.Create(path, chunker.New(1024), nil, []string{"https://cdn.example.com/album.zip"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	data, _ := meta.Marshal()
+
+	w := doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{Meta: data})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /v1/downloads with meta: status %d: %s", w.Code, w.Body)
+	}
+	var info DownloadInfo
+	json.NewDecoder(w.Body).Decode(&info)
+	if info.Hash != meta.File.Hash || info.Name != "album.zip" || info.Size != meta.File.Size {
+		t.Errorf("Download = %+v, want album.zip from the metafile", info)
+	}
+	state, ok := s.config.Store.GetDownload(meta.File.Hash)
+	if !ok || !state.FromMetaFile || len(state.WebSeeds) != 1 {
+		t.Errorf("Stored download should keep the metafile metadata and web seeds: %+v", state)
+	}
+
+	meta.File.Size++
+	data, _ = meta.Marshal()
+	if w := doRequest(t, h, http.MethodPost, "/v1/downloads", DownloadRequest{Meta: data}); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid metafile: status %d, want 400", w.Code)
+	}
+}
+
+func TestTorrentImportExport(t *testing.T) {
+	s, tracker := newTestServer(t)
+	h := s.Handler()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dataset.csv")
+	os.WriteFile(path, bytes.Repeat([]byte("a,b,c\n"), 10000), 0644)
+	tor, err := torrent.Create(path, torrent.Options{})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	data, _ := tor.Marshal()
+
+	w := doRequest(t, h, http.MethodPost, "/v1/torrents", TorrentImportRequest{Torrent: data, Path: dir})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/torrents: status %d: %s", w.Code, w.Body)
+	}
+	var imported TorrentImportResponse
+	json.NewDecoder(w.Body).Decode(&imported)
+	if len(imported.Shares) != 1 || len(tracker.announced) != 1 || imported.InfoHash != tor.InfoHash {
+		t.Fatalf("Import = %+v, announced %v", imported, tracker.announced)
+	}
+	shared, _ := s.config.Store.GetSharedFile(imported.Shares[0].Hash)
+	if shared.Metadata.BTInfoHash != tor.InfoHash || shared.Metadata.BTInfoHashV2 != tor.InfoHashV2 {
+		t.Errorf("Shared metadata should carry the info hashes: %+v", shared.Metadata)
+	}
+	if !strings.Contains(imported.Shares[0].Magnet, "urn:btih:"+tor.InfoHash) {
+		t.Errorf("Magnet %s should carry the BitTorrent info hash", imported.Shares[0].Magnet)
+	}
+
+	// Same file, name and piece length: the same torrent comes back
+	w = doRequest(t, h, http.MethodPost, "/v1/shares/"+shared.Metadata.Hash+"/torrent", TorrentExportRequest{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /v1/shares/{hash}/torrent: status %d: %s", w.Code, w.Body)
+	}
+	var exported TorrentExportResponse
+	json.NewDecoder(w.Body).Decode(&exported)
+	if exported.InfoHash != tor.InfoHash || exported.InfoHashV2 != tor.InfoHashV2 {
+		t.Errorf("Exported info hashes %s/%s, want %s/%s", exported.InfoHash, exported.InfoHashV2, tor.InfoHash, tor.InfoHashV2)
+	}
+	if _, err := torrent.Parse(exported.Torrent); err != nil {
+		t.Errorf("Exported torrent does not parse: %v", err)
+	}
+
+	os.WriteFile(path, []byte("changed"), 0644)
+	w = doRequest(t, h, http.MethodPost, "/v1/torrents", TorrentImportRequest{Torrent: data, Path: dir})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Import of changed content: status %d, want 422", w.Code)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/events?types=download.queued&token=" + testToken)
+	if err != nil {
+		t.Fatalf("GET /v1/events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /v1/events: status %d", resp.StatusCode)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	s.config.Events.Publish(events.BandwidthStats, nil) // filtered out
+	s.config.Downloads.Enqueue("dddd", "file.bin")
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "event: ") && line != "event: "+events.DownloadQueued {
+				t.Fatalf("Unexpected event %q", line)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				if !strings.Contains(line, `"hash":"dddd"`) {
+					t.Errorf("Unexpected data %q", line)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("No event received")
+		}
+	}
+}
+
+func TestListenRequiresLoopback(t *testing.T) {
+	if l, err := Listen("0.0.0.0:0"); err == nil {
+		l.Close()
+		t.Error("Listening on all interfaces should be refused")
+	}
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen on loopback failed: %v", err)
+	}
+	l.Close()
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ctl") // Short enough for a socket path
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peer.sock")
+
+	// A file that is not a socket is not removed
+	os.WriteFile(path, []byte("keep"), 0600)
+	if l, err := Listen("unix:" + path); err == nil {
+		l.Close()
+		t.Error("Listening over a regular file should be refused")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep" {
+		t.Error("The regular file should be left alone")
+	}
+	os.Remove(path)
+
+	// A socket left behind by a previous run is replaced
+	l, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("Listen over a stale socket failed: %v", err)
+	}
+	l.Close()
+}
+
+func TestStopTwice(t *testing.T) {
+	server := NewServer(Config{Addr: "127.0.0.1:0", Token: testToken})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	server.Stop()
+	server.Stop()
+}
+
+func TestLoadOrCreateToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.token")
+	token, err := LoadOrCreateToken(path)
+	if err != nil || len(token) != 64 {
+		t.Fatalf("LoadOrCreateToken = %q, %v", token, err)
+	}
+	again, _ := LoadOrCreateToken(path)
+	if again != token {
+		t.Error("Token should be reused once created")
+	}
+}
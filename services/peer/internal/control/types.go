@@ -0,0 +1,170 @@
+package control
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/client"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/downloader"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/p2p"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// StatusResponse is returned by GET /v1/status
+type StatusResponse struct {
+	PeerID       string                   `json:"peer_id"`
+	TrackerURL   string                   `json:"tracker_url"` // First configured tracker
+	Trackers     []client.TrackerStatus   `json:"trackers"`
+	Port         int                      `json:"port"`
+	StartedAt    time.Time                `json:"started_at"`
+	SharedFiles  int                      `json:"shared_files"`
+	Active       int                      `json:"active_downloads"`
+	Queued       int                      `json:"queued_downloads"`
+	Paused       int                      `json:"paused_downloads"`
+	Connections  int                      `json:"connections"`
+	StorageUsed  int64                    `json:"storage_used"`
+	StorageQuota int64                    `json:"storage_quota,omitempty"`
+	ChunkStore   *storage.ChunkStoreStats `json:"chunk_store,omitempty"`
+	UploadRate   int64                    `json:"upload_rate"`   // Bytes per second
+	DownloadRate int64                    `json:"download_rate"` // Bytes per second
+}
+
+// ShareRequest is the body of POST /v1/shares
+type ShareRequest struct {
+	Path       string `json:"path"`
+	Visibility string `json:"visibility,omitempty"` // public (default), unlisted or private
+}
+
+// ShareInfo describes a shared file
+type ShareInfo struct {
+	Hash   string `json:"hash"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Chunks int    `json:"chunks"`
+	Path   string `json:"path"`             // Empty for a file kept in the chunk store
+	Stored bool   `json:"stored,omitempty"` // Kept in the chunk store, see POST /v1/shares/{hash}/export
+	Magnet string `json:"magnet"`
+
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// ShareExportRequest is the body of POST /v1/shares/{hash}/export
+type ShareExportRequest struct {
+	Path string `json:"path"` // File to create, or a directory to create it in; valid on the peer's machine
+}
+
+// ShareExportResponse tells where a shared file was exported
+type ShareExportResponse struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// TorrentImportRequest is the body of POST /v1/torrents
+type TorrentImportRequest struct {
+	Torrent []byte `json:"torrent"` // Content of the .torrent file (base64 in JSON)
+	Path    string `json:"path"`    // Directory holding the torrent's content, as a BitTorrent client would save it
+}
+
+// TorrentImportResponse lists the files shared from an imported torrent
+type TorrentImportResponse struct {
+	Name       string      `json:"name"`
+	InfoHash   string      `json:"info_hash,omitempty"`
+	InfoHashV2 string      `json:"info_hash_v2,omitempty"`
+	Shares     []ShareInfo `json:"shares"`
+}
+
+// TorrentExportRequest is the body of POST /v1/shares/{hash}/torrent
+type TorrentExportRequest struct {
+	Trackers    []string `json:"trackers,omitempty"`  // BitTorrent announce URLs
+	WebSeeds    []string `json:"web_seeds,omitempty"` // HTTP(S) URLs serving the whole file
+	Comment     string   `json:"comment,omitempty"`
+	PieceLength int64    `json:"piece_length,omitempty"`
+}
+
+// TorrentExportResponse is a .torrent file created for a shared file
+type TorrentExportResponse struct {
+	InfoHash   string `json:"info_hash"`
+	InfoHashV2 string `json:"info_hash_v2"`
+	Magnet     string `json:"magnet"`
+	Torrent    []byte `json:"torrent"` // Base64 in JSON
+}
+
+// DownloadRequest is the body of POST /v1/downloads: a file hash or a magnet link
+type DownloadRequest struct {
+	Hash   string          `json:"hash,omitempty"`
+	Magnet string          `json:"magnet,omitempty"`
+	Meta   json.RawMessage `json:"meta,omitempty"` // Content of a .p2pmeta file
+
+	ShareToken string `json:"share_token,omitempty"` // Handed out by the owner of a private file
+}
+
+// DownloadInfo describes a download known to the queue or to local storage
+type DownloadInfo struct {
+	Hash       string  `json:"hash"`
+	Name       string  `json:"name,omitempty"`
+	Size       int64   `json:"size,omitempty"`
+	Status     string  `json:"status"`             // Queue state, or the stored status when not queued
+	Position   int     `json:"position,omitempty"` // Position in the queue when queued
+	Progress   float64 `json:"progress"`           // Percent
+	Speed      float64 `json:"speed,omitempty"`    // Bytes per second while active
+	OutputPath string  `json:"output_path,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// QueueResponse is returned by GET /v1/queue
+type QueueResponse struct {
+	MaxActive int                     `json:"max_active"`
+	Entries   []downloader.QueueEntry `json:"entries"`
+}
+
+// QueueUpdate is the body of PUT /v1/queue
+type QueueUpdate struct {
+	MaxActive int `json:"max_active"`
+}
+
+// MoveRequest is the body of POST /v1/downloads/{hash}/move
+type MoveRequest struct {
+	Position int `json:"position"`
+}
+
+// PeersResponse is returned by GET /v1/peers
+type PeersResponse struct {
+	Connections []p2p.ConnectionStats `json:"connections"` // Peers downloading from us
+	Downloads   []DownloadPeers       `json:"downloads"`   // Peers we download from
+}
+
+// DownloadPeers lists the peers serving one active download
+type DownloadPeers struct {
+	Hash  string     `json:"hash"`
+	Peers []PeerInfo `json:"peers"`
+}
+
+// PeerInfo is the performance of a peer serving a download
+type PeerInfo struct {
+	PeerID       string  `json:"peer_id"`
+	Chunks       int32   `json:"chunks"`
+	Bytes        int64   `json:"bytes"`
+	Failures     int32   `json:"failures"`
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+	Score        float64 `json:"score"`
+}
+
+// BandwidthResponse is returned by GET /v1/bandwidth
+type BandwidthResponse struct {
+	UploadLimit     int64    `json:"upload_limit"`   // Bytes per second in effect, 0 = unlimited
+	DownloadLimit   int64    `json:"download_limit"` // Bytes per second in effect, 0 = unlimited
+	DefaultUpload   int64    `json:"default_upload"`
+	DefaultDownload int64    `json:"default_download"`
+	UploadRate      int64    `json:"upload_rate"`
+	DownloadRate    int64    `json:"download_rate"`
+	TotalUploaded   int64    `json:"total_uploaded"`
+	TotalDownloaded int64    `json:"total_downloaded"`
+	Schedule        []string `json:"schedule,omitempty"`
+}
+
+// BandwidthUpdate is the body of PUT /v1/bandwidth. Rates use the -upload-limit
+// syntax ("512KB", "2MB", "unlimited"); empty fields are left unchanged.
+type BandwidthUpdate struct {
+	Upload   string `json:"upload,omitempty"`
+	Download string `json:"download,omitempty"`
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -14,6 +14,7 @@ import (
 	"github.com/p2p-filesharing/distributed-system/pkg/hash"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/metrics"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/p2p"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/relay"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
@@ -63,6 +64,9 @@ type Downloader struct {
 	maxWorkers       int
 	chunkTimeout     time.Duration
 	maxRetries       int
+
+	mu     sync.Mutex
+	active map[string]*DownloadStats // file hash -> stats of running downloads
 }
 
 // New creates a new Downloader
@@ -109,14 +113,21 @@ func (d *Downloader) SetRelayClient(client *relay.Client) {
 
 // SetBandwidthLimit sets download bandwidth limit (bytes per second, 0 = unlimited)
 func (d *Downloader) SetBandwidthLimit(bytesPerSecond int64) {
+	if d.bandwidthManager != nil {
+		d.bandwidthManager.SetDownloadLimit(bytesPerSecond)
+		return
+	}
 	if bytesPerSecond > 0 {
 		d.bandwidthManager = throttle.NewBandwidthManager(0, bytesPerSecond)
 		log.Printf("[Downloader] Bandwidth limit set to %d bytes/sec", bytesPerSecond)
-	} else {
-		d.bandwidthManager = nil
 	}
 }
 
+// SetBandwidthManager shares a peer-wide bandwidth manager (e.g. one driven by a schedule)
+func (d *Downloader) SetBandwidthManager(manager *throttle.BandwidthManager) {
+	d.bandwidthManager = manager
+}
+
 // GetBandwidthStats returns current bandwidth statistics
 func (d *Downloader) GetBandwidthStats() *throttle.BandwidthStats {
 	if d.bandwidthManager == nil {
@@ -126,20 +137,24 @@ func (d *Downloader) GetBandwidthStats() *throttle.BandwidthStats {
 	return &stats
 }
 
-// ThrottleChunk applies bandwidth limiting to chunk data
-func (d *Downloader) ThrottleChunk(ctx context.Context, size int64) {
-	if d.bandwidthManager != nil {
-		_, downloadLimit := d.bandwidthManager.GetLimits()
-		d.bandwidthManager.SetDownloadLimit(downloadLimit)
+// ThrottleChunk blocks until a received chunk of size bytes fits within the
+// download limit. It returns ctx's error if the download is cancelled first.
+func (d *Downloader) ThrottleChunk(ctx context.Context, size int64) error {
+	if d.bandwidthManager == nil {
+		return nil
 	}
+	return d.bandwidthManager.WaitDownload(ctx, size)
 }
 
 // DownloadFile downloads a file from available peers using parallel chunk downloads
 func (d *Downloader) DownloadFile(fileInfo *protocol.GetPeersResponse) error {
-	if len(fileInfo.Peers) == 0 {
-		return fmt.Errorf("no peers available for this file")
-	}
+	return d.DownloadFileContext(context.Background(), fileInfo)
+}
 
+// DownloadFileContext is DownloadFile that stops when ctx is cancelled. A
+// stopped download keeps its progress and returns ctx.Err(); the caller decides
+// whether it is paused or cancelled.
+func (d *Downloader) DownloadFileContext(ctx context.Context, fileInfo *protocol.GetPeersResponse) error {
 	// Initialize download state
 	metadata := &protocol.FileMetadata{
 		Name:      fileInfo.FileName,
@@ -148,12 +163,44 @@ func (d *Downloader) DownloadFile(fileInfo *protocol.GetPeersResponse) error {
 		ChunkSize: fileInfo.ChunkSize,
 		Chunks:    fileInfo.Chunks,
 	}
+	var webSeeds []string
+	if prepared, ok := d.storage.GetDownload(fileInfo.FileHash); ok && prepared.FromMetaFile {
+		// Metadata from a .p2pmeta file is used instead of the tracker's
+		metadata, webSeeds = prepared.Metadata, prepared.WebSeeds
+	}
+	if len(fileInfo.Peers) == 0 && len(webSeeds) == 0 {
+		return fmt.Errorf("no peers available for this file")
+	}
 
-	state := d.storage.StartDownload(metadata)
+	state, err := d.storage.StartDownload(metadata)
+	if err != nil {
+		return fmt.Errorf("cannot start download: %w", err)
+	}
+
+	// A private file is downloaded with the tracker's grant, and its chunks
+	// are only served to peers with one
+	if fileInfo.Visibility != "" {
+		d.storage.SetVisibility(metadata.Hash, fileInfo.Visibility)
+	}
+	if fileInfo.AccessToken != "" {
+		if d.p2pClient != nil {
+			d.p2pClient.SetAccessToken(metadata.Hash, fileInfo.AccessToken)
+		}
+		if d.relayClient != nil {
+			d.relayClient.SetAccessToken(metadata.Hash, fileInfo.AccessToken)
+		}
+	}
 	stats := d.initStats(len(metadata.Chunks), fileInfo.Peers)
+	d.trackStats(metadata.Hash, stats)
+	defer d.trackStats(metadata.Hash, nil)
 
-	log.Printf("[Downloader] Starting parallel download: %s (%d chunks from %d peers)",
-		metadata.Name, len(metadata.Chunks), len(fileInfo.Peers))
+	// Chunks already held locally (chunk store or other shared files) need no download
+	if reused := d.storage.ReuseLocalChunks(metadata.Hash); reused > 0 {
+		log.Printf("[Downloader] Reusing %d/%d chunks already stored locally", reused, len(metadata.Chunks))
+	}
+
+	log.Printf("[Downloader] Starting parallel download: %s (%d chunks from %d peers, %d web seeds)",
+		metadata.Name, len(metadata.Chunks), len(fileInfo.Peers), len(webSeeds))
 
 	// Create chunk task queue
 	taskQueue := make(chan *ChunkTask, len(metadata.Chunks))
@@ -173,11 +220,12 @@ func (d *Downloader) DownloadFile(fileInfo *protocol.GetPeersResponse) error {
 
 	if len(tasks) == 0 {
 		log.Printf("[Downloader] All chunks already downloaded")
-		return nil
+		stats.EndTime = time.Now()
+		return d.finishDownload(metadata, state, stats, nil)
 	}
 
 	// Determine optimal worker count
-	numWorkers := min(d.maxWorkers, len(fileInfo.Peers), len(tasks))
+	numWorkers := min(d.maxWorkers, len(fileInfo.Peers)+len(webSeeds), len(tasks))
 	log.Printf("[Downloader] Using %d parallel workers for %d tasks", numWorkers, len(tasks))
 
 	// Create worker pool with peer assignment
@@ -194,7 +242,7 @@ func (d *Downloader) DownloadFile(fileInfo *protocol.GetPeersResponse) error {
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		assignedPeers := d.assignPeers(i, numWorkers, fileInfo.Peers)
-		go d.simpleWorker(&wg, i, assignedPeers, metadata, state, stats, taskQueue, results)
+		go d.simpleWorker(ctx, &wg, i, assignedPeers, fileInfo.Peers, webSeeds, metadata, state, stats, taskQueue, results)
 	}
 
 	// Wait for workers and collect results
@@ -215,21 +263,43 @@ func (d *Downloader) DownloadFile(fileInfo *protocol.GetPeersResponse) error {
 	stats.EndTime = time.Now()
 	d.logDownloadStats(stats, metadata.Name)
 
+	if err := ctx.Err(); err != nil {
+		log.Printf("[Downloader] Stopped: %s", metadata.Name)
+		return err
+	}
+
+	return d.finishDownload(metadata, state, stats, lastErr)
+}
+
+// finishDownload verifies that every chunk is present, assembles the file and shares it.
+// A download in the chunk store is shared from the store, without assembling it.
+func (d *Downloader) finishDownload(metadata *protocol.FileMetadata, state *storage.DownloadState, stats *DownloadStats, lastErr error) error {
 	// Verify download complete
 	if !d.storage.IsDownloadComplete(metadata.Hash) {
+		err := fmt.Errorf("download incomplete")
 		if lastErr != nil {
-			return fmt.Errorf("download incomplete: %w", lastErr)
+			err = fmt.Errorf("download incomplete: %w", lastErr)
 		}
-		return fmt.Errorf("download incomplete")
+		d.storage.SetDownloadError(metadata.Hash, err)
+		metrics.RecordDownloadFinished(false)
+		return err
 	}
 
-	// Assemble file
-	if err := d.assembleFile(state); err != nil {
-		return fmt.Errorf("failed to assemble file: %w", err)
-	}
+	if state.ChunkStore {
+		d.storage.AddStoredFile(metadata)
+	} else {
+		// Assemble file
+		if err := d.assembleFile(state); err != nil {
+			d.storage.SetDownloadError(metadata.Hash, err)
+			metrics.RecordDownloadFinished(false)
+			return fmt.Errorf("failed to assemble file: %w", err)
+		}
 
-	// Move to shared files
-	d.storage.AddSharedFile(metadata, state.OutputPath)
+		// Move to shared files
+		d.storage.AddSharedFile(metadata, state.OutputPath)
+	}
+	d.storage.CompleteDownload(metadata.Hash)
+	metrics.RecordDownloadFinished(true)
 
 	log.Printf("[Downloader] Download complete: %s (%.2f MB/s)",
 		metadata.Name, d.calculateSpeed(stats))
@@ -310,8 +380,7 @@ func (d *Downloader) downloadWorker(wg *sync.WaitGroup, workerID int, peer proto
 		}
 
 		// Save chunk
-		chunkPath := filepath.Join(state.TempDir, fmt.Sprintf("chunk_%d", chunkIndex))
-		if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+		if err := d.storage.SaveChunk(metadata.Hash, chunkIndex, data); err != nil {
 			errors <- err
 			continue
 		}
@@ -330,8 +399,7 @@ func (d *Downloader) assembleFile(state *storage.DownloadState) error {
 	defer outFile.Close()
 
 	for i := range state.ChunksReceived {
-		chunkPath := filepath.Join(state.TempDir, fmt.Sprintf("chunk_%d", i))
-		data, err := os.ReadFile(chunkPath)
+		data, err := d.storage.ReadDownloadChunk(state.Metadata.Hash, i)
 		if err != nil {
 			return err
 		}
@@ -341,9 +409,7 @@ func (d *Downloader) assembleFile(state *storage.DownloadState) error {
 		}
 	}
 
-	// Cleanup temp files
-	os.RemoveAll(state.TempDir)
-
+	// CompleteDownload deletes the temp chunks
 	return nil
 }
 
@@ -448,8 +514,7 @@ func (d *Downloader) parallelWorker(
 		}
 
 		// Save chunk
-		chunkPath := filepath.Join(state.TempDir, fmt.Sprintf("chunk_%d", task.Index))
-		if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+		if err := d.storage.SaveChunk(metadata.Hash, task.Index, data); err != nil {
 			results <- &chunkResult{index: task.Index, err: err}
 			continue
 		}
@@ -494,11 +559,16 @@ func (d *Downloader) sortPeersByScore(peers []protocol.PeerFileInfo, stats *Down
 }
 
 // simpleWorker is a simplified worker that processes tasks from the queue
-// It handles retries internally and doesn't rely on a separate retry queue
+// It handles retries internally and doesn't rely on a separate retry queue.
+// Chunks that none of its peers has yet are requested from the file's other
+// peers (allPeers) that have them.
 func (d *Downloader) simpleWorker(
+	ctx context.Context,
 	wg *sync.WaitGroup,
 	workerID int,
 	peers []protocol.PeerFileInfo,
+	allPeers []protocol.PeerFileInfo,
+	webSeeds []string,
 	metadata *protocol.FileMetadata,
 	state *storage.DownloadState,
 	stats *DownloadStats,
@@ -508,17 +578,18 @@ func (d *Downloader) simpleWorker(
 	defer wg.Done()
 
 	log.Printf("[Worker %d] Starting with %d peers", workerID, len(peers))
-	if len(peers) == 0 {
+	if len(peers) == 0 && len(webSeeds) == 0 {
 		log.Printf("[Worker %d] No peers assigned, exiting", workerID)
 		return
 	}
 
 	// Sort peers by score (best first)
 	sortedPeers := d.sortPeersByScore(peers, stats)
+	sortedAllPeers := d.sortPeersByScore(allPeers, stats)
 
 	// Track active peer connection
 	var currentConn *p2p.PeerConnection
-	var currentPeerIdx int
+	var currentPeerID string
 	useRelayOnly := false // Switch to relay-only mode after direct TCP fails
 	defer func() {
 		if currentConn != nil {
@@ -527,7 +598,7 @@ func (d *Downloader) simpleWorker(
 	}()
 
 	// Try direct TCP connection once at start
-	if d.relayClient != nil && d.relayClient.IsConnected() {
+	if len(sortedPeers) > 0 && d.relayClient != nil && d.relayClient.IsConnected() {
 		// Test direct TCP to first peer
 		testPeer := sortedPeers[0]
 		testConn, err := d.p2pClient.Connect(testPeer.IP, testPeer.Port)
@@ -537,12 +608,16 @@ func (d *Downloader) simpleWorker(
 		} else {
 			log.Printf("[Worker %d] Direct TCP connected to %s:%d", workerID, testPeer.IP, testPeer.Port)
 			currentConn = testConn
-			currentPeerIdx = 0
+			currentPeerID = testPeer.PeerID
 		}
 	}
 
 	// Process tasks
 	for task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+
 		// Skip if already downloaded
 		if state.ChunksReceived[task.Index] {
 			results <- &chunkResult{index: task.Index}
@@ -551,27 +626,39 @@ func (d *Downloader) simpleWorker(
 
 		var data []byte
 		var err error
-		var downloadedFromPeer string
+		var downloadedFromPeer, transport string
 		startTime := time.Now()
 
+		// Leechers only serve the chunks they have
+		candidates := sortedPeers
+		if !slices.ContainsFunc(candidates, func(p protocol.PeerFileInfo) bool { return hasChunk(p, task.Index) }) {
+			candidates = sortedAllPeers
+		}
+		tried := 0
+
 		// Strategy 1: Try direct TCP connection (skip if relay-only mode)
 		if !useRelayOnly {
-			for attempt := 0; attempt < len(sortedPeers); attempt++ {
-				peerIdx := (currentPeerIdx + attempt) % len(sortedPeers)
-				peer := sortedPeers[peerIdx]
+			start := max(slices.IndexFunc(candidates, func(p protocol.PeerFileInfo) bool { return p.PeerID == currentPeerID }), 0)
+			for attempt := 0; attempt < len(candidates); attempt++ {
+				peer := candidates[(start+attempt)%len(candidates)]
+				if !hasChunk(peer, task.Index) {
+					continue
+				}
+				tried++
 
 				// Connect if needed
-				if currentConn == nil || peerIdx != currentPeerIdx {
+				if currentConn == nil || peer.PeerID != currentPeerID {
 					if currentConn != nil {
 						currentConn.Close()
 					}
 					currentConn, err = d.p2pClient.Connect(peer.IP, peer.Port)
 					if err != nil {
 						log.Printf("[Worker %d] Direct TCP to %s:%d failed: %v", workerID, peer.IP, peer.Port, err)
+						metrics.RecordChunkFailure(metrics.TransportDirect, metrics.FailureConnect)
 						d.updatePeerScore(stats, peer.PeerID, false, 0)
 						continue
 					}
-					currentPeerIdx = peerIdx
+					currentPeerID = peer.PeerID
 				}
 
 				// Request chunk
@@ -580,9 +667,13 @@ func (d *Downloader) simpleWorker(
 					// Verify hash
 					if hash.Verify(data, task.Hash) {
 						downloadedFromPeer = peer.PeerID
+						transport = metrics.TransportDirect
 						break
 					}
 					err = fmt.Errorf("hash mismatch")
+					metrics.RecordChunkFailure(metrics.TransportDirect, metrics.FailureHashMismatch)
+				} else {
+					metrics.RecordChunkFailure(metrics.TransportDirect, metrics.FailureRequest)
 				}
 
 				// Update peer score on failure
@@ -592,7 +683,7 @@ func (d *Downloader) simpleWorker(
 			}
 
 			// If all direct TCP failed, switch to relay-only mode for remaining chunks
-			if downloadedFromPeer == "" && d.relayClient != nil && d.relayClient.IsConnected() {
+			if downloadedFromPeer == "" && tried > 0 && d.relayClient != nil && d.relayClient.IsConnected() {
 				log.Printf("[Worker %d] All direct TCP failed, switching to relay-only mode", workerID)
 				useRelayOnly = true
 			}
@@ -600,35 +691,67 @@ func (d *Downloader) simpleWorker(
 
 		// Strategy 2: Use relay connection (always used in relay-only mode)
 		if downloadedFromPeer == "" && d.relayClient != nil && d.relayClient.IsConnected() {
-			for _, peer := range sortedPeers {
+			for _, peer := range candidates {
+				if !hasChunk(peer, task.Index) {
+					continue
+				}
 				data, err = d.relayClient.RequestChunk(peer.PeerID, metadata.Hash, task.Index)
 				if err == nil {
 					// Verify hash
 					if hash.Verify(data, task.Hash) {
 						downloadedFromPeer = peer.PeerID
+						transport = metrics.TransportRelay
 						if useRelayOnly && task.Index%50 == 0 {
 							log.Printf("[Worker %d] Chunk %d via relay from %s", workerID, task.Index, peer.PeerID[:8])
 						}
 						break
 					}
 					err = fmt.Errorf("hash mismatch via relay")
+					metrics.RecordChunkFailure(metrics.TransportRelay, metrics.FailureHashMismatch)
 				} else {
 					log.Printf("[Worker %d] Relay to %s failed: %v", workerID, peer.PeerID[:min(8, len(peer.PeerID))], err)
+					metrics.RecordChunkFailure(metrics.TransportRelay, metrics.FailureRequest)
+				}
+			}
+		}
+
+		// Strategy 3: Read the chunk from a web seed
+		if downloadedFromPeer == "" {
+			for _, seed := range webSeeds {
+				data, err = d.fetchWebSeed(ctx, seed, metadata.ChunkOffset(task.Index), task.Size)
+				if err == nil {
+					if hash.Verify(data, task.Hash) {
+						downloadedFromPeer = seed
+						transport = metrics.TransportWebSeed
+						break
+					}
+					err = fmt.Errorf("hash mismatch from web seed")
+					metrics.RecordChunkFailure(metrics.TransportWebSeed, metrics.FailureHashMismatch)
+				} else {
+					log.Printf("[Worker %d] Web seed %s failed: %v", workerID, seed, err)
+					metrics.RecordChunkFailure(metrics.TransportWebSeed, metrics.FailureRequest)
 				}
 			}
 		}
 
 		latency := time.Since(startTime)
 
+		if err == nil && data == nil {
+			err = fmt.Errorf("no peer has chunk %d yet", task.Index)
+		}
 		if err != nil || data == nil {
 			log.Printf("[Worker %d] Failed to download chunk %d after retries: %v", workerID, task.Index, err)
 			results <- &chunkResult{index: task.Index, err: err}
 			continue
 		}
 
+		if err := d.ThrottleChunk(ctx, int64(len(data))); err != nil {
+			results <- &chunkResult{index: task.Index, err: err}
+			continue
+		}
+
 		// Save chunk
-		chunkPath := filepath.Join(state.TempDir, fmt.Sprintf("chunk_%d", task.Index))
-		if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+		if err := d.storage.SaveChunk(metadata.Hash, task.Index, data); err != nil {
 			results <- &chunkResult{index: task.Index, err: err}
 			continue
 		}
@@ -636,6 +759,7 @@ func (d *Downloader) simpleWorker(
 		// Update stats and state
 		d.storage.MarkChunkReceived(metadata.Hash, task.Index)
 		d.updatePeerScore(stats, downloadedFromPeer, true, latency)
+		metrics.RecordChunkDownloaded(transport, len(data))
 
 		stats.mu.Lock()
 		stats.DownloadedChunks++
@@ -652,6 +776,12 @@ func (d *Downloader) simpleWorker(
 	log.Printf("[Worker %d] Finished", workerID)
 }
 
+// hasChunk reports whether a peer from the tracker has a chunk. Seeders, and
+// peers listed by trackers that do not send availability, have them all.
+func hasChunk(peer protocol.PeerFileInfo, index int) bool {
+	return peer.IsSeeder || peer.Availability == nil || peer.Availability.Has(index)
+}
+
 // updatePeerScore updates peer's score based on performance
 func (d *Downloader) updatePeerScore(stats *DownloadStats, peerID string, success bool, latency time.Duration) {
 	stats.mu.Lock()
@@ -773,6 +903,48 @@ func (d *Downloader) logDownloadStats(stats *DownloadStats, fileName string) {
 
 // GetDownloadStats returns current download statistics (for monitoring)
 func (d *Downloader) GetDownloadStats(fileHash string) (*DownloadStats, bool) {
-	// This could be extended to track active downloads
-	return nil, false
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats, ok := d.active[fileHash]
+	return stats, ok
+}
+
+// trackStats records the stats of a running download, or forgets them when stats is nil
+func (d *Downloader) trackStats(fileHash string, stats *DownloadStats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if stats == nil {
+		delete(d.active, fileHash)
+		return
+	}
+	if d.active == nil {
+		d.active = make(map[string]*DownloadStats)
+	}
+	d.active[fileHash] = stats
+}
+
+// Speed returns the average download speed so far in bytes per second
+func (s *DownloadStats) Speed() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elapsed := time.Since(s.StartTime).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BytesDownloaded) / elapsed
+}
+
+// Peers returns a copy of the per-peer statistics
+func (s *DownloadStats) Peers() []PeerDownloadStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peers := make([]PeerDownloadStats, 0, len(s.PeerStats))
+	for _, p := range s.PeerStats {
+		peers = append(peers, *p)
+	}
+	return peers
 }
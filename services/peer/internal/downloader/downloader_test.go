@@ -1,9 +1,12 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
 )
 
 func TestDownloadStats(t *testing.T) {
@@ -62,3 +65,36 @@ func TestAssignPeers(t *testing.T) {
 		t.Errorf("Expected 2 peers for worker 1, got %d", len(assigned1))
 	}
 }
+
+func TestHasChunk(t *testing.T) {
+	partial := protocol.NewChunkSet(0, 1)
+	cases := []struct {
+		peer protocol.PeerFileInfo
+		want bool
+	}{
+		{protocol.PeerFileInfo{IsSeeder: true}, true},
+		{protocol.PeerFileInfo{}, true}, // Tracker without availability
+		{protocol.PeerFileInfo{Availability: &partial}, false},
+	}
+	for i, c := range cases {
+		if got := hasChunk(c.peer, 5); got != c.want {
+			t.Errorf("case %d: hasChunk = %v, want %v", i, got, c.want)
+		}
+	}
+	if !hasChunk(protocol.PeerFileInfo{Availability: &partial}, 1) {
+		t.Error("a leecher should have the chunks it reported")
+	}
+}
+
+func TestThrottleChunk_Cancelled(t *testing.T) {
+	d := &Downloader{bandwidthManager: throttle.NewBandwidthManager(0, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.ThrottleChunk(ctx, 1000); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled download to stop waiting, got %v", err)
+	}
+	if err := (&Downloader{}).ThrottleChunk(ctx, 1000); err != nil {
+		t.Errorf("Expected no wait without a bandwidth manager, got %v", err)
+	}
+}
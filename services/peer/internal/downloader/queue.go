@@ -0,0 +1,521 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/events"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// PeerSource looks up the metadata and peers of a file, and tells the
+// trackers which chunks of its downloads this peer can serve and when they
+// start, complete, pause or stop (implemented by client.TrackerClient)
+type PeerSource interface {
+	GetPeers(fileHash string) (*protocol.GetPeersResponse, error)
+	ReportAvailability(fileHash string, have protocol.ChunkSet) error
+	Announce(fileHash, event string, have protocol.ChunkSet) error
+}
+
+// availabilityInterval is how often the chunks of active downloads are
+// reported to the trackers
+const availabilityInterval = 15 * time.Second
+
+// Queue states of a download
+const (
+	QueueQueued = "queued"
+	QueueActive = "active"
+	QueuePaused = "paused"
+)
+
+var (
+	ErrAlreadyQueued = errors.New("download is already queued")
+	ErrNotQueued     = errors.New("download is not in the queue")
+)
+
+// QueueEntry describes a download managed by the Manager
+type QueueEntry struct {
+	Hash     string    `json:"hash"`
+	Name     string    `json:"name,omitempty"`
+	State    string    `json:"state"`              // queued, active or paused
+	Position int       `json:"position,omitempty"` // 1-based position among queued downloads
+	AddedAt  time.Time `json:"added_at"`
+	Error    string    `json:"error,omitempty"` // Last error of a paused download
+}
+
+// DownloadEvent is the payload of download events
+type DownloadEvent struct {
+	Hash     string  `json:"hash"`
+	Name     string  `json:"name,omitempty"`
+	Progress float64 `json:"progress,omitempty"` // Percent
+	Speed    float64 `json:"speed,omitempty"`    // Bytes per second
+	Error    string  `json:"error,omitempty"`
+}
+
+type queueItem struct {
+	QueueEntry
+	run       int // Incremented each time the download starts
+	cancel    context.CancelFunc
+	cancelled bool // Remove the download's data once it stops
+}
+
+// Manager runs queued downloads in the background, at most maxActive at a
+// time, and lets them be paused, resumed, cancelled and reordered
+type Manager struct {
+	mu         sync.Mutex
+	downloader *Downloader
+	store      *storage.LocalStorage
+	peers      PeerSource
+	events     *events.Bus
+	maxActive  int
+	items      map[string]*queueItem // file hash -> item
+	order      []string              // hashes of queued items, next first
+	stop       chan struct{}
+}
+
+// NewManager creates a download manager. bus may be nil.
+func NewManager(d *Downloader, store *storage.LocalStorage, peers PeerSource, bus *events.Bus, maxActive int) *Manager {
+	if maxActive < 1 {
+		maxActive = 1
+	}
+	return &Manager{
+		downloader: d,
+		store:      store,
+		peers:      peers,
+		events:     bus,
+		maxActive:  maxActive,
+		items:      make(map[string]*queueItem),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start publishes progress of active downloads every second, and reports
+// their chunks to the trackers every availabilityInterval, until Stop
+func (m *Manager) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.publishProgress()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(availabilityInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.reportAvailability()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops all running downloads (keeping their progress) and the progress reports
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.stop:
+		return
+	default:
+		close(m.stop)
+	}
+	for _, item := range m.items {
+		if item.cancel != nil {
+			item.cancel()
+		}
+	}
+}
+
+// Enqueue adds a download to the end of the queue. name is only used for
+// display until the tracker returns the file metadata.
+func (m *Manager) Enqueue(fileHash, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNewUnsafe(fileHash); err != nil {
+		return err
+	}
+	m.enqueueUnsafe(fileHash, name)
+	return nil
+}
+
+// EnqueueMetadata queues a download whose metadata comes from a .p2pmeta
+// file. The trackers are still asked for peers, but the download can
+// proceed from the web seeds alone.
+func (m *Manager) EnqueueMetadata(metadata *protocol.FileMetadata, webSeeds []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNewUnsafe(metadata.Hash); err != nil {
+		return err
+	}
+	// Lack of space is reported when the download runs, like other downloads
+	var spaceErr *storage.SpaceError
+	if _, err := m.store.PrepareDownload(metadata, webSeeds); err != nil && !errors.As(err, &spaceErr) {
+		return err
+	}
+	m.enqueueUnsafe(metadata.Hash, metadata.Name)
+	return nil
+}
+
+// checkNewUnsafe returns why a file cannot be queued (caller must hold lock)
+func (m *Manager) checkNewUnsafe(fileHash string) error {
+	if _, exists := m.items[fileHash]; exists {
+		return ErrAlreadyQueued
+	}
+	if _, shared := m.store.GetSharedFile(fileHash); shared {
+		return fmt.Errorf("file is already available locally")
+	}
+	return nil
+}
+
+// enqueueUnsafe adds a download to the end of the queue (caller must hold lock)
+func (m *Manager) enqueueUnsafe(fileHash, name string) {
+	if state, ok := m.store.GetDownload(fileHash); ok && name == "" {
+		name = state.Metadata.Name
+	}
+
+	m.items[fileHash] = &queueItem{QueueEntry: QueueEntry{
+		Hash:    fileHash,
+		Name:    name,
+		State:   QueueQueued,
+		AddedAt: time.Now(),
+	}}
+	m.order = append(m.order, fileHash)
+	m.events.Publish(events.DownloadQueued, DownloadEvent{Hash: fileHash, Name: name})
+
+	m.scheduleUnsafe()
+}
+
+// ResumeInterrupted queues downloads that were still active when the peer
+// last stopped. It returns the number of downloads queued.
+func (m *Manager) ResumeInterrupted() int {
+	queued := 0
+	for _, state := range m.store.ListDownloads() {
+		if state.Status == storage.StatusActive && m.Enqueue(state.Metadata.Hash, state.Metadata.Name) == nil {
+			queued++
+		}
+	}
+	return queued
+}
+
+// Pause stops a queued or active download, keeping its progress
+func (m *Manager) Pause(fileHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, exists := m.items[fileHash]
+	if !exists {
+		// Not managed: only a download recorded in storage can be paused
+		return m.store.PauseDownload(fileHash)
+	}
+
+	switch item.State {
+	case QueuePaused:
+		return storage.ErrDownloadNotActive
+	case QueueQueued:
+		m.removeFromOrderUnsafe(fileHash)
+	case QueueActive:
+		item.cancel()
+		item.cancel = nil
+		have, _ := m.store.ReceivedChunks(fileHash)
+		go m.announce(fileHash, protocol.AnnouncePaused, have)
+	}
+	item.State = QueuePaused
+	if err := m.store.PauseDownload(fileHash); err != nil && err != storage.ErrDownloadNotFound && err != storage.ErrDownloadNotActive {
+		return err
+	}
+	m.events.Publish(events.DownloadPaused, DownloadEvent{Hash: fileHash, Name: item.Name})
+
+	m.scheduleUnsafe()
+	return nil
+}
+
+// Resume queues a paused download again, including downloads paused or
+// failed in an earlier run of the peer
+func (m *Manager) Resume(fileHash string) error {
+	m.mu.Lock()
+	item, exists := m.items[fileHash]
+	if !exists {
+		m.mu.Unlock()
+		state, ok := m.store.GetDownload(fileHash)
+		if !ok {
+			return storage.ErrDownloadNotFound
+		}
+		if state.Status != storage.StatusPaused && state.Status != storage.StatusFailed && state.Status != storage.StatusNoSpace {
+			return storage.ErrDownloadNotPaused
+		}
+		return m.Enqueue(fileHash, state.Metadata.Name)
+	}
+	defer m.mu.Unlock()
+
+	if item.State != QueuePaused {
+		return storage.ErrDownloadNotPaused
+	}
+	item.State = QueueQueued
+	item.Error = ""
+	m.order = append(m.order, fileHash)
+	m.events.Publish(events.DownloadQueued, DownloadEvent{Hash: fileHash, Name: item.Name})
+
+	m.scheduleUnsafe()
+	return nil
+}
+
+// Cancel stops a download and deletes its partial data
+func (m *Manager) Cancel(fileHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, exists := m.items[fileHash]
+	if !exists {
+		if err := m.store.CancelDownload(fileHash); err != nil {
+			return err
+		}
+		m.events.Publish(events.DownloadCancelled, DownloadEvent{Hash: fileHash})
+		go m.announce(fileHash, protocol.AnnounceStopped, protocol.ChunkSet{})
+		return nil
+	}
+
+	delete(m.items, fileHash)
+	m.removeFromOrderUnsafe(fileHash)
+	if item.State == QueueActive {
+		// The data is removed once the download has stopped
+		item.cancelled = true
+		item.cancel()
+	} else if err := m.store.CancelDownload(fileHash); err != nil && err != storage.ErrDownloadNotFound {
+		return err
+	}
+	m.events.Publish(events.DownloadCancelled, DownloadEvent{Hash: fileHash, Name: item.Name})
+	go m.announce(fileHash, protocol.AnnounceStopped, protocol.ChunkSet{})
+
+	m.scheduleUnsafe()
+	return nil
+}
+
+// Move places a queued download at a 1-based position in the queue
+func (m *Manager) Move(fileHash string, position int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, exists := m.items[fileHash]
+	if !exists || item.State != QueueQueued {
+		return ErrNotQueued
+	}
+
+	m.removeFromOrderUnsafe(fileHash)
+	position = min(max(position, 1), len(m.order)+1)
+	m.order = slices.Insert(m.order, position-1, fileHash)
+	return nil
+}
+
+// SetMaxActive changes how many downloads run at the same time
+func (m *Manager) SetMaxActive(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxActive = max(n, 1)
+	m.scheduleUnsafe()
+}
+
+// MaxActive returns how many downloads run at the same time
+func (m *Manager) MaxActive() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxActive
+}
+
+// Entries returns the managed downloads: active first, then queued in order,
+// then paused
+func (m *Manager) Entries() []QueueEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var active, paused []QueueEntry
+	for _, item := range m.items {
+		switch item.State {
+		case QueueActive:
+			active = append(active, item.QueueEntry)
+		case QueuePaused:
+			paused = append(paused, item.QueueEntry)
+		}
+	}
+	byAdded := func(a, b QueueEntry) int { return a.AddedAt.Compare(b.AddedAt) }
+	slices.SortFunc(active, byAdded)
+	slices.SortFunc(paused, byAdded)
+
+	entries := active
+	for i, hash := range m.order {
+		entry := m.items[hash].QueueEntry
+		entry.Position = i + 1
+		entries = append(entries, entry)
+	}
+	return append(entries, paused...)
+}
+
+// Entry returns a managed download
+func (m *Manager) Entry(fileHash string) (QueueEntry, bool) {
+	for _, entry := range m.Entries() {
+		if entry.Hash == fileHash {
+			return entry, true
+		}
+	}
+	return QueueEntry{}, false
+}
+
+// scheduleUnsafe starts queued downloads while fewer than maxActive run (caller must hold lock)
+func (m *Manager) scheduleUnsafe() {
+	select {
+	case <-m.stop:
+		return
+	default:
+	}
+
+	active := 0
+	for _, item := range m.items {
+		if item.State == QueueActive {
+			active++
+		}
+	}
+
+	for active < m.maxActive && len(m.order) > 0 {
+		item := m.items[m.order[0]]
+		m.order = m.order[1:]
+
+		ctx, cancel := context.WithCancel(context.Background())
+		item.State = QueueActive
+		item.cancel = cancel
+		item.run++
+		active++
+		go m.run(ctx, item, item.run)
+	}
+}
+
+// run downloads one item until it completes, fails or is stopped
+func (m *Manager) run(ctx context.Context, item *queueItem, run int) {
+	hash := item.Hash
+	m.events.Publish(events.DownloadStarted, DownloadEvent{Hash: hash, Name: item.Name})
+
+	fileInfo, err := m.peers.GetPeers(hash)
+	if state, ok := m.store.GetDownload(hash); err != nil && ok && len(state.WebSeeds) > 0 {
+		log.Printf("[Queue] No peers from the trackers for %s (%v), using web seeds", item.Name, err)
+		fileInfo, err = FileInfoOf(state.Metadata), nil
+	}
+	if err == nil {
+		m.mu.Lock()
+		item.Name = fileInfo.FileName
+		m.mu.Unlock()
+		have, _ := m.store.ReceivedChunks(hash)
+		go m.announce(hash, protocol.AnnounceStarted, have)
+		err = m.downloader.DownloadFileContext(ctx, fileInfo)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item.cancelled {
+		if err := m.store.CancelDownload(hash); err != nil && err != storage.ErrDownloadNotFound {
+			log.Printf("[Queue] Failed to remove cancelled download %s: %v", item.Name, err)
+		}
+		return
+	}
+	if m.items[hash] != item || item.run != run || ctx.Err() != nil {
+		// Paused, or resumed again since this run was stopped
+		return
+	}
+
+	if err != nil {
+		log.Printf("[Queue] Download failed: %s: %v", item.Name, err)
+		item.State = QueuePaused
+		item.Error = err.Error()
+		item.cancel = nil
+		m.events.Publish(events.DownloadFailed, DownloadEvent{Hash: hash, Name: item.Name, Error: err.Error()})
+	} else {
+		delete(m.items, hash)
+		m.events.Publish(events.DownloadCompleted, DownloadEvent{Hash: hash, Name: item.Name, Progress: 100})
+		if shared, ok := m.store.GetSharedFile(hash); ok {
+			// Every chunk: the trackers list this peer as a seeder
+			go m.announce(hash, protocol.AnnounceCompleted, protocol.FullChunkSet(len(shared.Metadata.Chunks)))
+		}
+	}
+	m.scheduleUnsafe()
+}
+
+// publishProgress reports the progress of every active download
+func (m *Manager) publishProgress() {
+	m.mu.Lock()
+	var active []QueueEntry
+	for _, item := range m.items {
+		if item.State == QueueActive {
+			active = append(active, item.QueueEntry)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, entry := range active {
+		progress, err := m.store.GetDownloadProgress(entry.Hash)
+		if err != nil {
+			continue
+		}
+		event := DownloadEvent{Hash: entry.Hash, Name: entry.Name, Progress: progress}
+		if stats, ok := m.downloader.GetDownloadStats(entry.Hash); ok {
+			event.Speed = stats.Speed()
+		}
+		m.events.Publish(events.DownloadProgress, event)
+	}
+}
+
+// reportAvailability sends the chunks received so far of every active download
+func (m *Manager) reportAvailability() {
+	m.mu.Lock()
+	var active []string
+	for hash, item := range m.items {
+		if item.State == QueueActive {
+			active = append(active, hash)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, hash := range active {
+		if have, ok := m.store.ReceivedChunks(hash); ok && !have.IsEmpty() {
+			m.report(hash, have)
+		}
+	}
+}
+
+// report sends the chunks this peer has of a download to the trackers
+func (m *Manager) report(fileHash string, have protocol.ChunkSet) {
+	if err := m.peers.ReportAvailability(fileHash, have); err != nil {
+		log.Printf("[Queue] Failed to report the chunks of %s: %v", fileHash[:min(12, len(fileHash))], err)
+	}
+}
+
+// announce tells the trackers a download started, completed, was paused or
+// stopped, with the chunks this peer has of it
+func (m *Manager) announce(fileHash, event string, have protocol.ChunkSet) {
+	if err := m.peers.Announce(fileHash, event, have); err != nil {
+		log.Printf("[Queue] Failed to announce %s of %s: %v", event, fileHash[:min(12, len(fileHash))], err)
+	}
+}
+
+// removeFromOrderUnsafe removes a hash from the queue order (caller must hold lock)
+func (m *Manager) removeFromOrderUnsafe(fileHash string) {
+	if i := slices.Index(m.order, fileHash); i >= 0 {
+		m.order = slices.Delete(m.order, i, i+1)
+	}
+}
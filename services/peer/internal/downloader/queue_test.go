@@ -0,0 +1,198 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// blockingPeers holds every lookup until released, keeping downloads active
+type blockingPeers struct {
+	release chan struct{}
+}
+
+func (b *blockingPeers) GetPeers(fileHash string) (*protocol.GetPeersResponse, error) {
+	<-b.release
+	return nil, errors.New("no peers")
+}
+
+func (b *blockingPeers) ReportAvailability(fileHash string, have protocol.ChunkSet) error {
+	return nil
+}
+
+func (b *blockingPeers) Announce(fileHash, event string, have protocol.ChunkSet) error {
+	return nil
+}
+
+func newTestManager(t *testing.T, maxActive int) (*Manager, *blockingPeers) {
+	t.Helper()
+	store, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	peers := &blockingPeers{release: make(chan struct{})}
+	m := NewManager(New(store, nil), store, peers, nil, maxActive)
+	t.Cleanup(func() {
+		m.Stop()
+		close(peers.release)
+	})
+	return m, peers
+}
+
+func queueStates(m *Manager) []string {
+	var states []string
+	for _, entry := range m.Entries() {
+		states = append(states, entry.Hash+":"+entry.State)
+	}
+	return states
+}
+
+func TestManager_QueueOrder(t *testing.T) {
+	m, _ := newTestManager(t, 1)
+
+	for _, hash := range []string{"a", "b", "c"} {
+		if err := m.Enqueue(hash, ""); err != nil {
+			t.Fatalf("Enqueue(%s) failed: %v", hash, err)
+		}
+	}
+	if err := m.Enqueue("a", ""); err != ErrAlreadyQueued {
+		t.Errorf("Enqueue twice = %v, want ErrAlreadyQueued", err)
+	}
+
+	want := []string{"a:active", "b:queued", "c:queued"}
+	if got := queueStates(m); !slices.Equal(got, want) {
+		t.Fatalf("Queue = %v, want %v", got, want)
+	}
+
+	// c jumps ahead of b
+	if err := m.Move("c", 1); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if entry, _ := m.Entry("c"); entry.Position != 1 {
+		t.Errorf("c position = %d, want 1", entry.Position)
+	}
+	if err := m.Move("a", 1); err != ErrNotQueued {
+		t.Errorf("Moving an active download = %v, want ErrNotQueued", err)
+	}
+
+	// Pausing the active download starts the next queued one
+	if err := m.Pause("a"); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	want = []string{"c:active", "b:queued", "a:paused"}
+	if got := queueStates(m); !slices.Equal(got, want) {
+		t.Fatalf("Queue after pause = %v, want %v", got, want)
+	}
+
+	// Resumed downloads go to the back of the queue
+	if err := m.Resume("a"); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if err := m.Cancel("b"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	want = []string{"c:active", "a:queued"}
+	if got := queueStates(m); !slices.Equal(got, want) {
+		t.Fatalf("Queue after resume and cancel = %v, want %v", got, want)
+	}
+
+	// More slots start queued downloads immediately
+	m.SetMaxActive(2)
+	if entry, _ := m.Entry("a"); entry.State != QueueActive {
+		t.Errorf("a state = %s after raising max active, want active", entry.State)
+	}
+}
+
+func TestManager_FailedDownloadIsPaused(t *testing.T) {
+	m, peers := newTestManager(t, 1)
+	m.Enqueue("a", "a.bin")
+	m.Enqueue("b", "b.bin")
+
+	// The lookup for a fails; b starts
+	peers.release <- struct{}{}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entry, _ := m.Entry("a")
+		if entry.State == QueuePaused {
+			if entry.Error == "" {
+				t.Error("Failed download should record its error")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("a state = %s, want paused after failure", entry.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if entry, _ := m.Entry("b"); entry.State != QueueActive {
+		t.Errorf("b state = %s, want active", entry.State)
+	}
+}
+
+// unknownFile is a PeerSource whose trackers do not know any file
+type unknownFile struct{}
+
+func (unknownFile) GetPeers(fileHash string) (*protocol.GetPeersResponse, error) {
+	return nil, errors.New("file not found")
+}
+
+func (unknownFile) ReportAvailability(fileHash string, have protocol.ChunkSet) error {
+	return errors.New("file not found")
+}
+
+func (unknownFile) Announce(fileHash, event string, have protocol.ChunkSet) error {
+	return errors.New("file not found")
+}
+
+func TestManager_WebSeedOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seed.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("web seed "), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := chunker.New(1024).ChunkFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, path)
+	}))
+	defer seed.Close()
+
+	store, err := storage.NewLocalStorage(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	m := NewManager(New(store, nil), store, unknownFile{}, nil, 1)
+	defer m.Stop()
+	if err := m.EnqueueMetadata(metadata, []string{seed.URL + "/seed.bin"}); err != nil {
+		t.Fatalf("EnqueueMetadata failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, queued := m.Entry(metadata.Hash); !queued {
+			break
+		}
+		if time.Now().After(deadline) {
+			entry, _ := m.Entry(metadata.Hash)
+			t.Fatalf("Download did not finish: %+v", entry)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	state, _ := store.GetDownload(metadata.Hash)
+	if sum, err := hash.CalculateFile(state.OutputPath); err != nil || sum != metadata.Hash {
+		t.Errorf("Downloaded file hash = %s, %v, want %s", sum, err, metadata.Hash)
+	}
+}
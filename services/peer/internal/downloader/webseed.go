@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// FileInfoOf describes a download from its known metadata with no peers, for
+// downloads that can only use the web seeds of a .p2pmeta file
+func FileInfoOf(metadata *protocol.FileMetadata) *protocol.GetPeersResponse {
+	return &protocol.GetPeersResponse{
+		FileHash:   metadata.Hash,
+		FileName:   metadata.Name,
+		FileSize:   metadata.Size,
+		ChunkCount: len(metadata.Chunks),
+		ChunkSize:  metadata.ChunkSize,
+		Chunks:     metadata.Chunks,
+	}
+}
+
+// fetchWebSeed reads one chunk from a web seed: a plain HTTP(S) URL of the
+// whole file, read with a Range request
+func (d *Downloader) fetchWebSeed(ctx context.Context, url string, offset, size int64) ([]byte, error) {
+	if d.chunkTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.chunkTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && offset == 0:
+		// Range ignored: the first chunk is the start of the body
+	case resp.StatusCode == http.StatusOK:
+		return nil, fmt.Errorf("web seed does not support range requests")
+	default:
+		return nil, fmt.Errorf("web seed returned %s", resp.Status)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("web seed: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the peer
+const (
+	ShareAdded        = "share.added"
+	ShareRemoved      = "share.removed"
+	DownloadQueued    = "download.queued"
+	DownloadStarted   = "download.started"
+	DownloadProgress  = "download.progress"
+	DownloadPaused    = "download.paused"
+	DownloadCompleted = "download.completed"
+	DownloadFailed    = "download.failed"
+	DownloadCancelled = "download.cancelled"
+	BandwidthStats    = "bandwidth.stats"
+)
+
+// Event is something that happened in the peer
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// that does not keep up misses events instead of stalling the peer.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates an event bus
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving every event published from now on
+func (b *Bus) Subscribe() chan Event {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivering events to ch and closes it
+func (b *Bus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends an event to all subscribers. A nil Bus discards events.
+func (b *Bus) Publish(eventType string, data any) {
+	if b == nil {
+		return
+	}
+	event := Event{Type: eventType, Time: time.Now(), Data: data}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
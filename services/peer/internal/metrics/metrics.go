@@ -0,0 +1,123 @@
+// Package metrics exposes peer metrics in the Prometheus format.
+//
+// Transfer counters are updated by the P2P server, the relay client and the
+// downloader as chunks move. Gauges describing the peer state (connections,
+// rates, disk usage...) are read from a State function at scrape time.
+package metrics
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Transports label how a chunk travelled
+const (
+	TransportDirect  = "direct"  // TCP connection between peers
+	TransportRelay   = "relay"   // WebSocket relay through the tracker
+	TransportWebSeed = "webseed" // HTTP range request to a web seed of a .p2pmeta file
+)
+
+// Chunk failure reasons
+const (
+	FailureConnect      = "connect"       // Could not connect to the peer
+	FailureRequest      = "request"       // The peer or the relay returned an error or timed out
+	FailureHashMismatch = "hash_mismatch" // The data did not match the chunk hash
+)
+
+var (
+	// Transfer metrics
+	bytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "p2p_peer_bytes_total",
+			Help: "Chunk bytes transferred",
+		},
+		[]string{"direction", "transport"}, // direction: "upload" or "download"
+	)
+
+	chunksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "p2p_peer_chunks_total",
+			Help: "Chunks transferred",
+		},
+		[]string{"direction", "transport"},
+	)
+
+	chunkFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "p2p_peer_chunk_failures_total",
+			Help: "Failed attempts to download a chunk",
+		},
+		[]string{"transport", "reason"},
+	)
+
+	// Download metrics
+	downloadsFinished = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "p2p_peer_downloads_finished_total",
+			Help: "Downloads that completed or failed",
+		},
+		[]string{"result"}, // "completed" or "failed"
+	)
+)
+
+// RecordChunkUploaded counts a chunk sent to another peer
+func RecordChunkUploaded(transport string, size int) {
+	bytesTotal.WithLabelValues("upload", transport).Add(float64(size))
+	chunksTotal.WithLabelValues("upload", transport).Inc()
+}
+
+// RecordChunkDownloaded counts a verified chunk received from another peer
+func RecordChunkDownloaded(transport string, size int) {
+	bytesTotal.WithLabelValues("download", transport).Add(float64(size))
+	chunksTotal.WithLabelValues("download", transport).Inc()
+}
+
+// RecordChunkFailure counts a failed chunk download attempt
+func RecordChunkFailure(transport, reason string) {
+	chunkFailures.WithLabelValues(transport, reason).Inc()
+}
+
+// RecordDownloadFinished counts a download that completed or failed
+func RecordDownloadFinished(completed bool) {
+	if completed {
+		downloadsFinished.WithLabelValues("completed").Inc()
+	} else {
+		downloadsFinished.WithLabelValues("failed").Inc()
+	}
+}
+
+// Handler returns the Prometheus metrics handler
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Start serves /metrics on addr in the background. Unlike the control API the
+// listener may be on any interface so Prometheus can scrape it.
+func Start(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[Metrics] Server error: %v", err)
+		}
+	}()
+
+	log.Printf("[Metrics] Serving /metrics on %s", listener.Addr())
+	return server, nil
+}
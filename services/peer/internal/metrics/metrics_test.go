@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	RegisterState("peer-1", func() State {
+		return State{Connections: 2, RelayConnected: true, QueuedDownloads: 3, DiskFree: -1, UploadLimit: 1024}
+	})
+	RecordChunkUploaded(TransportRelay, 100)
+	RecordChunkDownloaded(TransportDirect, 256)
+	RecordChunkFailure(TransportDirect, FailureHashMismatch)
+	RecordDownloadFinished(true)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+
+	for _, want := range []string{
+		`p2p_peer_info{peer_id="peer-1"} 1`,
+		`p2p_peer_connections_active 2`,
+		`p2p_peer_relay_connected 1`,
+		`p2p_peer_downloads{state="queued"} 3`,
+		`p2p_peer_bandwidth_limit_bytes{direction="upload"} 1024`,
+		`p2p_peer_bytes_total{direction="upload",transport="relay"} 100`,
+		`p2p_peer_bytes_total{direction="download",transport="direct"} 256`,
+		`p2p_peer_chunks_total{direction="download",transport="direct"} 1`,
+		`p2p_peer_chunk_failures_total{reason="hash_mismatch",transport="direct"} 1`,
+		`p2p_peer_downloads_finished_total{result="completed"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics output is missing %s", want)
+		}
+	}
+	if strings.Contains(string(body), "p2p_peer_disk_free_bytes ") {
+		t.Error("Unknown free disk space should not be reported")
+	}
+}
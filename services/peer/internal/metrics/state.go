@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// State is a snapshot of the peer, taken on every scrape
+type State struct {
+	Connections     int   // Peers downloading from us over TCP
+	RelayConnected  bool  // Connected to the relay
+	SharedFiles     int   // Files shared
+	ActiveDownloads int   // Downloads running
+	QueuedDownloads int   // Downloads waiting for a slot
+	PausedDownloads int   // Downloads paused or failed
+	UploadLimit     int64 // Bytes per second in effect, 0 = unlimited
+	DownloadLimit   int64 // Bytes per second in effect, 0 = unlimited
+	StorageUsed     int64 // Bytes used by downloads, temp data and the chunk store, as tracked by the storage
+	StorageQuota    int64 // 0 = no quota
+	DiskFree        int64 // Bytes available on the data volume, -1 if unknown
+	ChunkStoreBytes int64 // Bytes in the chunk store (0 when disabled)
+}
+
+var (
+	infoDesc = prometheus.NewDesc("p2p_peer_info",
+		"Peer information; the peer ID changes on every start", []string{"peer_id"}, nil)
+	connectionsDesc = prometheus.NewDesc("p2p_peer_connections_active",
+		"Peers downloading from this peer over direct TCP", nil, nil)
+	relayConnectedDesc = prometheus.NewDesc("p2p_peer_relay_connected",
+		"1 if connected to the relay", nil, nil)
+	sharedFilesDesc = prometheus.NewDesc("p2p_peer_shared_files",
+		"Files shared by this peer", nil, nil)
+	downloadsDesc = prometheus.NewDesc("p2p_peer_downloads",
+		"Downloads in the queue by state", []string{"state"}, nil)
+	limitDesc = prometheus.NewDesc("p2p_peer_bandwidth_limit_bytes",
+		"Bandwidth limit in effect in bytes per second (0 = unlimited)", []string{"direction"}, nil)
+	storageUsedDesc = prometheus.NewDesc("p2p_peer_storage_used_bytes",
+		"Disk space used by downloads, temp data and the chunk store", nil, nil)
+	storageQuotaDesc = prometheus.NewDesc("p2p_peer_storage_quota_bytes",
+		"Storage quota (0 = no quota)", nil, nil)
+	diskFreeDesc = prometheus.NewDesc("p2p_peer_disk_free_bytes",
+		"Free space on the volume holding the data directory", nil, nil)
+	chunkStoreDesc = prometheus.NewDesc("p2p_peer_chunk_store_bytes",
+		"Bytes held in the content-addressed chunk store", nil, nil)
+)
+
+// stateCollector reports State gauges at scrape time
+type stateCollector struct {
+	peerID string
+	state  func() State
+}
+
+// RegisterState registers the gauges read from state on every scrape. It must
+// be called once.
+func RegisterState(peerID string, state func() State) {
+	prometheus.MustRegister(&stateCollector{peerID: peerID, state: state})
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		infoDesc, connectionsDesc, relayConnectedDesc, sharedFilesDesc, downloadsDesc,
+		limitDesc, storageUsedDesc, storageQuotaDesc, diskFreeDesc, chunkStoreDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.state()
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	gauge(infoDesc, 1, c.peerID)
+	gauge(connectionsDesc, float64(s.Connections))
+	relay := 0.0
+	if s.RelayConnected {
+		relay = 1
+	}
+	gauge(relayConnectedDesc, relay)
+	gauge(sharedFilesDesc, float64(s.SharedFiles))
+	gauge(downloadsDesc, float64(s.ActiveDownloads), "active")
+	gauge(downloadsDesc, float64(s.QueuedDownloads), "queued")
+	gauge(downloadsDesc, float64(s.PausedDownloads), "paused")
+	gauge(limitDesc, float64(s.UploadLimit), "upload")
+	gauge(limitDesc, float64(s.DownloadLimit), "download")
+	gauge(storageUsedDesc, float64(s.StorageUsed))
+	gauge(storageQuotaDesc, float64(s.StorageQuota))
+	if s.DiskFree >= 0 {
+		gauge(diskFreeDesc, float64(s.DiskFree))
+	}
+	gauge(chunkStoreDesc, float64(s.ChunkStoreBytes))
+}
@@ -1,11 +1,16 @@
 package p2p
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/hash"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 )
@@ -14,6 +19,10 @@ import (
 type Client struct {
 	peerID  string
 	timeout time.Duration
+	key     ed25519.PrivateKey // Proves peerID to seeders, nil for public files only
+
+	mu     sync.RWMutex
+	grants map[string]string // File hash -> grant of the tracker, for private files
 }
 
 // NewClient creates a new P2P client
@@ -21,9 +30,30 @@ func NewClient(peerID string) *Client {
 	return &Client{
 		peerID:  peerID,
 		timeout: 5 * time.Second, // Quick timeout for direct TCP check
+		grants:  make(map[string]string),
 	}
 }
 
+// SetPeerKey sets the key the client proves its peer ID with in handshakes,
+// the one registered with the trackers that issue its grants
+func (c *Client) SetPeerKey(key ed25519.PrivateKey) {
+	c.key = key
+}
+
+// SetAccessToken sets the grant sent with the requests for a private file
+func (c *Client) SetAccessToken(fileHash, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.grants[fileHash] = token
+}
+
+// accessToken returns the grant of a file, empty for a public file
+func (c *Client) accessToken(fileHash string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.grants[fileHash]
+}
+
 // SetTimeout sets the connection timeout
 func (c *Client) SetTimeout(timeout time.Duration) {
 	c.timeout = timeout
@@ -35,11 +65,12 @@ type PeerConnection struct {
 	encoder *json.Encoder
 	decoder *json.Decoder
 	peerID  string
+	client  *Client
 }
 
 // Connect establishes a connection to a peer
 func (c *Client) Connect(ip string, port int) (*PeerConnection, error) {
-	addr := fmt.Sprintf("%s:%d", ip, port)
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
 	conn, err := net.DialTimeout("tcp", addr, c.timeout)
 	if err != nil {
 		return nil, err
@@ -49,6 +80,7 @@ func (c *Client) Connect(ip string, port int) (*PeerConnection, error) {
 		conn:    conn,
 		encoder: json.NewEncoder(conn),
 		decoder: json.NewDecoder(conn),
+		client:  c,
 	}
 
 	// Perform handshake
@@ -60,7 +92,8 @@ func (c *Client) Connect(ip string, port int) (*PeerConnection, error) {
 	return pc, nil
 }
 
-// handshake performs the P2P handshake
+// handshake performs the P2P handshake, and proves the peer ID by signing
+// the seeder's nonce when the client has a peer key
 func (pc *PeerConnection) handshake(peerID string) error {
 	// Send handshake
 	msg := protocol.HandshakeMessage{
@@ -80,6 +113,25 @@ func (pc *PeerConnection) handshake(peerID string) error {
 	}
 
 	pc.peerID = resp.PeerID
+	if pc.client.key == nil || resp.Nonce == "" {
+		return nil
+	}
+
+	auth := protocol.AuthMessage{
+		Type:    protocol.MsgAuth,
+		PeerKey: base64.StdEncoding.EncodeToString(pc.client.key.Public().(ed25519.PublicKey)),
+		Proof:   access.SignChallenge(pc.client.key, resp.Nonce, peerID),
+	}
+	if err := pc.encoder.Encode(auth); err != nil {
+		return err
+	}
+	var reply protocol.ErrorMessage
+	if err := pc.decoder.Decode(&reply); err != nil {
+		return err
+	}
+	if reply.Type == protocol.MsgError {
+		return fmt.Errorf("peer error %d: %s", reply.Code, reply.Message)
+	}
 	return nil
 }
 
@@ -87,9 +139,10 @@ func (pc *PeerConnection) handshake(peerID string) error {
 func (pc *PeerConnection) RequestChunk(fileHash string, chunkIndex int, expectedHash string) ([]byte, error) {
 	// Send request
 	req := protocol.RequestChunkMessage{
-		Type:       protocol.MsgRequestChunk,
-		FileHash:   fileHash,
-		ChunkIndex: chunkIndex,
+		Type:        protocol.MsgRequestChunk,
+		FileHash:    fileHash,
+		ChunkIndex:  chunkIndex,
+		AccessToken: pc.client.accessToken(fileHash),
 	}
 
 	if err := pc.encoder.Encode(req); err != nil {
@@ -148,9 +201,10 @@ func (pc *PeerConnection) GetPeerID() string {
 // SendBitfield sends our bitfield to the peer
 func (pc *PeerConnection) SendBitfield(fileHash string, bitfield []bool) (*protocol.BitfieldMessage, error) {
 	msg := protocol.BitfieldMessage{
-		Type:     protocol.MsgBitfield,
-		FileHash: fileHash,
-		Bitfield: bitfield,
+		Type:        protocol.MsgBitfield,
+		FileHash:    fileHash,
+		Bitfield:    bitfield,
+		AccessToken: pc.client.accessToken(fileHash),
 	}
 
 	if err := pc.encoder.Encode(msg); err != nil {
@@ -1,32 +1,66 @@
 package p2p
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/throttle"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/metrics"
 	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
 )
 
 // Server handles incoming P2P connections from other peers
 type Server struct {
-	port     int
-	peerID   string
-	storage  *storage.LocalStorage
-	chunker  *chunker.Chunker
-	listener net.Listener
+	port      int
+	peerID    string
+	storage   *storage.LocalStorage
+	chunker   *chunker.Chunker
+	listener  net.Listener
+	bandwidth *throttle.BandwidthManager
+	verifier  AccessVerifier
+	ctx       context.Context // Cancelled by Stop, ending waits for the upload limit
+	cancel    context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[net.Conn]*ConnectionStats
 }
 
+// ConnectionStats describes an incoming peer connection
+type ConnectionStats struct {
+	RemoteAddr   string    `json:"remote_addr"`
+	PeerID       string    `json:"peer_id,omitempty"` // Set once the peer sends a handshake
+	ConnectedAt  time.Time `json:"connected_at"`
+	ChunksServed int64     `json:"chunks_served"`
+	BytesServed  int64     `json:"bytes_served"`
+	Verified     bool      `json:"verified,omitempty"` // The peer proved PeerID with its peer key
+
+	nonce   string // Sent in the handshake, for the peer to sign
+	peerKey string // Proven by the peer, empty until then
+}
+
+// AccessVerifier checks that a peer was granted access to a private file by
+// a tracker, for the peer key it proved it holds
+type AccessVerifier func(fileHash, peerID, peerKey, token string) error
+
 // NewServer creates a new P2P server
 func NewServer(port int, peerID string, store *storage.LocalStorage) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
 		port:    port,
 		peerID:  peerID,
 		storage: store,
 		chunker: chunker.New(chunker.DefaultChunkSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		conns:   make(map[net.Conn]*ConnectionStats),
 	}
 }
 
@@ -59,6 +93,37 @@ func (s *Server) StartWithRetry(maxRetries int) error {
 	return fmt.Errorf("could not find available port after %d attempts (tried %d-%d)", maxRetries, originalPort, s.port-1)
 }
 
+// SetBandwidthManager limits outgoing chunk data to the manager's upload rate
+func (s *Server) SetBandwidthManager(manager *throttle.BandwidthManager) {
+	s.bandwidth = manager
+}
+
+// SetAccessVerifier sets the check of the grants sent with requests for
+// private files. Without one, private files are served to no one.
+func (s *Server) SetAccessVerifier(verifier AccessVerifier) {
+	s.verifier = verifier
+}
+
+// authorized reports whether the peer of a connection may get the chunks of a
+// file: any peer for a public file; for a private one, the peers that proved
+// their ID in the handshake and have a grant of it
+func (s *Server) authorized(fileHash string, stats *ConnectionStats, token string) bool {
+	if s.storage.Visibility(fileHash) != access.Private {
+		return true
+	}
+	s.mu.Lock()
+	peerID, peerKey := stats.PeerID, stats.peerKey
+	s.mu.Unlock()
+	if s.verifier == nil || peerID == "" || peerKey == "" {
+		return false
+	}
+	if err := s.verifier(fileHash, peerID, peerKey, token); err != nil {
+		log.Printf("[P2P Server] Refused private file %s to peer %s: %v", fileHash[:min(12, len(fileHash))], peerID, err)
+		return false
+	}
+	return true
+}
+
 // GetPort returns the actual port the server is listening on
 func (s *Server) GetPort() int {
 	return s.port
@@ -66,6 +131,7 @@ func (s *Server) GetPort() int {
 
 // Stop stops the P2P server
 func (s *Server) Stop() error {
+	s.cancel()
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -88,10 +154,22 @@ func (s *Server) acceptConnections() {
 // handleConnection processes a single peer connection
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
 
 	remoteAddr := conn.RemoteAddr().String()
 	log.Printf("[P2P Server] New connection from %s", remoteAddr)
 
+	stats := &ConnectionStats{RemoteAddr: remoteAddr, ConnectedAt: time.Now()}
+	s.mu.Lock()
+	s.conns[conn] = stats
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
 	decoder := json.NewDecoder(conn)
 	encoder := json.NewEncoder(conn)
 
@@ -113,7 +191,17 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 		switch baseMsg.Type {
 		case protocol.MsgHandshake:
-			s.handleHandshake(encoder)
+			var req protocol.HandshakeMessage
+			json.Unmarshal(msg, &req)
+			s.handleHandshake(encoder, &req, stats)
+
+		case protocol.MsgAuth:
+			var req protocol.AuthMessage
+			if err := json.Unmarshal(msg, &req); err != nil {
+				s.sendError(encoder, protocol.ErrInvalidMessage, "Invalid auth message")
+				continue
+			}
+			s.handleAuth(encoder, &req, stats)
 
 		case protocol.MsgRequestChunk:
 			var req protocol.RequestChunkMessage
@@ -121,7 +209,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 				s.sendError(encoder, protocol.ErrInvalidMessage, "Invalid request")
 				continue
 			}
-			s.handleChunkRequest(encoder, &req)
+			s.handleChunkRequest(ctx, encoder, &req, stats)
 
 		case protocol.MsgBitfield:
 			var req protocol.BitfieldMessage
@@ -129,7 +217,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 				s.sendError(encoder, protocol.ErrInvalidMessage, "Invalid bitfield")
 				continue
 			}
-			s.handleBitfield(encoder, &req)
+			s.handleBitfield(encoder, &req, stats)
 
 		case protocol.MsgHave:
 			var req protocol.HaveMessage
@@ -145,40 +233,94 @@ func (s *Server) handleConnection(conn net.Conn) {
 	}
 }
 
-// handleHandshake responds to a handshake request
-func (s *Server) handleHandshake(encoder *json.Encoder) {
+// handleHandshake responds to a handshake request with a nonce, which the
+// peer signs to prove the ID it claims. Until it does, it gets public files
+// only.
+func (s *Server) handleHandshake(encoder *json.Encoder, req *protocol.HandshakeMessage, stats *ConnectionStats) {
+	nonce := access.NewNonce()
+	s.mu.Lock()
+	stats.PeerID = req.PeerID
+	stats.Verified = false
+	stats.nonce, stats.peerKey = nonce, ""
+	s.mu.Unlock()
+
 	resp := protocol.HandshakeMessage{
 		Type:    protocol.MsgHandshake,
 		PeerID:  s.peerID,
 		Version: "1.0",
+		Nonce:   nonce,
 	}
 	encoder.Encode(resp)
 }
 
-// handleChunkRequest handles a request for a file chunk
-func (s *Server) handleChunkRequest(encoder *json.Encoder, req *protocol.RequestChunkMessage) {
+// handleAuth checks the proof of the ID a peer sent in its handshake. The
+// nonce is used up either way.
+func (s *Server) handleAuth(encoder *json.Encoder, req *protocol.AuthMessage, stats *ConnectionStats) {
+	s.mu.Lock()
+	peerID, nonce := stats.PeerID, stats.nonce
+	stats.nonce = ""
+	s.mu.Unlock()
+
+	key, err := access.ParsePublicKey(req.PeerKey)
+	if err != nil || peerID == "" || !access.VerifyChallenge(key, nonce, peerID, req.Proof) {
+		log.Printf("[P2P Server] Peer %s failed to prove its ID", peerID)
+		s.sendError(encoder, protocol.ErrAccessDenied, "Invalid proof of peer ID")
+		return
+	}
+
+	s.mu.Lock()
+	stats.Verified = true
+	stats.peerKey = req.PeerKey
+	s.mu.Unlock()
+	encoder.Encode(protocol.AuthMessage{Type: protocol.MsgAuth})
+}
+
+// handleChunkRequest handles a request for a file chunk. ctx ends with the connection.
+func (s *Server) handleChunkRequest(ctx context.Context, encoder *json.Encoder, req *protocol.RequestChunkMessage, stats *ConnectionStats) {
 	log.Printf("[P2P Server] Chunk request: file=%s chunk=%d", req.FileHash[:min(12, len(req.FileHash))], req.ChunkIndex)
 
-	// Find the file
-	sharedFile, exists := s.storage.GetSharedFile(req.FileHash)
-	if !exists {
-		log.Printf("[P2P Server] File not found: %s", req.FileHash[:min(12, len(req.FileHash))])
-		s.sendError(encoder, protocol.ErrFileNotFound, "File not found")
+	if !s.authorized(req.FileHash, stats, req.AccessToken) {
+		s.sendError(encoder, protocol.ErrAccessDenied, "Access denied")
 		return
 	}
 
-	// Read the chunk
-	chunkData, err := s.chunker.ReadChunk(sharedFile.FilePath, req.ChunkIndex)
-	if err != nil {
-		log.Printf("[P2P Server] Failed to read chunk %d: %v", req.ChunkIndex, err)
-		s.sendError(encoder, protocol.ErrChunkNotAvailable, "Could not read chunk")
-		return
+	// Find the file, or a download with the chunk
+	var chunkData []byte
+	var chunkHash, name string
+	if sharedFile, exists := s.storage.GetSharedFile(req.FileHash); exists {
+		data, err := s.storage.ReadSharedChunk(req.FileHash, req.ChunkIndex)
+		if err != nil {
+			log.Printf("[P2P Server] Failed to read chunk %d: %v", req.ChunkIndex, err)
+			s.sendError(encoder, protocol.ErrChunkNotAvailable, "Could not read chunk")
+			return
+		}
+		chunkData, name = data, sharedFile.Metadata.Name
+
+		// Get chunk hash for verification
+		if req.ChunkIndex < len(sharedFile.Metadata.Chunks) {
+			chunkHash = sharedFile.Metadata.Chunks[req.ChunkIndex].Hash
+		}
+	} else {
+		data, hash, err := s.storage.ReadReceivedChunk(req.FileHash, req.ChunkIndex)
+		switch {
+		case err == storage.ErrDownloadNotFound:
+			log.Printf("[P2P Server] File not found: %s", req.FileHash[:min(12, len(req.FileHash))])
+			s.sendError(encoder, protocol.ErrFileNotFound, "File not found")
+			return
+		case err != nil:
+			log.Printf("[P2P Server] Failed to read chunk %d of a download: %v", req.ChunkIndex, err)
+			s.sendError(encoder, protocol.ErrChunkNotAvailable, "Could not read chunk")
+			return
+		}
+		chunkData, chunkHash, name = data, hash, "download "+req.FileHash[:min(12, len(req.FileHash))]
 	}
 
-	// Get chunk hash for verification
-	chunkHash := ""
-	if req.ChunkIndex < len(sharedFile.Metadata.Chunks) {
-		chunkHash = sharedFile.Metadata.Chunks[req.ChunkIndex].Hash
+	// Respect the upload limit before sending
+	if s.bandwidth != nil {
+		if err := s.bandwidth.WaitUpload(ctx, int64(len(chunkData))); err != nil {
+			log.Printf("[P2P Server] Dropped chunk %d waiting for the upload limit: %v", req.ChunkIndex, err)
+			return
+		}
 	}
 
 	// Send the chunk
@@ -190,29 +332,50 @@ func (s *Server) handleChunkRequest(encoder *json.Encoder, req *protocol.Request
 		Data:       chunkData,
 	}
 	log.Printf("[P2P Server] Sending chunk %d (%d bytes) for file %s",
-		req.ChunkIndex, len(chunkData), sharedFile.Metadata.Name)
-	encoder.Encode(resp)
+		req.ChunkIndex, len(chunkData), name)
+	if encoder.Encode(resp) == nil {
+		s.mu.Lock()
+		stats.ChunksServed++
+		stats.BytesServed += int64(len(chunkData))
+		s.mu.Unlock()
+		s.storage.RecordUpload(req.FileHash, int64(len(chunkData)))
+		metrics.RecordChunkUploaded(metrics.TransportDirect, len(chunkData))
+	}
+}
+
+// Connections returns the peers currently connected to this server
+func (s *Server) Connections() []ConnectionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]ConnectionStats, 0, len(s.conns))
+	for _, stats := range s.conns {
+		conns = append(conns, *stats)
+	}
+	return conns
 }
 
 // handleBitfield handles bitfield messages (chunks a peer has)
-func (s *Server) handleBitfield(encoder *json.Encoder, req *protocol.BitfieldMessage) {
-	// Get our bitfield for this file
-	sharedFile, exists := s.storage.GetSharedFile(req.FileHash)
-	if !exists {
-		// We don't have this file, send empty bitfield
-		resp := protocol.BitfieldMessage{
-			Type:     protocol.MsgBitfield,
-			FileHash: req.FileHash,
-			Bitfield: []bool{},
-		}
-		encoder.Encode(resp)
+func (s *Server) handleBitfield(encoder *json.Encoder, req *protocol.BitfieldMessage, stats *ConnectionStats) {
+	if !s.authorized(req.FileHash, stats, req.AccessToken) {
+		s.sendError(encoder, protocol.ErrAccessDenied, "Access denied")
 		return
 	}
 
-	// Build our bitfield (we're a seeder, so we have all chunks)
-	bitfield := make([]bool, len(sharedFile.Metadata.Chunks))
-	for i := range bitfield {
-		bitfield[i] = true
+	// Get our bitfield for this file
+	bitfield := []bool{}
+	if sharedFile, exists := s.storage.GetSharedFile(req.FileHash); exists {
+		// We're a seeder, so we have all chunks
+		bitfield = make([]bool, len(sharedFile.Metadata.Chunks))
+		for i := range bitfield {
+			bitfield[i] = true
+		}
+	} else if state, downloading := s.storage.GetDownload(req.FileHash); downloading {
+		received, _ := s.storage.ReceivedChunks(req.FileHash)
+		bitfield = make([]bool, len(state.Metadata.Chunks))
+		for i := range bitfield {
+			bitfield[i] = received.Has(i)
+		}
 	}
 
 	resp := protocol.BitfieldMessage{
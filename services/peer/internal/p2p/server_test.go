@@ -0,0 +1,113 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+func TestServer_PrivateFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewLocalStorage(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	path := filepath.Join(dir, "report.txt")
+	os.WriteFile(path, []byte("confidential"), 0644)
+	metadata, err := chunker.New(chunker.DefaultChunkSize).ChunkFile(path)
+	if err != nil {
+		t.Fatalf("ChunkFile failed: %v", err)
+	}
+	metadata.Visibility = access.Private
+	store.AddSharedFile(metadata, path)
+
+	// The grant of the leecher is for its key
+	leecherPub, leecherKey, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	server := NewServer(0, "seeder", store)
+	server.SetAccessVerifier(func(fileHash, peerID, peerKey, token string) error {
+		if token != "grant-"+peerID || peerKey != base64.StdEncoding.EncodeToString(leecherPub) {
+			return errors.New("bad grant")
+		}
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+	port := server.listener.Addr().(*net.TCPAddr).Port
+
+	client := func(peerID string, key ed25519.PrivateKey, grant string) *Client {
+		c := NewClient(peerID)
+		c.SetPeerKey(key)
+		if grant != "" {
+			c.SetAccessToken(metadata.Hash, grant)
+		}
+		return c
+	}
+	request := func(client *Client) error {
+		conn, err := client.Connect("127.0.0.1", port)
+		if err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		defer conn.Close()
+		_, err = conn.RequestChunk(metadata.Hash, 0, metadata.Chunks[0].Hash)
+		return err
+	}
+
+	if err := request(client("leecher", leecherKey, "")); err == nil || !strings.Contains(err.Error(), "1007") {
+		t.Errorf("Expected access denied without a grant, got %v", err)
+	}
+	if err := request(client("thief", otherKey, "grant-leecher")); err == nil {
+		t.Error("Expected access denied with the grant of another peer")
+	}
+	if err := request(client("leecher", nil, "grant-leecher")); err == nil {
+		t.Error("Expected access denied to a peer that did not prove its ID")
+	}
+	if err := request(client("leecher", otherKey, "grant-leecher")); err == nil {
+		t.Error("Expected access denied to a peer claiming the ID of another")
+	}
+	if err := request(client("leecher", leecherKey, "grant-leecher")); err != nil {
+		t.Errorf("Expected the chunk with a grant, got %v", err)
+	}
+
+	// A proof of another nonce is refused
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	encoder, decoder := json.NewEncoder(conn), json.NewDecoder(conn)
+	encoder.Encode(protocol.HandshakeMessage{Type: protocol.MsgHandshake, PeerID: "leecher"})
+	var hello protocol.HandshakeMessage
+	if err := decoder.Decode(&hello); err != nil || hello.Nonce == "" {
+		t.Fatalf("Expected a nonce in the handshake, got %+v %v", hello, err)
+	}
+	encoder.Encode(protocol.AuthMessage{
+		Type:    protocol.MsgAuth,
+		PeerKey: base64.StdEncoding.EncodeToString(leecherPub),
+		Proof:   access.SignChallenge(leecherKey, access.NewNonce(), "leecher"),
+	})
+	var reply protocol.ErrorMessage
+	if err := decoder.Decode(&reply); err != nil || reply.Code != protocol.ErrAccessDenied {
+		t.Errorf("Expected the proof refused, got %+v %v", reply, err)
+	}
+
+	// Public files need no grant
+	store.SetVisibility(metadata.Hash, access.Public)
+	if err := request(NewClient("anyone")); err != nil {
+		t.Errorf("Expected the chunk of a public file, got %v", err)
+	}
+}
@@ -1,15 +1,19 @@
 package relay
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/metrics"
 )
 
 // Message types
@@ -25,6 +29,7 @@ const (
 type RelayMessage struct {
 	Type      string          `json:"type"`
 	From      string          `json:"from,omitempty"`
+	FromKey   string          `json:"from_key,omitempty"` // Set by the relay: the peer key in the session of From
 	To        string          `json:"to,omitempty"`
 	RequestID string          `json:"request_id,omitempty"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
@@ -33,8 +38,9 @@ type RelayMessage struct {
 
 // ChunkRequest is the payload for chunk requests
 type ChunkRequest struct {
-	FileHash   string `json:"file_hash"`
-	ChunkIndex int    `json:"chunk_index"`
+	FileHash    string `json:"file_hash"`
+	ChunkIndex  int    `json:"chunk_index"`
+	AccessToken string `json:"access_token,omitempty"` // Grant of the tracker, for a private file
 }
 
 // ChunkResponse is the payload for chunk responses
@@ -59,15 +65,24 @@ type Client struct {
 	send         chan []byte
 	responses    map[string]chan *RelayMessage
 	chunkHandler ChunkHandler
+	verifier     AccessVerifier
+	session      func() string     // Session token of this peer on the relay's tracker
+	grants       map[string]string // File hash -> grant of the tracker, for private files
 	mu           sync.RWMutex
 	connected    bool
+	cancel       context.CancelFunc // Ends the context of the current connection
 	done         chan struct{}
 	closing      bool // true when Close() is called intentionally
 	reconnectCh  chan struct{}
 }
 
-// ChunkHandler is called when a chunk request is received
-type ChunkHandler func(fileHash string, chunkIndex int) ([]byte, string, error)
+// ChunkHandler is called when a chunk request is received, with a context
+// that ends when the relay connection does
+type ChunkHandler func(ctx context.Context, fileHash string, chunkIndex int) ([]byte, string, error)
+
+// AccessVerifier is called before serving a chunk request, with the peer the
+// relay says sent it, the peer key of its session and the grant it sent
+type AccessVerifier func(fileHash, peerID, peerKey, token string) error
 
 // NewClient creates a new relay client
 func NewClient(peerID, trackerURL string) *Client {
@@ -76,6 +91,7 @@ func NewClient(peerID, trackerURL string) *Client {
 		trackerURL:  trackerURL,
 		send:        make(chan []byte, 256),
 		responses:   make(map[string]chan *RelayMessage),
+		grants:      make(map[string]string),
 		done:        make(chan struct{}),
 		reconnectCh: make(chan struct{}, 1),
 	}
@@ -86,6 +102,25 @@ func (c *Client) SetChunkHandler(handler ChunkHandler) {
 	c.chunkHandler = handler
 }
 
+// SetAccessVerifier sets the check of chunk requests, which refuses the
+// chunks of private files to peers without a grant
+func (c *Client) SetAccessVerifier(verifier AccessVerifier) {
+	c.verifier = verifier
+}
+
+// SetSessionToken sets where the session token this peer connects with comes
+// from. The relay then vouches for its peer key, which its grants are for.
+func (c *Client) SetSessionToken(session func() string) {
+	c.session = session
+}
+
+// SetAccessToken sets the grant sent with the requests for a private file
+func (c *Client) SetAccessToken(fileHash, token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.grants[fileHash] = token
+}
+
 // Connect establishes WebSocket connection to relay
 func (c *Client) Connect() error {
 	if err := c.doConnect(); err != nil {
@@ -119,17 +154,25 @@ func (c *Client) doConnect() error {
 		HandshakeTimeout: 10 * time.Second,
 	}
 
-	conn, _, err := dialer.Dial(u.String(), nil)
+	header := http.Header{}
+	if c.session != nil {
+		if token := c.session(); token != "" {
+			header.Set(protocol.HeaderPeerToken, token)
+		}
+	}
+	conn, _, err := dialer.Dial(u.String(), header)
 	if err != nil {
 		return fmt.Errorf("relay connect failed: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	c.mu.Lock()
 	c.conn = conn
 	c.connected = true
+	c.cancel = cancel
 	c.mu.Unlock()
 
-	go c.readPump()
+	go c.readPump(ctx)
 	go c.writePump()
 
 	log.Printf("[Relay] Connected to %s", u.String())
@@ -183,6 +226,9 @@ func (c *Client) Close() {
 
 	c.closing = true
 	c.connected = false
+	if c.cancel != nil {
+		c.cancel()
+	}
 
 	select {
 	case <-c.done:
@@ -204,6 +250,9 @@ func (c *Client) disconnect() {
 		return
 	}
 	c.connected = false
+	if c.cancel != nil {
+		c.cancel()
+	}
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
@@ -239,6 +288,7 @@ func (c *Client) RequestChunk(targetPeerID, fileHash string, chunkIndex int) ([]
 	respChan := make(chan *RelayMessage, 1)
 	c.mu.Lock()
 	c.responses[requestID] = respChan
+	accessToken := c.grants[fileHash]
 	c.mu.Unlock()
 
 	defer func() {
@@ -249,8 +299,9 @@ func (c *Client) RequestChunk(targetPeerID, fileHash string, chunkIndex int) ([]
 
 	// Send chunk request
 	payload, _ := json.Marshal(ChunkRequest{
-		FileHash:   fileHash,
-		ChunkIndex: chunkIndex,
+		FileHash:    fileHash,
+		ChunkIndex:  chunkIndex,
+		AccessToken: accessToken,
 	})
 
 	msg := RelayMessage{
@@ -287,8 +338,8 @@ func (c *Client) RequestChunk(targetPeerID, fileHash string, chunkIndex int) ([]
 	}
 }
 
-// readPump reads messages from relay
-func (c *Client) readPump() {
+// readPump reads messages from relay. ctx ends when the connection does.
+func (c *Client) readPump(ctx context.Context) {
 	defer c.disconnect()
 
 	for {
@@ -316,7 +367,7 @@ func (c *Client) readPump() {
 			continue
 		}
 
-		c.handleMessage(&msg)
+		c.handleMessage(ctx, &msg)
 	}
 }
 
@@ -366,10 +417,10 @@ func (c *Client) writePump() {
 }
 
 // handleMessage processes incoming relay messages
-func (c *Client) handleMessage(msg *RelayMessage) {
+func (c *Client) handleMessage(ctx context.Context, msg *RelayMessage) {
 	switch msg.Type {
 	case MsgChunkRequest:
-		c.handleChunkRequest(msg)
+		c.handleChunkRequest(ctx, msg)
 
 	case MsgChunkData, MsgError:
 		// Route to waiting request
@@ -390,7 +441,7 @@ func (c *Client) handleMessage(msg *RelayMessage) {
 }
 
 // handleChunkRequest handles incoming chunk requests from other peers
-func (c *Client) handleChunkRequest(msg *RelayMessage) {
+func (c *Client) handleChunkRequest(ctx context.Context, msg *RelayMessage) {
 	fromPeer := msg.From
 	if len(fromPeer) > 8 {
 		fromPeer = fromPeer[:8]
@@ -416,8 +467,16 @@ func (c *Client) handleChunkRequest(msg *RelayMessage) {
 	}
 	log.Printf("[Relay] Request: file=%s chunk=%d from=%s", fileHashShort, req.ChunkIndex, fromPeer)
 
+	if c.verifier != nil {
+		if err := c.verifier(req.FileHash, msg.From, msg.FromKey, req.AccessToken); err != nil {
+			log.Printf("[Relay] Refused chunk request from %s: %v", fromPeer, err)
+			c.sendError(msg.From, msg.RequestID, 403, "Access denied")
+			return
+		}
+	}
+
 	// Get chunk data
-	data, hash, err := c.chunkHandler(req.FileHash, req.ChunkIndex)
+	data, hash, err := c.chunkHandler(ctx, req.FileHash, req.ChunkIndex)
 	if err != nil {
 		log.Printf("[Relay] Failed to get chunk: %v", err)
 		c.sendError(msg.From, msg.RequestID, 404, err.Error())
@@ -444,6 +503,7 @@ func (c *Client) handleChunkRequest(msg *RelayMessage) {
 
 	respData, _ := json.Marshal(resp)
 	c.send <- respData
+	metrics.RecordChunkUploaded(metrics.TransportRelay, len(data))
 }
 
 // sendError sends an error response
@@ -0,0 +1,141 @@
+package scanner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// CacheEntry is the hashed metadata of a file together with the file
+// attributes it was computed from
+type CacheEntry struct {
+	Size     int64                  `json:"size"`
+	ModTime  int64                  `json:"mod_time"` // Unix nanoseconds
+	Inode    uint64                 `json:"inode,omitempty"`
+	Chunker  string                 `json:"chunker"` // Chunking parameters (chunker.Params)
+	Metadata *protocol.FileMetadata `json:"metadata"`
+}
+
+// HashCache persists file metadata keyed by path so unchanged files are not
+// rehashed on every scan or restart
+type HashCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*CacheEntry // file path -> entry
+	dirty   bool
+}
+
+// NewHashCache loads the cache stored at path, starting empty if it does not exist
+func NewHashCache(path string) (*HashCache, error) {
+	c := &HashCache{
+		path:    path,
+		entries: make(map[string]*CacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		// A corrupt cache only costs a rehash
+		c.entries = make(map[string]*CacheEntry)
+	}
+	return c, nil
+}
+
+// Lookup returns the cached metadata for a file if its size, mtime, inode and
+// chunking parameters are unchanged since it was hashed
+func (c *HashCache) Lookup(path string, info os.FileInfo, chunkerParams string) (*protocol.FileMetadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[path]
+	if !ok || entry.Metadata == nil {
+		return nil, false
+	}
+	if entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() ||
+		entry.Inode != fileInode(info) || entry.Chunker != chunkerParams {
+		return nil, false
+	}
+	return entry.Metadata, true
+}
+
+// Store records the metadata hashed from a file with the given attributes
+func (c *HashCache) Store(path string, info os.FileInfo, chunkerParams string, metadata *protocol.FileMetadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[path] = &CacheEntry{
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Inode:    fileInode(info),
+		Chunker:  chunkerParams,
+		Metadata: metadata,
+	}
+	c.dirty = true
+}
+
+// Remove forgets a file
+func (c *HashCache) Remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[path]; ok {
+		delete(c.entries, path)
+		c.dirty = true
+	}
+}
+
+// Paths returns every cached file path
+func (c *HashCache) Paths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths := make([]string, 0, len(c.entries))
+	for path := range c.entries {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Len returns the number of cached files
+func (c *HashCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Save writes the cache to disk if it changed since the last save.
+// The file is replaced atomically so a crash never leaves a truncated cache.
+func (c *HashCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,217 @@
+package scanner
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+// progressLogSize is the file size from which hashing progress is logged
+const progressLogSize = 1 << 30
+
+// Announcer publishes and withdraws shared files (implemented by client.TrackerClient)
+type Announcer interface {
+	AnnounceFile(file *protocol.FileMetadata) (*protocol.AnnounceResponse, error)
+	WithdrawFile(fileHash string) error
+}
+
+// Result summarizes one scan of the shared directory
+type Result struct {
+	Added     int // New files shared
+	Changed   int // Files modified since they were shared
+	Removed   int // Shared files deleted from disk
+	Rehashed  int // Files that had to be hashed (cache misses)
+	Announced int // Files announced to the tracker
+	Failed    int // Files that could not be hashed or announced
+}
+
+// Scanner keeps the files of a shared directory in sync with local storage and
+// the tracker. File metadata is cached by path, size, mtime and inode so only
+// new or changed files are hashed.
+type Scanner struct {
+	dir       string
+	store     *storage.LocalStorage
+	chunker   *chunker.Chunker
+	cache     *HashCache
+	tracker   Announcer
+	announced map[string]bool // file hashes announced during this run
+}
+
+// New creates a scanner for dir
+func New(dir string, store *storage.LocalStorage, c *chunker.Chunker, cache *HashCache, tracker Announcer) *Scanner {
+	return &Scanner{
+		dir:       dir,
+		store:     store,
+		chunker:   c,
+		cache:     cache,
+		tracker:   tracker,
+		announced: make(map[string]bool),
+	}
+}
+
+// Scan shares new files, re-announces modified files and withdraws deleted ones.
+// Files are announced once per run, so the first scan after a restart announces
+// everything (from the cache) under the new peer ID.
+func (s *Scanner) Scan() Result {
+	var result Result
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("[Scanner] Error reading shared directory: %v", err)
+		return result
+	}
+
+	// Files currently shared from this directory, by path
+	sharedByPath := make(map[string]*storage.SharedFile)
+	for _, shared := range s.store.ListSharedFiles() {
+		if !shared.Stored && filepath.Dir(shared.FilePath) == filepath.Clean(s.dir) {
+			sharedByPath[shared.FilePath] = shared
+		}
+	}
+
+	seen := make(map[string]bool)
+	pathByHash := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue // Skip directories and special files
+		}
+
+		filePath := filepath.Join(s.dir, entry.Name())
+		seen[filePath] = true
+
+		metadata, rehashed, err := s.metadataFor(filePath)
+		if err != nil {
+			log.Printf("[Scanner] Error hashing %s: %v", entry.Name(), err)
+			result.Failed++
+			continue
+		}
+		if rehashed {
+			result.Rehashed++
+		}
+
+		// Identical copies are shared once, under the first path
+		if first, dup := pathByHash[metadata.Hash]; dup {
+			if rehashed {
+				log.Printf("[Scanner] %s has the same content as %s, not shared twice", entry.Name(), filepath.Base(first))
+			}
+			continue
+		}
+		pathByHash[metadata.Hash] = filePath
+
+		previous, wasShared := sharedByPath[filePath]
+		switch {
+		case !wasShared:
+			s.store.AddSharedFile(metadata, filePath)
+			result.Added++
+		case previous.Metadata.Hash != metadata.Hash:
+			s.withdraw(previous.Metadata.Hash)
+			s.store.RemoveSharedFile(previous.Metadata.Hash)
+			s.store.AddSharedFile(metadata, filePath)
+			log.Printf("[Scanner] Modified: %s", metadata.Name)
+			result.Changed++
+		}
+
+		if s.announced[metadata.Hash] {
+			continue
+		}
+		if _, err := s.tracker.AnnounceFile(metadata); err != nil {
+			log.Printf("[Scanner] Error announcing %s: %v", metadata.Name, err)
+			result.Failed++
+			continue
+		}
+		s.announced[metadata.Hash] = true
+		result.Announced++
+	}
+
+	// Shared files that disappeared from disk
+	for filePath, shared := range sharedByPath {
+		if seen[filePath] {
+			continue
+		}
+		s.withdraw(shared.Metadata.Hash)
+		s.store.RemoveSharedFile(shared.Metadata.Hash)
+		log.Printf("[Scanner] Removed: %s", shared.Metadata.Name)
+		result.Removed++
+	}
+	for _, filePath := range s.cache.Paths() {
+		if filepath.Dir(filePath) == filepath.Clean(s.dir) && !seen[filePath] {
+			s.cache.Remove(filePath)
+		}
+	}
+
+	if err := s.cache.Save(); err != nil {
+		log.Printf("[Scanner] Failed to save hash cache: %v", err)
+	}
+	if result.Added > 0 || result.Changed > 0 || result.Removed > 0 {
+		s.store.SaveState()
+	}
+	return result
+}
+
+// metadataFor returns the metadata of a file from the cache, hashing it on a miss
+func (s *Scanner) metadataFor(filePath string) (*protocol.FileMetadata, bool, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if metadata, ok := s.cache.Lookup(filePath, info, s.chunker.Params()); ok {
+		return metadata, false, nil
+	}
+
+	var progress chunker.ProgressFunc
+	if info.Size() >= progressLogSize {
+		progress = logProgress(filepath.Base(filePath))
+	}
+	metadata, err := s.chunker.ChunkFileProgress(filePath, progress)
+	if err != nil {
+		return nil, true, err
+	}
+
+	// Only cache the result if the file did not change while it was being hashed
+	if after, err := os.Stat(filePath); err == nil &&
+		after.Size() == info.Size() && after.ModTime().Equal(info.ModTime()) {
+		s.cache.Store(filePath, info, s.chunker.Params(), metadata)
+	}
+	return metadata, true, nil
+}
+
+// logProgress returns a progress callback logging every 10% of a file hashed
+func logProgress(name string) chunker.ProgressFunc {
+	next := int64(10)
+	return func(hashed, total int64) {
+		if percent := hashed * 100 / total; percent >= next && hashed < total {
+			log.Printf("[Scanner] Hashing %s: %d%%", name, percent)
+			next = percent/10*10 + 10
+		}
+	}
+}
+
+// StopSharing withdraws and unshares every file of the directory, e.g. when it
+// is removed from the configuration. It returns the number of files removed.
+func (s *Scanner) StopSharing() int {
+	removed := 0
+	for _, shared := range s.store.ListSharedFiles() {
+		if shared.Stored || filepath.Dir(shared.FilePath) != filepath.Clean(s.dir) {
+			continue
+		}
+		s.withdraw(shared.Metadata.Hash)
+		s.store.RemoveSharedFile(shared.Metadata.Hash)
+		removed++
+	}
+	return removed
+}
+
+// withdraw tells the tracker a file is no longer shared
+func (s *Scanner) withdraw(fileHash string) {
+	if !s.announced[fileHash] {
+		return
+	}
+	delete(s.announced, fileHash)
+	if err := s.tracker.WithdrawFile(fileHash); err != nil {
+		log.Printf("[Scanner] Error withdrawing %s: %v", fileHash[:min(12, len(fileHash))], err)
+	}
+}
@@ -0,0 +1,180 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/peer/internal/storage"
+)
+
+type fakeTracker struct {
+	announced []string
+	withdrawn []string
+}
+
+func (f *fakeTracker) AnnounceFile(file *protocol.FileMetadata) (*protocol.AnnounceResponse, error) {
+	f.announced = append(f.announced, file.Name)
+	return &protocol.AnnounceResponse{Success: true, FileID: file.Hash}, nil
+}
+
+func (f *fakeTracker) WithdrawFile(fileHash string) error {
+	f.withdrawn = append(f.withdrawn, fileHash)
+	return nil
+}
+
+func newTestScanner(t *testing.T, dataDir, sharedDir string, tracker Announcer) (*Scanner, *storage.LocalStorage) {
+	t.Helper()
+	store, err := storage.NewLocalStorage(dataDir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	cache, err := NewHashCache(filepath.Join(dataDir, "hashcache.json"))
+	if err != nil {
+		t.Fatalf("NewHashCache failed: %v", err)
+	}
+	return New(sharedDir, store, chunker.New(1024), cache, tracker), store
+}
+
+func TestScanner_Incremental(t *testing.T) {
+	dataDir := t.TempDir()
+	sharedDir := filepath.Join(dataDir, "shared")
+	os.MkdirAll(sharedDir, 0755)
+	os.WriteFile(filepath.Join(sharedDir, "a.txt"), []byte("content a"), 0644)
+	os.WriteFile(filepath.Join(sharedDir, "b.txt"), []byte("content b"), 0644)
+
+	tracker := &fakeTracker{}
+	s, store := newTestScanner(t, dataDir, sharedDir, tracker)
+
+	result := s.Scan()
+	if result.Added != 2 || result.Rehashed != 2 || result.Announced != 2 {
+		t.Errorf("First scan = %+v, want 2 added, hashed and announced", result)
+	}
+
+	// Nothing changed: no hashing, no announcements
+	result = s.Scan()
+	if result != (Result{}) {
+		t.Errorf("Second scan = %+v, want no work", result)
+	}
+
+	// Modify a.txt and delete b.txt
+	aPath := filepath.Join(sharedDir, "a.txt")
+	oldA, _ := store.GetSharedFile(sharedHashByName(store, "a.txt"))
+	oldHash := oldA.Metadata.Hash
+	os.WriteFile(aPath, []byte("content a, version 2"), 0644)
+	os.Chtimes(aPath, time.Now(), time.Now().Add(time.Second))
+	bHash := sharedHashByName(store, "b.txt")
+	os.Remove(filepath.Join(sharedDir, "b.txt"))
+
+	result = s.Scan()
+	if result.Changed != 1 || result.Removed != 1 || result.Rehashed != 1 || result.Announced != 1 {
+		t.Errorf("Third scan = %+v, want 1 changed, 1 removed, 1 hashed, 1 announced", result)
+	}
+	if !slices.Contains(tracker.withdrawn, oldHash) || !slices.Contains(tracker.withdrawn, bHash) {
+		t.Errorf("Withdrawn = %v, want old a.txt and b.txt hashes", tracker.withdrawn)
+	}
+	if _, ok := store.GetSharedFile(oldHash); ok {
+		t.Error("Old version of a.txt should no longer be shared")
+	}
+	if _, ok := store.GetSharedFile(bHash); ok {
+		t.Error("Deleted b.txt should no longer be shared")
+	}
+	if len(store.GetAllSharedHashes()) != 1 {
+		t.Errorf("Expected 1 shared file, got %d", len(store.GetAllSharedHashes()))
+	}
+}
+
+func TestScanner_RestartUsesCache(t *testing.T) {
+	dataDir := t.TempDir()
+	sharedDir := filepath.Join(dataDir, "shared")
+	os.MkdirAll(sharedDir, 0755)
+	os.WriteFile(filepath.Join(sharedDir, "big.bin"), make([]byte, 5000), 0644)
+
+	s, _ := newTestScanner(t, dataDir, sharedDir, &fakeTracker{})
+	s.Scan()
+
+	// A new process re-announces everything without rehashing
+	tracker := &fakeTracker{}
+	restarted, _ := newTestScanner(t, dataDir, sharedDir, tracker)
+	result := restarted.Scan()
+	if result.Rehashed != 0 {
+		t.Errorf("Rehashed %d files after restart, want 0", result.Rehashed)
+	}
+	if result.Announced != 1 || len(tracker.announced) != 1 {
+		t.Errorf("Announced %d files after restart, want 1", result.Announced)
+	}
+}
+
+func TestScanner_StopSharing(t *testing.T) {
+	dataDir := t.TempDir()
+	sharedDir := filepath.Join(dataDir, "shared")
+	otherDir := filepath.Join(dataDir, "other")
+	os.MkdirAll(sharedDir, 0755)
+	os.MkdirAll(otherDir, 0755)
+	os.WriteFile(filepath.Join(sharedDir, "a.txt"), []byte("content a"), 0644)
+	os.WriteFile(filepath.Join(otherDir, "b.txt"), []byte("content b"), 0644)
+
+	tracker := &fakeTracker{}
+	s, store := newTestScanner(t, dataDir, sharedDir, tracker)
+	cache, _ := NewHashCache(filepath.Join(dataDir, "hashcache.json"))
+	other := New(otherDir, store, chunker.New(1024), cache, tracker)
+	s.Scan()
+	other.Scan()
+
+	if removed := s.StopSharing(); removed != 1 {
+		t.Errorf("StopSharing removed %d files, want 1", removed)
+	}
+	if len(tracker.withdrawn) != 1 {
+		t.Errorf("Withdrawn = %v, want a.txt only", tracker.withdrawn)
+	}
+	if hashes := store.GetAllSharedHashes(); len(hashes) != 1 || hashes[0] != sharedHashByName(store, "b.txt") {
+		t.Errorf("Files of other directories should stay shared, got %v", hashes)
+	}
+}
+
+func TestHashCache_Lookup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	os.WriteFile(path, []byte("hello"), 0644)
+	info, _ := os.Stat(path)
+
+	cache, _ := NewHashCache(filepath.Join(dir, "cache.json"))
+	meta := &protocol.FileMetadata{Name: "file.txt", Hash: "h1"}
+	cache.Store(path, info, "fixed:1024", meta)
+
+	if got, ok := cache.Lookup(path, info, "fixed:1024"); !ok || got.Hash != "h1" {
+		t.Error("Expected cache hit for unchanged file")
+	}
+	if _, ok := cache.Lookup(path, info, "cdc:256:1024:4096"); ok {
+		t.Error("Expected cache miss for different chunking parameters")
+	}
+
+	// Same size, different mtime
+	os.Chtimes(path, time.Now(), info.ModTime().Add(time.Minute))
+	changed, _ := os.Stat(path)
+	if _, ok := cache.Lookup(path, changed, "fixed:1024"); ok {
+		t.Error("Expected cache miss after mtime change")
+	}
+
+	// Persisted across reloads
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	reloaded, _ := NewHashCache(filepath.Join(dir, "cache.json"))
+	if _, ok := reloaded.Lookup(path, info, "fixed:1024"); !ok {
+		t.Error("Expected cache hit after reload")
+	}
+}
+
+func sharedHashByName(store *storage.LocalStorage, name string) string {
+	for _, shared := range store.ListSharedFiles() {
+		if shared.Metadata.Name == name {
+			return shared.Metadata.Hash
+		}
+	}
+	return ""
+}
@@ -0,0 +1,585 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/chunker"
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+)
+
+// ChunkStore is a content-addressed store of chunks keyed by their SHA-256 hash.
+// A chunk is kept once no matter how many files or downloads contain it; owners
+// (file hashes) hold references and unreferenced chunks are removed by GC.
+type ChunkStore struct {
+	mu    sync.Mutex
+	dir   string
+	refs  map[string]map[string]struct{} // chunkHash -> owner file hashes
+	files int                            // Files on disk, counted on open and updated as chunks are written and deleted
+	bytes int64                          // Their size
+}
+
+// ChunkStoreStats summarizes the contents of a chunk store
+type ChunkStoreStats struct {
+	Chunks     int   `json:"chunks"`     // Chunks on disk
+	Referenced int   `json:"referenced"` // Chunks with at least one owner
+	References int   `json:"references"` // Total (chunk, owner) references
+	Bytes      int64 `json:"bytes"`      // Disk space used by chunks
+}
+
+// NewChunkStore opens (or creates) a chunk store rooted at dir. It walks the
+// store once to count its chunks; the counts are kept up to date from then on.
+func NewChunkStore(dir string) (*ChunkStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &ChunkStore{
+		dir:  dir,
+		refs: make(map[string]map[string]struct{}),
+	}
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			c.files++
+			c.bytes += info.Size()
+		}
+		return nil
+	})
+	return c, nil
+}
+
+// path returns the on-disk location of a chunk, fanned out by hash prefix
+func (c *ChunkStore) path(chunkHash string) string {
+	if len(chunkHash) < 2 {
+		return filepath.Join(c.dir, "_", chunkHash)
+	}
+	return filepath.Join(c.dir, chunkHash[:2], chunkHash)
+}
+
+// Has reports whether the chunk is present
+func (c *ChunkStore) Has(chunkHash string) bool {
+	_, err := os.Stat(c.path(chunkHash))
+	return err == nil
+}
+
+// Get reads a chunk and verifies it against its hash
+func (c *ChunkStore) Get(chunkHash string) ([]byte, error) {
+	data, err := os.ReadFile(c.path(chunkHash))
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Verify(data, chunkHash) {
+		return nil, fmt.Errorf("chunk %s is corrupt", chunkHash)
+	}
+	return data, nil
+}
+
+// Put stores a chunk and adds a reference from owner.
+// Storing a chunk that is already present only adds the reference.
+func (c *ChunkStore) Put(chunkHash string, data []byte, owner string) error {
+	if !hash.Verify(data, chunkHash) {
+		return fmt.Errorf("chunk data does not match hash %s", chunkHash)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(chunkHash)
+	if _, err := os.Stat(path); err != nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// Write to a temp file first so a crash never leaves a truncated chunk
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		c.files++
+		c.bytes += int64(len(data))
+	}
+
+	c.addRefUnsafe(chunkHash, owner)
+	return nil
+}
+
+// Verify reports whether a chunk is present and intact; a corrupt chunk is deleted
+func (c *ChunkStore) Verify(chunkHash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.path(chunkHash))
+	if err != nil {
+		return false
+	}
+	if !hash.Verify(data, chunkHash) {
+		if os.Remove(c.path(chunkHash)) == nil {
+			c.files--
+			c.bytes -= int64(len(data))
+		}
+		return false
+	}
+	return true
+}
+
+// AddRef adds a reference from owner to a chunk that is already stored
+func (c *ChunkStore) AddRef(chunkHash, owner string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.Has(chunkHash) {
+		return false
+	}
+	c.addRefUnsafe(chunkHash, owner)
+	return true
+}
+
+func (c *ChunkStore) addRefUnsafe(chunkHash, owner string) {
+	owners, ok := c.refs[chunkHash]
+	if !ok {
+		owners = make(map[string]struct{})
+		c.refs[chunkHash] = owners
+	}
+	owners[owner] = struct{}{}
+}
+
+// RefCount returns the number of owners referencing a chunk
+func (c *ChunkStore) RefCount(chunkHash string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.refs[chunkHash])
+}
+
+// Release drops every reference held by owner and returns how many were dropped.
+// The chunks themselves stay on disk until GC.
+func (c *ChunkStore) Release(owner string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	released := 0
+	for chunkHash, owners := range c.refs {
+		if _, ok := owners[owner]; !ok {
+			continue
+		}
+		delete(owners, owner)
+		released++
+		if len(owners) == 0 {
+			delete(c.refs, chunkHash)
+		}
+	}
+	return released
+}
+
+// Drop releases every reference held by owner and deletes the chunks no other
+// owner references. Unlike GC it only looks at the owner's chunks, not at the
+// whole store. It returns the number of chunks removed and bytes freed.
+func (c *ChunkStore) Drop(owner string) (removed int, freed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for chunkHash, owners := range c.refs {
+		if _, ok := owners[owner]; !ok {
+			continue
+		}
+		delete(owners, owner)
+		if len(owners) > 0 {
+			continue
+		}
+		delete(c.refs, chunkHash)
+
+		path := c.path(chunkHash)
+		if info, err := os.Stat(path); err == nil && os.Remove(path) == nil {
+			removed++
+			freed += info.Size()
+		}
+	}
+	c.files -= removed
+	c.bytes -= freed
+	return removed, freed
+}
+
+// GC deletes chunks that no owner references, including leftovers from
+// interrupted writes. It walks the whole store, so it only runs on startup and
+// when space runs out. It returns the number of chunks removed and bytes freed.
+func (c *ChunkStore) GC() (removed int, freed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if filepath.Ext(name) != ".tmp" {
+			if _, referenced := c.refs[name]; referenced {
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+			freed += info.Size()
+		}
+		return nil
+	})
+
+	c.files -= removed
+	c.bytes -= freed
+	return removed, freed
+}
+
+// Stats returns chunk, reference and disk usage counts, without reading the
+// disk
+func (c *ChunkStore) Stats() ChunkStoreStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := ChunkStoreStats{Referenced: len(c.refs), Chunks: c.files, Bytes: c.bytes}
+	for _, owners := range c.refs {
+		stats.References += len(owners)
+	}
+	return stats
+}
+
+// Bytes returns the disk space used by chunks
+func (c *ChunkStore) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// chunkLocation is where a chunk can be read from a shared file
+type chunkLocation struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// EnableChunkStore stores the chunks of new downloads in a content-addressed store
+// under baseDir/chunks instead of per-download temp directories, so chunks shared
+// by several files are kept and downloaded once. Completed downloads stay in the
+// store and are shared from it, with no assembled copy. References held by
+// downloads and stored files are rebuilt from the saved state and orphaned
+// chunks are collected.
+func (s *LocalStorage) EnableChunkStore() error {
+	store, err := NewChunkStore(filepath.Join(s.baseDir, "chunks"))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for fileHash, state := range s.downloads {
+		if !state.ChunkStore || state.Status == StatusCancelled {
+			continue
+		}
+		lost := false
+		for i, received := range state.ChunksReceived {
+			if !received || i >= len(state.Metadata.Chunks) {
+				continue
+			}
+			// A chunk lost from the store or corrupted has to be downloaded again
+			chunkHash := state.Metadata.Chunks[i].Hash
+			if !store.Verify(chunkHash) || !store.AddRef(chunkHash, fileHash) {
+				state.ChunksReceived[i] = false
+				lost = true
+			}
+		}
+		if lost && state.Status == StatusCompleted {
+			// The file can't be served whole anymore: stop sharing it until
+			// the download is resumed
+			log.Printf("[Storage] Chunks of %s are missing from the chunk store", state.Metadata.Name)
+			now := time.Now()
+			state.Status, state.PausedAt, state.CompletedAt = StatusPaused, &now, nil
+			if shared, ok := s.sharedFiles[fileHash]; ok && shared.Stored {
+				delete(s.sharedFiles, fileHash)
+			}
+		}
+	}
+	// Stored files whose download was cancelled after it completed
+	for fileHash, shared := range s.sharedFiles {
+		if !shared.Stored {
+			continue
+		}
+		if _, ok := s.downloads[fileHash]; ok {
+			continue
+		}
+		for _, chunk := range shared.Metadata.Chunks {
+			if !store.Verify(chunk.Hash) || !store.AddRef(chunk.Hash, fileHash) {
+				log.Printf("[Storage] Chunks of %s are missing from the chunk store", shared.Metadata.Name)
+				store.Release(fileHash)
+				delete(s.sharedFiles, fileHash)
+				break
+			}
+		}
+	}
+	s.chunkIndex = nil
+
+	s.chunks = store
+	s.saveStateUnsafe()
+	if removed, freed := store.GC(); removed > 0 {
+		log.Printf("[Storage] Removed %d unreferenced chunks (%d bytes)", removed, freed)
+	}
+	return nil
+}
+
+// ChunkStore returns the content-addressed chunk store, or nil if it is not enabled
+func (s *LocalStorage) ChunkStore() *ChunkStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.chunks
+}
+
+// SaveChunk writes a downloaded chunk to the download's chunk location.
+// The caller marks it received with MarkChunkReceived.
+func (s *LocalStorage) SaveChunk(fileHash string, chunkIndex int, data []byte) error {
+	s.mu.RLock()
+	state, exists := s.downloads[fileHash]
+	if !exists {
+		s.mu.RUnlock()
+		return ErrDownloadNotFound
+	}
+	useStore := state.ChunkStore && s.chunks != nil
+	tempDir := state.TempDir
+	var chunkHash string
+	if chunkIndex < len(state.Metadata.Chunks) {
+		chunkHash = state.Metadata.Chunks[chunkIndex].Hash
+	}
+	s.mu.RUnlock()
+
+	if useStore {
+		return s.chunks.Put(chunkHash, data, fileHash)
+	}
+	path := filepath.Join(tempDir, fmt.Sprintf("chunk_%d", chunkIndex))
+	var replaced int64
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	s.used.Add(int64(len(data)) - replaced)
+	return nil
+}
+
+// ReadDownloadChunk reads a chunk previously saved with SaveChunk
+func (s *LocalStorage) ReadDownloadChunk(fileHash string, chunkIndex int) ([]byte, error) {
+	s.mu.RLock()
+	state, exists := s.downloads[fileHash]
+	if !exists {
+		s.mu.RUnlock()
+		return nil, ErrDownloadNotFound
+	}
+	useStore := state.ChunkStore && s.chunks != nil
+	tempDir := state.TempDir
+	var chunkHash string
+	if chunkIndex < len(state.Metadata.Chunks) {
+		chunkHash = state.Metadata.Chunks[chunkIndex].Hash
+	}
+	s.mu.RUnlock()
+
+	if useStore {
+		return s.chunks.Get(chunkHash)
+	}
+	return os.ReadFile(filepath.Join(tempDir, fmt.Sprintf("chunk_%d", chunkIndex)))
+}
+
+// ReadSharedChunk reads a chunk of a shared file, from the chunk store if the
+// file is kept there
+func (s *LocalStorage) ReadSharedChunk(fileHash string, chunkIndex int) ([]byte, error) {
+	s.mu.RLock()
+	shared, exists := s.sharedFiles[fileHash]
+	store := s.chunks
+	s.mu.RUnlock()
+	if !exists {
+		return nil, ErrFileNotShared
+	}
+
+	if !shared.Stored {
+		return chunker.ReadChunkOf(shared.FilePath, shared.Metadata, chunkIndex)
+	}
+	if store == nil {
+		return nil, ErrChunkStoreOff
+	}
+	if chunkIndex < 0 || chunkIndex >= len(shared.Metadata.Chunks) {
+		return nil, fmt.Errorf("chunk index %d out of range", chunkIndex)
+	}
+	return store.Get(shared.Metadata.Chunks[chunkIndex].Hash)
+}
+
+// ExportFile writes the content of a shared file to path, e.g. to open a file
+// kept in the chunk store. The file is written to a temp file first and only
+// renamed to path once complete.
+func (s *LocalStorage) ExportFile(fileHash, path string) error {
+	s.mu.RLock()
+	shared, exists := s.sharedFiles[fileHash]
+	s.mu.RUnlock()
+	if !exists {
+		return ErrFileNotShared
+	}
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for i := range shared.Metadata.Chunks {
+		data, err := s.ReadSharedChunk(fileHash, i)
+		if err == nil {
+			_, err = out.Write(data)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadReceivedChunk reads a chunk of a download in progress, for peers that
+// learned from the tracker that this peer has it. It also returns the chunk's
+// hash from the download metadata.
+func (s *LocalStorage) ReadReceivedChunk(fileHash string, chunkIndex int) ([]byte, string, error) {
+	s.mu.RLock()
+	state, exists := s.downloads[fileHash]
+	if !exists {
+		s.mu.RUnlock()
+		return nil, "", ErrDownloadNotFound
+	}
+	if chunkIndex < 0 || chunkIndex >= len(state.ChunksReceived) || !state.ChunksReceived[chunkIndex] {
+		s.mu.RUnlock()
+		return nil, "", ErrChunkNotReceived
+	}
+	chunkHash := state.Metadata.Chunks[chunkIndex].Hash
+	s.mu.RUnlock()
+
+	data, err := s.ReadDownloadChunk(fileHash, chunkIndex)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, chunkHash, nil
+}
+
+// ReuseLocalChunks marks every missing chunk of a download that is already
+// available locally - in the chunk store or inside a shared file - as received,
+// so it does not have to be downloaded. It returns the number of chunks reused.
+// Only downloads using the chunk store are deduplicated.
+func (s *LocalStorage) ReuseLocalChunks(fileHash string) int {
+	type missingChunk struct {
+		index int
+		hash  string
+	}
+
+	s.mu.Lock()
+	state, exists := s.downloads[fileHash]
+	if !exists || !state.ChunkStore || s.chunks == nil {
+		s.mu.Unlock()
+		return 0
+	}
+	var missing []missingChunk
+	for i, received := range state.ChunksReceived {
+		if !received && i < len(state.Metadata.Chunks) {
+			missing = append(missing, missingChunk{i, state.Metadata.Chunks[i].Hash})
+		}
+	}
+	if s.chunkIndex == nil {
+		s.buildChunkIndexUnsafe()
+	}
+	index := s.chunkIndex
+	store := s.chunks
+	s.mu.Unlock()
+
+	var reused []int
+	for _, chunk := range missing {
+		if store.AddRef(chunk.hash, fileHash) {
+			reused = append(reused, chunk.index)
+			continue
+		}
+		loc, ok := index[chunk.hash]
+		if !ok {
+			continue
+		}
+		data, err := readChunkAt(loc)
+		if err != nil {
+			continue
+		}
+		if err := store.Put(chunk.hash, data, fileHash); err == nil {
+			reused = append(reused, chunk.index)
+		}
+	}
+
+	if len(reused) > 0 {
+		s.mu.Lock()
+		for _, i := range reused {
+			state.ChunksReceived[i] = true
+		}
+		s.saveStateUnsafe()
+		s.mu.Unlock()
+	}
+	return len(reused)
+}
+
+// releaseChunksUnsafe drops the chunk references held by a download or stored
+// file and deletes the chunks nothing else uses (caller must hold lock). It
+// returns the number of bytes freed.
+func (s *LocalStorage) releaseChunksUnsafe(fileHash string) int64 {
+	if s.chunks == nil {
+		return 0
+	}
+	_, freed := s.chunks.Drop(fileHash)
+	return freed
+}
+
+// buildChunkIndexUnsafe maps chunk hashes to their location in shared files (caller must hold lock)
+func (s *LocalStorage) buildChunkIndexUnsafe() {
+	s.chunkIndex = make(map[string]chunkLocation)
+	for _, shared := range s.sharedFiles {
+		if shared.Stored {
+			// Its chunks are in the store already
+			continue
+		}
+		var offset int64
+		for _, chunk := range shared.Metadata.Chunks {
+			if _, exists := s.chunkIndex[chunk.Hash]; !exists {
+				s.chunkIndex[chunk.Hash] = chunkLocation{path: shared.FilePath, offset: offset, size: chunk.Size}
+			}
+			offset += chunk.Size
+		}
+	}
+}
+
+// readChunkAt reads a chunk out of a shared file
+func readChunkAt(loc chunkLocation) ([]byte, error) {
+	f, err := os.Open(loc.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, loc.size)
+	if _, err := f.ReadAt(data, loc.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
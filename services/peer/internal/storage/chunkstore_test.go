@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+// chunkedMetadata builds metadata for a file made of the given chunks
+func chunkedMetadata(name string, chunks ...[]byte) (*protocol.FileMetadata, []byte) {
+	var content []byte
+	meta := &protocol.FileMetadata{Name: name}
+	for i, c := range chunks {
+		meta.Chunks = append(meta.Chunks, protocol.ChunkInfo{Index: i, Hash: hash.Calculate(c), Size: int64(len(c))})
+		content = append(content, c...)
+	}
+	meta.Size = int64(len(content))
+	meta.Hash = hash.Calculate(content)
+	return meta, content
+}
+
+func TestChunkStore_RefCountAndGC(t *testing.T) {
+	cs, err := NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewChunkStore failed: %v", err)
+	}
+
+	data := []byte("shared chunk")
+	chunkHash := hash.Calculate(data)
+
+	if err := cs.Put(chunkHash, []byte("wrong data"), "file1"); err == nil {
+		t.Error("Put should reject data that does not match the hash")
+	}
+
+	cs.Put(chunkHash, data, "file1")
+	cs.Put(chunkHash, data, "file2")
+	if n := cs.RefCount(chunkHash); n != 2 {
+		t.Errorf("Expected 2 references, got %d", n)
+	}
+	if stats := cs.Stats(); stats.Chunks != 1 || stats.Bytes != int64(len(data)) {
+		t.Errorf("Expected one stored copy, got %+v", stats)
+	}
+
+	cs.Release("file1")
+	if removed, _ := cs.GC(); removed != 0 {
+		t.Error("GC removed a chunk that is still referenced")
+	}
+	got, err := cs.Get(chunkHash)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+
+	cs.Release("file2")
+	if removed, freed := cs.GC(); removed != 1 || freed != int64(len(data)) {
+		t.Errorf("GC = (%d, %d), want (1, %d)", removed, freed, len(data))
+	}
+	if cs.Has(chunkHash) {
+		t.Error("Unreferenced chunk should be collected")
+	}
+
+	cs.Put(chunkHash, data, "file1")
+	cs.Put(chunkHash, data, "file2")
+	if removed, _ := cs.Drop("file1"); removed != 0 || !cs.Has(chunkHash) {
+		t.Error("Drop removed a chunk that is still referenced")
+	}
+	if removed, freed := cs.Drop("file2"); removed != 1 || freed != int64(len(data)) || cs.Has(chunkHash) {
+		t.Errorf("Drop = (%d, %d), want (1, %d)", removed, freed, len(data))
+	}
+	if stats := cs.Stats(); stats.Chunks != 0 || stats.Bytes != 0 || cs.Bytes() != 0 {
+		t.Errorf("Expected an empty store, got %+v", stats)
+	}
+}
+
+func TestChunkStore_CountsOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	cs, _ := NewChunkStore(dir)
+	data := []byte("counted chunk")
+	cs.Put(hash.Calculate(data), data, "file1")
+
+	// Stats come from counters, not from the disk
+	os.WriteFile(filepath.Join(dir, "stray"), make([]byte, 100), 0644)
+	if stats := cs.Stats(); stats.Chunks != 1 || stats.Bytes != int64(len(data)) {
+		t.Errorf("Expected the disk not walked by Stats, got %+v", stats)
+	}
+
+	// Reopening counts what is on disk
+	reopened, _ := NewChunkStore(dir)
+	if stats := reopened.Stats(); stats.Chunks != 2 || stats.Bytes != int64(len(data))+100 {
+		t.Errorf("Expected the store counted on open, got %+v", stats)
+	}
+}
+
+func TestLocalStorage_ReuseLocalChunks(t *testing.T) {
+	ls, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if err := ls.EnableChunkStore(); err != nil {
+		t.Fatalf("EnableChunkStore failed: %v", err)
+	}
+
+	a, b, c, d := []byte("chunk-a"), []byte("chunk-b"), []byte("chunk-c"), []byte("chunk-d")
+
+	// v1 is shared from disk
+	v1, content := chunkedMetadata("v1.bin", a, b, c)
+	v1Path := filepath.Join(t.TempDir(), "v1.bin")
+	os.WriteFile(v1Path, content, 0644)
+	ls.AddSharedFile(v1, v1Path)
+
+	// v2 is partly downloaded and holds chunk d in the store
+	v2, _ := chunkedMetadata("v2.bin", a, d)
+	if _, err := ls.StartDownload(v2); err != nil {
+		t.Fatalf("StartDownload(v2) failed: %v", err)
+	}
+	ls.SaveChunk(v2.Hash, 1, d)
+	ls.MarkChunkReceived(v2.Hash, 1)
+
+	// v3 shares a and b with v1 and d with v2; only e is new
+	v3, _ := chunkedMetadata("v3.bin", a, b, d, []byte("chunk-e"))
+	if _, err := ls.StartDownload(v3); err != nil {
+		t.Fatalf("StartDownload(v3) failed: %v", err)
+	}
+
+	if reused := ls.ReuseLocalChunks(v3.Hash); reused != 3 {
+		t.Errorf("Expected 3 chunks reused, got %d", reused)
+	}
+	if missing := ls.GetMissingChunks(v3.Hash); len(missing) != 1 || missing[0] != 3 {
+		t.Errorf("Expected only chunk 3 missing, got %v", missing)
+	}
+
+	data, err := ls.ReadDownloadChunk(v3.Hash, 1)
+	if err != nil || !bytes.Equal(data, b) {
+		t.Errorf("ReadDownloadChunk = %q, %v; want %q", data, err, b)
+	}
+
+	// Chunk d is stored once and referenced by both downloads
+	store := ls.ChunkStore()
+	if n := store.RefCount(v3.Chunks[2].Hash); n != 2 {
+		t.Errorf("Expected 2 references to chunk d, got %d", n)
+	}
+
+	// Cancelling v2 keeps d for v3
+	ls.CancelDownload(v2.Hash)
+	if !store.Has(v3.Chunks[2].Hash) {
+		t.Error("Chunk still referenced by v3 was collected")
+	}
+
+	// Completing v3 keeps its chunks for sharing it from the store
+	for _, i := range ls.GetMissingChunks(v3.Hash) {
+		ls.SaveChunk(v3.Hash, i, []byte("chunk-e"))
+		ls.MarkChunkReceived(v3.Hash, i)
+	}
+	ls.AddStoredFile(v3)
+	ls.CompleteDownload(v3.Hash)
+	if stats := store.Stats(); stats.Chunks != 4 || stats.References != 4 {
+		t.Errorf("Expected the 4 chunks of v3 kept after completion, got %+v", stats)
+	}
+	data, err = ls.ReadSharedChunk(v3.Hash, 3)
+	if err != nil || string(data) != "chunk-e" {
+		t.Errorf("ReadSharedChunk = %q, %v", data, err)
+	}
+
+	// Unsharing v3 deletes its chunks, and nothing else
+	ls.RemoveSharedFile(v3.Hash)
+	if stats := store.Stats(); stats.Chunks != 0 {
+		t.Errorf("Expected empty chunk store after unsharing, got %+v", stats)
+	}
+	if _, ok := ls.GetDownload(v3.Hash); ok {
+		t.Error("Download of an unshared stored file should be removed")
+	}
+	if _, err := os.Stat(v1Path); err != nil {
+		t.Errorf("File shared from disk was touched: %v", err)
+	}
+}
+
+func TestLocalStorage_StoredFile(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+	ls.EnableChunkStore()
+
+	a, b := []byte("first"), []byte("second")
+	meta, content := chunkedMetadata("file.bin", a, b)
+	state, _ := ls.StartDownload(meta)
+	if state.OutputPath != "" {
+		t.Errorf("OutputPath = %s, want none for a download in the chunk store", state.OutputPath)
+	}
+	for i, c := range [][]byte{a, b} {
+		ls.SaveChunk(meta.Hash, i, c)
+		ls.MarkChunkReceived(meta.Hash, i)
+	}
+	ls.AddStoredFile(meta)
+	ls.CompleteDownload(meta.Hash)
+
+	// Restarting rebuilds the references of the completed download
+	restarted, _ := NewLocalStorage(dir)
+	if err := restarted.EnableChunkStore(); err != nil {
+		t.Fatalf("EnableChunkStore failed: %v", err)
+	}
+	if n := restarted.ChunkStore().RefCount(meta.Chunks[1].Hash); n != 1 {
+		t.Errorf("Expected the reference of the stored file rebuilt, got %d", n)
+	}
+
+	out := filepath.Join(t.TempDir(), "file.bin")
+	if err := restarted.ExportFile(meta.Hash, out); err != nil {
+		t.Fatalf("ExportFile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, content) {
+		t.Errorf("Exported %q, want %q", got, content)
+	}
+
+	// A lost chunk pauses the download and stops sharing the file
+	os.Remove(restarted.ChunkStore().path(meta.Chunks[0].Hash))
+	again, _ := NewLocalStorage(dir)
+	again.EnableChunkStore()
+	if _, ok := again.GetSharedFile(meta.Hash); ok {
+		t.Error("Stored file with a lost chunk should not be shared")
+	}
+	if state, _ := again.GetDownload(meta.Hash); state.Status != StatusPaused {
+		t.Errorf("Status = %s, want paused", state.Status)
+	}
+	if missing := again.GetMissingChunks(meta.Hash); len(missing) != 1 || missing[0] != 0 {
+		t.Errorf("Expected only chunk 0 missing, got %v", missing)
+	}
+}
+
+func TestLocalStorage_ChunkStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+	ls.EnableChunkStore()
+
+	a, b := []byte("first"), []byte("second")
+	meta, _ := chunkedMetadata("file.bin", a, b)
+	ls.StartDownload(meta)
+	ls.SaveChunk(meta.Hash, 0, a)
+	ls.MarkChunkReceived(meta.Hash, 0)
+	ls.SaveState()
+
+	// Leftover from an interrupted write
+	orphan := []byte("orphan")
+	ls.ChunkStore().Put(hash.Calculate(orphan), orphan, "gone")
+	ls.ChunkStore().Release("gone")
+
+	restarted, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if err := restarted.EnableChunkStore(); err != nil {
+		t.Fatalf("EnableChunkStore failed: %v", err)
+	}
+
+	store := restarted.ChunkStore()
+	if store.RefCount(meta.Chunks[0].Hash) != 1 {
+		t.Error("Reference of the paused download should be rebuilt")
+	}
+	if store.Has(hash.Calculate(orphan)) {
+		t.Error("Orphaned chunk should be collected on startup")
+	}
+	if missing := restarted.GetMissingChunks(meta.Hash); len(missing) != 1 || missing[0] != 1 {
+		t.Errorf("Expected only chunk 1 missing, got %v", missing)
+	}
+}
+
+func TestLocalStorage_ReadReceivedChunk(t *testing.T) {
+	ls, _ := NewLocalStorage(t.TempDir())
+	a, b, c := []byte("first"), []byte("second"), []byte("third")
+	meta, _ := chunkedMetadata("file.bin", a, b, c)
+	ls.StartDownload(meta)
+	ls.SaveChunk(meta.Hash, 1, b)
+	ls.MarkChunkReceived(meta.Hash, 1)
+
+	if received, _ := ls.ReceivedChunks(meta.Hash); received.String() != "1" {
+		t.Errorf("ReceivedChunks = %s, want 1", received)
+	}
+	data, chunkHash, err := ls.ReadReceivedChunk(meta.Hash, 1)
+	if err != nil || !bytes.Equal(data, b) || chunkHash != meta.Chunks[1].Hash {
+		t.Errorf("ReadReceivedChunk(1) = %q, %s, %v", data, chunkHash, err)
+	}
+	if _, _, err := ls.ReadReceivedChunk(meta.Hash, 0); err != ErrChunkNotReceived {
+		t.Errorf("ReadReceivedChunk(0) error = %v, want ErrChunkNotReceived", err)
+	}
+	if _, _, err := ls.ReadReceivedChunk("unknown", 0); err != ErrDownloadNotFound {
+		t.Errorf("ReadReceivedChunk of an unknown file error = %v, want ErrDownloadNotFound", err)
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startTestDownload starts a temp-dir download of chunks and returns its metadata
+func startTestDownload(t *testing.T, ls *LocalStorage, chunks ...[]byte) *DownloadState {
+	t.Helper()
+	meta, _ := chunkedMetadata("file.bin", chunks...)
+	state, err := ls.StartDownload(meta)
+	if err != nil {
+		t.Fatalf("StartDownload failed: %v", err)
+	}
+	return state
+}
+
+func writeTestChunk(t *testing.T, state *DownloadState, index int, data []byte) {
+	t.Helper()
+	path := filepath.Join(state.TempDir, fmt.Sprintf("chunk_%d", index))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournal_RecoversProgressWithoutSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+
+	chunks := [][]byte{[]byte("zero"), []byte("one"), []byte("two")}
+	state := startTestDownload(t, ls, chunks...)
+	for i := 0; i < 2; i++ {
+		writeTestChunk(t, state, i, chunks[i])
+		ls.MarkChunkReceived(state.Metadata.Hash, i)
+	}
+
+	// MarkChunkReceived only appends to the journal
+	snapshot, _ := os.ReadFile(filepath.Join(dir, "state.json"))
+	if bytes.Contains(snapshot, []byte("true")) {
+		t.Error("Snapshot should not be rewritten for each chunk")
+	}
+
+	// Simulate a crash: reopen without SaveState
+	restarted, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if missing := restarted.GetMissingChunks(state.Metadata.Hash); len(missing) != 1 || missing[0] != 2 {
+		t.Errorf("Expected only chunk 2 missing after recovery, got %v", missing)
+	}
+
+	// Recovery writes a fresh snapshot and empties the journal
+	if info, err := os.Stat(filepath.Join(dir, "state.journal")); err == nil && info.Size() != 0 {
+		t.Errorf("Journal should be truncated after recovery, size %d", info.Size())
+	}
+}
+
+func TestJournal_TornLineIgnored(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+
+	chunks := [][]byte{[]byte("zero"), []byte("one")}
+	state := startTestDownload(t, ls, chunks...)
+	writeTestChunk(t, state, 0, chunks[0])
+	ls.MarkChunkReceived(state.Metadata.Hash, 0)
+
+	// Crash in the middle of appending the next entry
+	f, _ := os.OpenFile(filepath.Join(dir, "state.journal"), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"op":"chunk","hash":"` + state.Metadata.Hash[:10])
+	f.Close()
+
+	restarted, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed with torn journal: %v", err)
+	}
+	if missing := restarted.GetMissingChunks(state.Metadata.Hash); len(missing) != 1 || missing[0] != 1 {
+		t.Errorf("Expected only chunk 1 missing, got %v", missing)
+	}
+}
+
+func TestJournal_BatchedSync(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+
+	chunks := make([][]byte, journalSyncBatch+2)
+	for i := range chunks {
+		chunks[i] = []byte(fmt.Sprintf("chunk %d", i))
+	}
+	state := startTestDownload(t, ls, chunks...)
+	ls.MarkChunkReceived(state.Metadata.Hash, 0)
+
+	ls.mu.Lock()
+	journal, unsynced, timer := ls.journal, ls.journalUnsynced, ls.journalTimer
+	ls.mu.Unlock()
+	if journal == nil || unsynced != 1 || timer == nil {
+		t.Fatalf("Expected an open journal with 1 entry to sync later, got %v %d %v", journal, unsynced, timer)
+	}
+
+	// A full batch is synced at once, on the same file
+	for i := 1; i <= journalSyncBatch; i++ {
+		ls.MarkChunkReceived(state.Metadata.Hash, i)
+	}
+	ls.mu.Lock()
+	if ls.journal != journal || ls.journalUnsynced != 1 {
+		t.Errorf("Expected the batch synced on the open journal, %d entries unsynced", ls.journalUnsynced)
+	}
+	ls.mu.Unlock()
+
+	ls.Close()
+	ls.mu.Lock()
+	if ls.journal != nil || ls.journalTimer != nil {
+		t.Error("Expected Close to close the journal and stop its sync")
+	}
+	ls.mu.Unlock()
+
+	// Entries after a snapshot start the emptied journal over
+	ls.SaveState()
+	ls.MarkChunkReceived(state.Metadata.Hash, journalSyncBatch+1)
+	ls.Close()
+	data, _ := os.ReadFile(filepath.Join(dir, "state.journal"))
+	if lines := bytes.Count(data, []byte("\n")); lines != 1 {
+		t.Errorf("Expected 1 journal entry after the snapshot, got %d", lines)
+	}
+}
+
+func TestRecovery_VerifiesTempChunks(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+
+	chunks := [][]byte{[]byte("zero"), []byte("one"), []byte("two")}
+	state := startTestDownload(t, ls, chunks...)
+
+	// Chunk 0: intact. Chunk 1: marked but corrupted on disk.
+	// Chunk 2: written to disk but the power went out before it was recorded.
+	writeTestChunk(t, state, 0, chunks[0])
+	ls.MarkChunkReceived(state.Metadata.Hash, 0)
+	writeTestChunk(t, state, 1, []byte("on"))
+	ls.MarkChunkReceived(state.Metadata.Hash, 1)
+	ls.SaveState()
+	writeTestChunk(t, state, 2, chunks[2])
+
+	restarted, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if missing := restarted.GetMissingChunks(state.Metadata.Hash); len(missing) != 1 || missing[0] != 1 {
+		t.Errorf("Expected only corrupt chunk 1 missing, got %v", missing)
+	}
+	if _, err := os.Stat(filepath.Join(state.TempDir, "chunk_1")); !os.IsNotExist(err) {
+		t.Error("Corrupt chunk should be deleted")
+	}
+}
+
+func TestSaveState_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	ls, _ := NewLocalStorage(dir)
+	startTestDownload(t, ls, []byte("data"))
+
+	// A leftover temp file from an interrupted save must not affect loading
+	os.WriteFile(filepath.Join(dir, "state.json.tmp"), []byte(`{"downloads": {`), 0644)
+
+	if err := ls.SaveState(); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state.json.tmp")); !os.IsNotExist(err) {
+		t.Error("Temp file should be replaced by the snapshot")
+	}
+
+	restarted, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if len(restarted.ListDownloads()) != 1 {
+		t.Errorf("Expected 1 download after reload, got %d", len(restarted.ListDownloads()))
+	}
+}
@@ -2,9 +2,15 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
@@ -20,6 +26,7 @@ const (
 	StatusCompleted DownloadStatus = "completed"
 	StatusFailed    DownloadStatus = "failed"
 	StatusCancelled DownloadStatus = "cancelled"
+	StatusNoSpace   DownloadStatus = "no_space" // Refused or stopped because the disk or quota is full
 )
 
 // LocalStorage manages local file storage for a peer
@@ -29,12 +36,26 @@ type LocalStorage struct {
 	sharedFiles map[string]*SharedFile    // fileHash -> SharedFile
 	downloads   map[string]*DownloadState // fileHash -> DownloadState
 	stateFile   string
+	journalFile string // Chunks received since the last state snapshot
+	quota       QuotaConfig
+	freeSpace   func(path string) (int64, error)
+	chunks      *ChunkStore               // nil unless EnableChunkStore was called
+	chunkIndex  map[string]chunkLocation  // chunkHash -> location in a shared file, rebuilt lazily
+	transfers   map[string]*transferCount // fileHash -> bytes transferred since this peer started
+	onEvict     func(hashes []string)     // Told of downloads evicted to make room, see SetEvictionHandler
+	used        atomic.Int64              // Bytes in downloads and temp, counted on load and updated as files are written and deleted
+
+	journal         *os.File    // Open journal, nil until the first entry
+	journalEntries  int         // Entries in the journal since the last snapshot
+	journalUnsynced int         // Entries written since the last fsync of the journal
+	journalTimer    *time.Timer // Pending fsync of the journal, nil if none
 }
 
 // SharedFile represents a file being shared by this peer
 type SharedFile struct {
 	Metadata *protocol.FileMetadata `json:"metadata"`
 	FilePath string                 `json:"file_path"`
+	Stored   bool                   `json:"stored,omitempty"` // Content is kept in the chunk store; FilePath is empty
 }
 
 // DownloadState tracks the progress of a file download
@@ -51,6 +72,10 @@ type DownloadState struct {
 	TotalBytes      int64                  `json:"total_bytes"`
 	LastError       string                 `json:"last_error,omitempty"`
 	RetryCount      int                    `json:"retry_count"`
+	RequiredBytes   int64                  `json:"required_bytes,omitempty"` // Space still needed, set when Status is no_space
+	ChunkStore      bool                   `json:"chunk_store,omitempty"`    // Chunks are kept in the shared chunk store, not TempDir
+	FromMetaFile    bool                   `json:"from_meta_file,omitempty"` // Metadata came from a .p2pmeta file and is trusted over the tracker's
+	WebSeeds        []string               `json:"web_seeds,omitempty"`      // HTTP(S) URLs of the whole file, from the .p2pmeta file
 }
 
 // NewLocalStorage creates a new local storage manager
@@ -73,6 +98,10 @@ func NewLocalStorage(baseDir string) (*LocalStorage, error) {
 		sharedFiles: make(map[string]*SharedFile),
 		downloads:   make(map[string]*DownloadState),
 		stateFile:   filepath.Join(baseDir, "state.json"),
+		journalFile: filepath.Join(baseDir, "state.journal"),
+		quota:       QuotaConfig{Eviction: EvictNone},
+		freeSpace:   freeDiskSpace,
+		transfers:   make(map[string]*transferCount),
 	}
 
 	// Try to load existing state
@@ -83,6 +112,15 @@ func NewLocalStorage(baseDir string) (*LocalStorage, error) {
 		}
 	}
 
+	// Check temp chunks against the recovered state and write a fresh snapshot
+	storage.mu.Lock()
+	if storage.verifyDownloadsUnsafe() > 0 || storage.journalEntries > 0 {
+		storage.saveStateUnsafe()
+	}
+	// The only walk of the data directories; usage is tracked from here on
+	storage.used.Store(dirSize(filepath.Join(baseDir, "downloads")) + dirSize(filepath.Join(baseDir, "temp")))
+	storage.mu.Unlock()
+
 	return storage, nil
 }
 
@@ -90,11 +128,31 @@ func NewLocalStorage(baseDir string) (*LocalStorage, error) {
 func (s *LocalStorage) AddSharedFile(metadata *protocol.FileMetadata, filePath string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.addSharedFileUnsafe(&SharedFile{Metadata: metadata, FilePath: filePath})
+}
+
+// AddStoredFile shares a completed download whose chunks are kept in the
+// chunk store, without an assembled copy on disk
+func (s *LocalStorage) AddStoredFile(metadata *protocol.FileMetadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addSharedFileUnsafe(&SharedFile{Metadata: metadata, Stored: true})
+}
 
-	s.sharedFiles[metadata.Hash] = &SharedFile{
-		Metadata: metadata,
-		FilePath: filePath,
+// addSharedFileUnsafe adds or replaces a shared file (caller must hold lock)
+func (s *LocalStorage) addSharedFileUnsafe(shared *SharedFile) {
+	metadata := shared.Metadata
+	// Sharing the same content again keeps the info hashes of the torrent it
+	// was imported from, and its visibility
+	existing, ok := s.sharedFiles[metadata.Hash]
+	if ok && metadata.BTInfoHash == "" && metadata.BTInfoHashV2 == "" {
+		metadata.BTInfoHash, metadata.BTInfoHashV2 = existing.Metadata.BTInfoHash, existing.Metadata.BTInfoHashV2
 	}
+	if ok && metadata.Visibility == "" {
+		metadata.Visibility = existing.Metadata.Visibility
+	}
+	s.sharedFiles[metadata.Hash] = shared
+	s.chunkIndex = nil
 }
 
 // GetSharedFile retrieves a shared file by hash
@@ -106,6 +164,73 @@ func (s *LocalStorage) GetSharedFile(hash string) (*SharedFile, bool) {
 	return file, exists
 }
 
+// RemoveSharedFile stops sharing a file. The file itself is left on disk, but a
+// file kept in the chunk store is deleted with its completed download, as
+// nothing else holds its content.
+func (s *LocalStorage) RemoveSharedFile(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shared, exists := s.sharedFiles[hash]
+	if !exists {
+		return
+	}
+	delete(s.sharedFiles, hash)
+	s.chunkIndex = nil
+	if shared.Stored {
+		if state, ok := s.downloads[hash]; !ok || state.Status == StatusCompleted {
+			delete(s.downloads, hash)
+			s.releaseChunksUnsafe(hash)
+		}
+	}
+	s.saveStateUnsafe()
+}
+
+// ListSharedFiles returns all shared files
+func (s *LocalStorage) ListSharedFiles() []*SharedFile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	files := make([]*SharedFile, 0, len(s.sharedFiles))
+	for _, shared := range s.sharedFiles {
+		files = append(files, shared)
+	}
+	return files
+}
+
+// Visibility returns the visibility of a shared file or download, empty for
+// an unknown file
+func (s *LocalStorage) Visibility(fileHash string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if shared, ok := s.sharedFiles[fileHash]; ok {
+		return shared.Metadata.Visibility
+	}
+	if state, ok := s.downloads[fileHash]; ok {
+		return state.Metadata.Visibility
+	}
+	return ""
+}
+
+// SetVisibility sets the visibility of a shared file or download, which
+// decides whether its chunks are served to peers without a grant
+func (s *LocalStorage) SetVisibility(fileHash, visibility string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Metadata is replaced, not modified, as it may be in use
+	if shared, ok := s.sharedFiles[fileHash]; ok && shared.Metadata.Visibility != visibility {
+		metadata := *shared.Metadata
+		metadata.Visibility = visibility
+		s.sharedFiles[fileHash] = &SharedFile{Metadata: &metadata, FilePath: shared.FilePath, Stored: shared.Stored}
+	}
+	if state, ok := s.downloads[fileHash]; ok && state.Metadata.Visibility != visibility {
+		metadata := *state.Metadata
+		metadata.Visibility = visibility
+		state.Metadata = &metadata
+	}
+	s.saveStateUnsafe()
+}
+
 // GetAllSharedHashes returns all shared file hashes
 func (s *LocalStorage) GetAllSharedHashes() []string {
 	s.mu.RLock()
@@ -131,20 +256,34 @@ func (s *LocalStorage) IsFileShared(filePath string) bool {
 	return false
 }
 
-// StartDownload initializes a new download or resumes an existing one
-func (s *LocalStorage) StartDownload(metadata *protocol.FileMetadata) *DownloadState {
+// StartDownload initializes a new download or resumes an existing one.
+// It refuses downloads that would not fit on disk or within the storage quota;
+// the refused download is kept with StatusNoSpace so it can be resumed later.
+func (s *LocalStorage) StartDownload(metadata *protocol.FileMetadata) (*DownloadState, error) {
+	var evicted []string
+	defer func() { s.notifyEvicted(evicted) }()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// Check if download already exists (resume scenario)
 	if existing, exists := s.downloads[metadata.Hash]; exists {
-		if existing.Status == StatusPaused || existing.Status == StatusFailed {
+		if existing.Status == StatusActive {
+			return existing, nil
+		}
+		if existing.Status == StatusPaused || existing.Status == StatusFailed || existing.Status == StatusNoSpace {
+			if existing.ChunkStore && s.chunks == nil {
+				// Chunk store was turned off: start over in the temp directory
+				existing.ChunkStore = false
+				existing.ChunksReceived = make([]bool, len(existing.Metadata.Chunks))
+				existing.OutputPath = filepath.Join(s.baseDir, "downloads", existing.Metadata.Name)
+			}
+			var err error
+			if evicted, err = s.checkSpaceUnsafe(existing); err != nil {
+				return existing, err
+			}
 			existing.Status = StatusActive
 			existing.PausedAt = nil
-			return existing
-		}
-		if existing.Status == StatusActive {
-			return existing
+			return existing, nil
 		}
 	}
 
@@ -152,15 +291,77 @@ func (s *LocalStorage) StartDownload(metadata *protocol.FileMetadata) *DownloadS
 		Metadata:       metadata,
 		ChunksReceived: make([]bool, len(metadata.Chunks)),
 		TempDir:        filepath.Join(s.baseDir, "temp", metadata.Hash),
-		OutputPath:     filepath.Join(s.baseDir, "downloads", metadata.Name),
 		Status:         StatusActive,
 		StartedAt:      time.Now(),
 		TotalBytes:     metadata.Size,
+		ChunkStore:     s.chunks != nil,
+	}
+	if !state.ChunkStore {
+		// With the chunk store the file is shared from the store, see AddStoredFile
+		state.OutputPath = filepath.Join(s.baseDir, "downloads", metadata.Name)
 	}
-
-	os.MkdirAll(state.TempDir, 0755)
 	s.downloads[metadata.Hash] = state
-	return state
+
+	var err error
+	if evicted, err = s.checkSpaceUnsafe(state); err != nil {
+		return state, err
+	}
+
+	if !state.ChunkStore {
+		os.MkdirAll(state.TempDir, 0755)
+	}
+	s.saveStateUnsafe()
+	return state, nil
+}
+
+// PrepareDownload starts a download whose metadata is already known from a
+// .p2pmeta file, so it does not depend on the tracker's copy. An existing
+// download of the file keeps its progress and gains the web seeds.
+func (s *LocalStorage) PrepareDownload(metadata *protocol.FileMetadata, webSeeds []string) (*DownloadState, error) {
+	state, err := s.StartDownload(metadata)
+	if state == nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.FromMetaFile = true
+	for _, seed := range webSeeds {
+		if !slices.Contains(state.WebSeeds, seed) {
+			state.WebSeeds = append(state.WebSeeds, seed)
+		}
+	}
+	s.saveStateUnsafe()
+	return state, err
+}
+
+// checkSpaceUnsafe runs the space preflight for state and records a refusal on it
+// (caller must hold lock). It returns the hashes of the downloads evicted.
+func (s *LocalStorage) checkSpaceUnsafe(state *DownloadState) ([]string, error) {
+	var received int64
+	for i, ok := range state.ChunksReceived {
+		if ok && i < len(state.Metadata.Chunks) {
+			received += state.Metadata.Chunks[i].Size
+		}
+	}
+
+	// Remaining chunks land in temp or the chunk store; without the chunk store
+	// the assembled copy is then written to downloads
+	required := state.TotalBytes - received
+	if !state.ChunkStore {
+		required += state.TotalBytes
+	}
+
+	evicted, err := s.preflightUnsafe(state.Metadata.Hash, required)
+	if err != nil {
+		state.Status = StatusNoSpace
+		state.LastError = err.Error()
+		state.RequiredBytes = required
+		s.saveStateUnsafe()
+		return evicted, err
+	}
+	state.RequiredBytes = 0
+	return evicted, nil
 }
 
 // GetDownload retrieves download state by file hash
@@ -172,15 +373,28 @@ func (s *LocalStorage) GetDownload(hash string) (*DownloadState, bool) {
 	return state, exists
 }
 
-// MarkChunkReceived marks a chunk as received
+// MarkChunkReceived marks a chunk as received.
+// The change is appended to the state journal rather than rewriting the snapshot.
 func (s *LocalStorage) MarkChunkReceived(fileHash string, chunkIndex int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if state, exists := s.downloads[fileHash]; exists {
-		if chunkIndex < len(state.ChunksReceived) {
-			state.ChunksReceived[chunkIndex] = true
-		}
+	state, exists := s.downloads[fileHash]
+	if !exists || chunkIndex < 0 || chunkIndex >= len(state.ChunksReceived) || state.ChunksReceived[chunkIndex] {
+		return
+	}
+	state.ChunksReceived[chunkIndex] = true
+	if chunkIndex < len(state.Metadata.Chunks) {
+		s.transferUnsafe(fileHash).downloaded += state.Metadata.Chunks[chunkIndex].Size
+	}
+
+	if err := s.appendJournalUnsafe(journalEntry{Op: "chunk", Hash: fileHash, Index: chunkIndex}); err != nil {
+		log.Printf("[Storage] Failed to append to state journal: %v", err)
+		s.saveStateUnsafe()
+		return
+	}
+	if s.journalEntries >= journalCompactThreshold {
+		s.saveStateUnsafe()
 	}
 }
 
@@ -210,6 +424,15 @@ func (s *LocalStorage) SaveState() error {
 	return s.saveStateUnsafe()
 }
 
+// Close syncs and closes the state journal. Progress recorded afterwards
+// reopens it.
+func (s *LocalStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeJournalUnsafe()
+}
+
 // GetMissingChunks returns indices of chunks not yet received
 func (s *LocalStorage) GetMissingChunks(fileHash string) []int {
 	s.mu.RLock()
@@ -229,6 +452,25 @@ func (s *LocalStorage) GetMissingChunks(fileHash string) []int {
 	return missing
 }
 
+// ReceivedChunks returns the chunks of a download received so far
+func (s *LocalStorage) ReceivedChunks(fileHash string) (protocol.ChunkSet, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, exists := s.downloads[fileHash]
+	if !exists {
+		return protocol.ChunkSet{}, false
+	}
+
+	var received protocol.ChunkSet
+	for i, ok := range state.ChunksReceived {
+		if ok {
+			received.Add(i)
+		}
+	}
+	return received, true
+}
+
 // PauseDownload pauses an active download
 func (s *LocalStorage) PauseDownload(fileHash string) error {
 	s.mu.Lock()
@@ -253,6 +495,8 @@ func (s *LocalStorage) PauseDownload(fileHash string) error {
 
 // ResumeDownload resumes a paused download
 func (s *LocalStorage) ResumeDownload(fileHash string) (*DownloadState, error) {
+	var evicted []string
+	defer func() { s.notifyEvicted(evicted) }()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -261,10 +505,15 @@ func (s *LocalStorage) ResumeDownload(fileHash string) (*DownloadState, error) {
 		return nil, ErrDownloadNotFound
 	}
 
-	if state.Status != StatusPaused && state.Status != StatusFailed {
+	if state.Status != StatusPaused && state.Status != StatusFailed && state.Status != StatusNoSpace {
 		return nil, ErrDownloadNotPaused
 	}
 
+	var err error
+	if evicted, err = s.checkSpaceUnsafe(state); err != nil {
+		return state, err
+	}
+
 	state.Status = StatusActive
 	state.PausedAt = nil
 
@@ -284,15 +533,20 @@ func (s *LocalStorage) CancelDownload(fileHash string) error {
 	state.Status = StatusCancelled
 
 	// Clean up temp files
-	if state.TempDir != "" {
-		os.RemoveAll(state.TempDir)
+	s.removeTempUnsafe(state)
+	// A completed download kept in the chunk store is still shared from it
+	if shared, ok := s.sharedFiles[fileHash]; !ok || !shared.Stored {
+		s.releaseChunksUnsafe(fileHash)
 	}
 
 	delete(s.downloads, fileHash)
 	return s.saveStateUnsafe()
 }
 
-// CompleteDownload marks a download as completed
+// CompleteDownload marks a download as completed. Chunks in the chunk store
+// stay referenced until the file is unshared or evicted. For a download
+// assembled in the downloads directory, the temp chunks are deleted and the
+// assembled file accounted for.
 func (s *LocalStorage) CompleteDownload(fileHash string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -301,6 +555,12 @@ func (s *LocalStorage) CompleteDownload(fileHash string) error {
 	if !exists {
 		return ErrDownloadNotFound
 	}
+	if state.Status != StatusCompleted && !state.ChunkStore {
+		s.removeTempUnsafe(state)
+		if info, err := os.Stat(state.OutputPath); err == nil && s.inDownloadsDir(state.OutputPath) {
+			s.used.Add(info.Size())
+		}
+	}
 
 	now := time.Now()
 	state.Status = StatusCompleted
@@ -309,6 +569,24 @@ func (s *LocalStorage) CompleteDownload(fileHash string) error {
 	return s.saveStateUnsafe()
 }
 
+// removeTempUnsafe deletes the temp chunks of a download (caller must hold lock)
+func (s *LocalStorage) removeTempUnsafe(state *DownloadState) {
+	if state.TempDir == "" {
+		return
+	}
+	size := dirSize(state.TempDir)
+	if err := os.RemoveAll(state.TempDir); err == nil {
+		s.used.Add(-size)
+	}
+}
+
+// inDownloadsDir reports whether path is in the downloads directory, where
+// this storage creates files
+func (s *LocalStorage) inDownloadsDir(path string) bool {
+	rel, err := filepath.Rel(filepath.Join(s.baseDir, "downloads"), path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
 // SetDownloadError sets an error on a download
 func (s *LocalStorage) SetDownloadError(fileHash string, err error) {
 	s.mu.Lock()
@@ -316,6 +594,9 @@ func (s *LocalStorage) SetDownloadError(fileHash string, err error) {
 
 	if state, exists := s.downloads[fileHash]; exists {
 		state.Status = StatusFailed
+		if errors.Is(err, syscall.ENOSPC) {
+			state.Status = StatusNoSpace
+		}
 		state.LastError = err.Error()
 		state.RetryCount++
 		s.saveStateUnsafe()
@@ -405,6 +686,7 @@ func (s *LocalStorage) LoadState() error {
 
 	if data.SharedFiles != nil {
 		s.sharedFiles = data.SharedFiles
+		s.chunkIndex = nil
 	}
 	if data.Downloads != nil {
 		s.downloads = data.Downloads
@@ -418,25 +700,26 @@ func (s *LocalStorage) LoadState() error {
 		}
 	}
 
-	return nil
+	// Apply progress recorded after the snapshot
+	return s.replayJournalUnsafe()
 }
 
-// saveStateUnsafe saves state without acquiring lock (caller must hold lock)
+// saveStateUnsafe writes a new snapshot atomically and truncates the journal it
+// supersedes, without acquiring lock (caller must hold lock)
 func (s *LocalStorage) saveStateUnsafe() error {
-	data := map[string]any{
+	data, err := json.MarshalIndent(map[string]any{
 		"shared_files": s.sharedFiles,
 		"downloads":    s.downloads,
-	}
-
-	file, err := os.Create(s.stateFile)
+	}, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	if err := writeFileAtomic(s.stateFile, append(data, '\n')); err != nil {
+		log.Printf("[Storage] Failed to save state: %v", err)
+		return err
+	}
+	return s.truncateJournalUnsafe()
 }
 
 // Errors
@@ -444,6 +727,9 @@ var (
 	ErrDownloadNotFound  = errDownloadNotFound{}
 	ErrDownloadNotActive = errDownloadNotActive{}
 	ErrDownloadNotPaused = errDownloadNotPaused{}
+	ErrChunkNotReceived  = errChunkNotReceived{}
+	ErrFileNotShared     = errFileNotShared{}
+	ErrChunkStoreOff     = errChunkStoreOff{}
 )
 
 type errDownloadNotFound struct{}
@@ -457,3 +743,17 @@ func (e errDownloadNotActive) Error() string { return "download is not active" }
 type errDownloadNotPaused struct{}
 
 func (e errDownloadNotPaused) Error() string { return "download is not paused" }
+
+type errChunkNotReceived struct{}
+
+func (e errChunkNotReceived) Error() string { return "chunk not received yet" }
+
+type errFileNotShared struct{}
+
+func (e errFileNotShared) Error() string { return "file is not shared" }
+
+type errChunkStoreOff struct{}
+
+func (e errChunkStoreOff) Error() string {
+	return "file is kept in the chunk store, which is not enabled"
+}
@@ -67,7 +67,10 @@ func TestDownloadState(t *testing.T) {
 			},
 		}
 
-		state := ls.StartDownload(metadata)
+		state, err := ls.StartDownload(metadata)
+		if err != nil {
+			t.Fatalf("StartDownload failed: %v", err)
+		}
 
 		if state.Metadata.Hash != "def456" {
 			t.Errorf("Expected hash def456, got %s", state.Metadata.Hash)
@@ -98,6 +101,20 @@ func TestDownloadState(t *testing.T) {
 		}
 	})
 
+	// Received chunks count as downloaded, the others as left
+	t.Run("Transfer", func(t *testing.T) {
+		ls.MarkChunkReceived("def456", 2)
+		ls.RecordUpload("def456", 100)
+
+		want := protocol.Transfer{Uploaded: 100, Downloaded: 1024, Left: 1024}
+		if got := ls.Transfer("def456"); got != want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+		if got := ls.Transfers()["def456"]; got != want {
+			t.Errorf("Expected %+v among all transfers, got %+v", want, got)
+		}
+	})
+
 	// Test GetMissingChunks
 	t.Run("GetMissingChunks", func(t *testing.T) {
 		missing := ls.GetMissingChunks("def456")
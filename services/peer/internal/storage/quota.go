@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EvictionPolicy decides which completed downloads are removed to stay within the quota
+type EvictionPolicy string
+
+const (
+	EvictNone    EvictionPolicy = "none"    // Never delete completed downloads
+	EvictOldest  EvictionPolicy = "oldest"  // Delete the downloads completed longest ago first
+	EvictLargest EvictionPolicy = "largest" // Delete the largest downloads first
+)
+
+// QuotaConfig bounds how much disk space downloads may use
+type QuotaConfig struct {
+	MaxBytes     int64          // Quota for downloads plus temp data (0 = no quota)
+	MinFreeBytes int64          // Free space to always leave on the disk
+	Eviction     EvictionPolicy // What to do with completed downloads when space runs out
+}
+
+// ParseEvictionPolicy validates an eviction policy name
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "", EvictNone:
+		return EvictNone, nil
+	case EvictOldest, EvictLargest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy %q (want none, oldest or largest)", s)
+	}
+}
+
+// ParseSize parses a size such as "500MB", "20GB", "1.5TB" or "1048576" (bytes)
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		value  int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.value
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// SetQuota configures the storage quota and free-space reserve
+func (s *LocalStorage) SetQuota(cfg QuotaConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cfg.Eviction == "" {
+		cfg.Eviction = EvictNone
+	}
+	s.quota = cfg
+}
+
+// GetQuota returns the current quota configuration
+func (s *LocalStorage) GetQuota() QuotaConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.quota
+}
+
+// UsedBytes returns the disk space used by downloads, temp data and the chunk
+// store. It is tracked as the storage writes and deletes files, so files
+// changed by something else are only accounted for on the next start.
+func (s *LocalStorage) UsedBytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.usedBytesUnsafe()
+}
+
+// FreeBytes returns the free disk space on the volume holding the data directory
+func (s *LocalStorage) FreeBytes() (int64, error) {
+	return s.freeSpace(s.baseDir)
+}
+
+// EnforceQuota evicts completed downloads until usage is within the quota.
+// It returns the hashes of the downloads evicted, for the trackers to be told.
+func (s *LocalStorage) EnforceQuota() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quota.MaxBytes <= 0 {
+		return nil
+	}
+	excess := s.usedBytesUnsafe() - s.quota.MaxBytes
+	if excess <= 0 {
+		return nil
+	}
+	evicted, _ := s.evictUnsafe(excess, "")
+	if len(evicted) > 0 {
+		s.saveStateUnsafe()
+	}
+	return evicted
+}
+
+// SetEvictionHandler sets a function called with the hashes of the completed
+// downloads evicted to make room for another download, outside the storage
+// lock. The peer uses it to withdraw them from the trackers.
+func (s *LocalStorage) SetEvictionHandler(handler func(hashes []string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = handler
+}
+
+// notifyEvicted passes evicted hashes to the eviction handler (caller must not
+// hold lock)
+func (s *LocalStorage) notifyEvicted(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+	s.mu.RLock()
+	handler := s.onEvict
+	s.mu.RUnlock()
+	if handler != nil {
+		handler(hashes)
+	}
+}
+
+// preflightUnsafe checks that required more bytes for the download of hash fit
+// on disk and within the quota, evicting completed downloads if the policy
+// allows (caller must hold lock). It returns the hashes of the downloads evicted.
+func (s *LocalStorage) preflightUnsafe(hash string, required int64) (evicted []string, err error) {
+	if s.quota.MaxBytes > 0 {
+		if required > s.quota.MaxBytes {
+			return nil, &SpaceError{Required: required, Available: s.quota.MaxBytes, Quota: true}
+		}
+		excess := s.usedBytesUnsafe() + required - s.quota.MaxBytes
+		if excess > 0 {
+			hashes, freed := s.evictUnsafe(excess, hash)
+			evicted = append(evicted, hashes...)
+			if freed < excess {
+				return evicted, &SpaceError{Required: required, Available: s.quota.MaxBytes - s.usedBytesUnsafe(), Quota: true}
+			}
+		}
+	}
+
+	free, err := s.freeSpace(s.baseDir)
+	if err != nil {
+		// Can't tell; let the download proceed and fail on write if the disk is full
+		log.Printf("[Storage] Could not check free disk space: %v", err)
+		return evicted, nil
+	}
+	shortfall := required + s.quota.MinFreeBytes - free
+	if shortfall > 0 {
+		hashes, freed := s.evictUnsafe(shortfall, hash)
+		evicted = append(evicted, hashes...)
+		if freed < shortfall {
+			available, _ := s.freeSpace(s.baseDir)
+			return evicted, &SpaceError{Required: required, Available: available - s.quota.MinFreeBytes}
+		}
+	}
+
+	return evicted, nil
+}
+
+// evictUnsafe deletes completed downloads according to the eviction policy until at
+// least want bytes are freed (caller must hold lock). Unreferenced chunks are
+// collected from the chunk store first. The download identified by skipHash is
+// never evicted. It returns the hashes of the evicted downloads.
+func (s *LocalStorage) evictUnsafe(want int64, skipHash string) (evicted []string, freed int64) {
+	if s.chunks != nil {
+		removed, collected := s.chunks.GC()
+		if removed > 0 {
+			log.Printf("[Storage] Removed %d unreferenced chunks (%d bytes)", removed, collected)
+		}
+		freed += collected
+	}
+	if freed >= want || s.quota.Eviction == EvictNone || s.quota.Eviction == "" {
+		return nil, freed
+	}
+
+	var candidates []*DownloadState
+	for hash, state := range s.downloads {
+		if hash == skipHash || state.Status != StatusCompleted {
+			continue
+		}
+		candidates = append(candidates, state)
+	}
+
+	switch s.quota.Eviction {
+	case EvictOldest:
+		sort.Slice(candidates, func(i, j int) bool {
+			return completedAt(candidates[i]).Before(completedAt(candidates[j]))
+		})
+	case EvictLargest:
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].TotalBytes > candidates[j].TotalBytes
+		})
+	}
+
+	for _, state := range candidates {
+		if freed >= want {
+			break
+		}
+		hash := state.Metadata.Hash
+		if state.ChunkStore {
+			// Chunks other files still use are kept
+			size := s.releaseChunksUnsafe(hash)
+			delete(s.downloads, hash)
+			if shared, ok := s.sharedFiles[hash]; ok && shared.Stored {
+				delete(s.sharedFiles, hash)
+			}
+			freed += size
+			evicted = append(evicted, hash)
+			log.Printf("[Storage] Evicted %s (%d bytes) to stay within storage limits", state.Metadata.Name, size)
+			continue
+		}
+
+		// Only delete files this storage created
+		if !s.inDownloadsDir(state.OutputPath) {
+			continue
+		}
+
+		size := int64(0)
+		if info, err := os.Stat(state.OutputPath); err == nil {
+			size = info.Size()
+		}
+		if err := os.Remove(state.OutputPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Storage] Failed to evict %s: %v", state.OutputPath, err)
+			continue
+		}
+		s.used.Add(-size)
+
+		delete(s.downloads, hash)
+		if shared, ok := s.sharedFiles[hash]; ok && shared.FilePath == state.OutputPath {
+			delete(s.sharedFiles, hash)
+			s.chunkIndex = nil
+		}
+
+		freed += size
+		evicted = append(evicted, hash)
+		log.Printf("[Storage] Evicted %s (%d bytes) to stay within storage limits", state.Metadata.Name, size)
+	}
+
+	return evicted, freed
+}
+
+// usedBytesUnsafe returns the bytes in the downloads and temp directories and
+// in the chunk store, from the counters kept up to date as they change (caller
+// must hold lock)
+func (s *LocalStorage) usedBytesUnsafe() int64 {
+	total := s.used.Load()
+	if s.chunks != nil {
+		total += s.chunks.Bytes()
+	}
+	return total
+}
+
+// dirSize sums the size of the files under dir. It is only used to load the
+// counters, and for a directory about to be deleted.
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+func completedAt(state *DownloadState) time.Time {
+	if state.CompletedAt != nil {
+		return *state.CompletedAt
+	}
+	return state.StartedAt
+}
+
+// SpaceError reports a download refused because it would not fit
+type SpaceError struct {
+	Required  int64 // Bytes the download needs
+	Available int64 // Bytes available on disk or left in the quota
+	Quota     bool  // True if the storage quota, not the disk, is the limit
+}
+
+func (e *SpaceError) Error() string {
+	if e.Quota {
+		return fmt.Sprintf("storage quota exceeded: need %d bytes, %d available in quota", e.Required, max(e.Available, 0))
+	}
+	return fmt.Sprintf("insufficient disk space: need %d bytes, %d available", e.Required, max(e.Available, 0))
+}
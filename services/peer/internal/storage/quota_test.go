@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/hash"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+)
+
+func newQuotaTestStorage(t *testing.T, free int64) *LocalStorage {
+	t.Helper()
+	ls, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	ls.freeSpace = func(string) (int64, error) { return free, nil }
+	return ls
+}
+
+func testMetadata(hash string, size int64) *protocol.FileMetadata {
+	return &protocol.FileMetadata{
+		Name:   hash + ".bin",
+		Size:   size,
+		Hash:   hash,
+		Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "c0", Size: size}},
+	}
+}
+
+// completeTestDownload starts a download and leaves a completed file of size bytes behind
+func completeTestDownload(t *testing.T, ls *LocalStorage, hash string, size int64, completedAt time.Time) {
+	t.Helper()
+	state, err := ls.StartDownload(testMetadata(hash, size))
+	if err != nil {
+		t.Fatalf("StartDownload(%s) failed: %v", hash, err)
+	}
+	if err := os.WriteFile(state.OutputPath, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(state.TempDir)
+	ls.AddSharedFile(state.Metadata, state.OutputPath)
+	ls.CompleteDownload(hash)
+	ls.mu.Lock()
+	state.CompletedAt = &completedAt
+	ls.mu.Unlock()
+}
+
+func TestStartDownload_InsufficientDiskSpace(t *testing.T) {
+	ls := newQuotaTestStorage(t, 1500)
+	ls.SetQuota(QuotaConfig{MinFreeBytes: 100})
+
+	// Needs 2000 bytes (temp chunks + assembled file) but only 1400 are usable
+	state, err := ls.StartDownload(testMetadata("big", 1000))
+
+	var spaceErr *SpaceError
+	if !errors.As(err, &spaceErr) {
+		t.Fatalf("Expected SpaceError, got %v", err)
+	}
+	if spaceErr.Quota {
+		t.Error("Expected disk space error, got quota error")
+	}
+	if state.Status != StatusNoSpace {
+		t.Errorf("Expected status %s, got %s", StatusNoSpace, state.Status)
+	}
+	if state.RequiredBytes != 2000 {
+		t.Errorf("Expected RequiredBytes 2000, got %d", state.RequiredBytes)
+	}
+	if state.LastError == "" {
+		t.Error("Expected LastError to be set")
+	}
+	if _, err := os.Stat(state.TempDir); !os.IsNotExist(err) {
+		t.Error("Temp directory should not be created for a refused download")
+	}
+
+	// Once space is freed the download can be resumed
+	ls.freeSpace = func(string) (int64, error) { return 10000, nil }
+	state, err = ls.ResumeDownload("big")
+	if err != nil {
+		t.Fatalf("ResumeDownload failed: %v", err)
+	}
+	if state.Status != StatusActive {
+		t.Errorf("Expected status %s, got %s", StatusActive, state.Status)
+	}
+}
+
+func TestStartDownload_QuotaExceeded(t *testing.T) {
+	ls := newQuotaTestStorage(t, 1<<40)
+	ls.SetQuota(QuotaConfig{MaxBytes: 3000})
+
+	completeTestDownload(t, ls, "old", 1000, time.Now().Add(-time.Hour))
+
+	// 1000 used + 2500 needed > 3000 and eviction is disabled
+	_, err := ls.StartDownload(testMetadata("new", 1250))
+	var spaceErr *SpaceError
+	if !errors.As(err, &spaceErr) || !spaceErr.Quota {
+		t.Fatalf("Expected quota SpaceError, got %v", err)
+	}
+	if _, ok := ls.GetDownload("old"); !ok {
+		t.Error("Completed download should not be evicted with policy none")
+	}
+}
+
+func TestStartDownload_EvictsOldest(t *testing.T) {
+	ls := newQuotaTestStorage(t, 1<<40)
+	ls.SetQuota(QuotaConfig{MaxBytes: 4000, Eviction: EvictOldest})
+
+	completeTestDownload(t, ls, "oldest", 1000, time.Now().Add(-2*time.Hour))
+	completeTestDownload(t, ls, "newer", 1000, time.Now().Add(-time.Hour))
+
+	var reported []string
+	ls.SetEvictionHandler(func(hashes []string) { reported = append(reported, hashes...) })
+
+	// 2000 used + 2500 needed > 4000: evicting the oldest download is enough
+	if _, err := ls.StartDownload(testMetadata("incoming", 1250)); err != nil {
+		t.Fatalf("StartDownload failed: %v", err)
+	}
+	if !slices.Equal(reported, []string{"oldest"}) {
+		t.Errorf("Reported evictions = %v, want [oldest]", reported)
+	}
+
+	if _, ok := ls.GetDownload("oldest"); ok {
+		t.Error("Oldest download should have been evicted")
+	}
+	if _, ok := ls.GetSharedFile("oldest"); ok {
+		t.Error("Evicted download should no longer be shared")
+	}
+	if _, err := os.Stat(filepath.Join(ls.baseDir, "downloads", "oldest.bin")); !os.IsNotExist(err) {
+		t.Error("Evicted file should be deleted")
+	}
+	if _, ok := ls.GetDownload("newer"); !ok {
+		t.Error("Newer download should be kept")
+	}
+}
+
+func TestEnforceQuota_EvictsLargest(t *testing.T) {
+	ls := newQuotaTestStorage(t, 1<<40)
+
+	completeTestDownload(t, ls, "small", 500, time.Now())
+	completeTestDownload(t, ls, "large", 2000, time.Now())
+
+	ls.SetQuota(QuotaConfig{MaxBytes: 1000, Eviction: EvictLargest})
+	if evicted := ls.EnforceQuota(); !slices.Equal(evicted, []string{"large"}) {
+		t.Errorf("Expected large to be evicted, got %v", evicted)
+	}
+	if _, ok := ls.GetDownload("large"); ok {
+		t.Error("Largest download should have been evicted")
+	}
+	if used := ls.UsedBytes(); used != 500 {
+		t.Errorf("Expected 500 bytes used, got %d", used)
+	}
+}
+
+func TestEnforceQuota_ChunkStore(t *testing.T) {
+	ls := newQuotaTestStorage(t, 1<<40)
+	ls.EnableChunkStore()
+
+	// Two stored files sharing a chunk
+	shared, a, b := make([]byte, 1000), make([]byte, 500), make([]byte, 300)
+	a[0], b[0] = 1, 2
+	first, _ := chunkedMetadata("first.bin", shared, a)
+	second, _ := chunkedMetadata("second.bin", shared, b)
+	for meta, own := range map[*protocol.FileMetadata][]byte{first: a, second: b} {
+		ls.StartDownload(meta)
+		for i, c := range [][]byte{shared, own} {
+			ls.SaveChunk(meta.Hash, i, c)
+			ls.MarkChunkReceived(meta.Hash, i)
+		}
+		ls.AddStoredFile(meta)
+		ls.CompleteDownload(meta.Hash)
+	}
+	// Leftover from an interrupted write, collected before anything is evicted
+	orphan := []byte("orphan")
+	ls.ChunkStore().Put(hash.Calculate(orphan), orphan, "gone")
+	ls.ChunkStore().Release("gone")
+
+	ls.SetQuota(QuotaConfig{MaxBytes: 1500, Eviction: EvictLargest})
+	if evicted := ls.EnforceQuota(); !slices.Equal(evicted, []string{first.Hash}) {
+		t.Errorf("Expected first to be evicted, got %v", evicted)
+	}
+	if _, ok := ls.GetSharedFile(first.Hash); ok {
+		t.Error("Evicted stored file should no longer be shared")
+	}
+	// The chunk second still uses is kept
+	if used := ls.UsedBytes(); used != 1300 {
+		t.Errorf("Expected 1300 bytes used, got %d", used)
+	}
+	if _, err := ls.ReadSharedChunk(second.Hash, 0); err != nil {
+		t.Errorf("ReadSharedChunk of the kept file failed: %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":       0,
+		"1048576": 1 << 20,
+		"500MB":   500 << 20,
+		"20gb":    20 << 30,
+		"1.5T":    3 << 39,
+	}
+	for in, want := range tests {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+
+	if _, err := ParseSize("lots"); err == nil {
+		t.Error("ParseSize(\"lots\") should fail")
+	}
+	if _, err := ParseEvictionPolicy("random"); err == nil {
+		t.Error("ParseEvictionPolicy(\"random\") should fail")
+	}
+}
+
+func TestUsedBytes_Tracked(t *testing.T) {
+	ls := newQuotaTestStorage(t, 1<<40)
+
+	// Temp chunks count while downloading, then the assembled file instead
+	state, _ := ls.StartDownload(testMetadata("file", 700))
+	ls.SaveChunk("file", 0, make([]byte, 700))
+	ls.SaveChunk("file", 0, make([]byte, 700)) // Written again, not counted twice
+	if used := ls.UsedBytes(); used != 700 {
+		t.Errorf("Expected 700 bytes of temp chunks, got %d", used)
+	}
+	os.WriteFile(state.OutputPath, make([]byte, 700), 0644)
+	ls.CompleteDownload("file")
+	if used := ls.UsedBytes(); used != 700 {
+		t.Errorf("Expected 700 bytes after completion, got %d", used)
+	}
+	if _, err := os.Stat(state.TempDir); !os.IsNotExist(err) {
+		t.Error("Temp chunks should be deleted on completion")
+	}
+
+	ls.StartDownload(testMetadata("cancelled", 300))
+	ls.SaveChunk("cancelled", 0, make([]byte, 300))
+	ls.CancelDownload("cancelled")
+	if used := ls.UsedBytes(); used != 700 {
+		t.Errorf("Expected 700 bytes after cancelling, got %d", used)
+	}
+
+	// Files written by something else are not seen until the next start
+	os.WriteFile(filepath.Join(ls.baseDir, "downloads", "other.bin"), make([]byte, 100), 0644)
+	if used := ls.UsedBytes(); used != 700 {
+		t.Errorf("Expected the disk not walked again, got %d", used)
+	}
+	reopened, err := NewLocalStorage(ls.baseDir)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if used := reopened.UsedBytes(); used != 800 {
+		t.Errorf("Expected 800 bytes after a restart, got %d", used)
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import "github.com/p2p-filesharing/distributed-system/pkg/protocol"
+
+// transferCount holds the bytes of a file sent to and received from other
+// peers. It is not persisted: trackers see a restarted peer start again.
+type transferCount struct {
+	uploaded   int64
+	downloaded int64
+}
+
+// transferUnsafe returns the counters of a file (caller must hold the write lock)
+func (s *LocalStorage) transferUnsafe(fileHash string) *transferCount {
+	count, ok := s.transfers[fileHash]
+	if !ok {
+		count = &transferCount{}
+		s.transfers[fileHash] = count
+	}
+	return count
+}
+
+// RecordUpload counts bytes of a file sent to another peer
+func (s *LocalStorage) RecordUpload(fileHash string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transferUnsafe(fileHash).uploaded += n
+}
+
+// Transfer returns the bytes of a file uploaded and downloaded since this
+// peer started, and those it still lacks: none of a shared file
+func (s *LocalStorage) Transfer(fileHash string) protocol.Transfer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transferOfUnsafe(fileHash)
+}
+
+// Transfers returns the Transfer of every shared file and download
+func (s *LocalStorage) Transfers() map[string]protocol.Transfer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]protocol.Transfer, len(s.sharedFiles)+len(s.downloads))
+	for hash := range s.sharedFiles {
+		result[hash] = s.transferOfUnsafe(hash)
+	}
+	for hash := range s.downloads {
+		result[hash] = s.transferOfUnsafe(hash)
+	}
+	return result
+}
+
+// transferOfUnsafe is Transfer (caller must hold the lock)
+func (s *LocalStorage) transferOfUnsafe(fileHash string) protocol.Transfer {
+	var transfer protocol.Transfer
+	if count, ok := s.transfers[fileHash]; ok {
+		transfer.Uploaded, transfer.Downloaded = count.uploaded, count.downloaded
+	}
+	if _, shared := s.sharedFiles[fileHash]; shared {
+		return transfer
+	}
+	if state, ok := s.downloads[fileHash]; ok {
+		for i, received := range state.ChunksReceived {
+			if !received && i < len(state.Metadata.Chunks) {
+				transfer.Left += state.Metadata.Chunks[i].Size
+			}
+		}
+	}
+	return transfer
+}
@@ -0,0 +1,44 @@
+// openapi-gen writes the Go client of an OpenAPI document, such as the
+// tracker API in services/tracker/internal/api/openapi.yaml. It is run by go
+// generate in pkg/trackerapi.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/openapi"
+)
+
+func main() {
+	spec := flag.String("spec", "", "OpenAPI document (YAML)")
+	pkg := flag.String("package", "", "Package of the generated code")
+	out := flag.String("out", "", "Output file (stdout if empty)")
+	flag.Parse()
+
+	if *spec == "" || *pkg == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := run(*spec, *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "openapi-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(spec, pkg, out string) error {
+	doc, err := openapi.Load(spec)
+	if err != nil {
+		return err
+	}
+	src, err := openapi.Generate(doc, pkg)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
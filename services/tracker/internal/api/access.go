@@ -0,0 +1,339 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
+)
+
+// Lifetimes of the tokens the tracker issues
+const (
+	grantTTL    = time.Hour            // Long enough for a download, a new one comes with each peer list
+	maxShareTTL = 365 * 24 * time.Hour // Longest TTL of a share token; one without a TTL does not expire
+)
+
+// minOwnerTokenLength rejects owner tokens short enough to be guessed; peers
+// send the 64 hex digits of access.OwnerToken
+const minOwnerTokenLength = 32
+
+// randomSecret returns a secret for a tracker started without ACCESS_SECRET
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetSigner sets the key the tracker signs session, share and grant tokens
+// with
+func (h *Handler) SetSigner(signer *access.Signer) {
+	h.signer = signer
+}
+
+// identify returns the owner identity of the peer making a request, from the
+// session token in its X-Peer-Token header. It is empty for a request without
+// a valid token, or whose token was issued to another peer.
+func (h *Handler) identify(r *http.Request, peerID string) string {
+	if t := h.session(r, peerID); t != nil {
+		return t.Owner
+	}
+	return ""
+}
+
+// session returns the session token in the X-Peer-Token header of a request,
+// nil without a valid token or with one issued to another peer
+func (h *Handler) session(r *http.Request, peerID string) *access.Token {
+	token := r.Header.Get(protocol.HeaderPeerToken)
+	if token == "" || peerID == "" {
+		return nil
+	}
+	t, err := h.signer.Verify(token, access.KindSession)
+	if err != nil || t.Peer != peerID {
+		return nil
+	}
+	return t
+}
+
+// authenticate checks that a request comes from peerID itself, by the
+// session token in its X-Peer-Token header, and returns the peer's owner
+// identity. It sends 401 without a valid token and 403 with the token of
+// another peer.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request, peerID string) (string, bool) {
+	if owner := h.identify(r, peerID); owner != "" {
+		return owner, true
+	}
+	if _, err := h.signer.Verify(r.Header.Get(protocol.HeaderPeerToken), access.KindSession); err == nil {
+		sendError(w, http.StatusForbidden, "The session token belongs to another peer")
+		return "", false
+	}
+	sendError(w, http.StatusUnauthorized, "The session token of the peer is required")
+	return "", false
+}
+
+// canAccess reports whether a request may get the peers of a file: any
+// request for a public or unlisted file; for a private file, one from its
+// owner, from a member of one of its groups, or with a share token of it in
+// the X-Share-Token header or the share parameter
+func (h *Handler) canAccess(r *http.Request, file *models.File, owner string) bool {
+	if file.Visibility != access.Private {
+		return true
+	}
+	if owner != "" {
+		if owner == file.OwnerID {
+			return true
+		}
+		for _, name := range file.Groups {
+			if group, ok := h.storage.GetGroup(name); ok && slices.Contains(group.Members, owner) {
+				return true
+			}
+		}
+	}
+
+	token := r.Header.Get(protocol.HeaderShareToken)
+	if token == "" {
+		token = r.URL.Query().Get("share")
+	}
+	if token == "" {
+		return false
+	}
+	t, err := h.signer.Verify(token, access.KindShare)
+	return err == nil && t.File == file.Hash && t.Epoch == file.ShareEpoch
+}
+
+// grant returns the token with which the peer of a session downloads a
+// private file from its seeders, who check that the peer holds its key
+func (h *Handler) grant(file *models.File, session *access.Token) string {
+	return h.signer.Sign(access.Token{
+		Kind:    access.KindGrant,
+		File:    file.Hash,
+		Peer:    session.Peer,
+		Key:     session.Key,
+		Expires: time.Now().Add(grantTTL),
+	})
+}
+
+// ownedFile returns the file of a request to an owner endpoint, after
+// checking that the peer making it owns the file. It sends the error
+// otherwise.
+func (h *Handler) ownedFile(w http.ResponseWriter, r *http.Request, peerID string) (*models.File, bool) {
+	if peerID == "" {
+		sendError(w, http.StatusBadRequest, "Peer ID is required")
+		return nil, false
+	}
+	owner := h.identify(r, peerID)
+	if owner == "" {
+		sendError(w, http.StatusUnauthorized, "A session token with an owner identity is required")
+		return nil, false
+	}
+	file, ok := h.storage.GetFile(r.PathValue("hash"))
+	if !ok || !h.canAccess(r, file, owner) {
+		sendError(w, http.StatusNotFound, "File not found")
+		return nil, false
+	}
+	if file.OwnerID != owner {
+		sendError(w, http.StatusForbidden, "Only the owner of the file can change its access")
+		return nil, false
+	}
+	return file, true
+}
+
+// SetFileAccess handles PUT /api/files/{hash}/access, by which the owner of
+// a file sets its visibility and the groups it is shared with
+func (h *Handler) SetFileAccess(w http.ResponseWriter, r *http.Request) {
+	h.setFileAccess(w, r, false)
+}
+
+// AdminSetFileAccess handles PUT /api/admin/files/{hash}/access, which sets
+// the access of any file
+func (h *Handler) AdminSetFileAccess(w http.ResponseWriter, r *http.Request) {
+	h.setFileAccess(w, r, true)
+}
+
+func (h *Handler) setFileAccess(w http.ResponseWriter, r *http.Request, admin bool) {
+	var req protocol.FileAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !access.ValidVisibility(req.Visibility) {
+		sendError(w, http.StatusBadRequest, "visibility must be public, unlisted or private")
+		return
+	}
+	visibility := req.Visibility
+	if visibility == "" {
+		visibility = access.Public
+	}
+
+	groups := []string{}
+	for _, name := range req.Groups {
+		name, err := normalizeLabel(name)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid group: "+err.Error())
+			return
+		}
+		if !slices.Contains(groups, name) {
+			groups = append(groups, name)
+		}
+	}
+
+	var file *models.File
+	if admin {
+		var ok bool
+		if file, ok = h.storage.GetFile(r.PathValue("hash")); !ok {
+			sendError(w, http.StatusNotFound, "File not found")
+			return
+		}
+	} else {
+		var ok bool
+		if file, ok = h.ownedFile(w, r, req.PeerID); !ok {
+			return
+		}
+	}
+
+	if err := h.storage.UpdateFileAccess(file.Hash, visibility, groups); err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			sendError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		sendError(w, http.StatusInternalServerError, "Failed to update file")
+		return
+	}
+
+	sendJSON(w, http.StatusOK, protocol.FileAccessResponse{
+		Success:    true,
+		Hash:       file.Hash,
+		Visibility: visibility,
+		Groups:     groups,
+	})
+}
+
+// CreateShare handles POST /api/files/{hash}/shares, by which the owner of a
+// private file gets a share token to hand out. Anyone holding it gets the
+// peers of the file until it expires or the owner revokes the shares.
+func (h *Handler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	var req protocol.ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.TTLSecs < 0 || req.TTLSecs > int64(maxShareTTL/time.Second) {
+		sendError(w, http.StatusBadRequest, "ttl_secs must be between 0 and one year")
+		return
+	}
+	ttl := time.Duration(req.TTLSecs) * time.Second
+
+	file, ok := h.ownedFile(w, r, req.PeerID)
+	if !ok {
+		return
+	}
+
+	token := access.Token{Kind: access.KindShare, File: file.Hash, Epoch: file.ShareEpoch}
+	if ttl > 0 {
+		token.Expires = time.Now().Add(ttl).UTC()
+	}
+	sendJSON(w, http.StatusOK, protocol.ShareResponse{
+		Success:   true,
+		Token:     h.signer.Sign(token),
+		ExpiresAt: token.Expires,
+	})
+}
+
+// RevokeShares handles DELETE /api/files/{hash}/shares?peer_id=..., which
+// revokes every share token of the file issued so far. Peers that got a grant
+// with one keep it until it expires.
+func (h *Handler) RevokeShares(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.ownedFile(w, r, r.URL.Query().Get("peer_id"))
+	if !ok {
+		return
+	}
+
+	if _, err := h.storage.RevokeShares(file.Hash); err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			sendError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		sendError(w, http.StatusInternalServerError, "Failed to revoke shares")
+		return
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"hash":    file.Hash,
+	})
+}
+
+// AdminSetGroup handles PUT /api/admin/groups/{group} with {"members": [...]},
+// the owner IDs of the members, which replace those of an existing group
+func (h *Handler) AdminSetGroup(w http.ResponseWriter, r *http.Request) {
+	name, err := normalizeLabel(r.PathValue("group"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid group: "+err.Error())
+		return
+	}
+	var req struct {
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	members := []string{}
+	for _, member := range req.Members {
+		if member == "" {
+			sendError(w, http.StatusBadRequest, "Empty member")
+			return
+		}
+		if !slices.Contains(members, member) {
+			members = append(members, member)
+		}
+	}
+
+	group := &models.Group{Name: name, Members: members}
+	if err := h.storage.SetGroup(group); err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to save group")
+		return
+	}
+	sendJSON(w, http.StatusOK, group)
+}
+
+// AdminListGroups handles GET /api/admin/groups, paged by name on /api/v2
+func (h *Handler) AdminListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, next, ok := adminPage(w, r, h.storage.ListGroups(), func(g *models.Group) string { return g.Name })
+	if !ok {
+		return
+	}
+	resp := map[string]interface{}{
+		"count":  len(groups),
+		"groups": groups,
+	}
+	if next != "" {
+		resp["next_cursor"] = next
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
+
+// AdminDeleteGroup handles DELETE /api/admin/groups/{group}
+func (h *Handler) AdminDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("group")
+	if _, ok := h.storage.GetGroup(name); !ok {
+		sendError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	if err := h.storage.DeleteGroup(name); err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to delete group")
+		return
+	}
+	sendJSON(w, http.StatusOK, map[string]string{
+		"message": "Group deleted successfully",
+		"group":   name,
+	})
+}
@@ -2,10 +2,13 @@ package api
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"time"
+
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
 )
 
 //go:embed templates/*
@@ -21,6 +24,7 @@ type DashboardData struct {
 	Uptime        string
 	Peers         []PeerView
 	Files         []FileView
+	NextCursor    string // Next page of files
 	RecentEvents  []EventView
 	LastRefreshed string
 }
@@ -89,10 +93,23 @@ func (s *Server) DashboardHandler() http.HandlerFunc {
 			})
 		}
 
-		// Get files list
-		allFiles := s.storage.ListFiles()
-		fileViews := make([]FileView, 0, len(allFiles))
-		for _, f := range allFiles {
+		// Get a page of files, ordered and filtered like GET /api/files
+		query, err := parseFileQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := s.storage.ListFiles(query)
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to list files", http.StatusInternalServerError)
+			return
+		}
+		fileViews := make([]FileView, 0, len(page.Files))
+		for _, f := range page.Files {
 			hash := f.Hash
 			fullHash := f.Hash
 			if len(hash) > 12 {
@@ -103,7 +120,7 @@ func (s *Server) DashboardHandler() http.HandlerFunc {
 				FullHash:   fullHash,
 				Name:       truncate(f.Name, 40),
 				Size:       formatBytes(f.Size),
-				Category:   "",
+				Category:   f.Category,
 				PeersCount: f.Seeders + f.Leechers,
 				AddedAt:    f.AddedAt.Format("2006-01-02 15:04"),
 			})
@@ -118,6 +135,7 @@ func (s *Server) DashboardHandler() http.HandlerFunc {
 			Uptime:        formatDuration(time.Since(startTime)),
 			Peers:         peerViews,
 			Files:         fileViews,
+			NextCursor:    page.NextCursor,
 			LastRefreshed: time.Now().Format("15:04:05"),
 		}
 
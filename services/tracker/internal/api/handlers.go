@@ -1,10 +1,19 @@
 package api
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/filetype"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
 	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
@@ -39,11 +48,19 @@ func getRealIP(r *http.Request) string {
 type Handler struct {
 	storage storage.Storage
 	wsHub   *WSHub
+	signer  *access.Signer
+	peers   PeerPolicy
 }
 
-// NewHandler creates a new Handler
+// NewHandler creates a new Handler, signing tokens with a random key until
+// SetSigner is called
 func NewHandler(s storage.Storage) *Handler {
-	return &Handler{storage: s}
+	return &Handler{storage: s, signer: access.NewSigner(randomSecret()), peers: DefaultPeerPolicy()}
+}
+
+// SetPeerPolicy sets how peer lists are selected
+func (h *Handler) SetPeerPolicy(policy PeerPolicy) {
+	h.peers = policy
 }
 
 // SetWSHub sets the WebSocket hub for broadcasting events
@@ -75,6 +92,23 @@ func (h *Handler) RegisterPeer(w http.ResponseWriter, r *http.Request) {
 		peerIP = getRealIP(r)
 	}
 
+	// The owner token gives the peer the identity it owns files with, and
+	// which the session token proves in its next requests
+	ownerID := ""
+	if req.OwnerToken != "" {
+		if len(req.OwnerToken) < minOwnerTokenLength {
+			sendError(w, http.StatusBadRequest, "Owner token too short")
+			return
+		}
+		ownerID = access.OwnerID(req.OwnerToken)
+	}
+	if req.PeerKey != "" {
+		if _, err := access.ParsePublicKey(req.PeerKey); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid peer key")
+			return
+		}
+	}
+
 	peer := &models.Peer{
 		ID:       req.PeerID,
 		IP:       peerIP,
@@ -94,13 +128,19 @@ func (h *Handler) RegisterPeer(w http.ResponseWriter, r *http.Request) {
 		"ip":       peer.IP,
 	})
 
-	sendJSON(w, http.StatusOK, protocol.RegisterResponse{
-		Success: true,
-		Message: "Registered successfully",
-	})
+	resp := protocol.RegisterResponse{
+		Success:   true,
+		Message:   "Registered successfully",
+		AccessKey: h.signer.PublicKey(),
+	}
+	if ownerID != "" {
+		resp.OwnerID = ownerID
+		resp.SessionToken = h.signer.Sign(access.Token{Kind: access.KindSession, Peer: peer.ID, Owner: ownerID, Key: req.PeerKey})
+	}
+	sendJSON(w, http.StatusOK, resp)
 }
 
-// Heartbeat handles POST /api/peers/heartbeat
+// Heartbeat handles POST /api/peers/heartbeat, with the session token of the peer
 func (h *Handler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	var req protocol.HeartbeatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -108,10 +148,24 @@ func (h *Handler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A peer removed while offline, or lost on restart with in-memory storage,
+	// must register again and re-announce its files
+	if _, ok := h.storage.GetPeer(req.PeerID); !ok {
+		sendError(w, http.StatusNotFound, "Unknown peer, register again")
+		return
+	}
+	// Only the peer itself keeps itself online and reports its transfers
+	if _, ok := h.authenticate(w, r, req.PeerID); !ok {
+		return
+	}
+
 	if err := h.storage.UpdatePeerHeartbeat(req.PeerID); err != nil {
 		sendError(w, http.StatusInternalServerError, "Failed to update heartbeat")
 		return
 	}
+	for hash, transfer := range req.Transfers {
+		h.recordTransfer(storage.TransferReport{FileHash: hash, PeerID: req.PeerID, Transfer: transfer})
+	}
 
 	sendJSON(w, http.StatusOK, protocol.HeartbeatResponse{
 		Success:           true,
@@ -147,8 +201,24 @@ func (h *Handler) AnnounceFile(w http.ResponseWriter, r *http.Request) {
 		sendError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	visibility := req.File.Visibility
+	if !access.ValidVisibility(visibility) {
+		sendError(w, http.StatusBadRequest, "visibility must be public, unlisted or private")
+		return
+	}
+	if visibility == "" {
+		visibility = access.Public
+	}
+	owner := h.identify(r, req.PeerID)
+	if owner == "" && visibility != access.Public {
+		sendError(w, http.StatusUnauthorized, "A session token with an owner identity is required to announce unlisted or private files")
+		return
+	}
+	existing, known := h.storage.GetFile(req.File.Hash)
 
-	// Add file metadata
+	// Add file metadata, classified from its first bytes. A file already
+	// known keeps its tags, its access and a category other than "other";
+	// a new one is owned by the announcing peer's owner.
 	file := &models.File{
 		ID:        req.File.Hash,
 		Hash:      req.File.Hash,
@@ -156,31 +226,45 @@ func (h *Handler) AnnounceFile(w http.ResponseWriter, r *http.Request) {
 		Size:      req.File.Size,
 		ChunkSize: req.File.ChunkSize,
 		Chunks:    req.File.Chunks,
+		Category:  filetype.Detect(req.File.Header, req.File.Name),
 		AddedBy:   req.PeerID,
+
+		OwnerID:    owner,
+		Visibility: visibility,
+
+		BTInfoHash:   req.File.BTInfoHash,
+		BTInfoHashV2: req.File.BTInfoHashV2,
 	}
 	h.storage.AddFile(file)
 
-	// Associate peer with file (as seeder with all chunks)
-	allChunks := make([]int, len(req.File.Chunks))
-	for i := range req.File.Chunks {
-		allChunks[i] = i
+	// Its owner changes the visibility of a known file by announcing it again
+	if known && owner != "" && owner == existing.OwnerID && req.File.Visibility != "" &&
+		visibility != cmp.Or(existing.Visibility, access.Public) {
+		if err := h.storage.UpdateFileAccess(existing.Hash, visibility, existing.Groups); err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to update file")
+			return
+		}
 	}
 
+	// Associate peer with file (as seeder with all chunks)
 	filePeer := &models.FilePeer{
 		FileHash:        req.File.Hash,
 		PeerID:          req.PeerID,
-		ChunksAvailable: allChunks,
+		ChunksAvailable: protocol.FullChunkSet(len(req.File.Chunks)),
 		IsSeeder:        true,
 	}
 	h.storage.AddFilePeer(filePeer)
 
-	// Broadcast file added event
-	h.broadcastEvent(EventFileAdded, map[string]interface{}{
-		"hash":     file.Hash,
-		"name":     file.Name,
-		"size":     file.Size,
-		"added_by": file.AddedBy,
-	})
+	// Broadcast file added event, for files that are listed
+	if !known && visibility == access.Public {
+		h.broadcastEvent(EventFileAdded, map[string]interface{}{
+			"hash":     file.Hash,
+			"name":     file.Name,
+			"size":     file.Size,
+			"category": file.Category,
+			"added_by": file.AddedBy,
+		})
+	}
 
 	sendJSON(w, http.StatusOK, protocol.AnnounceResponse{
 		Success: true,
@@ -188,26 +272,132 @@ func (h *Handler) AnnounceFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// ListFiles handles GET /api/files
+// WithdrawFile handles DELETE /api/files/{hash}/peers/{peer_id}
+// It is sent by a peer that stopped sharing a file (deleted or modified on disk),
+// with its session token so that no one else can remove it from the swarm.
+func (h *Handler) WithdrawFile(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	peerID := r.PathValue("peer_id")
+	if hash == "" || peerID == "" {
+		sendError(w, http.StatusBadRequest, "File hash and peer ID required")
+		return
+	}
+	// Only the peer itself can leave a swarm
+	if _, ok := h.authenticate(w, r, peerID); !ok {
+		return
+	}
+
+	if err := h.storage.RemoveFilePeer(hash, peerID); err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to withdraw file")
+		return
+	}
+
+	sendJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ReportAvailability handles POST /api/files/availability
+// It is sent by a peer downloading a file, with the chunks it got since its
+// last report, so other peers can download them from it before it finishes.
+// Its event, if any, tells that the download started, completed, was paused
+// or stopped; a stopped peer leaves the swarm of the file. The report must
+// carry the session token of the peer, and a private file must be accessible
+// to it.
+func (h *Handler) ReportAvailability(w http.ResponseWriter, r *http.Request) {
+	var req protocol.AvailabilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	switch req.Event {
+	case "", protocol.AnnounceStarted, protocol.AnnounceCompleted, protocol.AnnounceStopped, protocol.AnnouncePaused:
+	default:
+		sendError(w, http.StatusBadRequest, "event must be started, completed, stopped or paused")
+		return
+	}
+
+	if _, ok := h.storage.GetPeer(req.PeerID); !ok {
+		sendError(w, http.StatusNotFound, "Unknown peer, register again")
+		return
+	}
+	owner, ok := h.authenticate(w, r, req.PeerID)
+	if !ok {
+		return
+	}
+	file, ok := h.storage.GetFile(req.FileHash)
+	if !ok || !h.canAccess(r, file, owner) {
+		sendError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !req.Chunks.Difference(protocol.FullChunkSet(len(file.Chunks))).IsEmpty() {
+		sendError(w, http.StatusBadRequest, "Chunk index out of range")
+		return
+	}
+	report := storage.TransferReport{FileHash: file.Hash, PeerID: req.PeerID, Event: req.Event, Transfer: req.Transfer}
+
+	if req.Event == protocol.AnnounceStopped {
+		h.recordTransfer(report)
+		if err := h.storage.RemoveFilePeer(file.Hash, req.PeerID); err != nil {
+			sendError(w, http.StatusInternalServerError, "Failed to update availability")
+			return
+		}
+		sendJSON(w, http.StatusOK, protocol.AvailabilityResponse{Success: true})
+		return
+	}
+
+	chunks, err := h.storage.UpdateFilePeerChunks(file.Hash, req.PeerID, req.Chunks, req.Full, len(file.Chunks))
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "Failed to update availability")
+		return
+	}
+	// A download is complete once the peer has every chunk, whether or not
+	// it says so
+	if report.Event == "" && chunks.Complete(len(file.Chunks)) {
+		report.Event = protocol.AnnounceCompleted
+	}
+	h.recordTransfer(report)
+
+	sendJSON(w, http.StatusOK, protocol.AvailabilityResponse{
+		Success:  true,
+		Chunks:   chunks.Len(),
+		IsSeeder: chunks.Complete(len(file.Chunks)),
+	})
+}
+
+// ListFiles handles GET /api/files, with the filters and paging of
+// parseFileQuery
 func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
-	files := h.storage.ListFiles()
-	sendJSON(w, http.StatusOK, protocol.ListFilesResponse{Files: files})
+	query, err := parseFileQuery(r.URL.Query())
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, ok := h.listFiles(w, query)
+	if !ok {
+		return
+	}
+	sendFilePage(w, page, "", "")
 }
 
-// SearchFiles handles GET /api/files/search?q=query
+// SearchFiles handles GET /api/files/search?q=ubuntu+24+server, a full-text
+// search ranked by relevance. It takes the same parameters as ListFiles.
 func (h *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
+	params := r.URL.Query()
+	query := params.Get("q")
+	if query == "" && params.Get("cursor") == "" {
 		sendError(w, http.StatusBadRequest, "Query parameter 'q' is required")
 		return
 	}
 
-	files := h.storage.SearchFiles(query)
-	sendJSON(w, http.StatusOK, map[string]interface{}{
-		"query": query,
-		"count": len(files),
-		"files": files,
-	})
+	fileQuery, err := parseFileQuery(params)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, ok := h.listFiles(w, fileQuery)
+	if !ok {
+		return
+	}
+	sendFilePage(w, page, "query", query)
 }
 
 // ListCategories handles GET /api/categories
@@ -227,15 +417,31 @@ func (h *Handler) ListFilesByCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	files := h.storage.ListFilesByCategory(category)
-	sendJSON(w, http.StatusOK, map[string]interface{}{
-		"category": category,
-		"count":    len(files),
-		"files":    files,
-	})
+	query, err := parseFileQuery(r.URL.Query())
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Category = category
+	page, ok := h.listFiles(w, query)
+	if !ok {
+		return
+	}
+	sendFilePage(w, page, "category", category)
 }
 
-// GetFilePeers handles GET /api/files/{hash}/peers
+// GetFilePeers handles GET /api/files/{hash}/peers?numwant=50&chunks=leechers.
+// The hash may also be the BitTorrent info hash of a file imported from a
+// torrent; the response carries the file's own hash.
+//
+// The peer policy picks and orders the peers, at most numwant of them, for
+// the peer in peer_id. chunks selects whose availability is sent: all
+// (default on /api), leechers (seeders have every chunk, default on /api/v2)
+// or none.
+//
+// A private file is only found by the requests canAccess allows. Those with
+// the session token of the peer in peer_id get a grant for it, which seeders
+// check before serving it.
 func (h *Handler) GetFilePeers(w http.ResponseWriter, r *http.Request) {
 	fileHash := r.PathValue("hash")
 	if fileHash == "" {
@@ -243,15 +449,53 @@ func (h *Handler) GetFilePeers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+	numWant := -1
+	if s := query.Get("numwant"); s != "" {
+		n, err := parseInt(s)
+		if err != nil || n < 0 {
+			sendError(w, http.StatusBadRequest, "Invalid numwant")
+			return
+		}
+		numWant = n
+	}
+	chunks := query.Get("chunks")
+	if chunks == "" && isV2(w) {
+		chunks = "leechers"
+	}
+	switch chunks {
+	case "", "all", "leechers", "none":
+	default:
+		sendError(w, http.StatusBadRequest, "chunks must be all, leechers or none")
+		return
+	}
+
+	peerID := query.Get("peer_id")
+	session := h.session(r, peerID)
+	owner := ""
+	if session != nil {
+		owner = session.Owner
+	}
 	file, exists := h.storage.GetFile(fileHash)
-	if !exists {
+	if !exists || !h.canAccess(r, file, owner) {
 		sendError(w, http.StatusNotFound, "File not found")
 		return
 	}
 
-	peers := h.storage.GetPeersForFile(fileHash)
+	// The requester's registered address, rather than that of a proxy or
+	// NAT, tells which peers share its subnet
+	req := peerRequest{PeerID: peerID, IP: getRealIP(r), NumWant: h.peers.numWant(numWant)}
+	if peer, ok := h.storage.GetPeer(peerID); ok && peerID != "" {
+		req.IP = peer.IP
+	}
+	peers := h.peers.Select(h.storage.GetPeersForFile(file.Hash, peerID, h.peers.PoolSize), req)
+	for i := range peers {
+		if chunks == "none" || (chunks == "leechers" && peers[i].IsSeeder) {
+			peers[i].Availability = nil
+		}
+	}
 
-	sendJSON(w, http.StatusOK, protocol.GetPeersResponse{
+	resp := protocol.GetPeersResponse{
 		FileHash:   file.Hash,
 		FileName:   file.Name,
 		FileSize:   file.Size,
@@ -259,14 +503,22 @@ func (h *Handler) GetFilePeers(w http.ResponseWriter, r *http.Request) {
 		ChunkSize:  file.ChunkSize,
 		Chunks:     file.Chunks,
 		Peers:      peers,
-	})
+		Visibility: file.Visibility,
+	}
+	if file.Visibility == access.Private && session != nil {
+		resp.AccessToken = h.grant(file, session)
+	}
+	sendJSON(w, http.StatusOK, resp)
 }
 
 // === Admin Endpoints ===
 
-// AdminListPeers handles GET /api/admin/peers
+// AdminListPeers handles GET /api/admin/peers, paged by ID on /api/v2
 func (h *Handler) AdminListPeers(w http.ResponseWriter, r *http.Request) {
-	peers := h.storage.ListAllPeers()
+	peers, next, ok := adminPage(w, r, h.storage.ListAllPeers(), func(p *models.Peer) string { return p.ID })
+	if !ok {
+		return
+	}
 
 	type PeerInfo struct {
 		ID           string `json:"id"`
@@ -291,10 +543,14 @@ func (h *Handler) AdminListPeers(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	sendJSON(w, http.StatusOK, map[string]interface{}{
+	resp := map[string]interface{}{
 		"count": len(result),
 		"peers": result,
-	})
+	}
+	if next != "" {
+		resp["next_cursor"] = next
+	}
+	sendJSON(w, http.StatusOK, resp)
 }
 
 // AdminKickPeer handles DELETE /api/admin/peers/{peer_id}
@@ -418,6 +674,109 @@ func (h *Handler) ReportStats(w http.ResponseWriter, r *http.Request) {
 }
 
 // Helper functions
+// Page sizes of file listings
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// parseFileQuery reads the parameters of file listings: q (full-text search
+// of the names, tags and categories), category, tag (repeated or comma-separated, files having all of them),
+// min_size and max_size in bytes, min_seeders, since (an RFC 3339 time or a
+// duration such as 24h), sort (added, name, size, seeders or relevance, the
+// default when searching), order (asc or desc, by default asc for names and
+// desc otherwise), limit and cursor.
+func parseFileQuery(params url.Values) (storage.FileQuery, error) {
+	q := storage.FileQuery{
+		Search:   params.Get("q"),
+		Category: params.Get("category"),
+		Sort:     params.Get("sort"),
+		Cursor:   params.Get("cursor"),
+		Limit:    defaultPageSize,
+	}
+	for _, tags := range params["tag"] {
+		for _, tag := range strings.Split(tags, ",") {
+			if strings.TrimSpace(tag) == "" {
+				continue
+			}
+			// Tags are stored normalized, see normalizeLabel
+			tag, err := normalizeLabel(tag)
+			if err != nil {
+				return q, fmt.Errorf("invalid tag: %w", err)
+			}
+			q.Tags = append(q.Tags, tag)
+		}
+	}
+
+	numbers := []struct {
+		name string
+		dst  *int64
+	}{{"min_size", &q.MinSize}, {"max_size", &q.MaxSize}}
+	for _, n := range numbers {
+		if s := params.Get(n.name); s != "" {
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || v < 0 {
+				return q, fmt.Errorf("invalid %s", n.name)
+			}
+			*n.dst = v
+		}
+	}
+	if s := params.Get("min_seeders"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return q, fmt.Errorf("invalid min_seeders")
+		}
+		q.MinSeeders = v
+	}
+	if s := params.Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = v
+	}
+	if s := params.Get("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			q.AddedSince = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			q.AddedSince = t
+		} else {
+			return q, fmt.Errorf("since must be an RFC 3339 time or a duration")
+		}
+	}
+
+	switch q.Sort {
+	case "", storage.SortAdded, storage.SortName, storage.SortSize, storage.SortSeeders, storage.SortRelevance:
+	default:
+		return q, fmt.Errorf("sort must be added, name, size, seeders or relevance")
+	}
+	switch params.Get("order") {
+	case "":
+		q.Desc = q.Sort != storage.SortName
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("order must be asc or desc")
+	}
+	return q, nil
+}
+
+// listFiles runs a file query, sending the error response if it fails
+func (h *Handler) listFiles(w http.ResponseWriter, q storage.FileQuery) (*storage.FilePage, bool) {
+	page, err := h.storage.ListFiles(q)
+	if errors.Is(err, storage.ErrInvalidQuery) {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[Tracker] Failed to list files: %v", err)
+		sendError(w, http.StatusInternalServerError, "Failed to list files")
+		return nil, false
+	}
+	return page, true
+}
+
 func parseInt(s string) (int, error) {
 	var n int
 	for _, c := range s {
@@ -436,10 +795,35 @@ func sendJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// sendError sends an error: {"error": message} on /api, an apiError on
+// /api/v2
 func sendError(w http.ResponseWriter, status int, message string) {
+	if isV2(w) {
+		sendJSON(w, status, newAPIError(status, message))
+		return
+	}
 	sendJSON(w, status, map[string]string{"error": message})
 }
 
+// sendFilePage sends a page of files. /api echoes the search query or the
+// label of the listing in the field named echo, if any.
+func sendFilePage(w http.ResponseWriter, page *storage.FilePage, echo, value string) {
+	if echo == "" || isV2(w) {
+		sendJSON(w, http.StatusOK, protocol.ListFilesResponse{
+			Files:      page.Files,
+			Count:      len(page.Files),
+			NextCursor: page.NextCursor,
+		})
+		return
+	}
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		echo:          value,
+		"count":       len(page.Files),
+		"files":       page.Files,
+		"next_cursor": page.NextCursor,
+	})
+}
+
 // GetMagnetLink handles GET /api/files/{hash}/magnet
 func (h *Handler) GetMagnetLink(w http.ResponseWriter, r *http.Request) {
 	hash := r.PathValue("hash")
@@ -449,12 +833,12 @@ func (h *Handler) GetMagnetLink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	file, ok := h.storage.GetFile(hash)
-	if !ok {
+	if !ok || !h.canAccess(r, file, h.identify(r, r.URL.Query().Get("peer_id"))) {
 		sendError(w, http.StatusNotFound, "File not found")
 		return
 	}
 
-	peers := h.storage.GetPeersForFile(hash)
+	peers := h.storage.GetPeersForFile(hash, "", 0)
 
 	// Build magnet URI
 	magnetURI := "magnet:?xt=urn:sha256:" + file.Hash
@@ -525,7 +909,10 @@ func (h *Handler) ParseMagnetLink(w http.ResponseWriter, r *http.Request) {
 
 	// Check if file exists in tracker
 	file, exists := h.storage.GetFile(infoHash)
-	peers := h.storage.GetPeersForFile(infoHash)
+	if exists && !h.canAccess(r, file, h.identify(r, r.URL.Query().Get("peer_id"))) {
+		exists = false
+	}
+	peers := h.storage.GetPeersForFile(infoHash, "", 0)
 
 	response := map[string]interface{}{
 		"info_hash":    infoHash,
@@ -2,12 +2,18 @@ package api
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
 	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
 )
 
@@ -47,17 +53,28 @@ func TestHeartbeat(t *testing.T) {
 	h := setupTestHandler()
 
 	// First register a peer
-	regReq := protocol.RegisterRequest{PeerID: "test-peer-1", IP: "127.0.0.1", Port: 6881}
+	regReq := protocol.RegisterRequest{PeerID: "test-peer-1", IP: "127.0.0.1", Port: 6881, OwnerToken: strings.Repeat("a", 64)}
 	body, _ := json.Marshal(regReq)
 	r := httptest.NewRequest(http.MethodPost, "/api/peers/register", bytes.NewReader(body))
 	w := httptest.NewRecorder()
 	h.RegisterPeer(w, r)
+	var regResp protocol.RegisterResponse
+	json.NewDecoder(w.Body).Decode(&regResp)
 
-	// Now send heartbeat
+	// A heartbeat must carry the session token of the peer
 	hbReq := protocol.HeartbeatRequest{PeerID: "test-peer-1", FilesHashes: []string{}}
 	body, _ = json.Marshal(hbReq)
 	r = httptest.NewRequest(http.MethodPost, "/api/peers/heartbeat", bytes.NewReader(body))
 	w = httptest.NewRecorder()
+	h.Heartbeat(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a session, got %d", w.Code)
+	}
+
+	// Now send heartbeat
+	r = httptest.NewRequest(http.MethodPost, "/api/peers/heartbeat", bytes.NewReader(body))
+	r.Header.Set(protocol.HeaderPeerToken, regResp.SessionToken)
+	w = httptest.NewRecorder()
 
 	h.Heartbeat(w, r)
 
@@ -76,6 +93,21 @@ func TestHeartbeat(t *testing.T) {
 	}
 }
 
+func TestHeartbeatUnknownPeer(t *testing.T) {
+	h := setupTestHandler()
+
+	hbReq := protocol.HeartbeatRequest{PeerID: "never-registered"}
+	body, _ := json.Marshal(hbReq)
+	r := httptest.NewRequest(http.MethodPost, "/api/peers/heartbeat", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	h.Heartbeat(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
 func TestAnnounceFile(t *testing.T) {
 	h := setupTestHandler()
 
@@ -160,3 +192,549 @@ func TestListFiles(t *testing.T) {
 		t.Errorf("Expected 1 file, got %d", len(resp.Files))
 	}
 }
+
+func TestListFiles_Paging(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	h := NewHandler(store)
+	for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		store.AddFile(&models.File{Hash: "hash-" + name, Name: name, Size: 10})
+	}
+
+	list := func(target string) (int, protocol.ListFilesResponse) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		h.ListFiles(w, r)
+		var resp protocol.ListFilesResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, first := list("/api/files?sort=name&limit=2")
+	if code != http.StatusOK || len(first.Files) != 2 || first.Files[0].Name != "a.txt" || first.NextCursor == "" {
+		t.Fatalf("Expected a.txt and b.txt and a cursor, got %d %+v", code, first)
+	}
+	code, second := list("/api/files?limit=2&cursor=" + first.NextCursor)
+	if code != http.StatusOK || len(second.Files) != 1 || second.Files[0].Name != "c.txt" || second.NextCursor != "" {
+		t.Errorf("Expected c.txt on the last page, got %d %+v", code, second)
+	}
+
+	for _, bad := range []string{"sort=popularity", "order=up", "sort=relevance", "limit=0", "limit=5000", "min_size=-1", "since=yesterday", "cursor=xyz"} {
+		if code, _ := list("/api/files?" + bad); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", bad, code)
+		}
+	}
+}
+
+func TestFileLabels(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	h := NewHandler(store)
+	for _, id := range []string{"owner", "other"} {
+		store.RegisterPeer(&models.Peer{ID: id, IP: "127.0.0.1", Port: 6881})
+	}
+
+	// The category is detected from the first bytes of the file
+	announce := protocol.AnnounceRequest{
+		PeerID: "owner",
+		File: protocol.FileMetadata{Name: "holiday", Size: 1024, Hash: "abc123",
+			Header: []byte("\x89PNG\r\n\x1a\n"), Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "h1", Size: 1024}}},
+	}
+	body, _ := json.Marshal(announce)
+	h.AnnounceFile(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/announce", bytes.NewReader(body)))
+	if file, _ := store.GetFile("abc123"); file.Category != "image" {
+		t.Fatalf("Expected category image, got %q", file.Category)
+	}
+
+	// The file has no owner: the peer that announced it edits it, with its session token
+	sessions := map[string]string{}
+	for _, id := range []string{"owner", "other"} {
+		sessions[id] = h.signer.Sign(access.Token{Kind: access.KindSession, Peer: id, Owner: "user-" + id})
+	}
+	patch := func(handler http.HandlerFunc, req string) (int, protocol.FileLabelsResponse) {
+		var peer struct {
+			PeerID string `json:"peer_id"`
+		}
+		json.Unmarshal([]byte(req), &peer)
+		r := httptest.NewRequest(http.MethodPatch, "/api/files/abc123", bytes.NewReader([]byte(req)))
+		r.SetPathValue("hash", "abc123")
+		if session, ok := sessions[peer.PeerID]; ok {
+			r.Header.Set(protocol.HeaderPeerToken, session)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		var resp protocol.FileLabelsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := patch(h.UpdateFileLabels, `{"peer_id":"owner","category":"Photos","tags":["Summer 2024","beach"]}`)
+	if code != http.StatusOK || resp.Category != "photos" || fmt.Sprint(resp.Tags) != "[summer-2024 beach]" {
+		t.Fatalf("Expected the labels set, got %d %+v", code, resp)
+	}
+	code, resp = patch(h.UpdateFileLabels, `{"peer_id":"owner","add_tags":["sea","beach"],"remove_tags":["Summer 2024"]}`)
+	if code != http.StatusOK || resp.Category != "photos" || fmt.Sprint(resp.Tags) != "[beach sea]" {
+		t.Errorf("Expected tags beach and sea, got %d %+v", code, resp)
+	}
+	if code, _ := patch(h.UpdateFileLabels, `{"peer_id":"other","tags":[]}`); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another peer, got %d", code)
+	}
+	// Claiming the ID of the announcing peer is not enough
+	ownerSession := sessions["owner"]
+	delete(sessions, "owner")
+	if code, _ := patch(h.UpdateFileLabels, `{"peer_id":"owner","tags":[]}`); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without the session of the announcing peer, got %d", code)
+	}
+	sessions["owner"] = sessions["other"]
+	if code, _ := patch(h.UpdateFileLabels, `{"peer_id":"owner","tags":[]}`); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 with the session of another peer, got %d", code)
+	}
+	sessions["owner"] = ownerSession
+	if code, _ := patch(h.UpdateFileLabels, `{"peer_id":"owner","add_tags":["a,b"]}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid tag, got %d", code)
+	}
+	// An admin edits any file; an empty category goes back to the detected one
+	code, resp = patch(h.AdminUpdateFileLabels, `{"category":""}`)
+	if code != http.StatusOK || resp.Category != "other" || len(resp.Tags) != 2 {
+		t.Errorf("Expected category other and the tags kept, got %d %+v", code, resp)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	w := httptest.NewRecorder()
+	h.ListTags(w, r)
+	var tags struct{ Tags []storage.TagStats }
+	json.NewDecoder(w.Body).Decode(&tags)
+	if len(tags.Tags) != 2 || tags.Tags[0] != (storage.TagStats{Tag: "beach", FileCount: 1}) {
+		t.Errorf("Expected tags beach and sea, got %+v", tags.Tags)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/tags/Sea/files", nil)
+	r.SetPathValue("tag", "Sea")
+	w = httptest.NewRecorder()
+	h.ListFilesByTag(w, r)
+	var files protocol.ListFilesResponse
+	json.NewDecoder(w.Body).Decode(&files)
+	if w.Code != http.StatusOK || len(files.Files) != 1 || files.Files[0].Hash != "abc123" {
+		t.Errorf("Expected the file listed under its tag, got %d %+v", w.Code, files)
+	}
+}
+
+func TestPrivateFiles(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	h := NewHandler(store)
+
+	// call runs a handler with a JSON body and the session token of a peer
+	call := func(handler http.HandlerFunc, method, target, session string, req any, resp any) int {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(method, target, bytes.NewReader(body))
+		r.SetPathValue("hash", "secret1")
+		r.SetPathValue("group", "team")
+		if session != "" {
+			r.Header.Set(protocol.HeaderPeerToken, session)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if resp != nil {
+			json.NewDecoder(w.Body).Decode(resp)
+		}
+		return w.Code
+	}
+	// Each peer registers a key of its own, which goes into its grants
+	peerKeys := make(map[string]string)
+	register := func(peerID, ownerToken string) protocol.RegisterResponse {
+		pub, _, _ := ed25519.GenerateKey(nil)
+		peerKeys[peerID] = base64.StdEncoding.EncodeToString(pub)
+		var resp protocol.RegisterResponse
+		call(h.RegisterPeer, http.MethodPost, "/api/peers/register", "",
+			protocol.RegisterRequest{PeerID: peerID, IP: "10.0.0.1", Port: 6881, OwnerToken: ownerToken, PeerKey: peerKeys[peerID]}, &resp)
+		return resp
+	}
+	owner := register("owner", strings.Repeat("a", 64))
+	member := register("member", strings.Repeat("b", 64))
+	if owner.SessionToken == "" || owner.OwnerID != access.OwnerID(strings.Repeat("a", 64)) || owner.AccessKey == "" {
+		t.Fatalf("Expected a session, owner ID and access key, got %+v", owner)
+	}
+	if resp := register("stranger", ""); resp.SessionToken != "" || resp.AccessKey == "" {
+		t.Errorf("Expected an access key only without owner token, got %+v", resp)
+	}
+	badKey := protocol.RegisterRequest{PeerID: "bad", IP: "10.0.0.1", Port: 6881, PeerKey: "not a key"}
+	if code := call(h.RegisterPeer, http.MethodPost, "/api/peers/register", "", badKey, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid peer key, got %d", code)
+	}
+
+	announce := protocol.AnnounceRequest{
+		PeerID: "owner",
+		File: protocol.FileMetadata{Name: "report.pdf", Size: 1024, Hash: "secret1", Visibility: access.Private,
+			Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "h1", Size: 1024}}},
+	}
+	if code := call(h.AnnounceFile, http.MethodPost, "/api/files/announce", "", announce, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a private file without session, got %d", code)
+	}
+	if code := call(h.AnnounceFile, http.MethodPost, "/api/files/announce", owner.SessionToken, announce, nil); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the owner, got %d", code)
+	}
+
+	var list protocol.ListFilesResponse
+	call(h.ListFiles, http.MethodGet, "/api/files", "", nil, &list)
+	if len(list.Files) != 0 {
+		t.Errorf("Expected the private file not listed, got %+v", list.Files)
+	}
+
+	getPeers := func(target, session string) (int, protocol.GetPeersResponse) {
+		var resp protocol.GetPeersResponse
+		code := call(h.GetFilePeers, http.MethodGet, target, session, nil, &resp)
+		return code, resp
+	}
+	if code, _ := getPeers("/api/files/secret1/peers", ""); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without access, got %d", code)
+	}
+	if code, _ := getPeers("/api/files/secret1/peers?peer_id=member", owner.SessionToken); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 with the session of another peer, got %d", code)
+	}
+	code, resp := getPeers("/api/files/secret1/peers?peer_id=owner", owner.SessionToken)
+	if code != http.StatusOK || resp.Visibility != access.Private {
+		t.Fatalf("Expected the owner to get the peers, got %d %+v", code, resp)
+	}
+	key, _ := access.ParsePublicKey(owner.AccessKey)
+	if grant, err := access.Verify(resp.AccessToken, access.KindGrant, []ed25519.PublicKey{key}); err != nil || grant.File != "secret1" || grant.Peer != "owner" || grant.Key != peerKeys["owner"] {
+		t.Errorf("Expected a grant for the owner and its key, got %+v %v", grant, err)
+	}
+
+	// Members of the groups of the file get its peers
+	if code := call(h.AdminSetGroup, http.MethodPut, "/api/admin/groups/team", "", map[string][]string{"members": {member.OwnerID}}, nil); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the group, got %d", code)
+	}
+	setAccess := protocol.FileAccessRequest{PeerID: "member", Visibility: access.Private, Groups: []string{"Team"}}
+	if code := call(h.SetFileAccess, http.MethodPut, "/api/files/secret1/access", member.SessionToken, setAccess, nil); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a peer without access, got %d", code)
+	}
+	setAccess.PeerID = "owner"
+	if code := call(h.SetFileAccess, http.MethodPut, "/api/files/secret1/access", owner.SessionToken, setAccess, nil); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the owner, got %d", code)
+	}
+	if code, _ := getPeers("/api/files/secret1/peers?peer_id=member", member.SessionToken); code != http.StatusOK {
+		t.Errorf("Expected status 200 for a group member, got %d", code)
+	}
+	setAccess.PeerID = "member"
+	if code := call(h.SetFileAccess, http.MethodPut, "/api/files/secret1/access", member.SessionToken, setAccess, nil); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a member that does not own the file, got %d", code)
+	}
+
+	// Anyone holding a share token gets the peers, until the shares are revoked
+	var share protocol.ShareResponse
+	if code := call(h.CreateShare, http.MethodPost, "/api/files/secret1/shares", owner.SessionToken, protocol.ShareRequest{PeerID: "owner", TTLSecs: 3600}, &share); code != http.StatusOK || share.ExpiresAt.IsZero() {
+		t.Fatalf("Expected a share token, got %d %+v", code, share)
+	}
+	if code, resp := getPeers("/api/files/secret1/peers?peer_id=stranger&share="+share.Token, ""); code != http.StatusOK || resp.AccessToken != "" {
+		t.Errorf("Expected status 200 with a share token, and no grant without session, got %d %q", code, resp.AccessToken)
+	}
+	if code, resp := getPeers("/api/files/secret1/peers?peer_id=member&share="+share.Token, member.SessionToken); code != http.StatusOK || resp.AccessToken == "" {
+		t.Errorf("Expected a grant with a share token and the session of the peer, got %d %+v", code, resp)
+	}
+	if code := call(h.RevokeShares, http.MethodDelete, "/api/files/secret1/shares?peer_id=owner", owner.SessionToken, nil, nil); code != http.StatusOK {
+		t.Fatalf("Expected status 200 revoking the shares, got %d", code)
+	}
+	if code, _ := getPeers("/api/files/secret1/peers?peer_id=stranger&share="+share.Token, ""); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 with a revoked share token, got %d", code)
+	}
+
+	// A public file is listed again
+	if code := call(h.AdminSetFileAccess, http.MethodPut, "/api/admin/files/secret1/access", "", protocol.FileAccessRequest{Visibility: access.Public}, nil); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the admin, got %d", code)
+	}
+	call(h.ListFiles, http.MethodGet, "/api/files", "", nil, &list)
+	if len(list.Files) != 1 {
+		t.Errorf("Expected the public file listed, got %+v", list.Files)
+	}
+}
+
+func TestWithdrawFile(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	h := NewHandler(store)
+
+	register := func(peerID, ownerToken string) string {
+		body, _ := json.Marshal(protocol.RegisterRequest{PeerID: peerID, IP: "10.0.0.1", Port: 6881, OwnerToken: ownerToken})
+		w := httptest.NewRecorder()
+		h.RegisterPeer(w, httptest.NewRequest(http.MethodPost, "/api/peers/register", bytes.NewReader(body)))
+		var resp protocol.RegisterResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.SessionToken
+	}
+	seeder := register("seeder", strings.Repeat("a", 64))
+	other := register("other", strings.Repeat("b", 64))
+
+	body, _ := json.Marshal(protocol.AnnounceRequest{
+		PeerID: "seeder",
+		File:   protocol.FileMetadata{Name: "file.bin", Size: 1024, Hash: "file1", Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "h1", Size: 1024}}},
+	})
+	h.AnnounceFile(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/files/announce", bytes.NewReader(body)))
+
+	withdraw := func(session string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/api/files/file1/peers/seeder", nil)
+		r.SetPathValue("hash", "file1")
+		r.SetPathValue("peer_id", "seeder")
+		if session != "" {
+			r.Header.Set(protocol.HeaderPeerToken, session)
+		}
+		w := httptest.NewRecorder()
+		h.WithdrawFile(w, r)
+		return w.Code
+	}
+
+	if code := withdraw(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a session, got %d", code)
+	}
+	if code := withdraw("forged"); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 with an invalid session, got %d", code)
+	}
+	if code := withdraw(other); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another peer, got %d", code)
+	}
+	if peers := store.GetPeersForFile("file1", "", 0); len(peers) != 1 {
+		t.Fatalf("Expected the seeder still in the swarm, got %v", peers)
+	}
+
+	if code := withdraw(seeder); code != http.StatusOK {
+		t.Errorf("Expected status 200 for the peer itself, got %d", code)
+	}
+	if peers := store.GetPeersForFile("file1", "", 0); len(peers) != 0 {
+		t.Errorf("Expected the seeder withdrawn, got %v", peers)
+	}
+}
+
+func TestPeerPolicy(t *testing.T) {
+	swarm := func() []models.SwarmPeer {
+		var peers []models.SwarmPeer
+		add := func(id, ip string, seeder bool, reputation float64) {
+			peers = append(peers, models.SwarmPeer{
+				PeerFileInfo: protocol.PeerFileInfo{PeerInfo: protocol.PeerInfo{PeerID: id, IP: ip}, IsSeeder: seeder},
+				Reputation:   reputation,
+			})
+		}
+		add("me", "10.0.0.5", false, 50)
+		add("seed-remote", "203.0.113.7", true, 90)
+		add("seed-local", "10.0.0.9", true, 50)
+		add("seed-poor", "198.51.100.1", true, 10)
+		add("leech-1", "198.51.100.2", false, 50)
+		add("leech-2", "198.51.100.3", false, 40)
+		return peers
+	}
+	ids := func(peers []protocol.PeerFileInfo) []string {
+		var ids []string
+		for _, p := range peers {
+			ids = append(ids, p.PeerID)
+		}
+		return ids
+	}
+
+	// Without randomness, locality outweighs reputation
+	policy := PeerPolicy{SeederRatio: 0.5, ReputationWeight: 0.3, LocalityWeight: 0.5, SubnetBits: 24}
+	got := ids(policy.Select(swarm(), peerRequest{PeerID: "me", IP: "10.0.0.5"}))
+	if want := []string{"seed-local", "seed-remote", "leech-1", "leech-2", "seed-poor"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Half the list is seeders, the best ones
+	got = ids(policy.Select(swarm(), peerRequest{PeerID: "me", IP: "10.0.0.5", NumWant: 4}))
+	if want := []string{"seed-local", "seed-remote", "leech-1", "leech-2"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v with numwant 4, got %v", want, got)
+	}
+
+	// Leechers fill the share of missing seeders, and the other way round
+	policy.SeederRatio = 1
+	got = ids(policy.Select(swarm(), peerRequest{PeerID: "me", NumWant: 5}))
+	if want := []string{"seed-remote", "seed-local", "leech-1", "leech-2", "seed-poor"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected 3 seeders and 2 leechers, got %v", got)
+	}
+
+	// A seeder only gets leechers
+	got = ids(policy.Select(swarm(), peerRequest{PeerID: "seed-local"}))
+	if want := []string{"me", "leech-1", "leech-2"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected the leechers for a seeder, got %v", got)
+	}
+
+	// Randomness spreads the first place over equal seeders
+	policy = DefaultPeerPolicy()
+	first := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		var peers []models.SwarmPeer
+		for j := 0; j < 5; j++ {
+			peers = append(peers, models.SwarmPeer{
+				PeerFileInfo: protocol.PeerFileInfo{PeerInfo: protocol.PeerInfo{PeerID: fmt.Sprintf("seed-%d", j)}, IsSeeder: true},
+				Reputation:   50,
+			})
+		}
+		first[policy.Select(peers, peerRequest{NumWant: 1})[0].PeerID] = true
+	}
+	if len(first) < 3 {
+		t.Errorf("Expected the first peer to vary, got %v", first)
+	}
+
+	if n := policy.numWant(-1); n != 50 {
+		t.Errorf("Expected the default numwant 50, got %d", n)
+	}
+	if n := policy.numWant(0); n != 200 {
+		t.Errorf("Expected numwant 0 capped at 200, got %d", n)
+	}
+}
+
+func TestAnnounceEventsAndScrape(t *testing.T) {
+	h := setupTestHandler()
+
+	// post runs a handler with a JSON body and the session token of a peer
+	post := func(handler http.HandlerFunc, target, session string, req any, resp any) int {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		if session != "" {
+			r.Header.Set(protocol.HeaderPeerToken, session)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if resp != nil {
+			json.NewDecoder(w.Body).Decode(resp)
+		}
+		return w.Code
+	}
+	sessions := make(map[string]string)
+	for i, id := range []string{"seeder", "leecher"} {
+		var resp protocol.RegisterResponse
+		post(h.RegisterPeer, "/api/peers/register", "", protocol.RegisterRequest{PeerID: id, IP: "10.0.0.1", Port: 6881, OwnerToken: strings.Repeat(string(rune('a'+i)), 64)}, &resp)
+		sessions[id] = resp.SessionToken
+	}
+	post(h.AnnounceFile, "/api/files/announce", "", protocol.AnnounceRequest{
+		PeerID: "seeder",
+		File: protocol.FileMetadata{Name: "release.iso", Size: 2048, Hash: "release1", BTInfoHash: strings.Repeat("ab", 20),
+			Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "h1", Size: 1024}, {Index: 1, Hash: "h2", Size: 1024}}},
+	}, nil)
+
+	availability := func(event string, chunks protocol.ChunkSet, downloaded int64) int {
+		return post(h.ReportAvailability, "/api/files/availability", sessions["leecher"], protocol.AvailabilityRequest{
+			PeerID: "leecher", FileHash: "release1", Chunks: chunks, Full: true, Event: event,
+			Transfer: protocol.Transfer{Downloaded: downloaded, Left: 2048 - downloaded},
+		}, nil)
+	}
+	if code := availability("finished", protocol.ChunkSet{}, 0); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown event, got %d", code)
+	}
+	availability(protocol.AnnounceStarted, protocol.ChunkSet{}, 0)
+	availability("", protocol.NewChunkSet(0), 1024)
+	post(h.Heartbeat, "/api/peers/heartbeat", sessions["seeder"], protocol.HeartbeatRequest{
+		PeerID: "seeder", Transfers: map[string]protocol.Transfer{"release1": {Uploaded: 1024}},
+	}, nil)
+
+	scrape := func(target string) protocol.ScrapeResponse {
+		var resp protocol.ScrapeResponse
+		w := httptest.NewRecorder()
+		h.Scrape(w, httptest.NewRequest(http.MethodGet, target, nil))
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	got := scrape("/api/scrape?hash=release1,unknown")
+	want := protocol.ScrapeFile{Name: "release.iso", Complete: 1, Incomplete: 1, Downloaders: 1, BytesUploaded: 1024, BytesDownloaded: 1024}
+	if len(got.Files) != 1 || got.Files["release1"] != want {
+		t.Errorf("Expected %+v for release1 only, got %+v", want, got.Files)
+	}
+
+	// Getting every chunk completes the download, without an event
+	availability("", protocol.NewChunkSet(1), 2048)
+	availability(protocol.AnnounceCompleted, protocol.FullChunkSet(2), 2048)
+	got = scrape("/api/scrape?hash=" + strings.Repeat("ab", 20))
+	if f := got.Files[strings.Repeat("ab", 20)]; f.Complete != 2 || f.Incomplete != 0 || f.Downloaded != 1 || f.BytesDownloaded != 2048 {
+		t.Errorf("Expected 2 seeders and 1 completed download by info hash, got %+v", got.Files)
+	}
+
+	// Events need the session of the peer too
+	stopped := protocol.AvailabilityRequest{PeerID: "leecher", FileHash: "release1", Event: protocol.AnnounceStopped}
+	if code := post(h.ReportAvailability, "/api/files/availability", sessions["seeder"], stopped, nil); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a stopped event of another peer, got %d", code)
+	}
+	if f := scrape("/api/scrape?hash=release1").Files["release1"]; f.Complete != 2 {
+		t.Errorf("Expected the leecher kept in the swarm, got %+v", f)
+	}
+
+	// A stopped peer leaves the swarm, its completion is still counted
+	availability(protocol.AnnounceStopped, protocol.ChunkSet{}, 2048)
+	var resp protocol.ScrapeResponse
+	post(h.Scrape, "/api/scrape", "", protocol.ScrapeRequest{Hashes: []string{"release1"}}, &resp)
+	if f := resp.Files["release1"]; f.Complete != 1 || f.Downloaded != 1 {
+		t.Errorf("Expected 1 seeder and 1 completed download after stop, got %+v", f)
+	}
+
+	// Joining again and completing again is not another completed download
+	availability(protocol.AnnounceStarted, protocol.FullChunkSet(2), 2048)
+	availability(protocol.AnnounceCompleted, protocol.FullChunkSet(2), 2048)
+	if f := scrape("/api/scrape?hash=release1").Files["release1"]; f.Complete != 2 || f.Downloaded != 1 {
+		t.Errorf("Expected the completion counted once after rejoining, got %+v", f)
+	}
+
+	w := httptest.NewRecorder()
+	h.Scrape(w, httptest.NewRequest(http.MethodGet, "/api/scrape", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without hashes, got %d", w.Code)
+	}
+}
+
+func TestReportAvailability_Access(t *testing.T) {
+	h := setupTestHandler()
+
+	register := func(peerID, ownerToken string) string {
+		body, _ := json.Marshal(protocol.RegisterRequest{PeerID: peerID, IP: "10.0.0.1", Port: 6881, OwnerToken: ownerToken})
+		w := httptest.NewRecorder()
+		h.RegisterPeer(w, httptest.NewRequest(http.MethodPost, "/api/peers/register", bytes.NewReader(body)))
+		var resp protocol.RegisterResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.SessionToken
+	}
+	owner := register("owner", strings.Repeat("a", 64))
+	stranger := register("stranger", strings.Repeat("b", 64))
+
+	chunks := []protocol.ChunkInfo{{Index: 0, Hash: "h1", Size: 1024}}
+	for _, file := range []protocol.FileMetadata{
+		{Name: "public.bin", Size: 1024, Hash: "public1", Chunks: chunks},
+		{Name: "secret.bin", Size: 1024, Hash: "secret1", Chunks: chunks, Visibility: access.Private},
+	} {
+		body, _ := json.Marshal(protocol.AnnounceRequest{PeerID: "owner", File: file})
+		r := httptest.NewRequest(http.MethodPost, "/api/files/announce", bytes.NewReader(body))
+		r.Header.Set(protocol.HeaderPeerToken, owner)
+		h.AnnounceFile(httptest.NewRecorder(), r)
+	}
+
+	report := func(peerID, fileHash, session string) int {
+		body, _ := json.Marshal(protocol.AvailabilityRequest{PeerID: peerID, FileHash: fileHash, Full: true})
+		r := httptest.NewRequest(http.MethodPost, "/api/files/availability", bytes.NewReader(body))
+		if session != "" {
+			r.Header.Set(protocol.HeaderPeerToken, session)
+		}
+		w := httptest.NewRecorder()
+		h.ReportAvailability(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		name             string
+		peerID, fileHash string
+		session          string
+		want             int
+	}{
+		{"no session", "owner", "public1", "", http.StatusUnauthorized},
+		{"another peer's session", "owner", "public1", stranger, http.StatusForbidden},
+		{"private file of another owner", "stranger", "secret1", stranger, http.StatusNotFound},
+		{"own session", "stranger", "public1", stranger, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := report(tt.peerID, tt.fileHash, tt.session); code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, code)
+		}
+	}
+
+	// The seeder was not demoted by the rejected empty report
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/files/public1/peers", nil)
+	r.SetPathValue("hash", "public1")
+	h.GetFilePeers(w, r)
+	var resp protocol.GetPeersResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	for _, p := range resp.Peers {
+		if p.PeerID == "owner" && !p.IsSeeder {
+			t.Errorf("Expected the owner still a seeder, got %+v", p)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
@@ -19,6 +20,7 @@ func TestFullWorkflow(t *testing.T) {
 	defer ts.Close()
 
 	client := ts.Client()
+	var leecherSession string
 
 	// Step 1: Register Peer 1 (Seeder)
 	t.Run("Register Seeder", func(t *testing.T) {
@@ -79,9 +81,10 @@ func TestFullWorkflow(t *testing.T) {
 	// Step 3: Register Peer 2 (Leecher)
 	t.Run("Register Leecher", func(t *testing.T) {
 		req := protocol.RegisterRequest{
-			PeerID: "leecher-1",
-			IP:     "192.168.1.20",
-			Port:   6882,
+			PeerID:     "leecher-1",
+			IP:         "192.168.1.20",
+			Port:       6882,
+			OwnerToken: strings.Repeat("b", 64),
 		}
 		body, _ := json.Marshal(req)
 
@@ -94,6 +97,9 @@ func TestFullWorkflow(t *testing.T) {
 		if resp.StatusCode != http.StatusOK {
 			t.Errorf("Expected 200, got %d", resp.StatusCode)
 		}
+		var regResp protocol.RegisterResponse
+		json.NewDecoder(resp.Body).Decode(&regResp)
+		leecherSession = regResp.SessionToken
 	})
 
 	// Step 4: Leecher lists available files
@@ -142,6 +148,80 @@ func TestFullWorkflow(t *testing.T) {
 		if peersResp.ChunkCount != 4 {
 			t.Errorf("Expected 4 chunks, got %d", peersResp.ChunkCount)
 		}
+
+		if a := peersResp.Peers[0].Availability; a == nil || !a.Complete(4) {
+			t.Errorf("Expected the seeder to have all 4 chunks, got %v", a)
+		}
+	})
+
+	// Step 5b: Seeders' availability can be left out, the peer list capped
+	t.Run("Get Peers Without Seeder Availability", func(t *testing.T) {
+		resp, err := client.Get(ts.URL + "/api/files/abc123def456789/peers?numwant=1&chunks=leechers")
+		if err != nil {
+			t.Fatalf("Failed to get peers: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var peersResp protocol.GetPeersResponse
+		json.NewDecoder(resp.Body).Decode(&peersResp)
+
+		if len(peersResp.Peers) != 1 || peersResp.Peers[0].Availability != nil {
+			t.Errorf("Expected 1 peer without availability, got %+v", peersResp.Peers)
+		}
+
+		resp, err = client.Get(ts.URL + "/api/files/abc123def456789/peers?chunks=some")
+		if err != nil {
+			t.Fatalf("Failed to get peers: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown chunks value, got %d", resp.StatusCode)
+		}
+	})
+
+	// Step 5c: A leecher reports the chunks it downloaded so far
+	t.Run("Report Availability", func(t *testing.T) {
+		report := func(req protocol.AvailabilityRequest) (*protocol.AvailabilityResponse, int) {
+			body, _ := json.Marshal(req)
+			httpReq, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/files/availability", bytes.NewReader(body))
+			httpReq.Header.Set("Content-Type", "application/json")
+			httpReq.Header.Set(protocol.HeaderPeerToken, leecherSession)
+			resp, err := client.Do(httpReq)
+			if err != nil {
+				t.Fatalf("Failed to report availability: %v", err)
+			}
+			defer resp.Body.Close()
+			var r protocol.AvailabilityResponse
+			json.NewDecoder(resp.Body).Decode(&r)
+			return &r, resp.StatusCode
+		}
+
+		r, status := report(protocol.AvailabilityRequest{PeerID: "leecher-1", FileHash: "abc123def456789", Chunks: protocol.NewChunkSet(0, 1)})
+		if status != http.StatusOK || r.Chunks != 2 || r.IsSeeder {
+			t.Errorf("Expected 2 chunks as a leecher, got %d %+v", status, r)
+		}
+		if _, status := report(protocol.AvailabilityRequest{PeerID: "leecher-1", FileHash: "abc123def456789", Chunks: protocol.NewChunkSet(4)}); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for chunk 4 of a 4-chunk file, got %d", status)
+		}
+		if _, status := report(protocol.AvailabilityRequest{PeerID: "ghost", FileHash: "abc123def456789"}); status != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown peer, got %d", status)
+		}
+
+		resp, err := client.Get(ts.URL + "/api/files")
+		if err != nil {
+			t.Fatalf("Failed to list files: %v", err)
+		}
+		defer resp.Body.Close()
+		var listResp protocol.ListFilesResponse
+		json.NewDecoder(resp.Body).Decode(&listResp)
+		if len(listResp.Files) != 1 || listResp.Files[0].Seeders != 1 || listResp.Files[0].Leechers != 1 {
+			t.Errorf("Expected 1 seeder and 1 leecher, got %+v", listResp.Files)
+		}
+
+		r, _ = report(protocol.AvailabilityRequest{PeerID: "leecher-1", FileHash: "abc123def456789", Chunks: protocol.NewChunkSet(2, 3)})
+		if r.Chunks != 4 || !r.IsSeeder {
+			t.Errorf("Expected the leecher to become a seeder, got %+v", r)
+		}
 	})
 
 	// Step 6: Health check
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/filetype"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
+)
+
+// Limits of the labels of a file
+const (
+	maxLabelLength = 50
+	maxFileTags    = 20
+)
+
+// normalizeLabel returns a category or tag in the form it is stored: lower
+// case, words joined by "-". Labels hold letters, digits and "-_.+" only, so
+// that they can be listed comma-separated and put in a URL path.
+func normalizeLabel(label string) (string, error) {
+	label = strings.Join(strings.Fields(strings.ToLower(label)), "-")
+	switch {
+	case label == "":
+		return "", errors.New("empty label")
+	case len(label) > maxLabelLength:
+		return "", fmt.Errorf("label %q is longer than %d bytes", label, maxLabelLength)
+	}
+	for _, r := range label {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.+", r) {
+			return "", fmt.Errorf("label %q has an invalid character %q", label, r)
+		}
+	}
+	return label, nil
+}
+
+// applyTagEdits returns the tags of a file after a FileLabelsRequest,
+// normalized and without duplicates
+func applyTagEdits(tags []string, req *protocol.FileLabelsRequest) ([]string, error) {
+	if req.Tags != nil {
+		tags = *req.Tags
+	}
+	tags = append(slices.Clone(tags), req.AddTags...)
+
+	remove := make([]string, 0, len(req.RemoveTags))
+	for _, tag := range req.RemoveTags {
+		tag, err := normalizeLabel(tag)
+		if err != nil {
+			return nil, err
+		}
+		remove = append(remove, tag)
+	}
+
+	result := []string{}
+	for _, tag := range tags {
+		tag, err := normalizeLabel(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(result, tag) && !slices.Contains(remove, tag) {
+			result = append(result, tag)
+		}
+	}
+	if len(result) > maxFileTags {
+		return nil, fmt.Errorf("a file has at most %d tags", maxFileTags)
+	}
+	return result, nil
+}
+
+// UpdateFileLabels handles PATCH /api/files/{hash}, by which the owner of a
+// file edits its category and tags. A file without an owner identity is
+// edited by the peer that announced it first.
+func (h *Handler) UpdateFileLabels(w http.ResponseWriter, r *http.Request) {
+	h.updateFileLabels(w, r, false)
+}
+
+// AdminUpdateFileLabels handles PATCH /api/admin/files/{hash}, which edits
+// the category and tags of any file
+func (h *Handler) AdminUpdateFileLabels(w http.ResponseWriter, r *http.Request) {
+	h.updateFileLabels(w, r, true)
+}
+
+func (h *Handler) updateFileLabels(w http.ResponseWriter, r *http.Request, admin bool) {
+	var req protocol.FileLabelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	file, ok := h.storage.GetFile(r.PathValue("hash"))
+	if !ok || (!admin && !h.canAccess(r, file, h.identify(r, req.PeerID))) {
+		sendError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !admin {
+		if req.PeerID == "" {
+			sendError(w, http.StatusBadRequest, "Peer ID is required")
+			return
+		}
+		if _, ok := h.storage.GetPeer(req.PeerID); !ok {
+			sendError(w, http.StatusNotFound, "Unknown peer, register again")
+			return
+		}
+		if file.OwnerID != "" && h.identify(r, req.PeerID) != file.OwnerID {
+			sendError(w, http.StatusForbidden, "Only the owner of the file can edit it")
+			return
+		}
+		// Without an owner, the peer that announced the file must still prove
+		// its ID with its session token
+		if file.OwnerID == "" && (req.PeerID != file.AddedBy || h.identify(r, req.PeerID) == "") {
+			sendError(w, http.StatusForbidden, "Only the peer that announced the file can edit it")
+			return
+		}
+	}
+
+	category := file.Category
+	if req.Category != nil {
+		category = filetype.ByExtension(file.Name)
+		if *req.Category != "" {
+			var err error
+			if category, err = normalizeLabel(*req.Category); err != nil {
+				sendError(w, http.StatusBadRequest, "Invalid category: "+err.Error())
+				return
+			}
+		}
+	}
+	tags, err := applyTagEdits(file.Tags, &req)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid tags: "+err.Error())
+		return
+	}
+
+	if err := h.storage.UpdateFileLabels(file.Hash, category, tags); err != nil {
+		if errors.Is(err, storage.ErrFileNotFound) {
+			sendError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		sendError(w, http.StatusInternalServerError, "Failed to update file")
+		return
+	}
+
+	if file.Visibility == "" || file.Visibility == access.Public {
+		h.broadcastEvent(EventFileUpdated, map[string]interface{}{
+			"hash":     file.Hash,
+			"category": category,
+			"tags":     tags,
+		})
+	}
+
+	sendJSON(w, http.StatusOK, protocol.FileLabelsResponse{
+		Success:  true,
+		Hash:     file.Hash,
+		Category: category,
+		Tags:     tags,
+	})
+}
+
+// ListTags handles GET /api/tags?limit=100, the most used tags with their
+// number of files
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		l, err := parseInt(s)
+		if err != nil || l < 1 || l > 1000 {
+			sendError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = l
+	}
+
+	tags := h.storage.ListTags(limit)
+	if tags == nil {
+		tags = []storage.TagStats{}
+	}
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"count": len(tags),
+		"tags":  tags,
+	})
+}
+
+// ListFilesByTag handles GET /api/tags/{tag}/files. It takes the parameters
+// of ListFiles, the tag being added to those of the tag parameter.
+func (h *Handler) ListFilesByTag(w http.ResponseWriter, r *http.Request) {
+	tag, err := normalizeLabel(r.PathValue("tag"))
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid tag: "+err.Error())
+		return
+	}
+
+	query, err := parseFileQuery(r.URL.Query())
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Tags = append(query.Tags, tag)
+	page, ok := h.listFiles(w, query)
+	if !ok {
+		return
+	}
+	sendFilePage(w, page, "tag", tag)
+}
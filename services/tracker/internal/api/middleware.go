@@ -58,11 +58,15 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Allow public read access for file listing and peer discovery (for download clients)
-		if r.Method == http.MethodGet {
-			if r.URL.Path == "/api/files" ||
-				strings.HasPrefix(r.URL.Path, "/api/files/") ||
-				r.URL.Path == "/api/files/search" {
+		// Allow public read access for file listing, peer discovery, swarm
+		// statistics (for download clients) and the OpenAPI document, under
+		// both API prefixes
+		if path := apiPath(r.URL.Path); r.Method == http.MethodGet {
+			if path == "/api/files" ||
+				strings.HasPrefix(path, "/api/files/") ||
+				path == "/api/files/search" ||
+				path == "/api/scrape" ||
+				path == "/api/openapi.yaml" {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -75,7 +79,7 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		}
 
 		if apiKey == "" || !am.apiKeys[apiKey] {
-			http.Error(w, `{"error":"unauthorized","message":"Invalid or missing API key"}`, http.StatusUnauthorized)
+			sendJSON(w, http.StatusUnauthorized, apiError{Error: "unauthorized", Message: "Invalid or missing API key"})
 			return
 		}
 
@@ -204,7 +208,7 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", rl.rate))
 			w.Header().Set("X-RateLimit-Remaining", "0")
 			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(rl.window.Seconds())))
-			http.Error(w, `{"error":"rate_limit_exceeded","message":"Too many requests"}`, http.StatusTooManyRequests)
+			sendJSON(w, http.StatusTooManyRequests, apiError{Error: "rate_limit_exceeded", Message: "Too many requests"})
 			return
 		}
 
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/openapi"
+)
+
+// openapiYAML describes every endpoint of the tracker. pkg/trackerapi is
+// generated from it; TestOpenAPIRoutes checks it against SetupRoutes.
+//
+//go:embed openapi.yaml
+var openapiYAML []byte
+
+// apiSpec is the parsed openapiYAML
+var apiSpec = mustParseSpec()
+
+func mustParseSpec() *openapi.Document {
+	doc, err := openapi.Parse(openapiYAML)
+	if err != nil {
+		panic(fmt.Sprintf("api: invalid openapi.yaml: %v", err))
+	}
+	return doc
+}
+
+// maxValidatedBody bounds the request bodies the validator reads
+const maxValidatedBody = 32 << 20
+
+// OpenAPIValidator checks the requests of the API against its OpenAPI
+// document before they reach the handlers, answering 400 to invalid ones.
+// Requests to paths the document does not know, or outside /api, go through
+// unchecked.
+type OpenAPIValidator struct {
+	doc *openapi.Document
+
+	// OnInvalidResponse, when set, has the responses checked too and gets
+	// those that do not match the document
+	OnInvalidResponse func(r *http.Request, err error)
+}
+
+// NewOpenAPIValidator creates a validator of the tracker API
+func NewOpenAPIValidator() *OpenAPIValidator {
+	return &OpenAPIValidator{doc: apiSpec}
+}
+
+// LogInvalidResponses has the responses that do not match the document logged
+func (v *OpenAPIValidator) LogInvalidResponses() {
+	v.OnInvalidResponse = func(r *http.Request, err error) {
+		log.Printf("[OpenAPI] Invalid response to %s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// Middleware returns the validating middleware
+func (v *OpenAPIValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := v.doc.FindRoute(r.Method, r.URL.Path)
+		if !ok || route.Server == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if route.Operation.RequestBody != nil && r.Body != nil {
+			var err error
+			body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidatedBody))
+			if err != nil {
+				v.reject(w, route, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if err := v.doc.ValidateRequest(route, r.URL.Query(), body); err != nil {
+			v.reject(w, route, http.StatusBadRequest, "Invalid request: "+err.Error())
+			return
+		}
+
+		if v.OnInvalidResponse == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if err := v.doc.ValidateResponse(route, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+			v.OnInvalidResponse(r, err)
+		}
+	})
+}
+
+// reject sends an error in the shape of the prefix of the route
+func (v *OpenAPIValidator) reject(w http.ResponseWriter, route *openapi.Route, status int, message string) {
+	if route.Server == apiV2Prefix {
+		sendJSON(w, status, newAPIError(status, message))
+		return
+	}
+	sendError(w, status, message)
+}
+
+// responseRecorder keeps a copy of a response for its validation
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// ServeOpenAPI handles GET /api/openapi.yaml, the OpenAPI document of the API
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openapiYAML)
+}
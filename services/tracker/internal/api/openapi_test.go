@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/pkg/trackerapi"
+)
+
+// TestOpenAPIRoutes checks that openapi.yaml describes every route of
+// SetupRoutes, and nothing else
+func TestOpenAPIRoutes(t *testing.T) {
+	server := NewServer(":0")
+	server.SetupRoutes()
+
+	var described []string
+	for path, item := range apiSpec.Paths {
+		for method, op := range item.Operations() {
+			if op.OperationID == "" {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+			for _, prefix := range apiSpec.Prefixes(item) {
+				described = append(described, method+" "+prefix+path)
+			}
+		}
+	}
+
+	for _, pattern := range server.routes {
+		if !slices.Contains(described, pattern) {
+			t.Errorf("%s is not in openapi.yaml", pattern)
+		}
+	}
+	for _, pattern := range described {
+		if !slices.Contains(server.routes, pattern) {
+			t.Errorf("%s of openapi.yaml is not served", pattern)
+		}
+	}
+}
+
+// newValidatedTracker serves the routes of a tracker behind a validator that
+// fails the test on responses openapi.yaml does not describe
+func newValidatedTracker(t *testing.T) *httptest.Server {
+	t.Helper()
+	validator := NewOpenAPIValidator()
+	validator.OnInvalidResponse = func(r *http.Request, err error) {
+		t.Errorf("Invalid response to %s %s: %v", r.Method, r.URL, err)
+	}
+	ts := httptest.NewServer(validator.Middleware(NewServer(":0").SetupRoutes()))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestOpenAPIClient(t *testing.T) {
+	ts := newValidatedTracker(t)
+	ctx := context.Background()
+
+	for _, prefix := range []string{apiPrefix, apiV2Prefix} {
+		t.Run(prefix, func(t *testing.T) {
+			doer := &trackerapi.HTTPDoer{BaseURL: ts.URL + prefix, Header: http.Header{}}
+			c := trackerapi.NewClient(doer)
+			peerID := "peer" + strings.ReplaceAll(prefix, "/", "-")
+			hash := "hash" + strings.ReplaceAll(prefix, "/", "-")
+
+			reg, err := c.RegisterPeer(ctx, &protocol.RegisterRequest{PeerID: peerID, IP: "10.0.0.1", Port: 6881, OwnerToken: strings.Repeat("a", 64)})
+			if err != nil {
+				t.Fatalf("RegisterPeer() error = %v", err)
+			}
+			doer.Header.Set(protocol.HeaderPeerToken, reg.SessionToken)
+			if _, err := c.Heartbeat(ctx, &protocol.HeartbeatRequest{PeerID: peerID}); err != nil {
+				t.Fatalf("Heartbeat() error = %v", err)
+			}
+			file := protocol.FileMetadata{
+				Name: "song.mp3", Size: 100, Hash: hash, ChunkSize: 100,
+				Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "c0", Size: 100}},
+			}
+			if _, err := c.AnnounceFile(ctx, &protocol.AnnounceRequest{PeerID: peerID, File: file}); err != nil {
+				t.Fatalf("AnnounceFile() error = %v", err)
+			}
+
+			files, err := c.ListFiles(ctx, &trackerapi.ListFilesParams{Limit: 10})
+			if err != nil || files.Count == 0 {
+				t.Fatalf("ListFiles() = %+v, %v", files, err)
+			}
+			if _, err := c.SearchFiles(ctx, &trackerapi.SearchFilesParams{Q: "song"}); err != nil {
+				t.Errorf("SearchFiles() error = %v", err)
+			}
+			if _, err := c.ListTags(ctx, nil); err != nil {
+				t.Errorf("ListTags() error = %v", err)
+			}
+			if _, err := c.ListCategories(ctx); err != nil {
+				t.Errorf("ListCategories() error = %v", err)
+			}
+			if _, err := c.GetFilePeers(ctx, hash, nil); err != nil {
+				t.Errorf("GetFilePeers() error = %v", err)
+			}
+			if _, err := c.Scrape(ctx, &trackerapi.ScrapeParams{Hash: []string{hash}}); err != nil {
+				t.Errorf("Scrape() error = %v", err)
+			}
+			if _, err := c.GetMagnetLink(ctx, hash, nil); err != nil {
+				t.Errorf("GetMagnetLink() error = %v", err)
+			}
+			if _, err := c.AdminListPeers(ctx, nil); err != nil {
+				t.Errorf("AdminListPeers() error = %v", err)
+			}
+			if _, err := c.WithdrawFile(ctx, hash, peerID); err != nil {
+				t.Errorf("WithdrawFile() error = %v", err)
+			}
+			if _, err := c.LeavePeer(ctx, peerID); err != nil {
+				t.Errorf("LeavePeer() error = %v", err)
+			}
+
+			_, err = c.GetFilePeers(ctx, "unknown", nil)
+			var serr *trackerapi.StatusError
+			if !errors.As(err, &serr) || serr.StatusCode != http.StatusNotFound {
+				t.Fatalf("GetFilePeers() of an unknown file error = %v, want 404", err)
+			}
+			if (serr.Code == "not_found") != (prefix == apiV2Prefix) {
+				t.Errorf("GetFilePeers() error code = %q", serr.Code)
+			}
+		})
+	}
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	ts := newValidatedTracker(t)
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/files?limit=abc", ""},
+		{"GET", "/files?min_size=-1", ""},
+		{"POST", "/peers/register", `{"peer_id": "a", "port": "6881"}`},
+		{"POST", "/files/announce", `{"peer_id": "a"}`},
+	}
+	for _, prefix := range []string{apiPrefix, apiV2Prefix} {
+		for _, tt := range tests {
+			req, _ := http.NewRequest(tt.method, ts.URL+prefix+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body map[string]string
+			json.NewDecoder(resp.Body).Decode(&body)
+			resp.Body.Close()
+
+			message := body["error"]
+			if prefix == apiV2Prefix {
+				if body["error"] != "bad_request" {
+					t.Errorf("%s %s%s error = %q, want bad_request", tt.method, prefix, tt.path, body["error"])
+				}
+				message = body["message"]
+			}
+			if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(message, "Invalid request") {
+				t.Errorf("%s %s%s = %d %v, want 400", tt.method, prefix, tt.path, resp.StatusCode, body)
+			}
+		}
+	}
+}
+
+func TestAPIV2(t *testing.T) {
+	ts := newValidatedTracker(t)
+	ctx := context.Background()
+	v1 := trackerapi.NewClient(&trackerapi.HTTPDoer{BaseURL: ts.URL + apiPrefix})
+	v2 := trackerapi.NewClient(&trackerapi.HTTPDoer{BaseURL: ts.URL + apiV2Prefix})
+
+	for _, id := range []string{"c", "a", "b"} {
+		if _, err := v1.RegisterPeer(ctx, &protocol.RegisterRequest{PeerID: id, IP: "10.0.0.1", Port: 6881}); err != nil {
+			t.Fatalf("RegisterPeer() error = %v", err)
+		}
+	}
+	file := protocol.FileMetadata{
+		Name: "movie.mp4", Size: 100, Hash: "abc", ChunkSize: 100,
+		Chunks: []protocol.ChunkInfo{{Index: 0, Hash: "c0", Size: 100}},
+	}
+	if _, err := v1.AnnounceFile(ctx, &protocol.AnnounceRequest{PeerID: "a", File: file}); err != nil {
+		t.Fatalf("AnnounceFile() error = %v", err)
+	}
+
+	t.Run("admin paging", func(t *testing.T) {
+		var ids []string
+		params := &trackerapi.AdminListPeersParams{Limit: 2}
+		for range 3 {
+			page, err := v2.AdminListPeers(ctx, params)
+			if err != nil {
+				t.Fatalf("AdminListPeers() error = %v", err)
+			}
+			for _, p := range page.Peers {
+				ids = append(ids, p.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			params.Cursor = page.NextCursor
+		}
+		if !slices.Equal(ids, []string{"a", "b", "c"}) {
+			t.Errorf("Paged peers = %v, want [a b c]", ids)
+		}
+
+		all, err := v1.AdminListPeers(ctx, &trackerapi.AdminListPeersParams{Limit: 2})
+		if err != nil || len(all.Peers) != 3 || all.NextCursor != "" {
+			t.Errorf("AdminListPeers() on /api = %+v, %v, want every peer", all, err)
+		}
+	})
+
+	t.Run("chunks", func(t *testing.T) {
+		resp, err := v1.GetFilePeers(ctx, "abc", nil)
+		if err != nil || len(resp.Peers) != 1 || resp.Peers[0].Availability == nil {
+			t.Errorf("GetFilePeers() on /api = %+v, %v, want the availability of the seeder", resp, err)
+		}
+		resp, err = v2.GetFilePeers(ctx, "abc", nil)
+		if err != nil || len(resp.Peers) != 1 || resp.Peers[0].Availability != nil {
+			t.Errorf("GetFilePeers() on /api/v2 = %+v, %v, want no availability of seeders", resp, err)
+		}
+	})
+
+	t.Run("file listings", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/api/files/search?q=movie")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v1Body map[string]any
+		json.NewDecoder(resp.Body).Decode(&v1Body)
+		resp.Body.Close()
+		if v1Body["query"] != "movie" {
+			t.Errorf("Search on /api = %v, want the query echoed", v1Body)
+		}
+
+		page, err := v2.SearchFiles(ctx, &trackerapi.SearchFilesParams{Q: "movie"})
+		if err != nil || page.Count != 1 || len(page.Files) != 1 {
+			t.Errorf("SearchFiles() on /api/v2 = %+v, %v", page, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/api/v2/files/unknown/peers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]string
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound || body["error"] != "not_found" || body["message"] == "" {
+			t.Errorf("Error on /api/v2 = %d %v", resp.StatusCode, body)
+		}
+	})
+}
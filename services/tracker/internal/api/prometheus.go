@@ -82,6 +82,14 @@ var (
 		[]string{"direction"}, // "upload" or "download"
 	)
 
+	announceEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "p2p_tracker_announce_events_total",
+			Help: "Total announce events of downloads (started, completed, stopped, paused)",
+		},
+		[]string{"event"},
+	)
+
 	// Relay metrics
 	relayConnectionsActive = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -132,7 +140,7 @@ func PrometheusMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(wrapped, r)
 
 		duration := time.Since(start).Seconds()
-		path := normalizePath(r.URL.Path)
+		path := normalizePath(r.Method, r.URL.Path)
 
 		httpRequestsTotal.WithLabelValues(r.Method, path, strconv.Itoa(wrapped.statusCode)).Inc()
 		httpRequestDuration.WithLabelValues(r.Method, path).Observe(duration)
@@ -150,20 +158,14 @@ func (w *statusResponseWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-// normalizePath normalizes URL paths for metrics
-func normalizePath(path string) string {
-	// Normalize paths with IDs/hashes to prevent cardinality explosion
-	switch {
-	case len(path) > 12 && path[:12] == "/api/peers/":
-		return "/api/peers/{id}"
-	case len(path) > 11 && path[:11] == "/api/files/":
-		if len(path) > 70 { // file hash is 64 chars
-			return "/api/files/{hash}/peers"
-		}
-		return "/api/files/{hash}"
-	default:
-		return path
+// normalizePath normalizes URL paths for metrics. Paths of the API are named
+// after their path in the OpenAPI document, such as
+// /api/v2/files/{hash}/peers, to keep IDs and hashes out of the labels.
+func normalizePath(method, path string) string {
+	if route, ok := apiSpec.FindRoute(method, path); ok {
+		return route.Server + route.Path
 	}
+	return "other"
 }
 
 // MetricsHandler returns the Prometheus metrics handler
@@ -203,6 +205,11 @@ func RecordBytesTransferred(uploaded, downloaded int64) {
 	bytesTransferred.WithLabelValues("download").Add(float64(downloaded))
 }
 
+// IncrementAnnounceEvent increments the counter of an announce event
+func IncrementAnnounceEvent(event string) {
+	announceEvents.WithLabelValues(event).Inc()
+}
+
 // UpdateRelayConnections updates active relay connections
 func UpdateRelayConnections(count int) {
 	relayConnectionsActive.Set(float64(count))
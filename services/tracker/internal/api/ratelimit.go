@@ -95,9 +95,11 @@ type EndpointRateLimiter struct {
 func NewEndpointRateLimiter() *EndpointRateLimiter {
 	return &EndpointRateLimiter{
 		limiters: map[string]*TokenBucketLimiter{
-			"/api/files/announce":  NewTokenBucketLimiter(10, 20), // 10/s for announces
-			"/api/peers/register":  NewTokenBucketLimiter(5, 10),  // 5/s for registrations
-			"/api/peers/heartbeat": NewTokenBucketLimiter(1, 5),   // 1/s for heartbeat
+			"/api/files/announce":     NewTokenBucketLimiter(10, 20), // 10/s for announces
+			"/api/files/availability": NewTokenBucketLimiter(5, 20),  // 5/s for download progress
+			"/api/peers/register":     NewTokenBucketLimiter(5, 10),  // 5/s for registrations
+			"/api/peers/heartbeat":    NewTokenBucketLimiter(1, 5),   // 1/s for heartbeat
+			"/api/scrape":             NewTokenBucketLimiter(5, 10),  // 5/s for batches of swarm statistics
 		},
 		default_: NewTokenBucketLimiter(100, 200), // Default: 100/s
 	}
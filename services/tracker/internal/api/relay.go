@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 )
 
 // Relay message types
@@ -26,6 +29,7 @@ const (
 type RelayMessage struct {
 	Type      string          `json:"type"`
 	From      string          `json:"from,omitempty"`
+	FromKey   string          `json:"from_key,omitempty"` // Peer key in the session of From, empty without a session
 	To        string          `json:"to,omitempty"`
 	RequestID string          `json:"request_id,omitempty"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
@@ -58,6 +62,7 @@ type RelayPeer struct {
 	Conn     *websocket.Conn
 	Send     chan []byte
 	Hub      *RelayHub
+	Key      string // Peer key in its session token, empty if it connected without one
 	LastSeen time.Time
 	mu       sync.Mutex
 }
@@ -87,6 +92,15 @@ func (h *RelayHub) Run() {
 		select {
 		case peer := <-h.register:
 			h.mu.Lock()
+			// A peer with a session is not replaced by a connection without
+			// one, which would get the chunks sent to it
+			if existing, ok := h.peers[peer.ID]; ok && existing.Key != "" && peer.Key == "" {
+				h.mu.Unlock()
+				log.Printf("[Relay] Refused connection without session for peer %s", peer.ID)
+				close(peer.Send)
+				peer.Conn.Close()
+				continue
+			}
 			// Close existing connection if peer reconnects
 			if existing, ok := h.peers[peer.ID]; ok {
 				close(existing.Send)
@@ -249,7 +263,7 @@ func (p *RelayPeer) readPump() {
 			continue
 		}
 
-		msg.From = p.ID
+		msg.From, msg.FromKey = p.ID, p.Key
 		msg.Timestamp = time.Now()
 
 		// Handle ping/pong locally
@@ -307,13 +321,24 @@ func (p *RelayPeer) sendPong(requestID string) {
 	}
 }
 
-// ServeRelay handles relay WebSocket connections
-func ServeRelay(hub *RelayHub, w http.ResponseWriter, r *http.Request) {
+// ServeRelay handles relay WebSocket connections. A peer connecting with its
+// session token in the X-Peer-Token header has the peer key of the session
+// passed along with its messages, for seeders to check its grants against.
+func ServeRelay(hub *RelayHub, w http.ResponseWriter, r *http.Request, session func(*http.Request, string) *access.Token) {
 	peerID := r.URL.Query().Get("peer_id")
 	if peerID == "" {
 		http.Error(w, "peer_id required", http.StatusBadRequest)
 		return
 	}
+	key := ""
+	if r.Header.Get(protocol.HeaderPeerToken) != "" {
+		t := session(r, peerID)
+		if t == nil {
+			http.Error(w, "invalid session token", http.StatusUnauthorized)
+			return
+		}
+		key = t.Key
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -326,6 +351,7 @@ func ServeRelay(hub *RelayHub, w http.ResponseWriter, r *http.Request) {
 		Conn:     conn,
 		Send:     make(chan []byte, 256),
 		Hub:      hub,
+		Key:      key,
 		LastSeen: time.Now(),
 	}
 
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"cmp"
 	"log"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
 )
 
@@ -17,10 +19,16 @@ type ServerConfig struct {
 	Addr           string
 	PostgresURL    string
 	JWTSecret      string
+	AccessSecret   string // Key of the access tokens; JWTSecret if empty
 	APIKeys        []string
 	EnableMetrics  bool
 	RateLimitRPS   float64
 	RateLimitBurst int
+	PeerPolicy     PeerPolicy // How peer lists are selected
+
+	// Whether responses are checked against the OpenAPI document, with the
+	// mismatches logged. Requests always are.
+	ValidateResponses bool
 }
 
 // DefaultServerConfig returns default configuration
@@ -33,10 +41,14 @@ func DefaultServerConfig() ServerConfig {
 		Addr:           ":8080",
 		PostgresURL:    os.Getenv("POSTGRES_URL"),
 		JWTSecret:      os.Getenv("JWT_SECRET"),
+		AccessSecret:   os.Getenv("ACCESS_SECRET"),
 		APIKeys:        apiKeys,
 		EnableMetrics:  true,
 		RateLimitRPS:   100,
 		RateLimitBurst: 200,
+		PeerPolicy:     peerPolicyFromEnv(DefaultPeerPolicy()),
+
+		ValidateResponses: os.Getenv("OPENAPI_VALIDATE_RESPONSES") == "true",
 	}
 }
 
@@ -53,6 +65,8 @@ type Server struct {
 	healthChecker   *HealthChecker
 	wsHub           *WSHub
 	relayHub        *RelayHub
+	validator       *OpenAPIValidator
+	routes          []string // Patterns registered by SetupRoutes
 }
 
 // NewServer creates a new tracker server with in-memory storage
@@ -88,6 +102,7 @@ func NewServerWithConfig(config ServerConfig) *Server {
 	relayHub := NewRelayHub()
 	handler := NewHandler(store)
 	handler.SetWSHub(wsHub)
+	handler.SetPeerPolicy(cmp.Or(config.PeerPolicy, DefaultPeerPolicy()))
 
 	// Setup JWT manager
 	jwtSecret := config.JWTSecret
@@ -96,6 +111,20 @@ func NewServerWithConfig(config ServerConfig) *Server {
 	}
 	jwtManager := NewJWTManager(jwtSecret, "p2p-tracker", 24*time.Hour)
 
+	// Setup the key of access tokens. Peers keep the tokens of a tracker
+	// across its restarts only if it is configured.
+	accessSecret := cmp.Or(config.AccessSecret, config.JWTSecret)
+	if accessSecret == "" {
+		log.Println("[Tracker] No ACCESS_SECRET or JWT_SECRET set, access tokens will not survive restarts")
+		accessSecret = randomSecret()
+	}
+	handler.SetSigner(access.NewSigner(accessSecret))
+
+	validator := NewOpenAPIValidator()
+	if config.ValidateResponses {
+		validator.LogInvalidResponses()
+	}
+
 	return &Server{
 		handler:         handler,
 		storage:         store,
@@ -108,6 +137,7 @@ func NewServerWithConfig(config ServerConfig) *Server {
 		healthChecker:   NewHealthChecker(Version, store, storageType),
 		wsHub:           wsHub,
 		relayHub:        relayHub,
+		validator:       validator,
 	}
 }
 
@@ -119,29 +149,53 @@ func NewServerWithDB(addr, postgresURL string) (*Server, error) {
 	return NewServerWithConfig(config), nil
 }
 
-// SetupRoutes configures all HTTP routes
+// SetupRoutes configures all HTTP routes. Those of the API are served under
+// both /api and /api/v2 (see v2.go), as openapi.yaml describes them.
 func (s *Server) SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
+	s.routes = nil
+	handle := func(pattern string, handler http.Handler) {
+		mux.Handle(pattern, handler)
+		s.routes = append(s.routes, pattern)
+	}
+	api := func(method, path string, handler http.HandlerFunc) {
+		handle(method+" "+apiPrefix+path, handler)
+		handle(method+" "+apiV2Prefix+path, v2(handler))
+	}
 
 	// Peer endpoints
-	mux.HandleFunc("POST /api/peers/register", s.handler.RegisterPeer)
-	mux.HandleFunc("POST /api/peers/heartbeat", s.handler.Heartbeat)
-	mux.HandleFunc("DELETE /api/peers/{peer_id}", s.handler.LeavePeer)
-	mux.HandleFunc("GET /api/peers/top", s.handler.GetTopPeers)
-	mux.HandleFunc("POST /api/peers/stats", s.handler.ReportStats)
+	api("POST", "/peers/register", s.handler.RegisterPeer)
+	api("POST", "/peers/heartbeat", s.handler.Heartbeat)
+	api("DELETE", "/peers/{peer_id}", s.handler.LeavePeer)
+	api("GET", "/peers/top", s.handler.GetTopPeers)
+	api("POST", "/peers/stats", s.handler.ReportStats)
 
 	// File endpoints
-	mux.HandleFunc("POST /api/files/announce", s.handler.AnnounceFile)
-	mux.HandleFunc("GET /api/files", s.handler.ListFiles)
-	mux.HandleFunc("GET /api/files/search", s.handler.SearchFiles)
-	mux.HandleFunc("GET /api/files/{hash}/peers", s.handler.GetFilePeers)
+	api("POST", "/files/announce", s.handler.AnnounceFile)
+	api("DELETE", "/files/{hash}/peers/{peer_id}", s.handler.WithdrawFile)
+	api("POST", "/files/availability", s.handler.ReportAvailability)
+	api("GET", "/files", s.handler.ListFiles)
+	api("GET", "/files/search", s.handler.SearchFiles)
+	api("GET", "/files/{hash}/peers", s.handler.GetFilePeers)
+	api("PATCH", "/files/{hash}", s.handler.UpdateFileLabels)
+	api("PUT", "/files/{hash}/access", s.handler.SetFileAccess)
+	api("POST", "/files/{hash}/shares", s.handler.CreateShare)
+	api("DELETE", "/files/{hash}/shares", s.handler.RevokeShares)
+
+	// Swarm statistics of many files
+	api("GET", "/scrape", s.handler.Scrape)
+	api("POST", "/scrape", s.handler.Scrape)
 
 	// Category endpoints
-	mux.HandleFunc("GET /api/categories", s.handler.ListCategories)
-	mux.HandleFunc("GET /api/categories/{category}/files", s.handler.ListFilesByCategory)
+	api("GET", "/categories", s.handler.ListCategories)
+	api("GET", "/categories/{category}/files", s.handler.ListFilesByCategory)
+
+	// Tag endpoints
+	api("GET", "/tags", s.handler.ListTags)
+	api("GET", "/tags/{tag}/files", s.handler.ListFilesByTag)
 
 	// Health check (simple for k8s probes)
-	mux.HandleFunc("GET /health", s.healthChecker.SimpleHandler())
+	handle("GET /health", s.healthChecker.SimpleHandler())
 
 	// Detailed health check
 	getPeersCount := func() int {
@@ -152,45 +206,53 @@ func (s *Server) SetupRoutes() *http.ServeMux {
 		_, _, files := s.storage.GetStats()
 		return files
 	}
-	mux.HandleFunc("GET /health/detailed", s.healthChecker.DetailedHandler(getPeersCount, getFilesCount))
+	handle("GET /health/detailed", s.healthChecker.DetailedHandler(getPeersCount, getFilesCount))
 
 	// Prometheus metrics (new promhttp handler)
-	mux.Handle("GET /metrics", MetricsHandler())
+	handle("GET /metrics", MetricsHandler())
 
 	// JWT Auth endpoints
-	mux.HandleFunc("POST /api/auth/login", s.jwtManager.HandleLogin(s.config.APIKeys))
+	api("POST", "/auth/login", s.jwtManager.HandleLogin(s.config.APIKeys))
 
 	// Admin endpoints
-	mux.HandleFunc("GET /api/admin/peers", s.handler.AdminListPeers)
-	mux.HandleFunc("DELETE /api/admin/peers/{peer_id}", s.handler.AdminKickPeer)
-	mux.HandleFunc("DELETE /api/admin/files/{hash}", s.handler.AdminDeleteFile)
+	api("GET", "/admin/peers", s.handler.AdminListPeers)
+	api("DELETE", "/admin/peers/{peer_id}", s.handler.AdminKickPeer)
+	api("DELETE", "/admin/files/{hash}", s.handler.AdminDeleteFile)
+	api("PATCH", "/admin/files/{hash}", s.handler.AdminUpdateFileLabels)
+	api("PUT", "/admin/files/{hash}/access", s.handler.AdminSetFileAccess)
+	api("GET", "/admin/groups", s.handler.AdminListGroups)
+	api("PUT", "/admin/groups/{group}", s.handler.AdminSetGroup)
+	api("DELETE", "/admin/groups/{group}", s.handler.AdminDeleteGroup)
 
 	// WebSocket endpoint
-	mux.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+	handle("GET /ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ServeWS(s.wsHub, w, r)
-	})
+	}))
 
 	// Relay WebSocket endpoint for P2P tunneling
-	mux.HandleFunc("GET /relay", func(w http.ResponseWriter, r *http.Request) {
-		ServeRelay(s.relayHub, w, r)
-	})
+	handle("GET /relay", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeRelay(s.relayHub, w, r, s.handler.session)
+	}))
 
 	// Relay status endpoint
-	mux.HandleFunc("GET /api/relay/peers", s.handleRelayPeers)
+	api("GET", "/relay/peers", s.handleRelayPeers)
 
 	// Magnet link endpoints
-	mux.HandleFunc("GET /api/files/{hash}/magnet", s.handler.GetMagnetLink)
-	mux.HandleFunc("GET /api/magnet", s.handler.ParseMagnetLink)
+	api("GET", "/files/{hash}/magnet", s.handler.GetMagnetLink)
+	api("GET", "/magnet", s.handler.ParseMagnetLink)
+
+	// OpenAPI document of the API
+	api("GET", "/openapi.yaml", ServeOpenAPI)
 
 	// Web Dashboard
-	mux.HandleFunc("GET /dashboard", s.DashboardHandler())
-	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+	handle("GET /dashboard", s.DashboardHandler())
+	handle("GET /", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
 			http.Redirect(w, r, "/dashboard", http.StatusTemporaryRedirect)
 			return
 		}
 		http.NotFound(w, r)
-	})
+	}))
 
 	return mux
 }
@@ -296,9 +358,11 @@ func (s *Server) Run() error {
 	s.StartStatsBroadcast(5 * time.Second)
 	log.Println("[Tracker] Stats broadcast started (5s interval)")
 
-	// Apply middlewares: prometheus -> rate limiter -> auth -> handler
+	// Apply middlewares: prometheus -> rate limiter -> auth -> OpenAPI
+	// validation -> handler
 	// But bypass middlewares for WebSocket endpoints to preserve http.Hijacker
 	var handler http.Handler = mux
+	handler = s.validator.Middleware(handler)
 	handler = s.authMW.Middleware(handler)
 	handler = s.rateLimiter.Middleware(handler)
 	handler = PrometheusMiddleware(handler)
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"cmp"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+)
+
+// PeerPolicy decides which peers of a file a peer list holds and in which
+// order. Each candidate gets a score, the weighted sum of its reputation
+// (scaled to 0-1), whether it is in the subnet of the requesting peer, and a
+// random number in [0, 1) that spreads the load of a popular file over its
+// seeders. The requesting peer itself is never listed.
+type PeerPolicy struct {
+	DefaultNumWant int // Peers listed when the request has no numwant; 0 for all
+	MaxNumWant     int // Cap of numwant; 0 for none
+	PoolSize       int // Candidates read from storage, at random, for large swarms; 0 for all
+
+	// Share of seeders in the list of a leecher, 0 to 1. It is filled with
+	// leechers when there are not enough seeders, and the other way round.
+	// A seeder only gets leechers.
+	SeederRatio float64
+
+	ReputationWeight float64
+	LocalityWeight   float64
+	RandomWeight     float64
+
+	SubnetBits int // Prefix length of a subnet for IPv4; IPv6 addresses compare their /64
+}
+
+// DefaultPeerPolicy returns the policy of a tracker without PEERS_*
+// variables
+func DefaultPeerPolicy() PeerPolicy {
+	return PeerPolicy{
+		DefaultNumWant:   50,
+		MaxNumWant:       200,
+		PoolSize:         1000,
+		SeederRatio:      0.5,
+		ReputationWeight: 0.3,
+		LocalityWeight:   0.3,
+		RandomWeight:     1,
+		SubnetBits:       24,
+	}
+}
+
+// peerPolicyFromEnv overrides the fields of a policy set in the
+// environment, ignoring invalid values
+func peerPolicyFromEnv(p PeerPolicy) PeerPolicy {
+	ints := map[string]*int{
+		"PEERS_NUMWANT":     &p.DefaultNumWant,
+		"PEERS_MAX_NUMWANT": &p.MaxNumWant,
+		"PEERS_POOL_SIZE":   &p.PoolSize,
+		"PEERS_SUBNET_BITS": &p.SubnetBits,
+	}
+	for name, field := range ints {
+		if s := os.Getenv(name); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+				*field = n
+			} else {
+				log.Printf("[Tracker] Ignoring invalid %s=%q", name, s)
+			}
+		}
+	}
+
+	floats := map[string]*float64{
+		"PEERS_SEEDER_RATIO":      &p.SeederRatio,
+		"PEERS_REPUTATION_WEIGHT": &p.ReputationWeight,
+		"PEERS_LOCALITY_WEIGHT":   &p.LocalityWeight,
+		"PEERS_RANDOM_WEIGHT":     &p.RandomWeight,
+	}
+	for name, field := range floats {
+		if s := os.Getenv(name); s != "" {
+			if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 && !math.IsInf(f, 0) {
+				*field = f
+			} else {
+				log.Printf("[Tracker] Ignoring invalid %s=%q", name, s)
+			}
+		}
+	}
+	p.SeederRatio = min(p.SeederRatio, 1)
+	p.SubnetBits = min(p.SubnetBits, 32)
+	return p
+}
+
+// numWant returns the number of peers to list for the numwant of a request,
+// requested being -1 when it has none
+func (p PeerPolicy) numWant(requested int) int {
+	n := requested
+	if n < 0 {
+		n = p.DefaultNumWant
+	}
+	if p.MaxNumWant > 0 && (n == 0 || n > p.MaxNumWant) {
+		n = p.MaxNumWant
+	}
+	return n
+}
+
+// peerRequest is the requesting peer of a peer list
+type peerRequest struct {
+	PeerID  string
+	IP      string // Empty when unknown, which disables locality
+	NumWant int    // As given by numWant; 0 for all
+}
+
+// Select returns the peers of a list for a request, best first
+func (p PeerPolicy) Select(candidates []models.SwarmPeer, req peerRequest) []protocol.PeerFileInfo {
+	requester, _ := netip.ParseAddr(req.IP)
+	requester = requester.Unmap()
+
+	type scored struct {
+		peer  protocol.PeerFileInfo
+		score float64
+	}
+	var seeders, leechers []scored
+	requesterSeeds := false
+	for _, c := range candidates {
+		if req.PeerID != "" && c.PeerID == req.PeerID {
+			requesterSeeds = c.IsSeeder
+			continue
+		}
+		score := p.ReputationWeight*c.Reputation/100 + p.RandomWeight*rand.Float64()
+		if p.sameSubnet(requester, c.IP) {
+			score += p.LocalityWeight
+		}
+		if c.IsSeeder {
+			seeders = append(seeders, scored{c.PeerFileInfo, score})
+		} else {
+			leechers = append(leechers, scored{c.PeerFileInfo, score})
+		}
+	}
+	if requesterSeeds {
+		seeders = nil
+	}
+
+	byScore := func(a, b scored) int { return cmp.Compare(b.score, a.score) }
+	slices.SortFunc(seeders, byScore)
+	slices.SortFunc(leechers, byScore)
+
+	// Split the list between seeders and leechers, giving the share one of
+	// them cannot fill to the other
+	numWant := req.NumWant
+	if numWant <= 0 || numWant > len(seeders)+len(leechers) {
+		numWant = len(seeders) + len(leechers)
+	}
+	numSeeders := int(math.Ceil(float64(numWant) * p.SeederRatio))
+	numSeeders = max(min(numSeeders, len(seeders)), numWant-len(leechers))
+	selected := append(seeders[:numSeeders:numSeeders], leechers[:numWant-numSeeders]...)
+	slices.SortStableFunc(selected, byScore)
+
+	peers := make([]protocol.PeerFileInfo, len(selected))
+	for i, s := range selected {
+		peers[i] = s.peer
+	}
+	return peers
+}
+
+// sameSubnet reports whether ip is in the subnet of the requesting peer
+func (p PeerPolicy) sameSubnet(requester netip.Addr, ip string) bool {
+	if !requester.IsValid() || p.LocalityWeight == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	if addr = addr.Unmap(); addr.Is4() != requester.Is4() {
+		return false
+	}
+	bits := 64
+	if addr.Is4() {
+		bits = p.SubnetBits
+	}
+	prefix, err := requester.Prefix(bits)
+	return err == nil && prefix.Contains(addr)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/storage"
+)
+
+// maxScrapeHashes caps the files of a scrape request
+const maxScrapeHashes = 500
+
+// recordTransfer stores what a peer reports of a file in its swarm and
+// counts its event and the bytes it transferred since its last report
+func (h *Handler) recordTransfer(report storage.TransferReport) {
+	uploaded, downloaded, err := h.storage.RecordTransfer(report)
+	if err != nil {
+		log.Printf("[Tracker] Failed to record the transfers of %s for %s: %v", report.PeerID, report.FileHash, err)
+		return
+	}
+	if report.Event != "" {
+		IncrementAnnounceEvent(report.Event)
+	}
+	if uploaded > 0 || downloaded > 0 {
+		RecordBytesTransferred(uploaded, downloaded)
+	}
+}
+
+// Scrape handles GET /api/scrape and POST /api/scrape
+// It returns the swarm statistics of many files at once: their seeders and
+// leechers, the downloads completed and the bytes transferred. Files are
+// given by hash or BitTorrent info hash, in hash parameters (repeated or
+// comma-separated) or a protocol.ScrapeRequest body. Unknown files, and
+// private files the request may not access, are left out.
+func (h *Handler) Scrape(w http.ResponseWriter, r *http.Request) {
+	req := protocol.ScrapeRequest{PeerID: r.URL.Query().Get("peer_id")}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	} else {
+		for _, value := range r.URL.Query()["hash"] {
+			req.Hashes = append(req.Hashes, strings.Split(value, ",")...)
+		}
+	}
+	if len(req.Hashes) == 0 {
+		sendError(w, http.StatusBadRequest, "At least one hash is required")
+		return
+	}
+	if len(req.Hashes) > maxScrapeHashes {
+		sendError(w, http.StatusBadRequest, "Too many hashes, at most "+itoa(maxScrapeHashes))
+		return
+	}
+
+	owner := h.identify(r, req.PeerID)
+	byHash := make(map[string][]string) // File hash -> requested hashes
+	var hashes []string
+	for _, requested := range req.Hashes {
+		file, ok := h.storage.GetFile(requested)
+		if !ok || !h.canAccess(r, file, owner) {
+			continue
+		}
+		if _, seen := byHash[file.Hash]; !seen {
+			hashes = append(hashes, file.Hash)
+		}
+		byHash[file.Hash] = append(byHash[file.Hash], requested)
+	}
+
+	resp := protocol.ScrapeResponse{Files: make(map[string]protocol.ScrapeFile, len(req.Hashes))}
+	for hash, stats := range h.storage.GetSwarmStats(hashes) {
+		file, _ := h.storage.GetFile(hash)
+		scraped := protocol.ScrapeFile{
+			Complete:        stats.Seeders,
+			Incomplete:      stats.Leechers,
+			Downloaders:     stats.Downloaders,
+			Downloaded:      stats.Completed,
+			BytesUploaded:   stats.Uploaded,
+			BytesDownloaded: stats.Downloaded,
+		}
+		if file != nil {
+			scraped.Name = file.Name
+		}
+		for _, requested := range byHash[hash] {
+			resp.Files[requested] = scraped
+		}
+	}
+	sendJSON(w, http.StatusOK, resp)
+}
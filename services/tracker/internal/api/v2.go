@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The API is served under two prefixes. /api keeps its responses for the
+// clients written against it; /api/v2 serves the same handlers with the
+// changes /api clients would break on:
+//
+//   - errors are {"error": code, "message": text}, code being the status
+//     text in snake case, such as not_found;
+//   - file listings, searches included, all return a ListFilesResponse;
+//   - admin listings are paged with limit and cursor;
+//   - peer lists only carry the availability of leechers unless asked.
+const (
+	apiPrefix   = "/api"
+	apiV2Prefix = "/api/v2"
+)
+
+// Page size of admin listings on /api/v2 without a limit
+const defaultAdminPageSize = 100
+
+// apiPath returns the /api path of a request path, for the checks that apply
+// to both prefixes
+func apiPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, apiV2Prefix); ok && (rest == "" || rest[0] == '/') {
+		return apiPrefix + rest
+	}
+	return path
+}
+
+// apiError is an error response of /api/v2
+type apiError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func newAPIError(status int, message string) apiError {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	return apiError{Error: cmp.Or(code, "error"), Message: message}
+}
+
+// v2 wraps a handler of an /api route to serve it under /api/v2
+func v2(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vw := &v2Writer{ResponseWriter: w}
+		handler(vw, r)
+		vw.flush()
+	}
+}
+
+// isV2 reports whether a response is one of /api/v2
+func isV2(w http.ResponseWriter) bool {
+	_, ok := w.(*v2Writer)
+	return ok
+}
+
+// v2Writer marks the responses of /api/v2, whose errors sendError sends as
+// apiError. It holds back the plain text errors of http.Error to send them
+// as apiError too.
+type v2Writer struct {
+	http.ResponseWriter
+	status int          // Of a plain text error held back
+	text   bytes.Buffer // Its message
+}
+
+func (w *v2Writer) WriteHeader(status int) {
+	if status >= 400 && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *v2Writer) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.text.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *v2Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush sends the plain text error held back, if any
+func (w *v2Writer) flush() {
+	if w.status == 0 {
+		return
+	}
+	w.Header().Del("X-Content-Type-Options")
+	sendJSON(w.ResponseWriter, w.status, newAPIError(w.status, strings.TrimSpace(w.text.String())))
+}
+
+// adminPage returns the page of an admin listing that an /api/v2 request asks
+// for with limit and cursor, and the cursor of the next page, empty on the
+// last one. Items are sorted by key, which the cursors carry. /api requests
+// get every item. It sends the error response if the parameters are invalid.
+func adminPage[T any](w http.ResponseWriter, r *http.Request, items []T, key func(T) string) ([]T, string, bool) {
+	if !isV2(w) {
+		return items, "", true
+	}
+
+	limit := defaultAdminPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxPageSize {
+			sendError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return nil, "", false
+		}
+		limit = v
+	}
+	slices.SortFunc(items, func(a, b T) int { return strings.Compare(key(a), key(b)) })
+
+	start := 0
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		last, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid cursor")
+			return nil, "", false
+		}
+		start, _ = slices.BinarySearchFunc(items, string(last), func(item T, last string) int {
+			if key(item) <= last {
+				return -1
+			}
+			return 1
+		})
+	}
+
+	end := min(start+limit, len(items))
+	next := ""
+	if end < len(items) {
+		next = base64.RawURLEncoding.EncodeToString([]byte(key(items[end-1])))
+	}
+	return items[start:end], next, true
+}
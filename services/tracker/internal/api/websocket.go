@@ -24,6 +24,7 @@ const (
 	EventPeerLeft    = "peer_left"
 	EventFileAdded   = "file_added"
 	EventFileRemoved = "file_removed"
+	EventFileUpdated = "file_updated"
 	EventStatsUpdate = "stats_update"
 	EventPeerUpdate  = "peer_update"
 )
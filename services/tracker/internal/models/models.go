@@ -29,18 +29,63 @@ type File struct {
 	Size      int64                `json:"size"`
 	ChunkSize int64                `json:"chunk_size"`
 	Chunks    []protocol.ChunkInfo `json:"chunks"`
-	Category  string               `json:"category,omitempty"` // Category: video, audio, document, image, software, other
-	Tags      []string             `json:"tags,omitempty"`     // User-defined tags
+	Category  string               `json:"category,omitempty"` // video, audio, image, document, archive, software or other, unless edited
+	Tags      []string             `json:"tags,omitempty"`     // Set by the owner or an admin, normalized
 	AddedAt   time.Time            `json:"added_at"`
 	AddedBy   string               `json:"added_by"` // PeerID
+
+	// Access to the file: who owns it (the owner identity of the peer that
+	// announced it first, empty for peers without one), whether it is public,
+	// unlisted or private, and the groups whose members may download it when
+	// private. Share tokens of an epoch before ShareEpoch are revoked.
+	OwnerID    string   `json:"owner_id,omitempty"`
+	Visibility string   `json:"visibility,omitempty"` // Empty is public
+	Groups     []string `json:"groups,omitempty"`
+	ShareEpoch int      `json:"share_epoch,omitempty"`
+
+	// BitTorrent info hashes the file can also be looked up by
+	BTInfoHash   string `json:"bt_info_hash,omitempty"`
+	BTInfoHashV2 string `json:"bt_info_hash_v2,omitempty"`
 }
 
 // FilePeer represents the relationship between a file and a peer
 type FilePeer struct {
-	FileHash        string    `json:"file_hash"`
-	PeerID          string    `json:"peer_id"`
-	ChunksAvailable []int     `json:"chunks_available"`
-	IsSeeder        bool      `json:"is_seeder"`
-	AddedAt         time.Time `json:"added_at"`
-	LastUpdated     time.Time `json:"last_updated"`
+	FileHash        string            `json:"file_hash"`
+	PeerID          string            `json:"peer_id"`
+	ChunksAvailable protocol.ChunkSet `json:"chunks_available"`
+	IsSeeder        bool              `json:"is_seeder"`
+	AddedAt         time.Time         `json:"added_at"`
+	LastUpdated     time.Time         `json:"last_updated"`
+
+	// Last transfer counters the peer reported, whether it stopped
+	// downloading, and whether its completed download was counted
+	protocol.Transfer
+	Paused    bool `json:"paused,omitempty"`
+	Completed bool `json:"completed,omitempty"`
+}
+
+// SwarmStats describes the swarm of a file: its online seeders and leechers,
+// and the transfers peers reported since the tracker first saw the file
+type SwarmStats struct {
+	Hash        string `json:"hash"`
+	Seeders     int    `json:"seeders"`
+	Leechers    int    `json:"leechers"`
+	Downloaders int    `json:"downloaders"` // Leechers that are not paused
+	Completed   int    `json:"completed"`   // Downloads completed
+	Uploaded    int64  `json:"uploaded"`
+	Downloaded  int64  `json:"downloaded"`
+}
+
+// SwarmPeer is an online peer of a file, a candidate for its peer lists
+type SwarmPeer struct {
+	protocol.PeerFileInfo
+	Reputation float64 // Of the peer, 0-100
+}
+
+// Group is a named set of owner identities that private files can be shared
+// with
+type Group struct {
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"` // Owner IDs
+	UpdatedAt time.Time `json:"updated_at"`
 }
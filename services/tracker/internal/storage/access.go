@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+)
+
+// isListed reports whether a file appears in listings, tag clouds and
+// category counts: only public files do
+func isListed(file *models.File) bool {
+	return file.Visibility == "" || file.Visibility == access.Public
+}
+
+// listedSQL is the condition of isListed on the files table
+const listedSQL = "COALESCE(NULLIF(visibility, ''), 'public') = 'public'"
+
+// keepAccess carries the access of a known file over to a new announce of
+// it, which only sets it for new files
+func keepAccess(file, existing *models.File) {
+	file.OwnerID = existing.OwnerID
+	file.Visibility = existing.Visibility
+	file.Groups = existing.Groups
+	file.ShareEpoch = existing.ShareEpoch
+}
+
+// UpdateFileAccess sets the visibility of a file and the groups it is shared with
+func (s *MemoryStorage) UpdateFileAccess(hash, visibility string, groups []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[hash]
+	if !ok {
+		return ErrFileNotFound
+	}
+	updated := *file
+	updated.Visibility = visibility
+	updated.Groups = groups
+	s.files[hash] = &updated
+	return nil
+}
+
+// RevokeShares revokes the share tokens of a file issued so far and returns
+// the epoch of the tokens issued from now on
+func (s *MemoryStorage) RevokeShares(hash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[hash]
+	if !ok {
+		return 0, ErrFileNotFound
+	}
+	updated := *file
+	updated.ShareEpoch++
+	s.files[hash] = &updated
+	return updated.ShareEpoch, nil
+}
+
+// SetGroup creates or replaces a group
+func (s *MemoryStorage) SetGroup(group *models.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group.UpdatedAt = time.Now()
+	s.groups[group.Name] = group
+	return nil
+}
+
+// GetGroup retrieves a group by name
+func (s *MemoryStorage) GetGroup(name string) (*models.Group, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group, ok := s.groups[name]
+	return group, ok
+}
+
+// ListGroups returns every group, by name
+func (s *MemoryStorage) ListGroups() []*models.Group {
+	s.mu.RLock()
+	groups := make([]*models.Group, 0, len(s.groups))
+	for _, group := range s.groups {
+		groups = append(groups, group)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(groups, func(a, b *models.Group) int { return strings.Compare(a.Name, b.Name) })
+	return groups
+}
+
+// DeleteGroup removes a group. Files shared with it are no longer shared with
+// its former members.
+func (s *MemoryStorage) DeleteGroup(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.groups, name)
+	return nil
+}
+
+// === SQL storages ===
+
+// updateFileAccess sets the visibility and groups of a file in the SQL storages
+func updateFileAccess(db *sql.DB, hash, visibility string, groups []string) error {
+	groupsJSON, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(`UPDATE files SET visibility = $1, allowed_groups = $2 WHERE hash = $3`,
+		visibility, string(groupsJSON), hash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrFileNotFound
+	}
+	return nil
+}
+
+// revokeShares increments the share epoch of a file in the SQL storages
+func revokeShares(db *sql.DB, hash string) (int, error) {
+	var epoch int
+	err := db.QueryRow(`UPDATE files SET share_epoch = COALESCE(share_epoch, 0) + 1 WHERE hash = $1 RETURNING share_epoch`,
+		hash).Scan(&epoch)
+	if err == sql.ErrNoRows {
+		return 0, ErrFileNotFound
+	}
+	return epoch, err
+}
+
+// setGroup upserts a group of the access_groups table
+func setGroup(db *sql.DB, group *models.Group) error {
+	membersJSON, err := json.Marshal(group.Members)
+	if err != nil {
+		return err
+	}
+	group.UpdatedAt = time.Now()
+	_, err = db.Exec(`INSERT INTO access_groups (name, members, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET members = EXCLUDED.members, updated_at = EXCLUDED.updated_at`,
+		group.Name, string(membersJSON), group.UpdatedAt)
+	return err
+}
+
+// getGroup reads a group of the access_groups table
+func getGroup(db *sql.DB, name string) (*models.Group, bool) {
+	group := &models.Group{Name: name}
+	var membersJSON string
+	err := db.QueryRow(`SELECT members::text, updated_at FROM access_groups WHERE name = $1`, name).
+		Scan(&membersJSON, &group.UpdatedAt)
+	if err != nil {
+		return nil, false
+	}
+	json.Unmarshal([]byte(membersJSON), &group.Members)
+	return group, true
+}
+
+// listGroups reads the access_groups table
+func listGroups(db *sql.DB) []*models.Group {
+	rows, err := db.Query(`SELECT name, members::text, updated_at FROM access_groups ORDER BY name COLLATE "C"`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var groups []*models.Group
+	for rows.Next() {
+		group := &models.Group{}
+		var membersJSON string
+		if err := rows.Scan(&group.Name, &membersJSON, &group.UpdatedAt); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(membersJSON), &group.Members)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// deleteGroup removes a group of the access_groups table
+func deleteGroup(db *sql.DB, name string) error {
+	_, err := db.Exec(`DELETE FROM access_groups WHERE name = $1`, name)
+	return err
+}
@@ -1,12 +1,14 @@
 package storage
 
 import (
+	"cmp"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
 )
@@ -96,9 +98,35 @@ func (s *DatabaseStorage) migrate() error {
 		// File category columns
 		"ALTER TABLE files ADD COLUMN IF NOT EXISTS category TEXT DEFAULT 'other'",
 		"ALTER TABLE files ADD COLUMN IF NOT EXISTS tags TEXT DEFAULT '[]'",
+		// Files imported from BitTorrent torrents
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS bt_info_hash TEXT DEFAULT ''",
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS bt_info_hash_v2 TEXT DEFAULT ''",
 		// Indexes for new columns
 		"CREATE INDEX IF NOT EXISTS idx_peers_reputation ON peers(reputation)",
 		"CREATE INDEX IF NOT EXISTS idx_files_category ON files(category)",
+		"CREATE INDEX IF NOT EXISTS idx_files_bt_info_hash ON files(bt_info_hash)",
+		"CREATE INDEX IF NOT EXISTS idx_files_bt_info_hash_v2 ON files(bt_info_hash_v2)",
+		// Sort orders of file listings
+		"CREATE INDEX IF NOT EXISTS idx_files_added_at ON files(added_at)",
+		"CREATE INDEX IF NOT EXISTS idx_files_size ON files(size)",
+		// Full-text search
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (" + searchVectorSQL("tags") + ") STORED",
+		"CREATE INDEX IF NOT EXISTS idx_files_search ON files USING GIN (search_vector)",
+		// Access to private files
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS owner_id TEXT DEFAULT ''",
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS visibility TEXT DEFAULT 'public'",
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS allowed_groups TEXT DEFAULT '[]'",
+		"ALTER TABLE files ADD COLUMN IF NOT EXISTS share_epoch INTEGER DEFAULT 0",
+		"CREATE INDEX IF NOT EXISTS idx_files_visibility ON files(visibility)",
+		"CREATE TABLE IF NOT EXISTS access_groups (name TEXT PRIMARY KEY, members TEXT NOT NULL DEFAULT '[]', updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)",
+		// Announce events and swarm statistics
+		"ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS uploaded BIGINT DEFAULT 0",
+		"ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS downloaded BIGINT DEFAULT 0",
+		"ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS left_bytes BIGINT DEFAULT 0",
+		"ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS paused BOOLEAN DEFAULT FALSE",
+		"ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS completed BOOLEAN DEFAULT FALSE",
+		"CREATE TABLE IF NOT EXISTS swarm_stats (file_hash TEXT PRIMARY KEY, completed INTEGER NOT NULL DEFAULT 0, uploaded BIGINT NOT NULL DEFAULT 0, downloaded BIGINT NOT NULL DEFAULT 0)",
+		"CREATE TABLE IF NOT EXISTS swarm_completions (file_hash TEXT NOT NULL, peer_id TEXT NOT NULL, PRIMARY KEY (file_hash, peer_id))",
 	}
 
 	for _, m := range migrations {
@@ -234,33 +262,47 @@ func (s *DatabaseStorage) AddFile(file *models.File) error {
 	if err != nil {
 		return err
 	}
+	groupsJSON, err := json.Marshal(file.Groups)
+	if err != nil {
+		return err
+	}
 	category := file.Category
 	if category == "" {
 		category = "other"
 	}
 	query := `
-		INSERT INTO files (hash, name, size, chunk_size, chunks, category, tags, added_at, added_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO files (hash, name, size, chunk_size, chunks, category, tags, added_at, added_by, bt_info_hash, bt_info_hash_v2,
+			owner_id, visibility, allowed_groups)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $13, $14, $15)
 		ON CONFLICT(hash) DO UPDATE SET
 			name = EXCLUDED.name,
 			size = EXCLUDED.size,
 			chunk_size = EXCLUDED.chunk_size,
 			chunks = EXCLUDED.chunks,
-			category = EXCLUDED.category,
-			tags = EXCLUDED.tags
+			category = CASE WHEN COALESCE(files.category, '') IN ('', 'other') THEN EXCLUDED.category ELSE files.category END,
+			tags = CASE WHEN $12::boolean THEN EXCLUDED.tags ELSE files.tags END,
+			bt_info_hash = CASE WHEN EXCLUDED.bt_info_hash = '' THEN files.bt_info_hash ELSE EXCLUDED.bt_info_hash END,
+			bt_info_hash_v2 = CASE WHEN EXCLUDED.bt_info_hash_v2 = '' THEN files.bt_info_hash_v2 ELSE EXCLUDED.bt_info_hash_v2 END
 	`
-	_, err = s.db.Exec(query, file.Hash, file.Name, file.Size, file.ChunkSize, string(chunksJSON), category, string(tagsJSON), time.Now(), file.AddedBy)
+	_, err = s.db.Exec(query, file.Hash, file.Name, file.Size, file.ChunkSize, string(chunksJSON), category, string(tagsJSON), time.Now(), file.AddedBy,
+		file.BTInfoHash, file.BTInfoHashV2, file.Tags != nil, file.OwnerID, cmp.Or(file.Visibility, access.Public), string(groupsJSON))
 	return err
 }
 
-// GetFile retrieves a file by hash
+// GetFile retrieves a file by hash or by BitTorrent info hash
 func (s *DatabaseStorage) GetFile(hash string) (*models.File, bool) {
-	query := `SELECT hash, name, size, chunk_size, chunks, COALESCE(category, 'other'), COALESCE(tags, '[]'), added_at, added_by FROM files WHERE hash = $1`
+	query := `SELECT hash, name, size, chunk_size, chunks, COALESCE(category, 'other'), COALESCE(tags, '[]'), added_at, added_by,
+		COALESCE(bt_info_hash, ''), COALESCE(bt_info_hash_v2, ''),
+		COALESCE(owner_id, ''), COALESCE(visibility, ''), COALESCE(allowed_groups, '[]'), COALESCE(share_epoch, 0)
+		FROM files WHERE hash = $1 OR ($1 <> '' AND (bt_info_hash = $1 OR bt_info_hash_v2 = $1))
+		ORDER BY hash = $1 DESC LIMIT 1`
 	file := &models.File{}
-	var chunksJSON, tagsJSON string
+	var chunksJSON, tagsJSON, groupsJSON string
 	err := s.db.QueryRow(query, hash).Scan(
 		&file.Hash, &file.Name, &file.Size, &file.ChunkSize,
 		&chunksJSON, &file.Category, &tagsJSON, &file.AddedAt, &file.AddedBy,
+		&file.BTInfoHash, &file.BTInfoHashV2,
+		&file.OwnerID, &file.Visibility, &groupsJSON, &file.ShareEpoch,
 	)
 	if err != nil {
 		return nil, false
@@ -268,58 +310,38 @@ func (s *DatabaseStorage) GetFile(hash string) (*models.File, bool) {
 	file.ID = file.Hash
 	json.Unmarshal([]byte(chunksJSON), &file.Chunks)
 	json.Unmarshal([]byte(tagsJSON), &file.Tags)
+	json.Unmarshal([]byte(groupsJSON), &file.Groups)
 	return file, true
 }
 
-// ListFiles returns all files
-func (s *DatabaseStorage) ListFiles() []protocol.FileListItem {
-	query := `SELECT hash, name, size, added_at FROM files`
-	rows, err := s.db.Query(query)
+// ListFiles returns a page of the files matching q
+func (s *DatabaseStorage) ListFiles(q FileQuery) (*FilePage, error) {
+	q, after, err := q.resolve()
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	query, args := fileQuerySQL(q, after)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
 	var items []protocol.FileListItem
 	for rows.Next() {
 		var item protocol.FileListItem
-		if err := rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt); err != nil {
-			continue
+		var tagsJSON string
+		if err := rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt, &item.Category, &tagsJSON,
+			&item.Seeders, &item.Leechers, &item.Score); err != nil {
+			return nil, err
 		}
-		item.Seeders, item.Leechers = s.countPeers(item.Hash)
+		json.Unmarshal([]byte(tagsJSON), &item.Tags)
 		items = append(items, item)
 	}
-	return items
-}
-
-// countPeers counts seeders and leechers for a file
-func (s *DatabaseStorage) countPeers(fileHash string) (seeders, leechers int) {
-	query := `
-		SELECT fp.is_seeder, COUNT(*)
-		FROM file_peers fp
-		JOIN peers p ON fp.peer_id = p.id
-		WHERE fp.file_hash = $1 AND p.is_online = TRUE
-		GROUP BY fp.is_seeder
-	`
-	rows, err := s.db.Query(query, fileHash)
-	if err != nil {
-		return 0, 0
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var isSeeder bool
-		var count int
-		if err := rows.Scan(&isSeeder, &count); err != nil {
-			continue
-		}
-		if isSeeder {
-			seeders = count
-		} else {
-			leechers = count
-		}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return
+	return q.page(items), nil
 }
 
 // === File-Peer Operations ===
@@ -343,28 +365,76 @@ func (s *DatabaseStorage) AddFilePeer(fp *models.FilePeer) error {
 	return err
 }
 
-// GetPeersForFile returns all peers that have a file
-func (s *DatabaseStorage) GetPeersForFile(fileHash string) []protocol.PeerFileInfo {
+// RemoveFilePeer removes a peer from the peers of a file
+func (s *DatabaseStorage) RemoveFilePeer(fileHash, peerID string) error {
+	_, err := s.db.Exec(`DELETE FROM file_peers WHERE file_hash = $1 AND peer_id = $2`, fileHash, peerID)
+	return err
+}
+
+// UpdateFilePeerChunks records chunks a peer has of a file it is downloading
+func (s *DatabaseStorage) UpdateFilePeerChunks(fileHash, peerID string, chunks protocol.ChunkSet, replace bool, chunkCount int) (protocol.ChunkSet, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return protocol.ChunkSet{}, err
+	}
+	defer tx.Rollback()
+
+	merged := chunks.Clone()
+	if !replace {
+		var chunksJSON string
+		err := tx.QueryRow(`SELECT chunks_available FROM file_peers WHERE file_hash = $1 AND peer_id = $2 FOR UPDATE`,
+			fileHash, peerID).Scan(&chunksJSON)
+		if err != nil && err != sql.ErrNoRows {
+			return protocol.ChunkSet{}, err
+		}
+		var known protocol.ChunkSet
+		json.Unmarshal([]byte(chunksJSON), &known)
+		merged.Union(known)
+	}
+
+	chunksJSON, err := json.Marshal(merged)
+	if err != nil {
+		return protocol.ChunkSet{}, err
+	}
 	query := `
-		SELECT p.id, p.ip, p.port, fp.chunks_available, fp.is_seeder
+		INSERT INTO file_peers (file_hash, peer_id, chunks_available, is_seeder, added_at, last_updated)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT(file_hash, peer_id) DO UPDATE SET
+			chunks_available = EXCLUDED.chunks_available,
+			is_seeder = EXCLUDED.is_seeder,
+			last_updated = EXCLUDED.last_updated
+	`
+	if _, err := tx.Exec(query, fileHash, peerID, string(chunksJSON), merged.Complete(chunkCount), time.Now()); err != nil {
+		return protocol.ChunkSet{}, err
+	}
+	return merged, tx.Commit()
+}
+
+// GetPeersForFile returns the online peers of a file, or limit of them
+// chosen at random when limit > 0, peerID first if it has the file
+func (s *DatabaseStorage) GetPeersForFile(fileHash, peerID string, limit int) []models.SwarmPeer {
+	query := `
+		SELECT p.id, p.ip, p.port, COALESCE(p.reputation, 50), fp.chunks_available, fp.is_seeder
 		FROM file_peers fp
 		JOIN peers p ON fp.peer_id = p.id
 		WHERE fp.file_hash = $1 AND p.is_online = TRUE
+		ORDER BY p.id = $3 DESC, random() LIMIT NULLIF($2, 0)
 	`
-	rows, err := s.db.Query(query, fileHash)
+	rows, err := s.db.Query(query, fileHash, max(limit, 0), peerID)
 	if err != nil {
 		return nil
 	}
 	defer rows.Close()
 
-	var result []protocol.PeerFileInfo
+	var result []models.SwarmPeer
 	for rows.Next() {
-		var info protocol.PeerFileInfo
+		info := models.SwarmPeer{PeerFileInfo: protocol.PeerFileInfo{Availability: &protocol.ChunkSet{}}}
 		var chunksJSON string
-		if err := rows.Scan(&info.PeerID, &info.IP, &info.Port, &chunksJSON, &info.IsSeeder); err != nil {
+		if err := rows.Scan(&info.PeerID, &info.IP, &info.Port, &info.Reputation, &chunksJSON, &info.IsSeeder); err != nil {
 			continue
 		}
-		json.Unmarshal([]byte(chunksJSON), &info.ChunksAvailable)
+		// Rows written before the compact encoding hold a JSON array, which ChunkSet also reads
+		json.Unmarshal([]byte(chunksJSON), info.Availability)
 		result = append(result, info)
 	}
 	return result
@@ -412,56 +482,22 @@ func (s *DatabaseStorage) DeleteFile(hash string) error {
 	if err != nil {
 		return err
 	}
+	// Delete its swarm statistics
+	if _, err := s.db.Exec(`DELETE FROM swarm_stats WHERE file_hash = $1`, hash); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM swarm_completions WHERE file_hash = $1`, hash); err != nil {
+		return err
+	}
 	// Delete file
 	_, err = s.db.Exec(`DELETE FROM files WHERE hash = $1`, hash)
 	return err
 }
 
-// SearchFiles searches files by name (case-insensitive)
-func (s *DatabaseStorage) SearchFiles(query string) []protocol.FileListItem {
-	sqlQuery := `SELECT hash, name, size, added_at FROM files WHERE LOWER(name) LIKE LOWER($1)`
-	rows, err := s.db.Query(sqlQuery, "%"+query+"%")
-	if err != nil {
-		return nil
-	}
-	defer rows.Close()
-
-	var items []protocol.FileListItem
-	for rows.Next() {
-		var item protocol.FileListItem
-		if err := rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt); err != nil {
-			continue
-		}
-		item.Seeders, item.Leechers = s.countPeers(item.Hash)
-		items = append(items, item)
-	}
-	return items
-}
-
-// ListFilesByCategory returns files filtered by category
-func (s *DatabaseStorage) ListFilesByCategory(category string) []protocol.FileListItem {
-	sqlQuery := `SELECT hash, name, size, added_at FROM files WHERE LOWER(COALESCE(category, 'other')) = LOWER($1)`
-	rows, err := s.db.Query(sqlQuery, category)
-	if err != nil {
-		return nil
-	}
-	defer rows.Close()
-
-	var items []protocol.FileListItem
-	for rows.Next() {
-		var item protocol.FileListItem
-		if err := rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt); err != nil {
-			continue
-		}
-		item.Seeders, item.Leechers = s.countPeers(item.Hash)
-		items = append(items, item)
-	}
-	return items
-}
-
 // ListCategories returns statistics for all categories
 func (s *DatabaseStorage) ListCategories() []CategoryStats {
-	sqlQuery := `SELECT COALESCE(category, 'other') as cat, COUNT(*) as cnt, COALESCE(SUM(size), 0) as total_size FROM files GROUP BY cat ORDER BY cnt DESC`
+	sqlQuery := `SELECT COALESCE(category, 'other') as cat, COUNT(*) as cnt, COALESCE(SUM(size), 0) as total_size FROM files
+		WHERE ` + listedSQL + ` GROUP BY cat ORDER BY cnt DESC`
 	rows, err := s.db.Query(sqlQuery)
 	if err != nil {
 		return nil
@@ -479,6 +515,56 @@ func (s *DatabaseStorage) ListCategories() []CategoryStats {
 	return result
 }
 
+// UpdateFileLabels sets the category and tags of a file
+func (s *DatabaseStorage) UpdateFileLabels(hash, category string, tags []string) error {
+	return updateFileLabels(s.db, hash, category, tags)
+}
+
+// ListTags returns the most used tags, at most limit of them (0 for all)
+func (s *DatabaseStorage) ListTags(limit int) []TagStats {
+	return listTags(s.db, limit)
+}
+
+// UpdateFileAccess sets the visibility of a file and the groups it is shared with
+func (s *DatabaseStorage) UpdateFileAccess(hash, visibility string, groups []string) error {
+	return updateFileAccess(s.db, hash, visibility, groups)
+}
+
+// RevokeShares revokes the share tokens of a file issued so far
+func (s *DatabaseStorage) RevokeShares(hash string) (int, error) {
+	return revokeShares(s.db, hash)
+}
+
+// SetGroup creates or replaces a group
+func (s *DatabaseStorage) SetGroup(group *models.Group) error {
+	return setGroup(s.db, group)
+}
+
+// GetGroup retrieves a group by name
+func (s *DatabaseStorage) GetGroup(name string) (*models.Group, bool) {
+	return getGroup(s.db, name)
+}
+
+// ListGroups returns every group, by name
+func (s *DatabaseStorage) ListGroups() []*models.Group {
+	return listGroups(s.db)
+}
+
+// DeleteGroup removes a group
+func (s *DatabaseStorage) DeleteGroup(name string) error {
+	return deleteGroup(s.db, name)
+}
+
+// RecordTransfer stores the transfer counters a peer reports for a file
+func (s *DatabaseStorage) RecordTransfer(r TransferReport) (uploaded, downloaded int64, err error) {
+	return recordTransfer(s.db, r)
+}
+
+// GetSwarmStats returns the swarm statistics of files by hash
+func (s *DatabaseStorage) GetSwarmStats(hashes []string) map[string]*models.SwarmStats {
+	return getSwarmStats(s.db, hashes)
+}
+
 // === Reputation Operations ===
 
 // UpdatePeerStats updates peer upload/download statistics and recalculates reputation
@@ -26,16 +26,40 @@ type Storage interface {
 
 	// File operations
 	AddFile(file *models.File) error
-	GetFile(hash string) (*models.File, bool)
-	ListFiles() []protocol.FileListItem
-	SearchFiles(query string) []protocol.FileListItem
-	ListFilesByCategory(category string) []protocol.FileListItem
+	GetFile(hash string) (*models.File, bool) // By hash or BitTorrent info hash
+	ListFiles(q FileQuery) (*FilePage, error) // Returns ErrInvalidQuery for a bad sort or cursor
 	ListCategories() []CategoryStats
+	UpdateFileLabels(hash, category string, tags []string) error // Returns ErrFileNotFound for an unknown file
+	ListTags(limit int) []TagStats                               // Most used first; limit 0 for all
+
+	// Access operations. Only public files are listed by ListFiles, ListTags
+	// and ListCategories.
+	UpdateFileAccess(hash, visibility string, groups []string) error // Returns ErrFileNotFound for an unknown file
+	RevokeShares(hash string) (int, error)                           // Returns the new share epoch of the file
+	SetGroup(group *models.Group) error
+	GetGroup(name string) (*models.Group, bool)
+	ListGroups() []*models.Group
+	DeleteGroup(name string) error
 	DeleteOrphanFiles() int // Delete files with no peers
 
 	// File-Peer operations
 	AddFilePeer(fp *models.FilePeer) error
-	GetPeersForFile(fileHash string) []protocol.PeerFileInfo
+	RemoveFilePeer(fileHash, peerID string) error
+	// UpdateFilePeerChunks adds chunks to those a peer has of a file (replaces
+	// them if replace is set), listing the peer as a leecher if needed and as a
+	// seeder once it has all chunkCount chunks. It returns the peer's chunks.
+	UpdateFilePeerChunks(fileHash, peerID string, chunks protocol.ChunkSet, replace bool, chunkCount int) (protocol.ChunkSet, error)
+	// GetPeersForFile returns the online peers of a file, or limit of them
+	// chosen at random when limit > 0. The peer peerID is always among them
+	// if it has the file, so that callers know its own state.
+	GetPeersForFile(fileHash, peerID string, limit int) []models.SwarmPeer
+	// RecordTransfer stores the transfer counters a peer reports for a file
+	// and adds their increase to the swarm statistics of the file. It returns
+	// the increase, zero for a peer not in the swarm.
+	RecordTransfer(r TransferReport) (uploaded, downloaded int64, err error)
+	// GetSwarmStats returns the swarm statistics of files by hash, zero for
+	// unknown files
+	GetSwarmStats(hashes []string) map[string]*models.SwarmStats
 
 	// Stats
 	GetStats() (peersOnline, peersTotal, filesCount int)
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+)
+
+// ErrFileNotFound is returned when updating a file the tracker does not know
+var ErrFileNotFound = errors.New("file not found")
+
+// TagStats is a tag of the tag cloud and the number of files having it
+type TagStats struct {
+	Tag       string `json:"tag"`
+	FileCount int    `json:"file_count"`
+}
+
+// keepLabels carries the category and tags of a known file over to a new
+// announce of it: announces carry no tags, and the category detected from
+// the content only replaces one that is unknown
+func keepLabels(file, existing *models.File) {
+	if file.Tags == nil {
+		file.Tags = existing.Tags
+	}
+	if existing.Category != "" && existing.Category != "other" {
+		file.Category = existing.Category
+	}
+}
+
+// UpdateFileLabels sets the category and tags of a file
+func (s *MemoryStorage) UpdateFileLabels(hash, category string, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[hash]
+	if !ok {
+		return ErrFileNotFound
+	}
+	// Readers may hold the old file, so it is replaced rather than changed
+	updated := *file
+	updated.Category = category
+	updated.Tags = tags
+	s.files[hash] = &updated
+	s.index.add(&updated)
+	return nil
+}
+
+// ListTags returns the most used tags of the public files, at most limit of them (0 for all)
+func (s *MemoryStorage) ListTags(limit int) []TagStats {
+	s.mu.RLock()
+	counts := make(map[string]int)
+	for _, file := range s.files {
+		if !isListed(file) {
+			continue
+		}
+		for _, tag := range file.Tags {
+			counts[tag]++
+		}
+	}
+	s.mu.RUnlock()
+
+	tags := make([]TagStats, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagStats{Tag: tag, FileCount: count})
+	}
+	slices.SortFunc(tags, func(a, b TagStats) int {
+		return cmp.Or(cmp.Compare(b.FileCount, a.FileCount), cmp.Compare(a.Tag, b.Tag))
+	})
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags
+}
+
+// listTagsSQL is the ListTags query of the SQL storages, whose tags column
+// holds a JSON array, or JSON null for files added without tags
+const listTagsSQL = `SELECT tag, COUNT(*) AS cnt
+	FROM files, jsonb_array_elements_text(CASE WHEN jsonb_typeof(tags::jsonb) = 'array'
+		THEN tags::jsonb ELSE '[]'::jsonb END) AS tag
+	WHERE ` + listedSQL + `
+	GROUP BY tag ORDER BY cnt DESC, tag COLLATE "C"`
+
+// listTags runs listTagsSQL
+func listTags(db *sql.DB, limit int) []TagStats {
+	query, args := listTagsSQL, []any{}
+	if limit > 0 {
+		query += " LIMIT $1"
+		args = append(args, limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var tags []TagStats
+	for rows.Next() {
+		var ts TagStats
+		if err := rows.Scan(&ts.Tag, &ts.FileCount); err != nil {
+			continue
+		}
+		tags = append(tags, ts)
+	}
+	return tags
+}
+
+// updateFileLabels sets the category and tags of a file in the SQL storages
+func updateFileLabels(db *sql.DB, hash, category string, tags []string) error {
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(`UPDATE files SET category = $1, tags = $2 WHERE hash = $3`, category, string(tagsJSON), hash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrFileNotFound
+	}
+	return nil
+}
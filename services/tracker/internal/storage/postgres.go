@@ -1,12 +1,14 @@
 package storage
 
 import (
+	"cmp"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/p2p-filesharing/distributed-system/pkg/access"
 	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
 	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
 )
@@ -84,8 +86,50 @@ func (s *PostgresStorage) initSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_peers_last_seen ON peers(last_seen);
 	CREATE INDEX IF NOT EXISTS idx_files_category ON files(category);
 	CREATE INDEX IF NOT EXISTS idx_files_name ON files(name);
+	CREATE INDEX IF NOT EXISTS idx_files_added_at ON files(added_at);
+	CREATE INDEX IF NOT EXISTS idx_files_size ON files(size);
 	CREATE INDEX IF NOT EXISTS idx_file_peers_file ON file_peers(file_hash);
 	CREATE INDEX IF NOT EXISTS idx_file_peers_peer ON file_peers(peer_id);
+
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS bt_info_hash VARCHAR(40) DEFAULT '';
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS bt_info_hash_v2 VARCHAR(64) DEFAULT '';
+	CREATE INDEX IF NOT EXISTS idx_files_bt_info_hash ON files(bt_info_hash);
+	CREATE INDEX IF NOT EXISTS idx_files_bt_info_hash_v2 ON files(bt_info_hash_v2);
+
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (` + searchVectorSQL("tags::text") + `) STORED;
+	CREATE INDEX IF NOT EXISTS idx_files_search ON files USING GIN (search_vector);
+
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS owner_id VARCHAR(64) DEFAULT '';
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS visibility VARCHAR(16) DEFAULT 'public';
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS allowed_groups JSONB DEFAULT '[]';
+	ALTER TABLE files ADD COLUMN IF NOT EXISTS share_epoch INTEGER DEFAULT 0;
+	CREATE INDEX IF NOT EXISTS idx_files_visibility ON files(visibility);
+
+	CREATE TABLE IF NOT EXISTS access_groups (
+		name VARCHAR(50) PRIMARY KEY,
+		members JSONB NOT NULL DEFAULT '[]',
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS uploaded BIGINT DEFAULT 0;
+	ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS downloaded BIGINT DEFAULT 0;
+	ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS left_bytes BIGINT DEFAULT 0;
+	ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS paused BOOLEAN DEFAULT FALSE;
+	ALTER TABLE file_peers ADD COLUMN IF NOT EXISTS completed BOOLEAN DEFAULT FALSE;
+
+	CREATE TABLE IF NOT EXISTS swarm_stats (
+		file_hash VARCHAR(64) PRIMARY KEY,
+		completed INTEGER NOT NULL DEFAULT 0,
+		uploaded BIGINT NOT NULL DEFAULT 0,
+		downloaded BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS swarm_completions (
+		file_hash VARCHAR(64) NOT NULL,
+		peer_id VARCHAR(255) NOT NULL,
+		PRIMARY KEY (file_hash, peer_id)
+	);
 	`
 	_, err := s.db.Exec(schema)
 	return err
@@ -207,6 +251,7 @@ func (s *PostgresStorage) DeleteOrphanFiles() int {
 func (s *PostgresStorage) AddFile(file *models.File) error {
 	chunksJSON, _ := json.Marshal(file.Chunks)
 	tagsJSON, _ := json.Marshal(file.Tags)
+	groupsJSON, _ := json.Marshal(file.Groups)
 
 	category := file.Category
 	if category == "" {
@@ -214,31 +259,41 @@ func (s *PostgresStorage) AddFile(file *models.File) error {
 	}
 
 	query := `
-		INSERT INTO files (hash, name, size, chunk_size, chunks, category, tags, added_at, added_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO files (hash, name, size, chunk_size, chunks, category, tags, added_at, added_by, bt_info_hash, bt_info_hash_v2,
+			owner_id, visibility, allowed_groups)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $13, $14, $15)
 		ON CONFLICT (hash) DO UPDATE SET
 			name = EXCLUDED.name,
 			chunks = EXCLUDED.chunks,
-			category = EXCLUDED.category,
-			tags = EXCLUDED.tags
+			category = CASE WHEN COALESCE(files.category, '') IN ('', 'other') THEN EXCLUDED.category ELSE files.category END,
+			tags = CASE WHEN $12::boolean THEN EXCLUDED.tags ELSE files.tags END,
+			bt_info_hash = CASE WHEN EXCLUDED.bt_info_hash = '' THEN files.bt_info_hash ELSE EXCLUDED.bt_info_hash END,
+			bt_info_hash_v2 = CASE WHEN EXCLUDED.bt_info_hash_v2 = '' THEN files.bt_info_hash_v2 ELSE EXCLUDED.bt_info_hash_v2 END
 	`
 	_, err := s.db.Exec(query, file.Hash, file.Name, file.Size, file.ChunkSize,
-		string(chunksJSON), category, string(tagsJSON), time.Now(), file.AddedBy)
+		string(chunksJSON), category, string(tagsJSON), time.Now(), file.AddedBy,
+		file.BTInfoHash, file.BTInfoHashV2, file.Tags != nil, file.OwnerID, cmp.Or(file.Visibility, access.Public), string(groupsJSON))
 	return err
 }
 
 func (s *PostgresStorage) GetFile(hash string) (*models.File, bool) {
-	query := `SELECT hash, name, size, chunk_size, chunks, category, tags, added_at, added_by
-		FROM files WHERE hash = $1`
+	// Files imported from a torrent can also be looked up by its info hashes
+	query := `SELECT hash, name, size, chunk_size, chunks, category, tags, added_at, added_by,
+		COALESCE(bt_info_hash, ''), COALESCE(bt_info_hash_v2, ''),
+		COALESCE(owner_id, ''), COALESCE(visibility, ''), COALESCE(allowed_groups::text, '[]'), COALESCE(share_epoch, 0)
+		FROM files WHERE hash = $1 OR ($1 <> '' AND (bt_info_hash = $1 OR bt_info_hash_v2 = $1))
+		ORDER BY hash = $1 DESC LIMIT 1`
 
 	file := &models.File{}
-	var chunksJSON, tagsJSON string
+	var chunksJSON, tagsJSON, groupsJSON string
 	var category, addedBy sql.NullString
 	var addedAt sql.NullTime
 
 	err := s.db.QueryRow(query, hash).Scan(
 		&file.Hash, &file.Name, &file.Size, &file.ChunkSize,
 		&chunksJSON, &category, &tagsJSON, &addedAt, &addedBy,
+		&file.BTInfoHash, &file.BTInfoHashV2,
+		&file.OwnerID, &file.Visibility, &groupsJSON, &file.ShareEpoch,
 	)
 	if err != nil {
 		return nil, false
@@ -257,86 +312,42 @@ func (s *PostgresStorage) GetFile(hash string) (*models.File, bool) {
 
 	json.Unmarshal([]byte(chunksJSON), &file.Chunks)
 	json.Unmarshal([]byte(tagsJSON), &file.Tags)
+	json.Unmarshal([]byte(groupsJSON), &file.Groups)
 	return file, true
 }
 
-func (s *PostgresStorage) ListFiles() []protocol.FileListItem {
-	query := `SELECT f.hash, f.name, f.size, f.added_at,
-		COALESCE(SUM(CASE WHEN fp.is_seeder AND p.is_online THEN 1 ELSE 0 END), 0) as seeders,
-		COALESCE(SUM(CASE WHEN NOT fp.is_seeder AND p.is_online THEN 1 ELSE 0 END), 0) as leechers
-		FROM files f
-		LEFT JOIN file_peers fp ON f.hash = fp.file_hash
-		LEFT JOIN peers p ON fp.peer_id = p.id
-		GROUP BY f.hash, f.name, f.size, f.added_at`
-
-	rows, err := s.db.Query(query)
+func (s *PostgresStorage) ListFiles(q FileQuery) (*FilePage, error) {
+	q, after, err := q.resolve()
 	if err != nil {
-		return nil
-	}
-	defer rows.Close()
-
-	var items []protocol.FileListItem
-	for rows.Next() {
-		var item protocol.FileListItem
-		rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt, &item.Seeders, &item.Leechers)
-		items = append(items, item)
+		return nil, err
 	}
-	return items
-}
-
-func (s *PostgresStorage) SearchFiles(query string) []protocol.FileListItem {
-	sqlQuery := `SELECT f.hash, f.name, f.size, f.added_at,
-		COALESCE(SUM(CASE WHEN fp.is_seeder AND p.is_online THEN 1 ELSE 0 END), 0) as seeders,
-		COALESCE(SUM(CASE WHEN NOT fp.is_seeder AND p.is_online THEN 1 ELSE 0 END), 0) as leechers
-		FROM files f
-		LEFT JOIN file_peers fp ON f.hash = fp.file_hash
-		LEFT JOIN peers p ON fp.peer_id = p.id
-		WHERE LOWER(f.name) LIKE LOWER($1)
-		GROUP BY f.hash, f.name, f.size, f.added_at`
-
-	rows, err := s.db.Query(sqlQuery, "%"+query+"%")
+	query, args := fileQuerySQL(q, after)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 
 	var items []protocol.FileListItem
 	for rows.Next() {
 		var item protocol.FileListItem
-		rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt, &item.Seeders, &item.Leechers)
+		var tagsJSON string
+		if err := rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt, &item.Category, &tagsJSON,
+			&item.Seeders, &item.Leechers, &item.Score); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(tagsJSON), &item.Tags)
 		items = append(items, item)
 	}
-	return items
-}
-
-func (s *PostgresStorage) ListFilesByCategory(category string) []protocol.FileListItem {
-	sqlQuery := `SELECT f.hash, f.name, f.size, f.added_at,
-		COALESCE(SUM(CASE WHEN fp.is_seeder AND p.is_online THEN 1 ELSE 0 END), 0) as seeders,
-		COALESCE(SUM(CASE WHEN NOT fp.is_seeder AND p.is_online THEN 1 ELSE 0 END), 0) as leechers
-		FROM files f
-		LEFT JOIN file_peers fp ON f.hash = fp.file_hash
-		LEFT JOIN peers p ON fp.peer_id = p.id
-		WHERE LOWER(f.category) = LOWER($1)
-		GROUP BY f.hash, f.name, f.size, f.added_at`
-
-	rows, err := s.db.Query(sqlQuery, category)
-	if err != nil {
-		return nil
-	}
-	defer rows.Close()
-
-	var items []protocol.FileListItem
-	for rows.Next() {
-		var item protocol.FileListItem
-		rows.Scan(&item.Hash, &item.Name, &item.Size, &item.AddedAt, &item.Seeders, &item.Leechers)
-		items = append(items, item)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return items
+	return q.page(items), nil
 }
 
 func (s *PostgresStorage) ListCategories() []CategoryStats {
 	query := `SELECT COALESCE(NULLIF(category, ''), 'other') as cat, COUNT(*) as cnt,
-		COALESCE(SUM(size), 0) as total FROM files GROUP BY cat`
+		COALESCE(SUM(size), 0) as total FROM files WHERE ` + listedSQL + ` GROUP BY cat`
 
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -353,6 +364,38 @@ func (s *PostgresStorage) ListCategories() []CategoryStats {
 	return stats
 }
 
+func (s *PostgresStorage) UpdateFileLabels(hash, category string, tags []string) error {
+	return updateFileLabels(s.db, hash, category, tags)
+}
+
+func (s *PostgresStorage) ListTags(limit int) []TagStats {
+	return listTags(s.db, limit)
+}
+
+func (s *PostgresStorage) UpdateFileAccess(hash, visibility string, groups []string) error {
+	return updateFileAccess(s.db, hash, visibility, groups)
+}
+
+func (s *PostgresStorage) RevokeShares(hash string) (int, error) {
+	return revokeShares(s.db, hash)
+}
+
+func (s *PostgresStorage) SetGroup(group *models.Group) error {
+	return setGroup(s.db, group)
+}
+
+func (s *PostgresStorage) GetGroup(name string) (*models.Group, bool) {
+	return getGroup(s.db, name)
+}
+
+func (s *PostgresStorage) ListGroups() []*models.Group {
+	return listGroups(s.db)
+}
+
+func (s *PostgresStorage) DeleteGroup(name string) error {
+	return deleteGroup(s.db, name)
+}
+
 // === File-Peer Operations ===
 
 func (s *PostgresStorage) AddFilePeer(fp *models.FilePeer) error {
@@ -370,24 +413,66 @@ func (s *PostgresStorage) AddFilePeer(fp *models.FilePeer) error {
 	return err
 }
 
-func (s *PostgresStorage) GetPeersForFile(fileHash string) []protocol.PeerFileInfo {
-	query := `SELECT p.id, p.ip, p.port, fp.chunks_available, fp.is_seeder
+func (s *PostgresStorage) RemoveFilePeer(fileHash, peerID string) error {
+	_, err := s.db.Exec(`DELETE FROM file_peers WHERE file_hash = $1 AND peer_id = $2`, fileHash, peerID)
+	return err
+}
+
+func (s *PostgresStorage) UpdateFilePeerChunks(fileHash, peerID string, chunks protocol.ChunkSet, replace bool, chunkCount int) (protocol.ChunkSet, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return protocol.ChunkSet{}, err
+	}
+	defer tx.Rollback()
+
+	merged := chunks.Clone()
+	if !replace {
+		var chunksJSON []byte
+		err := tx.QueryRow(`SELECT chunks_available FROM file_peers WHERE file_hash = $1 AND peer_id = $2 FOR UPDATE`,
+			fileHash, peerID).Scan(&chunksJSON)
+		if err != nil && err != sql.ErrNoRows {
+			return protocol.ChunkSet{}, err
+		}
+		var known protocol.ChunkSet
+		json.Unmarshal(chunksJSON, &known)
+		merged.Union(known)
+	}
+
+	chunksJSON, _ := json.Marshal(merged)
+	query := `
+		INSERT INTO file_peers (file_hash, peer_id, chunks_available, is_seeder, added_at, last_updated)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (file_hash, peer_id) DO UPDATE SET
+			chunks_available = EXCLUDED.chunks_available,
+			is_seeder = EXCLUDED.is_seeder,
+			last_updated = EXCLUDED.last_updated
+	`
+	if _, err := tx.Exec(query, fileHash, peerID, chunksJSON, merged.Complete(chunkCount), time.Now()); err != nil {
+		return protocol.ChunkSet{}, err
+	}
+	return merged, tx.Commit()
+}
+
+func (s *PostgresStorage) GetPeersForFile(fileHash, peerID string, limit int) []models.SwarmPeer {
+	// LIMIT NULL returns every row
+	query := `SELECT p.id, p.ip, p.port, COALESCE(p.reputation, 50), fp.chunks_available, fp.is_seeder
 		FROM file_peers fp
 		JOIN peers p ON fp.peer_id = p.id
-		WHERE fp.file_hash = $1 AND p.is_online = TRUE`
+		WHERE fp.file_hash = $1 AND p.is_online = TRUE
+		ORDER BY p.id = $3 DESC, random() LIMIT NULLIF($2, 0)`
 
-	rows, err := s.db.Query(query, fileHash)
+	rows, err := s.db.Query(query, fileHash, max(limit, 0), peerID)
 	if err != nil {
 		return nil
 	}
 	defer rows.Close()
 
-	var result []protocol.PeerFileInfo
+	var result []models.SwarmPeer
 	for rows.Next() {
-		var info protocol.PeerFileInfo
+		info := models.SwarmPeer{PeerFileInfo: protocol.PeerFileInfo{Availability: &protocol.ChunkSet{}}}
 		var chunksJSON []byte
-		rows.Scan(&info.PeerID, &info.IP, &info.Port, &chunksJSON, &info.IsSeeder)
-		json.Unmarshal(chunksJSON, &info.ChunksAvailable)
+		rows.Scan(&info.PeerID, &info.IP, &info.Port, &info.Reputation, &chunksJSON, &info.IsSeeder)
+		json.Unmarshal(chunksJSON, info.Availability)
 		result = append(result, info)
 	}
 	return result
@@ -435,12 +520,26 @@ func (s *PostgresStorage) DeleteFile(hash string) error {
 	if _, err := tx.Exec(`DELETE FROM file_peers WHERE file_hash = $1`, hash); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(`DELETE FROM swarm_stats WHERE file_hash = $1`, hash); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM swarm_completions WHERE file_hash = $1`, hash); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(`DELETE FROM files WHERE hash = $1`, hash); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
+func (s *PostgresStorage) RecordTransfer(r TransferReport) (uploaded, downloaded int64, err error) {
+	return recordTransfer(s.db, r)
+}
+
+func (s *PostgresStorage) GetSwarmStats(hashes []string) map[string]*models.SwarmStats {
+	return getSwarmStats(s.db, hashes)
+}
+
 // === Reputation Operations ===
 
 func (s *PostgresStorage) UpdatePeerStats(peerID string, bytesUploaded, bytesDownloaded int64) error {
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+)
+
+// Sort orders of FileQuery
+const (
+	SortAdded     = "added"
+	SortName      = "name"
+	SortSize      = "size"
+	SortSeeders   = "seeders"
+	SortRelevance = "relevance" // Only with a search
+)
+
+// ErrInvalidQuery is returned by ListFiles for an unknown sort order or a
+// cursor it did not issue
+var ErrInvalidQuery = errors.New("invalid file query")
+
+// FileQuery selects, orders and pages the files returned by ListFiles. The
+// zero value returns every file, oldest first.
+//
+// Search is a full-text search: the name, tags and category of a file are
+// split into words of letters and digits, and each word of the search must
+// start one of them. A match in the name ranks above one in the tags, which
+// ranks above one in the category, and a whole word above a prefix.
+type FileQuery struct {
+	Search     string    `json:"q,omitempty"`
+	Category   string    `json:"category,omitempty"` // Case-insensitive; files without one are "other"
+	Tags       []string  `json:"tags,omitempty"`     // Files having all of them
+	MinSize    int64     `json:"min_size,omitempty"`
+	MaxSize    int64     `json:"max_size,omitempty"` // 0 for no maximum
+	MinSeeders int       `json:"min_seeders,omitempty"`
+	AddedSince time.Time `json:"since,omitzero"`
+	Sort       string    `json:"sort,omitempty"` // Defaults to SortRelevance when searching, SortAdded otherwise
+	Desc       bool      `json:"desc,omitempty"`
+
+	// Limit is the page size, 0 for no limit. Cursor is the NextCursor of the
+	// previous page: it carries the query, so the filters and sort order above
+	// are ignored when it is set.
+	Limit  int    `json:"-"`
+	Cursor string `json:"-"`
+}
+
+// FilePage is a page of files returned by ListFiles
+type FilePage struct {
+	Files      []protocol.FileListItem
+	NextCursor string // Empty on the last page
+}
+
+// fileCursor is the content of a cursor: the query and the sort key of the
+// last file returned, after which the next page starts
+type fileCursor struct {
+	Query FileQuery    `json:"q"`
+	After filePosition `json:"a"`
+}
+
+// filePosition holds the hash of a file and the field it is sorted by
+type filePosition struct {
+	Hash    string    `json:"h"`
+	Name    string    `json:"n,omitempty"`
+	Size    int64     `json:"s,omitempty"`
+	Seeders int       `json:"p,omitempty"`
+	AddedAt time.Time `json:"t,omitzero"`
+	Score   float64   `json:"r,omitempty"`
+}
+
+// resolve returns the query to run, taken from the cursor if there is one,
+// and the file the page starts after
+func (q FileQuery) resolve() (FileQuery, *protocol.FileListItem, error) {
+	var after *protocol.FileListItem
+	if q.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		var c fileCursor
+		if err == nil {
+			err = json.Unmarshal(data, &c)
+		}
+		if err != nil || c.After.Hash == "" {
+			return q, nil, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+		}
+		c.Query.Limit = q.Limit
+		q = c.Query
+		after = &protocol.FileListItem{Hash: c.After.Hash, Name: c.After.Name, Size: c.After.Size,
+			Seeders: c.After.Seeders, AddedAt: c.After.AddedAt, Score: c.After.Score}
+	}
+	searching := len(q.terms()) > 0
+	if q.Sort == "" {
+		q.Sort = SortAdded
+		if searching {
+			q.Sort = SortRelevance
+		}
+	}
+	if !slices.Contains([]string{SortAdded, SortName, SortSize, SortSeeders, SortRelevance}, q.Sort) {
+		return q, nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, q.Sort)
+	}
+	if q.Sort == SortRelevance && !searching {
+		return q, nil, fmt.Errorf("%w: sorting by relevance needs a search", ErrInvalidQuery)
+	}
+	return q, after, nil
+}
+
+// terms returns the words of the search
+func (q FileQuery) terms() []string {
+	return searchTerms(q.Search)
+}
+
+// matchesFile reports whether a file is listed and passes the filters other
+// than the search and those depending on its peers
+func (q FileQuery) matchesFile(file *models.File) bool {
+	if !isListed(file) {
+		return false
+	}
+	category := file.Category
+	if category == "" {
+		category = "other"
+	}
+	switch {
+	case q.Category != "" && !strings.EqualFold(category, q.Category),
+		file.Size < q.MinSize,
+		q.MaxSize > 0 && file.Size > q.MaxSize,
+		!q.AddedSince.IsZero() && file.AddedAt.Before(q.AddedSince):
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(file.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders two files by the sort field then by hash, so that the
+// order is total and pages neither skip nor repeat files
+func (q FileQuery) compare(a, b protocol.FileListItem) int {
+	var c int
+	switch q.Sort {
+	case SortName:
+		c = strings.Compare(a.Name, b.Name)
+	case SortSize:
+		c = cmp.Compare(a.Size, b.Size)
+	case SortSeeders:
+		c = cmp.Compare(a.Seeders, b.Seeders)
+	case SortRelevance:
+		c = cmp.Compare(a.Score, b.Score)
+	default:
+		c = a.AddedAt.Compare(b.AddedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Hash, b.Hash)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// page returns the first Limit sorted items, with a cursor if more follow,
+// and highlights the search in their names. Backends pass Limit+1 items when
+// there are more.
+func (q FileQuery) page(items []protocol.FileListItem) *FilePage {
+	if terms := q.terms(); len(terms) > 0 {
+		for i := range items {
+			items[i].Highlights = highlights(items[i].Name, terms)
+		}
+	}
+	page := &FilePage{Files: items}
+	if page.Files == nil {
+		page.Files = []protocol.FileListItem{}
+	}
+	if q.Limit <= 0 || len(items) <= q.Limit {
+		return page
+	}
+	page.Files = items[:q.Limit]
+	last := page.Files[q.Limit-1]
+	c := fileCursor{Query: q, After: filePosition{Hash: last.Hash}}
+	switch q.Sort {
+	case SortName:
+		c.After.Name = last.Name
+	case SortSize:
+		c.After.Size = last.Size
+	case SortSeeders:
+		c.After.Seeders = last.Seeders
+	case SortRelevance:
+		c.After.Score = last.Score
+	default:
+		c.After.AddedAt = last.AddedAt
+	}
+	data, _ := json.Marshal(c)
+	page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	return page
+}
+
+// fileQuerySQL returns the statement and arguments of a ListFiles query for
+// the SQL storages, whose files, file_peers and peers tables have the same
+// columns. It selects hash, name, size, added_at, category, tags (a JSON
+// array), seeders, leechers and score.
+func fileQuerySQL(q FileQuery, after *protocol.FileListItem) (string, []any) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// added_at is a TIMESTAMP holding the local time of the tracker
+	fileConds := []string{listedSQL}
+	score := "0::real"
+	if terms := q.terms(); len(terms) > 0 {
+		tsQuery := "to_tsquery('simple', " + arg(tsQuery(terms)) + ")"
+		fileConds = append(fileConds, "f.search_vector @@ "+tsQuery)
+		score = "ts_rank(f.search_vector, " + tsQuery + ")"
+	}
+	if q.Category != "" {
+		fileConds = append(fileConds, "LOWER(COALESCE(NULLIF(f.category, ''), 'other')) = LOWER("+arg(q.Category)+")")
+	}
+	if len(q.Tags) > 0 {
+		tags, _ := json.Marshal(q.Tags)
+		fileConds = append(fileConds, "COALESCE(f.tags::jsonb, '[]'::jsonb) @> "+arg(string(tags))+"::jsonb")
+	}
+	if q.MinSize > 0 {
+		fileConds = append(fileConds, "f.size >= "+arg(q.MinSize))
+	}
+	if q.MaxSize > 0 {
+		fileConds = append(fileConds, "f.size <= "+arg(q.MaxSize))
+	}
+	if !q.AddedSince.IsZero() {
+		fileConds = append(fileConds, "f.added_at >= "+arg(q.AddedSince.Local())+"::timestamp")
+	}
+
+	pageConds := []string{"TRUE"}
+	if q.MinSeeders > 0 {
+		pageConds = append(pageConds, "seeders >= "+arg(q.MinSeeders))
+	}
+	// Names and hashes are compared byte by byte, as MemoryStorage does
+	key := map[string]string{
+		SortAdded:     "added_at",
+		SortName:      `name COLLATE "C"`,
+		SortSize:      "size",
+		SortSeeders:   "seeders",
+		SortRelevance: "score",
+	}[q.Sort]
+	direction, op := "ASC", ">"
+	if q.Desc {
+		direction, op = "DESC", "<"
+	}
+	if after != nil {
+		var value string
+		switch q.Sort {
+		case SortName:
+			value = arg(after.Name)
+		case SortSize:
+			value = arg(after.Size)
+		case SortSeeders:
+			value = arg(after.Seeders)
+		case SortRelevance:
+			value = arg(after.Score) + "::real"
+		default:
+			// Read back from added_at, so already in its time zone
+			value = arg(after.AddedAt) + "::timestamp"
+		}
+		pageConds = append(pageConds, fmt.Sprintf(`(%s, hash COLLATE "C") %s (%s, %s)`, key, op, value, arg(after.Hash)))
+	}
+
+	query := fmt.Sprintf(`SELECT hash, name, size, added_at, category, tags, seeders, leechers, score FROM (
+		SELECT f.hash, f.name, f.size, f.added_at, COALESCE(NULLIF(f.category, ''), 'other') AS category,
+			COALESCE(f.tags::text, '[]') AS tags, c.seeders, c.leechers, %s AS score
+		FROM files f
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE fp.is_seeder) AS seeders,
+				COUNT(*) FILTER (WHERE NOT fp.is_seeder) AS leechers
+			FROM file_peers fp
+			JOIN peers p ON p.id = fp.peer_id AND p.is_online
+			WHERE fp.file_hash = f.hash
+		) c
+		WHERE %s
+	) f
+	WHERE %s
+	ORDER BY %s %s, hash COLLATE "C" %s`,
+		score, strings.Join(fileConds, " AND "), strings.Join(pageConds, " AND "), key, direction, direction)
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit+1)
+	}
+	return query, args
+}
+
+// searchVectorSQL is the expression of the search_vector column of the SQL
+// storages, given the text of the tags column. Punctuation is replaced by
+// spaces first, so that Postgres splits names into the words of tokenize
+// rather than reading "ubuntu-24.04.iso" as a file name, and the weights
+// are those of fieldWeights. Which non-ASCII characters [:alnum:] keeps
+// depends on the database locale (see the relevance notes in search.go).
+func searchVectorSQL(tags string) string {
+	words := func(column string) string {
+		return fmt.Sprintf(`to_tsvector('simple', regexp_replace(lower(COALESCE(%s, '')), '[^[:alnum:]]+', ' ', 'g'))`, column)
+	}
+	return fmt.Sprintf("setweight(%s, 'A') || setweight(%s, 'B') || setweight(%s, 'C')",
+		words("name"), words(tags), words("category"))
+}
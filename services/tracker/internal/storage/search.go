@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+)
+
+// Relevance is computed by each storage and only orders the results of that
+// storage. MemoryStorage scores a file as the sum over the terms of the best
+// field weight each matches, halved for prefix matches. The SQL storages use
+// ts_rank, which also counts how often words occur and does not tell prefix
+// from whole-word matches, and split words with the [:alnum:] class of the
+// database locale rather than unicode.IsLetter and unicode.IsDigit. Both rank
+// name matches above tag matches above category matches, but scores, and so
+// relevance cursors, are not comparable between storages.
+
+// Fields of a file that are searched, and their weights in the relevance of
+// a match. The weights are those of Postgres ts_rank for the A, B and C
+// labels the SQL storages give them.
+const (
+	fieldName = 1 << iota
+	fieldTags
+	fieldCategory
+)
+
+var fieldWeights = []struct {
+	field  int
+	weight float64
+}{{fieldName, 1.0}, {fieldTags, 0.4}, {fieldCategory, 0.2}}
+
+// prefixPenalty scales the relevance of a term that is only the prefix of a
+// word, so that "iso" ranks "ubuntu.iso" above "isolinux"
+const prefixPenalty = 0.5
+
+// tokenize splits text into lowercase words of letters and digits, so that
+// "ubuntu-24.04-live-server-amd64.iso" is found by "ubuntu 24 server iso"
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isWordSeparator)
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// searchTerms returns the distinct words of a search
+func searchTerms(search string) []string {
+	terms := tokenize(search)
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+// tsQuery returns the Postgres tsquery of a search: every word, each one as a
+// prefix. Words only hold letters and digits, so need no quoting.
+func tsQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// highlights returns the byte ranges of the words of name that start with a
+// search term
+func highlights(name string, terms []string) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for i, r := range name + " " {
+		if !isWordSeparator(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			word := strings.ToLower(name[start:i])
+			if slices.ContainsFunc(terms, func(term string) bool { return strings.HasPrefix(word, term) }) {
+				ranges = append(ranges, [2]int{start, i})
+			}
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// searchIndex is the inverted index of MemoryStorage: for each word of the
+// names, tags and categories, the files it is in. It is guarded by the
+// storage lock.
+type searchIndex struct {
+	postings map[string]map[string]int // Word -> file hash -> fields holding the word
+	words    []string                  // Words of postings, sorted for prefix lookups
+	files    map[string][]string       // File hash -> its words, to remove them
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		files:    make(map[string][]string),
+	}
+}
+
+// add indexes a file, replacing what was indexed under its hash
+func (x *searchIndex) add(file *models.File) {
+	x.remove(file.Hash)
+
+	fields := make(map[string]int)
+	for _, word := range tokenize(file.Name) {
+		fields[word] |= fieldName
+	}
+	for _, tag := range file.Tags {
+		for _, word := range tokenize(tag) {
+			fields[word] |= fieldTags
+		}
+	}
+	category := file.Category
+	if category == "" {
+		category = "other"
+	}
+	for _, word := range tokenize(category) {
+		fields[word] |= fieldCategory
+	}
+
+	for word, f := range fields {
+		files, ok := x.postings[word]
+		if !ok {
+			files = make(map[string]int)
+			x.postings[word] = files
+			i, _ := slices.BinarySearch(x.words, word)
+			x.words = slices.Insert(x.words, i, word)
+		}
+		files[file.Hash] = f
+		x.files[file.Hash] = append(x.files[file.Hash], word)
+	}
+}
+
+// remove drops a file from the index
+func (x *searchIndex) remove(hash string) {
+	for _, word := range x.files[hash] {
+		files := x.postings[word]
+		delete(files, hash)
+		if len(files) == 0 {
+			delete(x.postings, word)
+			if i, found := slices.BinarySearch(x.words, word); found {
+				x.words = slices.Delete(x.words, i, i+1)
+			}
+		}
+	}
+	delete(x.files, hash)
+}
+
+// search returns the relevance of the files holding a word starting with
+// each term
+func (x *searchIndex) search(terms []string) map[string]float64 {
+	var scores map[string]float64
+	for _, term := range terms {
+		// Best score of the term in each file
+		termScores := make(map[string]float64)
+		i, _ := slices.BinarySearch(x.words, term)
+		for ; i < len(x.words) && strings.HasPrefix(x.words[i], term); i++ {
+			penalty := 1.0
+			if x.words[i] != term {
+				penalty = prefixPenalty
+			}
+			for hash, fields := range x.postings[x.words[i]] {
+				termScores[hash] = max(termScores[hash], penalty*fieldWeight(fields))
+			}
+		}
+
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for hash, score := range scores {
+			if termScore, ok := termScores[hash]; ok {
+				scores[hash] = score + termScore
+			} else {
+				delete(scores, hash)
+			}
+		}
+	}
+	return scores
+}
+
+// fieldWeight returns the weight of the heaviest of fields
+func fieldWeight(fields int) float64 {
+	for _, fw := range fieldWeights {
+		if fields&fw.field != 0 {
+			return fw.weight
+		}
+	}
+	return 0
+}
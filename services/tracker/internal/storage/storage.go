@@ -1,7 +1,9 @@
 package storage
 
 import (
-	"strings"
+	"cmp"
+	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,18 +13,28 @@ import (
 
 // MemoryStorage is an in-memory implementation of the storage
 type MemoryStorage struct {
-	mu        sync.RWMutex
-	peers     map[string]*models.Peer      // peerID -> Peer
-	files     map[string]*models.File      // fileHash -> File
-	filePeers map[string][]models.FilePeer // fileHash -> []FilePeer
+	mu         sync.RWMutex
+	peers      map[string]*models.Peer       // peerID -> Peer
+	files      map[string]*models.File       // fileHash -> File
+	filePeers  map[string][]models.FilePeer  // fileHash -> []FilePeer
+	groups     map[string]*models.Group      // name -> Group
+	swarms     map[string]*models.SwarmStats // fileHash -> completions and bytes transferred
+	completed  map[string]map[string]bool    // fileHash -> peers whose completion was counted
+	index      *searchIndex                  // Full-text index of the files
+	infoHashes map[string]string             // BitTorrent info hash -> fileHash
 }
 
 // NewMemoryStorage creates a new in-memory storage
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		peers:     make(map[string]*models.Peer),
-		files:     make(map[string]*models.File),
-		filePeers: make(map[string][]models.FilePeer),
+		peers:      make(map[string]*models.Peer),
+		files:      make(map[string]*models.File),
+		filePeers:  make(map[string][]models.FilePeer),
+		groups:     make(map[string]*models.Group),
+		swarms:     make(map[string]*models.SwarmStats),
+		completed:  make(map[string]map[string]bool),
+		index:      newSearchIndex(),
+		infoHashes: make(map[string]string),
 	}
 }
 
@@ -36,6 +48,9 @@ func (s *MemoryStorage) RegisterPeer(peer *models.Peer) error {
 	peer.RegisteredAt = time.Now()
 	peer.LastSeen = time.Now()
 	peer.IsOnline = true
+	if peer.Reputation == 0 {
+		peer.Reputation = defaultReputation
+	}
 	s.peers[peer.ID] = peer
 	return nil
 }
@@ -148,8 +163,10 @@ func (s *MemoryStorage) DeleteOrphanFiles() int {
 	}
 
 	for _, hash := range toDelete {
+		s.unindexInfoHashes(s.files[hash])
 		delete(s.files, hash)
 		delete(s.filePeers, hash)
+		s.index.remove(hash)
 	}
 
 	return len(toDelete)
@@ -161,37 +178,104 @@ func (s *MemoryStorage) AddFile(file *models.File) error {
 	defer s.mu.Unlock()
 
 	file.AddedAt = time.Now()
+	// Like the SQL storages, an announce keeps when and by whom the file was
+	// first added, its labels and access, and the known info hashes if it has
+	// none
+	if existing, ok := s.files[file.Hash]; ok {
+		file.AddedAt, file.AddedBy = existing.AddedAt, existing.AddedBy
+		keepLabels(file, existing)
+		keepAccess(file, existing)
+		if file.BTInfoHash == "" && file.BTInfoHashV2 == "" {
+			file.BTInfoHash, file.BTInfoHashV2 = existing.BTInfoHash, existing.BTInfoHashV2
+		}
+		s.unindexInfoHashes(existing)
+	}
 	s.files[file.Hash] = file
+	s.index.add(file)
+	for _, h := range []string{file.BTInfoHash, file.BTInfoHashV2} {
+		if h != "" {
+			s.infoHashes[h] = file.Hash
+		}
+	}
 	return nil
 }
 
-// GetFile retrieves a file by hash
+// unindexInfoHashes removes the info hashes of file from the index, unless
+// they were taken over by another file. Caller must hold the lock.
+func (s *MemoryStorage) unindexInfoHashes(file *models.File) {
+	if file == nil {
+		return
+	}
+	for _, h := range []string{file.BTInfoHash, file.BTInfoHashV2} {
+		if h != "" && s.infoHashes[h] == file.Hash {
+			delete(s.infoHashes, h)
+		}
+	}
+}
+
+// GetFile retrieves a file by hash or by BitTorrent info hash
 func (s *MemoryStorage) GetFile(hash string) (*models.File, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	file, exists := s.files[hash]
-	return file, exists
+	if file, exists := s.files[hash]; exists {
+		return file, true
+	}
+	if fileHash, ok := s.infoHashes[hash]; ok {
+		file, exists := s.files[fileHash]
+		return file, exists
+	}
+	return nil, false
 }
 
-// ListFiles returns all files
-func (s *MemoryStorage) ListFiles() []protocol.FileListItem {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// ListFiles returns a page of the files matching q
+func (s *MemoryStorage) ListFiles(q FileQuery) (*FilePage, error) {
+	q, after, err := q.resolve()
+	if err != nil {
+		return nil, err
+	}
 
+	s.mu.RLock()
+	// A search only looks at the files the index finds
+	files := s.files
+	var scores map[string]float64
+	if terms := q.terms(); len(terms) > 0 {
+		scores = s.index.search(terms)
+		files = make(map[string]*models.File, len(scores))
+		for hash := range scores {
+			files[hash] = s.files[hash]
+		}
+	}
 	var items []protocol.FileListItem
-	for _, file := range s.files {
+	for _, file := range files {
+		if !q.matchesFile(file) {
+			continue
+		}
+		score := scores[file.Hash]
 		seeders, leechers := s.countPeers(file.Hash)
-		items = append(items, protocol.FileListItem{
+		item := protocol.FileListItem{
 			Hash:     file.Hash,
 			Name:     file.Name,
 			Size:     file.Size,
 			Seeders:  seeders,
 			Leechers: leechers,
 			AddedAt:  file.AddedAt,
-		})
+			Category: cmp.Or(file.Category, "other"),
+			Tags:     file.Tags,
+			Score:    score,
+		}
+		if seeders < q.MinSeeders || (after != nil && q.compare(item, *after) <= 0) {
+			continue
+		}
+		items = append(items, item)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(items, q.compare)
+	if q.Limit > 0 && len(items) > q.Limit+1 {
+		items = items[:q.Limit+1]
 	}
-	return items
+	return q.page(items), nil
 }
 
 // countPeers counts seeders and leechers for a file (must be called with lock)
@@ -219,31 +303,96 @@ func (s *MemoryStorage) AddFilePeer(fp *models.FilePeer) error {
 
 	fp.AddedAt = time.Now()
 	fp.LastUpdated = time.Now()
+
+	// A peer announcing again replaces its entry, like the upsert of the SQL storages
+	for i, existing := range s.filePeers[fp.FileHash] {
+		if existing.PeerID == fp.PeerID {
+			fp.AddedAt = existing.AddedAt
+			fp.Transfer, fp.Paused, fp.Completed = existing.Transfer, existing.Paused, existing.Completed
+			s.filePeers[fp.FileHash][i] = *fp
+			return nil
+		}
+	}
 	s.filePeers[fp.FileHash] = append(s.filePeers[fp.FileHash], *fp)
 	return nil
 }
 
-// GetPeersForFile returns all peers that have a file
-func (s *MemoryStorage) GetPeersForFile(fileHash string) []protocol.PeerFileInfo {
+// RemoveFilePeer removes a peer from the peers of a file
+func (s *MemoryStorage) RemoveFilePeer(fileHash, peerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var remaining []models.FilePeer
+	for _, fp := range s.filePeers[fileHash] {
+		if fp.PeerID != peerID {
+			remaining = append(remaining, fp)
+		}
+	}
+	s.filePeers[fileHash] = remaining
+	return nil
+}
+
+// UpdateFilePeerChunks records chunks a peer has of a file it is downloading
+func (s *MemoryStorage) UpdateFilePeerChunks(fileHash, peerID string, chunks protocol.ChunkSet, replace bool, chunkCount int) (protocol.ChunkSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := s.filePeers[fileHash]
+	i := slices.IndexFunc(entries, func(fp models.FilePeer) bool { return fp.PeerID == peerID })
+	if i < 0 {
+		entries = append(entries, models.FilePeer{FileHash: fileHash, PeerID: peerID, AddedAt: now})
+		i = len(entries) - 1
+		s.filePeers[fileHash] = entries
+	}
+
+	fp := &entries[i]
+	merged := chunks.Clone()
+	if !replace {
+		merged.Union(fp.ChunksAvailable)
+	}
+	fp.ChunksAvailable = merged
+	fp.IsSeeder = merged.Complete(chunkCount)
+	fp.LastUpdated = now
+	return merged.Clone(), nil
+}
+
+// GetPeersForFile returns the online peers of a file, or limit of them
+// chosen at random when limit > 0, peerID first if it has the file
+func (s *MemoryStorage) GetPeersForFile(fileHash, peerID string, limit int) []models.SwarmPeer {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var result []protocol.PeerFileInfo
+	var result []models.SwarmPeer
 	for _, fp := range s.filePeers[fileHash] {
 		peer, exists := s.peers[fp.PeerID]
 		if !exists || !peer.IsOnline {
 			continue
 		}
-		result = append(result, protocol.PeerFileInfo{
-			PeerInfo: protocol.PeerInfo{
-				PeerID: peer.ID,
-				IP:     peer.IP,
-				Port:   peer.Port,
+		chunks := fp.ChunksAvailable.Clone()
+		result = append(result, models.SwarmPeer{
+			PeerFileInfo: protocol.PeerFileInfo{
+				PeerInfo: protocol.PeerInfo{
+					PeerID: peer.ID,
+					IP:     peer.IP,
+					Port:   peer.Port,
+				},
+				Availability: &chunks,
+				IsSeeder:     fp.IsSeeder,
 			},
-			ChunksAvailable: fp.ChunksAvailable,
-			IsSeeder:        fp.IsSeeder,
+			Reputation: peer.Reputation,
 		})
 	}
+	if limit > 0 && len(result) > limit {
+		rand.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+		for i := range result {
+			if result[i].PeerID == peerID && peerID != "" {
+				result[0], result[i] = result[i], result[0]
+				break
+			}
+		}
+		result = result[:limit]
+	}
 	return result
 }
 
@@ -281,57 +430,15 @@ func (s *MemoryStorage) DeleteFile(hash string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.unindexInfoHashes(s.files[hash])
 	delete(s.files, hash)
 	delete(s.filePeers, hash)
+	delete(s.swarms, hash)
+	delete(s.completed, hash)
+	s.index.remove(hash)
 	return nil
 }
 
-// SearchFiles searches files by name (case-insensitive)
-func (s *MemoryStorage) SearchFiles(query string) []protocol.FileListItem {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	query = strings.ToLower(query)
-	var items []protocol.FileListItem
-	for _, file := range s.files {
-		if strings.Contains(strings.ToLower(file.Name), query) {
-			seeders, leechers := s.countPeers(file.Hash)
-			items = append(items, protocol.FileListItem{
-				Hash:     file.Hash,
-				Name:     file.Name,
-				Size:     file.Size,
-				Seeders:  seeders,
-				Leechers: leechers,
-				AddedAt:  file.AddedAt,
-			})
-		}
-	}
-	return items
-}
-
-// ListFilesByCategory returns files filtered by category
-func (s *MemoryStorage) ListFilesByCategory(category string) []protocol.FileListItem {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	category = strings.ToLower(category)
-	var items []protocol.FileListItem
-	for _, file := range s.files {
-		if strings.ToLower(file.Category) == category {
-			seeders, leechers := s.countPeers(file.Hash)
-			items = append(items, protocol.FileListItem{
-				Hash:     file.Hash,
-				Name:     file.Name,
-				Size:     file.Size,
-				Seeders:  seeders,
-				Leechers: leechers,
-				AddedAt:  file.AddedAt,
-			})
-		}
-	}
-	return items
-}
-
 // ListCategories returns statistics for all categories
 func (s *MemoryStorage) ListCategories() []CategoryStats {
 	s.mu.RLock()
@@ -339,6 +446,9 @@ func (s *MemoryStorage) ListCategories() []CategoryStats {
 
 	stats := make(map[string]*CategoryStats)
 	for _, file := range s.files {
+		if !isListed(file) {
+			continue
+		}
 		cat := file.Category
 		if cat == "" {
 			cat = "other"
@@ -402,10 +512,14 @@ func (s *MemoryStorage) GetTopPeers(limit int) []*models.Peer {
 	return peers[:limit]
 }
 
+// defaultReputation is the reputation of a peer without stats, as the
+// default of the reputation column
+const defaultReputation = 50.0
+
 // calculateReputation calculates peer reputation score (0-100)
 func calculateReputation(peer *models.Peer) float64 {
 	// Base score: 50
-	score := 50.0
+	score := defaultReputation
 
 	// Upload/download ratio bonus (max +30)
 	if peer.BytesDownloaded > 0 {
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -81,6 +84,36 @@ func TestAddAndGetFile(t *testing.T) {
 	}
 }
 
+func TestGetFile_BitTorrentInfoHash(t *testing.T) {
+	s := NewMemoryStorage()
+	s.AddFile(&models.File{Hash: "abc123", Name: "plain.txt"})
+	s.AddFile(&models.File{Hash: "def456", Name: "mirror.iso", BTInfoHash: "b1b1", BTInfoHashV2: "b2b2"})
+
+	for _, hash := range []string{"def456", "b1b1", "b2b2"} {
+		got, exists := s.GetFile(hash)
+		if !exists || got.Hash != "def456" {
+			t.Errorf("GetFile(%s) = %v, %v, want the mirrored file", hash, got, exists)
+		}
+	}
+	if _, exists := s.GetFile(""); exists {
+		t.Error("GetFile(\"\") should not match files without info hashes")
+	}
+
+	// A new info hash replaces the old one in the index
+	s.AddFile(&models.File{Hash: "def456", Name: "mirror.iso", BTInfoHash: "c1c1"})
+	if _, exists := s.GetFile("b1b1"); exists {
+		t.Error("GetFile(b1b1) should not find the file after its info hash changed")
+	}
+	if got, exists := s.GetFile("c1c1"); !exists || got.Hash != "def456" {
+		t.Errorf("GetFile(c1c1) = %v, %v, want the mirrored file", got, exists)
+	}
+
+	s.DeleteFile("def456")
+	if _, exists := s.GetFile("c1c1"); exists {
+		t.Error("GetFile(c1c1) should not find a deleted file")
+	}
+}
+
 func TestFilePeerAssociation(t *testing.T) {
 	s := NewMemoryStorage()
 
@@ -96,13 +129,15 @@ func TestFilePeerAssociation(t *testing.T) {
 	fp := &models.FilePeer{
 		FileHash:        "abc123",
 		PeerID:          "peer-1",
-		ChunksAvailable: []int{0, 1, 2},
+		ChunksAvailable: protocol.FullChunkSet(3),
 		IsSeeder:        true,
 	}
 	s.AddFilePeer(fp)
+	// Announcing again must not add a second entry
+	s.AddFilePeer(&models.FilePeer{FileHash: "abc123", PeerID: "peer-1", ChunksAvailable: protocol.FullChunkSet(3), IsSeeder: true})
 
 	// Get peers for file
-	peers := s.GetPeersForFile("abc123")
+	peers := s.GetPeersForFile("abc123", "", 0)
 
 	if len(peers) != 1 {
 		t.Fatalf("Expected 1 peer, got %d", len(peers))
@@ -115,6 +150,135 @@ func TestFilePeerAssociation(t *testing.T) {
 	if !peers[0].IsSeeder {
 		t.Error("Peer should be seeder")
 	}
+
+	if peers[0].Availability == nil || peers[0].Availability.String() != "0-2" {
+		t.Errorf("Expected availability 0-2, got %v", peers[0].Availability)
+	}
+}
+
+func TestGetPeersForFile_Limit(t *testing.T) {
+	s := NewMemoryStorage()
+	s.AddFile(&models.File{Hash: "abc123", Name: "test.txt", Size: 1024})
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("peer-%d", i)
+		s.RegisterPeer(&models.Peer{ID: id, IP: "192.168.1.1", Port: 6881 + i})
+		s.AddFilePeer(&models.FilePeer{FileHash: "abc123", PeerID: id, IsSeeder: true})
+	}
+
+	if peers := s.GetPeersForFile("abc123", "", 3); len(peers) != 3 {
+		t.Errorf("Expected 3 peers with limit 3, got %d", len(peers))
+	}
+	if peers := s.GetPeersForFile("abc123", "", 0); len(peers) != 10 {
+		t.Errorf("Expected all 10 peers with limit 0, got %d", len(peers))
+	}
+	// The requesting peer is always in the sample
+	for i := 0; i < 20; i++ {
+		if peers := s.GetPeersForFile("abc123", "peer-7", 2); len(peers) != 2 || peers[0].PeerID != "peer-7" {
+			t.Fatalf("Expected peer-7 first of 2 peers, got %v", peers)
+		}
+	}
+	if peers := s.GetPeersForFile("abc123", "", 1); peers[0].Reputation != defaultReputation {
+		t.Errorf("Expected the default reputation, got %v", peers[0].Reputation)
+	}
+}
+
+func TestUpdateFilePeerChunks(t *testing.T) {
+	s := NewMemoryStorage()
+	s.RegisterPeer(&models.Peer{ID: "seeder", IP: "192.168.1.1", Port: 6881})
+	s.RegisterPeer(&models.Peer{ID: "leecher", IP: "192.168.1.2", Port: 6881})
+	s.AddFile(&models.File{Hash: "abc123", Name: "test.txt", Size: 1024})
+	s.AddFilePeer(&models.FilePeer{FileHash: "abc123", PeerID: "seeder", ChunksAvailable: protocol.FullChunkSet(10), IsSeeder: true})
+
+	chunks, _ := s.UpdateFilePeerChunks("abc123", "leecher", protocol.NewChunkSet(0, 1, 2), false, 10)
+	if chunks.String() != "0-2" {
+		t.Errorf("Expected chunks 0-2, got %s", chunks)
+	}
+	chunks, _ = s.UpdateFilePeerChunks("abc123", "leecher", protocol.NewChunkSet(5), false, 10)
+	if chunks.String() != "0-2,5" {
+		t.Errorf("Expected an incremental update to add chunk 5, got %s", chunks)
+	}
+
+	page, _ := s.ListFiles(FileQuery{})
+	if files := page.Files; len(files) != 1 || files[0].Seeders != 1 || files[0].Leechers != 1 {
+		t.Errorf("Expected 1 seeder and 1 leecher, got %+v", files)
+	}
+
+	// A full report replaces the chunks, and the peer seeds once it has them all
+	chunks, _ = s.UpdateFilePeerChunks("abc123", "leecher", protocol.FullChunkSet(10), true, 10)
+	if !chunks.Complete(10) {
+		t.Errorf("Expected all chunks, got %s", chunks)
+	}
+	if page, _ := s.ListFiles(FileQuery{}); page.Files[0].Seeders != 2 || page.Files[0].Leechers != 0 {
+		t.Errorf("Expected 2 seeders after completion, got %+v", page.Files[0])
+	}
+}
+
+func TestRecordTransfer(t *testing.T) {
+	s := NewMemoryStorage()
+	s.RegisterPeer(&models.Peer{ID: "seeder", IP: "192.168.1.1", Port: 6881, IsOnline: true})
+	s.RegisterPeer(&models.Peer{ID: "leecher", IP: "192.168.1.2", Port: 6881, IsOnline: true})
+	s.AddFile(&models.File{Hash: "abc123", Name: "test.txt", Size: 1000})
+	s.AddFilePeer(&models.FilePeer{FileHash: "abc123", PeerID: "seeder", ChunksAvailable: protocol.FullChunkSet(10), IsSeeder: true})
+	s.UpdateFilePeerChunks("abc123", "leecher", protocol.NewChunkSet(0), true, 10)
+
+	report := func(peerID, event string, uploaded, downloaded int64) (int64, int64) {
+		up, down, err := s.RecordTransfer(TransferReport{FileHash: "abc123", PeerID: peerID, Event: event,
+			Transfer: protocol.Transfer{Uploaded: uploaded, Downloaded: downloaded, Left: 1000 - downloaded}})
+		if err != nil {
+			t.Fatalf("RecordTransfer: %v", err)
+		}
+		return up, down
+	}
+
+	// Counters add their increase since the last report
+	report("leecher", protocol.AnnounceStarted, 0, 100)
+	if _, down := report("leecher", "", 0, 400); down != 300 {
+		t.Errorf("Expected an increase of 300, got %d", down)
+	}
+	report("seeder", "", 400, 0)
+	report("leecher", protocol.AnnouncePaused, 0, 400)
+
+	stats := s.GetSwarmStats([]string{"abc123", "unknown"})
+	want := models.SwarmStats{Hash: "abc123", Seeders: 1, Leechers: 1, Uploaded: 400, Downloaded: 400}
+	if *stats["abc123"] != want {
+		t.Errorf("Expected %+v, got %+v", want, *stats["abc123"])
+	}
+	if got := stats["unknown"]; got == nil || *got != (models.SwarmStats{Hash: "unknown"}) {
+		t.Errorf("Expected empty stats for an unknown file, got %+v", got)
+	}
+
+	// A restarted peer counts from zero; a completion is counted once
+	report("leecher", protocol.AnnounceStarted, 0, 200)
+	report("leecher", protocol.AnnounceCompleted, 0, 1000)
+	report("leecher", protocol.AnnounceCompleted, 0, 1000)
+	if _, down := report("outsider", "", 0, 500); down != 0 {
+		t.Errorf("Expected no increase for a peer not in the swarm, got %d", down)
+	}
+	stats = s.GetSwarmStats([]string{"abc123"})
+	want = models.SwarmStats{Hash: "abc123", Seeders: 1, Leechers: 1, Downloaders: 1, Completed: 1, Uploaded: 400, Downloaded: 1400}
+	if *stats["abc123"] != want {
+		t.Errorf("Expected %+v, got %+v", want, *stats["abc123"])
+	}
+}
+
+func TestRemoveFilePeer(t *testing.T) {
+	s := NewMemoryStorage()
+
+	s.RegisterPeer(&models.Peer{ID: "peer-1", IP: "192.168.1.1", Port: 6881})
+	s.RegisterPeer(&models.Peer{ID: "peer-2", IP: "192.168.1.2", Port: 6881})
+	s.AddFile(&models.File{Hash: "abc123", Name: "test.txt", Size: 1024})
+	s.AddFilePeer(&models.FilePeer{FileHash: "abc123", PeerID: "peer-1", IsSeeder: true})
+	s.AddFilePeer(&models.FilePeer{FileHash: "abc123", PeerID: "peer-2", IsSeeder: true})
+
+	s.RemoveFilePeer("abc123", "peer-1")
+
+	peers := s.GetPeersForFile("abc123", "", 0)
+	if len(peers) != 1 || peers[0].PeerID != "peer-2" {
+		t.Errorf("Expected only peer-2 after withdrawal, got %v", peers)
+	}
+	if _, exists := s.GetPeer("peer-1"); !exists {
+		t.Error("Withdrawing a file should not remove the peer")
+	}
 }
 
 func TestCleanupOfflinePeers(t *testing.T) {
@@ -147,8 +311,305 @@ func TestListFiles(t *testing.T) {
 	s.AddFile(&models.File{Hash: "hash1", Name: "file1.txt", Size: 100})
 	s.AddFile(&models.File{Hash: "hash2", Name: "file2.txt", Size: 200})
 
-	files := s.ListFiles()
-	if len(files) != 2 {
-		t.Errorf("Expected 2 files, got %d", len(files))
+	page, err := s.ListFiles(FileQuery{})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	if len(page.Files) != 2 || page.NextCursor != "" {
+		t.Errorf("Expected 2 files on one page, got %+v", page)
+	}
+}
+
+func TestListFiles_FiltersAndSort(t *testing.T) {
+	s := NewMemoryStorage()
+	s.RegisterPeer(&models.Peer{ID: "peer-1", IP: "127.0.0.1", Port: 6881})
+	s.RegisterPeer(&models.Peer{ID: "peer-2", IP: "127.0.0.2", Port: 6881})
+	s.AddFile(&models.File{Hash: "a", Name: "Movie.mkv", Size: 3000, Category: "video", Tags: []string{"hd", "2024"}})
+	s.AddFile(&models.File{Hash: "b", Name: "movie-trailer.mp4", Size: 100, Category: "video", Tags: []string{"hd"}})
+	s.AddFile(&models.File{Hash: "c", Name: "notes.txt", Size: 10})
+	s.AddFilePeer(&models.FilePeer{FileHash: "a", PeerID: "peer-1", IsSeeder: true})
+	s.AddFilePeer(&models.FilePeer{FileHash: "a", PeerID: "peer-2", IsSeeder: true})
+	s.AddFilePeer(&models.FilePeer{FileHash: "b", PeerID: "peer-1", IsSeeder: true})
+
+	hashes := func(q FileQuery) string {
+		t.Helper()
+		page, err := s.ListFiles(q)
+		if err != nil {
+			t.Fatalf("ListFiles(%+v) failed: %v", q, err)
+		}
+		var result string
+		for _, f := range page.Files {
+			result += f.Hash
+		}
+		return result
+	}
+
+	cases := []struct {
+		query FileQuery
+		want  string
+	}{
+		{FileQuery{Search: "MOVIE", Sort: SortName}, "ab"},
+		{FileQuery{Category: "Video", Sort: SortSize}, "ba"},
+		{FileQuery{Category: "other"}, "c"},
+		{FileQuery{Tags: []string{"hd", "2024"}}, "a"},
+		{FileQuery{MinSize: 50, MaxSize: 1000}, "b"},
+		{FileQuery{MinSeeders: 1, Sort: SortSeeders, Desc: true}, "ab"},
+		{FileQuery{Sort: SortName, Desc: true}, "cba"},
+		{FileQuery{AddedSince: time.Now().Add(time.Hour)}, ""},
+	}
+	for _, c := range cases {
+		if got := hashes(c.query); got != c.want {
+			t.Errorf("ListFiles(%+v) = %q, want %q", c.query, got, c.want)
+		}
+	}
+
+	if _, err := s.ListFiles(FileQuery{Sort: "popularity"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery for an unknown sort, got %v", err)
+	}
+}
+
+func TestListFiles_Pagination(t *testing.T) {
+	s := NewMemoryStorage()
+	for i := 0; i < 25; i++ {
+		// Sizes repeat so that pages split files of equal size
+		s.AddFile(&models.File{Hash: fmt.Sprintf("hash%02d", i), Name: fmt.Sprintf("file%02d.bin", i), Size: int64(i % 4)})
+	}
+
+	seen := make(map[string]bool)
+	var last protocol.FileListItem
+	q := FileQuery{Sort: SortSize, Search: "file", Limit: 10}
+	for pages := 1; ; pages++ {
+		page, err := s.ListFiles(q)
+		if err != nil {
+			t.Fatalf("ListFiles failed: %v", err)
+		}
+		for _, f := range page.Files {
+			if seen[f.Hash] {
+				t.Errorf("%s returned twice", f.Hash)
+			}
+			if len(seen) > 0 && (f.Size < last.Size || (f.Size == last.Size && f.Hash < last.Hash)) {
+				t.Errorf("%s (size %d) out of order after %s", f.Hash, f.Size, last.Hash)
+			}
+			seen[f.Hash] = true
+			last = f
+		}
+		if page.NextCursor == "" {
+			if pages != 3 {
+				t.Errorf("Expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		// The cursor carries the query
+		q = FileQuery{Limit: 10, Cursor: page.NextCursor}
+	}
+	if len(seen) != 25 {
+		t.Errorf("Expected 25 files over all pages, got %d", len(seen))
+	}
+
+	if _, err := s.ListFiles(FileQuery{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery for a bad cursor, got %v", err)
+	}
+}
+
+func TestFileQuerySQL(t *testing.T) {
+	q := FileQuery{Search: "Ubuntu 24.04_server", Tags: []string{"hd"}, MinSeeders: 1, Sort: SortName, Desc: true, Limit: 10}
+	query, args := fileQuerySQL(q, &protocol.FileListItem{Hash: "abc", Name: "m"})
+
+	for _, want := range []string{
+		`f.search_vector @@ to_tsquery('simple', $1)`,
+		`@> $2::jsonb`,
+		`seeders >= $3`,
+		`(name COLLATE "C", hash COLLATE "C") < ($4, $5)`,
+		`ORDER BY name COLLATE "C" DESC, hash COLLATE "C" DESC`,
+		`LIMIT $6`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query lacks %s:\n%s", want, query)
+		}
+	}
+	if len(args) != 6 || args[0] != "04:* & 24:* & server:* & ubuntu:*" || args[1] != `["hd"]` || args[5] != 11 {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestFileQuerySQL_Relevance(t *testing.T) {
+	q := FileQuery{Search: "iso", Desc: true, Limit: 10}
+	q, _, err := q.resolve()
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	query, args := fileQuerySQL(q, &protocol.FileListItem{Hash: "abc", Score: 0.5})
+
+	for _, want := range []string{
+		`ts_rank(f.search_vector, to_tsquery('simple', $1)) AS score`,
+		`(score, hash COLLATE "C") < ($2::real, $3)`,
+		`ORDER BY score DESC, hash COLLATE "C" DESC`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query lacks %s:\n%s", want, query)
+		}
+	}
+	if len(args) != 4 || args[0] != "iso:*" || args[1] != 0.5 {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestListFiles_Search(t *testing.T) {
+	s := NewMemoryStorage()
+	s.AddFile(&models.File{Hash: "iso", Name: "ubuntu-24.04-live-server-amd64.iso"})
+	s.AddFile(&models.File{Hash: "desktop", Name: "ubuntu.24.04.desktop.amd64.iso"})
+	s.AddFile(&models.File{Hash: "tagged", Name: "image.bin", Tags: []string{"Ubuntu", "server"}})
+	s.AddFile(&models.File{Hash: "linux", Name: "isolinux.bin", Category: "ubuntu"})
+
+	search := func(text string) []protocol.FileListItem {
+		t.Helper()
+		page, err := s.ListFiles(FileQuery{Search: text, Desc: true})
+		if err != nil {
+			t.Fatalf("ListFiles(%q) failed: %v", text, err)
+		}
+		return page.Files
+	}
+
+	// Every word must match, in the name, tags or category
+	files := search("ubuntu 24 server iso")
+	if len(files) != 1 || files[0].Hash != "iso" {
+		t.Fatalf("Expected the server iso, got %+v", files)
+	}
+	if got := fmt.Sprint(files[0].Highlights); got != "[[0 6] [7 9] [18 24] [31 34]]" {
+		t.Errorf("Highlights = %s", got)
+	}
+
+	// Names rank above tags, tags above categories. The order is that of
+	// MemoryStorage's scores; the SQL storages rank with ts_rank (see
+	// TestFileQuerySQL_Relevance).
+	var order []string
+	for _, f := range search("ubuntu") {
+		order = append(order, f.Hash)
+	}
+	if got := fmt.Sprint(order); got != "[iso desktop tagged linux]" {
+		t.Errorf("Expected name matches, then the tag, then the category, got %s", got)
+	}
+
+	// Prefixes match, below whole words
+	files = search("iso")
+	if len(files) != 3 || files[2].Hash != "linux" {
+		t.Errorf("Expected isolinux last, got %+v", files)
+	}
+
+	s.DeleteFile("iso")
+	s.AddFile(&models.File{Hash: "desktop", Name: "debian.iso"})
+	if files := search("ubuntu"); len(files) != 2 {
+		t.Errorf("Expected the index to drop deleted and renamed files, got %+v", files)
+	}
+
+	// Pages follow the relevance
+	page, _ := s.ListFiles(FileQuery{Search: "ubuntu", Desc: true, Limit: 1})
+	next, err := s.ListFiles(FileQuery{Limit: 1, Cursor: page.NextCursor})
+	if err != nil || page.Files[0].Hash != "tagged" || len(next.Files) != 1 || next.Files[0].Hash != "linux" {
+		t.Errorf("Expected tagged then linux, got %+v then %+v (%v)", page.Files, next, err)
+	}
+	if _, err := s.ListFiles(FileQuery{Sort: SortRelevance}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery for relevance without a search, got %v", err)
+	}
+}
+
+func TestFileLabels(t *testing.T) {
+	s := NewMemoryStorage()
+	s.AddFile(&models.File{Hash: "h1", Name: "debian.iso", Category: "software", AddedBy: "p1"})
+	s.AddFile(&models.File{Hash: "h2", Name: "notes.txt", AddedBy: "p1"})
+
+	if err := s.UpdateFileLabels("h1", "software", []string{"linux", "debian"}); err != nil {
+		t.Fatalf("UpdateFileLabels failed: %v", err)
+	}
+	s.UpdateFileLabels("h2", "document", []string{"linux"})
+	if err := s.UpdateFileLabels("missing", "other", nil); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+
+	// A new announce keeps the labels and who added the file first
+	s.AddFile(&models.File{Hash: "h1", Name: "debian.iso", Category: "archive", AddedBy: "p2"})
+	got, _ := s.GetFile("h1")
+	if got.Category != "software" || len(got.Tags) != 2 || got.AddedBy != "p1" {
+		t.Errorf("Expected the labels and owner kept, got %q %v %q", got.Category, got.Tags, got.AddedBy)
+	}
+	// but a category detected from the content replaces "other"
+	s.UpdateFileLabels("h2", "other", []string{"linux"})
+	s.AddFile(&models.File{Hash: "h2", Name: "notes.txt", Category: "document"})
+	if got, _ := s.GetFile("h2"); got.Category != "document" {
+		t.Errorf("Expected category document, got %q", got.Category)
+	}
+
+	tags := s.ListTags(0)
+	want := []TagStats{{"linux", 2}, {"debian", 1}}
+	if fmt.Sprint(tags) != fmt.Sprint(want) {
+		t.Errorf("ListTags() = %v, want %v", tags, want)
+	}
+	if tags := s.ListTags(1); len(tags) != 1 || tags[0].Tag != "linux" {
+		t.Errorf("ListTags(1) = %v", tags)
+	}
+
+	// Tags are searched and filtered on, and listed with the files
+	page, _ := s.ListFiles(FileQuery{Tags: []string{"debian"}})
+	if len(page.Files) != 1 || page.Files[0].Hash != "h1" || page.Files[0].Category != "software" || len(page.Files[0].Tags) != 2 {
+		t.Errorf("Expected h1 with its labels, got %+v", page.Files)
+	}
+	page, _ = s.ListFiles(FileQuery{Search: "debian"})
+	if len(page.Files) != 1 || page.Files[0].Hash != "h1" {
+		t.Errorf("Expected the search to find h1, got %+v", page.Files)
+	}
+}
+
+func TestFileAccess(t *testing.T) {
+	s := NewMemoryStorage()
+	s.AddFile(&models.File{Hash: "h1", Name: "report.pdf", Category: "document", OwnerID: "o1", Visibility: "private", Groups: []string{"team"}})
+	s.AddFile(&models.File{Hash: "h2", Name: "public.pdf", Category: "document", Tags: []string{"pdf"}})
+	s.UpdateFileLabels("h1", "document", []string{"pdf", "secret"})
+
+	// Private and unlisted files are not listed nor counted
+	page, _ := s.ListFiles(FileQuery{})
+	if len(page.Files) != 1 || page.Files[0].Hash != "h2" {
+		t.Errorf("Expected only h2 listed, got %+v", page.Files)
+	}
+	if tags := s.ListTags(0); fmt.Sprint(tags) != "[{pdf 1}]" {
+		t.Errorf("Expected the tags of h2 only, got %v", tags)
+	}
+	if categories := s.ListCategories(); len(categories) != 1 || categories[0].FileCount != 1 {
+		t.Errorf("Expected one document counted, got %+v", categories)
+	}
+
+	// A new announce keeps the access of the file
+	s.AddFile(&models.File{Hash: "h1", Name: "report.pdf", OwnerID: "o2", Visibility: "public"})
+	if got, _ := s.GetFile("h1"); got.OwnerID != "o1" || got.Visibility != "private" || len(got.Groups) != 1 {
+		t.Errorf("Expected the access kept, got %q %q %v", got.OwnerID, got.Visibility, got.Groups)
+	}
+
+	if err := s.UpdateFileAccess("h1", "unlisted", nil); err != nil {
+		t.Fatalf("UpdateFileAccess failed: %v", err)
+	}
+	if err := s.UpdateFileAccess("missing", "public", nil); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+	if page, _ := s.ListFiles(FileQuery{}); len(page.Files) != 1 {
+		t.Errorf("Expected unlisted h1 not listed, got %+v", page.Files)
+	}
+
+	if epoch, err := s.RevokeShares("h1"); err != nil || epoch != 1 {
+		t.Errorf("RevokeShares() = %d, %v, want 1", epoch, err)
+	}
+	if got, _ := s.GetFile("h1"); got.ShareEpoch != 1 {
+		t.Errorf("Expected share epoch 1, got %d", got.ShareEpoch)
+	}
+
+	s.SetGroup(&models.Group{Name: "team", Members: []string{"o2"}})
+	s.SetGroup(&models.Group{Name: "admins", Members: []string{"o1"}})
+	if group, ok := s.GetGroup("team"); !ok || len(group.Members) != 1 || group.UpdatedAt.IsZero() {
+		t.Errorf("Expected group team, got %+v", group)
+	}
+	if groups := s.ListGroups(); len(groups) != 2 || groups[0].Name != "admins" {
+		t.Errorf("Expected groups by name, got %+v", groups)
+	}
+	s.DeleteGroup("team")
+	if _, ok := s.GetGroup("team"); ok {
+		t.Error("Expected group team deleted")
 	}
 }
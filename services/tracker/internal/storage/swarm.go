@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/p2p-filesharing/distributed-system/pkg/protocol"
+	"github.com/p2p-filesharing/distributed-system/services/tracker/internal/models"
+)
+
+// TransferReport is what a peer reports of a file it is in the swarm of:
+// its transfer counters since it started the file, and its announce event,
+// one of the protocol.Announce constants or empty for a periodic report
+type TransferReport struct {
+	FileHash string
+	PeerID   string
+	Event    string
+	protocol.Transfer
+}
+
+// paused returns whether the peer stopped downloading after an event, and
+// false for a report that does not change it
+func (r TransferReport) paused() (paused, changed bool) {
+	switch r.Event {
+	case protocol.AnnouncePaused:
+		return true, true
+	case protocol.AnnounceStarted, protocol.AnnounceCompleted:
+		return false, true
+	}
+	return false, false
+}
+
+// transferIncrease returns how much a counter went up since the last report:
+// all of it when it went down, the peer having restarted
+func transferIncrease(last, now int64, restarted bool) int64 {
+	if restarted || now < last {
+		return now
+	}
+	return now - last
+}
+
+// RecordTransfer stores the counters of a peer of the swarm of a file and
+// adds their increase to the totals of the file, counting a completed
+// download once per peer, even if it left the swarm and joined it again. It
+// returns the increase, which is zero for a peer not in the swarm.
+func (s *MemoryStorage) RecordTransfer(r TransferReport) (uploaded, downloaded int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.filePeers[r.FileHash] {
+		fp := &s.filePeers[r.FileHash][i]
+		if fp.PeerID != r.PeerID {
+			continue
+		}
+		restarted := r.Event == protocol.AnnounceStarted
+		uploaded = transferIncrease(fp.Uploaded, r.Uploaded, restarted)
+		downloaded = transferIncrease(fp.Downloaded, r.Downloaded, restarted)
+		completed := r.Event == protocol.AnnounceCompleted && !s.completed[r.FileHash][r.PeerID]
+
+		fp.Transfer = r.Transfer
+		if paused, changed := r.paused(); changed {
+			fp.Paused = paused
+		}
+		if completed {
+			if s.completed[r.FileHash] == nil {
+				s.completed[r.FileHash] = make(map[string]bool)
+			}
+			s.completed[r.FileHash][r.PeerID] = true
+			fp.Completed = true
+		}
+
+		stats := s.swarms[r.FileHash]
+		if stats == nil {
+			stats = &models.SwarmStats{Hash: r.FileHash}
+			s.swarms[r.FileHash] = stats
+		}
+		stats.Uploaded += uploaded
+		stats.Downloaded += downloaded
+		if completed {
+			stats.Completed++
+		}
+		return uploaded, downloaded, nil
+	}
+	return 0, 0, nil
+}
+
+// GetSwarmStats returns the swarm statistics of files, by hash, including
+// files without a swarm
+func (s *MemoryStorage) GetSwarmStats(hashes []string) map[string]*models.SwarmStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]*models.SwarmStats, len(hashes))
+	for _, hash := range hashes {
+		stats := &models.SwarmStats{Hash: hash}
+		if totals, ok := s.swarms[hash]; ok {
+			*stats = *totals
+		}
+		for _, fp := range s.filePeers[hash] {
+			if peer, ok := s.peers[fp.PeerID]; !ok || !peer.IsOnline {
+				continue
+			}
+			switch {
+			case fp.IsSeeder:
+				stats.Seeders++
+			case fp.Paused:
+				stats.Leechers++
+			default:
+				stats.Leechers++
+				stats.Downloaders++
+			}
+		}
+		result[hash] = stats
+	}
+	return result
+}
+
+// === SQL storages ===
+
+// recordTransfer is RecordTransfer of the SQL storages
+func recordTransfer(db *sql.DB, r TransferReport) (uploaded, downloaded int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var last protocol.Transfer
+	err = tx.QueryRow(`SELECT COALESCE(uploaded, 0), COALESCE(downloaded, 0)
+		FROM file_peers WHERE file_hash = $1 AND peer_id = $2 FOR UPDATE`,
+		r.FileHash, r.PeerID).Scan(&last.Uploaded, &last.Downloaded)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	restarted := r.Event == protocol.AnnounceStarted
+	uploaded = transferIncrease(last.Uploaded, r.Uploaded, restarted)
+	downloaded = transferIncrease(last.Downloaded, r.Downloaded, restarted)
+	completed := 0
+	if r.Event == protocol.AnnounceCompleted {
+		// Completions outlive the file_peers row, which a stopped event deletes
+		res, err := tx.Exec(`INSERT INTO swarm_completions (file_hash, peer_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, r.FileHash, r.PeerID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			completed = 1
+		}
+	}
+	var paused sql.NullBool
+	paused.Bool, paused.Valid = r.paused()
+
+	if _, err := tx.Exec(`UPDATE file_peers SET uploaded = $3, downloaded = $4, left_bytes = $5,
+		paused = COALESCE($6, paused), completed = COALESCE(completed, FALSE) OR $7
+		WHERE file_hash = $1 AND peer_id = $2`,
+		r.FileHash, r.PeerID, r.Uploaded, r.Downloaded, r.Left, paused, completed == 1); err != nil {
+		return 0, 0, err
+	}
+	if uploaded != 0 || downloaded != 0 || completed != 0 {
+		if _, err := tx.Exec(`INSERT INTO swarm_stats (file_hash, completed, uploaded, downloaded) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (file_hash) DO UPDATE SET
+				completed = swarm_stats.completed + EXCLUDED.completed,
+				uploaded = swarm_stats.uploaded + EXCLUDED.uploaded,
+				downloaded = swarm_stats.downloaded + EXCLUDED.downloaded`,
+			r.FileHash, completed, uploaded, downloaded); err != nil {
+			return 0, 0, err
+		}
+	}
+	return uploaded, downloaded, tx.Commit()
+}
+
+// getSwarmStats is GetSwarmStats of the SQL storages
+func getSwarmStats(db *sql.DB, hashes []string) map[string]*models.SwarmStats {
+	result := make(map[string]*models.SwarmStats, len(hashes))
+	for _, hash := range hashes {
+		result[hash] = &models.SwarmStats{Hash: hash}
+	}
+	hashesJSON, err := json.Marshal(hashes)
+	if err != nil || len(hashes) == 0 {
+		return result
+	}
+
+	rows, err := db.Query(`SELECT h.hash,
+			COUNT(fp.peer_id) FILTER (WHERE fp.is_seeder),
+			COUNT(fp.peer_id) FILTER (WHERE NOT fp.is_seeder),
+			COUNT(fp.peer_id) FILTER (WHERE NOT fp.is_seeder AND NOT COALESCE(fp.paused, FALSE)),
+			COALESCE(MAX(s.completed), 0), COALESCE(MAX(s.uploaded), 0), COALESCE(MAX(s.downloaded), 0)
+		FROM jsonb_array_elements_text($1::jsonb) AS h(hash)
+		LEFT JOIN swarm_stats s ON s.file_hash = h.hash
+		LEFT JOIN (file_peers fp JOIN peers p ON p.id = fp.peer_id AND p.is_online) ON fp.file_hash = h.hash
+		GROUP BY h.hash`, string(hashesJSON))
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stats models.SwarmStats
+		if err := rows.Scan(&stats.Hash, &stats.Seeders, &stats.Leechers, &stats.Downloaders,
+			&stats.Completed, &stats.Uploaded, &stats.Downloaded); err != nil {
+			continue
+		}
+		result[stats.Hash] = &stats
+	}
+	return result
+}